@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/tls"
+	"log/slog"
+
+	"github.com/nexus-lb/nexus/config"
+	"github.com/nexus-lb/nexus/internal/logdedup"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newACMEManager builds the certificate manager that obtains and renews
+// certificates for the configured hosts, caching them on disk
+func newACMEManager(ac *config.ACMEConfig) *autocert.Manager {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(ac.Hosts...),
+		Cache:      autocert.DirCache(ac.CacheDir),
+		Email:      ac.Email,
+	}
+	if ac.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: ac.DirectoryURL}
+	}
+	return m
+}
+
+// acmeTLSConfig serves the manager's certificates and answers TLS-ALPN-01
+// challenges. A name without a certificate fails its handshakes, which are
+// logged, while every other name keeps being served.
+func acmeTLSConfig(m *autocert.Manager, d *logdedup.Deduper) *tls.Config {
+	logger := slog.Default().With("component", "acme")
+	return &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, err := m.GetCertificate(hello)
+			if err != nil {
+				d.Log(logger, slog.LevelWarn, "acme:"+hello.ServerName, "no certificate, refusing TLS handshake",
+					"host", hello.ServerName, "error", err)
+			}
+			return cert, err
+		},
+		NextProtos: []string{acme.ALPNProto},
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/nexus-lb/nexus/config"
+	"github.com/nexus-lb/nexus/pkg/nexus"
+)
+
+// checkConfig reports, for -check, the problems of a loaded configuration
+// that only show once the parts it configures are built, and routing rules
+// that can never match, to stderr, or that it is fine to stdout. It returns
+// the exit status.
+func checkConfig(cfg *config.Config, stdout, stderr io.Writer) int {
+	problems := nexus.Check(*cfg)
+	for _, p := range problems {
+		fmt.Fprintln(stderr, p)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	fmt.Fprintln(stdout, "configuration OK")
+	return 0
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nexus-lb/nexus/config"
+)
+
+func TestCheckShadowedRules(t *testing.T) {
+	const (
+		webhooks = `{"name": "webhooks", "path_prefix": "/webhooks", "pool": "webhooks"}`
+		github   = `{"name": "github", "methods": ["POST"], "path_prefix": "/webhooks/github", "pool": "api"}`
+		mobile   = `{"name": "mobile", "headers": [{"name": "X-Client", "value": "mobile"}], "pool": "api"}`
+	)
+	tests := []struct {
+		name       string
+		rules      []string
+		wantStatus int
+		wantOut    string
+		wantErr    string
+	}{
+		{
+			name:       "shadowed by a broader rule",
+			rules:      []string{webhooks, github, mobile},
+			wantStatus: 1,
+			wantErr:    `routing: rule "github" is unreachable: rule "webhooks" before it matches every request it would` + "\n",
+		},
+		{
+			name:       "narrower rule first",
+			rules:      []string{github, webhooks, mobile},
+			wantStatus: 0,
+			wantOut:    "configuration OK\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			body := `{"listen": ":0",
+				"backends": [{"url": "http://127.0.0.1:9001", "pool": "webhooks"}, {"url": "http://127.0.0.1:9002", "pool": "api"}],
+				"routing": {"rules": [` + strings.Join(tt.rules, ", ") + `]}}`
+			if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := config.Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var stdout, stderr strings.Builder
+			status := checkConfig(cfg, &stdout, &stderr)
+			if status != tt.wantStatus || stdout.String() != tt.wantOut || stderr.String() != tt.wantErr {
+				t.Errorf("checkConfig = %d, stdout %q, stderr %q; want %d, %q, %q",
+					status, stdout.String(), stderr.String(), tt.wantStatus, tt.wantOut, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/nexus-lb/nexus/config"
+	"github.com/nexus-lb/nexus/internal/http3"
+)
+
+// newHTTP3Server creates the QUIC listener serving h over HTTP/3 with the
+// certificates of the TLS listener
+func newHTTP3Server(sc config.ServerConfig, h3 *config.HTTP3Config, h http.Handler, tc *tls.Config) *http3.Server {
+	return &http3.Server{
+		Addr:              h3.Listen,
+		Handler:           h,
+		TLSConfig:         tc,
+		ReadHeaderTimeout: sc.ReadHeaderTimeout.Std(),
+		ReadTimeout:       sc.ReadTimeout.Std(),
+		WriteTimeout:      sc.WriteTimeout.Std(),
+		IdleTimeout:       sc.IdleTimeout.Std(),
+		MaxHeaderBytes:    sc.MaxHeaderBytes,
+	}
+}
+
+// advertiseHTTP3 adds to every response of next an Alt-Svc header offering
+// HTTP/3 on the port of the QUIC listener
+func advertiseHTTP3(next http.Handler, h3 *config.HTTP3Config) http.Handler {
+	_, port, _ := net.SplitHostPort(h3.Listen)
+	altSvc := fmt.Sprintf(`h3=":%s"; ma=%d`, port, int(h3.AltSvcMaxAge.Std().Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Alt-Svc", altSvc)
+		next.ServeHTTP(w, r)
+	})
+}
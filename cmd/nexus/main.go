@@ -2,149 +2,365 @@ package main
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/nexus-lb/nexus/internal/backend"
-	"github.com/nexus-lb/nexus/internal/health"
-	"github.com/nexus-lb/nexus/internal/pool"
+	"github.com/nexus-lb/nexus/config"
+	"github.com/nexus-lb/nexus/internal/handoff"
+	"github.com/nexus-lb/nexus/internal/http3"
+	"github.com/nexus-lb/nexus/internal/logdedup"
+	"github.com/nexus-lb/nexus/internal/proxyproto"
+	"github.com/nexus-lb/nexus/pkg/nexus"
+	"github.com/nexus-lb/nexus/pkg/nexus/lifecycle"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
-const (
-	proxyPort       = ":8000"
-	healthInterval  = 10 * time.Second
-	healthTimeout   = 2 * time.Second
-	shutdownTimeout = 30 * time.Second
-	maxRetries      = 3
-)
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
 
-var (
-	backendURLs = []string{
-		"http://localhost:8081",
-		"http://localhost:8082",
-		"http://localhost:8083",
-	}
-)
+// hookTimeout bounds each lifecycle hook
+const hookTimeout = 10 * time.Second
+
+// stopUpgrade is the reason to shut down once the listeners are handed over
+const stopUpgrade = "upgrade"
 
 func main() {
-	// Create the server pool
-	serverPool := &pool.ServerPool{}
+	configPath := flag.String("config", "", "path to a JSON configuration file (built-in defaults if empty)")
+	check := flag.Bool("check", false, "check the configuration, report any problems and exit")
+	flag.Parse()
+	startedAt := time.Now()
 
-	// Add backends to the pool
-	for _, urlStr := range backendURLs {
-		backend, err := backend.NewBackend(urlStr)
+	// Load configuration
+	cfg := config.Default()
+	if *configPath != "" {
+		loaded, err := config.Load(*configPath)
 		if err != nil {
-			log.Fatalf("Failed to create backend for %s: %v", urlStr, err)
+			fatal("failed to load configuration", "path", *configPath, "error", err)
 		}
-		serverPool.AddBackend(backend)
-		log.Printf("Added backend: %s", urlStr)
+		cfg = loaded
+	}
+	if *check {
+		os.Exit(checkConfig(cfg, os.Stdout, os.Stderr))
 	}
 
-	// Log startup information
-	log.Printf("Nexus load balancer starting on port %s", proxyPort)
-	log.Printf("Load balancing across %d backends", serverPool.GetPoolSize())
+	// Every component logs through the default logger unless given its own
+	slog.SetDefault(newLogger(cfg.Log))
+	if *configPath != "" {
+		slog.Info("loaded configuration", "path", *configPath)
+	}
+
+	// Take over the listening sockets of the process this one replaces, if
+	// it was started by an upgrade
+	sockets, err := handoff.Inherit()
+	if err != nil {
+		fatal("failed to take over listening sockets", "error", err)
+	}
 
-	// Create and start health checker
-	healthChecker := health.NewHealthChecker(serverPool, healthInterval, healthTimeout)
-	healthChecker.Start()
+	// Hooks run at each stage of the process, backends going up and down
+	// included
+	life := lifecycle.New(hookTimeout, slog.Default().With("component", "lifecycle"))
+
+	// The load balancer is the handler of every listener below
+	lb, err := nexus.New(*cfg,
+		nexus.WithVersion(version),
+		nexus.WithStartTime(startedAt),
+		nexus.WithLifecycle(life))
+	if err != nil {
+		fatal("failed to set up the load balancer", "error", err)
+	}
+	slog.Info("nexus load balancer starting", "listen", cfg.Listen, "backends", lb.Status().Total, "version", version, "pid", os.Getpid())
 
 	// Create HTTP server with load balancing handler
-	server := &http.Server{
-		Addr: proxyPort,
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			startTime := time.Now()
-
-			// Try up to maxRetries times to find a working backend
-			attempts := 0
-
-			for attempts < maxRetries {
-				attempts++
-
-				// Get the next available peer
-				peer := serverPool.GetNextPeer()
-				if peer == nil {
-					if attempts < maxRetries {
-						time.Sleep(10 * time.Millisecond) // Brief pause before retry
-						continue
-					}
-					log.Printf("[%s] %s %s -> NO BACKEND AVAILABLE (503)",
-						startTime.Format("2006-01-02 15:04:05"),
-						r.Method,
-						r.URL.Path)
-					http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
-					return
-				}
-
-				// Check if backend is alive before proxying
-				if !peer.IsAlive() {
-					log.Printf("[%s] %s %s -> %s is marked DOWN, trying next (attempt %d)",
-						startTime.Format("2006-01-02 15:04:05"),
-						r.Method,
-						r.URL.Path,
-						peer.URL.String(),
-						attempts)
-					continue
-				}
-
-				// Log the request with backend information
-				log.Printf("[%s] %s %s -> %s (attempt %d)",
-					startTime.Format("2006-01-02 15:04:05"),
-					r.Method,
-					r.URL.Path,
-					peer.URL.String(),
-					attempts)
-
-				// Add custom headers
-				w.Header().Set("X-Forwarded-By", "Nexus")
-				w.Header().Set("X-Backend-Server", peer.URL.String())
-
-				// Forward the request to the selected backend
-				// The custom transport will mark backend as DOWN if it fails
-				peer.ReverseProxy.ServeHTTP(w, r)
-				return
+	// h2c lets clients speak HTTP/2 over the plain listener, either with
+	// prior knowledge or via an HTTP/1.1 Upgrade, while HTTP/1.x is untouched
+	var plain http.Handler = lb
+	var tlsConfig *tls.Config
+	if a := cfg.TLS.ACME; a != nil {
+		// HTTP-01 challenges are answered here, never proxied to a backend
+		manager := newACMEManager(a)
+		tlsConfig = acmeTLSConfig(manager, logdedup.New(cfg.Log.DedupWindow.Std()))
+		plain = manager.HTTPHandler(plain)
+		slog.Info("obtaining certificates through ACME", "hosts", a.Hosts, "cache_dir", a.CacheDir)
+	}
+	if cfg.H2C {
+		plain = h2c.NewHandler(plain, &http2.Server{})
+	}
+	server := newServer(cfg.Server, cfg.Listen, plain, lb)
+	if sc := cfg.Server; sc.ProxyProtocol || sc.RequireProxyProtocol {
+		slog.Info("reading PROXY protocol headers", "required", sc.RequireProxyProtocol)
+	}
+
+	// The TLS listener negotiates HTTP/2 through ALPN unless it is disabled
+	var tlsServer *http.Server
+	if t := cfg.TLS; t.Listen != "" {
+		if tlsConfig == nil {
+			cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+			if err != nil {
+				fatal("failed to load TLS certificate", "error", err)
+			}
+			tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		}
+		if err := applyTLSPolicy(tlsConfig, t); err != nil {
+			fatal("invalid TLS policy", "error", err)
+		}
+		tlsServer = newServer(cfg.Server, t.Listen, lb, lb)
+		tlsServer.TLSConfig = tlsConfig
+		tlsServer.Protocols = new(http.Protocols)
+		tlsServer.Protocols.SetHTTP1(true)
+		tlsServer.Protocols.SetHTTP2(t.HTTP2)
+		slog.Info("serving TLS", "listen", t.Listen, "http2", t.HTTP2, "min_version", t.MinVersion, "client_auth", t.ClientAuth)
+	}
+
+	// HTTP/3 runs the same handlers over QUIC, and the TLS listener tells
+	// its clients about it
+	var h3Server *http3.Server
+	if h3 := cfg.TLS.HTTP3; tlsServer != nil && h3 != nil {
+		h3Server = newHTTP3Server(cfg.Server, h3, lb, tlsConfig)
+		tlsServer.Handler = advertiseHTTP3(tlsServer.Handler, h3)
+		slog.Info("serving HTTP/3", "listen", h3.Listen, "alt_svc_max_age", h3.AltSvcMaxAge.Std())
+	}
+
+	// Start the admin API, the startup gate and the TCP and UDP listeners
+	// on the inherited sockets
+	if err := lb.Start(sockets); err != nil {
+		fatal("failed to start", "error", err)
+	}
+	if errs := lb.AdminErr(); errs != nil {
+		go func() {
+			fatal("admin server failed", "error", <-errs)
+		}()
+	}
+
+	// Setup graceful shutdown; a second signal gives up on draining
+	drain := drainLog{lb: lb}
+	life.HandleSignals(func(os.Signal) {
+		drain.phase("received second shutdown signal, exiting immediately")
+		os.Exit(1)
+	}, os.Interrupt, syscall.SIGTERM)
+
+	// Every listener is open before Nexus reports ready, so that an upgrade
+	// completes only once the new process holds them all
+	// The listeners are closed on their own after an upgrade, which ends
+	// Serve with net.ErrClosed
+	ln, err := listen(sockets, cfg.Server, server.Addr)
+	if err != nil {
+		fatal("server failed to start", "error", err)
+	}
+	httpListeners := []net.Listener{ln}
+	go func() {
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+			fatal("server failed", "error", err)
+		}
+	}()
+	if tlsServer != nil {
+		ln, err := listen(sockets, cfg.Server, tlsServer.Addr)
+		if err != nil {
+			fatal("TLS server failed to start", "error", err)
+		}
+		httpListeners = append(httpListeners, ln)
+		go func() {
+			if err := tlsServer.ServeTLS(ln, "", ""); err != nil && err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+				fatal("TLS server failed", "error", err)
 			}
+		}()
+	}
+	if h3Server != nil {
+		conn, err := sockets.ListenUDP(h3Server.Addr)
+		if err != nil {
+			fatal("HTTP/3 server failed to start", "error", err)
+		}
+		go func() {
+			if err := h3Server.Serve(conn); err != nil && err != http.ErrServerClosed {
+				fatal("HTTP/3 server failed", "error", err)
+			}
+		}()
+	}
 
-			// If we get here, all retries failed
-			log.Printf("[%s] %s %s -> ALL RETRIES FAILED (503)",
-				startTime.Format("2006-01-02 15:04:05"),
-				r.Method,
-				r.URL.Path)
-			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
-		}),
+	slog.Info("nexus is ready to accept connections")
+	if parent, ok := sockets.Upgraded(); ok {
+		slog.Info("took over from the previous process", "pid", parent, "sockets", sockets.Adopted())
 	}
+	if err := sockets.Ready(); err != nil {
+		slog.Warn("failed to tell the previous process to exit", "error", err)
+	}
+	life.Run(context.Background(), lifecycle.Start)
 
-	log.Printf("Nexus is ready to accept connections")
+	// Upgrade on SIGUSR2: the executable is started again with the
+	// listening sockets, and once it is ready this process drains and exits
+	upgradeChan := make(chan os.Signal, 1)
+	signal.Notify(upgradeChan, syscall.SIGUSR2)
+	var handedTo atomic.Int64
+	go func() {
+		for range upgradeChan {
+			slog.Info("upgrading, starting the new process")
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.UpgradeTimeout.Std())
+			pid, err := sockets.Upgrade(ctx)
+			cancel()
+			if err != nil {
+				slog.Error("upgrade failed, still serving", "error", err)
+				continue
+			}
+			handedTo.Store(int64(pid))
+			life.Stop(stopUpgrade)
+			return
+		}
+	}()
 
-	// Setup graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	// Reopen the access log on SIGUSR1, for logrotate
+	reopenChan := make(chan os.Signal, 1)
+	signal.Notify(reopenChan, syscall.SIGUSR1)
+	go func() {
+		for range reopenChan {
+			if err := lb.ReopenAccessLog(); err != nil {
+				slog.Error("failed to reopen access log", "component", "accesslog", "error", err)
+			} else if cfg.AccessLog != nil {
+				slog.Info("access log reopened", "component", "accesslog")
+			}
+		}
+	}()
 
-	// Start server in a goroutine
+	// Reload configuration on SIGHUP
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed to start: %v", err)
+		for range reloadChan {
+			if lb.Reload(*configPath, "SIGHUP") {
+				life.Run(context.Background(), lifecycle.ConfigReload)
+			}
 		}
 	}()
 
-	// Wait for interrupt signal
-	<-sigChan
-	log.Println("\nReceived shutdown signal, gracefully shutting down...")
+	// Wait for a shutdown signal or the end of an upgrade
+	upgraded := life.Reason() == stopUpgrade
+	if upgraded {
+		drain.phase("handed the listeners over to the new process, draining", "pid", handedTo.Load())
+		stopAccepting(httpListeners, []*http.Server{server, tlsServer}, lb)
+	} else {
+		drain.phase("received shutdown signal, gracefully shutting down", "reason", life.Reason())
+	}
+	life.Run(context.Background(), lifecycle.ShutdownBegin)
 
-	// Stop health checker
-	healthChecker.Stop()
+	// Fail readiness first so upstream load balancers stop sending traffic
+	// before connections are drained. After an upgrade the new process
+	// answers on the same sockets, so there is nothing to wait for.
+	if cfg.Probes != nil && !upgraded {
+		lb.StartDraining()
+		if d := cfg.Probes.ShutdownDelay.Std(); d > 0 {
+			slog.Info("reporting not ready before draining", "delay", d)
+			time.Sleep(d)
+		}
+	}
 
 	// Create shutdown context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Std())
 	defer cancel()
 
+	// Stop health checks, discovery and the TCP and UDP listeners, and have
+	// streams cut off, for the HTTP listeners to drain
+	lb.BeginShutdown(ctx)
+
 	// Shutdown HTTP server
+	drain.phase("closing listeners, waiting for requests in flight")
 	if err := server.Shutdown(ctx); err != nil {
-		log.Printf("Server shutdown error: %v", err)
+		slog.Error("server shutdown error", "error", err)
+	}
+	if tlsServer != nil {
+		if err := tlsServer.Shutdown(ctx); err != nil {
+			slog.Error("TLS server shutdown error", "error", err)
+		}
+	}
+	if h3Server != nil {
+		if err := h3Server.Shutdown(ctx); err != nil {
+			slog.Error("HTTP/3 server shutdown error", "error", err)
+		}
+	}
+
+	drain.phase("listeners closed")
+
+	// WebSocket tunnels and mirrored requests get what is left of the
+	// shutdown timeout
+	if err := lb.Shutdown(ctx); err != nil {
+		slog.Error("load balancer shutdown error", "error", err)
 	}
 
-	log.Println("Nexus shut down successfully")
+	life.Run(context.Background(), lifecycle.ShutdownComplete)
+	slog.Info("nexus shut down successfully")
+}
+
+// newServer creates a proxy listener with the configured timeouts and
+// limits, counting its connections in lb
+func newServer(sc config.ServerConfig, addr string, h http.Handler, lb *nexus.LoadBalancer) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ConnState:         lb.ConnState,
+		ReadHeaderTimeout: sc.ReadHeaderTimeout.Std(),
+		ReadTimeout:       sc.ReadTimeout.Std(),
+		WriteTimeout:      sc.WriteTimeout.Std(),
+		IdleTimeout:       sc.IdleTimeout.Std(),
+		MaxHeaderBytes:    sc.MaxHeaderBytes,
+	}
+}
+
+// drainLog logs the phases of shutdown with what is left to drain at each
+type drainLog struct {
+	lb    *nexus.LoadBalancer
+	start time.Time
+}
+
+func (d *drainLog) phase(msg string, args ...any) {
+	if d.start.IsZero() {
+		d.start = time.Now()
+	}
+	a := d.lb.Activity()
+	slog.Info(msg, append(args, "in_flight", a.Requests, "streams", a.Streams,
+		"connections", a.Connections, "active_connections", a.ActiveConnections, "elapsed", time.Since(d.start).Round(time.Millisecond))...)
+}
+
+// acceptGrace is how long connections accepted just before an upgrade
+// have to send their first request
+const acceptGrace = time.Second
+
+// stopAccepting leaves new connections to the process an upgrade handed the
+// listeners to and has the open ones close after their current response.
+// net/http drops a request it reads once Shutdown has begun, so the
+// connections accepted just before get up to acceptGrace to send theirs
+// before shutdown proceeds.
+func stopAccepting(lns []net.Listener, servers []*http.Server, lb *nexus.LoadBalancer) {
+	for _, ln := range lns {
+		ln.Close()
+	}
+	for _, srv := range servers {
+		if srv != nil {
+			srv.SetKeepAlivesEnabled(false)
+		}
+	}
+	deadline := time.Now().Add(acceptGrace)
+	for lb.Activity().NewConnections > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// listen opens a proxy listener, or takes over the inherited one, reading
+// PROXY protocol headers off its connections if configured
+func listen(sockets *handoff.Sockets, sc config.ServerConfig, addr string) (net.Listener, error) {
+	ln, err := sockets.Listen(addr)
+	if err != nil || !sc.ProxyProtocol && !sc.RequireProxyProtocol {
+		return ln, err
+	}
+	timeout := sc.ReadHeaderTimeout.Std()
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &proxyproto.Listener{Listener: ln, Required: sc.RequireProxyProtocol, Timeout: timeout}, nil
 }
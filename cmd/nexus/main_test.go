@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nexus-lb/nexus/config"
+	"github.com/nexus-lb/nexus/pkg/nexus"
+)
+
+// serveTestLB loads a configuration proxying to upstream with the given
+// server settings and serves it as the plain listener does
+func serveTestLB(t *testing.T, upstream, server string) net.Addr {
+	t.Helper()
+	body := fmt.Sprintf(`{"listen": ":0", "backends": [{"url": %q}], "server": %s}`, upstream, server)
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb, err := nexus.New(*cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer(cfg.Server, ln.Addr().String(), lb, lb)
+	go srv.Serve(ln)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+		lb.Shutdown(ctx)
+	})
+	return ln.Addr()
+}
+
+// dribble writes s to conn a byte at a time, every interval, until it is
+// done or the connection fails, and returns how long that took
+func dribble(conn net.Conn, s string, interval time.Duration) (time.Duration, error) {
+	start := time.Now()
+	for i := range len(s) {
+		if _, err := conn.Write([]byte{s[i]}); err != nil {
+			return time.Since(start), err
+		}
+		time.Sleep(interval)
+	}
+	return time.Since(start), nil
+}
+
+// waitClosed reads from conn until the server closes it, failing the test
+// if that takes longer than limit
+func waitClosed(t *testing.T, conn net.Conn, limit time.Duration) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(limit))
+	_, err := io.Copy(io.Discard, conn)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatalf("connection still open after %s", limit)
+	}
+}
+
+func TestSlowHeadersCut(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+	addr := serveTestLB(t, up.URL, `{"read_header_timeout": "200ms"}`)
+
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// A slowloris client never finishes its headers
+	headers := "GET / HTTP/1.1\r\nHost: nexus\r\n" + strings.Repeat("X-Slow: loris\r\n", 20)
+	go dribble(conn, headers, 20*time.Millisecond)
+	waitClosed(t, conn, 3*time.Second)
+}
+
+func TestSlowBodyCut(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer up.Close()
+	addr := serveTestLB(t, up.URL, `{"read_timeout": "300ms"}`)
+
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	io.WriteString(conn, "POST / HTTP/1.1\r\nHost: nexus\r\nContent-Length: 100\r\n\r\n")
+	go dribble(conn, strings.Repeat("x", 100), 20*time.Millisecond)
+	waitClosed(t, conn, 3*time.Second)
+}
+
+func TestIdleConnectionCut(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+	addr := serveTestLB(t, up.URL, `{"idle_timeout": "200ms"}`)
+
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: nexus\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// The keep-alive connection is closed once it has idled too long
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := br.ReadByte(); err == nil {
+		t.Fatal("unexpected data on an idle connection")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("idle connection still open")
+	}
+}
+
+func TestStreamOutlastsWriteTimeout(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := range 6 {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer up.Close()
+	addr := serveTestLB(t, up.URL, `{"write_timeout": "250ms"}`)
+
+	// The stream as a whole runs past the write timeout, each write well
+	// within it
+	resp, err := http.Get("http://" + addr.String() + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("stream cut off: %v after %q", err, body)
+	}
+	if got := strings.Count(string(body), "data: "); got != 6 {
+		t.Errorf("received %d events, want 6", got)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/nexus-lb/nexus/config"
+)
+
+// applyTLSPolicy sets the versions, cipher suites and client certificate
+// checks of tc from the tls block
+func applyTLSPolicy(tc *tls.Config, t config.TLSConfig) error {
+	tc.MinVersion, tc.MaxVersion = t.Versions()
+	tc.CipherSuites = t.CipherSuiteIDs()
+	if t.ClientCAFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(t.ClientCAFile)
+	if err != nil {
+		return err
+	}
+	cas := x509.NewCertPool()
+	if !cas.AppendCertsFromPEM(data) {
+		return fmt.Errorf("%s: no PEM certificates found", t.ClientCAFile)
+	}
+	tc.ClientCAs = cas
+	tc.ClientAuth = tls.RequireAndVerifyClientCert
+	if t.ClientAuth == "verify" {
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return nil
+}
@@ -1,3 +1,2482 @@
 package config
 
-// Placeholder for configuration implementation (Phase 5)
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Config holds the complete Nexus configuration
+type Config struct {
+	Listen          string            `json:"listen"`
+	H2C             bool              `json:"h2c"`
+	TLS             TLSConfig         `json:"tls"`
+	Backends        []BackendConfig   `json:"backends"`
+	HealthCheck     HealthCheckConfig `json:"health_check"`
+	ShutdownTimeout Duration          `json:"shutdown_timeout"`
+	MaxRetries      int               `json:"max_retries"`
+	Retry           RetryConfig       `json:"retry"`
+	Transport       TransportConfig   `json:"transport"`
+	Admin           AdminConfig       `json:"admin"`
+	Metrics         MetricsConfig     `json:"metrics"`
+	StatsD          *StatsDConfig     `json:"statsd,omitempty"`
+	Tracing         *TracingConfig    `json:"tracing,omitempty"`
+	AccessLog       *AccessLogConfig  `json:"access_log,omitempty"`
+	Log             LogConfig         `json:"log"`
+	Probes          *ProbesConfig     `json:"probes,omitempty"`
+
+	// StreamShutdownTimeout is how long WebSockets and event streams are
+	// waited for on shutdown before they are cut off, within the shutdown
+	// timeout (default 0, the whole shutdown timeout)
+	StreamShutdownTimeout Duration `json:"stream_shutdown_timeout,omitzero"`
+	// UpgradeTimeout is how long the process started on SIGUSR2 has to
+	// report ready before the upgrade is abandoned (default 30s)
+	UpgradeTimeout Duration `json:"upgrade_timeout"`
+	// Startup holds the proxy listener back until enough backends are
+	// healthy
+	Startup StartupConfig `json:"startup,omitzero"`
+
+	// TrustedProxies lists the CIDRs whose X-Forwarded-* headers are kept.
+	// Unset trusts loopback peers only; an empty list trusts none.
+	TrustedProxies []string `json:"trusted_proxies"`
+	// SetRealIP sets X-Real-IP to the client IP resolved through them
+	SetRealIP bool `json:"set_x_real_ip,omitempty"`
+
+	// HideIdentityHeaders leaves X-Forwarded-By and X-Backend-Server out of
+	// responses, so clients cannot learn the backend topology
+	HideIdentityHeaders bool `json:"hide_identity_headers,omitempty"`
+
+	// ErrorPages, if set, replaces the bodies of the 400, 413, 500, 502, 503
+	// and 504 responses Nexus writes itself
+	ErrorPages *ErrorPagesConfig `json:"error_pages,omitempty"`
+
+	// Headers are rules adding, setting and removing request and response
+	// headers, applied in order
+	Headers []HeaderRuleConfig `json:"headers,omitempty"`
+
+	// SecurityHeaders, if set, gives every response a baseline of security
+	// headers, those Nexus answers itself included
+	SecurityHeaders *SecurityHeadersConfig `json:"security_headers,omitempty"`
+
+	// Compression, if set, gzips responses for clients that accept it
+	Compression *CompressionConfig `json:"compression,omitempty"`
+
+	// RequestBody caps the size of request bodies
+	RequestBody RequestBodyConfig `json:"request_body"`
+
+	// ConcurrencyLimit caps the requests in flight through the proxy
+	ConcurrencyLimit ConcurrencyLimitConfig `json:"concurrency_limit"`
+
+	// RateLimit, if set, limits the request rate of each client IP
+	RateLimit *RateLimitConfig `json:"rate_limit,omitempty"`
+
+	// IPFilter, if set, admits or refuses every client by IP, ahead of the
+	// rate limit
+	IPFilter *IPFilterConfig `json:"ip_filter,omitempty"`
+
+	// Queue holds requests that find no backend available for a while
+	Queue QueueConfig `json:"queue"`
+
+	// Server bounds how long clients may take on the proxy listener
+	Server ServerConfig `json:"server"`
+
+	// WebSocket controls upgraded connections, WebSocket or otherwise
+	WebSocket WebSocketConfig `json:"websocket"`
+
+	// Mirror, if set, copies a sample of requests to shadow backends
+	Mirror *MirrorConfig `json:"mirror,omitempty"`
+
+	// Split, if set, divides traffic between the backend pools by percentage
+	Split *SplitConfig `json:"split,omitempty"`
+
+	// Maintenance controls the page of pools put in maintenance through the
+	// admin API
+	Maintenance MaintenanceConfig `json:"maintenance,omitzero"`
+
+	// Rewrites are redirect and rewrite rules applied, in order, before
+	// routing
+	Rewrites []RewriteRuleConfig `json:"rewrites,omitempty"`
+
+	// Routing, if set, sends requests to the backend pools by host, path,
+	// method and headers
+	Routing *RoutingConfig `json:"routing,omitempty"`
+
+	// Hedging, if set, sends slow GET and HEAD requests to a second backend
+	Hedging *HedgingConfig `json:"hedging,omitempty"`
+
+	// CircuitBreaker, if set, gives every backend a circuit breaker
+	CircuitBreaker *CircuitBreakerConfig `json:"circuit_breaker,omitempty"`
+
+	// Kubernetes connects to the API server backends given as k8s:// are
+	// discovered through
+	Kubernetes KubernetesConfig `json:"kubernetes,omitzero"`
+
+	// Consul connects to the Consul agent backends given as consul:// are
+	// discovered through
+	Consul ConsulConfig `json:"consul,omitzero"`
+
+	// Etcd connects to the etcd cluster backends given as etcd:// register
+	// in
+	Etcd EtcdConfig `json:"etcd,omitzero"`
+
+	// TCP are listeners proxying raw TCP connections, rather than HTTP
+	// requests, to backends of their own
+	TCP []TCPProxyConfig `json:"tcp,omitempty"`
+
+	// UDP are listeners spreading datagrams, such as syslog or StatsD, over
+	// backends of their own
+	UDP []UDPProxyConfig `json:"udp,omitempty"`
+}
+
+// BackendConfig describes a single backend server
+type BackendConfig struct {
+	URL       string          `json:"url"`
+	Weight    int             `json:"weight,omitempty"`
+	Warmup    *WarmupConfig   `json:"warmup,omitempty"`
+	Transport TransportConfig `json:"transport,omitzero"`
+	// HealthCheckInterval pins this backend's active health check interval,
+	// overriding the adaptive schedule
+	HealthCheckInterval Duration `json:"health_check_interval,omitzero"`
+	// Protocol is "http1" (default), "h2c" for HTTP/2 without TLS, as gRPC
+	// servers commonly speak, or "h2" for HTTP/2 over TLS
+	Protocol string `json:"protocol,omitempty"`
+	// FlushInterval is how often responses are flushed to the client while
+	// being copied; negative flushes after every write (default 0, only SSE
+	// and responses of unknown length are flushed as they arrive)
+	FlushInterval Duration `json:"flush_interval,omitzero"`
+	// Pool places the backend in a named pool of the traffic split; empty
+	// is the default pool
+	Pool string `json:"pool,omitempty"`
+	// ResolveInterval is how often a backend given as an SRV record, such as
+	// srv://_app._tcp.service.consul, is resolved again (default 30s)
+	ResolveInterval Duration `json:"resolve_interval,omitzero"`
+}
+
+// KubernetesConfig connects to the Kubernetes API server for backends given
+// as a Service, such as k8s://namespace/service:port. It is only used if
+// some backend is.
+type KubernetesConfig struct {
+	// Kubeconfig is a kubeconfig file to connect with; empty uses the
+	// service account of the pod Nexus runs in
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	// Context is the kubeconfig context to use (default its current one)
+	Context string `json:"context,omitempty"`
+	// Resync is how often EndpointSlices are listed again in case a change
+	// was missed (default 5m); changes are watched for in between
+	Resync Duration `json:"resync,omitzero"`
+}
+
+// ConsulConfig connects to the Consul agent for backends given as a Consul
+// service, such as consul://web?tag=v2. It is only used if some backend is.
+type ConsulConfig struct {
+	// Address is the agent's HTTP API (default http://127.0.0.1:8500)
+	Address    string `json:"address,omitempty"`
+	Token      string `json:"token,omitempty"`
+	Datacenter string `json:"datacenter,omitempty"`
+	// Health decides which instances are backends: "both" (default) takes
+	// those passing their Consul health checks and checks them as well,
+	// "consul" relies on Consul's checks alone, and "nexus" takes every
+	// registered instance and leaves their health to Nexus
+	Health string `json:"health,omitempty"`
+}
+
+// EtcdConfig connects to the etcd cluster for backends registered under a
+// key prefix, such as etcd:///services/web/. It is only used if some
+// backend is.
+type EtcdConfig struct {
+	// Endpoints are the members' client URLs, tried in turn (default
+	// http://127.0.0.1:2379)
+	Endpoints []string `json:"endpoints,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	Password  string   `json:"password,omitempty"`
+	// CAFile verifies https endpoints, instead of the system roots;
+	// CertFile and KeyFile authenticate Nexus to them
+	CAFile   string `json:"ca_file,omitempty"`
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+}
+
+// TransportConfig controls the connections Nexus opens to backends. In a
+// backend entry, zero values inherit from the top-level transport block.
+type TransportConfig struct {
+	DialTimeout           Duration `json:"dial_timeout,omitzero"`
+	KeepAlive             Duration `json:"keep_alive,omitzero"`
+	MaxIdleConns          int      `json:"max_idle_conns,omitzero"`
+	MaxIdleConnsPerHost   int      `json:"max_idle_conns_per_host,omitzero"`
+	MaxConnsPerHost       int      `json:"max_conns_per_host,omitzero"`
+	IdleConnTimeout       Duration `json:"idle_conn_timeout,omitzero"`
+	TLSHandshakeTimeout   Duration `json:"tls_handshake_timeout,omitzero"`
+	ResponseHeaderTimeout Duration `json:"response_header_timeout,omitzero"`
+	// RequestTimeout bounds a proxied attempt from start to the end of the
+	// response; a streaming response (SSE or of unknown length) is instead
+	// cut off once it stays silent for StreamIdleTimeout
+	RequestTimeout    Duration `json:"request_timeout,omitzero"`
+	StreamIdleTimeout Duration `json:"stream_idle_timeout,omitzero"`
+	// InsecureSkipVerify disables TLS certificate verification; enabling it
+	// at either level enables it for the backend
+	InsecureSkipVerify bool `json:"tls_insecure_skip_verify,omitzero"`
+}
+
+// Merge returns t with its zero-valued fields taken from fallback
+func (t TransportConfig) Merge(fallback TransportConfig) TransportConfig {
+	if t.DialTimeout == 0 {
+		t.DialTimeout = fallback.DialTimeout
+	}
+	if t.KeepAlive == 0 {
+		t.KeepAlive = fallback.KeepAlive
+	}
+	if t.MaxIdleConns == 0 {
+		t.MaxIdleConns = fallback.MaxIdleConns
+	}
+	if t.MaxIdleConnsPerHost == 0 {
+		t.MaxIdleConnsPerHost = fallback.MaxIdleConnsPerHost
+	}
+	if t.MaxConnsPerHost == 0 {
+		t.MaxConnsPerHost = fallback.MaxConnsPerHost
+	}
+	if t.IdleConnTimeout == 0 {
+		t.IdleConnTimeout = fallback.IdleConnTimeout
+	}
+	if t.TLSHandshakeTimeout == 0 {
+		t.TLSHandshakeTimeout = fallback.TLSHandshakeTimeout
+	}
+	if t.ResponseHeaderTimeout == 0 {
+		t.ResponseHeaderTimeout = fallback.ResponseHeaderTimeout
+	}
+	if t.RequestTimeout == 0 {
+		t.RequestTimeout = fallback.RequestTimeout
+	}
+	if t.StreamIdleTimeout == 0 {
+		t.StreamIdleTimeout = fallback.StreamIdleTimeout
+	}
+	t.InsecureSkipVerify = t.InsecureSkipVerify || fallback.InsecureSkipVerify
+	return t
+}
+
+// Pools returns the named pools backends are placed in, in order of first
+// appearance
+func (c *Config) Pools() []string {
+	var pools []string
+	for _, b := range c.Backends {
+		if b.Pool != "" && !slices.Contains(pools, b.Pool) {
+			pools = append(pools, b.Pool)
+		}
+	}
+	return pools
+}
+
+// TransportFor returns the effective transport configuration of a backend
+func (c *Config) TransportFor(b BackendConfig) TransportConfig {
+	return b.Transport.Merge(c.Transport)
+}
+
+// WarmupConfig controls the synthetic requests sent to a recovered backend
+// before it is admitted back into rotation
+type WarmupConfig struct {
+	Requests int    `json:"requests"`
+	Path     string `json:"path"`
+	// LatencyBudget is the latency the final warm-up request must stay under
+	LatencyBudget Duration `json:"latency_budget"`
+	// Timeout bounds each individual warm-up request (default 10s)
+	Timeout Duration `json:"timeout"`
+}
+
+// HealthCheckConfig controls active health checking
+type HealthCheckConfig struct {
+	Interval Duration `json:"interval"`
+	Timeout  Duration `json:"timeout"`
+	// Adaptive, if set, replaces the fixed interval with a per-backend
+	// schedule; Interval is then where healthy backends start
+	Adaptive *AdaptiveConfig `json:"adaptive,omitempty"`
+	// Passive decides when server errors from proxied requests mark a
+	// backend down; connection errors always do at once
+	Passive PassiveConfig `json:"passive"`
+}
+
+// PassiveConfig sets how many 5xx responses it takes to mark a backend down
+type PassiveConfig struct {
+	// Consecutive5xx marks a backend down after this many server errors in
+	// a row (default 5, 0 to disable)
+	Consecutive5xx int `json:"consecutive_5xx"`
+	// ErrorRate marks a backend down once this fraction of its responses
+	// within Window were server errors, if there were at least MinRequests
+	// (default off; window 30s, min_requests 20)
+	ErrorRate   float64  `json:"error_rate,omitempty"`
+	Window      Duration `json:"window"`
+	MinRequests int      `json:"min_requests"`
+	// Ignore lists server errors that never count against a backend
+	Ignore []PassiveIgnoreConfig `json:"ignore,omitempty"`
+}
+
+// PassiveIgnoreConfig excludes statuses, optionally only on paths starting
+// with PathPrefix, from passive health checking
+type PassiveIgnoreConfig struct {
+	Status     []int  `json:"status"`
+	PathPrefix string `json:"path_prefix,omitempty"`
+}
+
+// AdaptiveConfig bounds the adaptive health check schedule
+type AdaptiveConfig struct {
+	// MinInterval is used for backends that are down or just changed state
+	MinInterval Duration `json:"min_interval"`
+	// MaxInterval is what stable, healthy backends back off to
+	MaxInterval Duration `json:"max_interval"`
+}
+
+// AdminConfig controls the admin listener and its management features
+type AdminConfig struct {
+	Address string `json:"address"`
+	// Token, if set, must be sent as "Authorization: Bearer <token>" on
+	// every admin request; it is a shorthand for Auth.Token
+	Token string `json:"token,omitempty"`
+	// DrainTimeout bounds how long a backend removed with drain=true may
+	// keep serving in-flight requests
+	DrainTimeout Duration     `json:"drain_timeout"`
+	Freeze       FreezeConfig `json:"freeze"`
+	// JournalSize is how many recent changes /admin/changes keeps
+	JournalSize int `json:"journal_size"`
+	// EventsSize is how many recent backend state transitions /nexus/events
+	// keeps; EventsFile, if set, also appends every transition to a file
+	EventsSize int    `json:"events_size"`
+	EventsFile string `json:"events_file,omitempty"`
+	// Pprof serves the Go profiler under /debug/pprof/ on the admin listener
+	Pprof bool `json:"pprof,omitempty"`
+	// Auth, if set, protects every admin endpoint, reads included
+	Auth *AdminAuthConfig `json:"auth,omitempty"`
+	// AllowRemoteAdmin lets the admin listener bind to, and answer, other
+	// than loopback addresses; it requires credentials in Auth, as
+	// allow_cidrs alone do not authenticate anyone
+	AllowRemoteAdmin bool `json:"allow_remote_admin,omitempty"`
+}
+
+// hasCredentials reports whether admin requests must carry a token or
+// basic auth credentials
+func (a AdminConfig) hasCredentials() bool {
+	return a.Token != "" || (a.Auth != nil && (a.Auth.Token != "" || a.Auth.Username != ""))
+}
+
+// AdminAuthConfig restricts who may use the admin listener. With
+// credentials configured, each request must carry the bearer token or the
+// basic auth credentials; with AllowCIDRs, it must also come from one of
+// those networks.
+type AdminAuthConfig struct {
+	// Token is accepted as "Authorization: Bearer <token>"; TokenFile
+	// reads it from a file instead
+	Token     string `json:"token,omitempty"`
+	TokenFile string `json:"token_file,omitempty"`
+	// Username and Password are accepted as HTTP basic auth; PasswordFile
+	// reads the password from a file instead
+	Username     string `json:"username,omitempty"`
+	Password     string `json:"password,omitempty"`
+	PasswordFile string `json:"password_file,omitempty"`
+	// AllowCIDRs lists the source networks admin requests may come from,
+	// such as "10.0.0.0/8" or "127.0.0.1/32"
+	AllowCIDRs []string `json:"allow_cidrs,omitempty"`
+}
+
+// MetricsConfig controls the Prometheus metrics served on the admin listener
+type MetricsConfig struct {
+	// DurationBuckets are the upper bounds, in seconds, of the request
+	// duration histogram
+	DurationBuckets []float64 `json:"duration_buckets"`
+}
+
+// StatsDConfig enables pushing metrics to a StatsD or DogStatsD server
+type StatsDConfig struct {
+	// Address is the host:port of the UDP listener
+	Address string `json:"address"`
+	Prefix  string `json:"prefix"`
+	// SampleRate applies to per-request metrics; backend up/down events
+	// are always sent (default 1)
+	SampleRate float64 `json:"sample_rate"`
+	// DogStatsD tags metrics instead of encoding the backend in their names
+	DogStatsD bool     `json:"dogstatsd"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// ProbesConfig enables /healthz and /readyz on the proxy listener, answered
+// by Nexus itself rather than a backend
+type ProbesConfig struct {
+	// MinAliveBackends is how many backends must be alive for /readyz to
+	// report ready (default 1)
+	MinAliveBackends int `json:"min_alive_backends"`
+	// ShutdownDelay is how long /readyz reports 503 on shutdown before
+	// connections are drained, giving upstream load balancers time to
+	// stop sending traffic
+	ShutdownDelay Duration `json:"shutdown_delay,omitzero"`
+}
+
+// StartupConfig is the gate Nexus passes before it opens the proxy
+// listener, or reports ready to the process it upgrades
+type StartupConfig struct {
+	// MinHealthy is how many backends must pass an active health check
+	// before the listener opens (default 0, no gate)
+	MinHealthy int `json:"min_healthy"`
+	// Timeout is how long they are waited for before Nexus exits with an
+	// error (default 30s)
+	Timeout Duration `json:"timeout,omitzero"`
+}
+
+// LogConfig controls the operational log written to stderr
+type LogConfig struct {
+	// Level is "debug", "info" (default), "warn" or "error"; per-request
+	// lines are only logged at debug
+	Level string `json:"level"`
+	// Format is "text" (default) or "json"
+	Format string `json:"format"`
+	// DedupWindow collapses identical failure and retry lines logged within
+	// the window into one line with a suppressed count (default 5s, 0 to
+	// log every line)
+	DedupWindow Duration `json:"dedup_window"`
+}
+
+// AccessLogConfig enables a per-request access log, kept apart from the
+// operational log on stderr
+type AccessLogConfig struct {
+	// Format is "text" (default) or "json"
+	Format string `json:"format"`
+	// Output is "stdout" (default), "stderr" or a file path
+	Output string `json:"output"`
+	// MaxSizeMB and MaxAge rotate a log file once it grows past the size or
+	// has been written to for longer than the age; MaxBackups rotated
+	// files are kept (all of them if zero)
+	MaxSizeMB  int      `json:"max_size_mb,omitempty"`
+	MaxAge     Duration `json:"max_age,omitzero"`
+	MaxBackups int      `json:"max_backups,omitempty"`
+	// QueueSize bounds the lines waiting to be written; further lines are
+	// dropped and counted (default 8192)
+	QueueSize int `json:"queue_size,omitempty"`
+	// SampleEvery logs one in every N successful requests; retries and 5xx
+	// responses are always logged (default 1, log everything)
+	SampleEvery int `json:"sample_every,omitempty"`
+}
+
+// TracingConfig enables OpenTelemetry tracing of proxied requests
+type TracingConfig struct {
+	// OTLPEndpoint is the OTLP/HTTP traces URL of a collector, such as
+	// "http://localhost:4318/v1/traces"
+	OTLPEndpoint string `json:"otlp_endpoint"`
+	// SampleRatio is the fraction of new traces recorded (default 1);
+	// requests with a traceparent follow the caller's decision
+	SampleRatio float64 `json:"sample_ratio"`
+	// ServiceName is reported as service.name (default "nexus")
+	ServiceName string `json:"service_name"`
+}
+
+// RetryConfig controls when a failed request may be tried on another backend
+type RetryConfig struct {
+	// MaxBodyBytes is the largest request body buffered so it can be sent
+	// again; requests with larger or streaming bodies are never retried
+	// (default 1 MiB, 0 to retry only requests without a body)
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+	// Backoff is the pause before the first retry (default 10ms)
+	Backoff Duration `json:"backoff"`
+	// BackoffType is "fixed" (default) or "exponential", which doubles the
+	// pause with each retry up to MaxBackoff (default 1s)
+	BackoffType string   `json:"backoff_type"`
+	MaxBackoff  Duration `json:"max_backoff"`
+	// Jitter spreads each pause between half and all of its length
+	Jitter bool `json:"jitter,omitempty"`
+	// OnStatus lists backend response codes (502, 503 or 504) that are
+	// retried like connection errors; by default only the latter are
+	OnStatus []int `json:"on_status,omitempty"`
+	// Budget bounds the time a request may spend retrying, attempts
+	// included; zero leaves it to max_retries alone
+	Budget Duration `json:"budget,omitzero"`
+}
+
+// TLSConfig enables a TLS listener next to the plain one
+type TLSConfig struct {
+	// Listen is the address of the TLS listener; empty disables it
+	Listen   string `json:"listen,omitempty"`
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	// HTTP2 offers HTTP/2 to clients through ALPN (default true)
+	HTTP2 bool `json:"http2"`
+	// ACME, if set, obtains and renews certificates automatically instead
+	// of reading CertFile and KeyFile
+	ACME *ACMEConfig `json:"acme,omitempty"`
+	// MinVersion and MaxVersion bound the TLS versions accepted, "1.0" to
+	// "1.3" (default "1.2" and the newest supported)
+	MinVersion string `json:"min_version,omitempty"`
+	MaxVersion string `json:"max_version,omitempty"`
+	// CipherSuites, if set, are the only cipher suites accepted up to TLS
+	// 1.2, by their IANA names such as
+	// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256; those of TLS 1.3 cannot be
+	// chosen
+	CipherSuites []string `json:"cipher_suites,omitempty"`
+	// ClientCAFile, if set, is a PEM bundle of the CAs client certificates
+	// are verified against
+	ClientCAFile string `json:"client_ca_file,omitempty"`
+	// ClientAuth is "require" (the default) to refuse clients without a
+	// valid certificate, or "verify" to verify those that present one and
+	// let the others in
+	ClientAuth string `json:"client_auth,omitempty"`
+	// ForwardClientCert sends backends the client certificate in
+	// X-Forwarded-Client-Cert
+	ForwardClientCert bool `json:"forward_client_cert,omitempty"`
+	// HTTP3, if set, also serves HTTP/3 over QUIC with the same
+	// certificates, and advertises it to HTTP/1.1 and HTTP/2 clients
+	HTTP3 *HTTP3Config `json:"http3,omitempty"`
+}
+
+// HTTP3Config is the QUIC listener serving HTTP/3
+type HTTP3Config struct {
+	// Listen is its UDP address (default that of tls.listen)
+	Listen string `json:"listen,omitempty"`
+	// AltSvcMaxAge is how long clients may remember, from the Alt-Svc
+	// header of TLS responses, that HTTP/3 is offered (default 24h)
+	AltSvcMaxAge Duration `json:"alt_svc_max_age"`
+}
+
+// tlsVersions are the TLS versions by their names in the configuration
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Versions returns the lowest and highest TLS versions accepted; 0 leaves
+// the highest to crypto/tls
+func (t TLSConfig) Versions() (uint16, uint16) {
+	return tlsVersions[t.MinVersion], tlsVersions[t.MaxVersion]
+}
+
+// CipherSuiteIDs returns the IDs of CipherSuites, nil if it is empty
+func (t TLSConfig) CipherSuiteIDs() []uint16 {
+	var ids []uint16
+	for _, name := range t.CipherSuites {
+		for _, cs := range tls.CipherSuites() {
+			if cs.Name == name {
+				ids = append(ids, cs.ID)
+			}
+		}
+	}
+	return ids
+}
+
+// ACMEConfig obtains certificates from an ACME CA such as Let's Encrypt
+type ACMEConfig struct {
+	// Hosts are the only names certificates are requested for
+	Hosts []string `json:"hosts"`
+	// CacheDir keeps the account key and certificates across restarts
+	CacheDir string `json:"cache_dir"`
+	// Email is given to the CA for expiry and account notices
+	Email string `json:"email,omitempty"`
+	// DirectoryURL selects the CA (default Let's Encrypt production)
+	DirectoryURL string `json:"directory_url,omitempty"`
+}
+
+// ServerConfig holds the timeouts and limits of the proxy listener, which
+// keep slow or idle clients from holding connections open indefinitely
+type ServerConfig struct {
+	// ReadHeaderTimeout bounds reading a request's headers (default 10s)
+	ReadHeaderTimeout Duration `json:"read_header_timeout"`
+	// ReadTimeout bounds reading a whole request, body included (default 60s)
+	ReadTimeout Duration `json:"read_timeout"`
+	// WriteTimeout bounds writing a response (default 60s); a streaming
+	// response (SSE or of unknown length) is instead cut off only once a
+	// single write takes that long
+	WriteTimeout Duration `json:"write_timeout"`
+	// IdleTimeout bounds how long a keep-alive connection waits for its
+	// next request (default 120s)
+	IdleTimeout Duration `json:"idle_timeout"`
+	// MaxHeaderBytes caps the size of request headers (default 1 MiB)
+	MaxHeaderBytes int `json:"max_header_bytes"`
+	// MaxHeaderCount caps the header fields of a request (default 100)
+	// and MaxHeaderValueBytes the length of each value (default 8 KiB);
+	// requests over either are answered 431. Zero lifts the limit.
+	MaxHeaderCount      int `json:"max_header_count"`
+	MaxHeaderValueBytes int `json:"max_header_value_bytes"`
+
+	// ProxyProtocol reads the PROXY protocol v1 or v2 header a load
+	// balancer may send ahead of each connection, on both the plain and
+	// TLS listeners, and takes the client address from it. The header must
+	// arrive within ReadHeaderTimeout.
+	ProxyProtocol bool `json:"proxy_protocol,omitempty"`
+	// RequireProxyProtocol refuses connections that don't send the header;
+	// it implies ProxyProtocol
+	RequireProxyProtocol bool `json:"require_proxy_protocol,omitempty"`
+
+	// Routes override ReadTimeout and WriteTimeout on paths starting with
+	// their prefix; the longest matching prefix wins
+	Routes []ServerRouteConfig `json:"routes,omitempty"`
+}
+
+// ServerRouteConfig holds the timeouts of one path prefix; a zero timeout
+// lifts it there
+type ServerRouteConfig struct {
+	PathPrefix   string   `json:"path_prefix"`
+	ReadTimeout  Duration `json:"read_timeout"`
+	WriteTimeout Duration `json:"write_timeout"`
+	// FlushInterval, if set, replaces the flush interval of the backends
+	// serving the route; negative flushes after every write
+	FlushInterval Duration `json:"flush_interval,omitzero"`
+}
+
+// WebSocketConfig controls connections tunneled after a protocol upgrade
+type WebSocketConfig struct {
+	// IdleTimeout closes a tunnel once no data has crossed it in either
+	// direction for this long (default 0, never)
+	IdleTimeout Duration `json:"idle_timeout,omitzero"`
+}
+
+// HedgingConfig sends a GET or HEAD request to a second backend once the
+// first has not sent response headers within a delay; whichever answers
+// first is relayed and the other attempt canceled
+type HedgingConfig struct {
+	// Delay is how long the first backend gets (default 100ms)
+	Delay Duration `json:"delay"`
+	// DelayPercentile, "p50", "p90", "p95" or "p99", uses that percentile
+	// of the backend's latency over the last minute instead, falling back
+	// to Delay while it has none
+	DelayPercentile string `json:"delay_percentile,omitempty"`
+	// MaxOutstanding caps the hedges in flight at once (default 10)
+	MaxOutstanding int `json:"max_outstanding"`
+	// MaxPercent caps hedges at this percentage of the GET and HEAD
+	// requests (default 10)
+	MaxPercent float64 `json:"max_percent"`
+}
+
+// CircuitBreakerConfig opens a backend's circuit after a burst of failed
+// requests, connection errors, timeouts and server errors alike, so it is
+// skipped at once; after a cooloff a few trial requests decide whether it
+// closes again
+type CircuitBreakerConfig struct {
+	// Failures within Window open the circuit (default 5 within 10s)
+	Failures int      `json:"failures"`
+	Window   Duration `json:"window"`
+	// Cooloff is how long the circuit stays open (default 5s)
+	Cooloff Duration `json:"cooloff"`
+	// HalfOpenRequests is the number of trial requests that must succeed
+	// for the circuit to close (default 3)
+	HalfOpenRequests int `json:"half_open_requests"`
+}
+
+// RewriteRuleConfig redirects or rewrites the requests whose path, as the
+// client encoded it, the regular expression Path matches in full. Redirect
+// and Rewrite may refer to its groups as $1 or ${name}, and to the host as
+// ${host}; the query string is kept. Exactly one of them is set.
+type RewriteRuleConfig struct {
+	// Scheme, if set, limits the rule to "http" or "https" requests
+	Scheme string `json:"scheme,omitempty"`
+	// Path matches every path if empty
+	Path     string `json:"path,omitempty"`
+	Redirect string `json:"redirect,omitempty"`
+	// Status is the status code of the redirect: 301 (default), 302, 303,
+	// 307 or 308
+	Status int `json:"status,omitempty"`
+	// Rewrite is the new path; rules are tried again from the first after
+	// each rewrite, up to 10 times
+	Rewrite string `json:"rewrite,omitempty"`
+}
+
+// RoutingConfig picks the pool of each request from its Host header, path,
+// method and headers, so one Nexus can front several sites and services.
+// Requests no route matches go to the default pool, where the traffic
+// split, if any, still applies.
+type RoutingConfig struct {
+	// Rules are tried first, in order, and the first whose conditions all
+	// hold wins
+	Rules []RouteRuleConfig `json:"rules,omitempty"`
+	// Hosts map host names, exact or wildcards such as "*.example.com", to
+	// pools; an exact name wins over wildcards and a longer wildcard over a
+	// shorter one
+	Hosts []HostRouteConfig `json:"hosts"`
+	// Paths map path prefixes to pools. The longest matching prefix wins,
+	// and a path route wins over a host route.
+	Paths []PathRouteConfig `json:"paths,omitempty"`
+	// NoHost is what happens to requests without a Host header: "default"
+	// routes them by path alone (the default), "reject" answers 421
+	NoHost string `json:"no_host,omitempty"`
+	// NoMatch is what happens to requests no route matches: "default" sends
+	// them to the default pool (the default), "404" answers 404
+	NoMatch string `json:"no_match,omitempty"`
+	// PoolMiddleware is the middleware of the routes to each pool that
+	// list none of their own; that of "default" also applies to requests
+	// no route matches
+	PoolMiddleware map[string][]MiddlewareConfig `json:"pool_middleware,omitempty"`
+}
+
+// HostRouteConfig routes a host to a pool
+type HostRouteConfig struct {
+	Host string `json:"host"`
+	Pool string `json:"pool"`
+	// FallbackPool, if set, serves the route's requests while its pool has
+	// no backend available
+	FallbackPool string `json:"fallback_pool,omitempty"`
+	// Middleware, if set, replaces the pool's middleware for the route
+	Middleware []MiddlewareConfig `json:"middleware,omitempty"`
+}
+
+// MiddlewareConfig is one step of a route's middleware; exactly one field is
+// set. The steps of a route run in order, ahead of the proxy.
+type MiddlewareConfig struct {
+	// RateLimit limits each client's request rate on the route, separately
+	// from the global rate_limit
+	RateLimit *RateLimitConfig `json:"rate_limit,omitempty"`
+	// BodyLimit replaces the request_body limit on the route
+	BodyLimit *BodyLimitConfig `json:"body_limit,omitempty"`
+	// Timeout replaces the listener's read and write timeouts on the route
+	Timeout *TimeoutConfig `json:"timeout,omitempty"`
+	// RequestTimeout bounds the whole of each request on the route,
+	// retries included; a backend that has not answered by then gets the
+	// client a 504. It must fit within the write timeout.
+	RequestTimeout Duration `json:"request_timeout,omitzero"`
+	// Headers changes request and response headers, after any top-level
+	// header rules
+	Headers *HeaderRuleConfig `json:"headers,omitempty"`
+	// IPFilter admits or refuses clients of the route by IP, after any
+	// top-level ip_filter
+	IPFilter *IPFilterConfig `json:"ip_filter,omitempty"`
+	// Auth requires clients of the route to authenticate
+	Auth *AuthConfig `json:"auth,omitempty"`
+	// JWT requires clients of the route to send a valid bearer token
+	JWT *JWTConfig `json:"jwt,omitempty"`
+	// SecurityHeaders replaces the top-level security_headers on the route
+	SecurityHeaders *SecurityHeadersConfig `json:"security_headers,omitempty"`
+	// CORS answers preflights and sets the CORS headers of responses
+	CORS *CORSConfig `json:"cors,omitempty"`
+}
+
+// CORSConfig is the CORS policy of a route. Preflights from allowed
+// origins are answered by Nexus, and the Access-Control-* headers of
+// responses to them replaced. Requests from other origins pass through
+// untouched unless Strict answers them 403.
+type CORSConfig struct {
+	// AllowedOrigins are exact origins such as https://app.example.com,
+	// patterns such as https://*.example.com, or "*" for any
+	AllowedOrigins []string `json:"allowed_origins"`
+	// AllowedMethods are the methods of preflights (default GET, HEAD and
+	// POST)
+	AllowedMethods []string `json:"allowed_methods,omitempty"`
+	// AllowedHeaders are the request headers of preflights; "*" allows any
+	AllowedHeaders []string `json:"allowed_headers,omitempty"`
+	// ExposedHeaders are the response headers scripts may read
+	ExposedHeaders []string `json:"exposed_headers,omitempty"`
+	// MaxAge is how long browsers may cache a preflight answer
+	MaxAge           Duration `json:"max_age,omitzero"`
+	AllowCredentials bool     `json:"allow_credentials,omitempty"`
+	Strict           bool     `json:"strict,omitempty"`
+}
+
+// AuthConfig requires clients to authenticate with basic auth, checked
+// against an htpasswd file, or with an API key sent in a header; with both
+// set, either will do. Failures are answered 401.
+type AuthConfig struct {
+	// Realm is sent in WWW-Authenticate (default "nexus")
+	Realm string `json:"realm,omitempty"`
+	// HtpasswdFile holds "user:hash" lines with bcrypt hashes, as written
+	// by htpasswd -B; it is read again on SIGHUP
+	HtpasswdFile string `json:"htpasswd_file,omitempty"`
+	// APIKeyHeader is the header API keys are sent in (default X-API-Key)
+	APIKeyHeader string `json:"api_key_header,omitempty"`
+	// APIKeys maps the name of each client, logged as its user, to its key
+	APIKeys map[string]string `json:"api_keys,omitempty"`
+}
+
+// JWTConfig requires a bearer token signed with one of the keys of a JWKS
+// endpoint, or with a static public key, whose iss and aud claims match.
+// Failures are answered 401 with a JSON error.
+type JWTConfig struct {
+	// Realm is sent in WWW-Authenticate (default "nexus")
+	Realm string `json:"realm,omitempty"`
+	// JWKSURL is where the signing keys are published; they are fetched
+	// again every jwks_refresh (default 10m), and at once for a token
+	// signed with a key not seen yet
+	JWKSURL     string   `json:"jwks_url,omitempty"`
+	JWKSRefresh Duration `json:"jwks_refresh,omitzero"`
+	// PublicKeyFile holds the PEM public key or certificate of the signer
+	PublicKeyFile string `json:"public_key_file,omitempty"`
+	Issuer        string `json:"issuer"`
+	Audience      string `json:"audience"`
+	// ClockSkew is how far exp and nbf may be off (default 30s)
+	ClockSkew Duration `json:"clock_skew,omitzero"`
+	// ForwardClaims maps claims, such as sub or a tenant claim, to the
+	// request headers they are sent to the backend in; headers of the
+	// same names sent by clients are removed
+	ForwardClaims map[string]string `json:"forward_claims,omitempty"`
+	// SkipPreflight lets CORS preflight requests through without a token
+	SkipPreflight bool `json:"skip_preflight,omitempty"`
+}
+
+// BodyLimitConfig caps request bodies; zero lifts the limit
+type BodyLimitConfig struct {
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+// TimeoutConfig holds read and write timeouts; zero lifts a timeout
+type TimeoutConfig struct {
+	ReadTimeout  Duration `json:"read_timeout"`
+	WriteTimeout Duration `json:"write_timeout"`
+}
+
+// RouteRuleConfig routes the requests meeting all of its conditions to a
+// pool; a rule without conditions matches every request
+type RouteRuleConfig struct {
+	// Name labels the rule in metrics and the access log (default
+	// "rules[i]")
+	Name string `json:"name,omitempty"`
+	// Host is an exact name or a wildcard such as "*.example.com"
+	Host string `json:"host,omitempty"`
+	// PathPrefix matches like that of a path route
+	PathPrefix string              `json:"path_prefix,omitempty"`
+	Methods    []string            `json:"methods,omitempty"`
+	Headers    []HeaderMatchConfig `json:"headers,omitempty"`
+	Pool       string              `json:"pool"`
+	// FallbackPool, if set, serves the rule's requests while its pool has
+	// no backend available
+	FallbackPool string `json:"fallback_pool,omitempty"`
+	// StripPrefix removes PathPrefix from the path sent to the backend
+	StripPrefix bool `json:"strip_prefix,omitempty"`
+	// Middleware, if set, replaces the pool's middleware for the rule
+	Middleware []MiddlewareConfig `json:"middleware,omitempty"`
+}
+
+// HeaderMatchConfig is a condition on a request header: one of its values
+// must equal Value, or be matched in full by the regular expression Regex
+type HeaderMatchConfig struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+	Regex string `json:"regex,omitempty"`
+}
+
+// PathRouteConfig routes requests under a path prefix to a pool. Prefixes
+// match whole path segments, with or without a trailing slash: "/api" and
+// "/api/" both match /api and /api/users but not /apis.
+type PathRouteConfig struct {
+	// Name labels the route in metrics and the access log (default the host
+	// and prefix)
+	Name string `json:"name,omitempty"`
+	// Host, if set, limits the route to one host, exact or wildcard
+	Host       string `json:"host,omitempty"`
+	PathPrefix string `json:"path_prefix"`
+	Pool       string `json:"pool"`
+	// FallbackPool, if set, serves the route's requests while its pool has
+	// no backend available
+	FallbackPool string `json:"fallback_pool,omitempty"`
+	// StripPrefix removes the prefix from the path sent to the backend, so
+	// /api/users reaches it as /users
+	StripPrefix bool `json:"strip_prefix,omitempty"`
+	// Middleware, if set, replaces the pool's middleware for the route
+	Middleware []MiddlewareConfig `json:"middleware,omitempty"`
+}
+
+// SplitConfig divides requests between the default pool, the backends
+// without a pool, and the named pools, for canary releases. Each request is
+// served by backends of its pool only.
+type SplitConfig struct {
+	// Percent is the share of traffic of each named pool; the default
+	// pool takes the rest. It can be changed at runtime through the admin
+	// API.
+	Percent map[string]float64 `json:"percent"`
+	// Sticky keeps clients in one pool: "ip" by a hash of the client IP,
+	// "cookie" by a cookie set on their first response, "header" by a hash
+	// of a request header (default none)
+	Sticky string `json:"sticky,omitempty"`
+	// Cookie names the cookie of sticky "cookie" (default "nexus_split")
+	Cookie string `json:"cookie,omitempty"`
+	// StickyHeader names the request header of sticky "header", such as
+	// one a route's jwt middleware forwards a claim in
+	StickyHeader string `json:"sticky_header,omitempty"`
+	// Header, if set, names the response header telling which pool served
+	// the request
+	Header string `json:"header,omitempty"`
+}
+
+// MaintenanceConfig shapes the answer of pools in maintenance. Pools are
+// put in and out of maintenance at runtime through the admin API; that
+// state survives reloads but not restarts.
+type MaintenanceConfig struct {
+	// Page is the body of the 503 (default the 503 page of error_pages, if
+	// any)
+	Page *ErrorPageConfig `json:"page,omitempty"`
+	// RetryAfter is sent as Retry-After (default that of error_pages)
+	RetryAfter Duration `json:"retry_after,omitzero"`
+	// Allow lists CIDRs whose clients are let through to pools in
+	// maintenance, as resolved through the trusted proxies
+	Allow []string `json:"allow,omitempty"`
+}
+
+// MirrorConfig copies requests to shadow backends, whose responses are
+// discarded. Mirrored requests are sent in the background and never delay
+// or change the answer to the client.
+type MirrorConfig struct {
+	// Workers is how many mirrored requests are sent at once (default 4)
+	Workers int `json:"workers"`
+	// QueueSize bounds the requests waiting for a worker; further ones are
+	// dropped (default 256)
+	QueueSize int `json:"queue_size"`
+	// Timeout bounds each mirrored request (default 10s)
+	Timeout Duration `json:"timeout"`
+
+	// Rules are applied independently; a request can match several
+	Rules []MirrorRuleConfig `json:"rules"`
+}
+
+// MirrorRuleConfig mirrors a percentage of the matching requests. Only
+// requests whose bodies are buffered for retries (retry.max_body_bytes)
+// can be mirrored with their body.
+type MirrorRuleConfig struct {
+	// Targets are the shadow backend URLs, sent to in turn
+	Targets []string `json:"targets"`
+	// Percent of the matching requests is mirrored, from 0 to 100
+	Percent float64 `json:"percent"`
+	// Methods, if set, limits the rule to these methods
+	Methods []string `json:"methods,omitempty"`
+	// PathPrefix, if set, limits the rule to paths starting with it
+	PathPrefix string `json:"path_prefix,omitempty"`
+}
+
+// RequestBodyConfig limits request bodies; larger ones are answered with 413
+// without reaching a backend
+type RequestBodyConfig struct {
+	// MaxBytes is the largest body accepted (default 0, unlimited)
+	MaxBytes int64 `json:"max_bytes"`
+	// Routes override MaxBytes on paths starting with their prefix; the
+	// longest matching prefix wins
+	Routes []RequestBodyRouteConfig `json:"routes,omitempty"`
+}
+
+// ConcurrencyLimitConfig sheds load during a spike: requests beyond the cap
+// are answered 503 at once rather than piling onto backend connections.
+// Probes and the admin API are exempt.
+type ConcurrencyLimitConfig struct {
+	// MaxInFlight is the most requests proxied at once (default 0, no
+	// limit); the admin API can change it at runtime
+	MaxInFlight int `json:"max_in_flight"`
+	// RetryAfter is sent to rejected clients in whole seconds (default 1s)
+	RetryAfter Duration `json:"retry_after"`
+}
+
+// RateLimitConfig gives each client IP, as resolved through the trusted
+// proxies, a token bucket; requests beyond it are answered 429
+type RateLimitConfig struct {
+	// Rate is the requests per second a client may sustain
+	Rate float64 `json:"rate"`
+	// Burst is how many requests a client may send at once (default the
+	// rate, rounded up)
+	Burst int `json:"burst"`
+	// MaxClients bounds the clients tracked at once, forgetting the least
+	// recently seen (default 10000)
+	MaxClients int `json:"max_clients"`
+	// Exempt lists CIDRs whose clients are never limited
+	Exempt []string `json:"exempt,omitempty"`
+}
+
+// IPFilterConfig admits or refuses clients by IP, as resolved through the
+// trusted proxies; refused clients are answered 403. Deny is checked first,
+// so a client in both lists is refused. If Allow is set, only clients in
+// it are admitted. The lists can be replaced at runtime through the admin
+// API.
+type IPFilterConfig struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// SecurityHeadersConfig is a baseline of security headers for responses.
+// They are added to responses without them, or with Always, replace those
+// of backends.
+type SecurityHeadersConfig struct {
+	// HSTS, if set, sends Strict-Transport-Security
+	HSTS *HSTSConfig `json:"hsts,omitempty"`
+	// ContentTypeOptions sends X-Content-Type-Options: nosniff
+	ContentTypeOptions bool `json:"content_type_options,omitempty"`
+	// FrameOptions, if set, is sent as X-Frame-Options: "DENY" or
+	// "SAMEORIGIN"
+	FrameOptions string `json:"frame_options,omitempty"`
+	// ContentSecurityPolicy, if set, is sent as Content-Security-Policy
+	ContentSecurityPolicy string `json:"content_security_policy,omitempty"`
+	Always                bool   `json:"always,omitempty"`
+}
+
+// HSTSConfig shapes the Strict-Transport-Security header
+type HSTSConfig struct {
+	// MaxAge is how long browsers remember to use HTTPS (default 8760h, a
+	// year)
+	MaxAge            Duration `json:"max_age,omitzero"`
+	IncludeSubdomains bool     `json:"include_subdomains,omitempty"`
+	Preload           bool     `json:"preload,omitempty"`
+}
+
+// QueueConfig bounds the queue of requests waiting for a backend while none
+// is available, such as when every backend is briefly down or its circuit
+// open; they proceed once one is, or fail with 503
+type QueueConfig struct {
+	// MaxDepth is the most requests waiting at once (default 100, 0 to
+	// fail them at once)
+	MaxDepth int `json:"max_depth"`
+	// MaxWait is how long a request waits at most (default 200ms)
+	MaxWait Duration `json:"max_wait"`
+}
+
+// RequestBodyRouteConfig is the body limit of one path prefix; a MaxBytes of
+// zero lifts the limit there
+type RequestBodyRouteConfig struct {
+	PathPrefix string `json:"path_prefix"`
+	MaxBytes   int64  `json:"max_bytes"`
+}
+
+// CompressionConfig controls gzip compression of responses
+type CompressionConfig struct {
+	// MinSize is the smallest body compressed, in bytes (default 1024)
+	MinSize int `json:"min_size"`
+	// Types lists the content types compressed; a trailing "/" matches a
+	// whole family such as "text/" (default text, JSON, JavaScript, XML,
+	// WebAssembly and SVG)
+	Types []string `json:"types,omitempty"`
+	// Level is the gzip level from 1 (fastest) to 9 (smallest); 0 uses the
+	// gzip default
+	Level int `json:"level,omitempty"`
+}
+
+// HeaderRuleConfig changes the headers of requests toward backends and of
+// responses toward clients, on paths starting with PathPrefix if it is set
+type HeaderRuleConfig struct {
+	PathPrefix string           `json:"path_prefix,omitempty"`
+	Request    *HeaderOpsConfig `json:"request,omitempty"`
+	Response   *HeaderOpsConfig `json:"response,omitempty"`
+}
+
+// HeaderOpsConfig lists header changes; Remove runs first, then Set and Add.
+// Remove entries may end in "*"; Set and Add values may use ${client_ip},
+// ${host} and ${request_id}.
+type HeaderOpsConfig struct {
+	Set    map[string]string `json:"set,omitempty"`
+	Add    map[string]string `json:"add,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+}
+
+// ErrorPagesConfig customizes the error responses Nexus writes itself
+type ErrorPagesConfig struct {
+	// Pages maps a status code (400, 413, 500, 502, 503 or 504) to its page
+	Pages map[int]ErrorPageConfig `json:"pages,omitempty"`
+	// RetryAfter is sent as Retry-After on every 503
+	RetryAfter Duration `json:"retry_after,omitzero"`
+}
+
+// ErrorPageConfig is the body of one error status. With both an HTML and a
+// JSON template, the client's Accept header picks between them.
+type ErrorPageConfig struct {
+	// HTMLFile is an html/template file
+	HTMLFile string `json:"html_file,omitempty"`
+	// JSON is a text/template for a JSON body, JSONFile one read from a file
+	JSON     string `json:"json,omitempty"`
+	JSONFile string `json:"json_file,omitempty"`
+}
+
+// FreezeConfig controls the administrative freeze mode
+type FreezeConfig struct {
+	// MaxDuration caps how long a freeze may last before it expires on its own
+	MaxDuration Duration `json:"max_duration"`
+	// OnExpire is "apply" or "discard" and decides what happens to queued
+	// changes when a freeze expires
+	OnExpire string `json:"on_expire"`
+	// LogOnlyHealth holds back health transitions while frozen instead of
+	// applying them
+	LogOnlyHealth bool `json:"log_only_health"`
+}
+
+// Default returns the built-in configuration used when no file is given
+func Default() *Config {
+	return &Config{
+		Listen: ":8000",
+		H2C:    true,
+		TLS:    TLSConfig{HTTP2: true},
+		Backends: []BackendConfig{
+			{URL: "http://localhost:8081"},
+			{URL: "http://localhost:8082"},
+			{URL: "http://localhost:8083"},
+		},
+		HealthCheck: HealthCheckConfig{
+			Interval: Duration(10 * time.Second),
+			Timeout:  Duration(2 * time.Second),
+			Passive: PassiveConfig{
+				Consecutive5xx: 5,
+				Window:         Duration(30 * time.Second),
+				MinRequests:    20,
+			},
+		},
+		ShutdownTimeout: Duration(30 * time.Second),
+		UpgradeTimeout:  Duration(30 * time.Second),
+		MaxRetries:      3,
+		Server: ServerConfig{
+			ReadHeaderTimeout:   Duration(10 * time.Second),
+			ReadTimeout:         Duration(60 * time.Second),
+			WriteTimeout:        Duration(60 * time.Second),
+			IdleTimeout:         Duration(120 * time.Second),
+			MaxHeaderBytes:      1 << 20,
+			MaxHeaderCount:      100,
+			MaxHeaderValueBytes: 8 << 10,
+		},
+		ConcurrencyLimit: ConcurrencyLimitConfig{
+			RetryAfter: Duration(time.Second),
+		},
+		Queue: QueueConfig{
+			MaxDepth: 100,
+			MaxWait:  Duration(200 * time.Millisecond),
+		},
+		Retry: RetryConfig{
+			MaxBodyBytes: 1 << 20,
+			Backoff:      Duration(10 * time.Millisecond),
+			BackoffType:  "fixed",
+			MaxBackoff:   Duration(time.Second),
+		},
+		Transport: TransportConfig{
+			DialTimeout:         Duration(5 * time.Second),
+			KeepAlive:           Duration(30 * time.Second),
+			MaxIdleConns:        100,
+			IdleConnTimeout:     Duration(90 * time.Second),
+			TLSHandshakeTimeout: Duration(5 * time.Second),
+		},
+		Admin: AdminConfig{
+			Address:      "127.0.0.1:8001",
+			DrainTimeout: Duration(30 * time.Second),
+			Freeze: FreezeConfig{
+				MaxDuration: Duration(time.Hour),
+				OnExpire:    "apply",
+			},
+			JournalSize: 1000,
+			EventsSize:  500,
+		},
+		Metrics: MetricsConfig{
+			DurationBuckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		},
+		Log: LogConfig{
+			Level:       "info",
+			Format:      "text",
+			DedupWindow: Duration(5 * time.Second),
+		},
+	}
+}
+
+// Load reads a JSON configuration file on top of the defaults and validates it
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	cfg.applyDefaults()
+	if err := cfg.readSecretFiles(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// readSecretFiles loads secrets kept in separate files into the fields they
+// stand for; surrounding whitespace, such as a trailing newline, is dropped
+func (c *Config) readSecretFiles() error {
+	a := c.Admin.Auth
+	if a == nil {
+		return nil
+	}
+	for _, secret := range []struct {
+		file string
+		dst  *string
+		name string
+	}{
+		{a.TokenFile, &a.Token, "admin.auth.token_file"},
+		{a.PasswordFile, &a.Password, "admin.auth.password_file"},
+	} {
+		if secret.file == "" {
+			continue
+		}
+		data, err := os.ReadFile(secret.file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", secret.name, err)
+		}
+		*secret.dst = strings.TrimSpace(string(data))
+	}
+	return nil
+}
+
+// applyDefaults fills in defaults for settings nested in list entries and
+// optional blocks, which the top-level defaults cannot provide
+func (c *Config) applyDefaults() {
+	for i := range c.TCP {
+		c.TCP[i].applyDefaults(c.HealthCheck)
+	}
+	for i := range c.UDP {
+		c.UDP[i].applyDefaults(c.HealthCheck)
+	}
+	if c.Kubernetes.Resync == 0 {
+		c.Kubernetes.Resync = Duration(5 * time.Minute)
+	}
+	if c.Consul.Address == "" {
+		c.Consul.Address = "http://127.0.0.1:8500"
+	}
+	if c.Consul.Health == "" {
+		c.Consul.Health = "both"
+	}
+	if len(c.Etcd.Endpoints) == 0 {
+		c.Etcd.Endpoints = []string{"http://127.0.0.1:2379"}
+	}
+	if c.StatsD != nil && c.StatsD.SampleRate == 0 {
+		c.StatsD.SampleRate = 1
+	}
+	if a := c.AccessLog; a != nil {
+		if a.Format == "" {
+			a.Format = "text"
+		}
+		if a.Output == "" {
+			a.Output = "stdout"
+		}
+	}
+	if cc := c.Compression; cc != nil && cc.MinSize == 0 {
+		cc.MinSize = 1024
+	}
+	if m := c.Mirror; m != nil {
+		if m.Workers == 0 {
+			m.Workers = 4
+		}
+		if m.QueueSize == 0 {
+			m.QueueSize = 256
+		}
+		if m.Timeout == 0 {
+			m.Timeout = Duration(10 * time.Second)
+		}
+	}
+	if hc := c.Hedging; hc != nil {
+		if hc.Delay == 0 {
+			hc.Delay = Duration(100 * time.Millisecond)
+		}
+		if hc.MaxOutstanding == 0 {
+			hc.MaxOutstanding = 10
+		}
+		if hc.MaxPercent == 0 {
+			hc.MaxPercent = 10
+		}
+	}
+	if rl := c.RateLimit; rl != nil {
+		rl.applyDefaults()
+	}
+	if sh := c.SecurityHeaders; sh != nil {
+		sh.applyDefaults()
+	}
+	if rc := c.Routing; rc != nil {
+		for _, ml := range rc.middleware() {
+			for _, mw := range ml.list {
+				if mw.RateLimit != nil {
+					mw.RateLimit.applyDefaults()
+				}
+				if a := mw.Auth; a != nil {
+					if a.Realm == "" {
+						a.Realm = "nexus"
+					}
+					if a.APIKeyHeader == "" {
+						a.APIKeyHeader = "X-API-Key"
+					}
+				}
+				if sh := mw.SecurityHeaders; sh != nil {
+					sh.applyDefaults()
+				}
+				if c := mw.CORS; c != nil && c.AllowedMethods == nil {
+					c.AllowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+				}
+				if j := mw.JWT; j != nil {
+					if j.Realm == "" {
+						j.Realm = "nexus"
+					}
+					if j.JWKSURL != "" && j.JWKSRefresh == 0 {
+						j.JWKSRefresh = Duration(10 * time.Minute)
+					}
+					if j.ClockSkew == 0 {
+						j.ClockSkew = Duration(30 * time.Second)
+					}
+				}
+			}
+		}
+	}
+	if cb := c.CircuitBreaker; cb != nil {
+		if cb.Failures == 0 {
+			cb.Failures = 5
+		}
+		if cb.Window == 0 {
+			cb.Window = Duration(10 * time.Second)
+		}
+		if cb.Cooloff == 0 {
+			cb.Cooloff = Duration(5 * time.Second)
+		}
+		if cb.HalfOpenRequests == 0 {
+			cb.HalfOpenRequests = 3
+		}
+	}
+	if c.TLS.MinVersion == "" {
+		c.TLS.MinVersion = "1.2"
+	}
+	if c.TLS.ClientCAFile != "" && c.TLS.ClientAuth == "" {
+		c.TLS.ClientAuth = "require"
+	}
+	if h3 := c.TLS.HTTP3; h3 != nil {
+		if h3.Listen == "" {
+			h3.Listen = c.TLS.Listen
+		}
+		if h3.AltSvcMaxAge == 0 {
+			h3.AltSvcMaxAge = Duration(24 * time.Hour)
+		}
+	}
+	if sp := c.Split; sp != nil && sp.Sticky == "cookie" && sp.Cookie == "" {
+		sp.Cookie = "nexus_split"
+	}
+	if p := c.Probes; p != nil && p.MinAliveBackends == 0 {
+		p.MinAliveBackends = 1
+	}
+	if c.Startup.MinHealthy > 0 && c.Startup.Timeout == 0 {
+		c.Startup.Timeout = Duration(30 * time.Second)
+	}
+	if t := c.Tracing; t != nil {
+		if t.SampleRatio == 0 {
+			t.SampleRatio = 1
+		}
+		if t.ServiceName == "" {
+			t.ServiceName = "nexus"
+		}
+	}
+	for i := range c.Backends {
+		if c.Backends[i].Weight == 0 {
+			c.Backends[i].Weight = 1
+		}
+		// Naming the default pool is the same as naming none
+		if c.Backends[i].Pool == "default" {
+			c.Backends[i].Pool = ""
+		}
+		if c.Backends[i].ResolveInterval == 0 {
+			c.Backends[i].ResolveInterval = Duration(30 * time.Second)
+		}
+		if w := c.Backends[i].Warmup; w != nil {
+			if w.Path == "" {
+				w.Path = "/"
+			}
+			if w.Timeout == 0 {
+				w.Timeout = Duration(10 * time.Second)
+			}
+		}
+	}
+}
+
+// Validate checks the configuration for values Nexus cannot run with
+func (c *Config) Validate() error {
+	if c.Listen == "" {
+		return fmt.Errorf("listen address must not be empty")
+	}
+	if len(c.Backends) == 0 {
+		return fmt.Errorf("at least one backend is required")
+	}
+	if a := c.TLS.ACME; a != nil {
+		if c.TLS.Listen == "" {
+			return fmt.Errorf("tls.acme needs tls.listen")
+		}
+		if c.TLS.CertFile != "" || c.TLS.KeyFile != "" {
+			return fmt.Errorf("tls.acme cannot be combined with cert_file and key_file")
+		}
+		if len(a.Hosts) == 0 || a.CacheDir == "" {
+			return fmt.Errorf("tls.acme needs hosts and a cache_dir")
+		}
+	} else if c.TLS.Listen != "" && (c.TLS.CertFile == "" || c.TLS.KeyFile == "") {
+		return fmt.Errorf("tls needs cert_file and key_file, or acme")
+	}
+	if err := c.TLS.validate(); err != nil {
+		return err
+	}
+	for i, b := range c.Backends {
+		u, err := url.Parse(b.URL)
+		if err != nil {
+			return fmt.Errorf("backends[%d]: %w", i, err)
+		}
+		// Discovered backends are reached with the scheme after the "+" of
+		// their spec, http without one; a backends file and etcd name their
+		// own
+		scheme := u.Scheme
+		switch scheme {
+		case "srv", "k8s", "consul":
+			scheme = "http"
+		case "srv+https", "k8s+https", "consul+https":
+			scheme = "https"
+		case "file":
+			if !strings.HasPrefix(b.URL, "file://") || len(b.URL) == len("file://") {
+				return fmt.Errorf("backends[%d]: %s must be file:// followed by a path", i, b.URL)
+			}
+		case "etcd":
+			if !strings.HasPrefix(b.URL, "etcd://") || len(b.URL) == len("etcd://") {
+				return fmt.Errorf("backends[%d]: %s must be etcd:// followed by a key prefix", i, b.URL)
+			}
+		case "http", "https":
+		default:
+			return fmt.Errorf("backends[%d]: unsupported scheme %q", i, u.Scheme)
+		}
+		if scheme != u.Scheme && u.Host == "" {
+			return fmt.Errorf("backends[%d]: %s names no service", i, b.URL)
+		}
+		if strings.HasPrefix(u.Scheme, "k8s") {
+			if name, _, _ := strings.Cut(strings.Trim(u.Path, "/"), ":"); name == "" || strings.Contains(name, "/") {
+				return fmt.Errorf("backends[%d]: %s must be k8s://namespace/service or k8s://namespace/service:port", i, b.URL)
+			}
+		}
+		if b.ResolveInterval < 0 {
+			return fmt.Errorf("backends[%d].resolve_interval must not be negative", i)
+		}
+		if b.Weight < 0 {
+			return fmt.Errorf("backends[%d].weight must not be negative", i)
+		}
+		if w := b.Warmup; w != nil {
+			if w.Requests < 1 {
+				return fmt.Errorf("backends[%d].warmup.requests must be at least 1", i)
+			}
+			if w.LatencyBudget < 0 || w.Timeout <= 0 {
+				return fmt.Errorf("backends[%d].warmup.timeout must be positive and latency_budget not negative", i)
+			}
+		}
+		if b.HealthCheckInterval < 0 {
+			return fmt.Errorf("backends[%d].health_check_interval must not be negative", i)
+		}
+		switch {
+		case b.Protocol == "" || b.Protocol == "http1":
+		case b.Protocol == "h2c" && scheme == "http", b.Protocol == "h2" && scheme == "https":
+		case (b.Protocol == "h2c" || b.Protocol == "h2") && (scheme == "file" || scheme == "etcd"):
+		default:
+			return fmt.Errorf("backends[%d].protocol must be \"http1\", \"h2c\" with an http URL or \"h2\" with an https URL", i)
+		}
+	}
+	if c.HealthCheck.Interval <= 0 {
+		return fmt.Errorf("health_check.interval must be positive")
+	}
+	if c.HealthCheck.Timeout <= 0 {
+		return fmt.Errorf("health_check.timeout must be positive")
+	}
+	if a := c.HealthCheck.Adaptive; a != nil {
+		if a.MinInterval <= 0 || a.MaxInterval < a.MinInterval {
+			return fmt.Errorf("health_check.adaptive needs a positive min_interval no greater than max_interval")
+		}
+	}
+	if p := c.HealthCheck.Passive; p.Consecutive5xx < 0 || p.MinRequests < 0 ||
+		p.ErrorRate < 0 || p.ErrorRate > 1 || (p.ErrorRate > 0 && p.Window <= 0) {
+		return fmt.Errorf("health_check.passive needs non-negative counts, an error_rate between 0 and 1 and, with a rate, a positive window")
+	}
+	for i, ig := range c.HealthCheck.Passive.Ignore {
+		if len(ig.Status) == 0 {
+			return fmt.Errorf("health_check.passive.ignore[%d] needs at least one status", i)
+		}
+	}
+	if err := c.Transport.validate(); err != nil {
+		return fmt.Errorf("transport: %w", err)
+	}
+	for i, b := range c.Backends {
+		if err := b.Transport.validate(); err != nil {
+			return fmt.Errorf("backends[%d].transport: %w", i, err)
+		}
+	}
+	if c.MaxRetries < 1 {
+		return fmt.Errorf("max_retries must be at least 1")
+	}
+	if err := c.Retry.validate(); err != nil {
+		return fmt.Errorf("retry: %w", err)
+	}
+	if c.Admin.DrainTimeout <= 0 {
+		return fmt.Errorf("admin.drain_timeout must be positive")
+	}
+	if c.Admin.Freeze.MaxDuration <= 0 {
+		return fmt.Errorf("admin.freeze.max_duration must be positive")
+	}
+	if c.Admin.JournalSize < 1 {
+		return fmt.Errorf("admin.journal_size must be at least 1")
+	}
+	if c.Admin.AllowRemoteAdmin && !c.Admin.hasCredentials() {
+		return fmt.Errorf("admin.allow_remote_admin requires admin.token or admin.auth credentials")
+	}
+	if a := c.Admin.Auth; a != nil {
+		if (a.Username == "") != (a.Password == "") {
+			return fmt.Errorf("admin.auth needs both username and password for basic auth")
+		}
+		if c.Admin.Token != "" && (a.Token != "" || a.Username != "") {
+			return fmt.Errorf("admin.token cannot be combined with admin.auth credentials; use admin.auth.token")
+		}
+		if a.Token == "" && a.Username == "" && len(a.AllowCIDRs) == 0 {
+			return fmt.Errorf("admin.auth needs a token, basic auth credentials or allow_cidrs")
+		}
+		for _, cidr := range a.AllowCIDRs {
+			if _, err := netip.ParsePrefix(cidr); err != nil {
+				return fmt.Errorf("admin.auth.allow_cidrs: %w", err)
+			}
+		}
+	}
+	for _, cidr := range c.TrustedProxies {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			if _, addrErr := netip.ParseAddr(cidr); addrErr != nil {
+				return fmt.Errorf("trusted_proxies: %w", err)
+			}
+		}
+	}
+	for i, h := range c.Headers {
+		if err := h.validate(fmt.Sprintf("headers[%d]", i)); err != nil {
+			return err
+		}
+	}
+	if s := c.Server; s.ReadHeaderTimeout < 0 || s.ReadTimeout < 0 || s.WriteTimeout < 0 || s.IdleTimeout < 0 {
+		return fmt.Errorf("server timeouts must not be negative")
+	}
+	if c.Server.MaxHeaderBytes < 0 {
+		return fmt.Errorf("server.max_header_bytes must not be negative")
+	}
+	if c.Server.MaxHeaderCount < 0 || c.Server.MaxHeaderValueBytes < 0 {
+		return fmt.Errorf("server.max_header_count and max_header_value_bytes must not be negative")
+	}
+	for i, route := range c.Server.Routes {
+		if !strings.HasPrefix(route.PathPrefix, "/") {
+			return fmt.Errorf("server.routes[%d].path_prefix must start with /", i)
+		}
+		if route.ReadTimeout < 0 || route.WriteTimeout < 0 {
+			return fmt.Errorf("server.routes[%d] timeouts must not be negative", i)
+		}
+	}
+	if c.WebSocket.IdleTimeout < 0 {
+		return fmt.Errorf("websocket.idle_timeout must not be negative")
+	}
+	if c.Queue.MaxDepth < 0 || c.Queue.MaxWait < 0 {
+		return fmt.Errorf("queue settings must not be negative")
+	}
+	if c.ConcurrencyLimit.MaxInFlight < 0 || c.ConcurrencyLimit.RetryAfter < 0 {
+		return fmt.Errorf("concurrency_limit settings must not be negative")
+	}
+	if rl := c.RateLimit; rl != nil {
+		if err := rl.validate("rate_limit"); err != nil {
+			return err
+		}
+	}
+	if f := c.IPFilter; f != nil {
+		if err := f.validate("ip_filter"); err != nil {
+			return err
+		}
+	}
+	if sh := c.SecurityHeaders; sh != nil {
+		if err := sh.validate("security_headers"); err != nil {
+			return err
+		}
+	}
+	if c.RequestBody.MaxBytes < 0 {
+		return fmt.Errorf("request_body.max_bytes must not be negative")
+	}
+	for i, route := range c.RequestBody.Routes {
+		if !strings.HasPrefix(route.PathPrefix, "/") {
+			return fmt.Errorf("request_body.routes[%d].path_prefix must start with /", i)
+		}
+		if route.MaxBytes < 0 {
+			return fmt.Errorf("request_body.routes[%d].max_bytes must not be negative", i)
+		}
+	}
+	if hc := c.Hedging; hc != nil {
+		if hc.Delay < 0 || hc.MaxOutstanding < 0 {
+			return fmt.Errorf("hedging.delay and max_outstanding must not be negative")
+		}
+		if hc.MaxPercent < 0 || hc.MaxPercent > 100 {
+			return fmt.Errorf("hedging.max_percent must be between 0 and 100")
+		}
+		switch hc.DelayPercentile {
+		case "", "p50", "p90", "p95", "p99":
+		default:
+			return fmt.Errorf("hedging.delay_percentile must be p50, p90, p95 or p99")
+		}
+	}
+	if cb := c.CircuitBreaker; cb != nil &&
+		(cb.Failures < 0 || cb.Window < 0 || cb.Cooloff < 0 || cb.HalfOpenRequests < 0) {
+		return fmt.Errorf("circuit_breaker settings must not be negative")
+	}
+	for i, rw := range c.Rewrites {
+		if (rw.Redirect == "") == (rw.Rewrite == "") {
+			return fmt.Errorf("rewrites[%d] needs exactly one of redirect and rewrite", i)
+		}
+		if rw.Rewrite != "" && !strings.HasPrefix(rw.Rewrite, "/") {
+			return fmt.Errorf("rewrites[%d].rewrite must start with /", i)
+		}
+		if _, err := regexp.Compile(rw.Path); err != nil {
+			return fmt.Errorf("rewrites[%d].path: %w", i, err)
+		}
+		switch rw.Scheme {
+		case "", "http", "https":
+		default:
+			return fmt.Errorf("rewrites[%d].scheme must be http or https", i)
+		}
+		switch rw.Status {
+		case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+			http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			if rw.Status != 0 && rw.Redirect == "" {
+				return fmt.Errorf("rewrites[%d].status only applies to redirects", i)
+			}
+		default:
+			return fmt.Errorf("rewrites[%d].status must be 301, 302, 303, 307 or 308", i)
+		}
+	}
+	if rc := c.Routing; rc != nil {
+		pools := c.Pools()
+		for i, hr := range rc.Hosts {
+			if hr.Host == "" {
+				return fmt.Errorf("routing.hosts[%d].host is required", i)
+			}
+			if wild, ok := strings.CutPrefix(hr.Host, "*"); strings.Contains(wild, "*") || ok && !strings.HasPrefix(wild, ".") {
+				return fmt.Errorf("routing.hosts[%d].host: wildcards must look like *.example.com", i)
+			}
+			if hr.Pool != "" && hr.Pool != "default" && !slices.Contains(pools, hr.Pool) {
+				return fmt.Errorf("routing.hosts[%d]: no backend is in pool %q", i, hr.Pool)
+			}
+			if err := validateFallback(fmt.Sprintf("routing.hosts[%d]", i), hr.Pool, hr.FallbackPool, pools); err != nil {
+				return err
+			}
+		}
+		for i, ru := range rc.Rules {
+			if ru.PathPrefix != "" && !strings.HasPrefix(ru.PathPrefix, "/") {
+				return fmt.Errorf("routing.rules[%d].path_prefix must start with /", i)
+			}
+			if ru.StripPrefix && ru.PathPrefix == "" {
+				return fmt.Errorf("routing.rules[%d].strip_prefix needs a path_prefix", i)
+			}
+			if wild, ok := strings.CutPrefix(ru.Host, "*"); strings.Contains(wild, "*") || ok && !strings.HasPrefix(wild, ".") {
+				return fmt.Errorf("routing.rules[%d].host: wildcards must look like *.example.com", i)
+			}
+			for j, hm := range ru.Headers {
+				if hm.Name == "" {
+					return fmt.Errorf("routing.rules[%d].headers[%d].name is required", i, j)
+				}
+				if (hm.Value == "") == (hm.Regex == "") {
+					return fmt.Errorf("routing.rules[%d].headers[%d] needs exactly one of value and regex", i, j)
+				}
+				if _, err := regexp.Compile(hm.Regex); err != nil {
+					return fmt.Errorf("routing.rules[%d].headers[%d].regex: %w", i, j, err)
+				}
+			}
+			if ru.Pool != "" && ru.Pool != "default" && !slices.Contains(pools, ru.Pool) {
+				return fmt.Errorf("routing.rules[%d]: no backend is in pool %q", i, ru.Pool)
+			}
+			if err := validateFallback(fmt.Sprintf("routing.rules[%d]", i), ru.Pool, ru.FallbackPool, pools); err != nil {
+				return err
+			}
+		}
+		for i, pr := range rc.Paths {
+			if !strings.HasPrefix(pr.PathPrefix, "/") {
+				return fmt.Errorf("routing.paths[%d].path_prefix must start with /", i)
+			}
+			if wild, ok := strings.CutPrefix(pr.Host, "*"); strings.Contains(wild, "*") || ok && !strings.HasPrefix(wild, ".") {
+				return fmt.Errorf("routing.paths[%d].host: wildcards must look like *.example.com", i)
+			}
+			if pr.Pool != "" && pr.Pool != "default" && !slices.Contains(pools, pr.Pool) {
+				return fmt.Errorf("routing.paths[%d]: no backend is in pool %q", i, pr.Pool)
+			}
+			if err := validateFallback(fmt.Sprintf("routing.paths[%d]", i), pr.Pool, pr.FallbackPool, pools); err != nil {
+				return err
+			}
+		}
+		switch rc.NoHost {
+		case "", "default", "reject":
+		default:
+			return fmt.Errorf("routing.no_host must be default or reject")
+		}
+		switch rc.NoMatch {
+		case "", "default", "404":
+		default:
+			return fmt.Errorf("routing.no_match must be default or 404")
+		}
+		for pool := range rc.PoolMiddleware {
+			if pool != "default" && !slices.Contains(pools, pool) {
+				return fmt.Errorf("routing.pool_middleware: no backend is in pool %q", pool)
+			}
+		}
+		for _, ml := range rc.middleware() {
+			for i, mw := range ml.list {
+				if err := mw.validate(fmt.Sprintf("%s[%d]", ml.field, i)); err != nil {
+					return err
+				}
+			}
+			if err := ml.checkRequestTimeout(c.Server.WriteTimeout); err != nil {
+				return err
+			}
+		}
+	}
+	if sp := c.Split; sp != nil {
+		pools := c.Pools()
+		total := 0.0
+		for pool, percent := range sp.Percent {
+			if !slices.Contains(pools, pool) {
+				return fmt.Errorf("split.percent: no backend is in pool %q", pool)
+			}
+			if percent < 0 || percent > 100 {
+				return fmt.Errorf("split.percent.%s must be between 0 and 100", pool)
+			}
+			total += percent
+		}
+		if total > 100 {
+			return fmt.Errorf("split.percent must not add up to more than 100")
+		}
+		if sp.Sticky != "" && sp.Sticky != "ip" && sp.Sticky != "cookie" && sp.Sticky != "header" {
+			return fmt.Errorf("split.sticky must be \"ip\", \"cookie\" or \"header\"")
+		}
+		if sp.Sticky == "header" && sp.StickyHeader == "" {
+			return fmt.Errorf("split.sticky \"header\" needs sticky_header")
+		}
+	}
+	if m := c.Mirror; m != nil {
+		if m.Workers < 0 || m.QueueSize < 0 || m.Timeout < 0 {
+			return fmt.Errorf("mirror workers, queue_size and timeout must not be negative")
+		}
+		for i, rule := range m.Rules {
+			if len(rule.Targets) == 0 {
+				return fmt.Errorf("mirror.rules[%d] needs at least one target", i)
+			}
+			for _, target := range rule.Targets {
+				if u, err := url.Parse(target); err != nil || u.Host == "" {
+					return fmt.Errorf("mirror.rules[%d]: invalid target %q", i, target)
+				}
+			}
+			if rule.Percent < 0 || rule.Percent > 100 {
+				return fmt.Errorf("mirror.rules[%d].percent must be between 0 and 100", i)
+			}
+			if rule.PathPrefix != "" && !strings.HasPrefix(rule.PathPrefix, "/") {
+				return fmt.Errorf("mirror.rules[%d].path_prefix must start with /", i)
+			}
+		}
+	}
+	if cc := c.Compression; cc != nil {
+		if cc.MinSize < 0 {
+			return fmt.Errorf("compression.min_size must not be negative")
+		}
+		if cc.Level < 0 || cc.Level > 9 {
+			return fmt.Errorf("compression.level must be between 1 and 9")
+		}
+	}
+	if ep := c.ErrorPages; ep != nil {
+		if ep.RetryAfter < 0 {
+			return fmt.Errorf("error_pages.retry_after must not be negative")
+		}
+		for status, page := range ep.Pages {
+			switch status {
+			case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			default:
+				return fmt.Errorf("error_pages.pages: only 400, 413, 500, 502, 503 and 504 can be customized, not %d", status)
+			}
+			if err := page.validate(fmt.Sprintf("error_pages.pages.%d", status)); err != nil {
+				return err
+			}
+		}
+	}
+	if page := c.Maintenance.Page; page != nil {
+		if err := page.validate("maintenance.page"); err != nil {
+			return err
+		}
+	}
+	if c.Kubernetes.Resync < 0 {
+		return fmt.Errorf("kubernetes.resync must not be negative")
+	}
+	if u, err := url.Parse(c.Consul.Address); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("consul.address must be an http or https URL")
+	}
+	switch c.Consul.Health {
+	case "both", "consul", "nexus":
+	default:
+		return fmt.Errorf("consul.health must be \"both\", \"consul\" or \"nexus\"")
+	}
+	for i, e := range c.Etcd.Endpoints {
+		if u, err := url.Parse(e); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("etcd.endpoints[%d] must be an http or https URL", i)
+		}
+	}
+	if (c.Etcd.CertFile == "") != (c.Etcd.KeyFile == "") {
+		return fmt.Errorf("etcd.cert_file and etcd.key_file must be set together")
+	}
+	if c.Maintenance.RetryAfter < 0 {
+		return fmt.Errorf("maintenance.retry_after must not be negative")
+	}
+	for _, cidr := range c.Maintenance.Allow {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			if _, addrErr := netip.ParseAddr(cidr); addrErr != nil {
+				return fmt.Errorf("maintenance.allow: %w", err)
+			}
+		}
+	}
+	if c.Admin.EventsSize < 1 {
+		return fmt.Errorf("admin.events_size must be at least 1")
+	}
+	switch c.Admin.Freeze.OnExpire {
+	case "apply", "discard":
+	default:
+		return fmt.Errorf("admin.freeze.on_expire must be \"apply\" or \"discard\"")
+	}
+	if sd := c.StatsD; sd != nil {
+		if sd.Address == "" {
+			return fmt.Errorf("statsd.address must not be empty")
+		}
+		if sd.SampleRate <= 0 || sd.SampleRate > 1 {
+			return fmt.Errorf("statsd.sample_rate must be in (0, 1]")
+		}
+	}
+	if a := c.AccessLog; a != nil {
+		if a.Format != "text" && a.Format != "json" {
+			return fmt.Errorf("access_log.format must be \"text\" or \"json\"")
+		}
+		if a.MaxSizeMB < 0 || a.MaxAge < 0 || a.MaxBackups < 0 || a.QueueSize < 0 {
+			return fmt.Errorf("access_log rotation and queue settings must not be negative")
+		}
+		if a.SampleEvery < 0 {
+			return fmt.Errorf("access_log.sample_every must not be negative")
+		}
+	}
+	if t := c.Tracing; t != nil {
+		u, err := url.Parse(t.OTLPEndpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("tracing.otlp_endpoint must be an http or https URL")
+		}
+		if t.SampleRatio < 0 || t.SampleRatio > 1 {
+			return fmt.Errorf("tracing.sample_ratio must be between 0 and 1")
+		}
+	}
+	if p := c.Probes; p != nil && (p.MinAliveBackends < 1 || p.ShutdownDelay < 0) {
+		return fmt.Errorf("probes.min_alive_backends must be at least 1 and shutdown_delay not negative")
+	}
+	if c.StreamShutdownTimeout < 0 || c.StreamShutdownTimeout > c.ShutdownTimeout {
+		return fmt.Errorf("stream_shutdown_timeout must be between 0 and shutdown_timeout")
+	}
+	if c.UpgradeTimeout <= 0 {
+		return fmt.Errorf("upgrade_timeout must be positive")
+	}
+	if c.Startup.MinHealthy < 0 || c.Startup.Timeout < 0 {
+		return fmt.Errorf("startup.min_healthy and startup.timeout must not be negative")
+	}
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("log.level must be debug, info, warn or error")
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		return fmt.Errorf("log.format must be text or json")
+	}
+	if c.Log.DedupWindow < 0 {
+		return fmt.Errorf("log.dedup_window must not be negative")
+	}
+	if len(c.Metrics.DurationBuckets) == 0 {
+		return fmt.Errorf("metrics.duration_buckets must not be empty")
+	}
+	names := make(map[string]bool, len(c.TCP))
+	for i := range c.TCP {
+		t := &c.TCP[i]
+		if err := t.validate(fmt.Sprintf("tcp[%d]", i)); err != nil {
+			return err
+		}
+		if names[t.Name] {
+			return fmt.Errorf("tcp[%d].name %q is used twice", i, t.Name)
+		}
+		names[t.Name] = true
+	}
+	names = make(map[string]bool, len(c.UDP))
+	for i := range c.UDP {
+		u := &c.UDP[i]
+		if err := u.validate(fmt.Sprintf("udp[%d]", i)); err != nil {
+			return err
+		}
+		if names[u.Name] {
+			return fmt.Errorf("udp[%d].name %q is used twice", i, u.Name)
+		}
+		names[u.Name] = true
+	}
+	for i, b := range c.Metrics.DurationBuckets {
+		if b <= 0 || (i > 0 && b <= c.Metrics.DurationBuckets[i-1]) {
+			return fmt.Errorf("metrics.duration_buckets must be positive and strictly increasing")
+		}
+	}
+	return nil
+}
+
+// validate rejects negative transport settings
+func (r RetryConfig) validate() error {
+	if r.MaxBodyBytes < 0 {
+		return fmt.Errorf("max_body_bytes must not be negative")
+	}
+	if r.Backoff < 0 || r.MaxBackoff < 0 || r.Budget < 0 {
+		return fmt.Errorf("backoff, max_backoff and budget must not be negative")
+	}
+	switch r.BackoffType {
+	case "fixed", "exponential":
+	default:
+		return fmt.Errorf("backoff_type must be \"fixed\" or \"exponential\"")
+	}
+	for _, code := range r.OnStatus {
+		switch code {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		default:
+			return fmt.Errorf("on_status may only list 502, 503 and 504, not %d", code)
+		}
+	}
+	return nil
+}
+
+func (t TransportConfig) validate() error {
+	if t.DialTimeout < 0 || t.KeepAlive < 0 || t.IdleConnTimeout < 0 ||
+		t.TLSHandshakeTimeout < 0 || t.ResponseHeaderTimeout < 0 ||
+		t.RequestTimeout < 0 || t.StreamIdleTimeout < 0 {
+		return fmt.Errorf("durations must not be negative")
+	}
+	if t.MaxIdleConns < 0 || t.MaxIdleConnsPerHost < 0 || t.MaxConnsPerHost < 0 {
+		return fmt.Errorf("connection limits must not be negative")
+	}
+	return nil
+}
+
+// middlewareList is a middleware list of the routing configuration and the
+// field it is set in
+type middlewareList struct {
+	field string
+	list  []MiddlewareConfig
+}
+
+// middleware returns every middleware list of the routing configuration
+func (rc *RoutingConfig) middleware() []middlewareList {
+	var all []middlewareList
+	for _, pool := range slices.Sorted(maps.Keys(rc.PoolMiddleware)) {
+		all = append(all, middlewareList{"routing.pool_middleware." + pool, rc.PoolMiddleware[pool]})
+	}
+	for i, ru := range rc.Rules {
+		all = append(all, middlewareList{fmt.Sprintf("routing.rules[%d].middleware", i), ru.Middleware})
+	}
+	for i, hr := range rc.Hosts {
+		all = append(all, middlewareList{fmt.Sprintf("routing.hosts[%d].middleware", i), hr.Middleware})
+	}
+	for i, pr := range rc.Paths {
+		all = append(all, middlewareList{fmt.Sprintf("routing.paths[%d].middleware", i), pr.Middleware})
+	}
+	return all
+}
+
+// checkRequestTimeout makes sure no request timeout of the list outlasts
+// the write timeout it runs under, that of the list's timeout middleware,
+// if any, or else write; the connection would be cut off first
+func (ml middlewareList) checkRequestTimeout(write Duration) error {
+	for _, mw := range ml.list {
+		if mw.Timeout != nil {
+			write = mw.Timeout.WriteTimeout
+		}
+	}
+	for i, mw := range ml.list {
+		if mw.RequestTimeout > 0 && write > 0 && mw.RequestTimeout > write {
+			return fmt.Errorf("%s[%d].request_timeout (%s) is longer than the write timeout (%s) of the route",
+				ml.field, i, mw.RequestTimeout.Std(), write.Std())
+		}
+	}
+	return nil
+}
+
+func (mw MiddlewareConfig) validate(field string) error {
+	set := 0
+	for _, ok := range []bool{mw.RateLimit != nil, mw.BodyLimit != nil, mw.Timeout != nil, mw.RequestTimeout != 0, mw.Headers != nil, mw.IPFilter != nil, mw.Auth != nil, mw.JWT != nil, mw.SecurityHeaders != nil, mw.CORS != nil} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("%s needs exactly one of rate_limit, body_limit, timeout, request_timeout, headers, ip_filter, auth, jwt, security_headers and cors", field)
+	}
+	switch {
+	case mw.RateLimit != nil:
+		return mw.RateLimit.validate(field + ".rate_limit")
+	case mw.BodyLimit != nil && mw.BodyLimit.MaxBytes < 0:
+		return fmt.Errorf("%s.body_limit.max_bytes must not be negative", field)
+	case mw.Timeout != nil && (mw.Timeout.ReadTimeout < 0 || mw.Timeout.WriteTimeout < 0):
+		return fmt.Errorf("%s.timeout settings must not be negative", field)
+	case mw.RequestTimeout < 0:
+		return fmt.Errorf("%s.request_timeout must not be negative", field)
+	case mw.Headers != nil:
+		return mw.Headers.validate(field + ".headers")
+	case mw.IPFilter != nil:
+		return mw.IPFilter.validate(field + ".ip_filter")
+	case mw.Auth != nil:
+		return mw.Auth.validate(field + ".auth")
+	case mw.JWT != nil:
+		return mw.JWT.validate(field + ".jwt")
+	case mw.SecurityHeaders != nil:
+		return mw.SecurityHeaders.validate(field + ".security_headers")
+	case mw.CORS != nil:
+		return mw.CORS.validate(field + ".cors")
+	}
+	return nil
+}
+
+func (c *CORSConfig) validate(field string) error {
+	if len(c.AllowedOrigins) == 0 {
+		return fmt.Errorf("%s needs allowed_origins", field)
+	}
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		scheme, host, ok := strings.Cut(origin, "://")
+		if !ok || scheme == "" || host == "" || strings.Contains(host, "/") || strings.Count(origin, "*") > 1 {
+			return fmt.Errorf("%s.allowed_origins: %q is not an origin such as https://app.example.com or https://*.example.com", field, origin)
+		}
+	}
+	for _, m := range c.AllowedMethods {
+		if m == "" || m != strings.ToUpper(m) || strings.ContainsAny(m, " ,") {
+			return fmt.Errorf("%s.allowed_methods: invalid method %q", field, m)
+		}
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("%s.max_age must not be negative", field)
+	}
+	return nil
+}
+
+func (sh *SecurityHeadersConfig) validate(field string) error {
+	if sh.HSTS != nil && sh.HSTS.MaxAge < 0 {
+		return fmt.Errorf("%s.hsts.max_age must not be negative", field)
+	}
+	if sh.FrameOptions != "" && sh.FrameOptions != "DENY" && sh.FrameOptions != "SAMEORIGIN" {
+		return fmt.Errorf("%s.frame_options must be \"DENY\" or \"SAMEORIGIN\"", field)
+	}
+	if strings.ContainsAny(sh.ContentSecurityPolicy, "\r\n") {
+		return fmt.Errorf("%s.content_security_policy must be a single line", field)
+	}
+	return nil
+}
+
+func (t *TLSConfig) validate() error {
+	for _, v := range []struct{ field, version string }{{"min_version", t.MinVersion}, {"max_version", t.MaxVersion}} {
+		if _, ok := tlsVersions[v.version]; v.version != "" && !ok {
+			return fmt.Errorf("tls.%s must be \"1.0\", \"1.1\", \"1.2\" or \"1.3\"", v.field)
+		}
+	}
+	if lo, hi := t.Versions(); hi != 0 && lo > hi {
+		return fmt.Errorf("tls.min_version must not be above max_version")
+	}
+	for _, name := range t.CipherSuites {
+		if err := checkCipherSuite(name); err != nil {
+			return fmt.Errorf("tls.cipher_suites: %w", err)
+		}
+	}
+	if len(t.CipherSuites) > 0 && t.MinVersion == "1.3" {
+		return fmt.Errorf("tls.cipher_suites has no effect with min_version 1.3")
+	}
+	if t.ClientAuth != "" && t.ClientAuth != "require" && t.ClientAuth != "verify" {
+		return fmt.Errorf("tls.client_auth must be \"require\" or \"verify\"")
+	}
+	if (t.ClientAuth != "" || t.ForwardClientCert) && t.ClientCAFile == "" {
+		return fmt.Errorf("tls.client_auth and forward_client_cert need client_ca_file")
+	}
+	if t.ClientCAFile != "" && t.Listen == "" {
+		return fmt.Errorf("tls.client_ca_file needs tls.listen")
+	}
+	if h3 := t.HTTP3; h3 != nil {
+		if t.Listen == "" {
+			return fmt.Errorf("tls.http3 needs tls.listen")
+		}
+		if _, _, err := net.SplitHostPort(h3.Listen); err != nil {
+			return fmt.Errorf("tls.http3.listen: %w", err)
+		}
+		if t.MaxVersion != "" && t.MaxVersion != "1.3" {
+			return fmt.Errorf("tls.http3 needs TLS 1.3, which max_version %s excludes", t.MaxVersion)
+		}
+		if h3.AltSvcMaxAge <= 0 {
+			return fmt.Errorf("tls.http3.alt_svc_max_age must be positive")
+		}
+	}
+	return nil
+}
+
+// checkCipherSuite makes sure name is a cipher suite that can be chosen
+func checkCipherSuite(name string) error {
+	for _, cs := range tls.CipherSuites() {
+		if cs.Name != name {
+			continue
+		}
+		if !slices.ContainsFunc(cs.SupportedVersions, func(v uint16) bool { return v < tls.VersionTLS13 }) {
+			return fmt.Errorf("%s is a TLS 1.3 suite, which cannot be chosen", name)
+		}
+		return nil
+	}
+	for _, cs := range tls.InsecureCipherSuites() {
+		if cs.Name == name {
+			return fmt.Errorf("%s is insecure", name)
+		}
+	}
+	return fmt.Errorf("unknown cipher suite %q", name)
+}
+
+func (j *JWTConfig) validate(field string) error {
+	if (j.JWKSURL == "") == (j.PublicKeyFile == "") {
+		return fmt.Errorf("%s needs exactly one of jwks_url and public_key_file", field)
+	}
+	if j.JWKSURL != "" {
+		u, err := url.Parse(j.JWKSURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s.jwks_url must be an http or https URL", field)
+		}
+	}
+	if j.Issuer == "" || j.Audience == "" {
+		return fmt.Errorf("%s needs issuer and audience", field)
+	}
+	if j.JWKSRefresh < 0 || j.ClockSkew < 0 {
+		return fmt.Errorf("%s.jwks_refresh and clock_skew must not be negative", field)
+	}
+	for claim, header := range j.ForwardClaims {
+		if claim == "" || header == "" || strings.ContainsAny(header, " \t\r\n:") {
+			return fmt.Errorf("%s.forward_claims needs a claim and a valid header name for every entry", field)
+		}
+	}
+	return nil
+}
+
+func (a *AuthConfig) validate(field string) error {
+	if a.HtpasswdFile == "" && len(a.APIKeys) == 0 {
+		return fmt.Errorf("%s needs htpasswd_file, api_keys or both", field)
+	}
+	for name, key := range a.APIKeys {
+		if name == "" || key == "" {
+			return fmt.Errorf("%s.api_keys needs a name and a key for every entry", field)
+		}
+	}
+	return nil
+}
+
+func (f *IPFilterConfig) validate(field string) error {
+	for _, list := range []struct {
+		name  string
+		cidrs []string
+	}{{"allow", f.Allow}, {"deny", f.Deny}} {
+		for _, cidr := range list.cidrs {
+			if _, err := netip.ParsePrefix(cidr); err != nil {
+				if _, addrErr := netip.ParseAddr(cidr); addrErr != nil {
+					return fmt.Errorf("%s.%s: %w", field, list.name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (sh *SecurityHeadersConfig) applyDefaults() {
+	if sh.HSTS != nil && sh.HSTS.MaxAge == 0 {
+		sh.HSTS.MaxAge = Duration(365 * 24 * time.Hour)
+	}
+}
+
+func (rl *RateLimitConfig) applyDefaults() {
+	if rl.Burst == 0 {
+		rl.Burst = int(math.Ceil(rl.Rate))
+	}
+	if rl.MaxClients == 0 {
+		rl.MaxClients = 10000
+	}
+}
+
+func (rl *RateLimitConfig) validate(field string) error {
+	if rl.Rate <= 0 {
+		return fmt.Errorf("%s.rate must be positive", field)
+	}
+	if rl.Burst < 1 || rl.MaxClients < 0 {
+		return fmt.Errorf("%s.burst must be positive and max_clients not negative", field)
+	}
+	for _, cidr := range rl.Exempt {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			if _, addrErr := netip.ParseAddr(cidr); addrErr != nil {
+				return fmt.Errorf("%s.exempt: %w", field, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateFallback checks the fallback pool of a route to pool
+func validateFallback(field, pool, fallback string, pools []string) error {
+	switch {
+	case fallback == "":
+		return nil
+	case fallback == "default":
+		return fmt.Errorf("%s.fallback_pool cannot be the default pool", field)
+	case !slices.Contains(pools, fallback):
+		return fmt.Errorf("%s.fallback_pool: no backend is in pool %q", field, fallback)
+	case fallback == pool:
+		return fmt.Errorf("%s.fallback_pool is the route's own pool", field)
+	}
+	return nil
+}
+
+func (p *ErrorPageConfig) validate(field string) error {
+	if p.JSON != "" && p.JSONFile != "" {
+		return fmt.Errorf("%s: json and json_file are mutually exclusive", field)
+	}
+	if p.HTMLFile == "" && p.JSON == "" && p.JSONFile == "" {
+		return fmt.Errorf("%s needs html_file, json or json_file", field)
+	}
+	return nil
+}
+
+func (h *HeaderRuleConfig) validate(field string) error {
+	if h.Request == nil && h.Response == nil {
+		return fmt.Errorf("%s needs request or response changes", field)
+	}
+	for _, ops := range []*HeaderOpsConfig{h.Request, h.Response} {
+		if ops == nil {
+			continue
+		}
+		for _, name := range ops.Remove {
+			if name == "" || name == "*" || strings.Contains(strings.TrimSuffix(name, "*"), "*") {
+				return fmt.Errorf("%s: invalid header name %q in remove", field, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Duration is a time.Duration that reads and writes as a string like "10s"
+type Duration time.Duration
+
+// Std returns the value as a time.Duration
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+// MarshalJSON encodes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON decodes a duration from a string such as "1m30s"
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// TCPProxyConfig is a listener whose connections are each spliced to one of
+// its backends, for protocols such as PostgreSQL or Redis
+type TCPProxyConfig struct {
+	// Name identifies the listener in logs and metrics
+	Name   string `json:"name"`
+	Listen string `json:"listen"`
+	// Backends are host:port addresses; with SNI, those of TLS connections
+	// no route serves
+	Backends []string `json:"backends,omitempty"`
+	// Balance is "round_robin" (default) or "least_conn", which picks the
+	// backend with the fewest open connections
+	Balance string `json:"balance"`
+	// DialTimeout bounds connecting to a backend (default 5s)
+	DialTimeout Duration `json:"dial_timeout"`
+	// IdleTimeout closes connections idle both ways for that long (default
+	// 1h); negative for none
+	IdleTimeout Duration `json:"idle_timeout"`
+	// MaxConnections caps the connections proxied at once (0 for no limit)
+	MaxConnections int `json:"max_connections,omitempty"`
+	// DrainTimeout is how long open connections may carry on at shutdown
+	// before they are closed (default 30s)
+	DrainTimeout Duration        `json:"drain_timeout"`
+	HealthCheck  TCPHealthConfig `json:"health_check"`
+	// SNI, if set, passes TLS connections through, still encrypted, to the
+	// backends of the route matching the server name of their ClientHello
+	SNI *SNIConfig `json:"sni,omitempty"`
+}
+
+// SNIConfig routes TLS connections by server name. Connections without a
+// server name, or one no route matches, go to the listener's backends, and
+// are closed if it has none.
+type SNIConfig struct {
+	Routes []SNIRouteConfig `json:"routes"`
+	// HelloTimeout bounds waiting for the ClientHello (default 5s)
+	HelloTimeout Duration `json:"hello_timeout"`
+}
+
+// SNIRouteConfig sends the connections asking for one of ServerNames,
+// such as "db.example.com" or "*.example.com" for one label, to Backends
+type SNIRouteConfig struct {
+	ServerNames []string `json:"server_names"`
+	Backends    []string `json:"backends"`
+}
+
+// TCPHealthConfig checks the backends of a TCP listener. Without Send or
+// Expect, a backend is healthy while it accepts connections.
+type TCPHealthConfig struct {
+	// Interval and Timeout default to those of health_check
+	Interval Duration `json:"interval"`
+	Timeout  Duration `json:"timeout"`
+	// Send is written to the backend once connected, such as "PING\r\n"
+	Send string `json:"send,omitempty"`
+	// Expect must appear in what the backend then answers, such as "+PONG"
+	Expect string `json:"expect,omitempty"`
+}
+
+func (t *TCPProxyConfig) applyDefaults(hc HealthCheckConfig) {
+	if t.Balance == "" {
+		t.Balance = "round_robin"
+	}
+	if t.DialTimeout == 0 {
+		t.DialTimeout = Duration(5 * time.Second)
+	}
+	if t.IdleTimeout == 0 {
+		t.IdleTimeout = Duration(time.Hour)
+	}
+	if t.DrainTimeout == 0 {
+		t.DrainTimeout = Duration(30 * time.Second)
+	}
+	if t.HealthCheck.Interval == 0 {
+		t.HealthCheck.Interval = hc.Interval
+	}
+	if t.HealthCheck.Timeout == 0 {
+		t.HealthCheck.Timeout = hc.Timeout
+	}
+	if t.SNI != nil && t.SNI.HelloTimeout == 0 {
+		t.SNI.HelloTimeout = Duration(5 * time.Second)
+	}
+}
+
+func (t *TCPProxyConfig) validate(field string) error {
+	if t.Name == "" {
+		return fmt.Errorf("%s needs a name", field)
+	}
+	if _, _, err := net.SplitHostPort(t.Listen); err != nil {
+		return fmt.Errorf("%s.listen: %w", field, err)
+	}
+	if len(t.Backends) == 0 && t.SNI == nil {
+		return fmt.Errorf("%s needs at least one backend", field)
+	}
+	if err := validateTCPBackends(field+".backends", t.Backends); err != nil {
+		return err
+	}
+	if sni := t.SNI; sni != nil {
+		if len(sni.Routes) == 0 {
+			return fmt.Errorf("%s.sni needs at least one route", field)
+		}
+		if sni.HelloTimeout <= 0 {
+			return fmt.Errorf("%s.sni.hello_timeout must be positive", field)
+		}
+		for j, r := range sni.Routes {
+			rf := fmt.Sprintf("%s.sni.routes[%d]", field, j)
+			if len(r.ServerNames) == 0 || len(r.Backends) == 0 {
+				return fmt.Errorf("%s needs server_names and backends", rf)
+			}
+			for _, name := range r.ServerNames {
+				if rest, _ := strings.CutPrefix(name, "*."); rest == "" || strings.ContainsAny(rest, "*/: ") {
+					return fmt.Errorf("%s.server_names: %q is not a host name or *.domain wildcard", rf, name)
+				}
+			}
+			if err := validateTCPBackends(rf+".backends", r.Backends); err != nil {
+				return err
+			}
+		}
+	}
+	if t.Balance != "round_robin" && t.Balance != "least_conn" {
+		return fmt.Errorf("%s.balance must be round_robin or least_conn", field)
+	}
+	if t.DialTimeout <= 0 || t.DrainTimeout <= 0 {
+		return fmt.Errorf("%s.dial_timeout and drain_timeout must be positive", field)
+	}
+	if t.MaxConnections < 0 {
+		return fmt.Errorf("%s.max_connections must not be negative", field)
+	}
+	if t.HealthCheck.Interval <= 0 || t.HealthCheck.Timeout <= 0 {
+		return fmt.Errorf("%s.health_check.interval and timeout must be positive", field)
+	}
+	return nil
+}
+
+// UDPProxyConfig is a listener forwarding each client's datagrams to one of
+// its backends, picked by a hash of the client IP
+type UDPProxyConfig struct {
+	// Name identifies the listener in logs and metrics
+	Name     string   `json:"name"`
+	Listen   string   `json:"listen"`
+	Backends []string `json:"backends"`
+	// SessionTimeout forgets a client, and the socket its datagrams go out
+	// through, once nothing went either way for that long (default 60s)
+	SessionTimeout Duration `json:"session_timeout"`
+	// MaxDatagramBytes is the largest datagram forwarded either way; larger
+	// ones are dropped and counted (default 65507, the most IPv4 carries)
+	MaxDatagramBytes int             `json:"max_datagram_bytes"`
+	HealthCheck      UDPHealthConfig `json:"health_check"`
+}
+
+// UDPHealthConfig checks the backends of a UDP listener. Without Send, a
+// backend is only taken out of rotation when its host answers with an ICMP
+// port unreachable, and tried again an interval later.
+type UDPHealthConfig struct {
+	// Interval and Timeout default to those of health_check
+	Interval Duration `json:"interval"`
+	Timeout  Duration `json:"timeout"`
+	// Send is a heartbeat datagram the backend must answer within the
+	// timeout
+	Send string `json:"send,omitempty"`
+	// Expect, if set, must appear in that answer
+	Expect string `json:"expect,omitempty"`
+}
+
+func (u *UDPProxyConfig) applyDefaults(hc HealthCheckConfig) {
+	if u.SessionTimeout == 0 {
+		u.SessionTimeout = Duration(time.Minute)
+	}
+	if u.MaxDatagramBytes == 0 {
+		u.MaxDatagramBytes = 65507
+	}
+	if u.HealthCheck.Interval == 0 {
+		u.HealthCheck.Interval = hc.Interval
+	}
+	if u.HealthCheck.Timeout == 0 {
+		u.HealthCheck.Timeout = hc.Timeout
+	}
+}
+
+func (u *UDPProxyConfig) validate(field string) error {
+	if u.Name == "" {
+		return fmt.Errorf("%s needs a name", field)
+	}
+	if _, _, err := net.SplitHostPort(u.Listen); err != nil {
+		return fmt.Errorf("%s.listen: %w", field, err)
+	}
+	if len(u.Backends) == 0 {
+		return fmt.Errorf("%s needs at least one backend", field)
+	}
+	if err := validateTCPBackends(field+".backends", u.Backends); err != nil {
+		return err
+	}
+	if u.SessionTimeout <= 0 {
+		return fmt.Errorf("%s.session_timeout must be positive", field)
+	}
+	if u.MaxDatagramBytes < 1 || u.MaxDatagramBytes > 65535 {
+		return fmt.Errorf("%s.max_datagram_bytes must be between 1 and 65535", field)
+	}
+	if u.HealthCheck.Expect != "" && u.HealthCheck.Send == "" {
+		return fmt.Errorf("%s.health_check.expect requires send", field)
+	}
+	if u.HealthCheck.Interval <= 0 || u.HealthCheck.Timeout <= 0 {
+		return fmt.Errorf("%s.health_check.interval and timeout must be positive", field)
+	}
+	return nil
+}
+
+// validateTCPBackends checks that each of addrs is host:port, as TCP and
+// UDP backends are given
+func validateTCPBackends(field string, addrs []string) error {
+	for j, addr := range addrs {
+		if host, port, err := net.SplitHostPort(addr); err != nil || host == "" || port == "" {
+			return fmt.Errorf("%s[%d] must be host:port", field, j)
+		}
+	}
+	return nil
+}
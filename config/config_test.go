@@ -0,0 +1,79 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// load writes a configuration with a listener and one backend, plus the
+// given top-level fields, and loads it
+func load(t *testing.T, fields string) (*Config, error) {
+	t.Helper()
+	body := `{"listen": ":8080", "backends": [{"url": "http://127.0.0.1:8081"}]`
+	if fields != "" {
+		body += ", " + fields
+	}
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(body+"}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestLoadMinimal(t *testing.T) {
+	cfg, err := load(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Admin.Address != "127.0.0.1:8001" || cfg.Admin.AllowRemoteAdmin {
+		t.Errorf("admin defaults = %+v", cfg.Admin)
+	}
+}
+
+func TestRemoteAdminNeedsCredentials(t *testing.T) {
+	tests := []struct {
+		name    string
+		admin   string
+		wantErr string
+	}{
+		{"no auth", `{"allow_remote_admin": true}`, "allow_remote_admin requires"},
+		{"allow_cidrs only", `{"allow_remote_admin": true, "auth": {"allow_cidrs": ["0.0.0.0/0"]}}`, "allow_remote_admin requires"},
+		{"token", `{"allow_remote_admin": true, "auth": {"token": "s3cret"}}`, ""},
+		{"basic auth", `{"allow_remote_admin": true, "auth": {"username": "ops", "password": "pw"}}`, ""},
+		{"admin.token", `{"allow_remote_admin": true, "token": "s3cret"}`, ""},
+		{"admin.token and networks", `{"allow_remote_admin": true, "token": "s3cret", "auth": {"allow_cidrs": ["10.0.0.0/8"]}}`, ""},
+		{"two tokens", `{"token": "a", "auth": {"token": "b"}}`, "cannot be combined"},
+		{"credentials and networks", `{"allow_remote_admin": true, "auth": {"token": "s3cret", "allow_cidrs": ["10.0.0.0/8"]}}`, ""},
+		{"local with allow_cidrs only", `{"auth": {"allow_cidrs": ["127.0.0.1/32"]}}`, ""},
+		{"username without password", `{"auth": {"username": "ops"}}`, "both username and password"},
+		{"empty auth", `{"auth": {}}`, "needs a token"},
+		{"bad network", `{"auth": {"token": "t", "allow_cidrs": ["10.0.0.0/33"]}}`, "allow_cidrs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(t, `"admin": `+tt.admin)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAdminSecretFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := load(t, `"admin": {"allow_remote_admin": true, "auth": {"token_file": "`+path+`"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Admin.Auth.Token != "from-file" {
+		t.Errorf("token %q, want from-file", cfg.Admin.Auth.Token)
+	}
+}
@@ -1,3 +1,13 @@
 module github.com/nexus-lb/nexus
 
 go 1.25.4
+
+require (
+	golang.org/x/crypto v0.48.0
+	golang.org/x/net v0.50.0
+)
+
+require (
+	golang.org/x/sys v0.41.0 // indirect
+	golang.org/x/text v0.34.0 // indirect
+)
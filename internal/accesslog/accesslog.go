@@ -0,0 +1,187 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Formats supported by the access log
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Entry describes one completed request
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	Proto    string    `json:"proto"`
+	ClientIP string    `json:"client_ip"`
+	Backend  string    `json:"backend,omitempty"`
+	Route    string    `json:"route,omitempty"`
+	Fallback string    `json:"fallback_pool,omitempty"`
+	// User is who the client authenticated as on the route, if it had to
+	User string `json:"user,omitempty"`
+	// ClientCert is the subject of the certificate the client presented
+	// over TLS, if any
+	ClientCert string        `json:"client_cert,omitempty"`
+	Status     int           `json:"status"`
+	Attempts   int           `json:"attempts"`
+	Bytes      int64         `json:"bytes"`
+	Duration   time.Duration `json:"-"`
+}
+
+// jsonEntry adds the duration in milliseconds, which is easier to query
+// than nanoseconds
+type jsonEntry struct {
+	Entry
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// Options configures an access logger
+type Options struct {
+	// Output is "stdout", "stderr" or a file path, which is appended to
+	Output string
+	// Format is FormatText or FormatJSON
+	Format string
+	// MaxSize (bytes) and MaxAge trigger rotation of a log file; MaxBackups
+	// rotated files are kept (all of them if zero)
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+	// QueueSize bounds the lines waiting to be written (default 8192)
+	QueueSize int
+}
+
+// Logger writes one line per request in text or JSON format, separately
+// from Nexus's operational log. Lines are written in the background; when
+// output cannot keep up they are dropped rather than slowing requests.
+type Logger struct {
+	format string
+	writer *asyncWriter
+	file   *RotatingFile
+}
+
+// New creates an access logger
+func New(opts Options) (*Logger, error) {
+	if opts.Format != FormatText && opts.Format != FormatJSON {
+		return nil, fmt.Errorf("unknown access log format %q", opts.Format)
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 8192
+	}
+
+	l := &Logger{format: opts.Format}
+	var out io.Writer
+	switch opts.Output {
+	case "", "stdout":
+		out = os.Stdout
+	case "stderr":
+		out = os.Stderr
+	default:
+		f, err := OpenRotatingFile(opts.Output, opts.MaxSize, opts.MaxAge, opts.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		out, l.file = f, f
+	}
+	l.writer = newAsyncWriter(out, opts.QueueSize)
+	return l, nil
+}
+
+// Log queues an entry for writing. A nil *Logger discards it.
+func (l *Logger) Log(e Entry) {
+	if l == nil {
+		return
+	}
+
+	var line []byte
+	if l.format == FormatJSON {
+		line, _ = json.Marshal(jsonEntry{Entry: e, DurationMs: float64(e.Duration) / float64(time.Millisecond)})
+		line = append(line, '\n')
+	} else {
+		line = formatText(e)
+	}
+	l.writer.write(line)
+}
+
+// Dropped returns how many lines were dropped because output fell behind
+func (l *Logger) Dropped() uint64 {
+	if l == nil {
+		return 0
+	}
+	return l.writer.dropped.Load()
+}
+
+// Reopen reopens the log file after it has been moved by an external tool.
+// It does nothing when logging to stdout or stderr.
+func (l *Logger) Reopen() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	return l.file.Reopen()
+}
+
+// Close flushes queued lines and closes the log file, if any
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.writer.close()
+	if l.file != nil {
+		return l.file.Close()
+	}
+	return nil
+}
+
+// formatText renders an entry in an extended Common Log Format:
+// client - user [time] "METHOD path proto" status bytes backend attempts
+// duration, followed by the route if the request took one and the subject
+// of the client certificate if there was one
+func formatText(e Entry) []byte {
+	backend := e.Backend
+	if backend == "" {
+		backend = "-"
+	}
+	b := make([]byte, 0, 160)
+	user := e.User
+	if user == "" {
+		user = "-"
+	}
+	b = append(b, e.ClientIP...)
+	b = append(b, " - "...)
+	b = append(b, user...)
+	b = append(b, " ["...)
+	b = e.Time.AppendFormat(b, "02/Jan/2006:15:04:05 -0700")
+	b = append(b, "] "...)
+	b = strconv.AppendQuote(b, e.Method+" "+e.Path+" "+e.Proto)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, int64(e.Status), 10)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, e.Bytes, 10)
+	b = append(b, " backend="...)
+	b = append(b, backend...)
+	b = append(b, " attempts="...)
+	b = strconv.AppendInt(b, int64(e.Attempts), 10)
+	b = append(b, " duration="...)
+	b = append(b, e.Duration.String()...)
+	if e.Route != "" {
+		b = append(b, " route="...)
+		b = append(b, e.Route...)
+	}
+	if e.Fallback != "" {
+		b = append(b, " fallback="...)
+		b = append(b, e.Fallback...)
+	}
+	if e.ClientCert != "" {
+		b = append(b, " client_cert="...)
+		b = strconv.AppendQuote(b, e.ClientCert)
+	}
+	b = append(b, '\n')
+	return b
+}
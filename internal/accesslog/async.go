@@ -0,0 +1,80 @@
+package accesslog
+
+import (
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// asyncWriter hands lines to a background goroutine through a bounded
+// queue so slow output never delays a request. Lines that do not fit in the
+// queue are dropped and counted.
+type asyncWriter struct {
+	out   io.Writer
+	mu    sync.RWMutex // guards closed against writes racing close
+	lines chan []byte
+	done  chan struct{}
+
+	closed       bool
+	dropped      atomic.Uint64
+	lastErrorLog atomic.Int64
+}
+
+// newAsyncWriter starts a writer with room for queueSize pending lines
+func newAsyncWriter(out io.Writer, queueSize int) *asyncWriter {
+	w := &asyncWriter{
+		out:   out,
+		lines: make(chan []byte, queueSize),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// write queues a line, dropping it if the queue is full
+func (w *asyncWriter) write(line []byte) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		w.dropped.Add(1)
+		return
+	}
+	select {
+	case w.lines <- line:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// run writes queued lines until the queue is closed
+func (w *asyncWriter) run() {
+	defer close(w.done)
+	for line := range w.lines {
+		if _, err := w.out.Write(line); err != nil {
+			w.logError(err)
+		}
+	}
+}
+
+// logError logs a write failure at most once a minute
+func (w *asyncWriter) logError(err error) {
+	now := time.Now().UnixNano()
+	last := w.lastErrorLog.Load()
+	if now-last < int64(time.Minute) || !w.lastErrorLog.CompareAndSwap(last, now) {
+		return
+	}
+	slog.Warn("failed to write access log", "component", "accesslog", "error", err, "suppressed_for", time.Minute)
+}
+
+// close writes the remaining queued lines and stops the writer
+func (w *asyncWriter) close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.lines)
+	}
+	w.mu.Unlock()
+	<-w.done
+}
@@ -0,0 +1,69 @@
+package accesslog
+
+import (
+	"hash/fnv"
+	"sync/atomic"
+)
+
+// Sampler decides which successful requests are logged: one in every N.
+// Errors, retries and 5xx responses are always logged. The rate can be
+// changed at runtime; the zero value and a nil Sampler log everything.
+type Sampler struct {
+	every   atomic.Int64
+	counter atomic.Uint64
+	skipped atomic.Uint64
+}
+
+// NewSampler creates a sampler logging one in every n successful requests
+func NewSampler(n int) *Sampler {
+	s := &Sampler{}
+	s.SetEvery(n)
+	return s
+}
+
+// SetEvery changes the rate to one in every n requests; n <= 1 logs all
+func (s *Sampler) SetEvery(n int) {
+	s.every.Store(int64(max(n, 1)))
+}
+
+// Every returns the current sampling rate
+func (s *Sampler) Every() int {
+	return int(max(s.every.Load(), 1))
+}
+
+// Sample decides once per request whether its lines are logged. Requests
+// carrying an ID are sampled by a hash of it, so the decision is the same
+// wherever the ID is seen; others are sampled by arrival order.
+func (s *Sampler) Sample(requestID string) bool {
+	if s == nil {
+		return true
+	}
+	n := uint64(s.Every())
+	if n == 1 {
+		return true
+	}
+	var v uint64
+	if requestID != "" {
+		h := fnv.New64a()
+		h.Write([]byte(requestID))
+		v = h.Sum64()
+	} else {
+		v = s.counter.Add(1)
+	}
+	return v%n == 0
+}
+
+// Keep reports whether a finished request is logged: always for retries and
+// server errors, otherwise according to the sampling decision
+func (s *Sampler) Keep(sampled bool, status, attempts int) bool {
+	if s == nil || sampled || status >= 500 || attempts > 1 {
+		return true
+	}
+	s.skipped.Add(1)
+	return false
+}
+
+// Skipped returns how many requests were not logged because of sampling
+func (s *Sampler) Skipped() uint64 {
+	return s.skipped.Load()
+}
@@ -0,0 +1,106 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/nexus-lb/nexus/config"
+)
+
+// authenticator guards the whole admin listener with the admin.auth
+// settings, or the admin.token standing for admin.auth.token
+type authenticator struct {
+	token    string
+	username string
+	password string
+	allowed  []netip.Prefix
+}
+
+// newAuthenticator prepares the admin.auth settings and admin.token; the
+// configuration has already been validated, so at most one of the tokens is
+// set
+func newAuthenticator(c config.AdminConfig) *authenticator {
+	auth := &authenticator{token: c.Token}
+	a := c.Auth
+	if a == nil {
+		return auth
+	}
+	if a.Token != "" {
+		auth.token = a.Token
+	}
+	auth.username, auth.password = a.Username, a.Password
+	for _, cidr := range a.AllowCIDRs {
+		auth.allowed = append(auth.allowed, netip.MustParsePrefix(cidr).Masked())
+	}
+	return auth
+}
+
+// wrap rejects requests from outside the allowed networks with 403 and
+// requests without valid credentials with 401
+func (a *authenticator) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.allowedSource(r.RemoteAddr) {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		if !a.authorized(r) {
+			if a.token != "" {
+				w.Header().Add("WWW-Authenticate", `Bearer realm="nexus-admin"`)
+			}
+			if a.username != "" {
+				w.Header().Add("WWW-Authenticate", `Basic realm="nexus-admin", charset="UTF-8"`)
+			}
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedSource reports whether the client address is in an allowed network
+func (a *authenticator) allowedSource(remoteAddr string) bool {
+	if len(a.allowed) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, p := range a.allowed {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorized reports whether the request carries valid credentials, or no
+// credentials are configured
+func (a *authenticator) authorized(r *http.Request) bool {
+	if a.token == "" && a.username == "" {
+		return true
+	}
+	if given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && a.token != "" {
+		return secretEqual(given, a.token)
+	}
+	if user, pass, ok := r.BasicAuth(); ok && a.username != "" {
+		// Compare both so timing does not reveal which one was wrong
+		userOK := secretEqual(user, a.username)
+		passOK := secretEqual(pass, a.password)
+		return userOK && passOK
+	}
+	return false
+}
+
+// secretEqual compares two secrets in constant time
+func secretEqual(given, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(want)) == 1
+}
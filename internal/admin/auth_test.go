@@ -0,0 +1,102 @@
+package admin
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/nexus-lb/nexus/config"
+)
+
+// protected are admin endpoints of each kind: status, metrics, debug,
+// listings, the change journal and mutations
+var protected = []struct{ method, path string }{
+	{http.MethodGet, "/nexus/status"},
+	{http.MethodGet, "/metrics"},
+	{http.MethodGet, "/debug/vars"},
+	{http.MethodGet, "/debug/runtime"},
+	{http.MethodGet, "/nexus/backends"},
+	{http.MethodGet, "/admin/changes"},
+	{http.MethodGet, "/admin/freeze"},
+	{http.MethodPost, "/admin/freeze"},
+	{http.MethodPost, "/nexus/backends"},
+}
+
+func TestTokenProtectsEveryEndpoint(t *testing.T) {
+	for _, name := range []string{"admin.token", "admin.auth.token"} {
+		t.Run(name, func(t *testing.T) {
+			s := newTestServer(t, func(a *config.AdminConfig) {
+				if name == "admin.token" {
+					a.Token = "s3cret"
+				} else {
+					a.Auth = &config.AdminAuthConfig{Token: "s3cret"}
+				}
+			})
+			for _, e := range protected {
+				if code := do(s, e.method, e.path, "127.0.0.1:4000", nil); code != http.StatusUnauthorized {
+					t.Errorf("%s %s without the token: status %d, want 401", e.method, e.path, code)
+				}
+				wrong := http.Header{"Authorization": {"Bearer wrong"}}
+				if code := do(s, e.method, e.path, "127.0.0.1:4000", wrong); code != http.StatusUnauthorized {
+					t.Errorf("%s %s with a wrong token: status %d, want 401", e.method, e.path, code)
+				}
+			}
+			right := http.Header{"Authorization": {"Bearer s3cret"}}
+			if code := do(s, http.MethodGet, "/nexus/backends", "127.0.0.1:4000", right); code != http.StatusOK {
+				t.Errorf("with the token: status %d, want 200", code)
+			}
+			if code := do(s, http.MethodPost, "/admin/freeze", "127.0.0.1:4000", right); code != http.StatusOK {
+				t.Errorf("mutation with the token: status %d, want 200", code)
+			}
+		})
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	s := newTestServer(t, func(a *config.AdminConfig) {
+		a.Auth = &config.AdminAuthConfig{Username: "ops", Password: "pw"}
+	})
+	tests := []struct {
+		user, pass string
+		want       int
+	}{
+		{"ops", "pw", http.StatusOK},
+		{"ops", "nope", http.StatusUnauthorized},
+		{"root", "pw", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		h := http.Header{"Authorization": {"Basic " + base64.StdEncoding.EncodeToString([]byte(tt.user+":"+tt.pass))}}
+		if code := do(s, http.MethodGet, "/nexus/backends", "127.0.0.1:4000", h); code != tt.want {
+			t.Errorf("%s:%s: status %d, want %d", tt.user, tt.pass, code, tt.want)
+		}
+	}
+	// A bearer token is not accepted when only basic auth is configured
+	if code := do(s, http.MethodGet, "/nexus/backends", "127.0.0.1:4000", http.Header{"Authorization": {"Bearer pw"}}); code != http.StatusUnauthorized {
+		t.Errorf("bearer without a token configured: status %d, want 401", code)
+	}
+}
+
+func TestTokenWithNetworks(t *testing.T) {
+	// admin.token combines with the networks of admin.auth
+	s := newTestServer(t, func(a *config.AdminConfig) {
+		a.Token = "s3cret"
+		a.Auth = &config.AdminAuthConfig{AllowCIDRs: []string{"127.0.0.0/8"}}
+	})
+	right := http.Header{"Authorization": {"Bearer s3cret"}}
+	if code := do(s, http.MethodGet, "/nexus/status", "[::1]:4000", right); code != http.StatusForbidden {
+		t.Errorf("outside allow_cidrs: status %d, want 403", code)
+	}
+	if code := do(s, http.MethodGet, "/nexus/backends", "127.0.0.1:4000", nil); code != http.StatusUnauthorized {
+		t.Errorf("without the token: status %d, want 401", code)
+	}
+	if code := do(s, http.MethodGet, "/nexus/backends", "127.0.0.1:4000", right); code != http.StatusOK {
+		t.Errorf("with the token: status %d, want 200", code)
+	}
+}
+
+func TestNoCredentialsLocalOnly(t *testing.T) {
+	s := newTestServer(t, nil)
+	if code := do(s, http.MethodPost, "/admin/freeze", "127.0.0.1:4000", nil); code != http.StatusOK {
+		t.Errorf("local mutation without credentials configured: status %d, want 200", code)
+	}
+}
@@ -0,0 +1,180 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/journal"
+)
+
+// backendRequest is the body of POST /nexus/backends
+type backendRequest struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight,omitempty"`
+}
+
+// stateRequest is the body of PUT /nexus/backends/state
+type stateRequest struct {
+	URL   string `json:"url"`
+	State string `json:"state"`
+}
+
+// backendInfo describes a backend in GET /nexus/backends
+type backendInfo struct {
+	URL            string `json:"url"`
+	Weight         int    `json:"weight"`
+	Alive          bool   `json:"alive"`
+	Draining       bool   `json:"draining"`
+	Override       string `json:"override"`
+	ActiveRequests int64  `json:"active_requests"`
+	// Upgrades are open WebSocket and other upgraded connections, which
+	// are also counted in ActiveRequests
+	Upgrades int `json:"upgraded_connections"`
+	// Pool is the traffic split pool of the backend, empty for the default
+	Pool string `json:"pool,omitempty"`
+	// Tier is the failover tier of the backend, 0 for the primary
+	Tier int `json:"tier,omitempty"`
+	// Origin tells where the backend came from: static, admin-api,
+	// library, dns, k8s, consul, file or etcd; Source is the discovery source that found it
+	Origin string `json:"origin"`
+	Source string `json:"source,omitempty"`
+	// Labels are those a discovery source attached
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// handleListBackends lists the backends in the pool
+func (s *Server) handleListBackends(w http.ResponseWriter, r *http.Request) {
+	backends := s.Pool.GetBackends()
+	infos := make([]backendInfo, 0, len(backends))
+	for _, b := range backends {
+		infos = append(infos, describeBackend(b))
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+// handleAddBackend adds a backend to the pool. The health checker picks it
+// up on its next round and the proxy can select it immediately.
+func (s *Server) handleAddBackend(w http.ResponseWriter, r *http.Request) {
+	var req backendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		writeError(w, http.StatusBadRequest, "url must be an absolute http or https URL")
+		return
+	}
+	if req.Weight < 0 {
+		writeError(w, http.StatusBadRequest, "weight must not be negative")
+		return
+	}
+	if req.Weight == 0 {
+		req.Weight = 1
+	}
+	if b := s.Pool.GetBackend(u.String()); b != nil {
+		writeError(w, http.StatusConflict, fmt.Sprintf("backend already exists: %s (origin %s)", u.String(), b.Origin))
+		return
+	}
+
+	b, err := s.NewBackend(u.String(), req.Weight)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.Pool.AddBackend(b)
+	s.Journal.Record(journal.TypeAdmin, actor(r),
+		fmt.Sprintf("added backend %s (weight %d)", b.URL.String(), b.Weight), nil)
+
+	writeJSON(w, http.StatusCreated, describeBackend(b))
+}
+
+// handleRemoveBackend removes a backend. With drain=true the backend stops
+// receiving new requests at once and is removed when its in-flight requests
+// finish (or the drain timeout elapses).
+func (s *Server) handleRemoveBackend(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("url")
+	drain, _ := strconv.ParseBool(r.URL.Query().Get("drain"))
+
+	b := s.Pool.GetBackend(target)
+	if b == nil {
+		writeError(w, http.StatusNotFound, "no such backend: "+target)
+		return
+	}
+
+	if !drain {
+		s.Pool.RemoveBackend(target)
+		s.Journal.Record(journal.TypeAdmin, actor(r), "removed backend "+target, nil)
+		writeJSON(w, http.StatusOK, describeBackend(b))
+		return
+	}
+
+	b.StartDraining()
+	s.logger.Info("draining backend", "backend", target, "inflight", b.ActiveRequests(), "actor", actor(r))
+	s.Journal.Record(journal.TypeAdmin, actor(r), "draining and removing backend "+target, nil)
+	go s.Pool.DrainBackend(b, s.Config.Admin.DrainTimeout.Std())
+
+	writeJSON(w, http.StatusAccepted, describeBackend(b))
+}
+
+// handleSetState forces a backend up or down, pinning that state against
+// health checks until it is set back to "auto"
+func (s *Server) handleSetState(w http.ResponseWriter, r *http.Request) {
+	var req stateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	override, err := backend.ParseOverride(req.State)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	b := s.Pool.GetBackend(req.URL)
+	if b == nil {
+		writeError(w, http.StatusNotFound, "no such backend: "+req.URL)
+		return
+	}
+
+	previous := b.Override()
+	b.SetOverride(override)
+	if override == backend.OverrideAuto {
+		s.logger.Info("backend returned to automatic health checking", "backend", req.URL, "actor", actor(r))
+	} else {
+		s.logger.Info("backend pinned by operator", "backend", req.URL, "state", strings.ToUpper(override.String()), "actor", actor(r))
+	}
+	s.Journal.Record(journal.TypeAdmin, actor(r), "set state of backend "+req.URL, []journal.Change{{
+		Path: fmt.Sprintf("backends[url=%s].state", req.URL),
+		Op:   "changed",
+		Old:  previous.String(),
+		New:  override.String(),
+	}})
+
+	writeJSON(w, http.StatusOK, describeBackend(b))
+}
+
+// describeBackend builds the API representation of a backend
+func describeBackend(b *backend.Backend) backendInfo {
+	return backendInfo{
+		URL:            b.URL.String(),
+		Weight:         b.Weight,
+		Alive:          b.IsAlive(),
+		Draining:       b.Draining(),
+		Override:       b.Override().String(),
+		ActiveRequests: b.ActiveRequests(),
+		Upgrades:       b.Upgrades(),
+		Pool:           b.Group,
+		Tier:           b.Tier,
+		Origin:         b.Origin,
+		Source:         b.Source,
+		Labels:         b.Labels,
+	}
+}
@@ -0,0 +1,146 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nexus-lb/nexus/config"
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/journal"
+)
+
+// newBackendsServer is newTestServer able to construct backends, with a
+// short drain timeout
+func newBackendsServer(t *testing.T) *Server {
+	t.Helper()
+	s := newTestServer(t, func(a *config.AdminConfig) {
+		a.DrainTimeout = config.Duration(time.Second)
+	})
+	s.NewBackend = func(url string, weight int) (*backend.Backend, error) {
+		return backend.NewBackend(url, backend.WithWeight(weight))
+	}
+	return s
+}
+
+// send sends a local request with body to s and returns the status code
+func send(s *Server, method, path, body string) int {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.RemoteAddr = "127.0.0.1:4000"
+	w := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestAddBackend(t *testing.T) {
+	s := newBackendsServer(t)
+	if code := send(s, http.MethodPost, "/nexus/backends", `{"url": "http://10.0.0.1:80", "weight": 2}`); code != http.StatusCreated {
+		t.Fatalf("status %d, want 201", code)
+	}
+	// The very next selection sees the new backend
+	b := s.Pool.GetNextPeer()
+	if b == nil || b.URL.String() != "http://10.0.0.1:80" || b.Weight != 2 {
+		t.Fatalf("next peer %v, want the added backend", b)
+	}
+	if entries := s.Journal.Entries(journal.Filter{}); len(entries) != 1 || !strings.Contains(entries[0].Summary, "added backend") {
+		t.Errorf("journal %+v, want the addition", entries)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"duplicate", `{"url": "http://10.0.0.1:80"}`, http.StatusConflict},
+		{"relative url", `{"url": "/backend"}`, http.StatusBadRequest},
+		{"other scheme", `{"url": "ftp://10.0.0.2"}`, http.StatusBadRequest},
+		{"negative weight", `{"url": "http://10.0.0.2:80", "weight": -1}`, http.StatusBadRequest},
+		{"invalid JSON", `{"url":`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code := send(s, http.MethodPost, "/nexus/backends", tt.body); code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, code, tt.want)
+		}
+	}
+	if s.Pool.GetPoolSize() != 1 {
+		t.Errorf("pool has %d backends, want 1", s.Pool.GetPoolSize())
+	}
+}
+
+func TestRemoveBackend(t *testing.T) {
+	s := newBackendsServer(t)
+	send(s, http.MethodPost, "/nexus/backends", `{"url": "http://10.0.0.1:80"}`)
+	send(s, http.MethodPost, "/nexus/backends", `{"url": "http://10.0.0.2:80"}`)
+
+	if code := send(s, http.MethodDelete, "/nexus/backends?url=http://10.0.0.1:80", ""); code != http.StatusOK {
+		t.Fatalf("status %d, want 200", code)
+	}
+	for range 4 {
+		if b := s.Pool.GetNextPeer(); b.URL.String() != "http://10.0.0.2:80" {
+			t.Fatalf("picked %s after its removal", b.URL)
+		}
+	}
+	if code := send(s, http.MethodDelete, "/nexus/backends?url=http://10.0.0.1:80", ""); code != http.StatusNotFound {
+		t.Errorf("second removal: status %d, want 404", code)
+	}
+}
+
+func TestDrainBackend(t *testing.T) {
+	s := newBackendsServer(t)
+	send(s, http.MethodPost, "/nexus/backends", `{"url": "http://10.0.0.1:80"}`)
+	send(s, http.MethodPost, "/nexus/backends", `{"url": "http://10.0.0.2:80"}`)
+
+	if code := send(s, http.MethodDelete, "/nexus/backends?url=http://10.0.0.1:80&drain=true", ""); code != http.StatusAccepted {
+		t.Fatalf("status %d, want 202", code)
+	}
+	// A draining backend takes no new requests, even before it is removed
+	for range 4 {
+		if b := s.Pool.GetNextPeer(); b.URL.String() != "http://10.0.0.2:80" {
+			t.Fatalf("picked draining backend %s", b.URL)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for s.Pool.GetBackend("http://10.0.0.1:80") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("idle backend not removed after draining")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestChangesUnderTraffic(t *testing.T) {
+	s := newBackendsServer(t)
+	send(s, http.MethodPost, "/nexus/backends", `{"url": "http://10.0.0.1:80"}`)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					if b := s.Pool.GetNextPeer(); b == nil {
+						t.Error("no peer while the static backend is in the pool")
+						return
+					}
+				}
+			}
+		}()
+	}
+	for range 50 {
+		send(s, http.MethodPost, "/nexus/backends", `{"url": "http://10.0.0.2:80"}`)
+		send(s, http.MethodDelete, "/nexus/backends?url=http://10.0.0.2:80", "")
+	}
+	close(stop)
+	wg.Wait()
+	if s.Pool.GetPoolSize() != 1 {
+		t.Errorf("pool has %d backends, want 1", s.Pool.GetPoolSize())
+	}
+}
@@ -0,0 +1,32 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/journal"
+)
+
+// handleChanges lists recent configuration and operator changes.
+// Query parameters: type (config_reload, admin, discovery or freeze) and
+// since/until as RFC 3339 timestamps.
+func (s *Server) handleChanges(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := journal.Filter{Type: q.Get("type")}
+
+	for name, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid "+name+" (want RFC 3339): "+v)
+			return
+		}
+		*dst = t
+	}
+
+	_, pretty := q["pretty"]
+	writeJSONIndent(w, http.StatusOK, s.Journal.Entries(filter), pretty)
+}
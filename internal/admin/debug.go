@@ -0,0 +1,102 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	"time"
+)
+
+// registerPprof serves the Go profiler. It is only ever mounted on the admin
+// mux; the proxy listener forwards /debug/ paths to backends like any other.
+func (s *Server) registerPprof() {
+	s.mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	s.mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	s.mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	s.mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	s.mux.HandleFunc("POST /debug/pprof/symbol", pprof.Symbol)
+	s.mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+}
+
+// RuntimeStatus is the response of GET /debug/runtime
+type RuntimeStatus struct {
+	GoVersion  string     `json:"go_version"`
+	Goroutines int        `json:"goroutines"`
+	CPUs       int        `json:"cpus"`
+	Heap       HeapStatus `json:"heap"`
+	GC         GCStatus   `json:"gc"`
+	// OpenFDs is -1 where the platform does not expose the count
+	OpenFDs int `json:"open_fds"`
+}
+
+// HeapStatus summarizes heap memory in bytes
+type HeapStatus struct {
+	Alloc    uint64 `json:"alloc_bytes"`
+	InUse    uint64 `json:"inuse_bytes"`
+	Idle     uint64 `json:"idle_bytes"`
+	Sys      uint64 `json:"sys_bytes"`
+	Objects  uint64 `json:"objects"`
+	NextGC   uint64 `json:"next_gc_bytes"`
+	TotalSys uint64 `json:"total_sys_bytes"`
+}
+
+// GCStatus summarizes garbage collection, with the most recent pauses first
+type GCStatus struct {
+	Count        uint32    `json:"count"`
+	PauseTotalMs float64   `json:"pause_total_ms"`
+	RecentPauses []float64 `json:"recent_pauses_ms"`
+	LastGC       time.Time `json:"last_gc,omitzero"`
+}
+
+// recentPauses is how many of the latest GC pauses /debug/runtime reports
+const recentPauses = 10
+
+// handleRuntime reports goroutine, heap, GC and file descriptor counts
+func (s *Server) handleRuntime(w http.ResponseWriter, r *http.Request) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	gc := GCStatus{
+		Count:        m.NumGC,
+		PauseTotalMs: milliseconds(time.Duration(m.PauseTotalNs)),
+		RecentPauses: []float64{},
+	}
+	if m.NumGC > 0 {
+		gc.LastGC = time.Unix(0, int64(m.LastGC))
+	}
+	for i := uint32(0); i < min(m.NumGC, recentPauses); i++ {
+		// PauseNs is a circular buffer indexed by (NumGC+255)%256
+		pause := m.PauseNs[(m.NumGC-i+255)%256]
+		gc.RecentPauses = append(gc.RecentPauses, milliseconds(time.Duration(pause)))
+	}
+
+	writeJSONIndent(w, http.StatusOK, RuntimeStatus{
+		GoVersion:  runtime.Version(),
+		Goroutines: runtime.NumGoroutine(),
+		CPUs:       runtime.NumCPU(),
+		Heap: HeapStatus{
+			Alloc:    m.HeapAlloc,
+			InUse:    m.HeapInuse,
+			Idle:     m.HeapIdle,
+			Sys:      m.HeapSys,
+			Objects:  m.HeapObjects,
+			NextGC:   m.NextGC,
+			TotalSys: m.Sys,
+		},
+		GC:      gc,
+		OpenFDs: openFDs(),
+	}, r.URL.Query().Has("pretty"))
+}
+
+// openFDs counts the process's open file descriptors, or returns -1 if it
+// cannot tell
+func openFDs() int {
+	for _, dir := range []string{"/proc/self/fd", "/dev/fd"} {
+		if entries, err := os.ReadDir(dir); err == nil {
+			// Reading the directory itself holds one descriptor open
+			return len(entries) - 1
+		}
+	}
+	return -1
+}
@@ -0,0 +1,47 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nexus-lb/nexus/config"
+)
+
+func TestPprofOnlyWhenEnabled(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		s := newTestServer(t, func(a *config.AdminConfig) { a.Pprof = enabled })
+		want := http.StatusNotFound
+		if enabled {
+			want = http.StatusOK
+		}
+		for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline"} {
+			if code := do(s, http.MethodGet, path, "127.0.0.1:4000", nil); code != want {
+				t.Errorf("pprof %v: %s status %d, want %d", enabled, path, code, want)
+			}
+		}
+	}
+}
+
+func TestRuntimeStatus(t *testing.T) {
+	s := newTestServer(t, nil)
+	r := httptest.NewRequest(http.MethodGet, "/debug/runtime", nil)
+	r.RemoteAddr = "127.0.0.1:4000"
+	w := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+
+	var status RuntimeStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Goroutines < 1 || status.Heap.Sys == 0 || status.GoVersion == "" || status.OpenFDs == 0 {
+		t.Errorf("implausible runtime status %+v", status)
+	}
+	if len(status.GC.RecentPauses) > recentPauses {
+		t.Errorf("%d GC pauses, want at most %d", len(status.GC.RecentPauses), recentPauses)
+	}
+}
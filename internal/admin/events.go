@@ -0,0 +1,32 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/audit"
+)
+
+// handleEvents lists recent backend state transitions.
+// Query parameters: backend (URL), cause (active, passive, warmup, override
+// or manual) and since/until as RFC 3339 timestamps.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := audit.Filter{Backend: q.Get("backend"), Cause: q.Get("cause")}
+
+	for name, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid "+name+" (want RFC 3339): "+v)
+			return
+		}
+		*dst = t
+	}
+
+	_, pretty := q["pretty"]
+	writeJSONIndent(w, http.StatusOK, s.Audit.Events(filter), pretty)
+}
@@ -0,0 +1,52 @@
+package admin
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// expvar variables are process-wide, so the "nexus" variable is published
+// once and reads from whichever admin server was created last
+var (
+	expvarServer  atomic.Pointer[Server]
+	expvarPublish sync.Once
+)
+
+// publishExpvar exposes the core counters under the "nexus" expvar. The
+// values are read from the same counters as /metrics and /nexus/status.
+func (s *Server) publishExpvar() {
+	expvarServer.Store(s)
+	expvarPublish.Do(func() {
+		expvar.Publish("nexus", expvar.Func(func() any {
+			return expvarServer.Load().expvarSnapshot()
+		}))
+	})
+}
+
+// expvarSnapshot builds the value of the "nexus" expvar
+func (s *Server) expvarSnapshot() map[string]any {
+	alive, total := s.Pool.GetPoolStatus()
+
+	backendRequests := make(map[string]uint64)
+	backendFailures := make(map[string]uint64)
+	for _, b := range s.Pool.GetBackends() {
+		backendRequests[b.URL.String()] = b.Requests()
+		backendFailures[b.URL.String()] = b.Failures()
+	}
+
+	return map[string]any{
+		"version":              s.Version,
+		"started_at":           s.StartedAt.Format(time.RFC3339),
+		"uptime_seconds":       int64(time.Since(s.StartedAt).Seconds()),
+		"requests":             s.Metrics.Requests(),
+		"errors":               s.Metrics.Errors(),
+		"retries":              s.Metrics.Retries(),
+		"backends_alive":       alive,
+		"backends_total":       total,
+		"backend_requests":     backendRequests,
+		"backend_failures":     backendFailures,
+		"requests_by_protocol": s.Protocols.Snapshot(),
+	}
+}
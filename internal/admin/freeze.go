@@ -0,0 +1,54 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+)
+
+// handleFreezeStatus reports whether automatic state changes are frozen
+func (s *Server) handleFreezeStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Freeze.Status())
+}
+
+// handleFreeze freezes automatic state changes.
+// Query parameters: duration (e.g. "30m", defaults to the configured maximum)
+// and reason.
+func (s *Server) handleFreeze(w http.ResponseWriter, r *http.Request) {
+	var d time.Duration
+	if v := r.URL.Query().Get("duration"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed < 0 {
+			writeError(w, http.StatusBadRequest, "invalid duration: "+v)
+			return
+		}
+		d = parsed
+	}
+
+	writeJSON(w, http.StatusOK, s.Freeze.Freeze(actor(r), r.URL.Query().Get("reason"), d))
+}
+
+// handleUnfreeze lifts the freeze. The action query parameter must be
+// "apply" or "discard" and decides what happens to the queued changes.
+func (s *Server) handleUnfreeze(w http.ResponseWriter, r *http.Request) {
+	var apply bool
+	switch r.URL.Query().Get("action") {
+	case "apply":
+		apply = true
+	case "discard":
+		apply = false
+	default:
+		writeError(w, http.StatusBadRequest, "action must be \"apply\" or \"discard\"")
+		return
+	}
+
+	applied, discarded := s.Freeze.Unfreeze(actor(r), apply)
+	writeJSON(w, http.StatusOK, map[string]int{
+		"applied":   applied,
+		"discarded": discarded,
+	})
+}
+
+// handleFrozenChanges lists the changes queued while frozen
+func (s *Server) handleFrozenChanges(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Freeze.Changes())
+}
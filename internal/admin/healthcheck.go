@@ -0,0 +1,88 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/nexus-lb/nexus/config"
+	"github.com/nexus-lb/nexus/internal/journal"
+)
+
+// healthCheckChange is the body of PUT /nexus/health-check; a field left
+// out keeps its value
+type healthCheckChange struct {
+	Interval *config.Duration `json:"interval"`
+	Timeout  *config.Duration `json:"timeout"`
+}
+
+// handleGetHealthCheck reports the active health check settings
+func (s *Server) handleGetHealthCheck(w http.ResponseWriter, r *http.Request) {
+	if s.Health == nil {
+		writeError(w, http.StatusNotFound, "no health checker is running")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.healthCheckStatus())
+}
+
+// handleSetHealthCheck changes the interval or timeout of the active health
+// checks, from the next check of each backend on
+func (s *Server) handleSetHealthCheck(w http.ResponseWriter, r *http.Request) {
+	if s.Health == nil {
+		writeError(w, http.StatusNotFound, "no health checker is running")
+		return
+	}
+	var req healthCheckChange
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	if req.Interval == nil && req.Timeout == nil {
+		writeError(w, http.StatusBadRequest, "interval or timeout is required")
+		return
+	}
+	if req.Interval != nil && *req.Interval <= 0 || req.Timeout != nil && *req.Timeout <= 0 {
+		writeError(w, http.StatusBadRequest, "interval and timeout must be positive")
+		return
+	}
+
+	previous := s.Health.Settings()
+	var changes []journal.Change
+	if req.Interval != nil {
+		s.Health.SetInterval(req.Interval.Std())
+		changes = append(changes, journal.Change{
+			Path: "health_check.interval",
+			Op:   "changed",
+			Old:  previous.Interval.String(),
+			New:  req.Interval.Std().String(),
+		})
+	}
+	if req.Timeout != nil {
+		s.Health.SetTimeout(req.Timeout.Std())
+		changes = append(changes, journal.Change{
+			Path: "health_check.timeout",
+			Op:   "changed",
+			Old:  previous.Timeout.String(),
+			New:  req.Timeout.Std().String(),
+		})
+	}
+	current := s.Health.Settings()
+	s.logger.Info("health check settings changed", "interval", current.Interval, "timeout", current.Timeout, "actor", actor(r))
+	s.Journal.Record(journal.TypeAdmin, actor(r),
+		fmt.Sprintf("set health checks to every %s within %s", current.Interval, current.Timeout), changes)
+
+	writeJSON(w, http.StatusOK, s.healthCheckStatus())
+}
+
+func (s *Server) healthCheckStatus() HealthCheckStatus {
+	settings := s.Health.Settings()
+	st := HealthCheckStatus{
+		Running:  settings.Running,
+		Interval: settings.Interval.String(),
+		Timeout:  settings.Timeout.String(),
+	}
+	if c := settings.Cadence; c != nil {
+		st.MinInterval, st.MaxInterval = c.Min.String(), c.Max.String()
+	}
+	return st
+}
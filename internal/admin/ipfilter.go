@@ -0,0 +1,66 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/nexus-lb/nexus/internal/ipfilter"
+	"github.com/nexus-lb/nexus/internal/journal"
+)
+
+// ipFilterRequest is the body of PUT /nexus/ip-filters; both lists are
+// replaced, an absent one by an empty one
+type ipFilterRequest struct {
+	Name  string   `json:"name"`
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// handleGetIPFilters reports the lists of every IP filter
+func (s *Server) handleGetIPFilters(w http.ResponseWriter, r *http.Request) {
+	filters := make([]ipfilter.Status, 0, len(s.IPFilters))
+	for _, f := range s.IPFilters {
+		filters = append(filters, f.Status())
+	}
+	writeJSON(w, http.StatusOK, filters)
+}
+
+// handleSetIPFilter replaces the lists of one IP filter. The change lasts
+// until Nexus restarts.
+func (s *Server) handleSetIPFilter(w http.ResponseWriter, r *http.Request) {
+	var req ipFilterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	i := slices.IndexFunc(s.IPFilters, func(f *ipfilter.Filter) bool { return f.Name() == req.Name })
+	if i < 0 {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no IP filter is named %q", req.Name))
+		return
+	}
+	lists, err := ipfilter.Parse(req.Allow, req.Deny)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	f := s.IPFilters[i]
+	old := f.Status()
+	f.Set(lists)
+	st := f.Status()
+	s.logger.Info("IP filter changed", "filter", f.Name(), "allow", st.Allow, "deny", st.Deny, "actor", actor(r))
+	var diff []journal.Change
+	if !slices.Equal(old.Allow, st.Allow) {
+		diff = append(diff, journal.Change{Path: "ip_filter." + f.Name() + ".allow", Op: "changed", Old: old.Allow, New: st.Allow})
+	}
+	if !slices.Equal(old.Deny, st.Deny) {
+		diff = append(diff, journal.Change{Path: "ip_filter." + f.Name() + ".deny", Op: "changed", Old: old.Deny, New: st.Deny})
+	}
+	if len(diff) > 0 {
+		s.Journal.Record(journal.TypeAdmin, actor(r), fmt.Sprintf("replaced the lists of IP filter %s", f.Name()), diff)
+	}
+
+	writeJSON(w, http.StatusOK, st)
+}
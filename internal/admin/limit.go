@@ -0,0 +1,64 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/nexus-lb/nexus/internal/journal"
+)
+
+// concurrencyLimit is the body of PUT /nexus/concurrency-limit and, with
+// the requests in flight, the answer of both calls
+type concurrencyLimit struct {
+	MaxInFlight *int `json:"max_in_flight"`
+	InFlight    int  `json:"in_flight"`
+}
+
+// handleGetConcurrencyLimit reports the concurrency limit
+func (s *Server) handleGetConcurrencyLimit(w http.ResponseWriter, r *http.Request) {
+	if s.Limiter == nil {
+		writeError(w, http.StatusNotFound, "no concurrency limiter is installed")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.concurrencyLimit())
+}
+
+// handleSetConcurrencyLimit changes the concurrency limit; 0 lifts it
+func (s *Server) handleSetConcurrencyLimit(w http.ResponseWriter, r *http.Request) {
+	if s.Limiter == nil {
+		writeError(w, http.StatusNotFound, "no concurrency limiter is installed")
+		return
+	}
+	var req concurrencyLimit
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	if req.MaxInFlight == nil {
+		writeError(w, http.StatusBadRequest, "max_in_flight is required")
+		return
+	}
+	if *req.MaxInFlight < 0 {
+		writeError(w, http.StatusBadRequest, "max_in_flight must not be negative")
+		return
+	}
+
+	previous := s.Limiter.Limit()
+	s.Limiter.SetLimit(*req.MaxInFlight)
+	s.logger.Info("concurrency limit changed", "max_in_flight", *req.MaxInFlight, "actor", actor(r))
+	s.Journal.Record(journal.TypeAdmin, actor(r),
+		fmt.Sprintf("set the concurrency limit to %d", *req.MaxInFlight), []journal.Change{{
+			Path: "concurrency_limit.max_in_flight",
+			Op:   "changed",
+			Old:  previous,
+			New:  *req.MaxInFlight,
+		}})
+
+	writeJSON(w, http.StatusOK, s.concurrencyLimit())
+}
+
+func (s *Server) concurrencyLimit() concurrencyLimit {
+	limit := s.Limiter.Limit()
+	return concurrencyLimit{MaxInFlight: &limit, InFlight: s.Limiter.InFlight()}
+}
@@ -0,0 +1,62 @@
+package admin
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+
+	"github.com/nexus-lb/nexus/internal/metrics"
+)
+
+// loopbackAddr returns the address the admin listener binds to when remote
+// admin is not allowed: addr itself if its host is loopback or a name, and
+// 127.0.0.1 on the same port if it would listen on all interfaces or on
+// another IP. Names are left to the loopback guard, which refuses the
+// requests that arrive from elsewhere whatever the listener is bound to.
+func loopbackAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if host == "" {
+		return net.JoinHostPort("127.0.0.1", port)
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || ip.Unmap().IsLoopback() {
+		return addr
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+// loopbackGuard refuses admin requests whose peer is not a loopback
+// address with 403. Only the connection's address counts: X-Forwarded-For
+// and the like are sent by the client and prove nothing.
+type loopbackGuard struct {
+	metrics *metrics.Metrics
+	logger  *slog.Logger
+}
+
+func (g *loopbackGuard) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isLoopback(r.RemoteAddr) {
+			g.metrics.Rejected("admin_remote")
+			g.logger.Warn("refused admin request from a remote address; set admin.allow_remote_admin and admin.auth to allow it",
+				"client", r.RemoteAddr, "method", r.Method, "path", r.URL.Path)
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isLoopback reports whether remoteAddr is a loopback address, IPv4-mapped
+// IPv6 addresses included
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.Unmap().IsLoopback()
+}
@@ -0,0 +1,135 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nexus-lb/nexus/config"
+	"github.com/nexus-lb/nexus/internal/freeze"
+	"github.com/nexus-lb/nexus/internal/journal"
+	"github.com/nexus-lb/nexus/internal/pool"
+)
+
+// newTestServer creates an admin server over an empty pool with the admin
+// settings of configure applied to the defaults
+func newTestServer(t *testing.T, configure func(*config.AdminConfig)) *Server {
+	t.Helper()
+	cfg := config.Default()
+	if configure != nil {
+		configure(&cfg.Admin)
+	}
+	return NewServer(cfg.Admin.Address, Sources{
+		Config:  cfg,
+		Pool:    &pool.ServerPool{},
+		Freeze:  freeze.NewController(time.Hour, false, false),
+		Journal: journal.New(16, nil),
+	})
+}
+
+// do sends a request from remote to s and returns the status code
+func do(s *Server, method, path, remote string, header http.Header) int {
+	r := httptest.NewRequest(method, path, nil)
+	r.RemoteAddr = remote
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestLoopbackAddr(t *testing.T) {
+	tests := map[string]string{
+		":8001":            "127.0.0.1:8001",
+		"0.0.0.0:8001":     "127.0.0.1:8001",
+		"[::]:8001":        "127.0.0.1:8001",
+		"192.0.2.1:8001":   "127.0.0.1:8001",
+		"127.0.0.1:8001":   "127.0.0.1:8001",
+		"127.0.0.2:8001":   "127.0.0.2:8001",
+		"[::1]:8001":       "[::1]:8001",
+		"localhost:8001":   "localhost:8001",
+		"admin.local:8001": "admin.local:8001",
+		"no port":          "no port",
+	}
+	for addr, want := range tests {
+		if got := loopbackAddr(addr); got != want {
+			t.Errorf("loopbackAddr(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1:4000":        true,
+		"127.8.0.1:4000":        true,
+		"[::1]:4000":            true,
+		"[::ffff:127.0.0.1]:40": true,
+		"192.0.2.1:4000":        false,
+		"[2001:db8::1]:4000":    false,
+		"[::ffff:192.0.2.1]:40": false,
+		"127.0.0.1":             false,
+	}
+	for addr, want := range tests {
+		if got := isLoopback(addr); got != want {
+			t.Errorf("isLoopback(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestLocalOnlyByDefault(t *testing.T) {
+	s := newTestServer(t, func(a *config.AdminConfig) { a.Address = "0.0.0.0:8001" })
+	if s.Addr() != "127.0.0.1:8001" {
+		t.Errorf("bound to %s, want 127.0.0.1:8001", s.Addr())
+	}
+	for _, remote := range []string{"127.0.0.1:4000", "[::1]:4000", "[::ffff:127.0.0.1]:4000"} {
+		if code := do(s, http.MethodGet, "/nexus/backends", remote, nil); code != http.StatusOK {
+			t.Errorf("from %s: status %d, want 200", remote, code)
+		}
+	}
+	if code := do(s, http.MethodGet, "/nexus/backends", "192.0.2.1:4000", nil); code != http.StatusForbidden {
+		t.Errorf("remote client: status %d, want 403", code)
+	}
+}
+
+func TestForwardedHeadersIgnored(t *testing.T) {
+	s := newTestServer(t, nil)
+	forged := []http.Header{
+		{"X-Forwarded-For": {"127.0.0.1"}},
+		{"X-Forwarded-For": {"::1"}},
+		{"X-Real-Ip": {"127.0.0.1"}},
+		{"Forwarded": {"for=127.0.0.1"}},
+	}
+	for _, h := range forged {
+		if code := do(s, http.MethodGet, "/nexus/backends", "203.0.113.9:4000", h); code != http.StatusForbidden {
+			t.Errorf("remote client with %v: status %d, want 403", h, code)
+		}
+		if code := do(s, http.MethodPost, "/admin/freeze", "203.0.113.9:4000", h); code != http.StatusForbidden {
+			t.Errorf("remote mutation with %v: status %d, want 403", h, code)
+		}
+	}
+	if s.Freeze.Frozen() {
+		t.Error("a remote client froze Nexus")
+	}
+}
+
+func TestRemoteAdminNetworks(t *testing.T) {
+	s := newTestServer(t, func(a *config.AdminConfig) {
+		a.AllowRemoteAdmin = true
+		a.Auth = &config.AdminAuthConfig{Token: "s3cret", AllowCIDRs: []string{"10.0.0.0/8"}}
+	})
+	bearer := http.Header{"Authorization": {"Bearer s3cret"}}
+	if code := do(s, http.MethodGet, "/nexus/backends", "10.1.2.3:4000", bearer); code != http.StatusOK {
+		t.Errorf("allowed network with the token: status %d, want 200", code)
+	}
+	if code := do(s, http.MethodGet, "/nexus/backends", "10.1.2.3:4000", nil); code != http.StatusUnauthorized {
+		t.Errorf("allowed network without the token: status %d, want 401", code)
+	}
+	// Outside the networks the token does not help, nor does claiming to
+	// be forwarded from inside them
+	outside := http.Header{"Authorization": {"Bearer s3cret"}, "X-Forwarded-For": {"10.1.2.3"}}
+	if code := do(s, http.MethodGet, "/nexus/backends", "203.0.113.9:4000", outside); code != http.StatusForbidden {
+		t.Errorf("outside the networks: status %d, want 403", code)
+	}
+}
@@ -0,0 +1,79 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/nexus-lb/nexus/internal/journal"
+	"github.com/nexus-lb/nexus/internal/split"
+)
+
+// maintenanceRequest is the body of PUT /nexus/maintenance
+type maintenanceRequest struct {
+	Pool    string `json:"pool"`
+	Enabled *bool  `json:"enabled"`
+}
+
+// handleGetMaintenance reports the pools in maintenance
+func (s *Server) handleGetMaintenance(w http.ResponseWriter, r *http.Request) {
+	if s.Maintenance == nil {
+		writeError(w, http.StatusNotFound, "maintenance mode is not available")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Maintenance.Status())
+}
+
+// handleSetMaintenance puts one pool in maintenance or takes it out; an
+// empty pool is the default pool
+func (s *Server) handleSetMaintenance(w http.ResponseWriter, r *http.Request) {
+	if s.Maintenance == nil {
+		writeError(w, http.StatusNotFound, "maintenance mode is not available")
+		return
+	}
+	var req maintenanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	if req.Enabled == nil {
+		writeError(w, http.StatusBadRequest, "enabled is required")
+		return
+	}
+	if req.Pool == "" {
+		req.Pool = split.DefaultPool
+	}
+	if !s.hasPool(req.Pool) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("no backend is in pool %q", req.Pool))
+		return
+	}
+
+	if s.Maintenance.Set(req.Pool, *req.Enabled) {
+		verb := "took pool %s out of maintenance"
+		if *req.Enabled {
+			verb = "put pool %s in maintenance"
+		}
+		s.logger.Info("maintenance changed", "pool", req.Pool, "enabled", *req.Enabled, "actor", actor(r))
+		s.Journal.Record(journal.TypeAdmin, actor(r), fmt.Sprintf(verb, req.Pool), []journal.Change{{
+			Path: "maintenance." + req.Pool,
+			Op:   "changed",
+			Old:  !*req.Enabled,
+			New:  *req.Enabled,
+		}})
+	}
+
+	writeJSON(w, http.StatusOK, s.Maintenance.Status())
+}
+
+// hasPool reports whether pool is the default pool or some backend is in it
+func (s *Server) hasPool(pool string) bool {
+	if pool == split.DefaultPool {
+		return true
+	}
+	for _, b := range s.Pool.GetBackends() {
+		if b.Group == pool {
+			return true
+		}
+	}
+	return false
+}
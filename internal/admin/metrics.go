@@ -0,0 +1,13 @@
+package admin
+
+import (
+	"net/http"
+)
+
+// handleMetrics serves the metrics registry in the Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := s.Metrics.Registry.WriteTo(w); err != nil {
+		s.logger.Warn("failed to write metrics", "error", err)
+	}
+}
@@ -0,0 +1,47 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/nexus-lb/nexus/internal/journal"
+)
+
+// samplingStatus is the body of GET and PUT /admin/log-sampling
+type samplingStatus struct {
+	// Every logs one in every N successful requests
+	Every   int    `json:"every"`
+	Skipped uint64 `json:"skipped"`
+}
+
+// handleGetSampling reports the request log sampling rate
+func (s *Server) handleGetSampling(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, samplingStatus{Every: s.Sampler.Every(), Skipped: s.Sampler.Skipped()})
+}
+
+// handleSetSampling changes the request log sampling rate
+func (s *Server) handleSetSampling(w http.ResponseWriter, r *http.Request) {
+	var req samplingStatus
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	if req.Every < 1 {
+		writeError(w, http.StatusBadRequest, "every must be at least 1")
+		return
+	}
+
+	previous := s.Sampler.Every()
+	s.Sampler.SetEvery(req.Every)
+	s.logger.Info("request log sampling changed", "every", req.Every, "actor", actor(r))
+	s.Journal.Record(journal.TypeAdmin, actor(r),
+		fmt.Sprintf("set request log sampling to 1 in %d", req.Every), []journal.Change{{
+			Path: "access_log.sample_every",
+			Op:   "changed",
+			Old:  previous,
+			New:  req.Every,
+		}})
+
+	writeJSON(w, http.StatusOK, samplingStatus{Every: s.Sampler.Every(), Skipped: s.Sampler.Skipped()})
+}
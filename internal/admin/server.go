@@ -0,0 +1,190 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/nexus-lb/nexus/config"
+	"github.com/nexus-lb/nexus/internal/accesslog"
+	"github.com/nexus-lb/nexus/internal/audit"
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/discovery"
+	"github.com/nexus-lb/nexus/internal/events"
+	"github.com/nexus-lb/nexus/internal/freeze"
+	"github.com/nexus-lb/nexus/internal/health"
+	"github.com/nexus-lb/nexus/internal/ipfilter"
+	"github.com/nexus-lb/nexus/internal/journal"
+	"github.com/nexus-lb/nexus/internal/maintenance"
+	"github.com/nexus-lb/nexus/internal/metrics"
+	"github.com/nexus-lb/nexus/internal/pool"
+	"github.com/nexus-lb/nexus/internal/proxy"
+	"github.com/nexus-lb/nexus/internal/split"
+)
+
+// Sources holds the parts of Nexus the admin API reports on and manages
+type Sources struct {
+	Config      *config.Config
+	Pool        *pool.ServerPool
+	Freeze      *freeze.Controller
+	Protocols   *metrics.ProtocolCounters
+	Conns       *metrics.ConnCounters
+	Metrics     *metrics.Metrics
+	Journal     *journal.Journal
+	Audit       *audit.Log
+	Sampler     *accesslog.Sampler
+	Split       *split.Splitter
+	Maintenance *maintenance.Mode
+	Discovery   []*discovery.Reconciler
+	IPFilters   []*ipfilter.Filter
+	Limiter     *proxy.ConcurrencyLimiter
+	Health      *health.HealthChecker
+	Events      *events.Bus
+	StartedAt   time.Time
+	Version     string
+	Logger      *slog.Logger
+
+	// NewBackend constructs a backend for the given URL and weight with the
+	// same settings as configured backends
+	NewBackend func(url string, weight int) (*backend.Backend, error)
+}
+
+// Server exposes the Nexus management API on its own listener, separate
+// from the proxy so admin paths are never routed to backends
+type Server struct {
+	Sources
+	mux    *http.ServeMux
+	server *http.Server
+	logger *slog.Logger
+
+	// stopping is closed when shutdown begins, ending open event streams
+	stopping chan struct{}
+}
+
+// NewServer creates an admin server listening on addr. Unless
+// admin.allow_remote_admin is set, it binds to loopback instead of other
+// addresses and only answers loopback clients.
+func NewServer(addr string, src Sources) *Server {
+	s := &Server{
+		Sources:  src,
+		mux:      http.NewServeMux(),
+		logger:   src.Logger,
+		stopping: make(chan struct{}),
+	}
+	if s.logger == nil {
+		s.logger = slog.Default()
+	}
+	s.logger = s.logger.With("component", "admin")
+	var handler http.Handler = s.mux
+	if a := s.Config.Admin; a.Auth != nil || a.Token != "" {
+		handler = newAuthenticator(a).wrap(handler)
+	}
+	if !s.Config.Admin.AllowRemoteAdmin {
+		if bind := loopbackAddr(addr); bind != addr {
+			s.logger.Warn("admin.allow_remote_admin is not set, binding the admin API to loopback only", "address", addr, "bind", bind)
+			addr = bind
+		}
+		handler = (&loopbackGuard{metrics: s.Metrics, logger: s.logger}).wrap(handler)
+	}
+	s.server = &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+	s.server.RegisterOnShutdown(func() { close(s.stopping) })
+
+	s.mux.HandleFunc("GET /nexus/status", s.handleStatus)
+	s.mux.HandleFunc("GET /metrics", s.handleMetrics)
+	s.mux.Handle("GET /debug/vars", expvar.Handler())
+	s.mux.HandleFunc("GET /nexus/backends", s.handleListBackends)
+	s.mux.HandleFunc("POST /nexus/backends", s.handleAddBackend)
+	s.mux.HandleFunc("DELETE /nexus/backends", s.handleRemoveBackend)
+	s.mux.HandleFunc("PUT /nexus/backends/state", s.handleSetState)
+	s.mux.HandleFunc("GET /admin/freeze", s.handleFreezeStatus)
+	s.mux.HandleFunc("POST /admin/freeze", s.handleFreeze)
+	s.mux.HandleFunc("POST /admin/unfreeze", s.handleUnfreeze)
+	s.mux.HandleFunc("GET /admin/frozen-changes", s.handleFrozenChanges)
+	s.mux.HandleFunc("GET /admin/changes", s.handleChanges)
+	s.mux.HandleFunc("GET /nexus/events", s.handleEvents)
+	s.mux.HandleFunc("GET /nexus/stream", s.handleStream)
+	s.mux.HandleFunc("GET /admin/log-sampling", s.handleGetSampling)
+	s.mux.HandleFunc("PUT /admin/log-sampling", s.handleSetSampling)
+	s.mux.HandleFunc("GET /nexus/split", s.handleGetSplit)
+	s.mux.HandleFunc("PUT /nexus/split", s.handleSetSplit)
+	s.mux.HandleFunc("GET /nexus/maintenance", s.handleGetMaintenance)
+	s.mux.HandleFunc("PUT /nexus/maintenance", s.handleSetMaintenance)
+	s.mux.HandleFunc("GET /nexus/ip-filters", s.handleGetIPFilters)
+	s.mux.HandleFunc("PUT /nexus/ip-filters", s.handleSetIPFilter)
+	s.mux.HandleFunc("GET /nexus/concurrency-limit", s.handleGetConcurrencyLimit)
+	s.mux.HandleFunc("PUT /nexus/concurrency-limit", s.handleSetConcurrencyLimit)
+	s.mux.HandleFunc("GET /nexus/health-check", s.handleGetHealthCheck)
+	s.mux.HandleFunc("PUT /nexus/health-check", s.handleSetHealthCheck)
+	s.mux.HandleFunc("GET /debug/runtime", s.handleRuntime)
+	if s.Config.Admin.Pprof {
+		s.registerPprof()
+	}
+
+	s.publishExpvar()
+	return s
+}
+
+// Addr returns the address the admin API is to listen on, which may be the
+// loopback form of the configured one
+func (s *Server) Addr() string {
+	return s.server.Addr
+}
+
+// Start serves the admin API on ln, a listener on Addr, in a separate
+// goroutine. The returned channel receives the error that stops it
+// serving, unless that is Shutdown; what to do about it is up to the
+// caller.
+func (s *Server) Start(ln net.Listener) <-chan error {
+	s.logger.Info("admin API listening", "addr", s.server.Addr)
+	errs := make(chan error, 1)
+	go func() {
+		if err := s.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			errs <- err
+		}
+	}()
+	return errs
+}
+
+// Shutdown gracefully stops the admin listener; it does nothing on a nil
+// Server, which stands for a disabled admin API
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	return s.server.Shutdown(ctx)
+}
+
+// actor identifies the client behind an admin request in the change journal
+func actor(r *http.Request) string {
+	return "admin@" + r.RemoteAddr
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	writeJSONIndent(w, status, v, false)
+}
+
+// writeJSONIndent writes v as a JSON response, indented for humans if pretty is set
+func writeJSONIndent(w http.ResponseWriter, status int, v any, pretty bool) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	if pretty {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(v); err != nil {
+		slog.Warn("failed to encode response", "component", "admin", "error", err)
+	}
+}
+
+// writeError writes a JSON error body with the given status code
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,58 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/nexus-lb/nexus/internal/journal"
+)
+
+// splitRequest is the body of PUT /nexus/split
+type splitRequest struct {
+	Pool    string   `json:"pool"`
+	Percent *float64 `json:"percent"`
+}
+
+// handleGetSplit reports the share of traffic of every pool
+func (s *Server) handleGetSplit(w http.ResponseWriter, r *http.Request) {
+	if s.Split == nil {
+		writeError(w, http.StatusNotFound, "no traffic split is configured")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Split.Status())
+}
+
+// handleSetSplit changes the share of one named pool; the default pool
+// takes up the difference
+func (s *Server) handleSetSplit(w http.ResponseWriter, r *http.Request) {
+	if s.Split == nil {
+		writeError(w, http.StatusNotFound, "no traffic split is configured")
+		return
+	}
+	var req splitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	if req.Percent == nil {
+		writeError(w, http.StatusBadRequest, "percent is required")
+		return
+	}
+
+	previous := s.Split.Status().Percent[req.Pool]
+	if err := s.Split.SetPercent(req.Pool, *req.Percent); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.logger.Info("traffic split changed", "pool", req.Pool, "percent", *req.Percent, "actor", actor(r))
+	s.Journal.Record(journal.TypeAdmin, actor(r),
+		fmt.Sprintf("set the share of pool %s to %g%%", req.Pool, *req.Percent), []journal.Change{{
+			Path: "split.percent." + req.Pool,
+			Op:   "changed",
+			Old:  previous,
+			New:  *req.Percent,
+		}})
+
+	writeJSON(w, http.StatusOK, s.Split.Status())
+}
@@ -0,0 +1,274 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/discovery"
+	"github.com/nexus-lb/nexus/internal/freeze"
+	"github.com/nexus-lb/nexus/internal/maintenance"
+	"github.com/nexus-lb/nexus/internal/metrics"
+	"github.com/nexus-lb/nexus/internal/split"
+)
+
+// StatusResponse is the body of GET /nexus/status. Field names are part of
+// the admin API and should only ever be added to, not renamed.
+type StatusResponse struct {
+	StartedAt          time.Time         `json:"started_at"`
+	UptimeSeconds      int64             `json:"uptime_seconds"`
+	Pool               PoolStatus        `json:"pool"`
+	Backends           []BackendStatus   `json:"backends"`
+	RequestsByProtocol map[string]uint64 `json:"requests_by_protocol"`
+	Freeze             freeze.Status     `json:"freeze"`
+	Config             ConfigSummary     `json:"config"`
+
+	// ClientConnections counts the client connections Nexus holds
+	ClientConnections metrics.ConnSnapshot `json:"client_connections"`
+
+	// Split is the share of traffic of each pool, if traffic is split
+	Split *split.Status `json:"split,omitempty"`
+
+	// Maintenance lists the pools in maintenance
+	Maintenance *maintenance.Status `json:"maintenance,omitempty"`
+
+	// Discovery describes the service discovery sources, with those that
+	// cannot be reached marked stale
+	Discovery []discovery.Status `json:"discovery,omitempty"`
+
+	// HealthCheck holds the active health check settings in effect, which
+	// the admin API may have changed since startup
+	HealthCheck *HealthCheckStatus `json:"health_check,omitempty"`
+}
+
+// HealthCheckStatus describes the active health checks as they run now
+type HealthCheckStatus struct {
+	Running  bool   `json:"running"`
+	Interval string `json:"interval"`
+	Timeout  string `json:"timeout"`
+	// MinInterval and MaxInterval bound the adaptive schedule, if enabled
+	MinInterval string `json:"min_interval,omitempty"`
+	MaxInterval string `json:"max_interval,omitempty"`
+}
+
+// PoolStatus summarizes the server pool
+type PoolStatus struct {
+	Size  int `json:"size"`
+	Alive int `json:"alive"`
+	Total int `json:"total"`
+	// BackendsWithRetiredTransports counts backends still draining an old
+	// transport after a configuration change
+	BackendsWithRetiredTransports int64 `json:"backends_with_retired_transports"`
+	// RecentLatencyMs holds request duration percentiles over the last
+	// minute and five minutes
+	RecentLatencyMs RecentLatency `json:"recent_latency_ms"`
+}
+
+// RecentLatency reports latency percentiles over recent windows; a window
+// without requests is null
+type RecentLatency struct {
+	OneMinute   *WindowStatus `json:"1m"`
+	FiveMinutes *WindowStatus `json:"5m"`
+}
+
+// WindowStatus reports latency percentiles in milliseconds over one window
+type WindowStatus struct {
+	Count uint64  `json:"count"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+}
+
+// BackendStatus describes a single backend
+type BackendStatus struct {
+	URL       string            `json:"url"`
+	Weight    int               `json:"weight"`
+	Tier      int               `json:"tier,omitempty"`
+	Origin    string            `json:"origin"`
+	Source    string            `json:"source,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Alive     bool              `json:"alive"`
+	Draining  bool              `json:"draining"`
+	Override  string            `json:"override"`
+	LastCheck time.Time         `json:"last_check,omitzero"`
+	Requests  uint64            `json:"requests"`
+	Failures  uint64            `json:"failures"`
+	// Errors breaks the failures down by class: refused, timeout, reset,
+	// dns, tls, canceled, other and 5xx
+	Errors map[string]uint64 `json:"errors"`
+	// CheckInterval is the backend's current active health check interval
+	CheckInterval string `json:"check_interval,omitempty"`
+	// LatencyMs summarizes the time the backend took to serve requests
+	LatencyMs *LatencyStatus `json:"latency_ms,omitempty"`
+	// RecentLatencyMs is the same over the last minute and five minutes
+	RecentLatencyMs RecentLatency `json:"recent_latency_ms"`
+	// Connections counts the connections Nexus holds to the backend
+	Connections backend.ConnStats `json:"connections"`
+	// RetiredTransports is the number of old transports still draining
+	RetiredTransports int64 `json:"retired_transports"`
+	// Pool is the traffic split pool of the backend, empty for the default
+	Pool string `json:"pool,omitempty"`
+	// CircuitBreaker is the state of the backend's circuit breaker, if it
+	// has one
+	CircuitBreaker *backend.BreakerStats `json:"circuit_breaker,omitempty"`
+}
+
+// LatencyStatus reports latency percentiles in milliseconds
+type LatencyStatus struct {
+	Count uint64  `json:"count"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+}
+
+// ConfigSummary is the subset of the running configuration worth reporting
+type ConfigSummary struct {
+	Listen              string `json:"listen"`
+	AdminAddress        string `json:"admin_address"`
+	HealthCheckInterval string `json:"health_check_interval"`
+	HealthCheckTimeout  string `json:"health_check_timeout"`
+	ShutdownTimeout     string `json:"shutdown_timeout"`
+	MaxRetries          int    `json:"max_retries"`
+}
+
+// handleStatus reports the state of Nexus itself. Add ?pretty for indented output.
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	_, pretty := r.URL.Query()["pretty"]
+	writeJSONIndent(w, http.StatusOK, s.status(), pretty)
+}
+
+// status builds a snapshot of the current state
+func (s *Server) status() StatusResponse {
+	alive, total := s.Pool.GetPoolStatus()
+
+	backends := s.Pool.GetBackends()
+	backendStatuses := make([]BackendStatus, 0, len(backends))
+	for _, b := range backends {
+		backendStatuses = append(backendStatuses, BackendStatus{
+			URL:       b.URL.String(),
+			Weight:    b.Weight,
+			Tier:      b.Tier,
+			Origin:    b.Origin,
+			Source:    b.Source,
+			Labels:    b.Labels,
+			Alive:     b.IsAlive(),
+			Draining:  b.Draining(),
+			Override:  b.Override().String(),
+			LastCheck: b.LastCheck(),
+			Requests:  b.Requests(),
+			Failures:  b.Failures(),
+			Errors:    b.Errors(),
+
+			CheckInterval:     checkInterval(b),
+			LatencyMs:         latencyStatus(b),
+			RecentLatencyMs:   recentLatency(b.RecentLatency),
+			Connections:       b.Conns(),
+			RetiredTransports: b.RetiredTransports(),
+			Pool:              b.Group,
+			CircuitBreaker:    b.Breaker(),
+		})
+	}
+
+	var splitStatus *split.Status
+	if s.Split != nil {
+		st := s.Split.Status()
+		splitStatus = &st
+	}
+	var maintenanceStatus *maintenance.Status
+	if s.Maintenance != nil {
+		st := s.Maintenance.Status()
+		maintenanceStatus = &st
+	}
+
+	var discoveryStatus []discovery.Status
+	for _, rc := range s.Discovery {
+		discoveryStatus = append(discoveryStatus, rc.Status())
+	}
+	var healthStatus *HealthCheckStatus
+	if s.Health != nil {
+		st := s.healthCheckStatus()
+		healthStatus = &st
+	}
+
+	return StatusResponse{
+		StartedAt:     s.StartedAt,
+		UptimeSeconds: int64(time.Since(s.StartedAt).Seconds()),
+		Pool: PoolStatus{
+			Size:  s.Pool.GetPoolSize(),
+			Alive: alive,
+			Total: total,
+
+			BackendsWithRetiredTransports: backend.BackendsWithRetiredTransports(),
+			RecentLatencyMs:               recentLatency(s.Metrics.RecentLatency),
+		},
+		Backends:           backendStatuses,
+		RequestsByProtocol: s.Protocols.Snapshot(),
+		ClientConnections:  s.Conns.Snapshot(),
+		Freeze:             s.Freeze.Status(),
+		Split:              splitStatus,
+		Maintenance:        maintenanceStatus,
+		Discovery:          discoveryStatus,
+		HealthCheck:        healthStatus,
+		Config: ConfigSummary{
+			Listen:              s.Config.Listen,
+			AdminAddress:        s.Config.Admin.Address,
+			HealthCheckInterval: s.Config.HealthCheck.Interval.Std().String(),
+			HealthCheckTimeout:  s.Config.HealthCheck.Timeout.Std().String(),
+			ShutdownTimeout:     s.Config.ShutdownTimeout.Std().String(),
+			MaxRetries:          s.Config.MaxRetries,
+		},
+	}
+}
+
+// checkInterval formats a backend's current health check interval, empty
+// until the health checker has scheduled it
+func checkInterval(b *backend.Backend) string {
+	if d := b.CheckInterval(); d > 0 {
+		return d.String()
+	}
+	return ""
+}
+
+// latencyStatus reports a backend's latency percentiles, or nil before its
+// first request
+func latencyStatus(b *backend.Backend) *LatencyStatus {
+	l := b.Latency()
+	if l.Count == 0 {
+		return nil
+	}
+	return &LatencyStatus{
+		Count: l.Count,
+		Mean:  milliseconds(l.Mean),
+		P50:   milliseconds(l.P50),
+		P95:   milliseconds(l.P95),
+		P99:   milliseconds(l.P99),
+	}
+}
+
+// recentLatency reports the percentiles of the last minute and five minutes
+func recentLatency(snapshot func(window time.Duration) metrics.WindowSnapshot) RecentLatency {
+	return RecentLatency{
+		OneMinute:   windowStatus(snapshot(time.Minute)),
+		FiveMinutes: windowStatus(snapshot(5 * time.Minute)),
+	}
+}
+
+// windowStatus converts a window snapshot to milliseconds, or nil if the
+// window saw no requests
+func windowStatus(w metrics.WindowSnapshot) *WindowStatus {
+	if w.Count == 0 {
+		return nil
+	}
+	return &WindowStatus{
+		Count: w.Count,
+		P50:   milliseconds(w.P50),
+		P90:   milliseconds(w.P90),
+		P99:   milliseconds(w.P99),
+	}
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// streamBuffer is how many events a slow stream consumer may fall behind by
+// before it starts missing them
+const streamBuffer = 64
+
+// poolSummary is the periodic "summary" event of /nexus/stream
+type poolSummary struct {
+	Alive    int    `json:"alive"`
+	Total    int    `json:"total"`
+	Requests uint64 `json:"requests"`
+	Errors   uint64 `json:"errors"`
+}
+
+// handleStream sends pool state as server-sent events: a "snapshot" of the
+// full status on connect, then every event published on the event bus
+// (backend_state, backend_membership, change) and a "summary" every
+// interval (query parameter, default 10s). A consumer too slow to keep up
+// misses events rather than holding anything up, and is sent a fresh
+// snapshot once it catches up.
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	interval := 10 * time.Second
+	if v := r.URL.Query().Get("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second {
+			writeError(w, http.StatusBadRequest, "invalid interval (at least 1s): "+v)
+			return
+		}
+		interval = d
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	// Subscribe before taking the snapshot so no change falls in between
+	sub := s.Events.Subscribe(streamBuffer)
+	defer s.Events.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event string, data any) bool {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			s.logger.Warn("failed to encode stream event", "event", event, "error", err)
+			return true
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	if !send("snapshot", s.status()) {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var dropped uint64
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok || !send(e.Type, e) {
+				return
+			}
+		case <-ticker.C:
+			alive, total := s.Pool.GetPoolStatus()
+			if !send("summary", poolSummary{
+				Alive:    alive,
+				Total:    total,
+				Requests: s.Metrics.Requests(),
+				Errors:   s.Metrics.Errors(),
+			}) {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-s.stopping:
+			return
+		}
+
+		// Resynchronize a consumer that missed events once it has caught up
+		if n := sub.Dropped(); n != dropped && len(sub.C) == 0 {
+			dropped = n
+			if !send("snapshot", s.status()) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+)
+
+// Event is one recorded backend state transition
+type Event struct {
+	ID      uint64    `json:"id"`
+	Time    time.Time `json:"time"`
+	Backend string    `json:"backend"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Cause   string    `json:"cause"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Filter selects events; zero fields match everything
+type Filter struct {
+	Backend string
+	Cause   string
+	Since   time.Time
+	Until   time.Time
+}
+
+// matches reports whether an event passes the filter
+func (f Filter) matches(e Event) bool {
+	if f.Backend != "" && e.Backend != f.Backend {
+		return false
+	}
+	if f.Cause != "" && e.Cause != f.Cause {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Log keeps a bounded, in-memory history of backend state transitions,
+// oldest events falling off first, and optionally appends each one to a
+// file as a JSON line
+type Log struct {
+	mu       sync.Mutex
+	events   []Event
+	start    int // index of the oldest event once the ring is full
+	capacity int
+	nextID   uint64
+	file     *os.File
+}
+
+// New creates a log holding at most capacity events
+func New(capacity int) *Log {
+	return &Log{
+		events:   make([]Event, 0, capacity),
+		capacity: capacity,
+	}
+}
+
+// OpenFile appends every event recorded from now on to the file at path
+func (l *Log) OpenFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening audit log: %w", err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.file = f
+	return nil
+}
+
+// Record appends a transition of backendURL to the given alive state
+func (l *Log) Record(backendURL string, alive bool, cause backend.Cause) Event {
+	from, to := "UP", "DOWN"
+	if alive {
+		from, to = to, from
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.nextID++
+	e := Event{
+		ID:      l.nextID,
+		Time:    time.Now(),
+		Backend: backendURL,
+		From:    from,
+		To:      to,
+		Cause:   cause.Kind,
+		Error:   cause.Error,
+	}
+	if len(l.events) < l.capacity {
+		l.events = append(l.events, e)
+	} else {
+		l.events[l.start] = e
+		l.start = (l.start + 1) % l.capacity
+	}
+
+	// State changes are rare, so the file is written in line
+	if l.file != nil {
+		line, _ := json.Marshal(e)
+		if _, err := l.file.Write(append(line, '\n')); err != nil {
+			slog.Warn("failed to write audit log", "component", "audit", "error", err)
+		}
+	}
+	return e
+}
+
+// Events returns the events matching the filter, oldest first
+func (l *Log) Events(f Filter) []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	result := make([]Event, 0, len(l.events))
+	for i := range l.events {
+		e := l.events[(l.start+i)%len(l.events)]
+		if f.matches(e) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// Close closes the audit file, if any
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
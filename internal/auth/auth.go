@@ -0,0 +1,196 @@
+// Package auth puts authentication in front of routes whose backends have
+// none of their own: basic auth checked against an htpasswd file, static
+// API keys sent in a header, or either
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/nexus-lb/nexus/internal/metrics"
+	"github.com/nexus-lb/nexus/internal/proxy"
+)
+
+// Options configure a Gate
+type Options struct {
+	// Name identifies the gate in logs
+	Name string
+	// Realm is sent to clients in WWW-Authenticate
+	Realm string
+	// HtpasswdFile, if set, enables basic auth against its "user:hash"
+	// lines, whose hashes must be bcrypt, as htpasswd -B writes them
+	HtpasswdFile string
+	// APIKeyHeader is the header API keys are sent in
+	APIKeyHeader string
+	// APIKeys are the accepted keys by the name of their client, which is
+	// logged as its user
+	APIKeys map[string]string
+	Metrics *metrics.Metrics
+}
+
+// Gate answers requests without valid credentials with 401 and passes the
+// others on, recording who sent them for the access log. The credentials
+// are taken off the request before it reaches a backend.
+type Gate struct {
+	o      Options
+	logger *slog.Logger
+
+	mu    sync.RWMutex
+	users map[string][]byte // bcrypt hashes by user
+	// verified holds a digest of the password last verified for each
+	// user, so bcrypt runs once per user and password rather than once per
+	// request
+	verified map[string][sha256.Size]byte
+}
+
+// New creates a gate, reading its htpasswd file if it has one
+func New(o Options) (*Gate, error) {
+	g := &Gate{
+		o:      o,
+		logger: slog.Default().With("component", "auth", "gate", o.Name),
+	}
+	if err := g.Reload(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Reload reads the htpasswd file again. If it cannot be read or has an
+// invalid line, the users read before are kept.
+func (g *Gate) Reload() error {
+	if g.o.HtpasswdFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(g.o.HtpasswdFile)
+	if err != nil {
+		return err
+	}
+	users, err := parseHtpasswd(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", g.o.HtpasswdFile, err)
+	}
+	g.mu.Lock()
+	g.users, g.verified = users, make(map[string][sha256.Size]byte)
+	g.mu.Unlock()
+	g.logger.Info("read htpasswd file", "path", g.o.HtpasswdFile, "users", len(users))
+	return nil
+}
+
+// parseHtpasswd parses the "user:hash" lines of an htpasswd file, skipping
+// blank lines and # comments
+func parseHtpasswd(data []byte) (map[string][]byte, error) {
+	users := make(map[string][]byte)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("line %d: expected user:hash", n)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("line %d: the hash of %s is not bcrypt (create it with htpasswd -B)", n, user)
+		}
+		if _, dup := users[user]; dup {
+			return nil, fmt.Errorf("line %d: %s is listed twice", n, user)
+		}
+		users[user] = []byte(hash)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Wrap puts the gate in front of next
+func (g *Gate) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := g.authenticate(r)
+		if !ok {
+			g.o.Metrics.Rejected("auth")
+			if g.o.HtpasswdFile != "" {
+				w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, g.o.Realm))
+			}
+			if len(g.o.APIKeys) > 0 {
+				w.Header().Add("WWW-Authenticate", fmt.Sprintf(`APIKey realm=%q, header=%q`, g.o.Realm, g.o.APIKeyHeader))
+			}
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		proxy.SetUser(r, user)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate checks the credentials of r and returns who sent them,
+// removing them from r if they are valid
+func (g *Gate) authenticate(r *http.Request) (string, bool) {
+	if key := r.Header.Get(g.o.APIKeyHeader); key != "" && len(g.o.APIKeys) > 0 {
+		// Compare every key so timing does not reveal which one is close
+		user := ""
+		for name, want := range g.o.APIKeys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(want)) == 1 {
+				user = name
+			}
+		}
+		if user == "" {
+			return "", false
+		}
+		r.Header.Del(g.o.APIKeyHeader)
+		return user, true
+	}
+	if user, pass, ok := r.BasicAuth(); ok && g.o.HtpasswdFile != "" {
+		if !g.checkPassword(user, pass) {
+			return "", false
+		}
+		r.Header.Del("Authorization")
+		return user, true
+	}
+	return "", false
+}
+
+// checkPassword reports whether pass is the password of user
+func (g *Gate) checkPassword(user, pass string) bool {
+	digest := sha256.Sum256([]byte(pass))
+	g.mu.RLock()
+	hash, known := g.users[user]
+	last, seen := g.verified[user]
+	g.mu.RUnlock()
+	if !known {
+		// Spend the time of a real check, so unknown users do not stand out
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(pass))
+		return false
+	}
+	if seen && subtle.ConstantTimeCompare(digest[:], last[:]) == 1 {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(pass)) != nil {
+		return false
+	}
+	g.mu.Lock()
+	// The file may have been read again in the meantime
+	if current, ok := g.users[user]; ok && bytes.Equal(current, hash) {
+		g.verified[user] = digest
+	}
+	g.mu.Unlock()
+	return true
+}
+
+// dummyHash is checked against for unknown users; it is made on first use
+// rather than slowing down every start
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("nexus"), bcrypt.DefaultCost)
+	return hash
+})
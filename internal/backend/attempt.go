@@ -0,0 +1,76 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// Attempt lets the caller of ReverseProxy.ServeHTTP take over the failure of
+// one proxy attempt, so it can retry on another backend, instead of the
+// backend answering the client with an error response
+type Attempt struct {
+	// Retry asks for a failure to be reported in Err rather than answered;
+	// nothing is written to the client then
+	Retry bool
+	// RetryStatus lists backend response codes treated as failures while
+	// Retry is set
+	RetryStatus []int
+	// Err is the failure of the attempt, if there was one
+	Err error
+}
+
+// ErrAbandoned is the cancellation cause of an attempt given up because
+// another attempt of the same request answered first. Abandoned attempts
+// do not count against their backend.
+var ErrAbandoned = errors.New("attempt abandoned for a faster one")
+
+// StatusError is the failure of an attempt whose response had a status
+// listed in Attempt.RetryStatus
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return "backend answered " + strconv.Itoa(e.Code)
+}
+
+// FailureStatus returns the status a client is answered with for a failed
+// attempt: 504 for timeouts, the backend's own status for StatusErrors, 413
+// for request bodies cut off at their limit, 503 for open circuit breakers
+// and 502 for anything else
+func FailureStatus(err error) int {
+	var statusErr *StatusError
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &statusErr):
+		return statusErr.Code
+	case errors.As(err, &maxBytesErr):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrUpstreamTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, ErrCircuitOpen):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
+
+type attemptKey struct{}
+
+// WithAttempt returns a copy of r whose proxy attempt reports to a
+func WithAttempt(r *http.Request, a *Attempt) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), attemptKey{}, a))
+}
+
+// attemptOf returns the Attempt of a proxied request, or nil
+func attemptOf(r *http.Request) *Attempt {
+	a, _ := r.Context().Value(attemptKey{}).(*Attempt)
+	return a
+}
+
+// retryStatus reports whether resp is to be retried rather than relayed
+func (a *Attempt) retryStatus(resp *http.Response) bool {
+	return a != nil && a.Retry && slices.Contains(a.RetryStatus, resp.StatusCode)
+}
@@ -1,28 +1,199 @@
 package backend
 
 import (
-	"log"
-	"net"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/nexus-lb/nexus/internal/errorpage"
+	"github.com/nexus-lb/nexus/internal/headers"
+	"github.com/nexus-lb/nexus/internal/logdedup"
+	"github.com/nexus-lb/nexus/internal/metrics"
+)
+
+// Gate decides whether an automatic state change may be applied right away.
+// When Submit returns false the gate keeps apply and may run it later.
+type Gate interface {
+	Submit(kind, key, description string, apply func()) bool
+}
+
+// StateEventType is the event bus type of backend state changes
+const StateEventType = "backend_state"
+
+// Kinds of causes of backend state changes
+const (
+	CauseActive   = "active"   // active health check
+	CausePassive  = "passive"  // failed proxied request
+	CauseWarmup   = "warmup"   // active health check followed by a warm-up
+	CauseOverride = "override" // operator pinned the state
+	CauseManual   = "manual"   // SetAlive called directly
 )
 
+// Origins of backends, telling where each came from
+const (
+	OriginStatic     = "static"    // the configuration file
+	OriginAdmin      = "admin-api" // added through the admin API
+	OriginLibrary    = "library"   // added by a program embedding Nexus
+	OriginDNS        = "dns"       // an SRV record
+	OriginKubernetes = "k8s"       // the EndpointSlices of a Service
+	OriginConsul     = "consul"    // the Consul catalog
+	OriginFile       = "file"      // a backends file
+	OriginEtcd       = "etcd"      // registered in etcd
+)
+
+// Cause explains why a backend's alive state changed
+type Cause struct {
+	Kind  string `json:"kind"`
+	Error string `json:"error,omitempty"`
+}
+
+func (c Cause) String() string {
+	if c.Error == "" {
+		return c.Kind
+	}
+	return c.Kind + ": " + c.Error
+}
+
+// StateChange describes a backend going UP or DOWN
+type StateChange struct {
+	URL   string `json:"url"`
+	Alive bool   `json:"alive"`
+	Cause Cause  `json:"cause"`
+}
+
+// StateListener is told whenever a backend's alive state flips
+type StateListener func(b *Backend, alive bool, cause Cause)
+
 // Backend represents a backend server
 type Backend struct {
 	URL          *url.URL
 	Alive        bool
 	mux          sync.RWMutex
 	ReverseProxy *httputil.ReverseProxy
+	Warmup       *Warmup
+	Weight       int
+	gate         Gate
+	listener     StateListener
+	logger       *slog.Logger
+	draining     atomic.Bool
+	override     atomic.Int32
+	active       atomic.Int64
+
+	// HealthInterval pins the active health check interval of the backend;
+	// zero leaves it to the health checker's schedule
+	HealthInterval time.Duration
+
+	// ExternalHealth leaves the backend's health to another system, such
+	// as Consul, that removes it when unhealthy: the active health checker
+	// skips it, while passive checks still apply
+	ExternalHealth bool
+
+	// Group is the pool of a traffic split the backend serves; empty for
+	// the default pool
+	Group string
+
+	// Origin tells where the backend came from, one of the Origin
+	// constants; Source is the discovery source that found it, if any
+	Origin string
+	Source string
+	// Labels are free-form labels a discovery source attached
+	Labels map[string]string
+
+	// Tier ranks the backend within its pool: requests only go to a tier
+	// while every lower one has no backend available, so tiers above 0
+	// are backups
+	Tier int
+
+	transport         atomic.Pointer[transportRef]
+	retiredTransports atomic.Int64
+
+	requests  atomic.Uint64
+	failures  atomic.Uint64
+	lastCheck atomic.Int64 // unix nanoseconds of the last active health check
+	interval  atomic.Int64 // current active health check interval
+
+	latency metrics.LatencyHistogram
+	recent  metrics.WindowedLatency
+
+	// errorCounts counts failed requests by class, indexed like ErrorClasses
+	errorCounts [len(ErrorClasses)]atomic.Uint64
+
+	conns connCounters
+
+	// logDedup collapses repeated passive failure lines
+	logDedup *logdedup.Deduper
+
+	// trusted are the proxies whose forwarding headers are passed on
+	trusted TrustedProxies
+	realIP  bool
+	// clientCert forwards the client's TLS certificate in
+	// X-Forwarded-Client-Cert
+	clientCert bool
+
+	// hideIdentity leaves out the headers naming Nexus and the backend
+	hideIdentity bool
+	errorPages   *errorpage.Pages
+	headerRules  *headers.Rules
+
+	// serverErrors decides when 5xx responses mark the backend down
+	serverErrors serverErrors
+
+	// breaker takes the backend out of rotation briefly after a burst of
+	// failures
+	breaker breaker
+
+	// tunnels are the upgraded connections to the backend
+	tunnels tunnels
+}
+
+// Warmup describes synthetic requests sent to a recovered backend before it
+// is admitted back into rotation
+type Warmup struct {
+	// Requests is the number of warm-up requests to send
+	Requests int
+	// Path is the request path used for warm-up requests
+	Path string
+	// LatencyBudget is the latency the final warm-up request must stay under
+	LatencyBudget time.Duration
+	// Timeout bounds each individual warm-up request
+	Timeout time.Duration
+}
+
+// Option configures optional Backend behavior
+type Option func(*Backend)
+
+// WithWarmup enables warm-up requests before the backend is admitted after recovery
+func WithWarmup(w Warmup) Option {
+	return func(b *Backend) {
+		b.Warmup = &w
+	}
 }
 
 // SetAlive sets the alive status of the backend in a thread-safe manner
 func (b *Backend) SetAlive(alive bool) {
+	b.setAlive(alive, Cause{Kind: CauseManual})
+}
+
+// setAlive sets the alive status and tells the listener why it changed
+func (b *Backend) setAlive(alive bool, cause Cause) {
 	b.mux.Lock()
-	defer b.mux.Unlock()
+	changed := b.Alive != alive
 	b.Alive = alive
+	listener := b.listener
+	b.mux.Unlock()
+
+	if changed && listener != nil {
+		listener(b, alive, cause)
+	}
 }
 
 // IsAlive returns whether the backend is alive in a thread-safe manner
@@ -32,35 +203,400 @@ func (b *Backend) IsAlive() bool {
 	return b.Alive
 }
 
-// passiveHealthCheckTransport wraps http.RoundTripper to detect connection failures
+// RecordCheck records the time of an active health check against the backend
+func (b *Backend) RecordCheck(t time.Time) {
+	b.lastCheck.Store(t.UnixNano())
+}
+
+// LastCheck returns the time of the last active health check, or the zero
+// time if the backend has not been checked yet
+func (b *Backend) LastCheck() time.Time {
+	ns := b.lastCheck.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// SetCheckInterval records the interval at which the health checker is
+// currently probing the backend
+func (b *Backend) SetCheckInterval(d time.Duration) {
+	b.interval.Store(int64(d))
+}
+
+// CheckInterval returns the current active health check interval, or zero
+// if the backend has not been scheduled yet
+func (b *Backend) CheckInterval() time.Duration {
+	return time.Duration(b.interval.Load())
+}
+
+// Requests returns the number of requests proxied to the backend
+func (b *Backend) Requests() uint64 {
+	return b.requests.Load()
+}
+
+// Failures returns the number of proxied requests that failed with a
+// connection error or a 5xx response
+func (b *Backend) Failures() uint64 {
+	return b.failures.Load()
+}
+
+// ObserveLatency records how long the backend took to serve a request
+func (b *Backend) ObserveLatency(d time.Duration) {
+	b.latency.Observe(d)
+	b.recent.Observe(d)
+}
+
+// Latency returns the backend's latency percentiles
+func (b *Backend) Latency() metrics.LatencySnapshot {
+	return b.latency.Snapshot()
+}
+
+// RecentLatency returns the backend's latency percentiles over the last
+// window, at most metrics.MaxWindow
+func (b *Backend) RecentLatency(window time.Duration) metrics.WindowSnapshot {
+	return b.recent.Snapshot(window)
+}
+
+// ActiveRequests returns the number of requests currently in flight to the backend
+func (b *Backend) ActiveRequests() int64 {
+	return b.active.Load()
+}
+
+// StartDraining stops the backend from being selected for new requests
+func (b *Backend) StartDraining() {
+	b.draining.Store(true)
+}
+
+// Draining reports whether the backend is being drained
+func (b *Backend) Draining() bool {
+	return b.draining.Load()
+}
+
+// WaitIdle blocks until the backend has no requests in flight or the
+// timeout elapses, and reports whether it became idle
+func (b *Backend) WaitIdle(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for b.active.Load() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return true
+}
+
+// SetGate installs the gate that automatic health transitions pass through
+func (b *Backend) SetGate(g Gate) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.gate = g
+}
+
+// SetStateListener installs the listener told about alive state changes
+func (b *Backend) SetStateListener(l StateListener) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.listener = l
+}
+
+// UpdateHealth applies an automatic health transition through the backend's
+// gate and reports whether it took effect immediately. Transitions are
+// ignored while an operator override pins the backend's state.
+func (b *Backend) UpdateHealth(alive bool, cause Cause) bool {
+	if b.Pinned() {
+		return false
+	}
+
+	b.mux.RLock()
+	gate := b.gate
+	b.mux.RUnlock()
+
+	if gate == nil {
+		b.setAlive(alive, cause)
+		return true
+	}
+
+	state := "DOWN"
+	if alive {
+		state = "UP"
+	}
+	description := b.URL.String() + " -> " + state + " (" + cause.String() + ")"
+	return gate.Submit("health", "health:"+b.URL.String(), description, func() {
+		b.setAlive(alive, cause)
+	})
+}
+
+// passiveHealthCheckTransport wraps the backend's current transport to detect connection failures
 type passiveHealthCheckTransport struct {
-	backend   *Backend
-	transport http.RoundTripper
+	backend *Backend
 }
 
 func (t *passiveHealthCheckTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	resp, err := t.transport.RoundTrip(req)
+	admitted, trial, transition := t.backend.breaker.admit(time.Now())
+	t.backend.logBreaker(transition)
+	if !admitted {
+		return nil, ErrCircuitOpen
+	}
+	t.backend.requests.Add(1)
+	t.backend.active.Add(1)
+	ref := t.backend.acquireTransport()
+	req, dl := startDeadline(req, ref.settings)
+	done := func() {
+		dl.stop()
+		ref.release()
+		t.backend.active.Add(-1)
+	}
+	resp, err := ref.transport.RoundTrip(req)
 
 	if err != nil {
-		// Connection error detected - mark backend as down immediately
-		if t.backend.IsAlive() {
-			log.Printf("[PASSIVE] Backend %s failed: %v - marking as DOWN", t.backend.URL.String(), err)
-			t.backend.SetAlive(false)
+		if timedOut(req) && !errors.Is(err, ErrUpstreamTimeout) {
+			err = fmt.Errorf("%w: %w", ErrUpstreamTimeout, err)
+		}
+		done()
+		// A request body over its size limit is the client's fault, and
+		// neither a client going away nor an abandoned attempt says anything
+		// about the backend
+		var maxBytesErr *http.MaxBytesError
+		canceled := ClassifyError(err) == ErrorCanceled
+		abandoned := errors.Is(context.Cause(req.Context()), ErrAbandoned)
+		switch {
+		case errors.As(err, &maxBytesErr) || canceled:
+			if canceled && !abandoned {
+				t.backend.recordError(ErrorCanceled)
+			}
+			t.backend.breaker.release(trial)
+		case abandoned:
+			t.backend.logBreaker(t.backend.breaker.record(trial, true, time.Now()))
+		default:
+			t.backend.passiveFailure(err)
+			t.backend.logBreaker(t.backend.breaker.record(trial, true, time.Now()))
 		}
 		return nil, err
 	}
+	failed := resp.StatusCode >= 500 && !t.backend.serverErrors.policy.ignores(req, resp.StatusCode)
+	t.backend.logBreaker(t.backend.breaker.record(trial, failed, time.Now()))
+	dl.watchBody(t.backend, resp)
 
-	// Check for 5xx errors which might indicate backend issues
-	if resp.StatusCode >= 500 {
-		log.Printf("[PASSIVE] Backend %s returned %d - marking as DOWN", t.backend.URL.String(), resp.StatusCode)
-		t.backend.SetAlive(false)
+	// gRPC calls are judged by their grpc-status instead
+	if !t.backend.watchGRPC(resp) {
+		t.backend.observeStatus(req, resp.StatusCode)
 	}
 
+	// The request stays in flight until the response body has been consumed
+	wrapBody(resp, done)
+	if rwc, ok := resp.Body.(io.ReadWriteCloser); ok && resp.StatusCode == http.StatusSwitchingProtocols {
+		// An upgraded connection stays in flight until the tunnel closes
+		resp.Body = t.backend.tunnels.add(rwc)
+	}
 	return resp, nil
 }
 
+// observeStatus checks a response status for 5xx errors which might
+// indicate backend issues; only a pattern of them, as the policy defines
+// it, marks the backend down
+func (b *Backend) observeStatus(req *http.Request, status int) {
+	if status >= 500 {
+		b.failures.Add(1)
+		b.recordError(Error5xx)
+	}
+	if reason := b.serverErrors.observe(req, status, time.Now()); reason != "" && !b.Pinned() {
+		b.logDedup.Log(b.logger, slog.LevelWarn, "5xx:"+b.URL.String()+":"+strconv.Itoa(status),
+			"backend returned server errors, marking DOWN", "component", "passive", "class", Error5xx, "status", status, "reason", reason)
+		b.UpdateHealth(false, Cause{Kind: CausePassive, Error: "status " + strconv.Itoa(status) + ": " + reason})
+	}
+}
+
+// passiveFailure counts a failed proxied request and marks the backend
+// down; connection errors and timeouts are taken as the backend being gone
+func (b *Backend) passiveFailure(err error) {
+	b.failures.Add(1)
+	class := ClassifyError(err)
+	b.recordError(class)
+	if b.IsAlive() && !b.Pinned() {
+		b.logDedup.Log(b.logger, slog.LevelWarn, "passive:"+b.URL.String()+":"+class,
+			"passive health check failed, marking DOWN", "component", "passive", "class", class, "error", err)
+		b.UpdateHealth(false, Cause{Kind: CausePassive, Error: class + ": " + err.Error()})
+	}
+}
+
+// modifyResponse names Nexus and the backend in the response headers. It
+// only runs for the response actually relayed to the client, so the headers
+// never point at a backend whose attempt failed. A response whose status
+// the attempt retries is turned into a failure instead.
+func (b *Backend) modifyResponse(resp *http.Response) error {
+	if attemptOf(resp.Request).retryStatus(resp) {
+		return &StatusError{Code: resp.StatusCode}
+	}
+	if !b.hideIdentity {
+		resp.Header.Set("X-Forwarded-By", "Nexus")
+		resp.Header.Set("X-Backend-Server", b.URL.String())
+	}
+	if v, ok := headers.VarsFrom(resp.Request.Context()); ok {
+		b.headerRules.ApplyResponse(resp.Header, v)
+		headers.RulesFrom(resp.Request.Context()).ApplyResponse(resp.Header, v)
+	}
+	return nil
+}
+
+// errorHandler hands a failed attempt back to a caller that will retry it;
+// otherwise it answers with 504 if the attempt timed out and 502 if not,
+// like ReverseProxy's default handler
+func (b *Backend) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	if a := attemptOf(r); a != nil && a.Retry {
+		a.Err = err
+		return
+	}
+	status := FailureStatus(err)
+	b.logger.Debug("proxy error", "method", r.Method, "path", r.URL.Path, "status", status, "error", err)
+	if !b.errorPages.Render(w, r, status) {
+		w.WriteHeader(status)
+	}
+}
+
+// WithWeight sets the backend's weight (default 1)
+func WithWeight(weight int) Option {
+	return func(b *Backend) {
+		b.Weight = weight
+	}
+}
+
+// WithFlushInterval sets how often responses from the backend are flushed
+// to the client while they are copied; a negative interval flushes after
+// every write. Server-sent events and responses of unknown length are
+// always flushed after every write.
+func WithFlushInterval(d time.Duration) Option {
+	return func(b *Backend) {
+		b.ReverseProxy.FlushInterval = d
+	}
+}
+
+// WithGroup places the backend in a named pool of a traffic split
+func WithGroup(group string) Option {
+	return func(b *Backend) {
+		b.Group = group
+	}
+}
+
+// WithOrigin records where the backend came from: one of the Origin
+// constants and, for a discovered backend, the source that found it
+func WithOrigin(origin, source string) Option {
+	return func(b *Backend) {
+		b.Origin = origin
+		b.Source = source
+	}
+}
+
+// WithLabels attaches labels to the backend
+func WithLabels(labels map[string]string) Option {
+	return func(b *Backend) {
+		b.Labels = labels
+	}
+}
+
+// WithTier places the backend in a tier of its pool; 0, the default, is the
+// primary tier
+func WithTier(tier int) Option {
+	return func(b *Backend) {
+		b.Tier = tier
+	}
+}
+
+// WithHealthInterval pins the backend's active health check interval
+func WithHealthInterval(d time.Duration) Option {
+	return func(b *Backend) {
+		b.HealthInterval = d
+	}
+}
+
+// WithExternalHealth exempts the backend from active health checks
+func WithExternalHealth() Option {
+	return func(b *Backend) {
+		b.ExternalHealth = true
+	}
+}
+
+// WithLogger sets the logger the backend reports through (default
+// slog.Default())
+func WithLogger(l *slog.Logger) Option {
+	return func(b *Backend) {
+		b.logger = l
+	}
+}
+
+// WithLogDedup collapses repeats of the backend's passive failure log lines
+// within the deduper's window; failures are still counted individually
+func WithLogDedup(d *logdedup.Deduper) Option {
+	return func(b *Backend) {
+		b.logDedup = d
+	}
+}
+
+// WithTrustedProxies limits whose X-Forwarded-* headers are passed on to
+// the backend; by default only loopback peers' are
+func WithTrustedProxies(t TrustedProxies) Option {
+	return func(b *Backend) {
+		b.trusted = t
+	}
+}
+
+// WithRealIP sets X-Real-IP on every proxied request to the client IP
+// resolved through the trusted proxies
+func WithRealIP() Option {
+	return func(b *Backend) {
+		b.realIP = true
+	}
+}
+
+// WithClientCertHeader sets X-Forwarded-Client-Cert on every proxied
+// request to the certificate the client presented over TLS, if any
+func WithClientCertHeader() Option {
+	return func(b *Backend) {
+		b.clientCert = true
+	}
+}
+
+// WithoutIdentityHeaders stops the backend's responses from carrying the
+// X-Forwarded-By and X-Backend-Server headers
+func WithoutIdentityHeaders() Option {
+	return func(b *Backend) {
+		b.hideIdentity = true
+	}
+}
+
+// WithErrorPages answers failed attempts with the configured error pages
+// instead of an empty 502 or 504
+func WithErrorPages(p *errorpage.Pages) Option {
+	return func(b *Backend) {
+		b.errorPages = p
+	}
+}
+
+// WithServerErrorPolicy sets when 5xx responses mark the backend down
+// (default DefaultServerErrorPolicy)
+func WithServerErrorPolicy(p ServerErrorPolicy) Option {
+	return func(b *Backend) {
+		b.serverErrors.policy = p
+	}
+}
+
+// WithHeaderRules applies operator header rules to the backend's requests
+// and responses
+func WithHeaderRules(rs *headers.Rules) Option {
+	return func(b *Backend) {
+		b.headerRules = rs
+	}
+}
+
+// WithTransport sets the initial transport settings of the backend
+func WithTransport(s TransportSettings) Option {
+	return func(b *Backend) {
+		b.SetTransport(s)
+	}
+}
+
 // NewBackend creates a new Backend instance from a URL string
-func NewBackend(urlStr string) (*Backend, error) {
+func NewBackend(urlStr string, opts ...Option) (*Backend, error) {
 	parsedURL, err := url.Parse(urlStr)
 	if err != nil {
 		return nil, err
@@ -69,26 +605,28 @@ func NewBackend(urlStr string) (*Backend, error) {
 	backend := &Backend{
 		URL:          parsedURL,
 		Alive:        true,
-		ReverseProxy: httputil.NewSingleHostReverseProxy(parsedURL),
+		Weight:       1,
+		Origin:       OriginStatic,
+		ReverseProxy: &httputil.ReverseProxy{},
+		logger:       slog.Default(),
 	}
+	backend.serverErrors.policy = DefaultServerErrorPolicy()
+	backend.ReverseProxy.Rewrite = backend.rewrite
+	backend.ReverseProxy.ModifyResponse = backend.modifyResponse
+	backend.ReverseProxy.ErrorHandler = backend.errorHandler
 
-	// Create custom transport with passive health checking
-	defaultTransport := &http.Transport{
-		DialContext: (&net.Dialer{
-			Timeout:   5 * time.Second,
-			KeepAlive: 30 * time.Second,
-		}).DialContext,
-		MaxIdleConns:        100,
-		IdleConnTimeout:     90 * time.Second,
-		TLSHandshakeTimeout: 5 * time.Second,
+	for _, opt := range opts {
+		opt(backend)
 	}
+	backend.logger = backend.logger.With("backend", parsedURL.String())
 
-	// Wrap the transport with passive health checking
-	backend.ReverseProxy.Transport = &passiveHealthCheckTransport{
-		backend:   backend,
-		transport: defaultTransport,
+	if backend.transport.Load() == nil {
+		backend.SetTransport(DefaultTransportSettings())
 	}
 
-	log.Printf("Created backend %s with passive health check enabled", parsedURL.String())
+	// Wrap the transport with passive health checking
+	backend.ReverseProxy.Transport = &passiveHealthCheckTransport{backend: backend}
+
+	backend.logger.Debug("created backend with passive health check enabled")
 	return backend, nil
 }
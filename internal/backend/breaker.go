@@ -0,0 +1,214 @@
+package backend
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is the failure of an attempt refused because the backend's
+// circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// BreakerPolicy configures a backend's circuit breaker. The breaker works
+// alongside the alive state: it takes the backend out of rotation within
+// milliseconds of a burst of failures, for a short cooloff, while health
+// checks handle longer outages.
+type BreakerPolicy struct {
+	// Failures opens the circuit once this many requests failed within
+	// Window; zero disables the breaker
+	Failures int
+	Window   time.Duration
+	// Cooloff is how long the circuit stays open before it goes half-open
+	Cooloff time.Duration
+	// HalfOpenRequests is the number of trial requests let through while
+	// half-open; the circuit closes once they all succeed and opens again
+	// as soon as one fails
+	HalfOpenRequests int
+}
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// BreakerStats reports a backend's circuit breaker
+type BreakerStats struct {
+	State string `json:"state"`
+	// Opened, HalfOpened and Closed count the transitions into each state
+	Opened     uint64 `json:"opened"`
+	HalfOpened uint64 `json:"half_opened"`
+	Closed     uint64 `json:"closed"`
+}
+
+// breaker is the circuit breaker of a backend
+type breaker struct {
+	policy BreakerPolicy
+
+	mu       sync.Mutex
+	state    string
+	failures []time.Time // failures while closed, oldest first
+	openedAt time.Time
+	trials   int // trial requests let through since going half-open
+	passed   int // trial requests that succeeded
+
+	opened, halfOpened, closed uint64
+}
+
+func (c *breaker) enabled() bool {
+	return c.policy.Failures > 0
+}
+
+// allows reports whether a request would be let through at now
+func (c *breaker) allows(now time.Time) bool {
+	if !c.enabled() {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.state {
+	case BreakerOpen:
+		return now.Sub(c.openedAt) >= c.policy.Cooloff
+	case BreakerHalfOpen:
+		return c.trials < c.policy.HalfOpenRequests
+	}
+	return true
+}
+
+// admit lets a request through, or not, moving an open circuit whose
+// cooloff is over to half-open. It reports whether the request is a trial,
+// whose outcome must be passed to record or release.
+func (c *breaker) admit(now time.Time) (ok, trial bool, transition string) {
+	if !c.enabled() {
+		return true, false, ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == BreakerOpen {
+		if now.Sub(c.openedAt) < c.policy.Cooloff {
+			return false, false, ""
+		}
+		c.state, c.trials, c.passed = BreakerHalfOpen, 0, 0
+		c.halfOpened++
+		transition = BreakerHalfOpen
+	}
+	if c.state == BreakerHalfOpen {
+		if c.trials >= c.policy.HalfOpenRequests {
+			return false, false, transition
+		}
+		c.trials++
+		return true, true, transition
+	}
+	return true, false, ""
+}
+
+// record takes the outcome of an admitted request and returns the state
+// the circuit moved to, or "" if it stayed
+func (c *breaker) record(trial, failed bool, now time.Time) string {
+	if !c.enabled() {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.state {
+	case BreakerHalfOpen:
+		// Requests admitted before the circuit opened say nothing about
+		// the backend since
+		if !trial {
+			return ""
+		}
+		if failed {
+			c.open(now)
+			return BreakerOpen
+		}
+		if c.passed++; c.passed >= c.policy.HalfOpenRequests {
+			c.state = BreakerClosed
+			c.failures = c.failures[:0]
+			c.closed++
+			return BreakerClosed
+		}
+	case BreakerOpen:
+		return ""
+	default:
+		if !failed {
+			return ""
+		}
+		c.failures = append(c.failures, now)
+		cutoff := now.Add(-c.policy.Window)
+		for len(c.failures) > 0 && (c.failures[0].Before(cutoff) || len(c.failures) > c.policy.Failures) {
+			c.failures = c.failures[1:]
+		}
+		if len(c.failures) >= c.policy.Failures {
+			c.open(now)
+			return BreakerOpen
+		}
+	}
+	return ""
+}
+
+// release hands back a trial request that ended without a verdict on the
+// backend, such as one abandoned for another attempt
+func (c *breaker) release(trial bool) {
+	if !trial {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == BreakerHalfOpen {
+		c.trials--
+	}
+}
+
+// open trips the circuit; the caller holds mu
+func (c *breaker) open(now time.Time) {
+	c.state, c.openedAt = BreakerOpen, now
+	c.failures = c.failures[:0]
+	c.opened++
+}
+
+func (c *breaker) stats() BreakerStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := c.state
+	if state == "" {
+		state = BreakerClosed
+	}
+	return BreakerStats{State: state, Opened: c.opened, HalfOpened: c.halfOpened, Closed: c.closed}
+}
+
+// BreakerAllows reports whether the backend's circuit breaker would let a
+// request through now
+func (b *Backend) BreakerAllows() bool {
+	return b.breaker.allows(time.Now())
+}
+
+// Breaker reports the backend's circuit breaker, or nil if it has none
+func (b *Backend) Breaker() *BreakerStats {
+	if !b.breaker.enabled() {
+		return nil
+	}
+	stats := b.breaker.stats()
+	return &stats
+}
+
+// logBreaker logs a transition of the circuit breaker
+func (b *Backend) logBreaker(transition string) {
+	switch transition {
+	case BreakerOpen:
+		b.logger.Warn("circuit breaker opened", "component", "breaker", "cooloff", b.breaker.policy.Cooloff)
+	case BreakerHalfOpen:
+		b.logger.Info("circuit breaker half-open, sending trial requests", "component", "breaker",
+			"trials", b.breaker.policy.HalfOpenRequests)
+	case BreakerClosed:
+		b.logger.Info("circuit breaker closed", "component", "breaker")
+	}
+}
+
+// WithBreaker gives the backend a circuit breaker
+func WithBreaker(p BreakerPolicy) Option {
+	return func(b *Backend) {
+		b.breaker.policy = p
+	}
+}
@@ -0,0 +1,71 @@
+package backend
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// connCounters counts the connections a backend's transports have dialed,
+// across transport generations
+type connCounters struct {
+	open   atomic.Int64
+	dialed atomic.Uint64
+	closed atomic.Uint64
+}
+
+// dialContext wraps a dial function so every connection it makes is counted
+func (c *connCounters) dialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		c.dialed.Add(1)
+		c.open.Add(1)
+		return &countedConn{Conn: conn, counters: c}, nil
+	}
+}
+
+// countedConn decrements the open count once when closed
+type countedConn struct {
+	net.Conn
+	counters *connCounters
+	once     sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		c.counters.open.Add(-1)
+		c.counters.closed.Add(1)
+	})
+	return c.Conn.Close()
+}
+
+// ConnStats counts a backend's connections
+type ConnStats struct {
+	// Open connections, and of those an estimate of the idle ones: open
+	// connections beyond the requests in flight
+	Open int64 `json:"open"`
+	Idle int64 `json:"idle"`
+	// Dialed and Closed are running totals
+	Dialed uint64 `json:"dialed"`
+	Closed uint64 `json:"closed"`
+	// Upgraded are open connections tunneling an upgraded protocol, such
+	// as WebSocket
+	Upgraded int `json:"upgraded"`
+}
+
+// Conns returns the backend's connection counts
+func (b *Backend) Conns() ConnStats {
+	open := b.conns.open.Load()
+	return ConnStats{
+		Open:   open,
+		Idle:   max(open-b.active.Load(), 0),
+		Dialed: b.conns.dialed.Load(),
+		Closed: b.conns.closed.Load(),
+
+		Upgraded: b.tunnels.count(),
+	}
+}
@@ -0,0 +1,80 @@
+package backend
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+)
+
+// Classes of failed proxied requests
+const (
+	ErrorRefused  = "refused"  // connection refused
+	ErrorTimeout  = "timeout"  // dial, TLS handshake, response or upstream timeout
+	ErrorReset    = "reset"    // connection reset or closed mid-response
+	ErrorDNS      = "dns"      // backend host name did not resolve
+	ErrorTLS      = "tls"      // TLS handshake or certificate failure
+	ErrorCanceled = "canceled" // client went away before the backend answered
+	ErrorOther    = "other"    // any other transport error
+	Error5xx      = "5xx"      // backend answered with a server error
+)
+
+// ErrorClasses lists every error class in a stable order
+var ErrorClasses = [...]string{
+	ErrorRefused, ErrorTimeout, ErrorReset, ErrorDNS, ErrorTLS, ErrorCanceled, ErrorOther, Error5xx,
+}
+
+// ClassifyError sorts a transport error into one of the error classes
+func ClassifyError(err error) string {
+	var (
+		dnsErr    *net.DNSError
+		netErr    net.Error
+		certErr   *tls.CertificateVerificationError
+		recordErr tls.RecordHeaderError
+		unknownCA x509.UnknownAuthorityError
+		hostErr   x509.HostnameError
+		invalid   x509.CertificateInvalidError
+	)
+	switch {
+	case errors.Is(err, ErrUpstreamTimeout):
+		return ErrorTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrorCanceled
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorRefused
+	case errors.As(err, &dnsErr):
+		return ErrorDNS
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrorReset
+	case errors.As(err, &certErr), errors.As(err, &recordErr),
+		errors.As(err, &unknownCA), errors.As(err, &hostErr), errors.As(err, &invalid):
+		return ErrorTLS
+	}
+	return ErrorOther
+}
+
+// recordError counts a failed request of the given class
+func (b *Backend) recordError(class string) {
+	for i, c := range ErrorClasses {
+		if c == class {
+			b.errorCounts[i].Add(1)
+			return
+		}
+	}
+}
+
+// Errors returns the number of failed proxied requests by error class
+func (b *Backend) Errors() map[string]uint64 {
+	counts := make(map[string]uint64, len(ErrorClasses))
+	for i, c := range ErrorClasses {
+		counts[c] = b.errorCounts[i].Load()
+	}
+	return counts
+}
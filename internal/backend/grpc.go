@@ -0,0 +1,71 @@
+package backend
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// isGRPC reports whether a response carries a gRPC call, whose outcome is
+// in its grpc-status rather than its HTTP status
+func isGRPC(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "application/grpc" || strings.HasPrefix(mediaType, "application/grpc+")
+}
+
+// grpcHTTPStatus maps a grpc-status to the HTTP status passive health
+// checks judge it by. Codes that blame the server map to the 5xx status
+// gRPC's own HTTP mapping gives them; everything else, client and
+// application errors included, counts as a success.
+func grpcHTTPStatus(code string) int {
+	switch code {
+	case "2", "13", "15": // UNKNOWN, INTERNAL, DATA_LOSS
+		return http.StatusInternalServerError
+	case "4": // DEADLINE_EXCEEDED
+		return http.StatusGatewayTimeout
+	case "14": // UNAVAILABLE
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusOK
+}
+
+// grpcBody judges a gRPC call by the grpc-status trailer once the response
+// body has been read to its end
+type grpcBody struct {
+	io.ReadCloser
+	resp    *http.Response
+	backend *Backend
+	once    sync.Once
+}
+
+func (gb *grpcBody) Read(p []byte) (int, error) {
+	n, err := gb.ReadCloser.Read(p)
+	if err == io.EOF {
+		gb.once.Do(func() {
+			// A stream that ends without a status was cut off
+			status := http.StatusBadGateway
+			if code := gb.resp.Trailer.Get("Grpc-Status"); code != "" {
+				status = grpcHTTPStatus(code)
+			}
+			gb.backend.observeStatus(gb.resp.Request, status)
+		})
+	}
+	return n, err
+}
+
+// watchGRPC arranges for the status of a gRPC call to be observed. It
+// reports false if resp is not one, leaving the HTTP status to be judged.
+func (b *Backend) watchGRPC(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusOK || !isGRPC(resp) {
+		return false
+	}
+	if code := resp.Header.Get("Grpc-Status"); code != "" {
+		// A trailers-only response carries its status in the headers
+		b.observeStatus(resp.Request, grpcHTTPStatus(code))
+		return true
+	}
+	resp.Body = &grpcBody{ReadCloser: resp.Body, resp: resp, backend: b}
+	return true
+}
@@ -0,0 +1,63 @@
+package backend
+
+import "fmt"
+
+// Override is an operator-set state that pins a backend up or down,
+// overriding both active and passive health checks
+type Override int32
+
+const (
+	// OverrideAuto leaves the backend's state to the health checks
+	OverrideAuto Override = iota
+	// OverrideUp pins the backend in rotation
+	OverrideUp
+	// OverrideDown pins the backend out of rotation
+	OverrideDown
+)
+
+// String returns the API name of the override
+func (o Override) String() string {
+	switch o {
+	case OverrideUp:
+		return "up"
+	case OverrideDown:
+		return "down"
+	default:
+		return "auto"
+	}
+}
+
+// ParseOverride parses "up", "down" or "auto"
+func ParseOverride(s string) (Override, error) {
+	switch s {
+	case "up":
+		return OverrideUp, nil
+	case "down":
+		return OverrideDown, nil
+	case "auto":
+		return OverrideAuto, nil
+	}
+	return OverrideAuto, fmt.Errorf("state must be \"up\", \"down\" or \"auto\", got %q", s)
+}
+
+// SetOverride pins the backend up or down, or with OverrideAuto hands its
+// state back to the health checks
+func (b *Backend) SetOverride(o Override) {
+	b.override.Store(int32(o))
+	switch o {
+	case OverrideUp:
+		b.setAlive(true, Cause{Kind: CauseOverride})
+	case OverrideDown:
+		b.setAlive(false, Cause{Kind: CauseOverride})
+	}
+}
+
+// Override returns the backend's current manual override
+func (b *Backend) Override() Override {
+	return Override(b.override.Load())
+}
+
+// Pinned reports whether an operator override currently pins the backend's state
+func (b *Backend) Pinned() bool {
+	return b.Override() != OverrideAuto
+}
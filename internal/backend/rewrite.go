@@ -0,0 +1,169 @@
+package backend
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/nexus-lb/nexus/internal/headers"
+)
+
+// forwardingHeaders are the client-provided forwarding headers ReverseProxy
+// strips before Rewrite; Nexus passes them through from trusted proxies
+var forwardingHeaders = []string{"Forwarded", "X-Forwarded-Host", "X-Forwarded-Proto"}
+
+// rewrite routes an outbound request to the backend while forwarding the
+// request target exactly as the client sent it. Routing decisions are made
+// on the decoded r.URL.Path, but the bytes on the wire stay untouched:
+// %2F remains %2F, raw UTF-8 is not re-escaped and the query string is never
+// re-encoded. It also sets the X-Forwarded-* headers, keeping those a
+// trusted proxy sent and replacing those from anyone else.
+func (b *Backend) rewrite(pr *httputil.ProxyRequest) {
+	in, out := pr.In, pr.Out
+	target := b.URL
+
+	// ReverseProxy normalizes unparsable queries before calling Rewrite; undo it
+	out.URL.RawQuery = in.URL.RawQuery
+	if target.RawQuery != "" {
+		if out.URL.RawQuery == "" {
+			out.URL.RawQuery = target.RawQuery
+		} else {
+			out.URL.RawQuery = target.RawQuery + "&" + out.URL.RawQuery
+		}
+	}
+
+	out.URL.Scheme = target.Scheme
+	out.URL.Host = target.Host
+
+	escaped := singleJoiningSlash(target.EscapedPath(), rawRequestPath(in))
+	out.URL.Path = singleJoiningSlash(target.Path, in.URL.Path)
+	out.URL.RawPath = escaped
+	out.URL.Opaque = ""
+
+	// url.URL ignores RawPath when it is not a valid encoding of Path (raw
+	// UTF-8, for instance) and would re-escape it; Opaque is sent verbatim
+	if out.URL.EscapedPath() != escaped && !strings.HasPrefix(escaped, "//") {
+		out.URL.Opaque = escaped
+	}
+
+	trusted := b.trusted.Trusts(in.RemoteAddr)
+	if trusted {
+		for _, h := range forwardingHeaders {
+			if v := in.Header[h]; v != nil {
+				out.Header[h] = v
+			}
+		}
+	}
+	if out.Header.Get("X-Forwarded-Host") == "" {
+		out.Header.Set("X-Forwarded-Host", in.Host)
+	}
+	if out.Header.Get("X-Forwarded-Proto") == "" {
+		proto := "http"
+		if in.TLS != nil {
+			proto = "https"
+		}
+		out.Header.Set("X-Forwarded-Proto", proto)
+	}
+	appendForwardedFor(out, in, trusted)
+	if b.realIP {
+		out.Header.Set("X-Real-IP", b.trusted.ClientIP(in))
+	}
+	if b.clientCert {
+		// A trusted proxy's header stands unless the client connected with
+		// a certificate of its own
+		if !trusted {
+			out.Header.Del("X-Forwarded-Client-Cert")
+		}
+		if in.TLS != nil && len(in.TLS.PeerCertificates) > 0 {
+			out.Header.Set("X-Forwarded-Client-Cert", clientCertHeader(in.TLS.PeerCertificates[0]))
+		}
+	}
+
+	// Operator header rules come last so they can override any of the
+	// above, those of the request's route after those of the backend
+	routeRules := headers.RulesFrom(in.Context())
+	if b.headerRules != nil || routeRules != nil {
+		v := headers.Vars{
+			ClientIP:  b.trusted.ClientIP(in),
+			Host:      in.Host,
+			RequestID: in.Header.Get("X-Request-Id"),
+			Path:      in.URL.Path,
+		}
+		b.headerRules.ApplyRequest(out.Header, v)
+		routeRules.ApplyRequest(out.Header, v)
+		if b.headerRules.HasResponseRules() || routeRules.HasResponseRules() {
+			pr.Out = out.WithContext(headers.WithVars(out.Context(), v))
+		}
+	}
+}
+
+// clientCertHeader describes cert in the X-Forwarded-Client-Cert format of
+// Envoy: its SHA-256 hash, subject and SAN URIs and DNS names
+func clientCertHeader(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	var b strings.Builder
+	b.WriteString("Hash=")
+	b.WriteString(hex.EncodeToString(sum[:]))
+	b.WriteString(`;Subject="`)
+	b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(cert.Subject.String()))
+	b.WriteByte('"')
+	for _, u := range cert.URIs {
+		b.WriteString(";URI=")
+		b.WriteString(u.String())
+	}
+	for _, name := range cert.DNSNames {
+		b.WriteString(";DNS=")
+		b.WriteString(name)
+	}
+	return b.String()
+}
+
+// rawRequestPath returns the path of the request target as the client sent
+// it, or the escaped form of r.URL.Path if the path has been changed since
+// the request was parsed
+func rawRequestPath(r *http.Request) string {
+	raw := r.RequestURI
+	if i := strings.IndexByte(raw, '?'); i >= 0 {
+		raw = raw[:i]
+	}
+	if !strings.HasPrefix(raw, "/") {
+		// Absolute-form ("http://host/path") or asterisk-form targets
+		return r.URL.EscapedPath()
+	}
+	if decoded, err := url.PathUnescape(raw); err != nil || decoded != r.URL.Path {
+		return r.URL.EscapedPath()
+	}
+	return raw
+}
+
+// singleJoiningSlash joins two paths with exactly one slash between them
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash && b != "":
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// appendForwardedFor appends the client IP to the inbound X-Forwarded-For
+// chain, matching the behavior of ReverseProxy's Director mode. The chain
+// of an untrusted client is discarded and replaced by its own address.
+func appendForwardedFor(out, in *http.Request, trusted bool) {
+	clientIP, _, err := net.SplitHostPort(in.RemoteAddr)
+	if err != nil {
+		return
+	}
+	if prior := in.Header["X-Forwarded-For"]; trusted && len(prior) > 0 {
+		clientIP = strings.Join(prior, ", ") + ", " + clientIP
+	}
+	out.Header.Set("X-Forwarded-For", clientIP)
+}
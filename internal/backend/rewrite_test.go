@@ -0,0 +1,67 @@
+package backend
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// rawGet sends a GET of target, exactly as given, to addr and returns the
+// response body
+func rawGet(t *testing.T, addr, target string) (int, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET "+target+" HTTP/1.1\r\nHost: nexus\r\nConnection: close\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestRequestTargetForwardedVerbatim(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.RequestURI)
+	}))
+	defer up.Close()
+	b, err := NewBackend(up.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	front := httptest.NewServer(b.ReverseProxy)
+	defer front.Close()
+	addr := front.Listener.Addr().String()
+
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{"encoded slash", "/files/a%2Fb"},
+		{"lower-case escape", "/files/a%2fb"},
+		{"double-encoded slash", "/files/a%252Fb"},
+		{"plus in path and query", "/a+b/c?q=a+b&r=%2B"},
+		{"raw UTF-8", "/caf\xc3\xa9?q=\xc3\xa9"},
+		{"encoded unicode", "/caf%C3%A9?q=%C3%A9"},
+		{"overlong encoding", "/%C0%AF/etc?x=%C0%AF"},
+		{"semicolon in query", "/p?a=1;b=2"},
+		{"unparsable query", "/p?a=%zz&b&=%"},
+		{"reserved characters", "/p/@:!$&'()*,=?k=v?w/x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, got := rawGet(t, addr, tt.target)
+			if status != http.StatusOK || got != tt.target {
+				t.Errorf("backend got %d %q, want %q", status, got, tt.target)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package backend
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ServerErrorPolicy decides when 5xx responses take a backend out of
+// rotation. Connection errors still do so at once; a server error only does
+// once it repeats, since one bad endpoint says little about the backend.
+type ServerErrorPolicy struct {
+	// Consecutive marks the backend down after this many 5xx responses in
+	// a row; zero disables the rule
+	Consecutive int
+	// ErrorRate marks the backend down once this fraction of its responses
+	// over the last Window were 5xx, provided there were at least
+	// MinRequests of them; zero disables the rule
+	ErrorRate   float64
+	Window      time.Duration
+	MinRequests int
+	// Ignore lists 5xx responses that do not count against the backend
+	Ignore []ServerErrorIgnore
+}
+
+// ServerErrorIgnore excludes the listed statuses, on paths starting with
+// PathPrefix if it is set, from counting against a backend
+type ServerErrorIgnore struct {
+	Statuses   []int
+	PathPrefix string
+}
+
+// DefaultServerErrorPolicy marks a backend down after 5 server errors in a row
+func DefaultServerErrorPolicy() ServerErrorPolicy {
+	return ServerErrorPolicy{Consecutive: 5}
+}
+
+// ignores reports whether a 5xx response to req is excluded from counting
+func (p *ServerErrorPolicy) ignores(req *http.Request, status int) bool {
+	for _, ig := range p.Ignore {
+		if slices.Contains(ig.Statuses, status) && strings.HasPrefix(req.URL.Path, ig.PathPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateSlots is the number of slots the error rate window is split into
+const rateSlots = 10
+
+// serverErrors tracks the responses of a backend against its policy
+type serverErrors struct {
+	policy      ServerErrorPolicy
+	consecutive atomic.Int64
+	slots       [rateSlots]rateSlot
+}
+
+// rateSlot counts the responses of one tenth of the window
+type rateSlot struct {
+	epoch  atomic.Int64
+	total  atomic.Uint64
+	errors atomic.Uint64
+}
+
+// slotLength is the time one slot covers
+func (s *serverErrors) slotLength() int64 {
+	return max(int64(s.policy.Window)/rateSlots, 1)
+}
+
+// observe records a response and returns why the backend should be marked
+// down, or "" if it should not
+func (s *serverErrors) observe(req *http.Request, status int, now time.Time) string {
+	isError := status >= 500 && !s.policy.ignores(req, status)
+
+	if s.policy.ErrorRate > 0 {
+		epoch := now.UnixNano() / s.slotLength()
+		slot := &s.slots[epoch%rateSlots]
+		if old := slot.epoch.Load(); old != epoch && slot.epoch.CompareAndSwap(old, epoch) {
+			slot.total.Store(0)
+			slot.errors.Store(0)
+		}
+		slot.total.Add(1)
+		if isError {
+			slot.errors.Add(1)
+		}
+	}
+
+	if !isError {
+		if status < 500 {
+			s.consecutive.Store(0)
+		}
+		return ""
+	}
+
+	if n := s.consecutive.Add(1); s.policy.Consecutive > 0 && n >= int64(s.policy.Consecutive) {
+		s.reset()
+		return fmt.Sprintf("%d consecutive server errors", n)
+	}
+	if s.policy.ErrorRate > 0 {
+		total, errors := s.counts(now)
+		if total >= uint64(max(s.policy.MinRequests, 1)) && float64(errors) >= s.policy.ErrorRate*float64(total) {
+			s.reset()
+			return fmt.Sprintf("%d of %d responses were server errors", errors, total)
+		}
+	}
+	return ""
+}
+
+// counts sums the responses and errors within the window
+func (s *serverErrors) counts(now time.Time) (total, errors uint64) {
+	current := now.UnixNano() / s.slotLength()
+	for i := range s.slots {
+		slot := &s.slots[i]
+		if current-slot.epoch.Load() < rateSlots {
+			total += slot.total.Load()
+			errors += slot.errors.Load()
+		}
+	}
+	return total, errors
+}
+
+// reset forgets past responses once they have taken the backend down, so it
+// starts over when it recovers
+func (s *serverErrors) reset() {
+	s.consecutive.Store(0)
+	for i := range s.slots {
+		s.slots[i].total.Store(0)
+		s.slots[i].errors.Store(0)
+	}
+}
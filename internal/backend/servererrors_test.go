@@ -0,0 +1,142 @@
+package backend
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOneServerErrorKeepsBackendUp(t *testing.T) {
+	var n atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.Add(1) == 50 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer upstream.Close()
+
+	b, err := NewBackend(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 100 {
+		b.ReverseProxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	if n.Load() != 100 {
+		t.Fatalf("backend served %d requests, want 100", n.Load())
+	}
+	if !b.IsAlive() {
+		t.Error("one 500 among 100 requests marked the backend down")
+	}
+}
+
+func TestConnectionErrorMarksDown(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	upstream.Close()
+
+	b, err := NewBackend(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.ReverseProxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if b.IsAlive() {
+		t.Error("backend refusing connections still up")
+	}
+}
+
+func TestClientCancelKeepsBackendUp(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+
+	b, err := NewBackend(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Clients giving up before the backend answers say nothing about it
+	for range 10 {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		b.ReverseProxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	}
+	if !b.IsAlive() {
+		t.Error("cancelled requests marked the backend down")
+	}
+	if b.Failures() != 0 {
+		t.Errorf("%d passive failures, want 0", b.Failures())
+	}
+	if got := b.Errors()[ErrorCanceled]; got != 10 {
+		t.Errorf("%d cancelled requests counted, want 10", got)
+	}
+}
+
+// observeAll feeds the statuses to s at now and returns the first reason
+// to mark the backend down, with the index of the response that gave it
+func observeAll(s *serverErrors, path string, statuses []int, now time.Time) (string, int) {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	for i, status := range statuses {
+		if reason := s.observe(r, status, now); reason != "" {
+			return reason, i
+		}
+	}
+	return "", -1
+}
+
+func TestConsecutivePolicy(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	s := &serverErrors{policy: ServerErrorPolicy{Consecutive: 3}}
+
+	// A success in between starts the count over
+	if reason, _ := observeAll(s, "/", []int{500, 502, 200, 500, 503}, now); reason != "" {
+		t.Fatalf("marked down by %q without 3 errors in a row", reason)
+	}
+	if reason, i := observeAll(s, "/", []int{500}, now); reason == "" || i != 0 {
+		t.Fatal("third consecutive error did not mark the backend down")
+	}
+	// Counting starts over once the backend has been marked down
+	if reason, _ := observeAll(s, "/", []int{500, 500}, now); reason != "" {
+		t.Errorf("marked down by %q right after a reset", reason)
+	}
+}
+
+func TestIgnoredStatuses(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	s := &serverErrors{policy: ServerErrorPolicy{
+		Consecutive: 2,
+		Ignore: []ServerErrorIgnore{
+			{Statuses: []int{501}},
+			{Statuses: []int{500}, PathPrefix: "/broken"},
+		},
+	}}
+	if reason, _ := observeAll(s, "/", []int{501, 501, 501}, now); reason != "" {
+		t.Errorf("ignored 501s marked the backend down: %q", reason)
+	}
+	if reason, _ := observeAll(s, "/broken/endpoint", []int{500, 500, 500}, now); reason != "" {
+		t.Errorf("500s on an ignored path marked the backend down: %q", reason)
+	}
+	if reason, _ := observeAll(s, "/", []int{500, 500}, now); reason == "" {
+		t.Error("500s elsewhere did not mark the backend down")
+	}
+}
+
+func TestErrorRatePolicy(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	s := &serverErrors{policy: ServerErrorPolicy{ErrorRate: 0.5, Window: 10 * time.Second, MinRequests: 10}}
+
+	// Too few responses to judge by
+	if reason, _ := observeAll(s, "/", []int{500, 500, 500, 500}, now); reason != "" {
+		t.Fatalf("marked down by %q under MinRequests", reason)
+	}
+	// Errors that have left the window no longer count
+	later := now.Add(11 * time.Second)
+	if reason, _ := observeAll(s, "/", []int{200, 200, 200, 200, 200, 200, 500, 200, 200, 200}, later); reason != "" {
+		t.Fatalf("marked down by %q at a 10%% error rate", reason)
+	}
+	if reason, i := observeAll(s, "/", []int{500, 500, 500, 500, 500, 500, 500, 500}, later); reason == "" || i != 7 {
+		t.Errorf("reason %q after %d errors, want a mark down when half the responses failed", reason, i+1)
+	}
+}
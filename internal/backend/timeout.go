@@ -0,0 +1,132 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrUpstreamTimeout is the error of proxied attempts cut off by the
+// backend's request or stream idle timeout
+var ErrUpstreamTimeout = errors.New("upstream timeout")
+
+// deadline enforces the upstream timeouts of one attempt through its
+// request context. It starts out capping the whole attempt and, for
+// streaming responses, turns into an idle timeout once headers arrive.
+type deadline struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	timer  *time.Timer
+	idle   time.Duration
+}
+
+// startDeadline applies the settings' timeouts to req; it returns a nil
+// deadline if there are none
+func startDeadline(req *http.Request, s TransportSettings) (*http.Request, *deadline) {
+	if s.RequestTimeout <= 0 && s.StreamIdleTimeout <= 0 {
+		return req, nil
+	}
+	ctx, cancel := context.WithCancelCause(req.Context())
+	d := &deadline{ctx: ctx, cancel: cancel, idle: s.StreamIdleTimeout}
+	if s.RequestTimeout > 0 {
+		d.timer = time.AfterFunc(s.RequestTimeout, d.expire)
+	}
+	return req.WithContext(ctx), d
+}
+
+// expire cuts the attempt off
+func (d *deadline) expire() {
+	d.cancel(ErrUpstreamTimeout)
+}
+
+// reset restarts the timer to fire after t
+func (d *deadline) reset(t time.Duration) {
+	if d.timer == nil {
+		d.timer = time.AfterFunc(t, d.expire)
+		return
+	}
+	d.timer.Reset(t)
+}
+
+// expired reports whether the attempt was cut off by a timeout
+func (d *deadline) expired() bool {
+	return d != nil && errors.Is(context.Cause(d.ctx), ErrUpstreamTimeout)
+}
+
+// timedOut reports whether req was cut off by a timeout, the backend's own
+// or one set further up, such as a route's
+func timedOut(req *http.Request) bool {
+	return errors.Is(context.Cause(req.Context()), ErrUpstreamTimeout)
+}
+
+// stop ends the attempt and releases its context
+func (d *deadline) stop() {
+	if d == nil {
+		return
+	}
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.cancel(nil)
+}
+
+// watchBody keeps timing the response body. Streaming bodies trade the
+// total cap for the idle timeout; upgraded connections are not timed at all.
+func (d *deadline) watchBody(b *Backend, resp *http.Response) {
+	if d == nil {
+		return
+	}
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		if d.timer != nil {
+			d.timer.Stop()
+		}
+		return
+	}
+	body := &timeoutBody{ReadCloser: resp.Body, backend: b, deadline: d}
+	if streaming(resp) {
+		if d.timer != nil {
+			d.timer.Stop()
+		}
+		if d.idle > 0 {
+			body.idle = d.idle
+			d.reset(d.idle)
+		}
+	}
+	resp.Body = body
+}
+
+// streaming reports whether a response is a stream rather than a document
+func streaming(resp *http.Response) bool {
+	if resp.ContentLength < 0 {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// timeoutBody reports reads cut off by the deadline as upstream timeouts,
+// counting them against the backend, and pushes the idle timeout back on
+// every read that makes progress
+type timeoutBody struct {
+	io.ReadCloser
+	backend  *Backend
+	deadline *deadline
+	idle     time.Duration
+	once     sync.Once
+}
+
+func (tb *timeoutBody) Read(p []byte) (int, error) {
+	n, err := tb.ReadCloser.Read(p)
+	if n > 0 && tb.idle > 0 {
+		tb.deadline.reset(tb.idle)
+	}
+	if err != nil && err != io.EOF && tb.deadline.expired() {
+		err = ErrUpstreamTimeout
+		tb.once.Do(func() { tb.backend.passiveFailure(err) })
+	}
+	return n, err
+}
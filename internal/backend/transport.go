@@ -0,0 +1,228 @@
+package backend
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TransportSettings configures the HTTP transport used to reach a backend
+type TransportSettings struct {
+	DialTimeout           time.Duration
+	KeepAlive             time.Duration
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int
+	IdleConnTimeout       time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	RequestTimeout        time.Duration
+	StreamIdleTimeout     time.Duration
+	InsecureSkipVerify    bool
+
+	// Protocol is the HTTP version spoken to the backend: ProtocolHTTP1
+	// (the default), ProtocolH2C or ProtocolH2
+	Protocol string
+}
+
+// Protocols a backend can be reached over
+const (
+	ProtocolHTTP1 = "http1" // HTTP/1.1
+	ProtocolH2C   = "h2c"   // HTTP/2 without TLS, with prior knowledge
+	ProtocolH2    = "h2"    // HTTP/2 over TLS
+)
+
+// DefaultTransportSettings returns the transport settings Nexus has always used
+func DefaultTransportSettings() TransportSettings {
+	return TransportSettings{
+		DialTimeout:         5 * time.Second,
+		KeepAlive:           30 * time.Second,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 5 * time.Second,
+	}
+}
+
+// newHTTPTransport builds an http.Transport from the settings, counting the
+// connections it dials in conns
+func newHTTPTransport(s TransportSettings, conns *connCounters) *http.Transport {
+	t := &http.Transport{
+		DialContext: conns.dialContext((&net.Dialer{
+			Timeout:   s.DialTimeout,
+			KeepAlive: s.KeepAlive,
+		}).DialContext),
+		MaxIdleConns:          s.MaxIdleConns,
+		MaxIdleConnsPerHost:   s.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       s.MaxConnsPerHost,
+		IdleConnTimeout:       s.IdleConnTimeout,
+		TLSHandshakeTimeout:   s.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: s.ResponseHeaderTimeout,
+	}
+	if s.InsecureSkipVerify {
+		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	switch s.Protocol {
+	case ProtocolH2C:
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetUnencryptedHTTP2(true)
+	case ProtocolH2:
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetHTTP2(true)
+	}
+	return t
+}
+
+// backendsWithRetiredTransports counts backends that still have an old
+// transport waiting for its in-flight requests to finish
+var backendsWithRetiredTransports atomic.Int64
+
+// BackendsWithRetiredTransports returns how many backends are still draining
+// an old transport after a configuration change
+func BackendsWithRetiredTransports() int64 {
+	return backendsWithRetiredTransports.Load()
+}
+
+// transportRef is one generation of a backend's transport. It counts the
+// round trips using it so a replaced generation can be released only once
+// they have all completed, including reading their response bodies.
+type transportRef struct {
+	backend   *Backend
+	transport *http.Transport
+	settings  TransportSettings
+	inflight  atomic.Int64
+	retired   atomic.Bool
+	closeOnce sync.Once
+}
+
+// acquireTransport returns the current transport generation with its
+// in-flight count incremented; callers must release it when done
+func (b *Backend) acquireTransport() *transportRef {
+	for {
+		ref := b.transport.Load()
+		ref.inflight.Add(1)
+		if !ref.retired.Load() {
+			return ref
+		}
+		// Swapped out between Load and Add; try the new generation
+		ref.release()
+	}
+}
+
+// release ends one round trip; the last one out of a retired generation
+// closes its idle connections
+func (r *transportRef) release() {
+	if r.inflight.Add(-1) == 0 && r.retired.Load() {
+		r.close()
+	}
+}
+
+// close releases a retired generation exactly once
+func (r *transportRef) close() {
+	r.closeOnce.Do(func() {
+		r.transport.CloseIdleConnections()
+		if r.backend.retiredTransports.Add(-1) == 0 {
+			backendsWithRetiredTransports.Add(-1)
+		}
+		r.backend.logger.Info("old transport drained and released", "component", "transport")
+	})
+}
+
+// SetTransport swaps in a transport built from the given settings. New
+// requests use it immediately; the previous transport keeps serving its
+// in-flight requests and is released once they complete.
+func (b *Backend) SetTransport(s TransportSettings) {
+	ref := &transportRef{
+		backend:   b,
+		transport: newHTTPTransport(s, &b.conns),
+		settings:  s,
+	}
+
+	old := b.transport.Swap(ref)
+	if old == nil {
+		return
+	}
+
+	if b.retiredTransports.Add(1) == 1 {
+		backendsWithRetiredTransports.Add(1)
+	}
+	old.retired.Store(true)
+	inflight := old.inflight.Load()
+	b.logger.Info("transport configuration swapped", "component", "transport", "inflight_on_old", inflight)
+	if inflight == 0 {
+		old.close()
+	}
+}
+
+// TransportSettings returns the settings of the backend's current transport
+func (b *Backend) TransportSettings() TransportSettings {
+	return b.transport.Load().settings
+}
+
+// RetiredTransports returns how many old transports of this backend are
+// still draining
+func (b *Backend) RetiredTransports() int64 {
+	return b.retiredTransports.Load()
+}
+
+// ProbeTransport returns a RoundTripper sending requests over the backend's
+// current transport, with the TLS settings, timeouts and connection pool of
+// proxied requests. Its requests are not traffic: passive health checking
+// and the circuit breaker never see them.
+func (b *Backend) ProbeTransport() http.RoundTripper {
+	return probeTransport{b}
+}
+
+type probeTransport struct {
+	backend *Backend
+}
+
+// RoundTrip holds the transport generation it uses until the response body
+// is closed, so a swap in between does not close its connection
+func (t probeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ref := t.backend.acquireTransport()
+	resp, err := ref.transport.RoundTrip(req)
+	if err != nil {
+		ref.release()
+		return nil, err
+	}
+	wrapBody(resp, ref.release)
+	return resp, nil
+}
+
+// releasingBody runs a completion callback once the response body is closed
+type releasingBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (rb *releasingBody) Close() error {
+	err := rb.ReadCloser.Close()
+	rb.once.Do(rb.done)
+	return err
+}
+
+// releasingUpgradeBody is a releasingBody for 101 Switching Protocols
+// responses, whose body must stay writable for ReverseProxy to tunnel it
+type releasingUpgradeBody struct {
+	*releasingBody
+	w io.Writer
+}
+
+func (rb *releasingUpgradeBody) Write(p []byte) (int, error) {
+	return rb.w.Write(p)
+}
+
+// wrapBody calls done when the response body is closed
+func wrapBody(resp *http.Response, done func()) {
+	body := &releasingBody{ReadCloser: resp.Body, done: done}
+	if w, ok := resp.Body.(io.Writer); ok {
+		resp.Body = &releasingUpgradeBody{releasingBody: body, w: w}
+		return
+	}
+	resp.Body = body
+}
@@ -0,0 +1,76 @@
+package backend
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestSetTransportUnderTraffic(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	up := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stream" {
+			io.WriteString(w, r.Proto)
+			return
+		}
+		io.WriteString(w, "first part\n")
+		w.(http.Flusher).Flush()
+		started <- struct{}{}
+		<-release
+		io.WriteString(w, "second part\n")
+	}))
+	// The upstream speaks HTTP/1.1 and h2c, so each request shows which
+	// transport generation sent it
+	up.Config.Protocols = new(http.Protocols)
+	up.Config.Protocols.SetHTTP1(true)
+	up.Config.Protocols.SetUnencryptedHTTP2(true)
+	up.Start()
+	defer up.Close()
+
+	b, err := NewBackend(up.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const streams = 3
+	recorders := make([]*httptest.ResponseRecorder, streams)
+	var wg sync.WaitGroup
+	for i := range streams {
+		recorders[i] = httptest.NewRecorder()
+		wg.Go(func() {
+			b.ReverseProxy.ServeHTTP(recorders[i], httptest.NewRequest(http.MethodGet, "/stream", nil))
+		})
+	}
+	for range streams {
+		<-started
+	}
+
+	settings := DefaultTransportSettings()
+	settings.Protocol = ProtocolH2C
+	b.SetTransport(settings)
+	if b.RetiredTransports() != 1 || BackendsWithRetiredTransports() != 1 {
+		t.Fatalf("%d retired transports (%d backends), want the old one draining",
+			b.RetiredTransports(), BackendsWithRetiredTransports())
+	}
+
+	// New requests go over the new transport while the old one drains
+	w := httptest.NewRecorder()
+	b.ReverseProxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/proto", nil))
+	if w.Body.String() != "HTTP/2.0" {
+		t.Errorf("new request sent over %s, want HTTP/2.0", w.Body.String())
+	}
+
+	close(release)
+	wg.Wait()
+	for i, rec := range recorders {
+		if rec.Code != http.StatusOK || rec.Body.String() != "first part\nsecond part\n" {
+			t.Errorf("stream %d: got %d %q, want it complete", i, rec.Code, rec.Body.String())
+		}
+	}
+	if b.RetiredTransports() != 0 || BackendsWithRetiredTransports() != 0 {
+		t.Errorf("%d retired transports (%d backends) after the streams ended, want 0",
+			b.RetiredTransports(), BackendsWithRetiredTransports())
+	}
+}
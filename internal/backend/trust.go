@@ -0,0 +1,108 @@
+package backend
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies lists the networks whose forwarding headers are believed.
+// A nil list trusts only loopback peers, such as a proxy on the same host;
+// an empty one trusts none.
+type TrustedProxies []netip.Prefix
+
+// loopback is what a nil list trusts
+var loopback = TrustedProxies{
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("::1/128"),
+}
+
+// ParseTrustedProxies parses CIDRs such as "10.0.0.0/8"; a bare address is
+// taken as a single host. A nil list stays nil, trusting loopback only.
+func ParseTrustedProxies(cidrs []string) (TrustedProxies, error) {
+	if cidrs == nil {
+		return nil, nil
+	}
+	trusted := make(TrustedProxies, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(cidr)
+			if addrErr != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", cidr, err)
+			}
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		trusted = append(trusted, p.Masked())
+	}
+	return trusted, nil
+}
+
+// Trusts reports whether the peer at remoteAddr ("host:port") is trusted
+func (t TrustedProxies) Trusts(remoteAddr string) bool {
+	ip, err := netip.ParseAddr(stripPort(remoteAddr))
+	if err != nil {
+		return false
+	}
+	return t.contains(ip)
+}
+
+// ClientIP resolves who the client of r is. Behind trusted proxies it is the
+// rightmost X-Forwarded-For entry that is not itself a trusted proxy;
+// otherwise, or when every entry is trusted or one is not an address, it is
+// the connecting address. IPv4-mapped IPv6 addresses are given as IPv4. The
+// proxy, the access log, the filters and the hashing strategies all use it
+// so they agree.
+func (t TrustedProxies) ClientIP(r *http.Request) string {
+	peer := stripPort(r.RemoteAddr)
+	if ip, err := netip.ParseAddr(peer); err == nil {
+		peer = ip.Unmap().String()
+	}
+	if !t.Trusts(r.RemoteAddr) {
+		return peer
+	}
+	var chain []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		for entry := range strings.SplitSeq(v, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				chain = append(chain, entry)
+			}
+		}
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		entry := stripPort(chain[i])
+		ip, err := netip.ParseAddr(entry)
+		if err != nil {
+			break
+		}
+		if !t.contains(ip) {
+			return ip.Unmap().String()
+		}
+	}
+	return peer
+}
+
+// stripPort removes the port from "host:port" and the brackets from
+// "[v6]" or "[v6]:port", leaving bare addresses as they are
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+// contains reports whether ip lies in one of the trusted networks
+func (t TrustedProxies) contains(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if t == nil {
+		t = loopback
+	}
+	for _, p := range t {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package backend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func mustTrust(t *testing.T, cidrs []string) TrustedProxies {
+	t.Helper()
+	trusted, err := ParseTrustedProxies(cidrs)
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies(%q): %v", cidrs, err)
+	}
+	return trusted
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted []string
+		remote  string
+		xff     []string
+		want    string
+	}{
+		{"unset ignores a spoofed chain", nil, "203.0.113.9:4000", []string{"1.2.3.4, 5.6.7.8"}, "203.0.113.9"},
+		{"unset trusts loopback", nil, "127.0.0.1:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"unset trusts IPv6 loopback", nil, "[::1]:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"empty trusts nobody", []string{}, "127.0.0.1:4000", []string{"198.51.100.1"}, "127.0.0.1"},
+		{"untrusted peer", []string{"10.0.0.0/8"}, "203.0.113.9:4000", []string{"10.0.0.5"}, "203.0.113.9"},
+		{"trusted peer", []string{"10.0.0.0/8"}, "10.0.0.1:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"rightmost untrusted entry", []string{"10.0.0.0/8"}, "10.0.0.1:4000", []string{"1.2.3.4, 198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"entries across headers", []string{"10.0.0.0/8"}, "10.0.0.1:4000", []string{"1.2.3.4", "198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"every entry trusted", []string{"10.0.0.0/8"}, "10.0.0.1:4000", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.1"},
+		{"entry that is not an address", []string{"10.0.0.0/8"}, "10.0.0.1:4000", []string{"1.2.3.4, bogus, 10.0.0.2"}, "10.0.0.1"},
+		{"entry with a port", []string{"10.0.0.0/8"}, "10.0.0.1:4000", []string{"[2001:db8::1]:443"}, "2001:db8::1"},
+		{"no chain", []string{"10.0.0.0/8"}, "10.0.0.1:4000", nil, "10.0.0.1"},
+		{"bare trusted address", []string{"192.0.2.7"}, "192.0.2.7:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"IPv6 peer", []string{}, "[2001:db8::5]:443", nil, "2001:db8::5"},
+		{"IPv6 peer normalized", []string{}, "[2001:DB8:0::5]:443", nil, "2001:db8::5"},
+		{"IPv4-mapped peer", []string{}, "[::ffff:203.0.113.9]:4000", nil, "203.0.113.9"},
+		{"peer without a port", []string{}, "203.0.113.9", nil, "203.0.113.9"},
+		{"bracketed peer without a port", []string{}, "[2001:db8::5]", nil, "2001:db8::5"},
+		{"trusted IPv6 proxy", []string{"2001:db8::/32"}, "[2001:db8::1]:4000", []string{"2001:db9::9, 2001:db8::2"}, "2001:db9::9"},
+		{"IPv6 client behind trusted proxy", []string{"10.0.0.0/8"}, "10.0.0.1:4000", []string{"2001:db8::7"}, "2001:db8::7"},
+		{"IPv4-mapped entry", []string{"10.0.0.0/8"}, "10.0.0.1:4000", []string{"::ffff:198.51.100.1"}, "198.51.100.1"},
+		{"IPv4 entry with a port", []string{"10.0.0.0/8"}, "10.0.0.1:4000", []string{"198.51.100.1:5555"}, "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := mustTrust(t, tt.trusted).ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxiesRejectsGarbage(t *testing.T) {
+	if _, err := ParseTrustedProxies([]string{"10.0.0.0/8", "not-a-cidr"}); err == nil {
+		t.Fatal("ParseTrustedProxies accepted an invalid CIDR")
+	}
+}
+
+// TestSpoofedForwardingHeadersReplaced checks that the forwarding headers
+// of an untrusted client never reach the backend, with trusted_proxies unset
+func TestSpoofedForwardingHeadersReplaced(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	b, err := NewBackend(upstream.URL, WithRealIP())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	r.RemoteAddr = "203.0.113.9:4000"
+	r.Header.Set("X-Forwarded-For", "1.2.3.4, 5.6.7.8")
+	r.Header.Set("X-Forwarded-Host", "evil.example")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("Forwarded", "for=1.2.3.4")
+	b.ReverseProxy.ServeHTTP(httptest.NewRecorder(), r)
+
+	want := map[string]string{
+		"X-Forwarded-For":   "203.0.113.9",
+		"X-Real-Ip":         "203.0.113.9",
+		"X-Forwarded-Host":  "example.com",
+		"X-Forwarded-Proto": "http",
+		"Forwarded":         "",
+	}
+	for h, v := range want {
+		if g := got.Get(h); g != v {
+			t.Errorf("%s = %q, want %q", h, g, v)
+		}
+	}
+}
+
+func TestRealIPHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted []string
+		remote  string
+		xff     string
+		realIP  string
+		fwdFor  string
+	}{
+		{"IPv4 peer", []string{}, "203.0.113.9:4000", "", "203.0.113.9", "203.0.113.9"},
+		{"IPv6 peer", []string{}, "[2001:db8::5]:443", "", "2001:db8::5", "2001:db8::5"},
+		{"untrusted chain dropped", []string{}, "[2001:db8::5]:443", "1.2.3.4", "2001:db8::5", "2001:db8::5"},
+		{"trusted chain kept", []string{"10.0.0.0/8"}, "10.0.0.1:4000", "2001:db8::7", "2001:db8::7", "2001:db8::7, 10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got http.Header
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Clone()
+			}))
+			defer upstream.Close()
+
+			b, err := NewBackend(upstream.URL, WithRealIP(), WithTrustedProxies(mustTrust(t, tt.trusted)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			b.ReverseProxy.ServeHTTP(httptest.NewRecorder(), r)
+
+			if g := got.Get("X-Real-Ip"); g != tt.realIP {
+				t.Errorf("X-Real-IP = %q, want %q", g, tt.realIP)
+			}
+			if g := got.Get("X-Forwarded-For"); g != tt.fwdFor {
+				t.Errorf("X-Forwarded-For = %q, want %q", g, tt.fwdFor)
+			}
+		})
+	}
+}
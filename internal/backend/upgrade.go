@@ -0,0 +1,161 @@
+package backend
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// IsUpgrade reports whether r asks to switch protocols, as WebSocket
+// handshakes do
+func IsUpgrade(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range r.Header.Values("Connection") {
+		for token := range strings.SplitSeq(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tunnels tracks a backend's upgraded connections, which live on long after
+// their request, so they can be counted, waited for and closed
+type tunnels struct {
+	mu   sync.Mutex
+	open map[*tunnel]struct{}
+	idle time.Duration
+}
+
+// add registers the backend side of a newly upgraded connection
+func (ts *tunnels) add(rwc io.ReadWriteCloser) *tunnel {
+	t := &tunnel{ReadWriteCloser: rwc, owner: ts, idle: ts.idle}
+	t.touch()
+	if t.idle > 0 {
+		t.timer = time.AfterFunc(t.idle, t.checkIdle)
+	}
+	ts.mu.Lock()
+	if ts.open == nil {
+		ts.open = make(map[*tunnel]struct{})
+	}
+	ts.open[t] = struct{}{}
+	ts.mu.Unlock()
+	return t
+}
+
+func (ts *tunnels) remove(t *tunnel) {
+	ts.mu.Lock()
+	delete(ts.open, t)
+	ts.mu.Unlock()
+}
+
+// count returns the number of open tunnels
+func (ts *tunnels) count() int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return len(ts.open)
+}
+
+// closeAll closes every open tunnel and returns how many there were
+func (ts *tunnels) closeAll() int {
+	ts.mu.Lock()
+	open := make([]*tunnel, 0, len(ts.open))
+	for t := range ts.open {
+		open = append(open, t)
+	}
+	ts.mu.Unlock()
+	for _, t := range open {
+		t.Close()
+	}
+	return len(open)
+}
+
+// tunnel is the backend side of an upgraded connection. Closing it makes
+// ReverseProxy tear down the client side as well.
+type tunnel struct {
+	io.ReadWriteCloser
+	owner *tunnels
+	idle  time.Duration
+	timer *time.Timer
+	last  atomic.Int64 // unix nanoseconds of the last traffic either way
+	once  sync.Once
+}
+
+func (t *tunnel) touch() {
+	t.last.Store(time.Now().UnixNano())
+}
+
+func (t *tunnel) Read(p []byte) (int, error) {
+	n, err := t.ReadWriteCloser.Read(p)
+	t.touch()
+	return n, err
+}
+
+func (t *tunnel) Write(p []byte) (int, error) {
+	n, err := t.ReadWriteCloser.Write(p)
+	t.touch()
+	return n, err
+}
+
+// checkIdle closes the tunnel once nothing has crossed it for the idle
+// timeout, or checks again when that will next be possible
+func (t *tunnel) checkIdle() {
+	quiet := time.Since(time.Unix(0, t.last.Load()))
+	if quiet >= t.idle {
+		t.Close()
+		return
+	}
+	t.timer.Reset(t.idle - quiet)
+}
+
+func (t *tunnel) Close() error {
+	var err error
+	t.once.Do(func() {
+		if t.timer != nil {
+			t.timer.Stop()
+		}
+		t.owner.remove(t)
+		err = t.ReadWriteCloser.Close()
+	})
+	return err
+}
+
+// Upgrades returns the number of upgraded connections, such as WebSockets,
+// currently tunneled to the backend. They also count as active requests.
+func (b *Backend) Upgrades() int {
+	return b.tunnels.count()
+}
+
+// WaitUpgrades blocks until the backend's upgraded connections have all
+// closed or ctx is done, and reports whether they closed
+func (b *Backend) WaitUpgrades(ctx context.Context) bool {
+	for b.tunnels.count() > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	return true
+}
+
+// CloseUpgrades closes the backend's upgraded connections on both sides and
+// returns how many were open
+func (b *Backend) CloseUpgrades() int {
+	return b.tunnels.closeAll()
+}
+
+// WithUpgradeIdleTimeout closes upgraded connections to the backend once no
+// data has crossed them in either direction for d (default 0, never)
+func WithUpgradeIdleTimeout(d time.Duration) Option {
+	return func(b *Backend) {
+		b.tunnels.idle = d
+	}
+}
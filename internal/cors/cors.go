@@ -0,0 +1,224 @@
+// Package cors answers CORS preflight requests at the edge and sets the
+// Access-Control-Allow-* headers of actual responses, so every backend of a
+// route shares one policy instead of each implementing its own
+package cors
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/metrics"
+)
+
+// Options configure a Policy
+type Options struct {
+	// Origins are the origins allowed, such as https://app.example.com;
+	// "*" allows any, and a "*" within one, as in https://*.example.com,
+	// stands for one or more characters other than "/"
+	Origins []string
+	// Methods are the methods preflights are allowed
+	Methods []string
+	// Headers are the request headers preflights are allowed; "*" allows
+	// any
+	Headers []string
+	// ExposedHeaders are the response headers scripts may read
+	ExposedHeaders []string
+	// MaxAge is how long browsers may cache a preflight answer; zero
+	// leaves it to them
+	MaxAge time.Duration
+	// Credentials allows requests with cookies and authorization
+	Credentials bool
+	// Strict answers requests from origins not allowed 403, where they
+	// would otherwise pass through untouched
+	Strict  bool
+	Metrics *metrics.Metrics
+}
+
+// Policy applies a CORS policy to the requests of a route. Responses to
+// allowed origins have their Access-Control-* headers replaced by those of
+// the policy, whatever the backend sent.
+type Policy struct {
+	o          Options
+	anyOrigin  bool
+	anyHeader  bool
+	methods    string
+	headers    string
+	exposed    string
+	allowedHdr map[string]bool
+}
+
+// New creates a policy
+func New(o Options) *Policy {
+	p := &Policy{
+		o:          o,
+		anyOrigin:  slices.Contains(o.Origins, "*"),
+		anyHeader:  slices.Contains(o.Headers, "*"),
+		methods:    strings.Join(o.Methods, ", "),
+		headers:    strings.Join(o.Headers, ", "),
+		exposed:    strings.Join(o.ExposedHeaders, ", "),
+		allowedHdr: make(map[string]bool, len(o.Headers)),
+	}
+	for _, h := range o.Headers {
+		p.allowedHdr[http.CanonicalHeaderKey(h)] = true
+	}
+	return p
+}
+
+// allows reports whether origin may make requests
+func (p *Policy) allows(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	for _, pattern := range p.o.Origins {
+		if matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOrigin reports whether origin matches pattern
+func matchOrigin(pattern, origin string) bool {
+	prefix, suffix, wild := strings.Cut(pattern, "*")
+	if !wild {
+		return strings.EqualFold(pattern, origin)
+	}
+	if len(origin) <= len(prefix)+len(suffix) {
+		return false
+	}
+	origin = strings.ToLower(origin)
+	middle := origin[len(prefix) : len(origin)-len(suffix)]
+	return strings.HasPrefix(origin, strings.ToLower(prefix)) && strings.HasSuffix(origin, strings.ToLower(suffix)) && !strings.Contains(middle, "/")
+}
+
+// Wrap puts the policy in front of next
+func (p *Policy) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !p.allows(origin) {
+			if p.o.Strict {
+				p.o.Metrics.Rejected("cors")
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			p.preflight(w, r, origin)
+			return
+		}
+		next.ServeHTTP(&corsWriter{ResponseWriter: w, p: p, origin: origin}, r)
+	})
+}
+
+// preflight answers a preflight request from an allowed origin. A method
+// or header the policy does not allow is left out of the answer, for the
+// browser to refuse the request.
+func (p *Policy) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	h := w.Header()
+	h.Add("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+	if !slices.Contains(p.o.Methods, r.Header.Get("Access-Control-Request-Method")) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	requested := r.Header.Values("Access-Control-Request-Headers")
+	if p.anyHeader {
+		if len(requested) > 0 {
+			h.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+		}
+	} else {
+		for _, v := range requested {
+			for name := range strings.SplitSeq(v, ",") {
+				if name = strings.TrimSpace(name); name != "" && !p.allowedHdr[http.CanonicalHeaderKey(name)] {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+			}
+		}
+		if p.headers != "" {
+			h.Set("Access-Control-Allow-Headers", p.headers)
+		}
+	}
+	p.setOrigin(h, origin)
+	h.Set("Access-Control-Allow-Methods", p.methods)
+	if p.o.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.FormatInt(int64(p.o.MaxAge/time.Second), 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setOrigin sets the headers naming who may read the response: any origin
+// if all are allowed without credentials, otherwise origin itself
+func (p *Policy) setOrigin(h http.Header, origin string) {
+	if p.anyOrigin && !p.o.Credentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.o.Credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// corsWriter replaces the Access-Control-* headers of the response to an
+// allowed origin as its header is written. Flushing and hijacking reach the
+// underlying writer through Unwrap.
+type corsWriter struct {
+	http.ResponseWriter
+	p       *Policy
+	origin  string
+	applied bool
+}
+
+func (w *corsWriter) apply() {
+	if w.applied {
+		return
+	}
+	w.applied = true
+	h := w.Header()
+	for name := range h {
+		if strings.HasPrefix(name, "Access-Control-") {
+			delete(h, name)
+		}
+	}
+	w.p.setOrigin(h, w.origin)
+	if w.p.exposed != "" {
+		h.Set("Access-Control-Expose-Headers", w.p.exposed)
+	}
+	if !w.p.anyOrigin || w.p.o.Credentials {
+		h.Add("Vary", "Origin")
+	}
+}
+
+// WriteHeader sets the headers on the final response; informational
+// responses go out as they are
+func (w *corsWriter) WriteHeader(code int) {
+	if code >= 200 || code == http.StatusSwitchingProtocols {
+		w.apply()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *corsWriter) Write(p []byte) (int, error) {
+	w.apply()
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush sends the header, with the CORS headers, if it has not gone out yet
+func (w *corsWriter) Flush() {
+	w.apply()
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *corsWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,267 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// blockWait is how long Consul may hold a blocking query open
+	blockWait = 5 * time.Minute
+	// minQueryInterval spaces out queries that return at once, as Consul
+	// does when its index jumps without the service changing
+	minQueryInterval = time.Second
+)
+
+// ConsulService names a service in the Consul catalog and, optionally, a
+// tag its instances must have
+type ConsulService struct {
+	Name string
+	Tag  string
+}
+
+// ParseConsul parses a backend spec such as consul://web?tag=v2 into the
+// service and the scheme its instances are reached with: "http", or
+// "https" for consul+https://. It reports false for any other spec.
+func ParseConsul(spec string) (ConsulService, string, bool) {
+	u, err := url.Parse(spec)
+	if err != nil || u.Host == "" {
+		return ConsulService{}, "", false
+	}
+	switch u.Scheme {
+	case "consul":
+		return ConsulService{Name: u.Host, Tag: u.Query().Get("tag")}, "http", true
+	case "consul+https":
+		return ConsulService{Name: u.Host, Tag: u.Query().Get("tag")}, "https", true
+	}
+	return ConsulService{}, "", false
+}
+
+// ConsulClient reads from the HTTP API of a Consul agent
+type ConsulClient struct {
+	address    string
+	token      string
+	datacenter string
+	http       *http.Client
+}
+
+// NewConsulClient creates a client of the agent at address, such as
+// http://127.0.0.1:8500, authenticating with token if set and querying
+// datacenter, or the agent's own if empty
+func NewConsulClient(address, token, datacenter string) *ConsulClient {
+	return &ConsulClient{
+		address:    strings.TrimSuffix(address, "/"),
+		token:      token,
+		datacenter: datacenter,
+		// Blocking queries bound themselves through their context
+		http: &http.Client{},
+	}
+}
+
+// consulEntry is an instance in a /v1/health/service response
+type consulEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		Address string        `json:"Address"`
+		Port    int           `json:"Port"`
+		Weights consulWeights `json:"Weights"`
+	} `json:"Service"`
+}
+
+// consulCatalogEntry is an instance in a /v1/catalog/service response
+type consulCatalogEntry struct {
+	Address        string        `json:"Address"`
+	ServiceAddress string        `json:"ServiceAddress"`
+	ServicePort    int           `json:"ServicePort"`
+	ServiceWeights consulWeights `json:"ServiceWeights"`
+}
+
+// consulWeights are the DNS weights of an instance, which Nexus uses as
+// backend weights
+type consulWeights struct {
+	Passing int `json:"Passing"`
+}
+
+// Consul discovers backends from the instances of a service in the Consul
+// catalog, with blocking queries so changes apply as soon as Consul sees
+// them. Unless told to leave health to Nexus, only instances passing their
+// Consul health checks are backends.
+type Consul struct {
+	client  *ConsulClient
+	service ConsulService
+	scheme  string
+	passing bool
+	rc      *Reconciler
+	logger  *slog.Logger
+
+	// index is the Consul index of the last answer, only used by Refresh
+	// and the query loop, which never run at once
+	index uint64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewConsul creates a source for service that reads through client and
+// applies what it finds through rc. With passing set it uses Consul's
+// health checks.
+func NewConsul(client *ConsulClient, service ConsulService, scheme string, passing bool, rc *Reconciler) *Consul {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Consul{
+		client:  client,
+		service: service,
+		scheme:  scheme,
+		passing: passing,
+		rc:      rc,
+		logger:  slog.Default().With("component", "discovery", "source", rc.o.Source),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Refresh reads the service's instances at once and applies them. If
+// Consul cannot be reached the backends found before are kept.
+func (c *Consul) Refresh() error {
+	ctx, cancel := context.WithTimeout(c.ctx, listTimeout)
+	defer cancel()
+	return c.query(ctx, 0)
+}
+
+// query reads the service's instances, waiting for them to change from
+// index if it is not 0, and applies them
+func (c *Consul) query(ctx context.Context, index uint64) error {
+	path := "/v1/catalog/service/"
+	q := url.Values{}
+	if c.passing {
+		path = "/v1/health/service/"
+		q.Set("passing", "true")
+	}
+	if c.service.Tag != "" {
+		q.Set("tag", c.service.Tag)
+	}
+	if c.client.datacenter != "" {
+		q.Set("dc", c.client.datacenter)
+	}
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", blockWait.String())
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.client.address+path+url.PathEscape(c.service.Name)+"?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if c.client.token != "" {
+		req.Header.Set("X-Consul-Token", c.client.token)
+	}
+
+	targets, next, err := c.read(req)
+	if err != nil {
+		// The next query answers at once, to clear the stale flag as soon
+		// as Consul is back
+		c.index = 0
+		c.rc.Failed(err)
+		return err
+	}
+	// An index going backwards means Consul's state was reset
+	if next < c.index {
+		next = 0
+	}
+	c.index = next
+	c.rc.Apply(targets)
+	return nil
+}
+
+// read sends req and converts the instances it returns into backends,
+// along with the index of the answer
+func (c *Consul) read(req *http.Request) ([]Target, uint64, error) {
+	resp, err := c.client.http.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return nil, 0, fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	index, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+
+	var targets []Target
+	add := func(address, nodeAddress string, port int, w consulWeights) {
+		if address == "" {
+			address = nodeAddress
+		}
+		if address == "" || port == 0 {
+			return
+		}
+		targets = append(targets, Target{
+			URL:    c.scheme + "://" + net.JoinHostPort(address, strconv.Itoa(port)),
+			Weight: max(w.Passing, 1),
+		})
+	}
+	if c.passing {
+		var entries []consulEntry
+		if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+			return nil, 0, fmt.Errorf("decoding health response: %w", err)
+		}
+		for _, e := range entries {
+			add(e.Service.Address, e.Node.Address, e.Service.Port, e.Service.Weights)
+		}
+	} else {
+		var entries []consulCatalogEntry
+		if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+			return nil, 0, fmt.Errorf("decoding catalog response: %w", err)
+		}
+		for _, e := range entries {
+			add(e.ServiceAddress, e.Address, e.ServicePort, e.ServiceWeights)
+		}
+	}
+	return targets, index, nil
+}
+
+// Start follows the service with blocking queries in a separate goroutine
+func (c *Consul) Start() {
+	c.logger.Info("following Consul service", "tag", c.service.Tag, "consul_health", c.passing)
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for {
+			started := time.Now()
+			// Consul adds up to wait/16 of jitter to a blocking query
+			ctx, cancel := context.WithTimeout(c.ctx, blockWait+blockWait/16+listTimeout)
+			err := c.query(ctx, c.index)
+			cancel()
+			if c.ctx.Err() != nil {
+				return
+			}
+			wait := max(minQueryInterval-time.Since(started), 0)
+			if err != nil {
+				c.logger.Warn("Consul query failed, keeping current backends", "error", err)
+				wait = rewatchDelay
+			}
+			select {
+			case <-time.After(wait):
+			case <-c.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops following the service
+func (c *Consul) Stop() {
+	c.cancel()
+	c.wg.Wait()
+}
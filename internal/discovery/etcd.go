@@ -0,0 +1,450 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// etcdWatchTimeout is how long a watch is held open before it is opened
+// again from where it left off, so a connection that died silently is
+// noticed
+const etcdWatchTimeout = 5 * time.Minute
+
+// ParseEtcd parses a backend spec such as etcd:///services/web/ into the
+// key prefix backends register under. It reports false for any other spec.
+func ParseEtcd(spec string) (string, bool) {
+	prefix, ok := strings.CutPrefix(spec, "etcd://")
+	return prefix, ok && prefix != ""
+}
+
+// EtcdClient talks to an etcd cluster through the JSON gateway every v3
+// member serves, so no client libraries are needed. Requests go to the
+// member last reached and move on to the next when it cannot be.
+type EtcdClient struct {
+	endpoints []string
+	username  string
+	password  string
+	http      *http.Client
+
+	mu      sync.Mutex
+	current int // index of the endpoint last reached
+	// tokens are the auth tokens of the endpoints, since a member may only
+	// accept those it issued
+	tokens map[string]string
+}
+
+// etcdStatusError is an error an etcd member answered with, as opposed to
+// one reaching it
+type etcdStatusError struct {
+	path    string
+	code    int
+	status  string
+	message string
+}
+
+func (e *etcdStatusError) Error() string {
+	return fmt.Sprintf("POST %s: %s: %s", e.path, e.status, e.message)
+}
+
+// NewEtcdClient creates a client of the members at endpoints, such as
+// http://127.0.0.1:2379. With a username it authenticates as that user;
+// caFile verifies https endpoints and certFile and keyFile, if set, are the
+// client certificate.
+func NewEtcdClient(endpoints []string, username, password, caFile, certFile, keyFile string) (*EtcdClient, error) {
+	var ca []byte
+	if caFile != "" {
+		var err error
+		if ca, err = os.ReadFile(caFile); err != nil {
+			return nil, fmt.Errorf("etcd CA: %w", err)
+		}
+	}
+	tc, err := tlsConfig(ca, false)
+	if err != nil {
+		return nil, fmt.Errorf("etcd: %w", err)
+	}
+	if certFile != "" {
+		pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("etcd client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{pair}
+	}
+	c := &EtcdClient{
+		username: username,
+		password: password,
+		http:     newKubeHTTP(tc),
+		tokens:   make(map[string]string),
+	}
+	for _, e := range endpoints {
+		c.endpoints = append(c.endpoints, strings.TrimSuffix(e, "/"))
+	}
+	return c, nil
+}
+
+// post sends in as JSON to path and returns the open response body,
+// trying each endpoint in turn until one answers
+func (c *EtcdClient) post(ctx context.Context, path string, in any) (io.ReadCloser, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	start := c.current
+	c.mu.Unlock()
+	for i := range c.endpoints {
+		n := (start + i) % len(c.endpoints)
+		var resp io.ReadCloser
+		resp, err = c.postTo(ctx, c.endpoints[n], path, body)
+		if err == nil {
+			c.mu.Lock()
+			c.current = n
+			c.mu.Unlock()
+			return resp, nil
+		}
+		var se *etcdStatusError
+		if errors.As(err, &se) || ctx.Err() != nil {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// postTo sends body to path on endpoint, authenticating first if needed
+func (c *EtcdClient) postTo(ctx context.Context, endpoint, path string, body []byte) (io.ReadCloser, error) {
+	token, err := c.authenticate(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.send(ctx, endpoint, path, token, body)
+	var se *etcdStatusError
+	if errors.As(err, &se) && se.code == http.StatusUnauthorized {
+		// The token expired; get a new one next time
+		c.mu.Lock()
+		delete(c.tokens, endpoint)
+		c.mu.Unlock()
+	}
+	return resp, err
+}
+
+// authenticate returns the auth token of endpoint, getting one if there is
+// none yet. It returns "" without a username.
+func (c *EtcdClient) authenticate(ctx context.Context, endpoint string) (string, error) {
+	if c.username == "" {
+		return "", nil
+	}
+	c.mu.Lock()
+	token := c.tokens[endpoint]
+	c.mu.Unlock()
+	if token != "" {
+		return token, nil
+	}
+	body, err := json.Marshal(map[string]string{"name": c.username, "password": c.password})
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.send(ctx, endpoint, "/v3/auth/authenticate", "", body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Close()
+	var auth struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp).Decode(&auth); err != nil {
+		return "", fmt.Errorf("decoding auth response: %w", err)
+	}
+	c.mu.Lock()
+	c.tokens[endpoint] = auth.Token
+	c.mu.Unlock()
+	return auth.Token, nil
+}
+
+// send posts body to path on endpoint; any status other than 200 is an
+// error
+func (c *EtcdClient) send(ctx context.Context, endpoint, path, token string, body []byte) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var status struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, &status) != nil || status.Message == "" {
+			status.Message = strings.TrimSpace(string(data))
+		}
+		return nil, &etcdStatusError{path: path, code: resp.StatusCode, status: resp.Status, message: status.Message}
+	}
+	return resp.Body, nil
+}
+
+// etcdKV is a key and its value; the gateway sends bytes in base64, which
+// is how []byte decodes, and 64-bit integers as strings
+type etcdKV struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// etcdHeader is the header of every etcd response
+type etcdHeader struct {
+	Revision int64 `json:"revision,string"`
+}
+
+// etcdWatchResponse is one message of a watch stream
+type etcdWatchResponse struct {
+	Result *struct {
+		Header          etcdHeader `json:"header"`
+		Created         bool       `json:"created"`
+		Canceled        bool       `json:"canceled"`
+		CancelReason    string     `json:"cancel_reason"`
+		CompactRevision int64      `json:"compact_revision,string"`
+		Events          []struct {
+			// Type is "DELETE", or unset for a put
+			Type string `json:"type"`
+			KV   etcdKV `json:"kv"`
+		} `json:"events"`
+	} `json:"result"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Etcd discovers backends that register themselves in etcd, each writing a
+// key under a prefix whose value is a JSON object such as {"url":
+// "http://10.0.0.5:8080", "weight": 2, "labels": {"zone": "a"}}. The keys
+// are watched, so a backend is added as soon as it registers and drained as
+// soon as its key is deleted, which is also what happens when the lease it
+// was written with expires.
+type Etcd struct {
+	client *EtcdClient
+	prefix string
+	rc     *Reconciler
+	logger *slog.Logger
+
+	// keys and revision are only used by Refresh and the watch, which never
+	// run at once. keys holds the valid registrations.
+	keys     map[string]Target
+	revision int64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewEtcd creates a source for the keys under prefix that reads through
+// client and applies what it finds through rc
+func NewEtcd(client *EtcdClient, prefix string, rc *Reconciler) *Etcd {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Etcd{
+		client: client,
+		prefix: prefix,
+		rc:     rc,
+		logger: slog.Default().With("component", "discovery", "source", rc.o.Source),
+		keys:   make(map[string]Target),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// rangeEnd is the end of the range of keys starting with the prefix: the
+// prefix with its last byte that can be incremented incremented
+func (e *Etcd) rangeEnd() []byte {
+	end := []byte(e.prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// All 0xff; "\x00" means every key from the prefix on
+	return []byte{0}
+}
+
+// Refresh reads the keys under the prefix and applies them. If etcd cannot
+// be reached the backends found before are kept.
+func (e *Etcd) Refresh() error {
+	ctx, cancel := context.WithTimeout(e.ctx, listTimeout)
+	defer cancel()
+	body, err := e.client.post(ctx, "/v3/kv/range", map[string][]byte{
+		"key":       []byte(e.prefix),
+		"range_end": e.rangeEnd(),
+	})
+	if err != nil {
+		e.rc.Failed(err)
+		return err
+	}
+	defer body.Close()
+	var list struct {
+		Header etcdHeader `json:"header"`
+		KVs    []etcdKV   `json:"kvs"`
+	}
+	if err := json.NewDecoder(body).Decode(&list); err != nil {
+		err = fmt.Errorf("decoding range response: %w", err)
+		e.rc.Failed(err)
+		return err
+	}
+	clear(e.keys)
+	for _, kv := range list.KVs {
+		e.set(kv)
+	}
+	e.revision = list.Header.Revision
+	e.apply()
+	return nil
+}
+
+// watch applies changes to the keys until the watch ends, or until
+// etcdWatchTimeout, in which case it returns nil and the revision to watch
+// on from
+func (e *Etcd) watch() error {
+	ctx, cancel := context.WithTimeout(e.ctx, etcdWatchTimeout)
+	defer cancel()
+	body, err := e.client.post(ctx, "/v3/watch", map[string]any{
+		"create_request": map[string]any{
+			"key":            []byte(e.prefix),
+			"range_end":      e.rangeEnd(),
+			"start_revision": e.revision + 1,
+			// Progress notifications move the revision on while no key
+			// changes, so watching again from it replays less
+			"progress_notify": true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	dec := json.NewDecoder(body)
+	for {
+		var msg etcdWatchResponse
+		if err := dec.Decode(&msg); err != nil {
+			if ctx.Err() != nil && e.ctx.Err() == nil {
+				return nil
+			}
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("watch stream ended")
+			}
+			return err
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("watch: %s", msg.Error.Message)
+		}
+		r := msg.Result
+		switch {
+		case r == nil:
+			continue
+		case r.CompactRevision != 0:
+			return fmt.Errorf("watch: revision %d was compacted", e.revision+1)
+		case r.Canceled:
+			return fmt.Errorf("watch canceled: %s", r.CancelReason)
+		case r.Created:
+			// Events from start_revision may still follow
+			continue
+		}
+		for _, ev := range r.Events {
+			if ev.Type == "DELETE" {
+				delete(e.keys, string(ev.KV.Key))
+			} else {
+				e.set(ev.KV)
+			}
+		}
+		e.revision = r.Header.Revision
+		if len(r.Events) > 0 {
+			e.apply()
+		}
+	}
+}
+
+// set records the registration in kv. An invalid one is logged and
+// ignored, dropping whatever the key held before.
+func (e *Etcd) set(kv etcdKV) {
+	key := string(kv.Key)
+	var t Target
+	err := json.Unmarshal(kv.Value, &t)
+	if err == nil {
+		err = checkTarget(&t)
+	}
+	if err != nil {
+		delete(e.keys, key)
+		e.logger.Warn("ignoring invalid backend registration", "key", key, "error", err)
+		return
+	}
+	e.keys[key] = t
+}
+
+// apply applies the registered backends. A URL registered under several
+// keys counts once, with the first key's weight, tier and labels.
+func (e *Etcd) apply() {
+	var targets []Target
+	seen := make(map[string]bool, len(e.keys))
+	for _, key := range slices.Sorted(maps.Keys(e.keys)) {
+		t := e.keys[key]
+		if seen[t.URL] {
+			continue
+		}
+		seen[t.URL] = true
+		targets = append(targets, t)
+	}
+	e.rc.Apply(targets)
+}
+
+// Start watches the prefix in a separate goroutine
+func (e *Etcd) Start() {
+	e.logger.Info("watching etcd prefix", "prefix", e.prefix)
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		for {
+			var err error
+			if e.revision == 0 {
+				err = e.Refresh()
+			}
+			if err == nil {
+				err = e.watch()
+			}
+			if e.ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				continue
+			}
+			// Read the keys again afterwards, whatever was missed
+			e.revision = 0
+			e.rc.Failed(err)
+			e.logger.Warn("etcd watch failed, keeping current backends", "error", err)
+			select {
+			case <-time.After(rewatchDelay):
+			case <-e.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops watching the prefix
+func (e *Etcd) Stop() {
+	e.cancel()
+	e.wg.Wait()
+}
@@ -0,0 +1,190 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// filePollInterval is how often a backends file is checked for changes
+const filePollInterval = 250 * time.Millisecond
+
+// ParseFile parses a backend spec such as file:///etc/nexus/backends.txt
+// into the path of the file. It reports false for any other spec.
+func ParseFile(spec string) (string, bool) {
+	path, ok := strings.CutPrefix(spec, "file://")
+	return path, ok && path != ""
+}
+
+// File reads backends from a file, one per entry, and reads it again
+// whenever it changes, so it can be maintained by tools that know how to
+// write files but not how to call the admin API. A file ending in .json
+// holds an array of {"url": ..., "weight": ..., "tier": ...} objects; any
+// other holds one URL per line, optionally followed by a weight, with #
+// comments.
+type File struct {
+	path   string
+	rc     *Reconciler
+	logger *slog.Logger
+
+	// read is the version of the file last read, nil if there is none
+	read     os.FileInfo
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewFile creates a source for the backends file at path that applies
+// what it finds through rc
+func NewFile(path string, rc *Reconciler) *File {
+	return &File{
+		path:     path,
+		rc:       rc,
+		logger:   slog.Default().With("component", "discovery", "source", rc.o.Source),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Refresh reads the file and applies its backends. A file that cannot be
+// read or has any invalid entry is rejected as a whole, keeping the
+// backends read before.
+func (f *File) Refresh() error {
+	info, err := os.Stat(f.path)
+	f.read = info
+	if err != nil {
+		f.rc.Failed(err)
+		return err
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		f.rc.Failed(err)
+		return err
+	}
+	targets, err := parseBackends(f.path, data)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", f.path, err)
+		f.rc.Failed(err)
+		return err
+	}
+	f.rc.Apply(targets)
+	return nil
+}
+
+// changed reports whether the file differs from the version last read.
+// Replacing the file by a rename changes it even if size and time match.
+func (f *File) changed() bool {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		// Report a file gone only once
+		return f.read != nil
+	}
+	return f.read == nil || !info.ModTime().Equal(f.read.ModTime()) || info.Size() != f.read.Size() || !os.SameFile(info, f.read)
+}
+
+// parseBackends parses the entries of a backends file
+func parseBackends(path string, data []byte) ([]Target, error) {
+	var targets []Target
+	if filepath.Ext(path) == ".json" {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&targets); err != nil {
+			return nil, err
+		}
+		for i := range targets {
+			if err := checkTarget(&targets[i]); err != nil {
+				return nil, fmt.Errorf("entry %d: %w", i, err)
+			}
+		}
+	} else {
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for n := 1; sc.Scan(); n++ {
+			line, _, _ := strings.Cut(sc.Text(), "#")
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			if len(fields) > 2 {
+				return nil, fmt.Errorf("line %d: expected a URL and an optional weight", n)
+			}
+			t := Target{URL: fields[0]}
+			if len(fields) == 2 {
+				w, err := strconv.Atoi(fields[1])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid weight %q", n, fields[1])
+				}
+				t.Weight = w
+			}
+			if err := checkTarget(&t); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			targets = append(targets, t)
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	seen := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		if seen[t.URL] {
+			return nil, fmt.Errorf("backend %s is listed twice", t.URL)
+		}
+		seen[t.URL] = true
+	}
+	return targets, nil
+}
+
+// checkTarget validates a backend read from a file or registry, defaulting
+// its weight to 1 and normalizing its URL as the admin API does
+func checkTarget(t *Target) error {
+	u, err := url.Parse(t.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http or https URL", t.URL)
+	}
+	if t.Weight < 0 || t.Tier < 0 {
+		return fmt.Errorf("weight and tier of %s must not be negative", t.URL)
+	}
+	if t.Weight == 0 {
+		t.Weight = 1
+	}
+	t.URL = u.String()
+	return nil
+}
+
+// Start watches the file for changes in a separate goroutine
+func (f *File) Start() {
+	f.logger.Info("watching backends file", "path", f.path)
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		ticker := time.NewTicker(filePollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !f.changed() {
+					continue
+				}
+				if err := f.Refresh(); err != nil {
+					f.logger.Error("rejected backends file, keeping current backends", "error", err)
+				}
+			case <-f.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops watching the file
+func (f *File) Stop() {
+	close(f.stopChan)
+	f.wg.Wait()
+}
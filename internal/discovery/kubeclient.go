@@ -0,0 +1,267 @@
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// serviceAccountDir holds the credentials Kubernetes mounts into pods
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// KubeClient reads from a Kubernetes API server. It speaks the REST API
+// directly, so no client libraries are needed.
+type KubeClient struct {
+	server string
+	http   *http.Client
+	// token is a static bearer token; tokenFile is read again for every
+	// request, since the tokens Kubernetes mounts are rotated
+	token     string
+	tokenFile string
+	username  string
+	password  string
+}
+
+// kubeconfig is the part of a kubeconfig file Nexus uses
+type kubeconfig struct {
+	CurrentContext string `json:"current-context"`
+	Clusters       []struct {
+		Name    string `json:"name"`
+		Cluster struct {
+			Server                   string `json:"server"`
+			CertificateAuthority     string `json:"certificate-authority"`
+			CertificateAuthorityData string `json:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `json:"insecure-skip-tls-verify"`
+		} `json:"cluster"`
+	} `json:"clusters"`
+	Users []struct {
+		Name string `json:"name"`
+		User struct {
+			Token                 string `json:"token"`
+			TokenFile             string `json:"tokenFile"`
+			ClientCertificate     string `json:"client-certificate"`
+			ClientCertificateData string `json:"client-certificate-data"`
+			ClientKey             string `json:"client-key"`
+			ClientKeyData         string `json:"client-key-data"`
+			Username              string `json:"username"`
+			Password              string `json:"password"`
+			Exec                  any    `json:"exec"`
+			AuthProvider          any    `json:"auth-provider"`
+		} `json:"user"`
+	} `json:"users"`
+	Contexts []struct {
+		Name    string `json:"name"`
+		Context struct {
+			Cluster string `json:"cluster"`
+			User    string `json:"user"`
+		} `json:"context"`
+	} `json:"contexts"`
+}
+
+// NewKubeClient connects with the kubeconfig file at path, using its
+// context kubeContext or, if empty, its current one. Without a path it
+// uses the service account of the pod Nexus runs in.
+func NewKubeClient(path, kubeContext string) (*KubeClient, error) {
+	if path == "" {
+		return inClusterClient()
+	}
+	return kubeconfigClient(path, kubeContext)
+}
+
+// inClusterClient connects to the API server of the cluster Nexus runs in
+func inClusterClient() (*KubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster (KUBERNETES_SERVICE_HOST is unset); set kubernetes.kubeconfig")
+	}
+	tokenFile := filepath.Join(serviceAccountDir, "token")
+	if _, err := os.Stat(tokenFile); err != nil {
+		return nil, fmt.Errorf("service account token: %w", err)
+	}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("service account CA: %w", err)
+	}
+	tc, err := tlsConfig(ca, false)
+	if err != nil {
+		return nil, err
+	}
+	return &KubeClient{
+		server:    "https://" + net.JoinHostPort(host, port),
+		http:      newKubeHTTP(tc),
+		tokenFile: tokenFile,
+	}, nil
+}
+
+// kubeconfigClient connects as a context of the kubeconfig file at path
+func kubeconfigClient(path, kubeContext string) (*KubeClient, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err = yamlToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("kubeconfig %s: %w", path, err)
+	}
+	var kc kubeconfig
+	if err := json.Unmarshal(data, &kc); err != nil {
+		return nil, fmt.Errorf("kubeconfig %s: %w", path, err)
+	}
+
+	if kubeContext == "" {
+		kubeContext = kc.CurrentContext
+	}
+	var clusterName, userName string
+	found := false
+	for _, c := range kc.Contexts {
+		if c.Name == kubeContext {
+			clusterName, userName, found = c.Context.Cluster, c.Context.User, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("kubeconfig %s has no context %q", path, kubeContext)
+	}
+
+	// Relative paths in a kubeconfig are relative to the file
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	c := &KubeClient{}
+	var tc *tls.Config
+	found = false
+	for _, cl := range kc.Clusters {
+		if cl.Name != clusterName {
+			continue
+		}
+		found = true
+		c.server = strings.TrimSuffix(cl.Cluster.Server, "/")
+		ca, err := fileOrData(resolve(cl.Cluster.CertificateAuthority), cl.Cluster.CertificateAuthorityData)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: certificate authority: %w", clusterName, err)
+		}
+		if tc, err = tlsConfig(ca, cl.Cluster.InsecureSkipTLSVerify); err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", clusterName, err)
+		}
+	}
+	if !found || c.server == "" {
+		return nil, fmt.Errorf("kubeconfig %s has no server for cluster %q", path, clusterName)
+	}
+
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		if u.User.Exec != nil || u.User.AuthProvider != nil {
+			return nil, fmt.Errorf("user %s: exec and auth-provider credentials are not supported; use a token or client certificate", userName)
+		}
+		c.token, c.tokenFile = u.User.Token, resolve(u.User.TokenFile)
+		c.username, c.password = u.User.Username, u.User.Password
+		cert, err := fileOrData(resolve(u.User.ClientCertificate), u.User.ClientCertificateData)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: client certificate: %w", userName, err)
+		}
+		key, err := fileOrData(resolve(u.User.ClientKey), u.User.ClientKeyData)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: client key: %w", userName, err)
+		}
+		if cert != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, fmt.Errorf("user %s: %w", userName, err)
+			}
+			tc.Certificates = []tls.Certificate{pair}
+		}
+	}
+	c.http = newKubeHTTP(tc)
+	return c, nil
+}
+
+// fileOrData returns the contents of file, or else data decoded from base64
+func fileOrData(file, data string) ([]byte, error) {
+	if file != "" {
+		return os.ReadFile(file)
+	}
+	if data == "" {
+		return nil, nil
+	}
+	return base64.StdEncoding.DecodeString(data)
+}
+
+// tlsConfig trusts the PEM certificates in ca, or the system roots if ca
+// is empty
+func tlsConfig(ca []byte, insecure bool) (*tls.Config, error) {
+	tc := &tls.Config{InsecureSkipVerify: insecure}
+	if len(ca) > 0 {
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no PEM certificates in certificate authority")
+		}
+	}
+	return tc, nil
+}
+
+// newKubeHTTP creates the HTTP client of an API server. It has no overall
+// timeout, since watches stream for minutes; requests carry their own.
+func newKubeHTTP(tc *tls.Config) *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = tc
+	t.ResponseHeaderTimeout = 30 * time.Second
+	return &http.Client{Transport: t}
+}
+
+// get requests path from the API server and returns the open response
+// body; any status other than 200 is an error
+func (c *KubeClient) get(ctx context.Context, path string, query url.Values) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.server+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	token := c.token
+	if c.tokenFile != "" {
+		b, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("token: %w", err)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	switch {
+	case token != "":
+		req.Header.Set("Authorization", "Bearer "+token)
+	case c.username != "":
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var status struct {
+			Message string `json:"message"`
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(body, &status) != nil || status.Message == "" {
+			status.Message = strings.TrimSpace(string(body))
+		}
+		return nil, fmt.Errorf("%s %s: %s: %s", req.Method, path, resp.Status, status.Message)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,285 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// listTimeout bounds listing the EndpointSlices of a service
+	listTimeout = 10 * time.Second
+	// rewatchDelay is how long to wait after a failed list or watch
+	rewatchDelay = 5 * time.Second
+)
+
+// Service names a Kubernetes Service and the port of its endpoints to use
+type Service struct {
+	Namespace string
+	Name      string
+	// Port is the name of the port; empty picks the only port, or the
+	// unnamed one
+	Port string
+}
+
+// ParseKubernetes parses a backend spec such as k8s://namespace/service:port
+// into the service and the scheme its endpoints are reached with: "http",
+// or "https" for k8s+https://. It reports false for any other spec.
+func ParseKubernetes(spec string) (Service, string, bool) {
+	u, err := url.Parse(spec)
+	if err != nil || u.Host == "" {
+		return Service{}, "", false
+	}
+	var scheme string
+	switch u.Scheme {
+	case "k8s":
+		scheme = "http"
+	case "k8s+https":
+		scheme = "https"
+	default:
+		return Service{}, "", false
+	}
+	name, port, _ := strings.Cut(strings.Trim(u.Path, "/"), ":")
+	if name == "" || strings.Contains(name, "/") {
+		return Service{}, "", false
+	}
+	return Service{Namespace: u.Host, Name: name, Port: port}, scheme, true
+}
+
+// Kubernetes discovers backends from the EndpointSlices of a Service. It
+// watches them, so pods are added as soon as they are ready and drained as
+// soon as they are not, and lists them again every resync in case a change
+// was missed.
+type Kubernetes struct {
+	client  *KubeClient
+	service Service
+	scheme  string
+	resync  time.Duration
+	rc      *Reconciler
+	logger  *slog.Logger
+
+	// slices and version are only used by Refresh and the watch, which
+	// never run at once
+	slices  map[string]endpointSlice // by name
+	version string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// endpointSlice is the part of a discovery.k8s.io/v1 EndpointSlice Nexus
+// uses
+type endpointSlice struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Endpoints []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			// Ready is unset when unknown, which counts as ready
+			Ready *bool `json:"ready"`
+		} `json:"conditions"`
+	} `json:"endpoints"`
+	Ports []struct {
+		Name string `json:"name"`
+		Port *int   `json:"port"`
+	} `json:"ports"`
+}
+
+// watchEvent is one event of a watch stream
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// NewKubernetes creates a source for service that reads through client and
+// applies what it finds through rc
+func NewKubernetes(client *KubeClient, service Service, scheme string, resync time.Duration, rc *Reconciler) *Kubernetes {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Kubernetes{
+		client:  client,
+		service: service,
+		scheme:  scheme,
+		resync:  resync,
+		rc:      rc,
+		logger:  slog.Default().With("component", "discovery", "source", rc.o.Source),
+		slices:  make(map[string]endpointSlice),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// path is the API path of the EndpointSlices in the service's namespace
+func (k *Kubernetes) path() string {
+	return "/apis/discovery.k8s.io/v1/namespaces/" + url.PathEscape(k.service.Namespace) + "/endpointslices"
+}
+
+// query selects the EndpointSlices of the service
+func (k *Kubernetes) query() url.Values {
+	return url.Values{"labelSelector": {"kubernetes.io/service-name=" + k.service.Name}}
+}
+
+// Refresh lists the service's EndpointSlices and applies their ready
+// endpoints. If listing fails the backends found before are kept.
+func (k *Kubernetes) Refresh() error {
+	ctx, cancel := context.WithTimeout(k.ctx, listTimeout)
+	defer cancel()
+	body, err := k.client.get(ctx, k.path(), k.query())
+	if err != nil {
+		k.rc.Failed(err)
+		return err
+	}
+	defer body.Close()
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []endpointSlice `json:"items"`
+	}
+	if err := json.NewDecoder(body).Decode(&list); err != nil {
+		err = fmt.Errorf("decoding EndpointSlice list: %w", err)
+		k.rc.Failed(err)
+		return err
+	}
+	clear(k.slices)
+	for _, s := range list.Items {
+		k.slices[s.Metadata.Name] = s
+	}
+	k.version = list.Metadata.ResourceVersion
+	k.apply()
+	return nil
+}
+
+// watch applies changes to the EndpointSlices until the watch ends, which
+// the API server does after resync
+func (k *Kubernetes) watch() error {
+	q := k.query()
+	q.Set("watch", "1")
+	q.Set("resourceVersion", k.version)
+	q.Set("allowWatchBookmarks", "true")
+	q.Set("timeoutSeconds", strconv.Itoa(max(int(k.resync.Seconds()), 1)))
+	body, err := k.client.get(k.ctx, k.path(), q)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	dec := json.NewDecoder(body)
+	for {
+		var ev watchEvent
+		if err := dec.Decode(&ev); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if ev.Type == "ERROR" {
+			// Typically 410 Gone, once the version watched from is too old
+			var status struct {
+				Message string `json:"message"`
+			}
+			json.Unmarshal(ev.Object, &status)
+			return fmt.Errorf("watch ended: %s", status.Message)
+		}
+		var s endpointSlice
+		if err := json.Unmarshal(ev.Object, &s); err != nil {
+			return fmt.Errorf("decoding %s event: %w", ev.Type, err)
+		}
+		k.version = s.Metadata.ResourceVersion
+		switch ev.Type {
+		case "ADDED", "MODIFIED":
+			k.slices[s.Metadata.Name] = s
+		case "DELETED":
+			delete(k.slices, s.Metadata.Name)
+		default:
+			// BOOKMARK only moves the version on
+			continue
+		}
+		k.apply()
+	}
+}
+
+// apply applies the ready endpoints of all slices
+func (k *Kubernetes) apply() {
+	var targets []Target
+	for _, s := range k.slices {
+		port := k.port(s)
+		if port == 0 {
+			continue
+		}
+		for _, ep := range s.Endpoints {
+			if len(ep.Addresses) == 0 || (ep.Conditions.Ready != nil && !*ep.Conditions.Ready) {
+				continue
+			}
+			// The addresses of an endpoint are fungible; use the first
+			targets = append(targets, Target{
+				URL:    k.scheme + "://" + net.JoinHostPort(ep.Addresses[0], strconv.Itoa(port)),
+				Weight: 1,
+			})
+		}
+	}
+	k.rc.Apply(targets)
+}
+
+// port returns the port of s to use, or 0 if s has none by that name
+func (k *Kubernetes) port(s endpointSlice) int {
+	for _, p := range s.Ports {
+		if p.Port == nil {
+			continue
+		}
+		if p.Name == k.service.Port || (k.service.Port == "" && len(s.Ports) == 1) {
+			return *p.Port
+		}
+	}
+	return 0
+}
+
+// Start watches the service in a separate goroutine
+func (k *Kubernetes) Start() {
+	k.logger.Info("watching EndpointSlices", "resync", k.resync)
+	k.wg.Add(1)
+	go func() {
+		defer k.wg.Done()
+		for {
+			var err error
+			if k.version == "" {
+				err = k.Refresh()
+			}
+			if err == nil {
+				err = k.watch()
+			}
+			if k.ctx.Err() != nil {
+				return
+			}
+			// List again, whether the watch ended for the resync or failed
+			k.version = ""
+			if err == nil {
+				continue
+			}
+			k.rc.Failed(err)
+			k.logger.Warn("EndpointSlice watch failed, keeping current backends", "error", err)
+			select {
+			case <-time.After(rewatchDelay):
+			case <-k.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops watching the service
+func (k *Kubernetes) Stop() {
+	k.cancel()
+	k.wg.Wait()
+}
@@ -0,0 +1,343 @@
+// Package discovery keeps the backends of the pool in line with service
+// discovery. Each source reports the full set of backends it finds, and a
+// Reconciler adds the new ones to the pool and drains the ones gone.
+package discovery
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/freeze"
+	"github.com/nexus-lb/nexus/internal/journal"
+	"github.com/nexus-lb/nexus/internal/pool"
+)
+
+// Source is a discovery source, feeding what it finds to a Reconciler
+type Source interface {
+	// Refresh looks the backends up once and applies them
+	Refresh() error
+	// Start keeps looking them up in the background until Stop
+	Start()
+	Stop()
+}
+
+// Target is a backend found by a discovery source
+type Target struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+	Tier   int    `json:"tier"`
+	// Labels are free-form, shown with the backend
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// equal reports whether t and o describe the same backend
+func (t Target) equal(o Target) bool {
+	return t.URL == o.URL && t.Weight == o.Weight && t.Tier == o.Tier && maps.Equal(t.Labels, o.Labels)
+}
+
+// Options configure a Reconciler
+type Options struct {
+	// Source names the discovery source in logs and the change journal
+	Source string
+	// Origin is the kind of source, one of the backend Origin constants;
+	// backends it adds are marked with it and with Source
+	Origin string
+	Pool   *pool.ServerPool
+	// NewBackend creates the backend of a target
+	NewBackend func(Target) (*backend.Backend, error)
+	// DrainTimeout bounds how long a backend gone from discovery may take
+	// to finish its in-flight requests before it is removed
+	DrainTimeout time.Duration
+	Journal      *journal.Journal
+	// Gate, if set, decides whether what the source found is applied at
+	// once or held back, as it is while Nexus is frozen
+	Gate backend.Gate
+}
+
+// Reconciler applies the backends a source discovers to the pool. It only
+// ever adds and removes the backends it added itself, so configured
+// backends and those of other sources are left alone. A URL already in the
+// pool stays with whoever added it first.
+type Reconciler struct {
+	o      Options
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	members map[string]Target // by URL
+	// conflicts are targets left out since another origin has their URL,
+	// by URL, so each conflict is logged once
+	conflicts map[string]string
+	synced    time.Time // when the source last answered
+	stale     time.Time // since when it has not, zero while it does
+	err       error
+	// held is set while targets are held back by the gate
+	held bool
+}
+
+// Status describes the state of a discovery source
+type Status struct {
+	Source   string    `json:"source"`
+	Members  int       `json:"members"`
+	LastSync time.Time `json:"last_sync,omitzero"`
+	// Stale is set while the source cannot be reached; the members last
+	// found are kept in the meantime
+	Stale      bool      `json:"stale"`
+	StaleSince time.Time `json:"stale_since,omitzero"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// NewReconciler creates a reconciler that has added no backends yet
+func NewReconciler(o Options) *Reconciler {
+	return &Reconciler{
+		o:         o,
+		logger:    slog.Default().With("component", "discovery", "source", o.Source),
+		members:   make(map[string]Target),
+		conflicts: make(map[string]string),
+	}
+}
+
+// Apply makes the source's backends in the pool those of targets. New
+// targets are added, targets gone are drained and removed, and a target
+// whose weight, tier or labels changed is replaced, the old backend
+// draining as the new one takes over. Every change is logged
+// as one diff and recorded in the change journal.
+//
+// With a gate, the changes pass through it as one: held back, only the
+// latest targets of the source are kept, to be diffed against the members
+// of the time they are applied, if they are.
+func (rc *Reconciler) Apply(targets []Target) {
+	rc.mu.Lock()
+	if !rc.stale.IsZero() {
+		rc.logger.Info("discovery source recovered", "stale_for", time.Since(rc.stale).Round(time.Second))
+	}
+	rc.synced, rc.stale, rc.err = time.Now(), time.Time{}, nil
+	kind, summary := rc.pending(targets)
+	// Targets held back are replaced even by ones changing nothing, so
+	// they are not applied later
+	held := rc.held
+	rc.mu.Unlock()
+
+	if kind == "" && !held {
+		return
+	}
+	if rc.o.Gate == nil {
+		rc.apply(targets)
+		return
+	}
+	if kind == "" {
+		kind = freeze.KindBackends
+	}
+	if !rc.o.Gate.Submit(kind, "discovery:"+rc.o.Source, rc.o.Source+": "+summary, func() { rc.apply(targets) }) {
+		rc.mu.Lock()
+		rc.held = true
+		rc.mu.Unlock()
+	}
+}
+
+// pending tells what applying targets would change: the kind of change,
+// weights if nothing else would, and a summary. The kind is empty if
+// nothing would change. The caller holds mu.
+func (rc *Reconciler) pending(targets []Target) (kind, summary string) {
+	want := make(map[string]Target, len(targets))
+	for _, t := range targets {
+		want[t.URL] = t
+	}
+	var added, removed, changed int
+	weightsOnly := true
+	for url, old := range rc.members {
+		t, ok := want[url]
+		switch {
+		case !ok:
+			removed++
+		case !t.equal(old):
+			changed++
+			old.Weight = t.Weight
+			weightsOnly = weightsOnly && t.equal(old)
+		}
+	}
+	for url := range want {
+		if _, ok := rc.members[url]; ok {
+			continue
+		}
+		if b := rc.o.Pool.GetBackend(url); b == nil || b.Source == rc.o.Source {
+			added++
+		}
+	}
+	summary = fmt.Sprintf("%d backends to add, %d to remove, %d to change", added, removed, changed)
+	switch {
+	case added+removed+changed == 0:
+		return "", summary
+	case added+removed == 0 && weightsOnly:
+		return freeze.KindWeights, summary
+	default:
+		return freeze.KindBackends, summary
+	}
+}
+
+// apply makes the source's backends in the pool those of targets
+func (rc *Reconciler) apply(targets []Target) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.held = false
+
+	want := make(map[string]Target, len(targets))
+	for _, t := range targets {
+		want[t.URL] = t
+	}
+	var added, removed, changed []string
+	var diff []journal.Change
+	for _, url := range slices.Sorted(maps.Keys(rc.members)) {
+		old := rc.members[url]
+		t, ok := want[url]
+		switch {
+		case !ok:
+			if b := rc.own(url); b != nil {
+				go rc.o.Pool.DrainBackend(b, rc.o.DrainTimeout)
+			}
+			delete(rc.members, url)
+			removed = append(removed, url)
+			diff = append(diff, change(url, "removed", &old, nil))
+		case !t.equal(old):
+			// The old backend drains while its replacement takes over
+			delete(rc.members, url)
+			if !rc.replace(rc.own(url), t) {
+				removed = append(removed, url)
+				diff = append(diff, change(url, "removed", &old, nil))
+				continue
+			}
+			changed = append(changed, url)
+			diff = append(diff, change(url, "changed", &old, &t))
+		}
+	}
+	for _, url := range slices.Sorted(maps.Keys(want)) {
+		if _, ok := rc.members[url]; ok {
+			continue
+		}
+		t := want[url]
+		if b := rc.own(url); b != nil {
+			// Still draining since it was last gone; replace it, leaving
+			// its in-flight requests to finish
+			if rc.replace(b, t) {
+				added = append(added, url)
+				diff = append(diff, change(url, "added", nil, &t))
+			}
+			continue
+		}
+		if b := rc.o.Pool.GetBackend(url); b != nil {
+			// Configured, or found by another source first
+			if origin := describeOrigin(b); rc.conflicts[url] != origin {
+				rc.conflicts[url] = origin
+				rc.logger.Warn("discovered backend is already in the pool, keeping the first",
+					"backend", url, "origin", origin)
+			}
+			continue
+		}
+		delete(rc.conflicts, url)
+		if rc.add(t) {
+			added = append(added, url)
+			diff = append(diff, change(url, "added", nil, &t))
+		}
+	}
+	for url := range rc.conflicts {
+		if _, ok := want[url]; !ok {
+			delete(rc.conflicts, url)
+		}
+	}
+	if len(diff) == 0 {
+		return
+	}
+
+	rc.logger.Info("discovered backends changed",
+		"added", added, "removed", removed, "changed", changed, "members", len(rc.members))
+	rc.o.Journal.Record(journal.TypeDiscovery, rc.o.Source,
+		fmt.Sprintf("%s: %d backends added, %d removed, %d changed", rc.o.Source, len(added), len(removed), len(changed)),
+		diff)
+}
+
+// Failed records that the source could not be read, marking it stale until
+// the next Apply. The members are left as they are.
+func (rc *Reconciler) Failed(err error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.stale.IsZero() {
+		rc.stale = time.Now()
+	}
+	rc.err = err
+}
+
+// Status returns the state of the source
+func (rc *Reconciler) Status() Status {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	st := Status{
+		Source:     rc.o.Source,
+		Members:    len(rc.members),
+		LastSync:   rc.synced,
+		Stale:      !rc.stale.IsZero(),
+		StaleSince: rc.stale,
+	}
+	if rc.err != nil {
+		st.Error = rc.err.Error()
+	}
+	return st
+}
+
+// add creates the backend of t and adds it to the pool; the caller holds mu
+func (rc *Reconciler) add(t Target) bool {
+	return rc.replace(nil, t)
+}
+
+// replace creates the backend of t and puts it in the place of old, which
+// is drained, or adds it if old is nil or gone; if the backend cannot be
+// created, old is drained all the same. The caller holds mu.
+func (rc *Reconciler) replace(old *backend.Backend, t Target) bool {
+	b, err := rc.o.NewBackend(t)
+	if err != nil {
+		rc.logger.Warn("cannot create discovered backend", "backend", t.URL, "error", err)
+		if old != nil {
+			go rc.o.Pool.DrainBackend(old, rc.o.DrainTimeout)
+		}
+		return false
+	}
+	b.Origin, b.Source = rc.o.Origin, rc.o.Source
+	if old == nil || !rc.o.Pool.ReplaceBackend(old, b, rc.o.DrainTimeout) {
+		rc.o.Pool.AddBackend(b)
+	}
+	rc.members[t.URL] = t
+	return true
+}
+
+// own returns the pool's backend at url if this source added it; one
+// removed and added again by someone else is not touched
+func (rc *Reconciler) own(url string) *backend.Backend {
+	if b := rc.o.Pool.GetBackend(url); b != nil && b.Source == rc.o.Source {
+		return b
+	}
+	return nil
+}
+
+// describeOrigin names where b came from for logs
+func describeOrigin(b *backend.Backend) string {
+	if b.Source != "" {
+		return b.Origin + " " + b.Source
+	}
+	return b.Origin
+}
+
+// change describes a membership change for the journal; old or new is nil
+// for a target added or removed
+func change(url, op string, old, new *Target) journal.Change {
+	c := journal.Change{Path: fmt.Sprintf("backends[url=%s]", url), Op: op}
+	if old != nil {
+		c.Old = *old
+	}
+	if new != nil {
+		c.New = *new
+	}
+	return c
+}
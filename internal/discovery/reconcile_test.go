@@ -0,0 +1,215 @@
+package discovery
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/freeze"
+	"github.com/nexus-lb/nexus/internal/journal"
+	"github.com/nexus-lb/nexus/internal/pool"
+)
+
+func newTestReconciler(t *testing.T, p *pool.ServerPool, gate backend.Gate) *Reconciler {
+	t.Helper()
+	return NewReconciler(Options{
+		Source: "test",
+		Origin: backend.OriginFile,
+		Pool:   p,
+		NewBackend: func(tg Target) (*backend.Backend, error) {
+			return backend.NewBackend(tg.URL, backend.WithWeight(tg.Weight), backend.WithTier(tg.Tier))
+		},
+		DrainTimeout: time.Second,
+		Journal:      journal.New(16, nil),
+		Gate:         gate,
+	})
+}
+
+func urls(p *pool.ServerPool) []string {
+	var us []string
+	for _, b := range p.GetBackends() {
+		us = append(us, b.URL.String())
+	}
+	slices.Sort(us)
+	return us
+}
+
+// waitURLs waits for the pool to hold want, since backends gone are drained
+// in the background
+func waitURLs(t *testing.T, p *pool.ServerPool, want ...string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !slices.Equal(urls(p), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("pool holds %v, want %v", urls(p), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestApply(t *testing.T) {
+	p := &pool.ServerPool{}
+	rc := newTestReconciler(t, p, nil)
+
+	rc.Apply([]Target{{URL: "http://a:80", Weight: 1}, {URL: "http://b:80", Weight: 1}})
+	waitURLs(t, p, "http://a:80", "http://b:80")
+	if b := p.GetBackend("http://a:80"); b.Origin != backend.OriginFile || b.Source != "test" {
+		t.Errorf("origin %q source %q", b.Origin, b.Source)
+	}
+
+	rc.Apply([]Target{{URL: "http://a:80", Weight: 3}, {URL: "http://c:80", Weight: 1}})
+	waitURLs(t, p, "http://a:80", "http://c:80")
+	if w := p.GetBackend("http://a:80").Weight; w != 3 {
+		t.Errorf("weight %d, want 3", w)
+	}
+	if st := rc.Status(); st.Members != 2 || st.Stale {
+		t.Errorf("Status = %+v", st)
+	}
+}
+
+func TestWeightChangeKeepsInflight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	}))
+	defer up.Close()
+	p := &pool.ServerPool{}
+	rc := newTestReconciler(t, p, nil)
+	rc.Apply([]Target{{URL: up.URL, Weight: 1}})
+	old := p.GetBackend(up.URL)
+
+	w := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		old.ReverseProxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		close(served)
+	}()
+	<-started
+
+	rc.Apply([]Target{{URL: up.URL, Weight: 3}})
+	b := p.GetBackend(up.URL)
+	if p.GetPoolSize() != 1 || b == old || b.Weight != 3 {
+		t.Fatalf("pool holds %v, want the old backend replaced by one of weight 3", urls(p))
+	}
+	if !old.Draining() {
+		t.Error("replaced backend is not draining")
+	}
+
+	close(release)
+	<-served
+	if w.Code != http.StatusOK || w.Body.String() != "done" {
+		t.Errorf("in-flight request got %d %q, want it complete", w.Code, w.Body.String())
+	}
+}
+
+func TestApplyLeavesOthersAlone(t *testing.T) {
+	p := &pool.ServerPool{}
+	static, _ := backend.NewBackend("http://a:80")
+	p.AddBackend(static)
+	rc := newTestReconciler(t, p, nil)
+
+	rc.Apply([]Target{{URL: "http://a:80", Weight: 5}, {URL: "http://b:80", Weight: 1}})
+	if p.GetBackend("http://a:80") != static {
+		t.Fatal("configured backend replaced by a discovered one")
+	}
+	rc.Apply(nil)
+	waitURLs(t, p, "http://a:80")
+}
+
+func TestFailedMarksStale(t *testing.T) {
+	p := &pool.ServerPool{}
+	rc := newTestReconciler(t, p, nil)
+	rc.Apply([]Target{{URL: "http://a:80", Weight: 1}})
+	rc.Failed(errors.New("unreachable"))
+	if st := rc.Status(); !st.Stale || st.Error == "" || st.Members != 1 {
+		t.Errorf("Status = %+v", st)
+	}
+	rc.Apply([]Target{{URL: "http://a:80", Weight: 1}})
+	if rc.Status().Stale {
+		t.Error("still stale after the source answered")
+	}
+}
+
+func TestFrozenQueueThenApply(t *testing.T) {
+	p := &pool.ServerPool{}
+	fc := freeze.NewController(time.Hour, false, false)
+	rc := newTestReconciler(t, p, fc)
+	rc.Apply([]Target{{URL: "http://a:80", Weight: 1}})
+
+	fc.Freeze("test", "incident", 0)
+	rc.Apply([]Target{{URL: "http://a:80", Weight: 1}, {URL: "http://b:80", Weight: 1}})
+	rc.Apply([]Target{{URL: "http://b:80", Weight: 1}, {URL: "http://c:80", Weight: 1}})
+	waitURLs(t, p, "http://a:80")
+
+	// The source keeps a single entry, with its latest targets
+	changes := fc.Changes()
+	if len(changes) != 1 || changes[0].Kind != freeze.KindBackends || changes[0].Key != "discovery:test" {
+		t.Fatalf("queued %+v", changes)
+	}
+	if applied, _ := fc.Unfreeze("test", true); applied != 1 {
+		t.Fatalf("applied %d changes, want 1", applied)
+	}
+	waitURLs(t, p, "http://b:80", "http://c:80")
+}
+
+func TestFrozenQueueThenDiscard(t *testing.T) {
+	p := &pool.ServerPool{}
+	fc := freeze.NewController(time.Hour, false, false)
+	rc := newTestReconciler(t, p, fc)
+	rc.Apply([]Target{{URL: "http://a:80", Weight: 1}})
+
+	fc.Freeze("test", "incident", 0)
+	rc.Apply(nil)
+	if _, discarded := fc.Unfreeze("test", false); discarded != 1 {
+		t.Fatalf("discarded %d changes, want 1", discarded)
+	}
+	waitURLs(t, p, "http://a:80")
+	if rc.Status().Members != 1 {
+		t.Error("discarded removal still dropped the member")
+	}
+}
+
+func TestFrozenWeightChange(t *testing.T) {
+	p := &pool.ServerPool{}
+	fc := freeze.NewController(time.Hour, false, false)
+	rc := newTestReconciler(t, p, fc)
+	rc.Apply([]Target{{URL: "http://a:80", Weight: 1}})
+
+	fc.Freeze("test", "incident", 0)
+	rc.Apply([]Target{{URL: "http://a:80", Weight: 4}})
+	if changes := fc.Changes(); len(changes) != 1 || changes[0].Kind != freeze.KindWeights {
+		t.Fatalf("queued %+v", changes)
+	}
+	if w := p.GetBackend("http://a:80").Weight; w != 1 {
+		t.Fatalf("weight changed to %d while frozen", w)
+	}
+	fc.Unfreeze("test", true)
+	if w := p.GetBackend("http://a:80").Weight; w != 4 {
+		t.Errorf("weight %d after unfreezing, want 4", w)
+	}
+}
+
+// TestFrozenRevert checks that a source going back to what the pool holds
+// replaces the queued change, so the freeze lifting changes nothing
+func TestFrozenRevert(t *testing.T) {
+	p := &pool.ServerPool{}
+	fc := freeze.NewController(time.Hour, false, false)
+	rc := newTestReconciler(t, p, fc)
+	rc.Apply([]Target{{URL: "http://a:80", Weight: 1}})
+
+	fc.Freeze("test", "incident", 0)
+	rc.Apply(nil)
+	rc.Apply([]Target{{URL: "http://a:80", Weight: 1}})
+	fc.Unfreeze("test", true)
+	if rc.Status().Members != 1 || p.GetBackend("http://a:80").Draining() {
+		t.Error("the removal held back was applied after the source reverted it")
+	}
+}
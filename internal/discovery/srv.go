@@ -0,0 +1,139 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resolveTimeout bounds a single SRV lookup
+const resolveTimeout = 5 * time.Second
+
+// SRV discovers backends from a DNS SRV record, such as those Consul and
+// service meshes publish, and resolves it again every interval. Each target
+// becomes a backend of the record's weight, its tier the rank of its
+// priority: the lowest priority is the primary tier, higher ones backups.
+type SRV struct {
+	name     string
+	scheme   string
+	interval time.Duration
+	rc       *Reconciler
+	resolver *net.Resolver
+	logger   *slog.Logger
+
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// ParseSRV parses a backend spec such as srv://_app._tcp.service.consul
+// into the record's name and the scheme its backends are reached with:
+// "http", or "https" for srv+https://. It reports false for any other spec.
+func ParseSRV(spec string) (name, scheme string, ok bool) {
+	u, err := url.Parse(spec)
+	if err != nil || u.Host == "" {
+		return "", "", false
+	}
+	switch u.Scheme {
+	case "srv":
+		return u.Host, "http", true
+	case "srv+https":
+		return u.Host, "https", true
+	}
+	return "", "", false
+}
+
+// NewSRV creates a source for the SRV record name that applies what it
+// finds through rc
+func NewSRV(name, scheme string, interval time.Duration, rc *Reconciler) *SRV {
+	return &SRV{
+		name:     name,
+		scheme:   scheme,
+		interval: interval,
+		rc:       rc,
+		resolver: net.DefaultResolver,
+		logger:   slog.Default().With("component", "discovery", "source", rc.o.Source),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Refresh resolves the record and applies its targets. If the lookup fails
+// the backends found before are kept, so a DNS outage never empties the
+// pool.
+func (s *SRV) Refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	_, records, err := s.resolver.LookupSRV(ctx, "", "", s.name)
+	if err != nil {
+		s.rc.Failed(err)
+		return err
+	}
+	targets := s.targets(records)
+	if len(targets) == 0 {
+		err := fmt.Errorf("no usable targets in SRV record %s", s.name)
+		s.rc.Failed(err)
+		return err
+	}
+	s.rc.Apply(targets)
+	return nil
+}
+
+// targets converts SRV records into backends
+func (s *SRV) targets(records []*net.SRV) []Target {
+	var priorities []uint16
+	for _, rec := range records {
+		if !slices.Contains(priorities, rec.Priority) {
+			priorities = append(priorities, rec.Priority)
+		}
+	}
+	slices.Sort(priorities)
+
+	targets := make([]Target, 0, len(records))
+	for _, rec := range records {
+		host := strings.TrimSuffix(rec.Target, ".")
+		// A target of "." says the service is not available at this name
+		if host == "" {
+			continue
+		}
+		targets = append(targets, Target{
+			URL: s.scheme + "://" + net.JoinHostPort(host, strconv.Itoa(int(rec.Port))),
+			// SRV weights may be 0, backend weights may not
+			Weight: max(int(rec.Weight), 1),
+			Tier:   slices.Index(priorities, rec.Priority),
+		})
+	}
+	return targets
+}
+
+// Start resolves the record every interval in a separate goroutine
+func (s *SRV) Start() {
+	s.logger.Info("resolving SRV record", "interval", s.interval)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Refresh(); err != nil {
+					s.logger.Warn("SRV lookup failed, keeping current backends", "error", err)
+				}
+			case <-s.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops resolving the record
+func (s *SRV) Stop() {
+	close(s.stopChan)
+	s.wg.Wait()
+}
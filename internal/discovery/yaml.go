@@ -0,0 +1,187 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// yamlToJSON converts the YAML kubeconfig files are written in to JSON, so
+// they decode like any other document. Only the block style kubectl writes
+// is understood: nested mappings, "- " sequences, plain and quoted scalars,
+// comments and the empty {} and []. A document in JSON is returned as is.
+func yamlToJSON(data []byte) ([]byte, error) {
+	if s := strings.TrimSpace(string(data)); strings.HasPrefix(s, "{") {
+		return data, nil
+	}
+	p := &yamlParser{}
+	for n, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(raw, " \t\r")
+		text := strings.TrimLeft(raw, " ")
+		if text == "" || strings.HasPrefix(text, "#") || text == "---" {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: tabs cannot indent YAML", n+1)
+		}
+		p.lines = append(p.lines, yamlLine{n: n + 1, indent: len(raw) - len(text), text: text})
+	}
+	if len(p.lines) == 0 {
+		return []byte("{}"), nil
+	}
+	v, err := p.value(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.i].n)
+	}
+	return json.Marshal(v)
+}
+
+// yamlLine is a line holding content, its indentation taken off
+type yamlLine struct {
+	n      int
+	indent int
+	text   string
+}
+
+// yamlParser walks the lines of a document
+type yamlParser struct {
+	lines []yamlLine
+	i     int
+}
+
+// value parses the mapping or sequence starting at the current line
+func (p *yamlParser) value(indent int) (any, error) {
+	if isItem(p.lines[p.i].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+// sequence parses the items at indent
+func (p *yamlParser) sequence(indent int) ([]any, error) {
+	items := []any{}
+	for p.i < len(p.lines) && p.lines[p.i].indent == indent && isItem(p.lines[p.i].text) {
+		l := p.lines[p.i]
+		rest := strings.TrimLeft(l.text[1:], " ")
+		if rest == "" {
+			p.i++
+			item, err := p.nested(indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+		if _, _, ok := splitKey(rest); !ok && !isItem(rest) {
+			items = append(items, scalar(rest))
+			p.i++
+			continue
+		}
+		// "- key: value" opens a mapping indented as far as its key
+		p.lines[p.i] = yamlLine{n: l.n, indent: l.indent + len(l.text) - len(rest), text: rest}
+		item, err := p.value(p.lines[p.i].indent)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// mapping parses the keys at indent
+func (p *yamlParser) mapping(indent int) (map[string]any, error) {
+	m := map[string]any{}
+	for p.i < len(p.lines) && p.lines[p.i].indent == indent && !isItem(p.lines[p.i].text) {
+		l := p.lines[p.i]
+		key, rest, ok := splitKey(l.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", l.n)
+		}
+		p.i++
+		if rest != "" {
+			m[key] = scalar(rest)
+			continue
+		}
+		v, err := p.nested(indent)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	if p.i < len(p.lines) && p.lines[p.i].indent > indent {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.i].n)
+	}
+	return m, nil
+}
+
+// nested parses the value of a key or item given on the lines below it: a
+// block indented further, or a sequence at the key's own indentation
+func (p *yamlParser) nested(indent int) (any, error) {
+	if p.i == len(p.lines) {
+		return nil, nil
+	}
+	next := p.lines[p.i]
+	if next.indent > indent || (next.indent == indent && isItem(next.text)) {
+		return p.value(next.indent)
+	}
+	return nil, nil
+}
+
+// isItem reports whether text starts a sequence item
+func isItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitKey splits "key: value" into its key and value, the value empty if
+// it follows on the next lines
+func splitKey(text string) (key, rest string, ok bool) {
+	if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'") {
+		end := strings.IndexByte(text[1:], text[0])
+		if end < 0 {
+			return "", "", false
+		}
+		key, text = text[1:end+1], text[end+2:]
+		if text != ":" && !strings.HasPrefix(text, ": ") {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(text[1:]), true
+	}
+	if strings.HasSuffix(text, ":") && !strings.Contains(text, ": ") {
+		return text[:len(text)-1], "", true
+	}
+	key, rest, ok = strings.Cut(text, ": ")
+	return key, strings.TrimSpace(rest), ok
+}
+
+// scalar converts a scalar to its value
+func scalar(text string) any {
+	switch {
+	case text == "{}":
+		return map[string]any{}
+	case text == "[]":
+		return []any{}
+	case len(text) >= 2 && text[0] == '"' && text[len(text)-1] == '"':
+		var s string
+		if json.Unmarshal([]byte(text), &s) == nil {
+			return s
+		}
+		return text[1 : len(text)-1]
+	case len(text) >= 2 && text[0] == '\'' && text[len(text)-1] == '\'':
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'")
+	}
+	if i := strings.Index(text, " #"); i >= 0 {
+		text = strings.TrimSpace(text[:i])
+	}
+	switch text {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null", "~":
+		return nil
+	}
+	return text
+}
@@ -0,0 +1,162 @@
+package errorpage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	htmltemplate "html/template"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Data is what error page templates are rendered with
+type Data struct {
+	Status     int
+	StatusText string
+	RequestID  string
+	Time       string
+	Method     string
+	Path       string
+	// Route names the route the request took, empty if none
+	Route string
+}
+
+type routeKey struct{}
+
+// WithRoute returns a copy of r whose error pages name route
+func WithRoute(r *http.Request, route string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), routeKey{}, route))
+}
+
+// page holds the templates configured for one status code; either may be nil
+type page struct {
+	html *htmltemplate.Template
+	json *template.Template
+}
+
+// Pages renders operator-defined bodies for the error responses Nexus
+// writes itself. A nil *Pages, or a status without a page, leaves the
+// response to the caller's built-in default.
+type Pages struct {
+	pages      map[int]*page
+	retryAfter time.Duration
+}
+
+// New creates an empty set of error pages
+func New() *Pages {
+	return &Pages{pages: make(map[int]*page)}
+}
+
+// Set configures the page for a status from an HTML template and a JSON
+// template, either of which may be empty. HTML is escaped automatically;
+// JSON templates get a json function that quotes a value, as in
+// {"request_id": {{json .RequestID}}}.
+func (p *Pages) Set(status int, html, jsonBody string) error {
+	pg := &page{}
+	name := strconv.Itoa(status)
+	if html != "" {
+		t, err := htmltemplate.New(name).Parse(html)
+		if err != nil {
+			return err
+		}
+		pg.html = t
+	}
+	if jsonBody != "" {
+		t, err := template.New(name).Funcs(template.FuncMap{"json": quoteJSON}).Parse(jsonBody)
+		if err != nil {
+			return err
+		}
+		pg.json = t
+	}
+	p.pages[status] = pg
+	return nil
+}
+
+// SetRetryAfter makes every 503 carry a Retry-After header of d
+func (p *Pages) SetRetryAfter(d time.Duration) {
+	p.retryAfter = d
+}
+
+// Render writes the configured error response for status. It reports false
+// without writing anything when there is none, so the caller can fall back
+// to its default; a configured Retry-After is added to 503s either way.
+func (p *Pages) Render(w http.ResponseWriter, r *http.Request, status int) bool {
+	if p == nil {
+		return false
+	}
+	if status == http.StatusServiceUnavailable && p.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int((p.retryAfter+time.Second-1)/time.Second)))
+	}
+	pg := p.pages[status]
+	if pg == nil {
+		return false
+	}
+
+	data := Data{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		RequestID:  r.Header.Get("X-Request-Id"),
+		Time:       time.Now().UTC().Format(time.RFC3339),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+	}
+	data.Route, _ = r.Context().Value(routeKey{}).(string)
+	var (
+		body        bytes.Buffer
+		err         error
+		contentType string
+	)
+	if pg.json != nil && (pg.html == nil || prefersJSON(r.Header.Get("Accept"))) {
+		contentType = "application/json"
+		err = pg.json.Execute(&body, data)
+	} else {
+		contentType = "text/html; charset=utf-8"
+		err = pg.html.Execute(&body, data)
+	}
+	if err != nil {
+		slog.Warn("rendering error page failed", "component", "errorpage", "status", status, "error", err)
+		return false
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(body.Bytes())
+	return true
+}
+
+// prefersJSON reports whether an Accept header ranks JSON above HTML
+func prefersJSON(accept string) bool {
+	jsonQ, htmlQ := -1.0, -1.0
+	for part := range strings.SplitSeq(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		switch {
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			jsonQ = max(jsonQ, q)
+		case mediaType == "text/html":
+			htmlQ = max(htmlQ, q)
+		}
+	}
+	return jsonQ > 0 && jsonQ > htmlQ
+}
+
+// quoteJSON renders a value as JSON for use inside JSON templates
+func quoteJSON(v any) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
@@ -0,0 +1,232 @@
+package freeze
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Kinds of automatic state changes the controller can hold back
+const (
+	KindHealth   = "health"
+	KindBackends = "backends"
+	KindWeights  = "weights"
+)
+
+// Change is an automatic state change that was queued while Nexus was frozen
+type Change struct {
+	ID          uint64    `json:"id"`
+	Kind        string    `json:"kind"`
+	Key         string    `json:"key"`
+	Description string    `json:"description"`
+	QueuedAt    time.Time `json:"queued_at"`
+	apply       func()
+}
+
+// Status describes the current freeze state
+type Status struct {
+	Frozen        bool      `json:"frozen"`
+	Reason        string    `json:"reason,omitempty"`
+	Since         time.Time `json:"since,omitzero"`
+	ExpiresAt     time.Time `json:"expires_at,omitzero"`
+	LogOnlyHealth bool      `json:"log_only_health"`
+	QueuedChanges int       `json:"queued_changes"`
+}
+
+// Controller locks automatic state changes while an operator has frozen Nexus
+type Controller struct {
+	maxDuration   time.Duration
+	applyOnExpire bool
+	logOnlyHealth bool
+
+	mu        sync.Mutex
+	frozen    bool
+	reason    string
+	since     time.Time
+	expiresAt time.Time
+	timer     *time.Timer
+	queue     []*Change
+	nextID    uint64
+
+	notify func(actor, summary string)
+	logger *slog.Logger
+}
+
+// NewController creates a freeze controller; freezes never last longer than maxDuration
+func NewController(maxDuration time.Duration, applyOnExpire, logOnlyHealth bool) *Controller {
+	return &Controller{
+		maxDuration:   maxDuration,
+		applyOnExpire: applyOnExpire,
+		logOnlyHealth: logOnlyHealth,
+		logger:        slog.Default().With("component", "freeze"),
+	}
+}
+
+// SetLogger sets the logger the controller reports through
+func (c *Controller) SetLogger(l *slog.Logger) {
+	c.logger = l.With("component", "freeze")
+}
+
+// SetNotify registers a callback that is told about every freeze and
+// unfreeze along with who caused it
+func (c *Controller) SetNotify(notify func(actor, summary string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.notify = notify
+}
+
+// notifyChange reports a freeze state change to the registered callback
+func (c *Controller) notifyChange(actor, summary string) {
+	c.mu.Lock()
+	notify := c.notify
+	c.mu.Unlock()
+	if notify != nil {
+		notify(actor, summary)
+	}
+}
+
+// Freeze starts (or extends) a freeze for the given duration, capped at the
+// configured maximum. A zero duration freezes for the maximum. actor
+// identifies who asked for it.
+func (c *Controller) Freeze(actor, reason string, d time.Duration) Status {
+	if d <= 0 || d > c.maxDuration {
+		d = c.maxDuration
+	}
+
+	c.mu.Lock()
+	if !c.frozen {
+		c.frozen = true
+		c.since = time.Now()
+	}
+	c.reason = reason
+	c.expiresAt = time.Now().Add(d)
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.timer = time.AfterFunc(d, c.expire)
+	c.mu.Unlock()
+
+	c.logger.Info("automatic state changes frozen", "duration", d, "reason", reason, "actor", actor)
+	c.notifyChange(actor, fmt.Sprintf("frozen for %v (reason: %q)", d, reason))
+	return c.Status()
+}
+
+// Unfreeze lifts the freeze and either applies or discards the queued changes
+func (c *Controller) Unfreeze(actor string, apply bool) (applied, discarded int) {
+	c.mu.Lock()
+	if !c.frozen {
+		c.mu.Unlock()
+		return 0, 0
+	}
+	queue := c.queue
+	c.queue = nil
+	c.frozen = false
+	c.reason = ""
+	c.since = time.Time{}
+	c.expiresAt = time.Time{}
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.mu.Unlock()
+
+	for _, change := range queue {
+		if apply {
+			change.apply()
+			applied++
+		} else {
+			discarded++
+		}
+	}
+
+	c.logger.Info("unfrozen", "applied", applied, "discarded", discarded, "actor", actor)
+	c.notifyChange(actor, fmt.Sprintf("unfrozen: %d queued changes applied, %d discarded", applied, discarded))
+	return applied, discarded
+}
+
+// expire is called by the timer when a freeze reaches its expiry time
+func (c *Controller) expire() {
+	c.mu.Lock()
+	expired := c.frozen && !time.Now().Before(c.expiresAt)
+	c.mu.Unlock()
+
+	if expired {
+		c.logger.Info("freeze expired")
+		c.Unfreeze("expiry", c.applyOnExpire)
+	}
+}
+
+// Submit applies an automatic change immediately, or queues it if Nexus is
+// frozen and the change's kind is held. A queued change with the same key
+// replaces the earlier one so only the latest intent is kept. It reports
+// whether the change was applied.
+func (c *Controller) Submit(kind, key, description string, apply func()) bool {
+	c.mu.Lock()
+	if !c.frozen || (kind == KindHealth && !c.logOnlyHealth) {
+		c.mu.Unlock()
+		apply()
+		return true
+	}
+
+	c.nextID++
+	change := &Change{
+		ID:          c.nextID,
+		Kind:        kind,
+		Key:         key,
+		Description: description,
+		QueuedAt:    time.Now(),
+		apply:       apply,
+	}
+
+	replaced := false
+	for i, queued := range c.queue {
+		if queued.Key == key {
+			c.queue[i] = change
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		c.queue = append(c.queue, change)
+	}
+	c.mu.Unlock()
+
+	if !replaced {
+		c.logger.Info("holding change while frozen", "kind", kind, "change", description)
+	}
+	return false
+}
+
+// Frozen reports whether Nexus is currently frozen
+func (c *Controller) Frozen() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.frozen
+}
+
+// Status returns a snapshot of the freeze state
+func (c *Controller) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Status{
+		Frozen:        c.frozen,
+		Reason:        c.reason,
+		Since:         c.since,
+		ExpiresAt:     c.expiresAt,
+		LogOnlyHealth: c.logOnlyHealth,
+		QueuedChanges: len(c.queue),
+	}
+}
+
+// Changes returns a copy of the queued changes in the order they were recorded
+func (c *Controller) Changes() []Change {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	changes := make([]Change, len(c.queue))
+	for i, change := range c.queue {
+		changes[i] = *change
+	}
+	return changes
+}
@@ -0,0 +1,111 @@
+package freeze
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubmitAppliesWhileNotFrozen(t *testing.T) {
+	c := NewController(time.Hour, false, false)
+	applied := false
+	if !c.Submit(KindBackends, "k", "change", func() { applied = true }) || !applied {
+		t.Fatal("change not applied while not frozen")
+	}
+}
+
+func TestQueueThenApply(t *testing.T) {
+	c := NewController(time.Hour, false, false)
+	c.Freeze("test", "incident", time.Minute)
+
+	var applied []string
+	if c.Submit(KindBackends, "a", "first", func() { applied = append(applied, "a1") }) {
+		t.Fatal("change applied while frozen")
+	}
+	c.Submit(KindWeights, "b", "second", func() { applied = append(applied, "b") })
+	// The latest intent for a key replaces the earlier one, keeping its place
+	c.Submit(KindBackends, "a", "third", func() { applied = append(applied, "a2") })
+
+	if len(applied) != 0 {
+		t.Fatalf("applied %v while frozen", applied)
+	}
+	changes := c.Changes()
+	if len(changes) != 2 || changes[0].Description != "third" || changes[1].Kind != KindWeights {
+		t.Fatalf("queued %+v", changes)
+	}
+	if got := c.Status().QueuedChanges; got != 2 {
+		t.Fatalf("QueuedChanges = %d, want 2", got)
+	}
+
+	n, discarded := c.Unfreeze("test", true)
+	if n != 2 || discarded != 0 {
+		t.Fatalf("Unfreeze = %d applied, %d discarded; want 2, 0", n, discarded)
+	}
+	if len(applied) != 2 || applied[0] != "a2" || applied[1] != "b" {
+		t.Errorf("applied %v, want [a2 b]", applied)
+	}
+	if c.Frozen() || len(c.Changes()) != 0 {
+		t.Error("still frozen, or changes left queued")
+	}
+}
+
+func TestQueueThenDiscard(t *testing.T) {
+	c := NewController(time.Hour, false, false)
+	c.Freeze("test", "incident", 0)
+
+	applied := false
+	c.Submit(KindBackends, "a", "change", func() { applied = true })
+	n, discarded := c.Unfreeze("test", false)
+	if n != 0 || discarded != 1 || applied {
+		t.Fatalf("Unfreeze = %d applied, %d discarded, change run %v; want 0, 1, false", n, discarded, applied)
+	}
+	// A later change applies as usual
+	if !c.Submit(KindBackends, "a", "change", func() { applied = true }) || !applied {
+		t.Error("change not applied after unfreezing")
+	}
+}
+
+func TestHealthPassesUnlessLogOnly(t *testing.T) {
+	c := NewController(time.Hour, false, false)
+	c.Freeze("test", "", 0)
+	if !c.Submit(KindHealth, "h", "down", func() {}) {
+		t.Error("health transition held without log_only_health")
+	}
+
+	c = NewController(time.Hour, false, true)
+	c.Freeze("test", "", 0)
+	if c.Submit(KindHealth, "h", "down", func() {}) {
+		t.Error("health transition applied with log_only_health")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	for _, apply := range []bool{true, false} {
+		c := NewController(20*time.Millisecond, apply, false)
+		done := make(chan string, 1)
+		c.SetNotify(func(actor, summary string) {
+			if actor == "expiry" {
+				done <- summary
+			}
+		})
+		applied := false
+		// Longer than the maximum, so capped at it
+		c.Freeze("test", "", time.Hour)
+		c.Submit(KindBackends, "a", "change", func() { applied = true })
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("freeze did not expire")
+		}
+		if c.Frozen() || applied != apply {
+			t.Errorf("on_expire apply=%v: frozen %v, applied %v", apply, c.Frozen(), applied)
+		}
+	}
+}
+
+func TestUnfreezeWhileNotFrozen(t *testing.T) {
+	c := NewController(time.Hour, false, false)
+	if n, discarded := c.Unfreeze("test", true); n != 0 || discarded != 0 {
+		t.Errorf("Unfreeze = %d, %d; want 0, 0", n, discarded)
+	}
+}
@@ -0,0 +1,263 @@
+// Package handoff passes the listening sockets of a running Nexus to a new
+// process started from the same path, so that a binary upgrade neither
+// refuses nor drops connections. Sockets are known by their configured
+// network and address: the new process picks up those it listens on again
+// and opens the others afresh, which lets an upgrade change the
+// configuration as well.
+package handoff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The environment tells an upgraded process its inherited sockets, as
+// "fd network address" entries separated by commas, and the pipe it
+// reports readiness on
+const (
+	envListeners = "NEXUS_LISTENERS"
+	envReady     = "NEXUS_READY_FD"
+)
+
+// filer is a socket that can be passed on, as *net.TCPListener and
+// *net.UDPConn are
+type filer interface {
+	File() (*os.File, error)
+}
+
+// Sockets are the listening sockets of this process: those inherited from
+// the process it replaces and those opened through it since
+type Sockets struct {
+	// path is the executable started on upgrade, resolved at startup so
+	// that a binary replaced on disk is the one started
+	path string
+
+	mu        sync.Mutex
+	inherited map[string]*os.File
+	open      map[string]filer
+	adopted   int
+	ready     *os.File
+	parent    int
+}
+
+// Inherit returns the sockets passed on by the process this one replaces,
+// if any, and starts tracking those opened from now on
+func Inherit() (*Sockets, error) {
+	s := &Sockets{inherited: make(map[string]*os.File), open: make(map[string]filer)}
+	path, err := exec.LookPath(os.Args[0])
+	if err == nil {
+		path, err = filepath.Abs(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("locating the executable: %w", err)
+	}
+	s.path = path
+
+	defer os.Unsetenv(envListeners)
+	defer os.Unsetenv(envReady)
+	if spec := os.Getenv(envListeners); spec != "" {
+		for entry := range strings.SplitSeq(spec, ",") {
+			fields := strings.Fields(entry)
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("%s: malformed entry %q", envListeners, entry)
+			}
+			fd, err := strconv.Atoi(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("%s: malformed entry %q", envListeners, entry)
+			}
+			s.inherited[key(fields[1], fields[2])] = os.NewFile(uintptr(fd), fields[1]+" "+fields[2])
+		}
+	}
+	if v := os.Getenv(envReady); v != "" {
+		fd, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", envReady, err)
+		}
+		s.ready = os.NewFile(uintptr(fd), "ready")
+		s.parent = os.Getppid()
+	}
+	return s, nil
+}
+
+func key(network, addr string) string {
+	return network + " " + addr
+}
+
+// Upgraded reports whether this process was started by an upgrade, and
+// the PID of the process it replaces
+func (s *Sockets) Upgraded() (int, bool) {
+	return s.parent, s.parent != 0
+}
+
+// Adopted returns the number of inherited sockets listened on again
+func (s *Sockets) Adopted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.adopted
+}
+
+// Listen returns a TCP listener on addr, the inherited one if there is one
+func (s *Sockets) Listen(addr string) (net.Listener, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := key("tcp", addr)
+	var ln net.Listener
+	var err error
+	if f := s.inherited[k]; f != nil {
+		delete(s.inherited, k)
+		ln, err = net.FileListener(f)
+		f.Close()
+		s.adopted++
+	} else {
+		ln, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fl, ok := ln.(filer)
+	if !ok {
+		ln.Close()
+		return nil, fmt.Errorf("inherited socket for %s is not a TCP listener", addr)
+	}
+	s.open[k] = fl
+	return ln, nil
+}
+
+// ListenUDP returns a UDP socket bound to addr, the inherited one if there
+// is one
+func (s *Sockets) ListenUDP(addr string) (*net.UDPConn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := key("udp", addr)
+	var conn *net.UDPConn
+	if f := s.inherited[k]; f != nil {
+		delete(s.inherited, k)
+		pc, err := net.FilePacketConn(f)
+		f.Close()
+		s.adopted++
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if conn, ok = pc.(*net.UDPConn); !ok {
+			pc.Close()
+			return nil, fmt.Errorf("inherited socket for %s is not a UDP socket", addr)
+		}
+	} else {
+		a, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		if conn, err = net.ListenUDP("udp", a); err != nil {
+			return nil, err
+		}
+	}
+	s.open[k] = conn
+	return conn, nil
+}
+
+// Ready closes the inherited sockets the configuration no longer listens
+// on and, in an upgraded process, tells the previous one to drain and exit.
+// Call it once every listener is open.
+func (s *Sockets) Ready() error {
+	s.mu.Lock()
+	for k, f := range s.inherited {
+		f.Close()
+		delete(s.inherited, k)
+	}
+	ready := s.ready
+	s.ready = nil
+	s.mu.Unlock()
+	if ready == nil {
+		return nil
+	}
+	defer ready.Close()
+	_, err := ready.Write([]byte{1})
+	return err
+}
+
+// Upgrade starts the executable this process was started from with the
+// same arguments, passing it every open socket, and waits until it reports
+// ready or ctx is done. On failure the new process is killed and this one
+// keeps serving; on success it should drain and exit.
+func (s *Sockets) Upgrade(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	keys := slices.Sorted(maps.Keys(s.open))
+	files := make([]*os.File, 0, len(keys)+1)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	entries := make([]string, 0, len(keys))
+	for i, k := range keys {
+		f, err := s.open[k].File()
+		if err != nil {
+			s.mu.Unlock()
+			return 0, fmt.Errorf("passing on %s: %w", k, err)
+		}
+		files = append(files, f)
+		// ExtraFiles start at descriptor 3, after stdin, stdout and stderr
+		entries = append(entries, strconv.Itoa(3+i)+" "+k)
+	}
+	s.mu.Unlock()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	files = append(files, w)
+
+	cmd := exec.Command(s.path, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envListeners+"=") && !strings.HasPrefix(kv, envReady+"=") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(cmd.Env,
+		envListeners+"="+strings.Join(entries, ","),
+		envReady+"="+strconv.Itoa(3+len(keys)))
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	// Only the new process holds the write end now, so the read ends once it
+	// reports ready or exits
+	w.Close()
+	files = files[:len(files)-1]
+
+	ready := make(chan error, 1)
+	go func() {
+		var b [1]byte
+		_, err := r.Read(b[:])
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+		if err != nil {
+			err = errors.New("new process exited before it was ready")
+		}
+	case <-ctx.Done():
+		err = fmt.Errorf("new process not ready: %w", ctx.Err())
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, err
+	}
+	// Reap the new process should it exit while this one drains
+	go cmd.Wait()
+	return cmd.Process.Pid, nil
+}
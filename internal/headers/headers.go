@@ -0,0 +1,162 @@
+package headers
+
+import (
+	"context"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strings"
+)
+
+// Ops are the header changes of one direction of a rule. Remove runs first,
+// then Set and then Add. Names in Remove may end in "*" to remove every
+// header with that prefix; values in Set and Add may refer to the request
+// variables ${client_ip}, ${host} and ${request_id}.
+type Ops struct {
+	Set    map[string]string
+	Add    map[string]string
+	Remove []string
+}
+
+// Rule changes the headers of requests, toward backends, and of responses,
+// toward clients, whose path starts with PathPrefix
+type Rule struct {
+	PathPrefix string
+	Request    *Ops
+	Response   *Ops
+}
+
+// Vars are the per-request values header values are expanded with. Path is
+// the request path as the client sent it, which rules match on.
+type Vars struct {
+	ClientIP  string
+	Host      string
+	RequestID string
+	Path      string
+}
+
+// Rules is an ordered set of header rules. A nil *Rules changes nothing.
+type Rules struct {
+	rules    []Rule
+	response bool
+}
+
+// New creates a rule set; the rules are applied in order
+func New(rules []Rule) *Rules {
+	if len(rules) == 0 {
+		return nil
+	}
+	rs := &Rules{rules: rules}
+	for _, r := range rules {
+		if r.Response != nil {
+			rs.response = true
+		}
+	}
+	return rs
+}
+
+// HasResponseRules reports whether any rule changes response headers
+func (rs *Rules) HasResponseRules() bool {
+	return rs != nil && rs.response
+}
+
+// ApplyRequest changes the headers of a request on its way to a backend
+func (rs *Rules) ApplyRequest(h http.Header, v Vars) {
+	if rs == nil {
+		return
+	}
+	for _, r := range rs.rules {
+		if r.Request != nil && strings.HasPrefix(v.Path, r.PathPrefix) {
+			r.Request.apply(h, v)
+		}
+	}
+}
+
+// ApplyResponse changes the headers of a response on its way to the client
+func (rs *Rules) ApplyResponse(h http.Header, v Vars) {
+	if rs == nil {
+		return
+	}
+	for _, r := range rs.rules {
+		if r.Response != nil && strings.HasPrefix(v.Path, r.PathPrefix) {
+			r.Response.apply(h, v)
+		}
+	}
+}
+
+func (o *Ops) apply(h http.Header, v Vars) {
+	for _, name := range o.Remove {
+		if prefix, ok := strings.CutSuffix(name, "*"); ok {
+			prefix = textproto.CanonicalMIMEHeaderKey(prefix)
+			for key := range h {
+				if strings.HasPrefix(key, prefix) {
+					delete(h, key)
+				}
+			}
+			continue
+		}
+		h.Del(name)
+	}
+	for name, value := range o.Set {
+		h.Set(name, v.expand(value))
+	}
+	for name, value := range o.Add {
+		h.Add(name, v.expand(value))
+	}
+}
+
+// expand substitutes the request variables in a header value
+func (v Vars) expand(value string) string {
+	if !strings.Contains(value, "${") {
+		return value
+	}
+	return strings.NewReplacer(
+		"${client_ip}", v.ClientIP,
+		"${host}", v.Host,
+		"${request_id}", v.RequestID,
+	).Replace(value)
+}
+
+type varsKey struct{}
+
+// WithVars returns a context carrying the request's variables, so response
+// rules can use them once the request itself has been rewritten
+func WithVars(ctx context.Context, v Vars) context.Context {
+	return context.WithValue(ctx, varsKey{}, v)
+}
+
+// VarsFrom returns the variables stored by WithVars
+func VarsFrom(ctx context.Context) (Vars, bool) {
+	v, ok := ctx.Value(varsKey{}).(Vars)
+	return v, ok
+}
+
+type rulesKey struct{}
+
+// Middleware applies rs to the requests it wraps, after the rules of the
+// backend serving them, by handing them down in the request context
+func Middleware(rs *Rules) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(WithRules(r.Context(), rs)))
+		})
+	}
+}
+
+// WithRules returns a context carrying rs after any rules ctx carries
+// already
+func WithRules(ctx context.Context, rs *Rules) context.Context {
+	if rs == nil {
+		return ctx
+	}
+	if prev := RulesFrom(ctx); prev != nil {
+		rs = &Rules{rules: append(slices.Clip(prev.rules), rs.rules...), response: prev.response || rs.response}
+	}
+	return context.WithValue(ctx, rulesKey{}, rs)
+}
+
+// RulesFrom returns the rules stored by WithRules, or nil
+func RulesFrom(ctx context.Context) *Rules {
+	rs, _ := ctx.Value(rulesKey{}).(*Rules)
+	return rs
+}
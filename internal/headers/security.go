@@ -0,0 +1,133 @@
+package headers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SecurityOptions are the security headers every response is given
+type SecurityOptions struct {
+	// HSTSMaxAge, if positive, sends Strict-Transport-Security with that
+	// max-age, optionally for subdomains too and asking to be preloaded
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	// NoSniff sends X-Content-Type-Options: nosniff
+	NoSniff bool
+	// FrameOptions, if set, is sent as X-Frame-Options
+	FrameOptions string
+	// ContentSecurityPolicy, if set, is sent as Content-Security-Policy
+	ContentSecurityPolicy string
+	// Always replaces the headers a backend set; otherwise they are only
+	// added to responses without them
+	Always bool
+}
+
+// Security adds a baseline of security headers to responses as they are
+// written, so those of backends, redirects and error pages alike get them.
+// The Security of a route replaces the one wrapping the whole handler for
+// the route's requests.
+type Security struct {
+	headers [][2]string
+	always  bool
+}
+
+// NewSecurity creates the security headers of o
+func NewSecurity(o SecurityOptions) *Security {
+	s := &Security{always: o.Always}
+	if o.HSTSMaxAge > 0 {
+		hsts := "max-age=" + strconv.FormatInt(int64(o.HSTSMaxAge/time.Second), 10)
+		if o.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if o.HSTSPreload {
+			hsts += "; preload"
+		}
+		s.headers = append(s.headers, [2]string{"Strict-Transport-Security", hsts})
+	}
+	if o.NoSniff {
+		s.headers = append(s.headers, [2]string{"X-Content-Type-Options", "nosniff"})
+	}
+	if o.FrameOptions != "" {
+		s.headers = append(s.headers, [2]string{"X-Frame-Options", o.FrameOptions})
+	}
+	if o.ContentSecurityPolicy != "" {
+		s.headers = append(s.headers, [2]string{"Content-Security-Policy", o.ContentSecurityPolicy})
+	}
+	return s
+}
+
+type securityKey struct{}
+
+// securitySlot holds the Security a response is given; route middleware
+// replaces it before the response is written
+type securitySlot struct {
+	s *Security
+}
+
+// Wrap adds the headers to the responses of next. Inside a handler already
+// wrapped, it only replaces the headers that one adds.
+func (s *Security) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slot, ok := r.Context().Value(securityKey{}).(*securitySlot); ok {
+			slot.s = s
+			next.ServeHTTP(w, r)
+			return
+		}
+		slot := &securitySlot{s: s}
+		next.ServeHTTP(&securityWriter{ResponseWriter: w, slot: slot}, r.WithContext(context.WithValue(r.Context(), securityKey{}, slot)))
+	})
+}
+
+// apply adds the headers to h
+func (s *Security) apply(h http.Header) {
+	for _, kv := range s.headers {
+		if s.always || h.Get(kv[0]) == "" {
+			h.Set(kv[0], kv[1])
+		}
+	}
+}
+
+// securityWriter adds the headers of its slot to the response as its
+// header is written. Flushing and hijacking reach the underlying writer
+// through Unwrap.
+type securityWriter struct {
+	http.ResponseWriter
+	slot    *securitySlot
+	applied bool
+}
+
+func (w *securityWriter) apply() {
+	if !w.applied {
+		w.applied = true
+		w.slot.s.apply(w.Header())
+	}
+}
+
+// WriteHeader adds the headers to the final response; informational
+// responses other than 101 Switching Protocols go out as they are
+func (w *securityWriter) WriteHeader(code int) {
+	if code >= 200 || code == http.StatusSwitchingProtocols {
+		w.apply()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *securityWriter) Write(p []byte) (int, error) {
+	w.apply()
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush sends the header, with the security headers, if it has not gone
+// out yet
+func (w *securityWriter) Flush() {
+	w.apply()
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *securityWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -1,22 +1,49 @@
 package health
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"net"
 	"net/url"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/metrics"
 	"github.com/nexus-lb/nexus/internal/pool"
 )
 
-// HealthChecker performs periodic health checks on backend servers
+// HealthChecker performs periodic health checks on backend servers. It may
+// be stopped and started again, and its interval and timeout changed while
+// it runs.
 type HealthChecker struct {
-	pool     *pool.ServerPool
-	interval time.Duration
-	timeout  time.Duration
+	pool *pool.ServerPool
+
+	// runMu serializes Start and Stop; stopChan ends the current run, and
+	// wg waits for its loop and warm-ups
+	runMu    sync.Mutex
+	running  atomic.Bool
 	stopChan chan struct{}
 	wg       sync.WaitGroup
+
+	// mu guards the settings; changed wakes the check loop to reschedule
+	// after SetInterval
+	mu       sync.Mutex
+	interval time.Duration
+	timeout  time.Duration
+	changed  chan struct{}
+
+	cadence  *Cadence
+	schedule *schedule
+	now      func() time.Time
+	metrics  *metrics.Metrics
+	logger   *slog.Logger
+
+	// warming tracks backends with a warm-up in progress
+	warming    map[string]bool
+	warmingMux sync.Mutex
 }
 
 // NewHealthChecker creates a new health checker instance
@@ -25,64 +52,215 @@ func NewHealthChecker(pool *pool.ServerPool, interval, timeout time.Duration) *H
 		pool:     pool,
 		interval: interval,
 		timeout:  timeout,
-		stopChan: make(chan struct{}),
+		changed:  make(chan struct{}, 1),
+		warming:  make(map[string]bool),
+		now:      time.Now,
+		logger:   slog.Default().With("component", "health"),
 	}
 }
 
-// Start launches the health checker in a separate goroutine
+// SetLogger sets the logger the checker reports through. It must be called
+// before Start.
+func (h *HealthChecker) SetLogger(l *slog.Logger) {
+	h.logger = l.With("component", "health")
+}
+
+// SetAdaptive switches the checker from a fixed interval to an adaptive
+// schedule within the given cadence. It must be called before Start.
+func (h *HealthChecker) SetAdaptive(c Cadence) {
+	h.cadence = &c
+}
+
+// SetMetrics makes the checker record health check metrics
+func (h *HealthChecker) SetMetrics(m *metrics.Metrics) {
+	h.metrics = m
+}
+
+// Start launches the health checker in a separate goroutine, unless it is
+// running already. Every backend is checked at once.
 func (h *HealthChecker) Start() {
-	log.Printf("Health checker starting (interval: %v, timeout: %v)", h.interval, h.timeout)
+	h.runMu.Lock()
+	defer h.runMu.Unlock()
+	if h.running.Load() {
+		return
+	}
+	interval, timeout := h.settings()
+	if h.cadence != nil {
+		h.logger.Info("health checker starting",
+			"min_interval", h.cadence.Min, "max_interval", h.cadence.Max, "timeout", timeout)
+	} else {
+		h.logger.Info("health checker starting", "interval", interval, "timeout", timeout)
+	}
+	h.schedule = newSchedule(interval, h.cadence)
+	h.stopChan = make(chan struct{})
+	h.running.Store(true)
 
 	h.wg.Add(1)
 	go func() {
 		defer h.wg.Done()
 
 		// Run initial health check immediately
-		h.checkHealth()
-
-		ticker := time.NewTicker(h.interval)
-		defer ticker.Stop()
+		timer := time.NewTimer(0)
+		defer timer.Stop()
 
 		for {
 			select {
-			case <-ticker.C:
+			case <-timer.C:
 				h.checkHealth()
+				timer.Reset(h.schedule.wait(h.now(), h.poll()))
+			case <-h.changed:
+				interval, _ := h.settings()
+				h.schedule.setBase(interval)
+				timer.Reset(h.schedule.wait(h.now(), h.poll()))
 			case <-h.stopChan:
-				log.Println("Health checker stopped")
+				h.logger.Info("health checker stopped")
 				return
 			}
 		}
 	}()
 }
 
-// Stop gracefully stops the health checker
+// Stop gracefully stops the health checker, if it is running
 func (h *HealthChecker) Stop() {
-	log.Println("Stopping health checker...")
+	h.runMu.Lock()
+	defer h.runMu.Unlock()
+	if !h.running.Load() {
+		return
+	}
+	h.logger.Info("stopping health checker")
 	close(h.stopChan)
 	h.wg.Wait()
+	h.running.Store(false)
+}
+
+// Settings are the current health check settings
+type Settings struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	// Cadence bounds the adaptive schedule, nil for a fixed interval
+	Cadence *Cadence
+	Running bool
 }
 
-// checkHealth iterates through all backends and tests their health
+// Settings returns the current settings
+func (h *HealthChecker) Settings() Settings {
+	interval, timeout := h.settings()
+	return Settings{Interval: interval, Timeout: timeout, Cadence: h.cadence, Running: h.running.Load()}
+}
+
+func (h *HealthChecker) settings() (interval, timeout time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.interval, h.timeout
+}
+
+// SetInterval changes the interval between checks of the backends without
+// one of their own: each is next checked d after its last check. With an
+// adaptive schedule, d is where newly seen backends start.
+func (h *HealthChecker) SetInterval(d time.Duration) {
+	h.mu.Lock()
+	h.interval = d
+	h.mu.Unlock()
+	select {
+	case h.changed <- struct{}{}:
+	default:
+	}
+}
+
+// SetTimeout changes the timeout of the checks from the next one on
+func (h *HealthChecker) SetTimeout(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.timeout = d
+}
+
+// poll is the longest the check loop sleeps, so that new backends and
+// state changes are noticed
+func (h *HealthChecker) poll() time.Duration {
+	if h.cadence != nil {
+		return h.cadence.Min
+	}
+	interval, _ := h.settings()
+	return interval
+}
+
+// WaitHealthy blocks until at least n backends are healthy or ctx is done,
+// and returns how many were. A backend counts once it is alive after an
+// active check, or alive if its health is decided elsewhere.
+func (h *HealthChecker) WaitHealthy(ctx context.Context, n int) (int, error) {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		healthy := 0
+		for _, b := range h.pool.GetBackends() {
+			if b.IsAlive() && (b.ExternalHealth || !b.LastCheck().IsZero()) {
+				healthy++
+			}
+		}
+		if healthy >= n {
+			return healthy, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return healthy, ctx.Err()
+		}
+	}
+}
+
+// checkHealth tests the health of every backend that is due for a check,
+// leaving out those whose health is decided elsewhere
 func (h *HealthChecker) checkHealth() {
-	backends := h.pool.GetBackends()
+	checked := slices.DeleteFunc(h.pool.GetBackends(), func(b *backend.Backend) bool { return b.ExternalHealth })
+	backends := h.schedule.due(checked, h.now())
 
 	for _, backend := range backends {
-		alive := h.isBackendAlive(backend.URL)
-		wasAlive := backend.IsAlive()
-
-		if alive != wasAlive {
-			if alive {
-				log.Printf("Backend %s recovered (DOWN -> UP)", backend.URL.String())
-			} else {
-				log.Printf("Backend %s failed health check (UP -> DOWN)", backend.URL.String())
-			}
-			backend.SetAlive(alive)
+		h.checkBackend(backend)
+		h.schedule.checked(backend, h.now())
+	}
+}
+
+// checkBackend probes a single backend and applies the resulting transition
+func (h *HealthChecker) checkBackend(b *backend.Backend) {
+	started := time.Now()
+	err := h.probe(b.URL)
+	alive := err == nil
+	// Recorded once the state is updated, so a checked backend never looks
+	// alive through a check it failed
+	defer b.RecordCheck(time.Now())
+	h.metrics.HealthChecked(b.URL.String(), alive, time.Since(started))
+
+	// Operator overrides pin the state until returned to auto
+	if b.Pinned() {
+		return
+	}
+
+	wasAlive := b.IsAlive()
+
+	if alive != wasAlive {
+		// Recovered backends with a warm-up configured are admitted
+		// only once the warm-up succeeds
+		if alive && b.Warmup != nil && b.Warmup.Requests > 0 {
+			h.startWarmup(b)
+			return
+		}
+		cause := backend.Cause{Kind: backend.CauseActive}
+		if err != nil {
+			cause.Error = err.Error()
+		}
+		if !b.UpdateHealth(alive, cause) {
+			return
+		}
+		if alive {
+			h.logger.Info("backend recovered", "backend", b.URL.String(), "transition", "DOWN -> UP")
+		} else {
+			h.logger.Warn("backend failed health check", "backend", b.URL.String(), "transition", "UP -> DOWN", "error", err)
 		}
 	}
 }
 
-// isBackendAlive checks if a backend is reachable by attempting a TCP connection
-func (h *HealthChecker) isBackendAlive(u *url.URL) bool {
+// probe checks if a backend is reachable by attempting a TCP connection
+func (h *HealthChecker) probe(u *url.URL) error {
 	// Extract host and port from URL
 	host := u.Host
 
@@ -96,12 +274,13 @@ func (h *HealthChecker) isBackendAlive(u *url.URL) bool {
 	}
 
 	// Attempt TCP connection with timeout
-	conn, err := net.DialTimeout("tcp", host, h.timeout)
+	_, timeout := h.settings()
+	conn, err := net.DialTimeout("tcp", host, timeout)
 	if err != nil {
-		return false
+		return err
 	}
 
-	// Connection successful, close it and return true
+	// Connection successful, close it
 	conn.Close()
-	return true
+	return nil
 }
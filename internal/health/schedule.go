@@ -0,0 +1,147 @@
+package health
+
+import (
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+)
+
+// Cadence bounds the adaptive health check schedule. Backends that are down
+// or just changed state are probed every Min; healthy ones back off by
+// doubling their interval after each uneventful check, up to Max.
+type Cadence struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// scheduleEntry is the schedule state of one backend
+type scheduleEntry struct {
+	interval time.Duration
+	due      time.Time
+	alive    bool
+}
+
+// schedule decides when each backend is next due for an active check. It
+// never reads the clock itself so it can be driven by a fake one.
+type schedule struct {
+	base    time.Duration
+	cadence *Cadence // nil for a fixed interval
+	entries map[*backend.Backend]*scheduleEntry
+}
+
+// newSchedule creates a schedule probing every base, or adaptively within
+// cadence if one is given
+func newSchedule(base time.Duration, cadence *Cadence) *schedule {
+	return &schedule{
+		base:    base,
+		cadence: cadence,
+		entries: make(map[*backend.Backend]*scheduleEntry),
+	}
+}
+
+// due returns the backends that should be checked at now, in pool order.
+// Backends seen for the first time are due immediately, and a backend whose
+// state changed since its last check (through passive health checks or an
+// operator) is brought forward to the minimum interval.
+func (s *schedule) due(backends []*backend.Backend, now time.Time) []*backend.Backend {
+	present := make(map[*backend.Backend]bool, len(backends))
+	var due []*backend.Backend
+
+	for _, b := range backends {
+		present[b] = true
+		e, ok := s.entries[b]
+		if !ok {
+			e = &scheduleEntry{interval: s.initialInterval(b), due: now, alive: b.IsAlive()}
+			s.entries[b] = e
+			b.SetCheckInterval(e.interval)
+		}
+
+		if s.cadence != nil && b.HealthInterval == 0 && b.IsAlive() != e.alive {
+			if fast := now.Add(s.cadence.Min); fast.Before(e.due) {
+				e.due = fast
+			}
+		}
+		if !now.Before(e.due) {
+			due = append(due, b)
+		}
+	}
+
+	for b := range s.entries {
+		if !present[b] {
+			delete(s.entries, b)
+		}
+	}
+	return due
+}
+
+// setBase changes the fixed interval. Backends on it are next due the new
+// interval after their last check; those with an interval of their own or
+// on the adaptive schedule keep theirs.
+func (s *schedule) setBase(base time.Duration) {
+	if s.cadence == nil {
+		for b, e := range s.entries {
+			if b.HealthInterval == 0 {
+				e.due = e.due.Add(base - e.interval)
+				e.interval = base
+				b.SetCheckInterval(base)
+			}
+		}
+	}
+	s.base = base
+}
+
+// checked advances a backend's schedule after an active check at now
+func (s *schedule) checked(b *backend.Backend, now time.Time) {
+	e, ok := s.entries[b]
+	if !ok {
+		return
+	}
+
+	alive := b.IsAlive()
+	e.interval = s.nextInterval(b, e.interval, alive, alive != e.alive)
+	e.alive = alive
+	e.due = now.Add(e.interval)
+	b.SetCheckInterval(e.interval)
+}
+
+// nextInterval is the cadence state machine: pinned and fixed intervals
+// never move, unhealthy or changed backends drop to the minimum and stable
+// healthy ones back off toward the maximum
+func (s *schedule) nextInterval(b *backend.Backend, current time.Duration, healthy, changed bool) time.Duration {
+	if b.HealthInterval > 0 {
+		return b.HealthInterval
+	}
+	if s.cadence == nil {
+		return s.base
+	}
+	if !healthy || changed {
+		return s.cadence.Min
+	}
+	return min(max(current*2, s.cadence.Min), s.cadence.Max)
+}
+
+// initialInterval is the interval a newly seen backend starts at
+func (s *schedule) initialInterval(b *backend.Backend) time.Duration {
+	if b.HealthInterval > 0 {
+		return b.HealthInterval
+	}
+	if s.cadence == nil {
+		return s.base
+	}
+	if !b.IsAlive() {
+		return s.cadence.Min
+	}
+	return min(max(s.base, s.cadence.Min), s.cadence.Max)
+}
+
+// wait returns how long until the next backend is due, measured from now.
+// It never exceeds poll so new backends and state changes are noticed.
+func (s *schedule) wait(now time.Time, poll time.Duration) time.Duration {
+	wait := poll
+	for _, e := range s.entries {
+		if d := e.due.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	return max(wait, 0)
+}
@@ -0,0 +1,156 @@
+package health
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/pool"
+)
+
+// clock is a fake clock advanced by hand
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+var testCadence = Cadence{Min: 2 * time.Second, Max: 30 * time.Second}
+
+func newScheduleBackend(t *testing.T, opts ...backend.Option) *backend.Backend {
+	t.Helper()
+	b, err := backend.NewBackend("http://10.0.0.1:80", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestCadenceFollowsState(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	p := &pool.ServerPool{}
+	b, _ := backend.NewBackend("http://" + addr)
+	p.AddBackend(b)
+
+	c := &clock{t: time.Unix(1_000_000, 0)}
+	h := NewHealthChecker(p, 10*time.Second, time.Second)
+	h.SetAdaptive(testCadence)
+	h.now = c.now
+	h.schedule = newSchedule(10*time.Second, h.cadence)
+
+	// step advances the clock by d, runs a round of checks and expects the
+	// backend's interval to be want
+	step := func(d, want time.Duration) {
+		t.Helper()
+		c.advance(d)
+		h.checkHealth()
+		if got := b.CheckInterval(); got != want {
+			t.Fatalf("interval %s, want %s", got, want)
+		}
+	}
+
+	// A stable healthy backend backs off to the maximum
+	step(0, 20*time.Second)
+	step(20*time.Second, 30*time.Second)
+	step(30*time.Second, 30*time.Second)
+
+	// Going down drops it to the minimum, where it stays while down
+	ln.Close()
+	step(30*time.Second, 2*time.Second)
+	if b.IsAlive() {
+		t.Fatal("backend still alive after failing its check")
+	}
+	step(2*time.Second, 2*time.Second)
+
+	// Recovering keeps the minimum for one more check, then backs off again
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("cannot listen on %s again: %v", addr, err)
+	}
+	defer ln.Close()
+	step(2*time.Second, 2*time.Second)
+	if !b.IsAlive() {
+		t.Fatal("backend not recovered")
+	}
+	step(2*time.Second, 4*time.Second)
+	step(4*time.Second, 8*time.Second)
+}
+
+func TestNotDueBeforeInterval(t *testing.T) {
+	b := newScheduleBackend(t)
+	s := newSchedule(10*time.Second, &testCadence)
+	c := &clock{t: time.Unix(1_000_000, 0)}
+
+	if due := s.due([]*backend.Backend{b}, c.now()); len(due) != 1 {
+		t.Fatal("new backend not due at once")
+	}
+	s.checked(b, c.now())
+	c.advance(19 * time.Second)
+	if due := s.due([]*backend.Backend{b}, c.now()); len(due) != 0 {
+		t.Fatal("backend due before its interval")
+	}
+	if w := s.wait(c.now(), time.Minute); w != time.Second {
+		t.Errorf("wait %s, want 1s", w)
+	}
+	c.advance(time.Second)
+	if due := s.due([]*backend.Backend{b}, c.now()); len(due) != 1 {
+		t.Fatal("backend not due after its interval")
+	}
+}
+
+func TestPassiveChangeBringsCheckForward(t *testing.T) {
+	b := newScheduleBackend(t)
+	s := newSchedule(30*time.Second, &testCadence)
+	c := &clock{t: time.Unix(1_000_000, 0)}
+	s.due([]*backend.Backend{b}, c.now())
+	s.checked(b, c.now())
+
+	// A passive failure between active checks
+	b.SetAlive(false)
+	c.advance(time.Second)
+	s.due([]*backend.Backend{b}, c.now())
+	c.advance(2 * time.Second)
+	if due := s.due([]*backend.Backend{b}, c.now()); len(due) != 1 {
+		t.Fatal("backend that went down not checked at the minimum interval")
+	}
+}
+
+func TestPinnedInterval(t *testing.T) {
+	pinned := newScheduleBackend(t, backend.WithHealthInterval(5*time.Second))
+	s := newSchedule(10*time.Second, &testCadence)
+	c := &clock{t: time.Unix(1_000_000, 0)}
+
+	for _, alive := range []bool{true, true, false, false, true} {
+		pinned.SetAlive(alive)
+		s.due([]*backend.Backend{pinned}, c.now())
+		s.checked(pinned, c.now())
+		if got := pinned.CheckInterval(); got != 5*time.Second {
+			t.Fatalf("pinned interval moved to %s", got)
+		}
+		c.advance(5 * time.Second)
+	}
+}
+
+func TestFixedInterval(t *testing.T) {
+	b := newScheduleBackend(t)
+	s := newSchedule(10*time.Second, nil)
+	c := &clock{t: time.Unix(1_000_000, 0)}
+	for _, alive := range []bool{true, false, true} {
+		b.SetAlive(alive)
+		s.due([]*backend.Backend{b}, c.now())
+		s.checked(b, c.now())
+		if got := b.CheckInterval(); got != 10*time.Second {
+			t.Fatalf("fixed interval moved to %s", got)
+		}
+		c.advance(10 * time.Second)
+	}
+
+	s.setBase(20 * time.Second)
+	if got := b.CheckInterval(); got != 20*time.Second {
+		t.Errorf("interval %s after setBase, want 20s", got)
+	}
+}
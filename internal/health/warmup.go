@@ -0,0 +1,84 @@
+package health
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+)
+
+// startWarmup warms up a recovered backend in the background and only admits
+// it once the warm-up succeeds. Nothing happens if a warm-up for the backend
+// is already running.
+func (h *HealthChecker) startWarmup(b *backend.Backend) {
+	key := b.URL.String()
+
+	h.warmingMux.Lock()
+	if h.warming[key] {
+		h.warmingMux.Unlock()
+		return
+	}
+	h.warming[key] = true
+	h.warmingMux.Unlock()
+
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		defer func() {
+			h.warmingMux.Lock()
+			delete(h.warming, key)
+			h.warmingMux.Unlock()
+		}()
+
+		h.logger.Info("backend passed health check, warming up",
+			"backend", key, "requests", b.Warmup.Requests, "path", b.Warmup.Path)
+
+		if err := h.warmup(b); err != nil {
+			h.logger.Warn("backend failed warm-up, keeping DOWN", "backend", key, "error", err)
+			return
+		}
+
+		if b.UpdateHealth(true, backend.Cause{Kind: backend.CauseWarmup}) {
+			h.logger.Info("backend recovered after warm-up", "backend", key, "transition", "DOWN -> UP")
+		}
+	}()
+}
+
+// warmup sends the configured synthetic requests to a backend and checks
+// that the final one completes within the latency budget. They go over the
+// backend's own transport, so they warm the connections proxied requests
+// will use.
+func (h *HealthChecker) warmup(b *backend.Backend) error {
+	w := b.Warmup
+	client := &http.Client{Transport: b.ProbeTransport(), Timeout: w.Timeout}
+	target := b.URL.JoinPath(w.Path).String()
+
+	var latency time.Duration
+	for i := 1; i <= w.Requests; i++ {
+		select {
+		case <-h.stopChan:
+			return fmt.Errorf("health checker stopped")
+		default:
+		}
+
+		start := time.Now()
+		resp, err := client.Get(target)
+		if err != nil {
+			return fmt.Errorf("request %d: %w", i, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		latency = time.Since(start)
+
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("request %d: status %d", i, resp.StatusCode)
+		}
+	}
+
+	if w.LatencyBudget > 0 && latency > w.LatencyBudget {
+		return fmt.Errorf("final request took %v, budget is %v", latency.Round(time.Microsecond), w.LatencyBudget)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/pool"
+)
+
+func TestWarmupUsesBackendTransport(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	up := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.URL.Path+" "+r.Proto+" "+r.RemoteAddr)
+		mu.Unlock()
+	}))
+	up.Config.Protocols = new(http.Protocols)
+	up.Config.Protocols.SetHTTP1(true)
+	up.Config.Protocols.SetUnencryptedHTTP2(true)
+	up.Start()
+	defer up.Close()
+
+	// The backend speaks h2c, which a plain client would not
+	settings := backend.DefaultTransportSettings()
+	settings.Protocol = backend.ProtocolH2C
+	b, err := backend.NewBackend(up.URL,
+		backend.WithTransport(settings),
+		backend.WithWarmup(backend.Warmup{Requests: 3, Path: "/warm", Timeout: time.Second}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &pool.ServerPool{}
+	p.AddBackend(b)
+	h := NewHealthChecker(p, time.Minute, time.Second)
+	if err := h.warmup(b); err != nil {
+		t.Fatal(err)
+	}
+	b.ReverseProxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/real", nil))
+
+	// Every request went over the one connection the warm-up opened
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) != 4 {
+		t.Fatalf("backend saw %q, want 3 warm-up requests and 1 proxied", seen)
+	}
+	remote := seen[0][len("/warm HTTP/2.0 "):]
+	for i, want := range []string{"/warm", "/warm", "/warm", "/real"} {
+		if seen[i] != want+" HTTP/2.0 "+remote {
+			t.Errorf("request %d: %q, want %s over HTTP/2.0 from %s", i, seen[i], want, remote)
+		}
+	}
+	if b.Failures() != 0 || b.Requests() != 1 {
+		t.Errorf("warm-up counted as traffic: %d requests, %d failures", b.Requests(), b.Failures())
+	}
+}
@@ -0,0 +1,134 @@
+package http3
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Frame types (RFC 9114, Section 7.2)
+const (
+	frameData     = 0x00
+	frameHeaders  = 0x01
+	frameSettings = 0x04
+	frameGoAway   = 0x07
+)
+
+// Unidirectional stream types (RFC 9114, Section 6.2, and RFC 9204,
+// Section 4.2)
+const (
+	streamControl      = 0x00
+	streamPush         = 0x01
+	streamQPACKEncoder = 0x02
+	streamQPACKDecoder = 0x03
+)
+
+// Error codes (RFC 9114, Section 8.1, and RFC 9204, Section 6)
+const (
+	errNoError              = 0x100
+	errGeneralProtocol      = 0x101
+	errInternal             = 0x102
+	errStreamCreation       = 0x103
+	errClosedCriticalStream = 0x104
+	errFrameUnexpected      = 0x105
+	errFrame                = 0x106
+	errExcessiveLoad        = 0x107
+	errMissingSettings      = 0x10a
+	errRequestRejected      = 0x10b
+	errRequestIncomplete    = 0x10d
+	errMessage              = 0x10e
+	errQPACKDecompression   = 0x200
+)
+
+// connError is an error that ends the whole connection with its code
+type connError struct {
+	code   uint64
+	reason string
+}
+
+func (e *connError) Error() string {
+	return fmt.Sprintf("HTTP/3 error %#x: %s", e.code, e.reason)
+}
+
+// streamError is an error that only resets the stream it occurred on
+type streamError struct {
+	code   uint64
+	reason string
+}
+
+func (e *streamError) Error() string {
+	return fmt.Sprintf("HTTP/3 stream error %#x: %s", e.code, e.reason)
+}
+
+// maxVarint is the largest value a QUIC variable-length integer holds
+const maxVarint = 1<<62 - 1
+
+// readVarint reads a QUIC variable-length integer (RFC 9000, Section 16)
+func readVarint(r io.ByteReader) (uint64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	n := 1 << (b >> 6)
+	v := uint64(b & 0x3f)
+	for i := 1; i < n; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		v = v<<8 | uint64(b)
+	}
+	return v, nil
+}
+
+// appendVarint appends v as a QUIC variable-length integer
+func appendVarint(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return append(b, byte(v>>8)|0x40, byte(v))
+	case v < 1<<30:
+		return append(b, byte(v>>24)|0x80, byte(v>>16), byte(v>>8), byte(v))
+	default:
+		return append(b, byte(v>>56)|0xc0, byte(v>>48), byte(v>>40), byte(v>>32),
+			byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+}
+
+// appendFrameHeader appends the type and length that open a frame
+func appendFrameHeader(b []byte, typ, length uint64) []byte {
+	return appendVarint(appendVarint(b, typ), length)
+}
+
+// reader is a stream frames are read off
+type reader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// readFrameHeader reads the type and length of the next frame on s. A
+// stream ending cleanly between frames returns io.EOF.
+func readFrameHeader(s reader) (typ, length uint64, err error) {
+	typ, err = readVarint(s)
+	if err != nil {
+		return 0, 0, err
+	}
+	length, err = readVarint(s)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, 0, err
+	}
+	return typ, length, nil
+}
+
+// discard skips n bytes of s
+func discard(s io.Reader, n uint64) error {
+	_, err := io.CopyN(io.Discard, s, int64(n))
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
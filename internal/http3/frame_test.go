@@ -0,0 +1,71 @@
+package http3
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestVarintRoundTrip(t *testing.T) {
+	tests := []struct {
+		v    uint64
+		size int
+	}{
+		{0, 1}, {63, 1}, {64, 2}, {16383, 2}, {16384, 4}, {1<<30 - 1, 4}, {1 << 30, 8}, {maxVarint, 8},
+	}
+	for _, tt := range tests {
+		b := appendVarint(nil, tt.v)
+		if len(b) != tt.size {
+			t.Errorf("%d encoded in %d bytes, want %d", tt.v, len(b), tt.size)
+		}
+		got, err := readVarint(bytes.NewReader(b))
+		if err != nil || got != tt.v {
+			t.Errorf("%d decoded as %d, %v", tt.v, got, err)
+		}
+	}
+}
+
+func TestTruncatedVarint(t *testing.T) {
+	for _, v := range []uint64{64, 16384, 1 << 30} {
+		b := appendVarint(nil, v)
+		for n := 1; n < len(b); n++ {
+			if _, err := readVarint(bytes.NewReader(b[:n])); !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("%d cut to %d bytes: %v, want io.ErrUnexpectedEOF", v, n, err)
+			}
+		}
+	}
+	if _, err := readVarint(bytes.NewReader(nil)); err != io.EOF {
+		t.Errorf("empty input: %v, want io.EOF", err)
+	}
+}
+
+func TestFrameHeader(t *testing.T) {
+	b := appendFrameHeader(nil, frameHeaders, 1234)
+	typ, length, err := readFrameHeader(bytes.NewReader(b))
+	if err != nil || typ != frameHeaders || length != 1234 {
+		t.Fatalf("got type %#x length %d, %v", typ, length, err)
+	}
+
+	// A stream ending between frames ends cleanly, one ending inside a
+	// frame header does not
+	if _, _, err := readFrameHeader(bytes.NewReader(nil)); err != io.EOF {
+		t.Errorf("empty stream: %v, want io.EOF", err)
+	}
+	for n := 1; n < len(b); n++ {
+		if _, _, err := readFrameHeader(bytes.NewReader(b[:n])); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("header cut to %d bytes: %v, want io.ErrUnexpectedEOF", n, err)
+		}
+	}
+}
+
+func TestDiscardShortStream(t *testing.T) {
+	// A frame claiming more than the stream holds is skipped without
+	// buffering it
+	if err := discard(bytes.NewReader(make([]byte, 10)), maxVarint); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got %v, want io.ErrUnexpectedEOF", err)
+	}
+	if err := discard(bytes.NewReader(make([]byte, 10)), 10); err != nil {
+		t.Errorf("exact length: %v", err)
+	}
+}
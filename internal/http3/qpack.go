@@ -0,0 +1,202 @@
+package http3
+
+import (
+	"errors"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// The server advertises no QPACK dynamic table, so field sections only
+// ever refer to the static table (RFC 9204) and no encoder or decoder
+// stream instructions need acting on.
+
+// errQPACK is a field section the server cannot decode
+var errQPACK = errors.New("malformed or dynamic QPACK field section")
+
+type field struct {
+	name, value string
+}
+
+// staticTable is the QPACK static table (RFC 9204, Appendix A)
+var staticTable = [...]field{
+	{":authority", ""}, {":path", "/"}, {"age", "0"}, {"content-disposition", ""},
+	{"content-length", "0"}, {"cookie", ""}, {"date", ""}, {"etag", ""},
+	{"if-modified-since", ""}, {"if-none-match", ""}, {"last-modified", ""}, {"link", ""},
+	{"location", ""}, {"referer", ""}, {"set-cookie", ""}, {":method", "CONNECT"},
+	{":method", "DELETE"}, {":method", "GET"}, {":method", "HEAD"}, {":method", "OPTIONS"},
+	{":method", "POST"}, {":method", "PUT"}, {":scheme", "http"}, {":scheme", "https"},
+	{":status", "103"}, {":status", "200"}, {":status", "304"}, {":status", "404"},
+	{":status", "503"}, {"accept", "*/*"}, {"accept", "application/dns-message"}, {"accept-encoding", "gzip, deflate, br"},
+	{"accept-ranges", "bytes"}, {"access-control-allow-headers", "cache-control"}, {"access-control-allow-headers", "content-type"}, {"access-control-allow-origin", "*"},
+	{"cache-control", "max-age=0"}, {"cache-control", "max-age=2592000"}, {"cache-control", "max-age=604800"}, {"cache-control", "no-cache"},
+	{"cache-control", "no-store"}, {"cache-control", "public, max-age=31536000"}, {"content-encoding", "br"}, {"content-encoding", "gzip"},
+	{"content-type", "application/dns-message"}, {"content-type", "application/javascript"}, {"content-type", "application/json"}, {"content-type", "application/x-www-form-urlencoded"},
+	{"content-type", "image/gif"}, {"content-type", "image/jpeg"}, {"content-type", "image/png"}, {"content-type", "text/css"},
+	{"content-type", "text/html; charset=utf-8"}, {"content-type", "text/plain"}, {"content-type", "text/plain;charset=utf-8"}, {"range", "bytes=0-"},
+	{"strict-transport-security", "max-age=31536000"}, {"strict-transport-security", "max-age=31536000; includesubdomains"}, {"strict-transport-security", "max-age=31536000; includesubdomains; preload"}, {"vary", "accept-encoding"},
+	{"vary", "origin"}, {"x-content-type-options", "nosniff"}, {"x-xss-protection", "1; mode=block"}, {":status", "100"},
+	{":status", "204"}, {":status", "206"}, {":status", "302"}, {":status", "400"},
+	{":status", "403"}, {":status", "421"}, {":status", "425"}, {":status", "500"},
+	{"accept-language", ""}, {"access-control-allow-credentials", "FALSE"}, {"access-control-allow-credentials", "TRUE"}, {"access-control-allow-headers", "*"},
+	{"access-control-allow-methods", "get"}, {"access-control-allow-methods", "get, post, options"}, {"access-control-allow-methods", "options"}, {"access-control-expose-headers", "content-length"},
+	{"access-control-request-headers", "content-type"}, {"access-control-request-method", "get"}, {"access-control-request-method", "post"}, {"alt-svc", "clear"},
+	{"authorization", ""}, {"content-security-policy", "script-src 'none'; object-src 'none'; base-uri 'none'"}, {"early-data", "1"}, {"expect-ct", ""},
+	{"forwarded", ""}, {"if-range", ""}, {"origin", ""}, {"purpose", "prefetch"},
+	{"server", ""}, {"timing-allow-origin", "*"}, {"upgrade-insecure-requests", "1"}, {"user-agent", ""},
+	{"x-forwarded-for", ""}, {"x-frame-options", "deny"}, {"x-frame-options", "sameorigin"},
+}
+
+// staticByName and staticByField index staticTable for encoding
+var staticByName, staticByField = func() (map[string]int, map[field]int) {
+	byName := make(map[string]int)
+	byField := make(map[field]int, len(staticTable))
+	for i, f := range staticTable {
+		if _, ok := byName[f.name]; !ok {
+			byName[f.name] = i
+		}
+		byField[f] = i
+	}
+	return byName, byField
+}()
+
+// decodeFields calls f with each field of the encoded field section b
+func decodeFields(b []byte, f func(name, value string) error) error {
+	insertCount, b, err := readPrefixInt(b, 8)
+	if err != nil || insertCount != 0 {
+		return errQPACK
+	}
+	if _, b, err = readPrefixInt(b, 7); err != nil {
+		return errQPACK
+	}
+	for len(b) > 0 {
+		var name, value string
+		switch c := b[0]; {
+		case c&0x80 != 0: // indexed field line
+			if c&0x40 == 0 {
+				return errQPACK
+			}
+			var i uint64
+			if i, b, err = readPrefixInt(b, 6); err != nil || i >= uint64(len(staticTable)) {
+				return errQPACK
+			}
+			name, value = staticTable[i].name, staticTable[i].value
+		case c&0xc0 == 0x40: // literal field line with name reference
+			if c&0x10 == 0 {
+				return errQPACK
+			}
+			var i uint64
+			if i, b, err = readPrefixInt(b, 4); err != nil || i >= uint64(len(staticTable)) {
+				return errQPACK
+			}
+			name = staticTable[i].name
+			if value, b, err = readString(b, 7); err != nil {
+				return errQPACK
+			}
+		case c&0xe0 == 0x20: // literal field line with literal name
+			if name, b, err = readString(b, 3); err != nil {
+				return errQPACK
+			}
+			if value, b, err = readString(b, 7); err != nil {
+				return errQPACK
+			}
+		default: // post-base references, only meaningful with a dynamic table
+			return errQPACK
+		}
+		if err := f(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendFields appends fields as a field section referring to the static
+// table where it can
+func appendFields(b []byte, fields []field) []byte {
+	b = append(b, 0, 0) // required insert count and base
+	for _, f := range fields {
+		if i, ok := staticByField[f]; ok {
+			b = appendPrefixInt(b, 0xc0, 6, uint64(i))
+			continue
+		}
+		if i, ok := staticByName[f.name]; ok {
+			b = appendPrefixInt(b, 0x50, 4, uint64(i))
+		} else {
+			b = appendString(b, 0x20, 3, f.name)
+		}
+		b = appendString(b, 0, 7, f.value)
+	}
+	return b
+}
+
+// readPrefixInt reads an integer with an n-bit prefix (RFC 7541, Section
+// 5.1) off b
+func readPrefixInt(b []byte, n uint) (uint64, []byte, error) {
+	if len(b) == 0 {
+		return 0, nil, errQPACK
+	}
+	limit := uint64(1)<<n - 1
+	v := uint64(b[0]) & limit
+	b = b[1:]
+	if v < limit {
+		return v, b, nil
+	}
+	for shift := uint(0); len(b) > 0; shift += 7 {
+		if shift > 56 {
+			return 0, nil, errQPACK
+		}
+		c := b[0]
+		b = b[1:]
+		v += uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return v, b, nil
+		}
+	}
+	return 0, nil, errQPACK
+}
+
+// appendPrefixInt appends v as an integer with an n-bit prefix, the bits
+// above which are those of first
+func appendPrefixInt(b []byte, first byte, n uint, v uint64) []byte {
+	limit := uint64(1)<<n - 1
+	if v < limit {
+		return append(b, first|byte(v))
+	}
+	b = append(b, first|byte(limit))
+	for v -= limit; v >= 0x80; v >>= 7 {
+		b = append(b, byte(v)|0x80)
+	}
+	return append(b, byte(v))
+}
+
+// readString reads a string literal whose length has an n-bit prefix, the
+// bit above it telling whether it is Huffman-coded
+func readString(b []byte, n uint) (string, []byte, error) {
+	if len(b) == 0 {
+		return "", nil, errQPACK
+	}
+	huffman := b[0]&(1<<n) != 0
+	length, b, err := readPrefixInt(b, n)
+	if err != nil || length > uint64(len(b)) {
+		return "", nil, errQPACK
+	}
+	s, b := b[:length], b[length:]
+	if !huffman {
+		return string(s), b, nil
+	}
+	decoded, err := hpack.HuffmanDecodeToString(s)
+	if err != nil {
+		return "", nil, errQPACK
+	}
+	return decoded, b, nil
+}
+
+// appendString appends s as a string literal whose length has an n-bit
+// prefix, Huffman-coding it when that is shorter
+func appendString(b []byte, first byte, n uint, s string) []byte {
+	if l := hpack.HuffmanEncodeLength(s); l < uint64(len(s)) {
+		b = appendPrefixInt(b, first|1<<n, n, l)
+		return hpack.AppendHuffmanString(b, s)
+	}
+	b = appendPrefixInt(b, first, n, uint64(len(s)))
+	return append(b, s...)
+}
@@ -0,0 +1,86 @@
+package http3
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// decodeAll decodes the field section b into a list of fields
+func decodeAll(b []byte) ([]field, error) {
+	var fields []field
+	err := decodeFields(b, func(name, value string) error {
+		fields = append(fields, field{name, value})
+		return nil
+	})
+	return fields, err
+}
+
+func TestFieldsRoundTrip(t *testing.T) {
+	fields := []field{
+		// Whole field in the static table
+		{":method", "GET"},
+		{":scheme", "https"},
+		// Name in the static table, with a value Huffman-coding shortens
+		{":path", "/api/v1/users?id=7"},
+		{":authority", "example.com"},
+		// Literal names, with a value Huffman-coding does not shorten
+		{"x-request-id", "\x01\x02\xff"},
+		{"x-empty", ""},
+		// Lengths past the prefixes
+		{"x-long", strings.Repeat("v", 300)},
+		{strings.Repeat("n", 40), "1"},
+	}
+	got, err := decodeAll(appendFields(nil, fields))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, fields) {
+		t.Errorf("decoded %q, want %q", got, fields)
+	}
+}
+
+func TestPrefixIntRoundTrip(t *testing.T) {
+	for _, n := range []uint{3, 4, 6, 7, 8} {
+		for _, v := range []uint64{0, 1<<n - 2, 1<<n - 1, 1 << n, 1000, 1 << 40} {
+			b := appendPrefixInt(nil, 0, n, v)
+			got, rest, err := readPrefixInt(b, n)
+			if err != nil || got != v || len(rest) != 0 {
+				t.Errorf("%d with a %d-bit prefix decoded as %d, %v", v, n, got, err)
+			}
+		}
+	}
+}
+
+func TestMalformedFields(t *testing.T) {
+	valid := appendFields(nil, []field{{":path", "/a"}})
+	overlong := append([]byte{0, 0, 0xff}, slices.Repeat([]byte{0xff}, 10)...)
+	tests := []struct {
+		name    string
+		section []byte
+	}{
+		{"empty", nil},
+		{"no base", []byte{0}},
+		{"dynamic table insert count", []byte{1, 0}},
+		{"static index past the table", appendPrefixInt([]byte{0, 0}, 0xc0, 6, uint64(len(staticTable)))},
+		{"huge static index", appendPrefixInt([]byte{0, 0}, 0xc0, 6, 1<<40)},
+		{"dynamic index", []byte{0, 0, 0x80}},
+		{"name reference past the table", appendString(appendPrefixInt([]byte{0, 0}, 0x50, 4, 200), 0, 7, "v")},
+		{"dynamic name reference", []byte{0, 0, 0x40, 0}},
+		{"post-base index", []byte{0, 0, 0x10}},
+		{"string past the end", []byte{0, 0, 0x21, 'n', 0x05, 'v'}},
+		{"value missing", []byte{0, 0, 0x21, 'n'}},
+		{"bad Huffman", []byte{0, 0, 0x21, 'n', 0x81, 0x00}},
+		{"unterminated integer", []byte{0, 0, 0xff, 0x80}},
+		{"overlong integer", overlong},
+		{"truncated", valid[:len(valid)-1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeAll(tt.section); !errors.Is(err, errQPACK) {
+				t.Errorf("got %v, want errQPACK", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,487 @@
+package http3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/quic"
+)
+
+// defaultMaxHeaderBytes bounds request field sections, as for net/http
+const defaultMaxHeaderBytes = http.DefaultMaxHeaderBytes
+
+// connectionHeaders are the fields HTTP/3 forbids (RFC 9114, Section 4.2)
+var connectionHeaders = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// stream wraps a request stream with deadlines for its reads and writes.
+// Buffered reads and writes do not block, so the deadlines are checked on
+// each besides bounding those that do.
+type stream struct {
+	*quic.Stream
+	// peeked is a byte read ahead of the body, -1 if none
+	peeked        int
+	readDeadline  time.Time
+	writeDeadline time.Time
+	cancelRead    context.CancelFunc
+	cancelWrite   context.CancelFunc
+}
+
+// expired reports whether deadline is set and has passed
+func expired(deadline time.Time) bool {
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+func (s *stream) ReadByte() (byte, error) {
+	if expired(s.readDeadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+	if s.peeked >= 0 {
+		b := byte(s.peeked)
+		s.peeked = -1
+		return b, nil
+	}
+	return s.Stream.ReadByte()
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	if expired(s.readDeadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+	if s.peeked >= 0 && len(p) > 0 {
+		p[0] = byte(s.peeked)
+		s.peeked = -1
+		return 1, nil
+	}
+	return s.Stream.Read(p)
+}
+
+func (s *stream) Write(p []byte) (int, error) {
+	if expired(s.writeDeadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+	return s.Stream.Write(p)
+}
+
+func (s *stream) Flush() error {
+	if expired(s.writeDeadline) {
+		return os.ErrDeadlineExceeded
+	}
+	return s.Stream.Flush()
+}
+
+// setReadDeadline bounds the reads of the stream; zero lifts the bound
+func (s *stream) setReadDeadline(t time.Time) {
+	s.readDeadline = t
+	ctx, cancel := deadlineContext(t)
+	s.Stream.SetReadContext(ctx)
+	if s.cancelRead != nil {
+		s.cancelRead()
+	}
+	s.cancelRead = cancel
+}
+
+// setWriteDeadline bounds the writes of the stream; zero lifts the bound
+func (s *stream) setWriteDeadline(t time.Time) {
+	s.writeDeadline = t
+	ctx, cancel := deadlineContext(t)
+	s.Stream.SetWriteContext(ctx)
+	if s.cancelWrite != nil {
+		s.cancelWrite()
+	}
+	s.cancelWrite = cancel
+}
+
+func (s *stream) release() {
+	if s.cancelRead != nil {
+		s.cancelRead()
+	}
+	if s.cancelWrite != nil {
+		s.cancelWrite()
+	}
+}
+
+func deadlineContext(t time.Time) (context.Context, context.CancelFunc) {
+	if t.IsZero() {
+		return context.Background(), nil
+	}
+	return context.WithDeadline(context.Background(), t)
+}
+
+// bodyProbe is how long a request without content-length is given to show
+// whether it has a body. The end of a request sent without one usually
+// arrives with its HEADERS frame or right after, so only clients holding
+// a body back wait that long.
+const bodyProbe = 50 * time.Millisecond
+
+// atEOF reports whether the client ends the stream within bodyProbe; a byte
+// read to find out is kept for the next read
+func (s *stream) atEOF() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), bodyProbe)
+	defer cancel()
+	s.Stream.SetReadContext(ctx)
+	defer s.Stream.SetReadContext(context.Background())
+	b, err := s.Stream.ReadByte()
+	if err == nil {
+		s.peeked = int(b)
+	}
+	return errors.Is(err, io.EOF)
+}
+
+// serveRequest reads a request off st, serves it and writes the response
+func (c *serverConn) serveRequest(qs *quic.Stream) {
+	defer c.done(qs)
+	st := &stream{Stream: qs, peeked: -1}
+	defer st.release()
+	srv := c.srv
+
+	start := time.Now()
+	if t := srv.ReadHeaderTimeout; t > 0 {
+		st.setReadDeadline(start.Add(t))
+	} else if t := srv.ReadTimeout; t > 0 {
+		st.setReadDeadline(start.Add(t))
+	}
+	req, body, err := c.readRequest(st)
+	if err != nil {
+		var se *streamError
+		var ce *connError
+		switch {
+		case errors.As(err, &ce):
+			c.abort(err)
+		case errors.As(err, &se) && se.code == errExcessiveLoad:
+			w := newResponseWriter(st, nil)
+			w.writeError(http.StatusRequestHeaderFieldsTooLarge)
+		case errors.As(err, &se):
+			st.CloseRead()
+			st.Reset(se.code)
+		default:
+			st.CloseRead()
+			st.Reset(errRequestIncomplete)
+		}
+		return
+	}
+	if t := srv.ReadTimeout; t > 0 {
+		st.setReadDeadline(start.Add(t))
+	} else {
+		st.setReadDeadline(time.Time{})
+	}
+	if t := srv.WriteTimeout; t > 0 {
+		st.setWriteDeadline(start.Add(t))
+	}
+
+	// The context carries what net/http gives its handlers, so that
+	// httputil.ReverseProxy, for one, aborts a response it cannot finish
+	ctx := context.WithValue(context.Background(), http.ServerContextKey, &srv.std)
+	ctx = context.WithValue(ctx, http.LocalAddrContextKey, net.UDPAddrFromAddrPort(c.qc.LocalAddr()))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req = req.WithContext(ctx)
+	w := newResponseWriter(st, req)
+	if req.Method == http.MethodConnect {
+		// Tunnels, extended CONNECT included, are not supported
+		w.writeError(http.StatusNotImplemented)
+		return
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			if v != http.ErrAbortHandler {
+				srv.logger().Error("HTTP/3 handler panicked", "method", req.Method, "path", req.URL.Path, "panic", v)
+			}
+			st.CloseRead()
+			st.Reset(errInternal)
+		}
+	}()
+	srv.Handler.ServeHTTP(w, req)
+	w.finish()
+	if !body.eof {
+		// The handler left the rest of the body unread
+		st.CloseRead()
+	}
+}
+
+// readRequest reads the HEADERS frame opening st into a request, whose body
+// is also returned as the handler may replace it
+func (c *serverConn) readRequest(st *stream) (*http.Request, *requestBody, error) {
+	limit := c.srv.MaxHeaderBytes
+	if limit <= 0 {
+		limit = defaultMaxHeaderBytes
+	}
+	var fields []byte
+	for fields == nil {
+		typ, length, err := readFrameHeader(st)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch typ {
+		case frameHeaders:
+			if length > uint64(limit) {
+				return nil, nil, &streamError{errExcessiveLoad, "request headers too large"}
+			}
+			fields = make([]byte, length)
+			if _, err := io.ReadFull(st, fields); err != nil {
+				return nil, nil, err
+			}
+		case frameData:
+			return nil, nil, &connError{errFrameUnexpected, "DATA before HEADERS"}
+		case frameSettings, frameGoAway:
+			return nil, nil, &connError{errFrameUnexpected, "control frame on request stream"}
+		default:
+			// Reserved and unknown frame types are skipped
+			if err := discard(st, length); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	var method, scheme, authority, path string
+	header := make(http.Header)
+	regular := false
+	err := decodeFields(fields, func(name, value string) error {
+		if strings.HasPrefix(name, ":") {
+			if regular {
+				return &streamError{errMessage, "pseudo-header after regular header"}
+			}
+			var dst *string
+			switch name {
+			case ":method":
+				dst = &method
+			case ":scheme":
+				dst = &scheme
+			case ":authority":
+				dst = &authority
+			case ":path":
+				dst = &path
+			default:
+				return &streamError{errMessage, "unknown pseudo-header " + name}
+			}
+			if *dst != "" {
+				return &streamError{errMessage, "repeated pseudo-header " + name}
+			}
+			*dst = value
+			return nil
+		}
+		regular = true
+		if name != strings.ToLower(name) || connectionHeaders[name] || name == "te" && value != "trailers" {
+			return &streamError{errMessage, "malformed header " + name}
+		}
+		header.Add(http.CanonicalHeaderKey(name), value)
+		return nil
+	})
+	if errors.Is(err, errQPACK) {
+		return nil, nil, &connError{errQPACKDecompression, err.Error()}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if method == "" || method != http.MethodConnect && (scheme == "" || path == "") {
+		return nil, nil, &streamError{errMessage, "missing pseudo-header"}
+	}
+	// Cookies may be split into several fields (RFC 9114, Section 4.2.1)
+	if cookies := header.Values("Cookie"); len(cookies) > 1 {
+		header.Set("Cookie", strings.Join(cookies, "; "))
+	}
+	if authority == "" {
+		authority = header.Get("Host")
+	}
+	header.Del("Host")
+
+	u := &url.URL{Host: authority}
+	if method != http.MethodConnect {
+		if u, err = url.ParseRequestURI(path); err != nil {
+			return nil, nil, &streamError{errMessage, "malformed :path"}
+		}
+	}
+	contentLength := int64(-1)
+	if cl := header.Values("Content-Length"); len(cl) > 0 {
+		if len(cl) > 1 {
+			return nil, nil, &streamError{errMessage, "repeated content-length"}
+		}
+		n, err := strconv.ParseUint(cl[0], 10, 63)
+		if err != nil {
+			return nil, nil, &streamError{errMessage, "malformed content-length"}
+		}
+		contentLength = int64(n)
+	}
+	body := &requestBody{conn: c, st: st, declared: contentLength, limit: limit}
+	var reqBody io.ReadCloser = body
+	if contentLength == -1 && st.atEOF() {
+		contentLength = 0
+	}
+	if contentLength == 0 && st.peeked < 0 {
+		body.eof = true
+		reqBody = http.NoBody
+	}
+	state := c.qc.ConnectionState()
+	req := &http.Request{
+		Method:        method,
+		URL:           u,
+		Proto:         "HTTP/3.0",
+		ProtoMajor:    3,
+		Header:        header,
+		Body:          reqBody,
+		ContentLength: contentLength,
+		Host:          authority,
+		RemoteAddr:    c.qc.RemoteAddr().String(),
+		RequestURI:    path,
+		TLS:           &state,
+		Trailer:       make(http.Header),
+	}
+	body.trailer = req.Trailer
+	return req, body, nil
+}
+
+// requestBody reads the DATA frames of a request stream
+type requestBody struct {
+	conn *serverConn
+	st   *stream
+	// declared is the content-length of the request, -1 if none
+	declared int64
+	read     int64
+	// remaining is what is left of the current DATA frame
+	remaining uint64
+	limit     int
+	trailer   http.Header
+	eof       bool
+	err       error
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.eof {
+		return 0, io.EOF
+	}
+	for b.remaining == 0 {
+		typ, length, err := readFrameHeader(b.st)
+		if errors.Is(err, io.EOF) {
+			return 0, b.end()
+		}
+		if err != nil {
+			return 0, b.fail(err)
+		}
+		switch typ {
+		case frameData:
+			b.remaining = length
+		case frameHeaders:
+			if err := b.readTrailer(length); err != nil {
+				return 0, b.fail(err)
+			}
+			if err := b.readToEnd(); err != nil {
+				return 0, b.fail(err)
+			}
+			return 0, b.end()
+		case frameSettings, frameGoAway:
+			return 0, b.fail(&connError{errFrameUnexpected, "control frame on request stream"})
+		default:
+			if err := discard(b.st, length); err != nil {
+				return 0, b.fail(err)
+			}
+		}
+	}
+	if uint64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.st.Read(p)
+	b.remaining -= uint64(n)
+	b.read += int64(n)
+	if b.declared >= 0 && b.read > b.declared {
+		return n, b.fail(&streamError{errMessage, "body longer than content-length"})
+	}
+	if err != nil && (b.remaining > 0 || !errors.Is(err, io.EOF)) {
+		if errors.Is(err, io.EOF) {
+			// The stream ended inside the frame; the body must not look
+			// complete
+			err = io.ErrUnexpectedEOF
+		}
+		return n, b.fail(err)
+	}
+	return n, nil
+}
+
+// readTrailer reads the trailing HEADERS frame of a request
+func (b *requestBody) readTrailer(length uint64) error {
+	if length > uint64(b.limit) {
+		return &streamError{errExcessiveLoad, "request trailers too large"}
+	}
+	fields := make([]byte, length)
+	if _, err := io.ReadFull(b.st, fields); err != nil {
+		return err
+	}
+	err := decodeFields(fields, func(name, value string) error {
+		if strings.HasPrefix(name, ":") {
+			return &streamError{errMessage, "pseudo-header in trailers"}
+		}
+		b.trailer.Add(http.CanonicalHeaderKey(name), value)
+		return nil
+	})
+	if errors.Is(err, errQPACK) {
+		return &connError{errQPACKDecompression, err.Error()}
+	}
+	return err
+}
+
+// readToEnd reads the rest of the stream after the trailers, where only
+// frames of unknown types may come before it ends
+func (b *requestBody) readToEnd() error {
+	for {
+		typ, length, err := readFrameHeader(b.st)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case frameData, frameHeaders, frameSettings, frameGoAway:
+			return &connError{errFrameUnexpected, "frame after trailers"}
+		}
+		if err := discard(b.st, length); err != nil {
+			return err
+		}
+	}
+}
+
+// end checks the body against its content-length at the end of the stream
+func (b *requestBody) end() error {
+	if b.declared >= 0 && b.read != b.declared {
+		return b.fail(&streamError{errMessage, "body shorter than content-length"})
+	}
+	b.eof = true
+	return io.EOF
+}
+
+func (b *requestBody) fail(err error) error {
+	b.err = fmt.Errorf("http3: reading request body: %w", err)
+	var se *streamError
+	var ce *connError
+	switch {
+	case errors.As(err, &ce):
+		b.conn.abort(err)
+	case errors.As(err, &se):
+		b.st.CloseRead()
+		b.st.Reset(se.code)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		b.st.CloseRead()
+		b.st.Reset(errRequestIncomplete)
+	}
+	return b.err
+}
+
+func (b *requestBody) Close() error {
+	return nil
+}
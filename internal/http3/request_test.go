@@ -0,0 +1,268 @@
+package http3
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/quic"
+)
+
+// testCertificate makes a self-signed certificate for the test server
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"nexus"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// echo answers with the method, path, body and X-Sum trailer of the request
+func echo(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "%s %s %s %s", r.Method, r.URL.Path, body, r.Trailer.Get("X-Sum"))
+}
+
+// dialTestServer starts a server of echo with a small header limit and
+// returns a QUIC connection to it
+func dialTestServer(t *testing.T) *quic.Conn {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{
+		Handler:        http.HandlerFunc(echo),
+		TLSConfig:      &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}},
+		MaxHeaderBytes: 1024,
+	}
+	go srv.Serve(pc)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+
+	client, err := quic.Listen("udp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close(context.Background()) })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := client.Dial(ctx, "udp", pc.LocalAddr().String(), &quic.Config{
+		TLSConfig: &tls.Config{ServerName: "nexus", InsecureSkipVerify: true, MinVersion: tls.VersionTLS13, NextProtos: []string{"h3"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// headersFrame encodes fields as a HEADERS frame
+func headersFrame(fields ...field) []byte {
+	encoded := appendFields(nil, fields)
+	return append(appendFrameHeader(nil, frameHeaders, uint64(len(encoded))), encoded...)
+}
+
+// dataFrame wraps s in a DATA frame
+func dataFrame(s string) []byte {
+	return append(appendFrameHeader(nil, frameData, uint64(len(s))), s...)
+}
+
+// post is the opening HEADERS frame of a POST to /echo
+var post = headersFrame(field{":method", "POST"}, field{":scheme", "https"}, field{":authority", "nexus"}, field{":path", "/echo"})
+
+// send opens a request stream on conn, writes the given frames to it and
+// ends it
+func send(t *testing.T, conn *quic.Conn, frames ...[]byte) *quic.Stream {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	st, err := conn.NewStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range frames {
+		st.Write(f)
+	}
+	st.CloseWrite()
+	return st
+}
+
+// readResponse reads the status and body of the response on st
+func readResponse(st *quic.Stream) (int, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	st.SetReadContext(ctx)
+	status := 0
+	var body []byte
+	for {
+		typ, length, err := readFrameHeader(st)
+		if err == io.EOF {
+			return status, string(body), nil
+		}
+		if err != nil {
+			return status, string(body), err
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(st, payload); err != nil {
+			return status, string(body), err
+		}
+		switch typ {
+		case frameHeaders:
+			fields, err := decodeAll(payload)
+			if err != nil {
+				return status, string(body), err
+			}
+			for _, f := range fields {
+				if f.name == ":status" {
+					status, _ = strconv.Atoi(f.value)
+				}
+			}
+		case frameData:
+			body = append(body, payload...)
+		}
+	}
+}
+
+// closedWith waits for the server to close conn and reports whether it
+// did so with the given error code
+func closedWith(t *testing.T, conn *quic.Conn, code uint64) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := conn.Wait(ctx); !errors.Is(err, &quic.ApplicationError{Code: code}) {
+		t.Errorf("connection closed with %v, want error %#x", err, code)
+	}
+}
+
+func TestRequestRoundTrip(t *testing.T) {
+	conn := dialTestServer(t)
+	tests := []struct {
+		name   string
+		frames [][]byte
+		want   string
+	}{
+		{"no body", [][]byte{post}, "POST /echo  "},
+		{"body over frames", [][]byte{post, dataFrame("hello"), dataFrame(" world")}, "POST /echo hello world "},
+		// HEADERS after DATA are the trailers
+		{"trailers", [][]byte{post, dataFrame("hi"), headersFrame(field{"x-sum", "42"})}, "POST /echo hi 42"},
+		// Frames of unknown types are skipped wherever they come
+		{"unknown frames", [][]byte{appendFrameHeader(nil, 0x21, 3), []byte("abc"), post, dataFrame("x"),
+			appendFrameHeader(nil, 0x21, 0), headersFrame(field{"x-sum", "1"}), appendFrameHeader(nil, 0x21, 0)}, "POST /echo x 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body, err := readResponse(send(t, conn, tt.frames...))
+			if err != nil || status != http.StatusOK || body != tt.want {
+				t.Errorf("got %d %q, %v, want 200 %q", status, body, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOversizedHeaders(t *testing.T) {
+	conn := dialTestServer(t)
+	// A length far past the limit is refused before anything is read or
+	// allocated for it, and the connection carries on
+	for _, length := range []uint64{1025, 1 << 40, maxVarint} {
+		status, _, err := readResponse(send(t, conn, appendFrameHeader(nil, frameHeaders, length)))
+		if err != nil || status != http.StatusRequestHeaderFieldsTooLarge {
+			t.Errorf("length %d: got %d, %v, want 431", length, status, err)
+		}
+	}
+	// Huffman-coded, the value still takes more than the limit
+	trailers := headersFrame(field{"x-sum", strings.Repeat("a", 2000)})
+	if _, _, err := readResponse(send(t, conn, post, dataFrame("x"), trailers)); !errors.Is(err, quic.StreamErrorCode(errExcessiveLoad)) {
+		t.Errorf("oversized trailers: %v, want a stream reset with %#x", err, errExcessiveLoad)
+	}
+	if status, _, err := readResponse(send(t, conn, post)); err != nil || status != http.StatusOK {
+		t.Errorf("next request: got %d, %v", status, err)
+	}
+}
+
+func TestMalformedRequestStreams(t *testing.T) {
+	contentLength := headersFrame(field{":method", "POST"}, field{":scheme", "https"}, field{":authority", "nexus"},
+		field{":path", "/echo"}, field{"content-length", "2"})
+	tests := []struct {
+		name   string
+		frames [][]byte
+		code   uint64
+	}{
+		{"truncated frame type", [][]byte{{0x80, 0x00}}, errRequestIncomplete},
+		{"truncated frame length", [][]byte{{frameHeaders, 0xc0, 0x00, 0x00}}, errRequestIncomplete},
+		{"truncated field section", [][]byte{appendFrameHeader(nil, frameHeaders, 100), post[2:]}, errRequestIncomplete},
+		{"unknown frame past the end", [][]byte{appendFrameHeader(nil, 0x21, maxVarint)}, errRequestIncomplete},
+		{"truncated body frame", [][]byte{post, dataFrame("hello")[:4]}, errRequestIncomplete},
+		{"truncated body frame header", [][]byte{post, dataFrame("x"), {frameData, 0x40}}, errRequestIncomplete},
+		{"missing pseudo-headers", [][]byte{headersFrame(field{":method", "GET"})}, errMessage},
+		{"pseudo-header in trailers", [][]byte{post, dataFrame("x"), headersFrame(field{":path", "/"})}, errMessage},
+		{"body past content-length", [][]byte{contentLength, dataFrame("abc")}, errMessage},
+		{"body short of content-length", [][]byte{contentLength, dataFrame("a")}, errMessage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := dialTestServer(t)
+			if _, _, err := readResponse(send(t, conn, tt.frames...)); !errors.Is(err, quic.StreamErrorCode(tt.code)) {
+				t.Errorf("got %v, want a stream reset with %#x", err, tt.code)
+			}
+			// Only the stream failed
+			if status, _, err := readResponse(send(t, conn, post)); err != nil || status != http.StatusOK {
+				t.Errorf("next request: got %d, %v", status, err)
+			}
+		})
+	}
+}
+
+func TestMalformedRequestsCloseConnection(t *testing.T) {
+	badIndex := appendPrefixInt([]byte{0, 0}, 0xc0, 6, uint64(len(staticTable)))
+	tests := []struct {
+		name   string
+		frames [][]byte
+		code   uint64
+	}{
+		{"DATA before HEADERS", [][]byte{dataFrame("x"), post}, errFrameUnexpected},
+		{"SETTINGS on a request stream", [][]byte{appendFrameHeader(nil, frameSettings, 0), post}, errFrameUnexpected},
+		{"GOAWAY in a body", [][]byte{post, dataFrame("x"), appendFrameHeader(nil, frameGoAway, 1), {0}}, errFrameUnexpected},
+		{"DATA after trailers", [][]byte{post, dataFrame("x"), headersFrame(field{"x-sum", "1"}), dataFrame("y")}, errFrameUnexpected},
+		{"HEADERS after trailers", [][]byte{post, headersFrame(field{"x-sum", "1"}), headersFrame(field{"x-sum", "2"})}, errFrameUnexpected},
+		{"static index past the table", [][]byte{append(appendFrameHeader(nil, frameHeaders, uint64(len(badIndex))), badIndex...)}, errQPACKDecompression},
+		{"dynamic table reference", [][]byte{appendFrameHeader(nil, frameHeaders, 3), {0, 0, 0x80}}, errQPACKDecompression},
+		{"bad index in trailers", [][]byte{post, dataFrame("x"), append(appendFrameHeader(nil, frameHeaders, uint64(len(badIndex))), badIndex...)}, errQPACKDecompression},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := dialTestServer(t)
+			send(t, conn, tt.frames...)
+			closedWith(t, conn, tt.code)
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package http3
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// responseWriter writes a response as the HEADERS and DATA frames of its
+// stream. Like net/http, it sends the header on the first write or flush,
+// sniffing the content type and adding the date if the handler did not
+// set them.
+type responseWriter struct {
+	st  *stream
+	req *http.Request
+
+	header http.Header
+	status int
+	// wroteHeader is whether the handler chose the status, sentHeader
+	// whether the header has gone out
+	wroteHeader bool
+	sentHeader  bool
+	// declared is the content-length the handler set, -1 if none
+	declared int64
+	written  int64
+	// trailers are the names the handler announced in the Trailer header
+	trailers []string
+	frame    []byte
+}
+
+func newResponseWriter(st *stream, req *http.Request) *responseWriter {
+	return &responseWriter{st: st, req: req, header: make(http.Header), declared: -1}
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	if code < 100 || code > 999 {
+		panic("http3: invalid WriteHeader code " + strconv.Itoa(code))
+	}
+	if code < 200 {
+		// HTTP/3 has no Upgrade, so 101 cannot be sent; other informational
+		// responses, such as 103 Early Hints, go out at once
+		if code != http.StatusSwitchingProtocols {
+			w.writeFields(frameHeaders, w.fields(code))
+			w.st.Flush()
+		}
+		return
+	}
+	w.wroteHeader = true
+	w.status = code
+	if cl := w.header.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil && n >= 0 {
+			w.declared = n
+		}
+	}
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !bodyAllowed(w.status) {
+		return 0, http.ErrBodyNotAllowed
+	}
+	if !w.sentHeader {
+		w.sendHeader(p)
+	}
+	if w.head() {
+		return len(p), nil
+	}
+	if w.declared >= 0 && w.written+int64(len(p)) > w.declared {
+		return 0, http.ErrContentLength
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	w.frame = appendFrameHeader(w.frame[:0], frameData, uint64(len(p)))
+	if _, err := w.st.Write(w.frame); err != nil {
+		return 0, err
+	}
+	n, err := w.st.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// Flush sends what has been written so far
+func (w *responseWriter) Flush() {
+	w.FlushError()
+}
+
+// FlushError sends what has been written so far, and is the flush
+// http.ResponseController uses
+func (w *responseWriter) FlushError() error {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.sentHeader {
+		w.sendHeader(nil)
+	}
+	return w.st.Flush()
+}
+
+// SetReadDeadline bounds reading the request body, for
+// http.ResponseController
+func (w *responseWriter) SetReadDeadline(t time.Time) error {
+	w.st.setReadDeadline(t)
+	return nil
+}
+
+// SetWriteDeadline bounds writing the response, for http.ResponseController
+func (w *responseWriter) SetWriteDeadline(t time.Time) error {
+	w.st.setWriteDeadline(t)
+	return nil
+}
+
+// sendHeader writes the HEADERS frame of the response, p being the start
+// of the body if any was written
+func (w *responseWriter) sendHeader(p []byte) {
+	w.sentHeader = true
+	if _, ok := w.header["Content-Type"]; !ok && len(p) > 0 && bodyAllowed(w.status) && w.header.Get("Content-Encoding") == "" {
+		w.header.Set("Content-Type", http.DetectContentType(p))
+	}
+	if _, ok := w.header["Date"]; !ok {
+		w.header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	for _, v := range w.header.Values("Trailer") {
+		for name := range strings.SplitSeq(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				w.trailers = append(w.trailers, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	w.writeFields(frameHeaders, w.fields(w.status))
+}
+
+// fields lists the fields of a response header with status
+func (w *responseWriter) fields(status int) []field {
+	fields := []field{{":status", strconv.Itoa(status)}}
+	for name, values := range w.header {
+		lower := strings.ToLower(name)
+		if connectionHeaders[lower] || strings.HasPrefix(name, http.TrailerPrefix) {
+			continue
+		}
+		for _, v := range values {
+			fields = append(fields, field{lower, v})
+		}
+	}
+	return fields
+}
+
+// trailerFields lists the values of the trailers names
+func (w *responseWriter) trailerFields(names []string) []field {
+	var fields []field
+	for _, name := range names {
+		for _, v := range w.header.Values(name) {
+			fields = append(fields, field{strings.ToLower(name), v})
+		}
+	}
+	return fields
+}
+
+// writeFields writes fields as a frame of type typ
+func (w *responseWriter) writeFields(typ uint64, fields []field) error {
+	encoded := appendFields(nil, fields)
+	w.frame = appendFrameHeader(w.frame[:0], typ, uint64(len(encoded)))
+	if _, err := w.st.Write(w.frame); err != nil {
+		return err
+	}
+	_, err := w.st.Write(encoded)
+	return err
+}
+
+// finish ends the response once the handler has returned, sending its
+// header if nothing was written and its trailers if it set any
+func (w *responseWriter) finish() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.sentHeader {
+		if _, ok := w.header["Content-Length"]; !ok && bodyAllowed(w.status) && !w.head() {
+			w.header.Set("Content-Length", "0")
+		}
+		w.sendHeader(nil)
+	}
+	names := w.trailers
+	for name := range w.header {
+		if after, ok := strings.CutPrefix(name, http.TrailerPrefix); ok {
+			w.header[http.CanonicalHeaderKey(after)] = w.header[name]
+			names = append(names, http.CanonicalHeaderKey(after))
+		}
+	}
+	if trailers := w.trailerFields(names); len(trailers) > 0 {
+		w.writeFields(frameHeaders, trailers)
+	}
+	w.st.CloseWrite()
+}
+
+// writeError answers with a plain error response of status code
+func (w *responseWriter) writeError(code int) {
+	w.header.Set("Content-Type", "text/plain; charset=utf-8")
+	w.header.Set("X-Content-Type-Options", "nosniff")
+	body := http.StatusText(code) + "\n"
+	w.header.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(code)
+	w.Write([]byte(body))
+	w.finish()
+}
+
+// head reports whether the response answers a HEAD request, which gets no
+// body; the request is nil for those refused before it could be read
+func (w *responseWriter) head() bool {
+	return w.req != nil && w.req.Method == http.MethodHead
+}
+
+// bodyAllowed reports whether a response with status may have a body
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
@@ -0,0 +1,345 @@
+// Package http3 serves HTTP/3 over the QUIC transport of golang.org/x/net,
+// handing requests to an ordinary http.Handler so they go through the same
+// handlers as those arriving over HTTP/1.1 and HTTP/2. Server push, the
+// QPACK dynamic table and extended CONNECT are not supported.
+package http3
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/quic"
+)
+
+// Server serves HTTP/3 requests on a UDP address
+type Server struct {
+	Addr    string
+	Handler http.Handler
+	// TLSConfig provides the certificates; ALPN is set to h3 and TLS 1.3
+	// required, as QUIC needs
+	TLSConfig *tls.Config
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout and MaxHeaderBytes work
+	// as for http.Server; IdleTimeout closes connections without a request
+	// for that long
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	Logger            *slog.Logger
+
+	// std stands for the server in request contexts, under
+	// http.ServerContextKey
+	std http.Server
+
+	mu       sync.Mutex
+	endpoint *quic.Endpoint
+	conns    map[*serverConn]struct{}
+	closing  bool
+	// stopAccept stops the accept loop
+	stopAccept context.CancelFunc
+}
+
+// ListenAndServe listens on Addr and serves connections until Shutdown,
+// when it returns http.ErrServerClosed
+func (s *Server) ListenAndServe() error {
+	conn, err := net.ListenPacket("udp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(conn)
+}
+
+// Serve serves connections on conn, a UDP socket bound to Addr, until
+// Shutdown, which closes it
+func (s *Server) Serve(conn net.PacketConn) error {
+	tlsConfig := s.TLSConfig.Clone()
+	tlsConfig.NextProtos = []string{"h3"}
+	tlsConfig.MinVersion = tls.VersionTLS13
+	idle := s.IdleTimeout
+	if idle <= 0 {
+		// Match net/http, which keeps idle connections open indefinitely
+		idle = -1
+	}
+	endpoint, err := quic.NewEndpoint(conn, &quic.Config{
+		TLSConfig:        tlsConfig,
+		MaxIdleTimeout:   idle,
+		HandshakeTimeout: s.ReadHeaderTimeout,
+	})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		endpoint.Close(context.Background())
+		return http.ErrServerClosed
+	}
+	s.endpoint = endpoint
+	s.stopAccept = cancel
+	s.std = http.Server{Addr: s.Addr, Handler: s.Handler}
+	s.conns = make(map[*serverConn]struct{})
+	s.mu.Unlock()
+
+	for {
+		qc, err := endpoint.Accept(ctx)
+		if err != nil {
+			s.mu.Lock()
+			closing := s.closing
+			s.mu.Unlock()
+			if closing {
+				return http.ErrServerClosed
+			}
+			return err
+		}
+		c := &serverConn{srv: s, qc: qc, active: make(map[*quic.Stream]bool)}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		go c.serve()
+	}
+}
+
+// Shutdown stops accepting connections and tells clients with GOAWAY to
+// send no more requests, then waits for the requests in flight until ctx
+// is done. Connections are closed either way before it returns.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	endpoint := s.endpoint
+	if s.stopAccept != nil {
+		s.stopAccept()
+	}
+	conns := make([]*serverConn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+	if endpoint == nil {
+		return nil
+	}
+
+	for _, c := range conns {
+		c.goAway()
+	}
+	var err error
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+wait:
+	for s.inFlight() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break wait
+		}
+	}
+	// The peers have a moment to acknowledge the close; past ctx, they do
+	// not get it
+	closeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second)
+	defer cancel()
+	endpoint.Close(closeCtx)
+	return err
+}
+
+// inFlight counts the requests being served
+func (s *Server) inFlight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for c := range s.conns {
+		c.mu.Lock()
+		n += len(c.active)
+		c.mu.Unlock()
+	}
+	return n
+}
+
+func (s *Server) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+// serverConn is a QUIC connection carrying HTTP/3
+type serverConn struct {
+	srv *Server
+	qc  *quic.Conn
+
+	mu sync.Mutex
+	// control is the server's control stream, nil until opened
+	control *quic.Stream
+	active  map[*quic.Stream]bool
+	// nextStreamID is the ID of the next request stream, and goneAway
+	// whether a GOAWAY has been sent
+	nextStreamID uint64
+	goneAway     bool
+	// peerControl tells whether the client has opened its control stream
+	peerControl bool
+}
+
+// serve opens the server's control stream, then serves the streams the
+// client opens until the connection ends
+func (c *serverConn) serve() {
+	defer func() {
+		c.srv.mu.Lock()
+		delete(c.srv.conns, c)
+		c.srv.mu.Unlock()
+	}()
+	ctx := context.Background()
+	control, err := c.qc.NewSendOnlyStream(ctx)
+	if err != nil {
+		c.qc.Abort(nil)
+		return
+	}
+	// An empty SETTINGS leaves every setting at its default of zero: no
+	// QPACK dynamic table and no blocked streams
+	b := appendVarint(nil, streamControl)
+	b = appendFrameHeader(b, frameSettings, 0)
+	control.Write(b)
+	control.Flush()
+	c.mu.Lock()
+	c.control = control
+	goneAway := c.goneAway
+	c.mu.Unlock()
+	if goneAway {
+		c.goAway()
+	}
+
+	for {
+		st, err := c.qc.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		if st.IsReadOnly() {
+			go c.serveUniStream(st)
+			continue
+		}
+		if !c.accept(st) {
+			// After GOAWAY, the client is told to retry the request
+			// elsewhere
+			st.CloseRead()
+			st.Reset(errRequestRejected)
+			continue
+		}
+		go c.serveRequest(st)
+	}
+}
+
+// accept registers a new request stream unless a GOAWAY has been sent
+func (c *serverConn) accept(st *quic.Stream) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.goneAway {
+		return false
+	}
+	c.nextStreamID += 4
+	c.active[st] = true
+	return true
+}
+
+// done unregisters a finished request stream
+func (c *serverConn) done(st *quic.Stream) {
+	c.mu.Lock()
+	delete(c.active, st)
+	c.mu.Unlock()
+}
+
+// goAway tells the client that no request beyond those already received
+// will be served
+func (c *serverConn) goAway() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.goneAway = true
+	if c.control == nil {
+		return
+	}
+	// Client request streams are numbered 0, 4, 8...; the ID is the first
+	// one not served
+	b := appendVarint(nil, c.nextStreamID)
+	c.control.Write(appendFrameHeader(nil, frameGoAway, uint64(len(b))))
+	c.control.Write(b)
+	c.control.Flush()
+}
+
+// abort closes the connection with an HTTP/3 error code
+func (c *serverConn) abort(err error) {
+	var ce *connError
+	if errors.As(err, &ce) {
+		c.qc.Abort(&quic.ApplicationError{Code: ce.code, Reason: ce.reason})
+		return
+	}
+	c.qc.Abort(&quic.ApplicationError{Code: errGeneralProtocol})
+}
+
+// serveUniStream reads a unidirectional stream opened by the client
+func (c *serverConn) serveUniStream(st *quic.Stream) {
+	typ, err := readVarint(st)
+	if err != nil {
+		st.CloseRead()
+		return
+	}
+	switch typ {
+	case streamControl:
+		c.mu.Lock()
+		dup := c.peerControl
+		c.peerControl = true
+		c.mu.Unlock()
+		if dup {
+			c.abort(&connError{errStreamCreation, "second control stream"})
+			return
+		}
+		c.readControl(st)
+	case streamQPACKEncoder, streamQPACKDecoder:
+		// With no dynamic table there is nothing to act on; the stream is
+		// drained for the connection's flow control
+		discard(st, maxVarint)
+	case streamPush:
+		c.abort(&connError{errStreamCreation, "push stream from client"})
+	default:
+		// Unknown stream types are ignored (RFC 9114, Section 6.2)
+		st.CloseRead()
+	}
+}
+
+// readControl reads the client's control stream, which must open with
+// SETTINGS and stay open for the life of the connection
+func (c *serverConn) readControl(st *quic.Stream) {
+	first := true
+	for {
+		typ, length, err := readFrameHeader(st)
+		if err != nil {
+			c.abort(&connError{errClosedCriticalStream, "control stream closed"})
+			return
+		}
+		switch {
+		case first && typ != frameSettings:
+			c.abort(&connError{errMissingSettings, "control stream must open with SETTINGS"})
+			return
+		case !first && typ == frameSettings:
+			c.abort(&connError{errFrameUnexpected, "second SETTINGS"})
+			return
+		case typ == frameData || typ == frameHeaders:
+			c.abort(&connError{errFrameUnexpected, "request frame on control stream"})
+			return
+		}
+		first = false
+		// SETTINGS carry nothing the server acts on, and a client GOAWAY
+		// only concerns pushes
+		if err := discard(st, length); err != nil {
+			c.abort(&connError{errClosedCriticalStream, "control stream closed"})
+			return
+		}
+	}
+}
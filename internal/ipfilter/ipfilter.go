@@ -0,0 +1,155 @@
+// Package ipfilter admits or refuses clients by IP address, with allow and
+// deny lists of networks that can be replaced at runtime through the admin
+// API
+package ipfilter
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/metrics"
+)
+
+// Lists are the networks a filter allows and denies. Deny is checked
+// first, so a client in both is refused; if Allow is empty, every client
+// not denied is admitted, otherwise only those in Allow are.
+type Lists struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+}
+
+// Options configure a Filter
+type Options struct {
+	// Trusted resolves the client IP behind trusted proxies, like the
+	// access log does
+	Trusted backend.TrustedProxies
+	Metrics *metrics.Metrics
+}
+
+// Filter answers refused clients with 403 and passes the others on
+type Filter struct {
+	name     string
+	o        Options
+	rejected atomic.Uint64
+
+	mu    sync.RWMutex
+	lists Lists
+}
+
+// Status describes a filter
+type Status struct {
+	Name     string   `json:"name"`
+	Allow    []string `json:"allow"`
+	Deny     []string `json:"deny"`
+	Rejected uint64   `json:"rejected"`
+}
+
+// New creates a filter named name, as the admin API addresses it
+func New(name string, lists Lists, o Options) *Filter {
+	return &Filter{name: name, o: o, lists: lists}
+}
+
+// Parse parses allow and deny lists of CIDRs such as "10.0.0.0/8" or
+// "2001:db8::/32"; a bare address is taken as a single host
+func Parse(allow, deny []string) (Lists, error) {
+	var l Lists
+	var err error
+	if l.Allow, err = parsePrefixes("allow", allow); err != nil {
+		return Lists{}, err
+	}
+	if l.Deny, err = parsePrefixes("deny", deny); err != nil {
+		return Lists{}, err
+	}
+	return l, nil
+}
+
+func parsePrefixes(list string, cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(cidr)
+			if addrErr != nil {
+				return nil, fmt.Errorf("%s network %q: %w", list, cidr, err)
+			}
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// Name returns the name of the filter
+func (f *Filter) Name() string {
+	return f.name
+}
+
+// Set replaces the lists of the filter; requests already admitted are not
+// affected
+func (f *Filter) Set(lists Lists) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lists = lists
+}
+
+// Admits reports whether the client at ip may pass. An ip that is not an
+// address is only admitted by a filter without an allow list.
+func (f *Filter) Admits(ip string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return len(f.lists.Allow) == 0
+	}
+	addr = addr.Unmap()
+	if contains(f.lists.Deny, addr) {
+		return false
+	}
+	return len(f.lists.Allow) == 0 || contains(f.lists.Allow, addr)
+}
+
+// contains reports whether addr lies in one of prefixes
+func contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Wrap puts the filter in front of next
+func (f *Filter) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !f.Admits(f.o.Trusted.ClientIP(r)) {
+			f.rejected.Add(1)
+			f.o.Metrics.Rejected("ip_filter")
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Rejected returns how many requests the filter refused
+func (f *Filter) Rejected() uint64 {
+	return f.rejected.Load()
+}
+
+// Status returns the lists of the filter and how many requests it refused
+func (f *Filter) Status() Status {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	st := Status{Name: f.name, Allow: []string{}, Deny: []string{}, Rejected: f.Rejected()}
+	for _, p := range f.lists.Allow {
+		st.Allow = append(st.Allow, p.String())
+	}
+	for _, p := range f.lists.Deny {
+		st.Deny = append(st.Deny, p.String())
+	}
+	return st
+}
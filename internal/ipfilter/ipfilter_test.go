@@ -0,0 +1,101 @@
+package ipfilter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+)
+
+func mustParse(t *testing.T, allow, deny []string) Lists {
+	t.Helper()
+	l, err := Parse(allow, deny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func serve(f *Filter, remote, xff string) int {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = remote
+	if xff != "" {
+		r.Header.Set("X-Forwarded-For", xff)
+	}
+	w := httptest.NewRecorder()
+	f.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestAdmits(t *testing.T) {
+	f := New("test", mustParse(t, []string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.1.0.0/16"}), Options{})
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.0.0.1", true},
+		{"10.1.0.1", false},
+		{"::ffff:10.0.0.1", true},
+		{"2001:db8::1", true},
+		{"192.0.2.1", false},
+		{"bogus", false},
+	}
+	for _, tt := range tests {
+		if got := f.Admits(tt.ip); got != tt.want {
+			t.Errorf("Admits(%q) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestForgedForwardedFor(t *testing.T) {
+	var trusted backend.TrustedProxies // trusted_proxies unset
+	allow := New("allow", mustParse(t, []string{"10.0.0.0/8"}, nil), Options{Trusted: trusted})
+	deny := New("deny", mustParse(t, nil, []string{"203.0.113.0/24"}), Options{Trusted: trusted})
+
+	// An untrusted peer cannot claim an allowed address
+	if code := serve(allow, "203.0.113.9:4000", "10.0.0.1"); code != http.StatusForbidden {
+		t.Errorf("forged allowed address: status %d, want 403", code)
+	}
+	// nor hide a denied one behind another address
+	if code := serve(deny, "203.0.113.9:4000", "198.51.100.1"); code != http.StatusForbidden {
+		t.Errorf("forged address past deny list: status %d, want 403", code)
+	}
+	if got := deny.Rejected(); got != 1 {
+		t.Errorf("Rejected = %d, want 1", got)
+	}
+	// A loopback proxy is still believed
+	if code := serve(allow, "127.0.0.1:4000", "10.0.0.1"); code != http.StatusOK {
+		t.Errorf("loopback proxy: status %d, want 200", code)
+	}
+}
+
+func TestForwardedForFromTrustedProxy(t *testing.T) {
+	trusted, err := backend.ParseTrustedProxies([]string{"192.0.2.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := New("deny", mustParse(t, nil, []string{"203.0.113.0/24"}), Options{Trusted: trusted})
+	if code := serve(f, "192.0.2.1:4000", "203.0.113.9"); code != http.StatusForbidden {
+		t.Errorf("denied client behind trusted proxy: status %d, want 403", code)
+	}
+	// A denied client cannot prepend an address of its own
+	if code := serve(f, "192.0.2.1:4000", "198.51.100.1, 203.0.113.9"); code != http.StatusForbidden {
+		t.Errorf("prepended address: status %d, want 403", code)
+	}
+}
+
+func TestSet(t *testing.T) {
+	f := New("test", Lists{}, Options{})
+	if !f.Admits("192.0.2.1") {
+		t.Fatal("empty filter refused a client")
+	}
+	f.Set(mustParse(t, nil, []string{"192.0.2.0/24"}))
+	if f.Admits("192.0.2.1") {
+		t.Fatal("Set did not apply the deny list")
+	}
+	st := f.Status()
+	if st.Name != "test" || len(st.Deny) != 1 || st.Deny[0] != "192.0.2.0/24" {
+		t.Errorf("Status = %+v", st)
+	}
+}
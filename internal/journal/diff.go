@@ -0,0 +1,215 @@
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// redacted replaces secret values in diffs
+const redacted = "[REDACTED]"
+
+// secretKeys are substrings of field names whose values must never appear
+// in the journal
+var secretKeys = []string{"token", "password", "secret", "api_key", "private_key"}
+
+// Change is a single difference between two configurations
+type Change struct {
+	Path string `json:"path"`
+	Op   string `json:"op"` // "added", "removed" or "changed"
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+}
+
+// Diff compares two values through their JSON representation and returns
+// the changed leaves, with paths like "backends[url=http://a].weight".
+// List entries that carry a "url" field are matched by URL rather than by
+// position, so inserting a backend does not show up as every later one
+// changing.
+func Diff(before, after any) ([]Change, error) {
+	a, err := toGeneric(before)
+	if err != nil {
+		return nil, err
+	}
+	b, err := toGeneric(after)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	diffValues("", a, b, false, &changes)
+	return changes, nil
+}
+
+// toGeneric round-trips v through JSON into maps, slices and scalars
+func toGeneric(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// diffValues appends the differences between a and b under path
+func diffValues(path string, a, b any, secret bool, out *[]Change) {
+	switch av := a.(type) {
+	case map[string]any:
+		if bv, ok := b.(map[string]any); ok {
+			diffMaps(path, av, bv, secret, out)
+			return
+		}
+	case []any:
+		if bv, ok := b.([]any); ok {
+			diffSlices(path, av, bv, secret, out)
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		*out = append(*out, newChange(path, "changed", a, b, secret))
+	}
+}
+
+// diffMaps compares two objects key by key
+func diffMaps(path string, a, b map[string]any, secret bool, out *[]Change) {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		childPath := k
+		if path != "" {
+			childPath = path + "." + k
+		}
+		childSecret := secret || isSecretKey(k)
+
+		av, inA := a[k]
+		bv, inB := b[k]
+		switch {
+		case !inA:
+			*out = append(*out, newChange(childPath, "added", nil, bv, childSecret))
+		case !inB:
+			*out = append(*out, newChange(childPath, "removed", av, nil, childSecret))
+		default:
+			diffValues(childPath, av, bv, childSecret, out)
+		}
+	}
+}
+
+// diffSlices compares two lists, by "url" identity when every entry has one
+// and by position otherwise
+func diffSlices(path string, a, b []any, secret bool, out *[]Change) {
+	aKeys, aKeyed := urlKeys(a)
+	bKeys, bKeyed := urlKeys(b)
+
+	if !aKeyed || !bKeyed {
+		for i := 0; i < max(len(a), len(b)); i++ {
+			childPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(a):
+				*out = append(*out, newChange(childPath, "added", nil, b[i], secret))
+			case i >= len(b):
+				*out = append(*out, newChange(childPath, "removed", a[i], nil, secret))
+			default:
+				diffValues(childPath, a[i], b[i], secret, out)
+			}
+		}
+		return
+	}
+
+	bByKey := make(map[string]any, len(b))
+	for i, k := range bKeys {
+		bByKey[k] = b[i]
+	}
+	aByKey := make(map[string]any, len(a))
+	for i, k := range aKeys {
+		aByKey[k] = a[i]
+		childPath := fmt.Sprintf("%s[url=%s]", path, k)
+		if bv, ok := bByKey[k]; ok {
+			diffValues(childPath, a[i], bv, secret, out)
+		} else {
+			*out = append(*out, newChange(childPath, "removed", a[i], nil, secret))
+		}
+	}
+	for i, k := range bKeys {
+		if _, ok := aByKey[k]; !ok {
+			*out = append(*out, newChange(fmt.Sprintf("%s[url=%s]", path, k), "added", nil, b[i], secret))
+		}
+	}
+}
+
+// urlKeys returns the "url" field of every entry, and whether all entries
+// have a distinct one
+func urlKeys(list []any) ([]string, bool) {
+	keys := make([]string, len(list))
+	seen := make(map[string]bool, len(list))
+	for i, item := range list {
+		m, ok := item.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		u, ok := m["url"].(string)
+		if !ok || seen[u] {
+			return nil, false
+		}
+		seen[u] = true
+		keys[i] = u
+	}
+	return keys, true
+}
+
+// newChange builds a change, hiding the values of secrets, including those
+// nested in an added or removed object
+func newChange(path, op string, old, new any, secret bool) Change {
+	return Change{Path: path, Op: op, Old: redact(old, secret), New: redact(new, secret)}
+}
+
+// redact returns v with the values of secret fields replaced, or entirely
+// replaced if secret is set
+func redact(v any, secret bool) any {
+	if v == nil {
+		return nil
+	}
+	if secret {
+		return redacted
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			out[k] = redact(item, isSecretKey(k))
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = redact(item, false)
+		}
+		return out
+	}
+	return v
+}
+
+// isSecretKey reports whether a field name denotes a secret
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range secretKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package journal
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nexus-lb/nexus/config"
+)
+
+func TestDiffNested(t *testing.T) {
+	before := config.Default()
+	before.Backends = []config.BackendConfig{
+		{URL: "http://a:80", Weight: 1},
+		{URL: "http://b:80", Weight: 1},
+	}
+	after := config.Default()
+	after.Backends = []config.BackendConfig{
+		{URL: "http://c:80", Weight: 1},
+		{URL: "http://a:80", Weight: 3},
+	}
+	after.HealthCheck.Interval = config.Duration(time.Minute)
+
+	changes, err := Diff(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Change{
+		"backends[url=http://a:80].weight": {Op: "changed", Old: 1.0, New: 3.0},
+		"backends[url=http://b:80]":        {Op: "removed"},
+		"backends[url=http://c:80]":        {Op: "added"},
+		"health_check.interval":            {Op: "changed", Old: before.HealthCheck.Interval.Std().String(), New: "1m0s"},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("changes %+v, want %d", changes, len(want))
+	}
+	for _, c := range changes {
+		w, ok := want[c.Path]
+		if !ok {
+			t.Errorf("unexpected change %+v", c)
+			continue
+		}
+		if c.Op != w.Op || (w.Old != nil && !reflect.DeepEqual(c.Old, w.Old)) || (w.New != nil && !reflect.DeepEqual(c.New, w.New)) {
+			t.Errorf("%s: got %+v, want %+v", c.Path, c, w)
+		}
+	}
+}
+
+func TestDiffPositionalLists(t *testing.T) {
+	type listen struct {
+		Addresses []string `json:"addresses"`
+	}
+	changes, err := Diff(listen{[]string{":80", ":81"}}, listen{[]string{":80", ":82", ":83"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Change{
+		{Path: "addresses[1]", Op: "changed", Old: ":81", New: ":82"},
+		{Path: "addresses[2]", Op: "added", New: ":83"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes %+v, want %+v", changes, want)
+	}
+}
+
+func TestDiffRedactsSecrets(t *testing.T) {
+	before := config.Default()
+	before.Admin.Token = "old-token"
+	after := config.Default()
+	after.Admin.Token = "new-token"
+	// Secrets nested in a newly added object must be hidden as well
+	after.Admin.Auth = &config.AdminAuthConfig{Username: "ops", Password: "hunter2", AllowCIDRs: []string{"10.0.0.0/8"}}
+
+	changes, err := Diff(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(changes)
+	for _, secret := range []string{"old-token", "new-token", "hunter2"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("diff %s leaks %q", data, secret)
+		}
+	}
+	for _, c := range changes {
+		if c.Path == "admin.token" && (c.Old != redacted || c.New != redacted) {
+			t.Errorf("admin.token: got %+v, want both values redacted", c)
+		}
+		if c.Path == "admin.auth" {
+			auth := c.New.(map[string]any)
+			if auth["username"] != "ops" || auth["password"] != redacted {
+				t.Errorf("admin.auth: got %v, want the username kept and the password redacted", auth)
+			}
+		}
+	}
+}
+
+func TestDiffEqual(t *testing.T) {
+	changes, err := Diff(config.Default(), config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("changes %+v between equal configurations", changes)
+	}
+}
@@ -0,0 +1,113 @@
+package journal
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/events"
+)
+
+// Types of journal entries
+const (
+	TypeConfigReload = "config_reload"
+	TypeAdmin        = "admin"
+	TypeLibrary      = "library"
+	TypeDiscovery    = "discovery"
+	TypeFreeze       = "freeze"
+)
+
+// EventType is the event bus type under which journal entries are published
+const EventType = "change"
+
+// Entry is one recorded change
+type Entry struct {
+	ID      uint64    `json:"id"`
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Actor   string    `json:"actor"`
+	Summary string    `json:"summary"`
+	Diff    []Change  `json:"diff,omitempty"`
+}
+
+// Filter selects journal entries; zero fields match everything
+type Filter struct {
+	Type  string
+	Since time.Time
+	Until time.Time
+}
+
+// matches reports whether an entry passes the filter
+func (f Filter) matches(e Entry) bool {
+	if f.Type != "" && e.Type != f.Type {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Journal keeps a bounded, in-memory history of configuration and
+// operator changes, oldest entries falling off first
+type Journal struct {
+	mu       sync.Mutex
+	entries  []Entry
+	start    int // index of the oldest entry once the ring is full
+	capacity int
+	nextID   uint64
+	bus      *events.Bus
+}
+
+// New creates a journal holding at most capacity entries. Entries are also
+// published on bus, if given.
+func New(capacity int, bus *events.Bus) *Journal {
+	return &Journal{
+		entries:  make([]Entry, 0, capacity),
+		capacity: capacity,
+		bus:      bus,
+	}
+}
+
+// Record appends an entry to the journal
+func (j *Journal) Record(entryType, actor, summary string, diff []Change) Entry {
+	j.mu.Lock()
+	j.nextID++
+	e := Entry{
+		ID:      j.nextID,
+		Time:    time.Now(),
+		Type:    entryType,
+		Actor:   actor,
+		Summary: summary,
+		Diff:    diff,
+	}
+	if len(j.entries) < j.capacity {
+		j.entries = append(j.entries, e)
+	} else {
+		j.entries[j.start] = e
+		j.start = (j.start + 1) % j.capacity
+	}
+	j.mu.Unlock()
+
+	if j.bus != nil {
+		j.bus.Publish(EventType, e)
+	}
+	return e
+}
+
+// Entries returns the entries matching the filter, oldest first
+func (j *Journal) Entries(f Filter) []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	result := make([]Entry, 0, len(j.entries))
+	for i := range j.entries {
+		e := j.entries[(j.start+i)%len(j.entries)]
+		if f.matches(e) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
@@ -0,0 +1,61 @@
+package journal
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/events"
+)
+
+func TestBoundedRetention(t *testing.T) {
+	j := New(3, nil)
+	for i := 1; i <= 5; i++ {
+		j.Record(TypeAdmin, "test", fmt.Sprintf("change %d", i), nil)
+	}
+	entries := j.Entries(Filter{})
+	if len(entries) != 3 {
+		t.Fatalf("%d entries kept, want 3", len(entries))
+	}
+	for i, e := range entries {
+		if want := fmt.Sprintf("change %d", i+3); e.Summary != want || e.ID != uint64(i+3) {
+			t.Errorf("entry %d is %d %q, want %q", i, e.ID, e.Summary, want)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	j := New(8, nil)
+	j.Record(TypeConfigReload, "reload", "first", nil)
+	mid := time.Now()
+	time.Sleep(time.Millisecond)
+	j.Record(TypeAdmin, "ops", "second", nil)
+	j.Record(TypeFreeze, "ops", "third", nil)
+
+	if got := j.Entries(Filter{Type: TypeAdmin}); len(got) != 1 || got[0].Summary != "second" {
+		t.Errorf("type filter: %+v", got)
+	}
+	if got := j.Entries(Filter{Since: mid}); len(got) != 2 || got[0].Summary != "second" {
+		t.Errorf("since filter: %+v", got)
+	}
+	if got := j.Entries(Filter{Until: mid}); len(got) != 1 || got[0].Summary != "first" {
+		t.Errorf("until filter: %+v", got)
+	}
+}
+
+func TestPublishes(t *testing.T) {
+	bus := events.NewBus()
+	sub := bus.Subscribe(4)
+	defer bus.Unsubscribe(sub)
+
+	j := New(4, bus)
+	j.Record(TypeDiscovery, "dns", "2 added", nil)
+	select {
+	case ev := <-sub.C:
+		if e, ok := ev.Data.(Entry); !ok || ev.Type != EventType || e.Summary != "2 added" {
+			t.Errorf("published %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("entry not published")
+	}
+}
@@ -0,0 +1,284 @@
+// Package jwt verifies the bearer tokens of routes whose backends expect
+// their clients to be authenticated already, rejecting bad tokens before
+// they cost a backend anything. Tokens are checked against a static public
+// key or the keys a JWKS endpoint publishes, and selected claims are passed
+// on to the backend as headers.
+package jwt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/metrics"
+	"github.com/nexus-lb/nexus/internal/proxy"
+)
+
+// Options configure a Verifier; exactly one of JWKSURL and PublicKeyFile is
+// set
+type Options struct {
+	// Name identifies the verifier in logs
+	Name string
+	// Realm is sent to clients in WWW-Authenticate
+	Realm string
+	// JWKSURL is where the signing keys are published
+	JWKSURL string
+	// JWKSRefresh is how often the keys are fetched again; a token signed
+	// by a key not seen yet has them fetched at once
+	JWKSRefresh time.Duration
+	// PublicKeyFile holds a PEM public key or certificate tokens are
+	// signed for
+	PublicKeyFile string
+	// Issuer and Audience are required of the iss and aud claims
+	Issuer   string
+	Audience string
+	// ClockSkew is how far exp and nbf may be off
+	ClockSkew time.Duration
+	// ForwardClaims maps claims to the request headers they are sent to
+	// the backend in
+	ForwardClaims map[string]string
+	// SkipPreflight lets CORS preflight requests through without a token
+	SkipPreflight bool
+	Metrics       *metrics.Metrics
+}
+
+// Claims are the claims of a verified token
+type Claims map[string]any
+
+// String returns the claim name as text: strings as they are, numbers and
+// booleans formatted, and lists of them joined by commas. It returns ""
+// for a claim that is missing or an object.
+func (c Claims) String(name string) string {
+	switch v := c[name].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case []any:
+		parts := make([]string, 0, len(v))
+		for _, e := range v {
+			if s := (Claims{"": e}).String(""); s != "" {
+				parts = append(parts, s)
+			}
+		}
+		return strings.Join(parts, ",")
+	}
+	return ""
+}
+
+type claimsKey struct{}
+
+// ClaimsFrom returns the claims of the token the middleware of ctx's route
+// verified, if any
+func ClaimsFrom(ctx context.Context) (Claims, bool) {
+	c, ok := ctx.Value(claimsKey{}).(Claims)
+	return c, ok
+}
+
+// Verifier answers requests without a valid bearer token with a 401 JSON
+// error and passes the others on, with their claims in the request context
+// and the selected ones in headers. The subject is logged as the user.
+type Verifier struct {
+	o      Options
+	logger *slog.Logger
+	keys   keySource
+}
+
+// New creates a verifier, reading its public key file if it has one. The
+// keys of a JWKS URL are first fetched for the first token.
+func New(o Options) (*Verifier, error) {
+	v := &Verifier{
+		o:      o,
+		logger: slog.Default().With("component", "jwt", "verifier", o.Name),
+	}
+	if o.PublicKeyFile != "" {
+		data, err := os.ReadFile(o.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parsePEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", o.PublicKeyFile, err)
+		}
+		v.keys = staticKey{key}
+	} else {
+		v.keys = newKeySet(o.JWKSURL, o.JWKSRefresh, v.logger)
+	}
+	return v, nil
+}
+
+// errorBody is the body of a 401
+type errorBody struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// Wrap puts the verifier in front of next
+func (v *Verifier) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Clients must not pass off headers of their own as claims
+		for _, header := range v.o.ForwardClaims {
+			r.Header.Del(header)
+		}
+		if v.o.SkipPreflight && r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, ok := bearerToken(r)
+		if !ok {
+			v.reject(w, "", "a bearer token is required")
+			return
+		}
+		claims, err := v.verify(r.Context(), token)
+		if err != nil {
+			v.logger.Debug("rejected token", "error", err)
+			v.reject(w, "invalid_token", err.Error())
+			return
+		}
+		for claim, header := range v.o.ForwardClaims {
+			if s := claims.String(claim); s != "" && !strings.ContainsAny(s, "\r\n\x00") {
+				r.Header.Set(header, s)
+			}
+		}
+		proxy.SetUser(r, claims.String("sub"))
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims)))
+	})
+}
+
+// reject answers 401; code is empty when no token was sent
+func (v *Verifier) reject(w http.ResponseWriter, code, description string) {
+	v.o.Metrics.Rejected("jwt")
+	challenge := fmt.Sprintf("Bearer realm=%q", v.o.Realm)
+	body := errorBody{Error: "missing_token", Description: description}
+	if code != "" {
+		challenge += fmt.Sprintf(", error=%q, error_description=%q", code, description)
+		body.Error = code
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(body)
+}
+
+// bearerToken returns the token of r's Authorization header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// header is the JOSE header of a token
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verify checks the signature and claims of token and returns its claims
+func (v *Verifier) verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("malformed header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	key, err := v.keys.key(ctx, h.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := key.verify(h.Alg, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed claims: %w", err)
+	}
+	if err := v.checkClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// checkClaims checks the issuer, audience and validity period of claims
+func (v *Verifier) checkClaims(claims Claims, now time.Time) error {
+	if iss, _ := claims["iss"].(string); iss != v.o.Issuer {
+		return errors.New("unexpected issuer")
+	}
+	if !hasAudience(claims["aud"], v.o.Audience) {
+		return errors.New("unexpected audience")
+	}
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return errors.New("token has no expiry")
+	}
+	if now.After(exp.Add(v.o.ClockSkew)) {
+		return errors.New("token has expired")
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(v.o.ClockSkew).Before(nbf) {
+		return errors.New("token is not valid yet")
+	}
+	return nil
+}
+
+// hasAudience reports whether aud, a string or a list of them, includes want
+func hasAudience(aud any, want string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == want
+	case []any:
+		for _, a := range aud {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// numericDate converts a claim holding seconds since the epoch
+func numericDate(claim any) (time.Time, bool) {
+	n, ok := claim.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	secs, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	if math.IsNaN(secs) || math.Abs(secs) > math.MaxInt64/2 {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(secs), 0), true
+}
+
+// decodeSegment decodes a base64url JSON segment of a token into v
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
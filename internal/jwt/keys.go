@@ -0,0 +1,344 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// jwksTimeout bounds a fetch of the key set
+	jwksTimeout = 10 * time.Second
+	// jwksMinRefetch spaces out the fetches for unknown key IDs, so tokens
+	// made up by a client cannot have the key set fetched on every request
+	jwksMinRefetch = 30 * time.Second
+	// maxJWKSBytes caps the size of a key set
+	maxJWKSBytes = 1 << 20
+)
+
+// publicKey is a key tokens are verified with; alg, if set, is the only
+// algorithm it may be used with
+type publicKey struct {
+	key crypto.PublicKey
+	alg string
+}
+
+// keySource finds the key of a token by its key ID, which may be empty
+type keySource interface {
+	key(ctx context.Context, kid string) (publicKey, error)
+}
+
+// staticKey is a key read from a file, used whatever the key ID
+type staticKey struct {
+	publicKey
+}
+
+func (k staticKey) key(context.Context, string) (publicKey, error) {
+	return k.publicKey, nil
+}
+
+// parsePEM parses a PEM public key or certificate
+func parsePEM(data []byte) (publicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return publicKey{}, errors.New("no PEM block found")
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return publicKey{}, err
+		}
+		return publicKey{key: key}, nil
+	case "RSA PUBLIC KEY":
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return publicKey{}, err
+		}
+		return publicKey{key: key}, nil
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return publicKey{}, err
+		}
+		return publicKey{key: cert.PublicKey}, nil
+	}
+	return publicKey{}, fmt.Errorf("unexpected PEM block %q", block.Type)
+}
+
+// keySet holds the keys published at a JWKS URL. They are fetched again in
+// the background once older than the refresh interval, and at once for a
+// key ID not among them, so rotated keys are picked up as soon as tokens
+// signed with them arrive.
+type keySet struct {
+	url     string
+	refresh time.Duration
+	http    *http.Client
+	logger  *slog.Logger
+
+	// fetchMu is held through a fetch, so only one runs at a time
+	fetchMu sync.Mutex
+	mu      sync.RWMutex
+	keys    map[string]publicKey // by key ID
+	fetched time.Time            // when the keys were last fetched
+	tried   time.Time            // when they were last fetched or failed to be
+}
+
+func newKeySet(url string, refresh time.Duration, logger *slog.Logger) *keySet {
+	return &keySet{
+		url:     url,
+		refresh: refresh,
+		http:    &http.Client{Timeout: jwksTimeout},
+		logger:  logger,
+	}
+}
+
+func (ks *keySet) key(ctx context.Context, kid string) (publicKey, error) {
+	k, ok, stale := ks.lookup(kid)
+	if ok {
+		if stale && ks.fetchMu.TryLock() {
+			go func() {
+				defer ks.fetchMu.Unlock()
+				ks.fetch(context.Background())
+			}()
+		}
+		return k, nil
+	}
+
+	ks.fetchMu.Lock()
+	// Another request may have fetched the keys while this one waited
+	ks.mu.RLock()
+	recent := time.Since(ks.tried) < jwksMinRefetch
+	ks.mu.RUnlock()
+	if !recent {
+		ks.fetch(ctx)
+	}
+	ks.fetchMu.Unlock()
+
+	if k, ok, _ = ks.lookup(kid); ok {
+		return k, nil
+	}
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if ks.keys == nil {
+		return publicKey{}, errors.New("signing keys are unavailable")
+	}
+	return publicKey{}, errors.New("unknown signing key")
+}
+
+// lookup returns the key with ID kid, or the only key if kid is empty, and
+// whether the keys are due to be fetched again
+func (ks *keySet) lookup(kid string) (publicKey, bool, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	stale := time.Since(ks.fetched) >= ks.refresh
+	if kid == "" && len(ks.keys) == 1 {
+		for _, k := range ks.keys {
+			return k, true, stale
+		}
+	}
+	k, ok := ks.keys[kid]
+	return k, ok, stale
+}
+
+// fetch fetches the keys, keeping those fetched before if it fails; the
+// caller holds fetchMu
+func (ks *keySet) fetch(ctx context.Context) {
+	keys, err := ks.get(ctx)
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.tried = time.Now()
+	if err != nil {
+		ks.logger.Warn("fetching JWKS failed, keeping current keys", "url", ks.url, "error", err)
+		return
+	}
+	ks.keys, ks.fetched = keys, ks.tried
+	ks.logger.Debug("fetched JWKS", "url", ks.url, "keys", len(keys))
+}
+
+// jwk is a key of a JWKS document
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// get fetches and parses the keys, skipping those not for signatures or of
+// an unsupported type
+func (ks *keySet) get(ctx context.Context) (map[string]publicKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, jwksTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := ks.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", ks.url, resp.Status)
+	}
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSBytes)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decoding JWKS: %w", err)
+	}
+	keys := make(map[string]publicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			ks.logger.Warn("skipping JWKS key", "kid", k.Kid, "error", err)
+			continue
+		}
+		keys[k.Kid] = publicKey{key: key, alg: k.Alg}
+	}
+	return keys, nil
+}
+
+// publicKey converts the key
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	field := func(s string) ([]byte, error) {
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+		if err == nil && len(b) == 0 {
+			err = errors.New("missing parameter")
+		}
+		return b, err
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := field(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("n: %w", err)
+		}
+		e, err := field(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("e: %w", err)
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := field(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("x: %w", err)
+		}
+		y, err := field(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("y: %w", err)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, errors.New("invalid point")
+		}
+		return ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := field(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid x")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// hashes are the hashes of the RSA and ECDSA algorithms by the suffix of
+// their names
+var hashes = map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+
+// verify checks sig, made with alg over signed, against the key. Only
+// asymmetric algorithms are accepted, and only those matching the type of
+// the key, so a token cannot choose how it is checked.
+func (k publicKey) verify(alg string, signed, sig []byte) error {
+	if k.alg != "" && k.alg != alg {
+		return fmt.Errorf("algorithm %s does not match the key", alg)
+	}
+	if alg == "EdDSA" {
+		pub, ok := k.key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(pub, signed, sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	hash, ok := hashes[alg[2:]]
+	if !ok {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	var valid bool
+	switch alg[:2] {
+	case "RS", "PS":
+		pub, ok := k.key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s does not match the key", alg)
+		}
+		if alg[0] == 'R' {
+			valid = rsa.VerifyPKCS1v15(pub, hash, digest, sig) == nil
+		} else {
+			valid = rsa.VerifyPSS(pub, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+	case "ES":
+		pub, ok := k.key.(*ecdsa.PublicKey)
+		// Each size of ES goes with one curve: ES256 with P-256 and so on
+		curves := map[string]elliptic.Curve{"256": elliptic.P256(), "384": elliptic.P384(), "512": elliptic.P521()}
+		if !ok || pub.Curve != curves[alg[2:]] {
+			return fmt.Errorf("algorithm %s does not match the key", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("invalid signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		valid = ecdsa.Verify(pub, digest, r, s)
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	if !valid {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package logdedup
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Deduper collapses repeats of a log line. The first occurrence of a key is
+// logged at once; further occurrences within the window are only counted,
+// and reported in a single line with a "suppressed" count when the window
+// closes. A nil Deduper logs every line.
+type Deduper struct {
+	window time.Duration
+
+	mu   sync.Mutex
+	seen map[string]*repeat
+}
+
+// repeat tracks the suppressed occurrences of one key in its window
+type repeat struct {
+	suppressed int
+	logger     *slog.Logger
+	level      slog.Level
+	msg        string
+	args       []any
+}
+
+// New creates a deduper suppressing repeats within window
+func New(window time.Duration) *Deduper {
+	return &Deduper{
+		window: window,
+		seen:   make(map[string]*repeat),
+	}
+}
+
+// Log logs msg through l unless a line with the same key was logged within
+// the window. Callers should count the event themselves: a suppressed line
+// records nothing but its count.
+func (d *Deduper) Log(l *slog.Logger, level slog.Level, key, msg string, args ...any) {
+	ctx := context.Background()
+	if !l.Enabled(ctx, level) {
+		return
+	}
+	if d == nil || d.window <= 0 {
+		l.Log(ctx, level, msg, args...)
+		return
+	}
+
+	d.mu.Lock()
+	if r, ok := d.seen[key]; ok {
+		r.suppressed++
+		r.logger, r.level, r.msg, r.args = l, level, msg, args
+		d.mu.Unlock()
+		return
+	}
+	d.seen[key] = &repeat{}
+	d.mu.Unlock()
+
+	l.Log(ctx, level, msg, args...)
+	time.AfterFunc(d.window, func() { d.flush(key) })
+}
+
+// flush closes the window of key, reporting the latest suppressed line
+func (d *Deduper) flush(key string) {
+	d.mu.Lock()
+	r := d.seen[key]
+	delete(d.seen, key)
+	d.mu.Unlock()
+
+	if r == nil || r.suppressed == 0 {
+		return
+	}
+	args := append(r.args[:len(r.args):len(r.args)], "suppressed", r.suppressed, "window", d.window)
+	r.logger.Log(context.Background(), r.level, r.msg, args...)
+}
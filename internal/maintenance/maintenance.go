@@ -0,0 +1,141 @@
+// Package maintenance answers the requests of pools taken down for planned
+// work with a 503 page, without touching the health of their backends, so
+// a pool can be switched out and back in at once through the admin API
+package maintenance
+
+import (
+	"cmp"
+	"net/http"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/errorpage"
+	"github.com/nexus-lb/nexus/internal/metrics"
+	"github.com/nexus-lb/nexus/internal/split"
+)
+
+// Options configure a Mode
+type Options struct {
+	// Allow lists the networks whose clients pass through to pools in
+	// maintenance, so operators can test them while users see the page
+	Allow []netip.Prefix
+	// Trusted resolves the client IP behind trusted proxies, like the
+	// access log does
+	Trusted backend.TrustedProxies
+	// Pages renders the 503; without a 503 page a plain one is written
+	Pages   *errorpage.Pages
+	Metrics *metrics.Metrics
+}
+
+// Mode tracks which pools are in maintenance. The state lives only in
+// memory: it outlasts configuration reloads but not a restart. A nil *Mode
+// has no pool in maintenance.
+type Mode struct {
+	o Options
+
+	mu    sync.RWMutex
+	since map[string]time.Time // by pool, when it entered maintenance
+}
+
+// PoolStatus describes one pool in maintenance
+type PoolStatus struct {
+	Pool  string    `json:"pool"`
+	Since time.Time `json:"since"`
+}
+
+// Status is a snapshot of the pools in maintenance
+type Status struct {
+	Pools []PoolStatus `json:"pools"`
+	Allow []string     `json:"allow,omitempty"`
+}
+
+// New creates a mode with no pool in maintenance
+func New(o Options) *Mode {
+	return &Mode{o: o, since: make(map[string]time.Time)}
+}
+
+// Set puts pool in maintenance or takes it out, and reports whether that
+// changed anything. The default pool may be named "" or "default".
+func (m *Mode) Set(pool string, on bool) bool {
+	pool = poolName(pool)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, active := m.since[pool]; active == on {
+		return false
+	}
+	if on {
+		m.since[pool] = time.Now()
+	} else {
+		delete(m.since, pool)
+	}
+	return true
+}
+
+// Active reports whether pool is in maintenance
+func (m *Mode) Active(pool string) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, active := m.since[poolName(pool)]
+	return active
+}
+
+// Status returns the pools in maintenance, sorted by name
+func (m *Mode) Status() Status {
+	st := Status{Pools: []PoolStatus{}}
+	for _, p := range m.o.Allow {
+		st.Allow = append(st.Allow, p.String())
+	}
+	m.mu.RLock()
+	for pool, since := range m.since {
+		st.Pools = append(st.Pools, PoolStatus{Pool: pool, Since: since})
+	}
+	m.mu.RUnlock()
+	slices.SortFunc(st.Pools, func(a, b PoolStatus) int { return cmp.Compare(a.Pool, b.Pool) })
+	return st
+}
+
+// Serve answers r with the maintenance page and returns true if pool is in
+// maintenance and the client is not allowed through; otherwise it returns
+// false without writing anything
+func (m *Mode) Serve(w http.ResponseWriter, r *http.Request, pool string) bool {
+	if !m.Active(pool) || m.allowed(m.o.Trusted.ClientIP(r)) {
+		return false
+	}
+	m.o.Metrics.Rejected("maintenance")
+	if !m.o.Pages.Render(w, r, http.StatusServiceUnavailable) {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	}
+	return true
+}
+
+// allowed reports whether ip lies in one of the allowed networks
+func (m *Mode) allowed(ip string) bool {
+	if len(m.o.Allow) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range m.o.Allow {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// poolName returns the name pool is reported under
+func poolName(pool string) string {
+	if pool == "" {
+		return split.DefaultPool
+	}
+	return pool
+}
@@ -0,0 +1,68 @@
+package maintenance
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func serve(m *Mode, pool, remote, xff string) (bool, int) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = remote
+	if xff != "" {
+		r.Header.Set("X-Forwarded-For", xff)
+	}
+	w := httptest.NewRecorder()
+	served := m.Serve(w, r, pool)
+	return served, w.Code
+}
+
+func TestSet(t *testing.T) {
+	m := New(Options{})
+	if !m.Set("", true) || m.Set("default", true) {
+		t.Fatal("the default pool is not the same under both names")
+	}
+	if !m.Active("default") || m.Active("canary") {
+		t.Fatal("wrong pool in maintenance")
+	}
+	if st := m.Status(); len(st.Pools) != 1 || st.Pools[0].Pool != "default" {
+		t.Errorf("Status = %+v", st)
+	}
+	if !m.Set("default", false) || m.Active("") {
+		t.Fatal("pool still in maintenance")
+	}
+}
+
+func TestNilMode(t *testing.T) {
+	var m *Mode
+	if m.Active("default") {
+		t.Fatal("a nil mode has a pool in maintenance")
+	}
+}
+
+func TestServe(t *testing.T) {
+	m := New(Options{Allow: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}})
+	if served, _ := serve(m, "default", "192.0.2.1:4000", ""); served {
+		t.Fatal("served the page for a pool not in maintenance")
+	}
+	m.Set("default", true)
+	if served, code := serve(m, "default", "192.0.2.1:4000", ""); !served || code != http.StatusServiceUnavailable {
+		t.Fatalf("Serve = %v, %d; want true, 503", served, code)
+	}
+	if served, _ := serve(m, "default", "10.0.0.1:4000", ""); served {
+		t.Fatal("allowed client got the page")
+	}
+}
+
+func TestForgedForwardedFor(t *testing.T) {
+	// trusted_proxies unset: only loopback peers are believed
+	m := New(Options{Allow: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}})
+	m.Set("default", true)
+	if served, code := serve(m, "default", "203.0.113.9:4000", "10.0.0.1"); !served || code != http.StatusServiceUnavailable {
+		t.Errorf("forged allowed address: Serve = %v, %d; want true, 503", served, code)
+	}
+	if served, _ := serve(m, "default", "127.0.0.1:4000", "10.0.0.1"); served {
+		t.Error("allowed client behind a loopback proxy got the page")
+	}
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// ConnCounters tracks the client connections of an http.Server through its
+// ConnState hook
+type ConnCounters struct {
+	states   sync.Map // net.Conn -> http.ConnState
+	active   atomic.Int64
+	idle     atomic.Int64
+	pending  atomic.Int64 // accepted, no request read yet
+	accepted atomic.Uint64
+}
+
+// ConnState is an http.Server ConnState hook
+func (c *ConnCounters) ConnState(conn net.Conn, state http.ConnState) {
+	if state == http.StateNew {
+		c.accepted.Add(1)
+	}
+	if previous, ok := c.states.Load(conn); ok {
+		c.gauge(previous.(http.ConnState)).Add(-1)
+	}
+	switch state {
+	case http.StateHijacked, http.StateClosed:
+		c.states.Delete(conn)
+	default:
+		c.states.Store(conn, state)
+		c.gauge(state).Add(1)
+	}
+}
+
+// gauge returns the counter of connections in the given live state
+func (c *ConnCounters) gauge(state http.ConnState) *atomic.Int64 {
+	switch state {
+	case http.StateActive:
+		return &c.active
+	case http.StateIdle:
+		return &c.idle
+	}
+	return &c.pending
+}
+
+// ConnSnapshot counts client connections by state
+type ConnSnapshot struct {
+	Open     int64  `json:"open"`
+	Active   int64  `json:"active"`
+	Idle     int64  `json:"idle"`
+	New      int64  `json:"new"`
+	Accepted uint64 `json:"accepted"`
+}
+
+// Snapshot returns the current connection counts
+func (c *ConnCounters) Snapshot() ConnSnapshot {
+	s := ConnSnapshot{
+		Active:   c.active.Load(),
+		Idle:     c.idle.Load(),
+		New:      c.pending.Load(),
+		Accepted: c.accepted.Load(),
+	}
+	s.Open = s.Active + s.Idle + s.New
+	return s
+}
@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"bufio"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are the Prometheus client default latency buckets in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// HistogramVec is a histogram partitioned by label values
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	series  sync.Map // label key -> *histogramSeries
+}
+
+// histogramSeries is one labeled histogram. Bucket counts are stored
+// non-cumulatively and summed when written.
+type histogramSeries struct {
+	values []string
+	counts []atomic.Uint64 // one per bucket plus +Inf
+	count  atomic.Uint64
+	sum    atomic.Uint64 // float64 bits
+}
+
+// NewHistogramVec registers a histogram with the given upper bounds, which
+// must be sorted in increasing order
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: slices.Clone(buckets)}
+	r.register(h)
+	return h
+}
+
+// Observe records a value for the given label values
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	key := seriesKey(values)
+	s, ok := h.series.Load(key)
+	if !ok {
+		s, _ = h.series.LoadOrStore(key, &histogramSeries{
+			values: slices.Clone(values),
+			counts: make([]atomic.Uint64, len(h.buckets)+1),
+		})
+	}
+	hs := s.(*histogramSeries)
+
+	hs.counts[sort.SearchFloat64s(h.buckets, v)].Add(1)
+	hs.count.Add(1)
+	for {
+		old := hs.sum.Load()
+		if hs.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			break
+		}
+	}
+}
+
+// Sum returns the sum of all observed values across every series
+func (h *HistogramVec) Sum() float64 {
+	var total float64
+	h.series.Range(func(_, v any) bool {
+		total += math.Float64frombits(v.(*histogramSeries).sum.Load())
+		return true
+	})
+	return total
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	for _, s := range sortedSeries[*histogramSeries](&h.series) {
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i].Load()
+			writeSample(w, h.name+"_bucket", h.labels, s.values, "le", formatFloat(upper), float64(cumulative))
+		}
+		cumulative += s.counts[len(h.buckets)].Load()
+		writeSample(w, h.name+"_bucket", h.labels, s.values, "le", "+Inf", float64(cumulative))
+		writeSample(w, h.name+"_sum", h.labels, s.values, "", "", math.Float64frombits(s.sum.Load()))
+		writeSample(w, h.name+"_count", h.labels, s.values, "", "", float64(s.count.Load()))
+	}
+}
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Latency buckets are log-spaced with latencySubBuckets per doubling,
+// starting at one microsecond, for a relative error of about 19%. The last
+// bucket holds everything beyond latencyDoublings doublings (~18 minutes).
+const (
+	latencySubBuckets = 4
+	latencyDoublings  = 30
+	latencyBuckets    = latencySubBuckets*latencyDoublings + 1
+)
+
+// LatencyHistogram records durations into fixed log-spaced buckets using
+// only atomic counters, and estimates percentiles from them. The zero value
+// is ready to use.
+type LatencyHistogram struct {
+	counts [latencyBuckets]atomic.Uint64
+	count  atomic.Uint64
+	sum    atomic.Int64 // nanoseconds
+	max    atomic.Int64 // nanoseconds, caps the interpolated percentiles
+}
+
+// Observe records one duration
+func (h *LatencyHistogram) Observe(d time.Duration) {
+	h.counts[latencyBucket(d)].Add(1)
+	h.count.Add(1)
+	h.sum.Add(int64(d))
+	for {
+		old := h.max.Load()
+		if int64(d) <= old || h.max.CompareAndSwap(old, int64(d)) {
+			break
+		}
+	}
+}
+
+// latencyBucket returns the bucket index of a duration
+func latencyBucket(d time.Duration) int {
+	us := float64(d) / float64(time.Microsecond)
+	if us <= 1 {
+		return 0
+	}
+	i := int(math.Ceil(math.Log2(us) * latencySubBuckets))
+	return min(i, latencyBuckets-1)
+}
+
+// latencyUpper returns the upper bound of bucket i
+func latencyUpper(i int) time.Duration {
+	return time.Duration(math.Exp2(float64(i)/latencySubBuckets) * float64(time.Microsecond))
+}
+
+// LatencySnapshot summarizes a latency histogram
+type LatencySnapshot struct {
+	Count uint64
+	Mean  time.Duration
+	P50   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+}
+
+// Snapshot estimates the mean and the 50th, 95th and 99th percentiles
+func (h *LatencyHistogram) Snapshot() LatencySnapshot {
+	var counts [latencyBuckets]uint64
+	var total uint64
+	for i := range counts {
+		counts[i] = h.counts[i].Load()
+		total += counts[i]
+	}
+	if total == 0 {
+		return LatencySnapshot{}
+	}
+
+	highest := time.Duration(h.max.Load())
+	return LatencySnapshot{
+		Count: total,
+		Mean:  time.Duration(h.sum.Load() / int64(max(h.count.Load(), 1))),
+		P50:   min(quantile(&counts, total, 0.50), highest),
+		P95:   min(quantile(&counts, total, 0.95), highest),
+		P99:   min(quantile(&counts, total, 0.99), highest),
+	}
+}
+
+// quantile interpolates the q-th quantile within the bucket it falls in
+func quantile(counts *[latencyBuckets]uint64, total uint64, q float64) time.Duration {
+	rank := q * float64(total)
+	var cumulative uint64
+	for i, c := range counts {
+		if c == 0 {
+			continue
+		}
+		if float64(cumulative+c) >= rank {
+			lower := time.Duration(0)
+			if i > 0 {
+				lower = latencyUpper(i - 1)
+			}
+			upper := latencyUpper(i)
+			frac := (rank - float64(cumulative)) / float64(c)
+			return lower + time.Duration(frac*float64(upper-lower))
+		}
+		cumulative += c
+	}
+	return latencyUpper(latencyBuckets - 1)
+}
@@ -0,0 +1,268 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+)
+
+// noBackend labels requests that never reached a backend
+const noBackend = "none"
+
+// Exporter receives request outcomes as they are recorded, for push-based
+// metric systems
+type Exporter interface {
+	RequestFinished(backend string, status, retries int, d time.Duration)
+	BackendServed(backend string, d time.Duration)
+}
+
+// Metrics holds the Nexus metrics recorded by the proxy handler and the
+// health checker. A nil *Metrics records nothing.
+type Metrics struct {
+	Registry *Registry
+
+	requests         *CounterVec
+	requestDuration  *HistogramVec
+	retries          *HistogramVec
+	inFlight         *Gauge
+	healthChecks     *CounterVec
+	healthCheckTimes *HistogramVec
+	mirrored         *CounterVec
+	mirrorDropped    *CounterVec
+	hedges           *CounterVec
+	rejected         *CounterVec
+	queueWait        *HistogramVec
+	poolRequests     *CounterVec
+	routeRequests    *CounterVec
+	fallbacks        *CounterVec
+	panics           *CounterVec
+
+	// recent keeps the last few minutes of request durations
+	recent WindowedLatency
+
+	exporter Exporter
+}
+
+// New registers the Nexus metrics in a fresh registry. durationBuckets are
+// the upper bounds, in seconds, of the request duration histogram.
+func New(durationBuckets []float64) *Metrics {
+	r := NewRegistry()
+	return &Metrics{
+		Registry: r,
+		requests: r.NewCounterVec("nexus_requests_total",
+			"Requests handled, by backend and status class.", "backend", "code"),
+		requestDuration: r.NewHistogramVec("nexus_request_duration_seconds",
+			"Time to serve a request, by backend and status class.", durationBuckets, "backend", "code"),
+		retries: r.NewHistogramVec("nexus_request_retries",
+			"Backend selection retries needed per request.", []float64{0, 1, 2, 3, 5, 10}),
+		inFlight: r.NewGauge("nexus_requests_in_flight",
+			"Requests currently being served."),
+		healthChecks: r.NewCounterVec("nexus_health_checks_total",
+			"Active health checks, by backend and result.", "backend", "result"),
+		healthCheckTimes: r.NewHistogramVec("nexus_health_check_duration_seconds",
+			"Time taken by active health checks.", DefaultBuckets, "backend"),
+		mirrored: r.NewCounterVec("nexus_mirror_requests_total",
+			"Mirrored requests sent, by shadow target and status class.", "target", "code"),
+		mirrorDropped: r.NewCounterVec("nexus_mirror_dropped_total",
+			"Requests that were to be mirrored but were not, by shadow target and reason.", "target", "reason"),
+		hedges: r.NewCounterVec("nexus_hedges_total",
+			"Hedged attempts sent, and those of them that answered first.", "result"),
+		rejected: r.NewCounterVec("nexus_rejected_requests_total",
+			"Requests turned away before reaching a backend, by reason.", "reason"),
+		queueWait: r.NewHistogramVec("nexus_queue_wait_seconds",
+			"Time requests waited in the queue for a backend, by how the wait ended.",
+			[]float64{.001, .005, .01, .025, .05, .1, .25, .5, 1}, "result"),
+		poolRequests: r.NewCounterVec("nexus_pool_requests_total",
+			"Requests routed or split to each pool of backends, by status class.", "pool", "code"),
+		routeRequests: r.NewCounterVec("nexus_route_requests_total",
+			"Requests that took each configured route, by status class.", "route", "code"),
+		fallbacks: r.NewCounterVec("nexus_route_fallback_requests_total",
+			"Requests served by the fallback pool of their route, by status class.", "route", "pool", "code"),
+		panics: r.NewCounterVec("nexus_panics_total",
+			"Requests whose handling panicked, by the backend they were being proxied to.", "backend"),
+	}
+}
+
+// SetExporter forwards request outcomes to e as well. It must be called
+// before traffic is served.
+func (m *Metrics) SetExporter(e Exporter) {
+	m.exporter = e
+}
+
+// RequestStarted marks a request as in flight
+func (m *Metrics) RequestStarted() {
+	if m == nil {
+		return
+	}
+	m.inFlight.Add(1)
+}
+
+// RequestFinished records a completed request. backend is empty when no
+// backend could serve it; retries counts the extra selection attempts.
+func (m *Metrics) RequestFinished(backend string, status, retries int, d time.Duration) {
+	if m == nil {
+		return
+	}
+	if backend == "" {
+		backend = noBackend
+	}
+	class := StatusClass(status)
+	m.inFlight.Add(-1)
+	m.requests.Inc(backend, class)
+	m.requestDuration.Observe(d.Seconds(), backend, class)
+	m.retries.Observe(float64(retries))
+	m.recent.Observe(d)
+	if m.exporter != nil {
+		m.exporter.RequestFinished(backend, status, retries, d)
+	}
+}
+
+// BackendServed records how long a backend took to serve one attempt
+func (m *Metrics) BackendServed(backend string, d time.Duration) {
+	if m == nil || m.exporter == nil {
+		return
+	}
+	m.exporter.BackendServed(backend, d)
+}
+
+// HealthChecked records the result and duration of an active health check
+func (m *Metrics) HealthChecked(backend string, alive bool, d time.Duration) {
+	if m == nil {
+		return
+	}
+	result := "down"
+	if alive {
+		result = "up"
+	}
+	m.healthChecks.Inc(backend, result)
+	m.healthCheckTimes.Observe(d.Seconds(), backend)
+}
+
+// MirrorFinished records how a shadow target answered a mirrored request;
+// a status of zero means the request failed without an answer
+func (m *Metrics) MirrorFinished(target string, status int) {
+	if m == nil {
+		return
+	}
+	class := "error"
+	if status != 0 {
+		class = StatusClass(status)
+	}
+	m.mirrored.Inc(target, class)
+}
+
+// MirrorDropped records a request that was sampled for mirroring but not sent
+func (m *Metrics) MirrorDropped(target, reason string) {
+	if m == nil {
+		return
+	}
+	m.mirrorDropped.Inc(target, reason)
+}
+
+// Hedged records a hedged attempt being sent
+func (m *Metrics) Hedged() {
+	if m == nil {
+		return
+	}
+	m.hedges.Inc("sent")
+}
+
+// HedgeWon records a hedged attempt answering before the attempt it hedged
+func (m *Metrics) HedgeWon() {
+	if m == nil {
+		return
+	}
+	m.hedges.Inc("won")
+}
+
+// Panicked records a panic while serving a request; backend is empty when
+// none had been chosen
+func (m *Metrics) Panicked(backend string) {
+	if m == nil {
+		return
+	}
+	if backend == "" {
+		backend = noBackend
+	}
+	m.panics.Inc(backend)
+}
+
+// Rejected records a request shed by a limit, such as "concurrency"
+func (m *Metrics) Rejected(reason string) {
+	if m == nil {
+		return
+	}
+	m.rejected.Inc(reason)
+}
+
+// Queued records how a request's wait in the queue ended: "ready",
+// "timeout" or "canceled" after waiting d, or "full" if it was turned away
+func (m *Metrics) Queued(result string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	if result == "full" {
+		m.rejected.Inc("queue_full")
+		return
+	}
+	m.queueWait.Observe(d.Seconds(), result)
+}
+
+// PoolRequestFinished records a request served within a pool, "" being the
+// default pool
+func (m *Metrics) PoolRequestFinished(pool string, status int) {
+	if m == nil {
+		return
+	}
+	if pool == "" {
+		pool = "default"
+	}
+	m.poolRequests.Inc(pool, StatusClass(status))
+}
+
+// RecentLatency estimates request duration percentiles over the last window
+func (m *Metrics) RecentLatency(window time.Duration) WindowSnapshot {
+	if m == nil {
+		return WindowSnapshot{}
+	}
+	return m.recent.Snapshot(window)
+}
+
+// Requests returns the number of requests handled
+func (m *Metrics) Requests() uint64 {
+	return m.requests.Sum(nil)
+}
+
+// Errors returns the number of requests answered with a 5xx status
+func (m *Metrics) Errors() uint64 {
+	return m.requests.Sum(func(values []string) bool { return values[1] == "5xx" })
+}
+
+// Retries returns the total number of backend selection retries
+func (m *Metrics) Retries() uint64 {
+	return uint64(m.retries.Sum())
+}
+
+// StatusClass returns the class of an HTTP status code, such as "2xx"
+func StatusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "other"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// RouteRequestFinished records a request that took a route
+func (m *Metrics) RouteRequestFinished(route string, status int) {
+	if m == nil {
+		return
+	}
+	m.routeRequests.Inc(route, StatusClass(status))
+}
+
+// FallbackRequestFinished records a request that fell back from the pool
+// of its route to pool
+func (m *Metrics) FallbackRequestFinished(route, pool string, status int) {
+	if m == nil {
+		return
+	}
+	m.fallbacks.Inc(route, pool, StatusClass(status))
+}
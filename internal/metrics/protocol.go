@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// ProtocolCounters tracks how many requests arrived over each HTTP protocol version
+type ProtocolCounters struct {
+	http10 atomic.Uint64
+	http11 atomic.Uint64
+	http2  atomic.Uint64
+	http3  atomic.Uint64
+	other  atomic.Uint64
+}
+
+// Observe records the protocol of an incoming request
+func (c *ProtocolCounters) Observe(r *http.Request) {
+	switch {
+	case r.ProtoMajor == 3:
+		c.http3.Add(1)
+	case r.ProtoMajor == 2:
+		c.http2.Add(1)
+	case r.ProtoMajor == 1 && r.ProtoMinor == 1:
+		c.http11.Add(1)
+	case r.ProtoMajor == 1 && r.ProtoMinor == 0:
+		c.http10.Add(1)
+	default:
+		c.other.Add(1)
+	}
+}
+
+// Snapshot returns the current request count per protocol name
+func (c *ProtocolCounters) Snapshot() map[string]uint64 {
+	return map[string]uint64{
+		"HTTP/1.0": c.http10.Load(),
+		"HTTP/1.1": c.http11.Load(),
+		"HTTP/2.0": c.http2.Load(),
+		"HTTP/3.0": c.http3.Load(),
+		"other":    c.other.Load(),
+	}
+}
@@ -0,0 +1,262 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// family is a named group of metrics in the Prometheus text format
+type family interface {
+	write(w *bufio.Writer)
+}
+
+// Registry collects metric families and renders them in the Prometheus text
+// exposition format. Registration takes a lock; recording values never does.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// register adds a family to the registry
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, f)
+}
+
+// WriteTo writes every registered family in registration order
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// CounterVec is a counter partitioned by label values
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	series sync.Map // label key -> *counterSeries
+}
+
+// counterSeries is one labeled counter
+type counterSeries struct {
+	values []string
+	value  atomic.Uint64
+}
+
+// NewCounterVec registers a counter with the given label names
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels}
+	r.register(c)
+	return c
+}
+
+// Add increments the counter for the given label values by n
+func (c *CounterVec) Add(n uint64, values ...string) {
+	key := seriesKey(values)
+	s, ok := c.series.Load(key)
+	if !ok {
+		s, _ = c.series.LoadOrStore(key, &counterSeries{values: slices.Clone(values)})
+	}
+	s.(*counterSeries).value.Add(n)
+}
+
+// Inc increments the counter for the given label values by one
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Sum adds up the series whose label values satisfy match, or every series
+// if match is nil
+func (c *CounterVec) Sum(match func(values []string) bool) uint64 {
+	var total uint64
+	c.series.Range(func(_, v any) bool {
+		s := v.(*counterSeries)
+		if match == nil || match(s.values) {
+			total += s.value.Load()
+		}
+		return true
+	})
+	return total
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	for _, s := range sortedSeries[*counterSeries](&c.series) {
+		writeSample(w, c.name, c.labels, s.values, "", "", float64(s.value.Load()))
+	}
+}
+
+// Gauge is a single gauge value
+type Gauge struct {
+	name  string
+	help  string
+	value atomic.Int64
+}
+
+// NewGauge registers an unlabeled gauge
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	r.register(g)
+	return g
+}
+
+// Add adds delta (which may be negative) to the gauge
+func (g *Gauge) Add(delta int64) {
+	g.value.Add(delta)
+}
+
+// Value returns the current value of the gauge
+func (g *Gauge) Value() int64 {
+	return g.value.Load()
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	writeSample(w, g.name, nil, nil, "", "", float64(g.value.Load()))
+}
+
+// Sample is one labeled value reported by a collect function
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// funcFamily reports values computed at scrape time
+type funcFamily struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	collect func() []Sample
+}
+
+// NewGaugeFunc registers a gauge whose samples are computed by collect on
+// every scrape. Each sample carries one value per label name.
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, collect func() []Sample) {
+	r.register(&funcFamily{name: name, help: help, kind: "gauge", labels: labels, collect: collect})
+}
+
+// NewCounterFunc registers a counter whose samples are read from counters
+// kept elsewhere, so they are exported without being counted twice
+func (r *Registry) NewCounterFunc(name, help string, labels []string, collect func() []Sample) {
+	r.register(&funcFamily{name: name, help: help, kind: "counter", labels: labels, collect: collect})
+}
+
+func (f *funcFamily) write(w *bufio.Writer) {
+	writeHeader(w, f.name, f.help, f.kind)
+	for _, s := range f.collect() {
+		writeSample(w, f.name, f.labels, s.Labels, "", "", s.Value)
+	}
+}
+
+// seriesKey joins label values into a map key
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// sortedSeries returns the series of a vector ordered by label values so
+// scrapes are stable
+func sortedSeries[T any](m *sync.Map) []T {
+	type entry struct {
+		key string
+		s   T
+	}
+	var entries []entry
+	m.Range(func(k, v any) bool {
+		entries = append(entries, entry{k.(string), v.(T)})
+		return true
+	})
+	slices.SortFunc(entries, func(a, b entry) int { return strings.Compare(a.key, b.key) })
+
+	out := make([]T, len(entries))
+	for i, e := range entries {
+		out[i] = e.s
+	}
+	return out
+}
+
+// writeHeader writes the HELP and TYPE lines of a family
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+// writeSample writes one sample line. extraName/extraValue add a trailing
+// label such as a histogram's "le".
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraName, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, l, values[i])
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+// writeLabel writes name="value" with the value escaped
+func writeLabel(w *bufio.Writer, name, value string) {
+	w.WriteString(name)
+	w.WriteString(`="`)
+	w.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value))
+	w.WriteByte('"')
+}
+
+// formatFloat renders a sample value the way Prometheus expects
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Windowed latency is kept in slots of windowSlot, enough of them to cover
+// the longest window that can be asked for
+const (
+	windowSlot  = 10 * time.Second
+	windowSlots = 30
+	// MaxWindow is the longest window WindowedLatency can report on
+	MaxWindow = windowSlot * windowSlots
+)
+
+// WindowedLatency records durations like LatencyHistogram, but only keeps
+// the last MaxWindow of them so percentiles reflect recent behavior. Slots
+// are reused in place as time moves on, so recording stays lock-free and an
+// idle series simply ages out. The zero value is ready to use.
+type WindowedLatency struct {
+	slots [windowSlots]latencySlot
+}
+
+// latencySlot holds the observations of one windowSlot-long period
+type latencySlot struct {
+	epoch  atomic.Int64 // index of the period the slot currently holds
+	counts [latencyBuckets]atomic.Uint64
+	max    atomic.Int64 // nanoseconds
+}
+
+// Observe records one duration at the current time
+func (w *WindowedLatency) Observe(d time.Duration) {
+	w.observeAt(d, time.Now())
+}
+
+// observeAt records one duration at time t
+func (w *WindowedLatency) observeAt(d time.Duration, t time.Time) {
+	epoch := t.UnixNano() / int64(windowSlot)
+	s := &w.slots[epoch%windowSlots]
+
+	// The first observer of a new period clears what the slot held before;
+	// an observation racing the reset may be lost, which is harmless here
+	if old := s.epoch.Load(); old != epoch && s.epoch.CompareAndSwap(old, epoch) {
+		for i := range s.counts {
+			s.counts[i].Store(0)
+		}
+		s.max.Store(0)
+	}
+
+	s.counts[latencyBucket(d)].Add(1)
+	for {
+		old := s.max.Load()
+		if int64(d) <= old || s.max.CompareAndSwap(old, int64(d)) {
+			break
+		}
+	}
+}
+
+// WindowSnapshot summarizes the latency over a recent window
+type WindowSnapshot struct {
+	Count uint64
+	P50   time.Duration
+	P90   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+}
+
+// Snapshot estimates the 50th, 90th, 95th and 99th percentiles over the last
+// window (at most MaxWindow, rounded up to whole slots)
+func (w *WindowedLatency) Snapshot(window time.Duration) WindowSnapshot {
+	return w.snapshotAt(window, time.Now())
+}
+
+// snapshotAt is Snapshot as of time t
+func (w *WindowedLatency) snapshotAt(window time.Duration, t time.Time) WindowSnapshot {
+	now := t.UnixNano() / int64(windowSlot)
+	oldest := now - int64(min((window+windowSlot-1)/windowSlot, windowSlots)) + 1
+
+	var counts [latencyBuckets]uint64
+	var total uint64
+	var highest time.Duration
+	for i := range w.slots {
+		s := &w.slots[i]
+		if epoch := s.epoch.Load(); epoch < oldest || epoch > now {
+			continue
+		}
+		for j := range counts {
+			c := s.counts[j].Load()
+			counts[j] += c
+			total += c
+		}
+		highest = max(highest, time.Duration(s.max.Load()))
+	}
+	if total == 0 {
+		return WindowSnapshot{}
+	}
+
+	return WindowSnapshot{
+		Count: total,
+		P50:   min(quantile(&counts, total, 0.50), highest),
+		P90:   min(quantile(&counts, total, 0.90), highest),
+		P95:   min(quantile(&counts, total, 0.95), highest),
+		P99:   min(quantile(&counts, total, 0.99), highest),
+	}
+}
@@ -0,0 +1,239 @@
+// Package mirror copies a sample of proxied requests to shadow backends and
+// discards their responses, so a new version can be tried on real traffic
+// without any client seeing its answers
+package mirror
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/metrics"
+)
+
+// Rule mirrors matching requests to its targets
+type Rule struct {
+	// Targets receive the mirrored requests in turn
+	Targets []*backend.Backend
+	// Percent of the matching requests is mirrored, from 0 to 100
+	Percent float64
+	// Methods, if set, limits the rule to these request methods
+	Methods []string
+	// PathPrefix, if set, limits the rule to paths starting with it
+	PathPrefix string
+}
+
+// matches reports whether r falls under the rule, before sampling
+func (rule *Rule) matches(r *http.Request) bool {
+	if len(rule.Methods) > 0 && !slices.Contains(rule.Methods, r.Method) {
+		return false
+	}
+	return strings.HasPrefix(r.URL.Path, rule.PathPrefix)
+}
+
+// Options bound the work mirroring may take on
+type Options struct {
+	// Workers send mirrored requests concurrently
+	Workers int
+	// QueueSize bounds the requests waiting for a worker; requests that do
+	// not fit are dropped
+	QueueSize int
+	// Timeout bounds each mirrored request
+	Timeout time.Duration
+	Metrics *metrics.Metrics
+	Logger  *slog.Logger
+}
+
+// job is one request on its way to a shadow target
+type job struct {
+	req    *http.Request
+	target *backend.Backend
+}
+
+// Mirror sends copies of requests to the targets of its rules from a fixed
+// set of workers, so shadow traffic never delays or changes the outcome of
+// the request it copies. A nil *Mirror mirrors nothing.
+type Mirror struct {
+	rules   []Rule
+	next    []atomic.Uint32 // round-robin position of each rule
+	timeout time.Duration
+	metrics *metrics.Metrics
+	logger  *slog.Logger
+
+	mu     sync.RWMutex // guards closed against sends racing Stop
+	closed bool
+	jobs   chan job
+	wg     sync.WaitGroup
+}
+
+// New starts the workers of a mirror for rules
+func New(rules []Rule, o Options) *Mirror {
+	logger := o.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	m := &Mirror{
+		rules:   rules,
+		next:    make([]atomic.Uint32, len(rules)),
+		timeout: o.Timeout,
+		metrics: o.Metrics,
+		logger:  logger.With("component", "mirror"),
+		jobs:    make(chan job, o.QueueSize),
+	}
+	for range max(o.Workers, 1) {
+		m.wg.Add(1)
+		go m.work()
+	}
+	return m
+}
+
+// Send queues a copy of r for every rule it falls under and is sampled by.
+// It must be called before r is proxied, once its body has been buffered:
+// a body that could not be buffered for retries is not mirrored either.
+func (m *Mirror) Send(r *http.Request) {
+	// An upgraded connection cannot be copied
+	if m == nil || backend.IsUpgrade(r) {
+		return
+	}
+	for i := range m.rules {
+		rule := &m.rules[i]
+		if len(rule.Targets) == 0 || !rule.matches(r) || rand.Float64()*100 >= rule.Percent {
+			continue
+		}
+		target := rule.Targets[int(m.next[i].Add(1)-1)%len(rule.Targets)]
+		name := target.URL.String()
+		req, ok := clone(r)
+		if !ok {
+			m.metrics.MirrorDropped(name, "body")
+			continue
+		}
+		if !m.enqueue(job{req: req, target: target}) {
+			m.metrics.MirrorDropped(name, "queue_full")
+		}
+	}
+}
+
+// clone copies r for mirroring, detached from the client's connection;
+// it fails for a streaming body, which only the primary request can read
+func clone(r *http.Request) (*http.Request, bool) {
+	req := r.Clone(context.Background())
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		req.Body = http.NoBody
+		return req, true
+	}
+	if r.GetBody == nil {
+		return nil, false
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	req.Body = body
+	return req, true
+}
+
+// enqueue hands j to a worker unless the queue is full or the mirror stopped
+func (m *Mirror) enqueue(j job) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return false
+	}
+	select {
+	case m.jobs <- j:
+		return true
+	default:
+		return false
+	}
+}
+
+// work sends queued requests until the mirror is stopped
+func (m *Mirror) work() {
+	defer m.wg.Done()
+	for j := range m.jobs {
+		m.send(j)
+	}
+}
+
+// send proxies one mirrored request and records how the target answered
+func (m *Mirror) send(j job) {
+	ctx := context.Background()
+	if m.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+	}
+	// Failures are taken over rather than answered, so they can be told
+	// apart from a backend's own error responses
+	attempt := &backend.Attempt{Retry: true}
+	w := &discard{header: make(http.Header)}
+	j.target.ReverseProxy.ServeHTTP(w, backend.WithAttempt(j.req.WithContext(ctx), attempt))
+
+	name := j.target.URL.String()
+	if attempt.Err != nil {
+		m.logger.Debug("mirrored request failed", "method", j.req.Method, "path", j.req.URL.Path, "target", name, "error", attempt.Err)
+		m.metrics.MirrorFinished(name, 0)
+		return
+	}
+	m.metrics.MirrorFinished(name, w.status())
+}
+
+// Stop lets queued requests finish, waiting at most until ctx is done
+func (m *Mirror) Stop(ctx context.Context) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.jobs)
+	}
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		m.logger.Warn("stopped waiting for mirrored requests", "error", ctx.Err())
+	}
+}
+
+// discard is the response writer of mirrored requests; it keeps the status
+// and throws everything else away
+type discard struct {
+	header http.Header
+	code   int
+}
+
+func (d *discard) Header() http.Header { return d.header }
+
+func (d *discard) Write(p []byte) (int, error) {
+	if d.code == 0 {
+		d.code = http.StatusOK
+	}
+	return len(p), nil
+}
+
+func (d *discard) WriteHeader(code int) {
+	if d.code == 0 && code >= 200 {
+		d.code = code
+	}
+}
+
+func (d *discard) status() int {
+	if d.code == 0 {
+		return http.StatusOK
+	}
+	return d.code
+}
@@ -1,25 +1,102 @@
 package pool
 
 import (
+	"log/slog"
 	"net/url"
+	"slices"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/nexus-lb/nexus/internal/backend"
 )
 
+// MembershipEventType is the event bus type of backends joining or leaving the pool
+const MembershipEventType = "backend_membership"
+
+// MembershipChange describes a backend being added to or removed from the pool
+type MembershipChange struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+	Added  bool   `json:"added"`
+}
+
+// MembershipListener is told whenever a backend joins or leaves the pool
+type MembershipListener func(b *backend.Backend, added bool)
+
 // ServerPool represents a pool of backend servers
 type ServerPool struct {
-	backends []*backend.Backend
-	current  uint64
-	mux      sync.RWMutex
+	backends   []*backend.Backend
+	current    uint64
+	mux        sync.RWMutex
+	gate       backend.Gate
+	listener   backend.StateListener
+	membership MembershipListener
+	logger     *slog.Logger
+}
+
+// SetLogger sets the logger the pool reports through (default slog.Default())
+func (s *ServerPool) SetLogger(l *slog.Logger) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.logger = l.With("component", "pool")
+}
+
+// log returns the pool's logger; callers must hold s.mux
+func (s *ServerPool) log() *slog.Logger {
+	if s.logger == nil {
+		return slog.Default().With("component", "pool")
+	}
+	return s.logger
+}
+
+// SetGate installs a gate for automatic health transitions on every backend
+// in the pool, including backends added later
+func (s *ServerPool) SetGate(g backend.Gate) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.gate = g
+	for _, b := range s.backends {
+		b.SetGate(g)
+	}
+}
+
+// SetStateListener installs a state change listener on every backend in
+// the pool, including backends added later
+func (s *ServerPool) SetStateListener(l backend.StateListener) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.listener = l
+	for _, b := range s.backends {
+		b.SetStateListener(l)
+	}
+}
+
+// SetMembershipListener installs the listener told about backends being
+// added to or removed from the pool
+func (s *ServerPool) SetMembershipListener(l MembershipListener) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.membership = l
 }
 
 // AddBackend adds a backend to the server pool
 func (s *ServerPool) AddBackend(b *backend.Backend) {
 	s.mux.Lock()
-	defer s.mux.Unlock()
+	if s.gate != nil {
+		b.SetGate(s.gate)
+	}
+	if s.listener != nil {
+		b.SetStateListener(s.listener)
+	}
 	s.backends = append(s.backends, b)
+	s.log().Info("backend added", "backend", b.URL.String(), "weight", b.Weight)
+	membership := s.membership
+	s.mux.Unlock()
+
+	if membership != nil {
+		membership(b, true)
+	}
 }
 
 // GetPoolSize returns the number of backends in the pool safely
@@ -44,28 +121,88 @@ func (s *ServerPool) NextIndex() int {
 
 // GetNextPeer returns the next alive backend using round-robin selection
 func (s *ServerPool) GetNextPeer() *backend.Backend {
+	return s.NextPeerExcluding(nil)
+}
+
+// NextPeerExcluding returns the next alive backend that is not in exclude,
+// such as the backends a request has already tried, or nil if none is left
+func (s *ServerPool) NextPeerExcluding(exclude []*backend.Backend) *backend.Backend {
+	return s.nextPeer(exclude, nil, true)
+}
+
+// NextPeerInGroup is NextPeerExcluding among the backends of one group of
+// a traffic split only
+func (s *ServerPool) NextPeerInGroup(group string, exclude []*backend.Backend) *backend.Backend {
+	return s.nextPeer(exclude, inGroup(group), true)
+}
+
+// SparePeerExcluding returns the backend NextPeerExcluding would, but
+// without advancing the round-robin position, for an extra attempt such as
+// a hedge that should not shift the rotation of first attempts
+func (s *ServerPool) SparePeerExcluding(exclude []*backend.Backend) *backend.Backend {
+	return s.nextPeer(exclude, nil, false)
+}
+
+// SparePeerInGroup is SparePeerExcluding among the backends of one group only
+func (s *ServerPool) SparePeerInGroup(group string, exclude []*backend.Backend) *backend.Backend {
+	return s.nextPeer(exclude, inGroup(group), false)
+}
+
+// inGroup matches the backends of a traffic split group
+func inGroup(group string) func(*backend.Backend) bool {
+	return func(b *backend.Backend) bool { return b.Group == group }
+}
+
+// nextPeer returns the next eligible backend that in, if set, accepts,
+// moving the round-robin position to it if advance is set. The position
+// counts slots, each backend having as many in a row as its weight, so a
+// backend of weight 2 gets twice the requests of one of weight 1.
+func (s *ServerPool) nextPeer(exclude []*backend.Backend, in func(*backend.Backend) bool, advance bool) *backend.Backend {
+	// Work on a snapshot so concurrent adds and removes cannot shift the
+	// slice underneath the selection loop
 	s.mux.RLock()
-	poolSize := len(s.backends)
+	backends := s.backends
 	s.mux.RUnlock()
 
+	poolSize := len(backends)
 	if poolSize == 0 {
 		return nil
 	}
 
-	// Start from the next index
-	next := s.NextIndex()
+	// Start from the backend of the next slot
+	slots := uint64(0)
+	for _, b := range backends {
+		slots += weight(b)
+	}
+	var slot uint64
+	if advance {
+		slot = atomic.AddUint64(&s.current, 1) % slots
+	} else {
+		slot = (atomic.LoadUint64(&s.current) + 1) % slots
+	}
+	next, first := 0, uint64(0)
+	for first+weight(backends[next]) <= slot {
+		first += weight(backends[next])
+		next++
+	}
+
+	// Backups only serve while no backend of a lower tier can
+	tier, ok := lowestTier(backends, exclude, in)
+	if !ok {
+		return nil
+	}
 
 	// Try to find an alive backend, starting from next and wrapping around
 	for i := 0; i < poolSize; i++ {
 		idx := (next + i) % poolSize
+		backend := backends[idx]
 
-		s.mux.RLock()
-		backend := s.backends[idx]
-		s.mux.RUnlock()
-
-		if backend.IsAlive() {
-			// Update current index to the selected backend
-			atomic.StoreUint64(&s.current, uint64(idx))
+		if backend.Tier == tier && eligible(backend, exclude) && (in == nil || in(backend)) {
+			// A backend standing in for a skipped one takes over from its
+			// first slot
+			if advance && i > 0 {
+				atomic.StoreUint64(&s.current, firstSlot(backends, idx))
+			}
 			return backend
 		}
 	}
@@ -74,6 +211,179 @@ func (s *ServerPool) GetNextPeer() *backend.Backend {
 	return nil
 }
 
+// weight returns the number of round-robin slots of b; a weight below 1
+// counts as 1
+func weight(b *backend.Backend) uint64 {
+	return uint64(max(b.Weight, 1))
+}
+
+// firstSlot returns the first round-robin slot of backends[idx]
+func firstSlot(backends []*backend.Backend, idx int) uint64 {
+	var slot uint64
+	for _, b := range backends[:idx] {
+		slot += weight(b)
+	}
+	return slot
+}
+
+// lowestTier returns the lowest tier with an eligible backend that in, if
+// set, accepts
+func lowestTier(backends []*backend.Backend, exclude []*backend.Backend, in func(*backend.Backend) bool) (int, bool) {
+	tier, found := 0, false
+	for _, b := range backends {
+		if (!found || b.Tier < tier) && eligible(b, exclude) && (in == nil || in(b)) {
+			tier, found = b.Tier, true
+		}
+	}
+	return tier, found
+}
+
+// HasPeerExcluding reports whether an alive backend outside exclude is
+// left, without advancing the round-robin position
+func (s *ServerPool) HasPeerExcluding(exclude []*backend.Backend) bool {
+	return s.hasPeer(exclude, nil)
+}
+
+// HasPeerInGroup is HasPeerExcluding among the backends of one group only
+func (s *ServerPool) HasPeerInGroup(group string, exclude []*backend.Backend) bool {
+	return s.hasPeer(exclude, inGroup(group))
+}
+
+func (s *ServerPool) hasPeer(exclude []*backend.Backend, in func(*backend.Backend) bool) bool {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	for _, b := range s.backends {
+		if eligible(b, exclude) && (in == nil || in(b)) {
+			return true
+		}
+	}
+	return false
+}
+
+// eligible reports whether b can take a request that has tried exclude
+func eligible(b *backend.Backend, exclude []*backend.Backend) bool {
+	return b.IsAlive() && !b.Draining() && b.BreakerAllows() && !slices.Contains(exclude, b)
+}
+
+// GetBackend returns the backend with the given URL, or nil if it is not in the pool
+func (s *ServerPool) GetBackend(backendURL string) *backend.Backend {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	for _, b := range s.backends {
+		if b.URL.String() == backendURL {
+			return b
+		}
+	}
+	return nil
+}
+
+// RemoveBackend removes the backend with the given URL from the pool and
+// returns it, or nil if no such backend exists
+func (s *ServerPool) RemoveBackend(backendURL string) *backend.Backend {
+	return s.remove(backendURL, nil)
+}
+
+// remove takes the backend with the given URL out of the pool and reports
+// the change; with only set, it must be that backend
+func (s *ServerPool) remove(backendURL string, only *backend.Backend) *backend.Backend {
+	b := s.removeBackend(backendURL, only)
+	if b == nil {
+		return nil
+	}
+
+	s.mux.RLock()
+	membership := s.membership
+	s.mux.RUnlock()
+	if membership != nil {
+		membership(b, false)
+	}
+	return b
+}
+
+// DrainBackend stops b from taking new requests, waits up to timeout for
+// its in-flight requests to finish and removes it from the pool. Upgraded
+// connections still open at the timeout are closed, as they would
+// otherwise outlive the backend's removal. A backend added meanwhile under
+// the same URL is left in place.
+func (s *ServerPool) DrainBackend(b *backend.Backend, timeout time.Duration) {
+	b.StartDraining()
+	s.waitDrained(b, timeout)
+	s.remove(b.URL.String(), b)
+}
+
+// ReplaceBackend puts b in the place of old, which takes no new requests
+// from then on and is given up to timeout to finish its in-flight ones, as
+// with DrainBackend. It reports false, changing nothing, if old is no
+// longer in the pool.
+func (s *ServerPool) ReplaceBackend(old, b *backend.Backend, timeout time.Duration) bool {
+	s.mux.Lock()
+	i := slices.Index(s.backends, old)
+	if i < 0 {
+		s.mux.Unlock()
+		return false
+	}
+	if s.gate != nil {
+		b.SetGate(s.gate)
+	}
+	if s.listener != nil {
+		b.SetStateListener(s.listener)
+	}
+	// A new slice, as in removeBackend, so snapshots stay valid
+	backends := slices.Clone(s.backends)
+	backends[i] = b
+	s.backends = backends
+	old.StartDraining()
+	s.log().Info("backend replaced", "backend", b.URL.String(), "weight", b.Weight)
+	membership := s.membership
+	s.mux.Unlock()
+
+	if membership != nil {
+		membership(old, false)
+		membership(b, true)
+	}
+	go s.waitDrained(old, timeout)
+	return true
+}
+
+// waitDrained waits up to timeout for b's in-flight requests to finish,
+// then closes the upgraded connections still open
+func (s *ServerPool) waitDrained(b *backend.Backend, timeout time.Duration) {
+	if b.WaitIdle(timeout) {
+		return
+	}
+	target := b.URL.String()
+	s.mux.RLock()
+	logger := s.log()
+	s.mux.RUnlock()
+	logger.Warn("drain timed out", "backend", target, "inflight", b.ActiveRequests())
+	if n := b.CloseUpgrades(); n > 0 {
+		logger.Info("closed upgraded connections", "backend", target, "count", n)
+	}
+}
+
+// removeBackend takes the backend with the given URL out of the pool; with
+// only set, it must be that backend
+func (s *ServerPool) removeBackend(backendURL string, only *backend.Backend) *backend.Backend {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for i, b := range s.backends {
+		if b.URL.String() == backendURL && (only == nil || b == only) {
+			// Build a new slice rather than shifting in place, so snapshots
+			// taken by GetNextPeer stay valid
+			backends := make([]*backend.Backend, 0, len(s.backends)-1)
+			backends = append(backends, s.backends[:i]...)
+			backends = append(backends, s.backends[i+1:]...)
+			s.backends = backends
+			s.log().Info("backend removed", "backend", backendURL)
+			return b
+		}
+	}
+	return nil
+}
+
 // MarkBackendStatus updates the alive status of a backend by URL
 func (s *ServerPool) MarkBackendStatus(backendURL *url.URL, alive bool) {
 	s.mux.RLock()
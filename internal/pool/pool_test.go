@@ -0,0 +1,170 @@
+package pool
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+)
+
+func newPool(t *testing.T, weights ...int) (*ServerPool, []*backend.Backend) {
+	t.Helper()
+	p := &ServerPool{}
+	var bs []*backend.Backend
+	for i, w := range weights {
+		b, err := backend.NewBackend(fmt.Sprintf("http://10.0.0.%d:80", i+1), backend.WithWeight(w))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.AddBackend(b)
+		bs = append(bs, b)
+	}
+	return p, bs
+}
+
+// picks counts the backends n selections land on
+func picks(p *ServerPool, n int) map[*backend.Backend]int {
+	counts := make(map[*backend.Backend]int)
+	for range n {
+		counts[p.GetNextPeer()]++
+	}
+	return counts
+}
+
+func TestRoundRobin(t *testing.T) {
+	p, bs := newPool(t, 1, 1, 1)
+	counts := picks(p, 300)
+	for _, b := range bs {
+		if counts[b] != 100 {
+			t.Errorf("%s picked %d times, want 100", b.URL, counts[b])
+		}
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	p, bs := newPool(t, 1, 2, 3)
+	counts := picks(p, 600)
+	for i, want := range []int{100, 200, 300} {
+		if counts[bs[i]] != want {
+			t.Errorf("weight %d picked %d times, want %d", bs[i].Weight, counts[bs[i]], want)
+		}
+	}
+}
+
+func TestZeroWeightCountsAsOne(t *testing.T) {
+	p, bs := newPool(t, 0, 1)
+	counts := picks(p, 100)
+	if counts[bs[0]] != 50 || counts[bs[1]] != 50 {
+		t.Errorf("picked %d and %d times, want 50 each", counts[bs[0]], counts[bs[1]])
+	}
+}
+
+func TestSkipsDownBackends(t *testing.T) {
+	p, bs := newPool(t, 3, 1, 1)
+	bs[0].SetAlive(false)
+	counts := picks(p, 100)
+	if counts[bs[0]] != 0 {
+		t.Fatalf("down backend picked %d times", counts[bs[0]])
+	}
+	if counts[bs[1]]+counts[bs[2]] != 100 || counts[bs[1]] == 0 || counts[bs[2]] == 0 {
+		t.Errorf("picked %d and %d times", counts[bs[1]], counts[bs[2]])
+	}
+
+	for _, b := range bs {
+		b.SetAlive(false)
+	}
+	if b := p.GetNextPeer(); b != nil {
+		t.Errorf("picked %s with every backend down", b.URL)
+	}
+}
+
+func TestExcluding(t *testing.T) {
+	p, bs := newPool(t, 5, 1)
+	for range 10 {
+		if b := p.NextPeerExcluding(bs[:1]); b != bs[1] {
+			t.Fatalf("picked %v, want the backend not excluded", b)
+		}
+	}
+	if b := p.NextPeerExcluding(bs); b != nil {
+		t.Errorf("picked %s with every backend excluded", b.URL)
+	}
+	if p.HasPeerExcluding(bs) || !p.HasPeerExcluding(bs[:1]) {
+		t.Error("HasPeerExcluding disagrees with NextPeerExcluding")
+	}
+}
+
+func TestSpareDoesNotAdvance(t *testing.T) {
+	p, _ := newPool(t, 1, 1, 1)
+	spare := p.SparePeerExcluding(nil)
+	if again := p.SparePeerExcluding(nil); again != spare {
+		t.Fatal("SparePeerExcluding advanced the rotation")
+	}
+	if next := p.GetNextPeer(); next != spare {
+		t.Errorf("next peer %s, want the spare %s", next.URL, spare.URL)
+	}
+}
+
+func TestTiers(t *testing.T) {
+	p := &ServerPool{}
+	primary, _ := backend.NewBackend("http://10.0.0.1:80")
+	backup, _ := backend.NewBackend("http://10.0.0.2:80", backend.WithTier(1), backend.WithWeight(10))
+	p.AddBackend(primary)
+	p.AddBackend(backup)
+	if counts := picks(p, 20); counts[primary] != 20 {
+		t.Fatalf("primary picked %d of 20 times", counts[primary])
+	}
+	primary.SetAlive(false)
+	if b := p.GetNextPeer(); b != backup {
+		t.Errorf("picked %v, want the backup", b)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	p := &ServerPool{}
+	stable, _ := backend.NewBackend("http://10.0.0.1:80")
+	canary, _ := backend.NewBackend("http://10.0.0.2:80", backend.WithGroup("canary"))
+	p.AddBackend(stable)
+	p.AddBackend(canary)
+	for range 5 {
+		if b := p.NextPeerInGroup("canary", nil); b != canary {
+			t.Fatalf("picked %v, want the canary", b)
+		}
+	}
+}
+
+func TestRemoveAndDrain(t *testing.T) {
+	p, bs := newPool(t, 1, 1)
+	if p.RemoveBackend(bs[0].URL.String()) != bs[0] || p.GetPoolSize() != 1 {
+		t.Fatal("backend not removed")
+	}
+	if p.RemoveBackend(bs[0].URL.String()) != nil {
+		t.Error("removed a backend twice")
+	}
+
+	bs[1].StartDraining()
+	p.DrainBackend(bs[1], time.Second)
+	if p.GetPoolSize() != 0 {
+		t.Error("drained backend left in the pool")
+	}
+}
+
+func TestReplace(t *testing.T) {
+	p, bs := newPool(t, 1, 1)
+	b, err := backend.NewBackend(bs[0].URL.String(), backend.WithWeight(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.ReplaceBackend(bs[0], b, time.Second) {
+		t.Fatal("backend not replaced")
+	}
+	if got := p.GetBackends(); len(got) != 2 || got[0] != b || got[1] != bs[1] {
+		t.Errorf("pool holds %v, want the replacement in the old one's place", got)
+	}
+	if !bs[0].Draining() || b.Draining() {
+		t.Error("want only the replaced backend draining")
+	}
+	if p.ReplaceBackend(bs[0], b, time.Second) {
+		t.Error("replaced a backend no longer in the pool")
+	}
+}
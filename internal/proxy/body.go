@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxReplayBody is the largest request body buffered for retries
+// unless SetMaxReplayBody says otherwise
+const DefaultMaxReplayBody = 1 << 20
+
+// BodyLimits caps the size of request bodies, per path prefix
+type BodyLimits struct {
+	// Default applies to paths no route matches; zero means unlimited
+	Default int64
+	Routes  []BodyLimitRoute
+}
+
+// BodyLimitRoute overrides the body limit on paths starting with PathPrefix;
+// a MaxBytes of zero lifts the limit there
+type BodyLimitRoute struct {
+	PathPrefix string
+	MaxBytes   int64
+}
+
+// limit returns the body limit of a path; the longest matching prefix wins
+func (l BodyLimits) limit(path string) int64 {
+	limit, matched := l.Default, -1
+	for _, route := range l.Routes {
+		if len(route.PathPrefix) > matched && strings.HasPrefix(path, route.PathPrefix) {
+			limit, matched = route.MaxBytes, len(route.PathPrefix)
+		}
+	}
+	return limit
+}
+
+// limitBody enforces limit on the request body. A declared length over the
+// limit is refused before anything is read; a body of unknown length is cut
+// off once it passes the limit, failing the attempt with an
+// *http.MaxBytesError.
+func limitBody(w http.ResponseWriter, r *http.Request, limit int64) (ok bool) {
+	if limit <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	if r.ContentLength > limit {
+		return false
+	}
+	if r.ContentLength < 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	return true
+}
+
+// bufferBody makes the request body replayable when it has a known length of
+// at most limit bytes, reading it into memory and setting r.GetBody. Larger
+// bodies and bodies of unknown length are left streaming and reported as not
+// replayable; a request without a body is always replayable.
+func bufferBody(r *http.Request, limit int64) (replayable bool, err error) {
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return true, nil
+	}
+	if r.ContentLength < 0 || r.ContentLength > limit {
+		return false, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, r.ContentLength))
+	if err != nil {
+		return false, err
+	}
+	if int64(len(data)) != r.ContentLength {
+		return false, io.ErrUnexpectedEOF
+	}
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	r.Body, _ = r.GetBody()
+	return true, nil
+}
+
+// rewindBody gives the request a fresh copy of its buffered body before an
+// attempt, so every backend tried receives it complete
+func rewindBody(r *http.Request) {
+	if r.GetBody != nil {
+		r.Body, _ = r.GetBody()
+	}
+}
@@ -0,0 +1,260 @@
+package proxy
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultCompressibleTypes are the content types compressed unless the
+// configuration lists its own; a trailing "/" matches a whole family
+var DefaultCompressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// Compressor gzips responses for clients that accept it, as they stream
+// through. Bodies that are already encoded, too small, of other content
+// types, server-sent events and upgraded connections pass through as is.
+type Compressor struct {
+	next    http.Handler
+	minSize int
+	types   []string
+	pool    sync.Pool
+}
+
+// NewCompressor compresses responses of next whose content type matches
+// types and whose body is at least minSize bytes, at the given gzip level
+// (0 for the gzip default)
+func NewCompressor(next http.Handler, minSize int, types []string, level int) *Compressor {
+	if len(types) == 0 {
+		types = DefaultCompressibleTypes
+	}
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	c := &Compressor{next: next, minSize: minSize, types: types}
+	c.pool.New = func() any {
+		zw, _ := gzip.NewWriterLevel(nil, level)
+		return zw
+	}
+	return c
+}
+
+func (c *Compressor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Upgrade") != "" {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+	cw := &compressWriter{ResponseWriter: w, c: c, accepts: acceptsGzip(r.Header.Get("Accept-Encoding")), head: r.Method == http.MethodHead}
+	defer cw.close()
+	c.next.ServeHTTP(cw, r)
+}
+
+// compressible reports whether responses of the content type are compressed
+func (c *Compressor) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "text/event-stream" {
+		return false
+	}
+	for _, t := range c.types {
+		if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(header string) bool {
+	for part := range strings.SplitSeq(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		return q > 0
+	}
+	return false
+}
+
+// Decisions a compressWriter makes about its response
+const (
+	undecided = iota
+	passThrough
+	compressing
+)
+
+// compressWriter decides once the response headers are known whether to
+// compress. A body of unknown length is held back until minSize bytes
+// decide it, the response is flushed or it ends short.
+type compressWriter struct {
+	http.ResponseWriter
+	c       *Compressor
+	accepts bool
+	head    bool
+
+	status   int
+	state    int
+	pending  []byte
+	zw       *gzip.Writer
+	hijacked bool
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if code < 200 && code != http.StatusSwitchingProtocols {
+		// Informational responses go out right away
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if cw.status != 0 {
+		return
+	}
+	cw.status = code
+
+	h := cw.Header()
+	eligible := cw.c.compressible(h.Get("Content-Type"))
+	if eligible {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	switch {
+	case !eligible || !cw.accepts || cw.head || h.Get("Content-Encoding") != "" ||
+		code == http.StatusNoContent || code == http.StatusNotModified || code == http.StatusSwitchingProtocols:
+		cw.passThrough()
+	case h.Get("Content-Length") != "":
+		if n, err := strconv.Atoi(h.Get("Content-Length")); err != nil || n < cw.c.minSize {
+			cw.passThrough()
+		} else {
+			cw.startCompressing()
+		}
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	switch cw.state {
+	case compressing:
+		return cw.zw.Write(p)
+	case passThrough:
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.pending = append(cw.pending, p...)
+	if len(cw.pending) >= cw.c.minSize {
+		cw.startCompressing()
+		if err := cw.flushPending(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// passThrough sends the response uncompressed
+func (cw *compressWriter) passThrough() {
+	cw.state = passThrough
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+// startCompressing sends the headers of a gzipped response
+func (cw *compressWriter) startCompressing() {
+	cw.state = compressing
+	h := cw.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", "gzip")
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		// The encoded body is no longer byte-for-byte the tagged one
+		h.Set("ETag", "W/"+etag)
+	}
+	cw.zw = cw.c.pool.Get().(*gzip.Writer)
+	cw.zw.Reset(cw.ResponseWriter)
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+// flushPending writes out what was held back while undecided
+func (cw *compressWriter) flushPending() error {
+	if len(cw.pending) == 0 {
+		return nil
+	}
+	var err error
+	if cw.state == compressing {
+		_, err = cw.zw.Write(cw.pending)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.pending)
+	}
+	cw.pending = nil
+	return err
+}
+
+// Flush sends everything written so far. A response still undecided is a
+// stream of unknown length, which ReverseProxy flushes write by write, and
+// is compressed as it goes.
+func (cw *compressWriter) Flush() {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.state == undecided {
+		cw.startCompressing()
+		cw.flushPending()
+	}
+	if cw.state == compressing {
+		cw.zw.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Hijack hands over the connection; nothing is compressed after that
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if cw.state == compressing {
+		return nil, nil, errors.New("proxy: cannot hijack a compressed response")
+	}
+	conn, rw, err := http.NewResponseController(cw.ResponseWriter).Hijack()
+	if err == nil {
+		cw.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close finishes the response once the handler returns
+func (cw *compressWriter) close() {
+	if cw.hijacked || cw.status == 0 {
+		return
+	}
+	if cw.state == undecided {
+		// The whole body fit under minSize
+		cw.Header().Set("Content-Length", strconv.Itoa(len(cw.pending)))
+		cw.passThrough()
+		cw.flushPending()
+	}
+	if cw.state == compressing {
+		cw.zw.Close()
+		cw.zw.Reset(nil)
+		cw.c.pool.Put(cw.zw)
+		cw.zw = nil
+	}
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Deadlines are the read and write timeouts of the proxy listener as the
+// handler applies them to each request. The server enforces its timeouts on
+// its own; the handler lets routes override them and turns Write, the write
+// timeout, into a per-write timeout for streaming responses, which would
+// otherwise be cut off once the whole response outlasts it.
+type Deadlines struct {
+	Write  time.Duration
+	Routes []DeadlineRoute
+}
+
+// DeadlineRoute replaces the read and write timeouts on paths starting with
+// PathPrefix; a zero timeout lifts it there
+type DeadlineRoute struct {
+	PathPrefix string
+	Read       time.Duration
+	Write      time.Duration
+}
+
+// apply sets the deadlines of the request's connection and returns the
+// writer the response goes through
+func (d Deadlines) apply(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	var read time.Duration
+	write, matched := d.Write, -1
+	for _, route := range d.Routes {
+		if len(route.PathPrefix) > matched && strings.HasPrefix(r.URL.Path, route.PathPrefix) {
+			read, write, matched = route.Read, route.Write, len(route.PathPrefix)
+		}
+	}
+	if matched < 0 && write <= 0 {
+		return w
+	}
+	rc := http.NewResponseController(w)
+	if matched >= 0 {
+		// Errors only mean the connection has no deadlines to change
+		rc.SetReadDeadline(deadlineAfter(read))
+		rc.SetWriteDeadline(deadlineAfter(write))
+	}
+	if write <= 0 {
+		return w
+	}
+	return &deadlineWriter{ResponseWriter: w, rc: rc, timeout: write}
+}
+
+// deadlineAfter returns the deadline d from now, or none for zero
+func deadlineAfter(d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
+
+// deadlineWriter pushes the write deadline back on every write of a
+// streaming response, so the timeout bounds a stalled client rather than
+// the length of the stream
+type deadlineWriter struct {
+	http.ResponseWriter
+	rc        *http.ResponseController
+	timeout   time.Duration
+	streaming bool
+}
+
+func (dw *deadlineWriter) WriteHeader(code int) {
+	if code >= 200 && !dw.streaming {
+		mediaType, _, _ := mime.ParseMediaType(dw.Header().Get("Content-Type"))
+		dw.streaming = dw.Header().Get("Content-Length") == "" || mediaType == "text/event-stream"
+	}
+	dw.ResponseWriter.WriteHeader(code)
+}
+
+func (dw *deadlineWriter) Write(p []byte) (int, error) {
+	if dw.streaming {
+		dw.rc.SetWriteDeadline(deadlineAfter(dw.timeout))
+	}
+	return dw.ResponseWriter.Write(p)
+}
+
+// finish leaves the server time to end a streaming response, whose final
+// chunk is written only once the handler returns
+func (dw *deadlineWriter) finish() {
+	if dw.streaming {
+		dw.rc.SetWriteDeadline(deadlineAfter(dw.timeout))
+	}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (dw *deadlineWriter) Unwrap() http.ResponseWriter {
+	return dw.ResponseWriter
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FlushRoute sets how often responses on paths starting with PathPrefix are
+// flushed to the client while they are being written; a negative Interval
+// flushes after every write. Server-sent events and responses of unknown
+// length are always flushed after every write.
+type FlushRoute struct {
+	PathPrefix string
+	Interval   time.Duration
+}
+
+// flushInterval returns the interval of the longest matching route, or zero
+func flushInterval(routes []FlushRoute, path string) time.Duration {
+	var interval time.Duration
+	matched := -1
+	for _, route := range routes {
+		if len(route.PathPrefix) > matched && strings.HasPrefix(path, route.PathPrefix) {
+			interval, matched = route.Interval, len(route.PathPrefix)
+		}
+	}
+	return interval
+}
+
+// flushWriter flushes what is written through it at most interval after it
+// was written. Flushes of its own and of ReverseProxy are serialized.
+type flushWriter struct {
+	http.ResponseWriter
+	rc       *http.ResponseController
+	interval time.Duration
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	stopped bool
+}
+
+func newFlushWriter(w http.ResponseWriter, interval time.Duration) *flushWriter {
+	return &flushWriter{ResponseWriter: w, rc: http.NewResponseController(w), interval: interval}
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	n, err := fw.ResponseWriter.Write(p)
+	switch {
+	case fw.interval < 0:
+		fw.rc.Flush()
+	case fw.timer == nil:
+		fw.timer = time.AfterFunc(fw.interval, fw.delayedFlush)
+	}
+	return n, err
+}
+
+func (fw *flushWriter) delayedFlush() {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if !fw.stopped {
+		fw.rc.Flush()
+	}
+	fw.timer = nil
+}
+
+// FlushError flushes everything written so far
+func (fw *flushWriter) FlushError() error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return fw.rc.Flush()
+}
+
+// stop cancels a pending flush once the handler is done with the response
+func (fw *flushWriter) stop() {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	fw.stopped = true
+	if fw.timer != nil {
+		fw.timer.Stop()
+	}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (fw *flushWriter) Unwrap() http.ResponseWriter {
+	return fw.ResponseWriter
+}
@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/pool"
+)
+
+// newStreamProxy serves a handler over a pool of the one upstream, built
+// with opts
+func newStreamProxy(t *testing.T, up *testBackend, opts ...backend.Option) (*Handler, *httptest.Server) {
+	t.Helper()
+	b, err := backend.NewBackend(up.URL, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &pool.ServerPool{}
+	p.AddBackend(b)
+	h := NewHandler(p, 1)
+	s := httptest.NewServer(h)
+	t.Cleanup(s.Close)
+	return h, s
+}
+
+// firstLine starts a GET of url and returns how long after the time sent
+// on written the first line of the body arrived
+func firstLine(t *testing.T, url string, written <-chan time.Time) time.Duration {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	br := bufio.NewReader(resp.Body)
+	if _, err := br.ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	return time.Since(<-written)
+}
+
+func TestFirstSSEEventImmediate(t *testing.T) {
+	written := make(chan time.Time, 1)
+	release := make(chan struct{})
+	up := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		written <- time.Now()
+		<-release
+	})
+	defer close(release)
+	// Event streams are flushed at once whatever the backend's interval
+	_, s := newStreamProxy(t, up, backend.WithFlushInterval(time.Hour))
+
+	if delay := firstLine(t, s.URL, written); delay > 100*time.Millisecond {
+		t.Errorf("first event arrived %s after it was written", delay)
+	}
+}
+
+func TestFlushIntervals(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []backend.Option
+		routes []FlushRoute
+	}{
+		{"flush after every write", []backend.Option{backend.WithFlushInterval(-1)}, nil},
+		{"route interval", nil, []FlushRoute{{PathPrefix: "/poll", Interval: 20 * time.Millisecond}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			written := make(chan time.Time, 1)
+			release := make(chan struct{})
+			// A long poll of known length, whose first part would otherwise
+			// wait in the proxy's buffer for the rest
+			up := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "64")
+				io.WriteString(w, "waiting\n")
+				w.(http.Flusher).Flush()
+				written <- time.Now()
+				<-release
+				io.WriteString(w, strings.Repeat("x", 56))
+			})
+			defer close(release)
+			h, s := newStreamProxy(t, up, tt.opts...)
+			h.SetFlushRoutes(tt.routes)
+
+			if delay := firstLine(t, s.URL+"/poll", written); delay > 200*time.Millisecond {
+				t.Errorf("first part arrived %s after it was written", delay)
+			}
+		})
+	}
+}
+
+func TestStreamIdleDeadline(t *testing.T) {
+	settings := backend.DefaultTransportSettings()
+	settings.RequestTimeout = 100 * time.Millisecond
+	settings.StreamIdleTimeout = 150 * time.Millisecond
+
+	t.Run("active stream outlasts the request timeout", func(t *testing.T) {
+		up := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for range 6 {
+				io.WriteString(w, "data: tick\n\n")
+				w.(http.Flusher).Flush()
+				time.Sleep(50 * time.Millisecond)
+			}
+		})
+		_, s := newStreamProxy(t, up, backend.WithTransport(settings))
+		resp, err := http.Get(s.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if got := strings.Count(string(body), "data: tick"); got != 6 {
+			t.Errorf("received %d events, want 6", got)
+		}
+	})
+	t.Run("stalled stream is cut off", func(t *testing.T) {
+		release := make(chan struct{})
+		up := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, "data: first\n\n")
+			w.(http.Flusher).Flush()
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		})
+		defer close(release)
+		_, s := newStreamProxy(t, up, backend.WithTransport(settings))
+		resp, err := http.Get(s.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		done := make(chan struct{})
+		go func() {
+			io.Copy(io.Discard, resp.Body)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("stalled stream still open")
+		}
+	})
+}
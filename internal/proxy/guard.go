@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/errorpage"
+	"github.com/nexus-lb/nexus/internal/metrics"
+)
+
+// GuardOptions configure a RequestGuard
+type GuardOptions struct {
+	// MaxHeaders caps the header fields of a request, each value of a
+	// repeated header counting once; zero for no limit
+	MaxHeaders int
+	// MaxHeaderValueBytes caps the length of each header value; zero for
+	// no limit
+	MaxHeaderValueBytes int
+	// Trusted resolves the client IP behind trusted proxies, like the
+	// access log does
+	Trusted backend.TrustedProxies
+	// OnViolation, if set, is called with the client IP of every request
+	// refused
+	OnViolation func(ip string)
+	Metrics     *metrics.Metrics
+	ErrorPages  *errorpage.Pages
+}
+
+// RequestGuard refuses requests that are too big or ambiguous to be worth
+// proxying, before any other work is done for them: those with too many
+// header fields or too long a header value get 431, and those whose body
+// framing is ambiguous get 400 and their connection closed.
+//
+// The HTTP/1 and HTTP/2 servers already refuse differing Content-Length
+// values and transfer codings other than chunked, and drop Content-Length
+// alongside chunked, so the framing check is a backstop for requests that
+// reached the handler without being normalized.
+type RequestGuard struct {
+	next http.Handler
+	o    GuardOptions
+}
+
+// NewRequestGuard checks the requests to next
+func NewRequestGuard(next http.Handler, o GuardOptions) *RequestGuard {
+	return &RequestGuard{next: next, o: o}
+}
+
+func (g *RequestGuard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !g.headersFit(r.Header) {
+		g.refuse(w, r, "header_limit", http.StatusRequestHeaderFieldsTooLarge)
+		return
+	}
+	if ambiguousFraming(r) {
+		w.Header().Set("Connection", "close")
+		g.refuse(w, r, "bad_framing", http.StatusBadRequest)
+		return
+	}
+	g.next.ServeHTTP(w, r)
+}
+
+// headersFit reports whether h is within the header count and value length
+// limits
+func (g *RequestGuard) headersFit(h http.Header) bool {
+	n := 0
+	for _, values := range h {
+		n += len(values)
+		if g.o.MaxHeaders > 0 && n > g.o.MaxHeaders {
+			return false
+		}
+		if g.o.MaxHeaderValueBytes > 0 {
+			for _, v := range values {
+				if len(v) > g.o.MaxHeaderValueBytes {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// ambiguousFraming reports whether r declares its body length in more than
+// one way, or in a way the backend could read differently: a
+// Transfer-Encoding header left in place, Content-Length alongside a
+// transfer coding, or more than one or a malformed Content-Length
+func ambiguousFraming(r *http.Request) bool {
+	if _, ok := r.Header["Transfer-Encoding"]; ok {
+		return true
+	}
+	cl, ok := r.Header["Content-Length"]
+	if !ok {
+		return false
+	}
+	return len(r.TransferEncoding) > 0 || len(cl) > 1 || cl[0] == "" || strings.Trim(cl[0], "0123456789") != ""
+}
+
+// refuse answers status, counting the refusal under reason and against the
+// client
+func (g *RequestGuard) refuse(w http.ResponseWriter, r *http.Request, reason string, status int) {
+	g.o.Metrics.Rejected(reason)
+	if g.o.OnViolation != nil {
+		g.o.OnViolation(g.o.Trusted.ClientIP(r))
+	}
+	if !g.o.ErrorPages.Render(w, r, status) {
+		http.Error(w, http.StatusText(status), status)
+	}
+}
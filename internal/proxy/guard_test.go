@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newGuard(o GuardOptions) *RequestGuard {
+	return NewRequestGuard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), o)
+}
+
+func TestGuardHeaderLimits(t *testing.T) {
+	g := newGuard(GuardOptions{MaxHeaders: 3, MaxHeaderValueBytes: 8})
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{"within limits", http.Header{"A": {"1"}, "B": {"2"}}, http.StatusOK},
+		{"repeated values count", http.Header{"A": {"1", "2", "3", "4"}}, http.StatusRequestHeaderFieldsTooLarge},
+		{"long value", http.Header{"A": {strings.Repeat("x", 9)}}, http.StatusRequestHeaderFieldsTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header = tt.header
+			w := httptest.NewRecorder()
+			g.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestGuardAmbiguousFraming(t *testing.T) {
+	g := newGuard(GuardOptions{})
+	tests := []struct {
+		name   string
+		header http.Header
+		te     []string
+		want   int
+	}{
+		{"plain length", http.Header{"Content-Length": {"5"}}, nil, http.StatusOK},
+		{"Transfer-Encoding left in place", http.Header{"Transfer-Encoding": {"chunked"}}, nil, http.StatusBadRequest},
+		{"length with a transfer coding", http.Header{"Content-Length": {"5"}}, []string{"chunked"}, http.StatusBadRequest},
+		{"two lengths", http.Header{"Content-Length": {"5", "6"}}, nil, http.StatusBadRequest},
+		{"signed length", http.Header{"Content-Length": {"+5"}}, nil, http.StatusBadRequest},
+		{"empty length", http.Header{"Content-Length": {""}}, nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.Header = tt.header
+			r.TransferEncoding = tt.te
+			w := httptest.NewRecorder()
+			g.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusBadRequest && w.Header().Get("Connection") != "close" {
+				t.Error("connection not closed after ambiguous framing")
+			}
+		})
+	}
+}
+
+func TestGuardViolationChargesPeer(t *testing.T) {
+	var charged []string
+	// trusted_proxies unset: only loopback peers are believed
+	g := newGuard(GuardOptions{MaxHeaders: 1, OnViolation: func(ip string) { charged = append(charged, ip) }})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.9:4000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r.Header.Set("X-Extra", "1")
+	g.ServeHTTP(httptest.NewRecorder(), r)
+
+	r.RemoteAddr = "127.0.0.1:4000"
+	g.ServeHTTP(httptest.NewRecorder(), r)
+
+	if len(charged) != 2 || charged[0] != "203.0.113.9" || charged[1] != "198.51.100.1" {
+		t.Errorf("violations charged to %v, want [203.0.113.9 198.51.100.1]", charged)
+	}
+}
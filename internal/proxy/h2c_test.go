@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/http2/hpack"
+)
+
+// newH2CServer serves h as the plain proxy listener does, with h2c enabled
+func newH2CServer(t *testing.T, h *Handler) *httptest.Server {
+	t.Helper()
+	s := httptest.NewServer(h2c.NewHandler(h, &http2.Server{}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// echoProto answers with the protocol the backend was reached over and
+// the request path
+func echoProto(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, r.Proto+" "+r.URL.Path)
+}
+
+func TestH2CPriorKnowledge(t *testing.T) {
+	h := NewHandler(newTestPool(t, newTestBackend(t, echoProto)), 3)
+	s := newH2CServer(t, h)
+
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+	for range 3 {
+		resp, err := client.Get(s.URL + "/h2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.ProtoMajor != 2 {
+			t.Fatalf("answered over %s, want HTTP/2", resp.Proto)
+		}
+		if resp.StatusCode != http.StatusOK || string(body) != "HTTP/1.1 /h2" {
+			t.Fatalf("got %d %q", resp.StatusCode, body)
+		}
+	}
+	if got := h.Protocols().Snapshot(); got["HTTP/2.0"] != 3 || got["HTTP/1.1"] != 0 {
+		t.Errorf("protocol counters %v, want 3 HTTP/2 requests", got)
+	}
+}
+
+func TestH2CUpgrade(t *testing.T) {
+	h := NewHandler(newTestPool(t, newTestBackend(t, echoProto)), 3)
+	s := newH2CServer(t, h)
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// HTTP2-Settings carries an empty SETTINGS payload
+	io.WriteString(conn, "GET /upgraded HTTP/1.1\r\nHost: nexus\r\n"+
+		"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: \r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d, want 101", resp.StatusCode)
+	}
+
+	// The upgrade request is answered as stream 1 of the HTTP/2 connection
+	io.WriteString(conn, http2.ClientPreface)
+	fr := http2.NewFramer(conn, br)
+	if err := fr.WriteSettings(); err != nil {
+		t.Fatal(err)
+	}
+	dec := hpack.NewDecoder(4096, nil)
+	if status, body := readStream(t, fr, dec, 1); status != "200" || body != "HTTP/1.1 /upgraded" {
+		t.Errorf("upgrade request: got %s %q", status, body)
+	}
+
+	// Later requests on the connection are HTTP/2 end to end
+	var block bytes.Buffer
+	enc := hpack.NewEncoder(&block)
+	for _, f := range []hpack.HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":authority", Value: "nexus"},
+		{Name: ":path", Value: "/second"},
+	} {
+		enc.WriteField(f)
+	}
+	if err := fr.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      3,
+		BlockFragment: block.Bytes(),
+		EndStream:     true,
+		EndHeaders:    true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if status, body := readStream(t, fr, dec, 3); status != "200" || body != "HTTP/1.1 /second" {
+		t.Errorf("second request: got %s %q", status, body)
+	}
+
+	// The upgrade request itself was sent as HTTP/1.1
+	if got := h.Protocols().Snapshot(); got["HTTP/1.1"] != 1 || got["HTTP/2.0"] != 1 {
+		t.Errorf("protocol counters %v, want 1 HTTP/1.1 and 1 HTTP/2 request", got)
+	}
+}
+
+// readStream reads frames until stream id ends and returns its status and
+// body, skipping the frames of other streams
+func readStream(t *testing.T, fr *http2.Framer, dec *hpack.Decoder, id uint32) (string, string) {
+	t.Helper()
+	var status string
+	var body bytes.Buffer
+	dec.SetEmitFunc(func(f hpack.HeaderField) {
+		if f.Name == ":status" {
+			status = f.Value
+		}
+	})
+	for {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			t.Fatalf("reading stream %d: %v", id, err)
+		}
+		if f.Header().StreamID != id {
+			continue
+		}
+		switch f := f.(type) {
+		case *http2.HeadersFrame:
+			if _, err := dec.Write(f.HeaderBlockFragment()); err != nil {
+				t.Fatal(err)
+			}
+		case *http2.DataFrame:
+			body.Write(f.Data())
+		}
+		if f.Header().Flags.Has(http2.FlagDataEndStream) {
+			return status, body.String()
+		}
+	}
+}
+
+func TestHTTP11Unchanged(t *testing.T) {
+	// Besides plain requests, upgrades other than h2c still need the
+	// connection hijacked through every response writer wrapper
+	up := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			echoProto(w, r)
+			return
+		}
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("backend hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		rw.Flush()
+		io.Copy(conn, rw)
+	})
+	h := NewHandler(newTestPool(t, up), 3)
+	s := newH2CServer(t, h)
+
+	resp, err := http.Get(s.URL + "/h1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.ProtoMajor != 1 || resp.StatusCode != http.StatusOK || string(body) != "HTTP/1.1 /h1" {
+		t.Fatalf("got %s %d %q", resp.Proto, resp.StatusCode, body)
+	}
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /tunnel HTTP/1.1\r\nHost: nexus\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err = http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("upgrade status %d, want 101", resp.StatusCode)
+	}
+	io.WriteString(conn, "ping\n")
+	if line, err := br.ReadString('\n'); err != nil || strings.TrimSpace(line) != "ping" {
+		t.Fatalf("tunnel echoed %q, %v", line, err)
+	}
+
+	if got := h.Protocols().Snapshot(); got["HTTP/1.1"] != 2 || got["HTTP/2.0"] != 0 {
+		t.Errorf("protocol counters %v, want 2 HTTP/1.1 requests", got)
+	}
+}
@@ -0,0 +1,545 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/accesslog"
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/errorpage"
+	"github.com/nexus-lb/nexus/internal/logdedup"
+	"github.com/nexus-lb/nexus/internal/maintenance"
+	"github.com/nexus-lb/nexus/internal/metrics"
+	"github.com/nexus-lb/nexus/internal/mirror"
+	"github.com/nexus-lb/nexus/internal/pool"
+	"github.com/nexus-lb/nexus/internal/rewrite"
+	"github.com/nexus-lb/nexus/internal/route"
+	"github.com/nexus-lb/nexus/internal/split"
+	"github.com/nexus-lb/nexus/internal/tracing"
+)
+
+// Handler load balances incoming requests across the backends of a server pool
+type Handler struct {
+	pool        *pool.ServerPool
+	maxRetries  int
+	protocols   metrics.ProtocolCounters
+	metrics     *metrics.Metrics
+	tracer      *tracing.Tracer
+	accessLog   *accesslog.Logger
+	sampler     *accesslog.Sampler
+	logger      *slog.Logger
+	logDedup    *logdedup.Deduper
+	trusted     backend.TrustedProxies
+	maxReplay   int64
+	bodyLimits  BodyLimits
+	deadlines   Deadlines
+	flushes     []FlushRoute
+	retry       RetryPolicy
+	errorPages  *errorpage.Pages
+	mirror      *mirror.Mirror
+	split       *split.Splitter
+	maintenance *maintenance.Mode
+	rewriter    *rewrite.Rewriter
+	router      *route.Router
+	chains      map[string]http.Handler // by route name
+	hedge       *hedger
+	queue       *Queue
+}
+
+// exchange is the state of a request that outlives its trip through the
+// middleware of its route
+type exchange struct {
+	start    time.Time
+	attempts int
+	// sampled decides whether the request's lines are logged
+	sampled bool
+	served  string
+	routed  route.Match
+	// fallback is the pool the request fell back to, if it did
+	fallback string
+	// user is who the route's middleware authenticated the client as
+	user string
+	rec  *responseRecorder
+	span *tracing.Span
+}
+
+type exchangeKey struct{}
+
+// NewHandler creates a new load balancing handler for the given pool
+func NewHandler(serverPool *pool.ServerPool, maxRetries int) *Handler {
+	return &Handler{
+		pool:       serverPool,
+		maxRetries: maxRetries,
+		maxReplay:  DefaultMaxReplayBody,
+		retry:      DefaultRetryPolicy(),
+		logger:     slog.Default().With("component", "proxy"),
+	}
+}
+
+// SetLogger sets the logger the handler reports through. Per-request lines
+// are logged at Debug level.
+func (h *Handler) SetLogger(l *slog.Logger) {
+	h.logger = l.With("component", "proxy")
+}
+
+// SetLogDedup collapses repeats of the handler's retry and failure log
+// lines within the deduper's window
+func (h *Handler) SetLogDedup(d *logdedup.Deduper) {
+	h.logDedup = d
+}
+
+// SetTrustedProxies sets the proxies believed when resolving the client IP
+// for the access log
+func (h *Handler) SetTrustedProxies(t backend.TrustedProxies) {
+	h.trusted = t
+}
+
+// SetMaxReplayBody sets the largest request body buffered so the request
+// can be retried on another backend; requests with larger or streaming
+// bodies are only ever attempted once
+func (h *Handler) SetMaxReplayBody(n int64) {
+	h.maxReplay = n
+}
+
+// SetBodyLimits sets the largest request bodies accepted; larger ones are
+// answered with 413 Payload Too Large
+func (h *Handler) SetBodyLimits(l BodyLimits) {
+	h.bodyLimits = l
+}
+
+// SetDeadlines tells the handler the listener's read and write timeouts, so
+// it can apply their per-route overrides and keep streaming responses from
+// being cut off by the write timeout
+func (h *Handler) SetDeadlines(d Deadlines) {
+	h.deadlines = d
+}
+
+// SetFlushRoutes sets how often responses are flushed on the routes listed,
+// overriding the interval of whichever backend serves them
+func (h *Handler) SetFlushRoutes(routes []FlushRoute) {
+	h.flushes = routes
+}
+
+// SetRetryPolicy sets the pauses between attempts and what is retried
+func (h *Handler) SetRetryPolicy(p RetryPolicy) {
+	h.retry = p
+}
+
+// SetErrorPages replaces the plain text bodies of the handler's own 502 and
+// 503 responses with the configured pages
+func (h *Handler) SetErrorPages(p *errorpage.Pages) {
+	h.errorPages = p
+}
+
+// SetMirror copies a sample of requests to shadow backends
+func (h *Handler) SetMirror(m *mirror.Mirror) {
+	h.mirror = m
+}
+
+// SetSplit divides requests between the pools of a traffic split; each
+// request is then only ever served by backends of its pool
+func (h *Handler) SetSplit(s *split.Splitter) {
+	h.split = s
+}
+
+// SetMaintenance answers the requests of pools in maintenance with a 503
+// once their pool is known, before any backend is tried
+func (h *Handler) SetMaintenance(m *maintenance.Mode) {
+	h.maintenance = m
+}
+
+// SetRewriter answers requests with redirects or rewrites their paths,
+// before they are routed
+func (h *Handler) SetRewriter(rw *rewrite.Rewriter) {
+	h.rewriter = rw
+}
+
+// SetRouter picks the pool of each request by its route; requests for the
+// default pool may still be split off to other pools by the traffic split.
+// The middleware of each route wraps the rest of the handling of its
+// requests.
+func (h *Handler) SetRouter(rt *route.Router) {
+	h.router = rt
+	h.chains = make(map[string]http.Handler)
+	for name, mws := range rt.Middleware() {
+		h.chains[name] = Chain(http.HandlerFunc(h.serveChained), mws...)
+	}
+}
+
+// SetHedging hedges slow GET and HEAD requests with a second attempt on
+// another backend
+func (h *Handler) SetHedging(p HedgePolicy) {
+	h.hedge = &hedger{policy: p}
+}
+
+// SetQueue holds requests that find no backend available in q for a while,
+// instead of failing them at once
+func (h *Handler) SetQueue(q *Queue) {
+	h.queue = q
+}
+
+// Protocols returns the per-protocol request counters of the handler
+func (h *Handler) Protocols() *metrics.ProtocolCounters {
+	return &h.protocols
+}
+
+// SetMetrics makes the handler record request metrics
+func (h *Handler) SetMetrics(m *metrics.Metrics) {
+	h.metrics = m
+}
+
+// SetTracer makes the handler trace requests and the attempts to serve them
+func (h *Handler) SetTracer(t *tracing.Tracer) {
+	h.tracer = t
+}
+
+// SetAccessLog makes the handler write an access log line per request
+func (h *Handler) SetAccessLog(l *accesslog.Logger) {
+	h.accessLog = l
+}
+
+// SetSampler makes the handler log only a sample of successful requests,
+// both in the access log and at Debug level
+func (h *Handler) SetSampler(s *accesslog.Sampler) {
+	h.sampler = s
+}
+
+// ServeHTTP forwards the request to the next available backend, retrying on failure
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ex := &exchange{start: time.Now()}
+	h.protocols.Observe(r)
+
+	// Decide once whether this request's lines are logged; retries are
+	// always logged, so a retried request is logged from its first retry on
+	ex.sampled = h.sampler.Sample(r.Header.Get("X-Request-Id"))
+
+	// Record the outcome once the response has been written
+	w = h.deadlines.apply(w, r)
+	if dw, ok := w.(*deadlineWriter); ok {
+		defer dw.finish()
+	}
+	if interval := flushInterval(h.flushes, r.URL.Path); interval != 0 {
+		fw := newFlushWriter(w, interval)
+		defer fw.stop()
+		w = fw
+	}
+	ex.rec = &responseRecorder{ResponseWriter: w}
+	w = ex.rec
+	// The access log shows the path as the client sent it, before any
+	// route rewrites it
+	path := r.URL.Path
+	h.metrics.RequestStarted()
+	defer func() {
+		duration := time.Since(ex.start)
+		h.metrics.RequestFinished(ex.served, ex.rec.Status(), max(ex.attempts-1, 0), duration)
+		if h.accessLog != nil && h.sampler.Keep(ex.sampled, ex.rec.Status(), ex.attempts) {
+			h.accessLog.Log(accesslog.Entry{
+				Time:       ex.start,
+				Method:     r.Method,
+				Path:       path,
+				Proto:      r.Proto,
+				ClientIP:   h.trusted.ClientIP(r),
+				Backend:    ex.served,
+				Route:      ex.routed.Route,
+				Fallback:   ex.fallback,
+				User:       ex.user,
+				ClientCert: clientCertSubject(r),
+				Status:     ex.rec.Status(),
+				Attempts:   ex.attempts,
+				Bytes:      ex.rec.Bytes(),
+				Duration:   duration,
+			})
+		}
+	}()
+
+	// Trace the request; every proxy attempt becomes a child span
+	ex.span = h.tracer.StartRequest(r, "proxy "+r.Method)
+	if ex.span != nil {
+		ex.span.SetString("http.request.method", r.Method)
+		ex.span.SetString("url.path", r.URL.Path)
+		defer func() {
+			ex.span.SetInt("nexus.attempts", ex.attempts)
+			ex.span.SetHTTPStatus(ex.rec.Status())
+			ex.span.End()
+		}()
+	}
+	// A panic is answered with a 500 before the outcome above is recorded
+	defer h.recoverPanic(w, r, ex)
+
+	// Redirects are answered without reading the body; rewrites change the
+	// path everything from here on sees
+	if h.rewriter.Handle(w, r) {
+		return
+	}
+
+	// Pick the route before anything else, so its middleware sees the
+	// request as it arrived
+	if h.router != nil {
+		var status int
+		if ex.routed, status = h.router.Route(r); status != 0 {
+			h.logger.Debug("request matched no route", "method", r.Method, "host", r.Host, "path", r.URL.Path, "status", status)
+			h.writeError(w, r, status)
+			return
+		}
+		if ex.routed.Route != "" {
+			defer func() { h.metrics.RouteRequestFinished(ex.routed.Route, ex.rec.Status()) }()
+			r = errorpage.WithRoute(r, ex.routed.Route)
+		}
+	}
+	if chain, ok := h.chains[ex.routed.Route]; ok {
+		chain.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), exchangeKey{}, ex)))
+		return
+	}
+	h.forward(w, r, ex)
+}
+
+// serveChained ends the middleware chain of a route
+func (h *Handler) serveChained(w http.ResponseWriter, r *http.Request) {
+	h.forward(w, r, r.Context().Value(exchangeKey{}).(*exchange))
+}
+
+// forward serves a routed request through the backends of its pool,
+// retrying on failure
+func (h *Handler) forward(w http.ResponseWriter, r *http.Request, ex *exchange) {
+	// Pick the pool once, by route and then by traffic split for the
+	// default pool; retries stay within it
+	group := ex.routed.Pool
+	if group == "" && h.split != nil {
+		group = h.split.Choose(w, r)
+	}
+	if h.grouped() {
+		defer func() { h.metrics.PoolRequestFinished(group, ex.rec.Status()) }()
+	}
+	// Again for the route and pool outcomes, should a backend hook panic
+	defer h.recoverPanic(w, r, ex)
+	if h.maintenance.Serve(w, r, group) {
+		return
+	}
+
+	// Refuse oversized bodies before any backend sees them; the route's
+	// middleware may have replaced the limit
+	limit := h.bodyLimits.limit(r.URL.Path)
+	if l, ok := bodyLimitFrom(r.Context()); ok {
+		limit = l
+	}
+	if !limitBody(ex.rec.ResponseWriter, r, limit) {
+		h.logger.Debug("request body too large", "method", r.Method, "path", r.URL.Path, "content_length", r.ContentLength)
+		h.writeError(w, r, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Buffer small bodies so a retry can send them again in full
+	replayable, err := bufferBody(r, h.maxReplay)
+	if err != nil {
+		h.logger.Debug("reading request body failed", "method", r.Method, "path", r.URL.Path, "error", err)
+		h.writeError(w, r, http.StatusBadRequest)
+		return
+	}
+	// Once a handshake reaches a backend the connection belongs to it, so
+	// upgrades are never retried
+	if backend.IsUpgrade(r) {
+		replayable = false
+	}
+	// Shadow copies take the buffered body, if there is one, and are sent
+	// in the background
+	h.mirror.Send(r)
+
+	// Shadows see the path as the client sent it, backends as the route
+	// rewrites it
+	ex.routed.Apply(r)
+
+	proxied := false
+	var lastErr error
+
+	// Every backend tried is excluded from the request's later attempts
+	var tried []*backend.Backend
+	queued := false
+
+	// Try up to maxRetries times to find a working backend
+	for ex.attempts < h.maxRetries {
+		ex.attempts++
+		w.Header().Set("X-Nexus-Attempts", strconv.Itoa(ex.attempts))
+
+		// Get the next available peer; once the route's pool has none
+		// left, its fallback pool takes over for the rest of the request
+		peer := h.nextPeer(group, tried)
+		if peer == nil && ex.fallback == "" && ex.routed.Fallback != "" {
+			ex.fallback, group = ex.routed.Fallback, ex.routed.Fallback
+			ex.span.AddEvent("falling back", "nexus.pool", group)
+			ex.sampled = true
+			h.logDedup.Log(h.logger, slog.LevelWarn, "fallback:"+ex.routed.Route, "no backend available, falling back",
+				"route", ex.routed.Route, "pool", ex.routed.Pool, "fallback", group)
+			defer func() { h.metrics.FallbackRequestFinished(ex.routed.Route, ex.fallback, ex.rec.Status()) }()
+			peer = h.nextPeer(group, tried)
+		}
+		if peer == nil && len(tried) > 0 {
+			// Each distinct candidate has had its chance
+			break
+		}
+		if peer == nil {
+			ex.span.AddEvent("no backend available")
+			ex.sampled = true
+			// Wait once for a backend to become available; the wait does
+			// not use up an attempt
+			if !queued && h.queue != nil {
+				queued = true
+				result, waited := h.queue.wait(r.Context(), func() bool { return h.hasPeer(group, tried) })
+				h.metrics.Queued(result, waited)
+				ex.span.AddEvent("queued", "nexus.queue.result", result)
+				if result == queueReady {
+					ex.attempts--
+					continue
+				}
+			}
+			h.logDedup.Log(h.logger, slog.LevelWarn, "no backend available",
+				"no backend available", "method", r.Method, "path", r.URL.Path, "status", http.StatusServiceUnavailable)
+			h.writeError(w, r, http.StatusServiceUnavailable)
+			return
+		}
+
+		tried = append(tried, peer)
+
+		// Check if backend is alive before proxying
+		if !peer.IsAlive() {
+			ex.span.AddEvent("backend down", "nexus.backend.url", peer.URL.String())
+			ex.sampled = true
+			h.logDedup.Log(h.logger, slog.LevelDebug, "down:"+peer.URL.String(), "backend is marked DOWN, trying next",
+				"method", r.Method, "path", r.URL.Path, "backend", peer.URL.String(), "attempt", ex.attempts)
+			continue
+		}
+
+		rewindBody(r)
+		proxied = true
+
+		// A failure is handed back for another try unless this is the last
+		// attempt or the body, once sent, cannot be sent again; then the
+		// backend's error response goes to the client as is
+		last := ex.attempts == h.maxRetries || !h.hasPeer(group, tried) ||
+			(h.retry.Budget > 0 && time.Since(ex.start) >= h.retry.Budget)
+		attempt := &backend.Attempt{Retry: replayable && !last, RetryStatus: h.retry.OnStatus}
+
+		// Log the request with backend information
+		if ex.sampled {
+			h.logger.Debug("proxying request",
+				"method", r.Method, "path", r.URL.Path, "backend", peer.URL.String(), "attempt", ex.attempts)
+		}
+
+		// Forward the request to the selected backend
+		// The custom transport will mark backend as DOWN if it fails
+		// Latency is measured per attempt so each backend's numbers
+		// reflect only its own work
+		ex.served = peer.URL.String()
+		var elapsed time.Duration
+		if h.hedge.eligible(r, replayable) {
+			ex.served, elapsed, attempt.Err = h.hedged(w, r, peer, attempt, group, &tried, &ex.attempts, ex.span)
+		} else {
+			elapsed = h.proxyAttempt(w, r, peer, attempt, ex.span, ex.attempts, ex.rec.Status)
+		}
+
+		if attempt.Err != nil {
+			if ex.rec.Written() {
+				// Part of a response already went out; it can neither be
+				// retried nor completed, so abort the client connection
+				panic(http.ErrAbortHandler)
+			}
+			ex.served = ""
+			lastErr = attempt.Err
+			ex.sampled = true
+			h.logDedup.Log(h.logger, slog.LevelDebug, "retry:"+peer.URL.String(), "attempt failed, trying next",
+				"method", r.Method, "path", r.URL.Path, "backend", peer.URL.String(), "attempt", ex.attempts, "error", attempt.Err)
+			if !h.retry.wait(r.Context(), ex.start, ex.attempts) {
+				break
+			}
+			continue
+		}
+
+		h.metrics.BackendServed(ex.served, elapsed)
+		return
+	}
+
+	// If we get here, all retries failed; a backend that was reached and
+	// failed makes it a bad gateway rather than an unavailable service
+	status := http.StatusServiceUnavailable
+	if lastErr != nil {
+		status = backend.FailureStatus(lastErr)
+	} else if proxied {
+		status = http.StatusBadGateway
+	}
+	h.logDedup.Log(h.logger, slog.LevelWarn, "all retries failed",
+		"all retries failed", "method", r.Method, "path", r.URL.Path, "status", status, "backends_tried", len(tried))
+	h.writeError(w, r, status)
+}
+
+// proxyAttempt makes one attempt at serving r on peer, tracing it as a
+// child of span, and returns how long it took; status reports the status
+// of the response once it has been relayed
+func (h *Handler) proxyAttempt(w http.ResponseWriter, r *http.Request, peer *backend.Backend,
+	attempt *backend.Attempt, span *tracing.Span, n int, status func() int) time.Duration {
+	attemptSpan := span.StartChild("proxy attempt", tracing.KindClient)
+	attemptSpan.SetString("nexus.backend.url", peer.URL.String())
+	attemptSpan.SetInt("nexus.attempt", n)
+	attemptStart := time.Now()
+	peer.ReverseProxy.ServeHTTP(w, backend.WithAttempt(attemptSpan.Inject(r), attempt))
+	elapsed := time.Since(attemptStart)
+	peer.ObserveLatency(elapsed)
+
+	if attempt.Err != nil {
+		attemptSpan.AddEvent("attempt failed", "error", attempt.Err.Error())
+		attemptSpan.SetHTTPStatus(backend.FailureStatus(attempt.Err))
+	} else {
+		attemptSpan.SetHTTPStatus(status())
+	}
+	attemptSpan.End()
+	return elapsed
+}
+
+// grouped reports whether requests are confined to the pool they are
+// routed or split to
+func (h *Handler) grouped() bool {
+	return h.split != nil || h.router != nil
+}
+
+// nextPeer returns the next backend for a request of the given pool that
+// has tried the backends in tried, or nil if none is left
+func (h *Handler) nextPeer(group string, tried []*backend.Backend) *backend.Backend {
+	if !h.grouped() {
+		return h.pool.NextPeerExcluding(tried)
+	}
+	return h.pool.NextPeerInGroup(group, tried)
+}
+
+// sparePeer is nextPeer for an extra attempt, leaving the rotation alone
+func (h *Handler) sparePeer(group string, tried []*backend.Backend) *backend.Backend {
+	if !h.grouped() {
+		return h.pool.SparePeerExcluding(tried)
+	}
+	return h.pool.SparePeerInGroup(group, tried)
+}
+
+// hasPeer reports whether nextPeer would find a backend
+func (h *Handler) hasPeer(group string, tried []*backend.Backend) bool {
+	if !h.grouped() {
+		return h.pool.HasPeerExcluding(tried)
+	}
+	return h.pool.HasPeerInGroup(group, tried)
+}
+
+// writeError answers with the configured error page for status, or a plain
+// text one
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, status int) {
+	if !h.errorPages.Render(w, r, status) {
+		http.Error(w, http.StatusText(status), status)
+	}
+}
+
+// clientCertSubject returns the subject of the certificate the client of r
+// presented over TLS, if any
+func clientCertSubject(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	return r.TLS.PeerCertificates[0].Subject.String()
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/errorpage"
+	"github.com/nexus-lb/nexus/internal/metrics"
+	"github.com/nexus-lb/nexus/internal/pool"
+)
+
+// testBackend is an upstream counting the requests it gets
+type testBackend struct {
+	*httptest.Server
+	hits atomic.Int64
+}
+
+func newTestBackend(t *testing.T, h http.HandlerFunc) *testBackend {
+	t.Helper()
+	tb := &testBackend{}
+	tb.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tb.hits.Add(1)
+		h(w, r)
+	}))
+	t.Cleanup(tb.Close)
+	return tb
+}
+
+// newTestPool creates a pool of backends for the given upstreams
+func newTestPool(t *testing.T, upstreams ...*testBackend) *pool.ServerPool {
+	t.Helper()
+	p := &pool.ServerPool{}
+	for _, u := range upstreams {
+		b, err := backend.NewBackend(u.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.AddBackend(b)
+	}
+	return p
+}
+
+func TestUnreadableBodyUsesErrorPages(t *testing.T) {
+	up := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {})
+	h := NewHandler(newTestPool(t, up), 3)
+	pages := errorpage.New()
+	if err := pages.Set(http.StatusBadRequest, "", `{"error": "bad body", "status": {{.Status}}}`); err != nil {
+		t.Fatal(err)
+	}
+	h.SetErrorPages(pages)
+	m := metrics.New(nil)
+	h.SetMetrics(m)
+
+	// The body ends before its declared length
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("abc"))
+	r.ContentLength = 10
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400", w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, `"bad body"`) {
+		t.Errorf("body %q is not the configured page", body)
+	}
+	if up.hits.Load() != 0 {
+		t.Error("the backend received the request")
+	}
+	if m.Requests() != 1 {
+		t.Errorf("%d requests counted, want 1", m.Requests())
+	}
+}
+
+func TestProxies(t *testing.T) {
+	up := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(append([]byte(r.Method+" "+r.URL.Path+" "), body...))
+	})
+	h := NewHandler(newTestPool(t, up), 3)
+	r := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader("hello"))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "POST /echo hello" {
+		t.Errorf("got %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("X-Nexus-Attempts") != "1" {
+		t.Errorf("X-Nexus-Attempts = %q, want 1", w.Header().Get("X-Nexus-Attempts"))
+	}
+}
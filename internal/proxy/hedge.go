@@ -0,0 +1,335 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/tracing"
+)
+
+// HedgePolicy sends a GET or HEAD request to a second backend when the
+// first has not answered within a delay, relaying whichever answers first
+type HedgePolicy struct {
+	// Delay is how long the first backend has to send its response headers
+	Delay time.Duration
+	// Percentile, if set, replaces Delay with this percentile (0.5, 0.9,
+	// 0.95 or 0.99) of the backend's latency over the last minute, once
+	// it has served requests in that minute
+	Percentile float64
+	// MaxOutstanding caps the hedges in flight at once
+	MaxOutstanding int
+	// MaxRatio caps the hedges at this fraction of the requests that could
+	// be hedged, so an incident slowing every backend cannot double the
+	// load on them
+	MaxRatio float64
+}
+
+// hedgeBurst is the most hedges the ratio budget saves up for
+const hedgeBurst = 10
+
+// hedger applies a HedgePolicy. A nil *hedger hedges nothing.
+type hedger struct {
+	policy      HedgePolicy
+	outstanding atomic.Int64
+
+	mu     sync.Mutex
+	tokens float64
+}
+
+// eligible reports whether r may be hedged: an idempotent read whose body,
+// if any, can be sent twice
+func (hd *hedger) eligible(r *http.Request, replayable bool) bool {
+	return hd != nil && replayable && (r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+		!backend.IsUpgrade(r)
+}
+
+// delay returns how long peer has to answer before a hedge is sent
+func (hd *hedger) delay(peer *backend.Backend) time.Duration {
+	if hd.policy.Percentile == 0 {
+		return hd.policy.Delay
+	}
+	recent := peer.RecentLatency(time.Minute)
+	if recent.Count == 0 {
+		return hd.policy.Delay
+	}
+	switch {
+	case hd.policy.Percentile <= 0.5:
+		return recent.P50
+	case hd.policy.Percentile <= 0.9:
+		return recent.P90
+	case hd.policy.Percentile <= 0.95:
+		return recent.P95
+	}
+	return recent.P99
+}
+
+// earn adds an eligible request's share to the ratio budget
+func (hd *hedger) earn() {
+	hd.mu.Lock()
+	defer hd.mu.Unlock()
+	hd.tokens = min(hd.tokens+hd.policy.MaxRatio, hedgeBurst)
+}
+
+// acquire takes a hedge out of both caps, reporting false if either is spent
+func (hd *hedger) acquire() bool {
+	if hd.outstanding.Add(1) > int64(hd.policy.MaxOutstanding) {
+		hd.outstanding.Add(-1)
+		return false
+	}
+	hd.mu.Lock()
+	defer hd.mu.Unlock()
+	if hd.tokens < 1 {
+		hd.outstanding.Add(-1)
+		return false
+	}
+	hd.tokens--
+	return true
+}
+
+// release returns a hedge that has finished
+func (hd *hedger) release() {
+	hd.outstanding.Add(-1)
+}
+
+// hedgeRace decides which of the attempts of a request reaches the client:
+// the first to write its response headers
+type hedgeRace struct {
+	w       http.ResponseWriter
+	mu      sync.Mutex
+	writers []*hedgeWriter
+	winner  *hedgeWriter
+	// stopped is set once an attempt panicked, so none can win any more
+	stopped bool
+}
+
+// add enters an attempt's writer in the race
+func (hr *hedgeRace) add(hw *hedgeWriter) {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	hr.writers = append(hr.writers, hw)
+}
+
+// setHeader sets a header of the client response, while no attempt has won
+func (hr *hedgeRace) setHeader(key, value string) {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	if hr.winner == nil {
+		hr.w.Header().Set(key, value)
+	}
+}
+
+func (hr *hedgeRace) decided() bool {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	return hr.winner != nil || hr.stopped
+}
+
+// stop ends the race without a winner, abandoning every attempt
+func (hr *hedgeRace) stop() {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	hr.stopped = true
+	for _, hw := range hr.writers {
+		if hw != hr.winner {
+			hw.cancel(backend.ErrAbandoned)
+		}
+	}
+}
+
+// hedgeWriter is the response writer of one attempt in a race. Until it
+// wins, the response is collected aside; a loser's response is discarded.
+type hedgeWriter struct {
+	race   *hedgeRace
+	header http.Header
+	cancel context.CancelCauseFunc
+	ctx    context.Context
+
+	// won and code are only touched by the attempt's own goroutine
+	won  bool
+	code int
+}
+
+func (hw *hedgeWriter) Header() http.Header { return hw.header }
+
+// claim makes the attempt the winner, if no other attempt is, sends its
+// headers to the client and abandons the other attempts
+func (hw *hedgeWriter) claim(code int) bool {
+	if hw.won {
+		return true
+	}
+	hr := hw.race
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	if hr.winner != nil || hr.stopped {
+		return false
+	}
+	hr.winner, hw.won, hw.code = hw, true, code
+	for _, other := range hr.writers {
+		if other != hw {
+			other.cancel(backend.ErrAbandoned)
+		}
+	}
+	for key, values := range hw.header {
+		hr.w.Header()[key] = values
+	}
+	hr.w.WriteHeader(code)
+	return true
+}
+
+func (hw *hedgeWriter) WriteHeader(code int) {
+	// Informational responses do not decide the race
+	if code >= 200 && !hw.claim(code) {
+		hw.cancel(backend.ErrAbandoned)
+	}
+}
+
+func (hw *hedgeWriter) Write(p []byte) (int, error) {
+	if !hw.claim(http.StatusOK) {
+		hw.cancel(backend.ErrAbandoned)
+		return len(p), nil
+	}
+	return hw.race.w.Write(p)
+}
+
+// abandoned reports whether another attempt answered first
+func (hw *hedgeWriter) abandoned() bool {
+	return !hw.won && errors.Is(context.Cause(hw.ctx), backend.ErrAbandoned)
+}
+
+// FlushError flushes the winner's response
+func (hw *hedgeWriter) FlushError() error {
+	if !hw.won {
+		return nil
+	}
+	return http.NewResponseController(hw.race.w).Flush()
+}
+
+// hedgeResult is the end of one attempt in a race
+type hedgeResult struct {
+	peer    *backend.Backend
+	writer  *hedgeWriter
+	hedge   bool
+	err     error
+	elapsed time.Duration
+	// panicked is the panic that ended the attempt, if one did
+	panicked *attemptPanic
+}
+
+// hedged proxies r to peer and, if peer has not sent its response headers
+// within the hedge delay, to the next backend as well, relaying whichever
+// answers first and abandoning the other. It returns the URL of the backend
+// that answered and how long it took, or the last failure if none did. The
+// hedge counts as an attempt and its backend as tried. Failures are always
+// handed back, but response statuses only count as failures while first,
+// the attempt being hedged, is to be retried.
+func (h *Handler) hedged(w http.ResponseWriter, r *http.Request, peer *backend.Backend, first *backend.Attempt,
+	group string, tried *[]*backend.Backend, attempts *int, span *tracing.Span) (string, time.Duration, error) {
+	var retryStatus []int
+	if first.Retry {
+		retryStatus = first.RetryStatus
+	}
+	h.hedge.earn()
+	race := &hedgeRace{w: w}
+	results := make(chan hedgeResult, 2)
+
+	start := func(b *backend.Backend, n int, hedge bool) {
+		ctx, cancel := context.WithCancelCause(r.Context())
+		hw := &hedgeWriter{race: race, header: make(http.Header), cancel: cancel, ctx: ctx}
+		race.add(hw)
+		req := r.WithContext(ctx)
+		if r.GetBody != nil {
+			req.Body, _ = r.GetBody()
+		}
+		attempt := &backend.Attempt{Retry: true, RetryStatus: retryStatus}
+		attemptSpan := span.StartChild("proxy attempt", tracing.KindClient)
+		attemptSpan.SetString("nexus.backend.url", b.URL.String())
+		attemptSpan.SetInt("nexus.attempt", n)
+		if hedge {
+			attemptSpan.AddEvent("hedge sent")
+		}
+		go func() {
+			defer cancel(nil)
+			if hedge {
+				defer h.hedge.release()
+			}
+			// A panic here would take the process down; it is handed to
+			// the request's goroutine instead, ErrAbortHandler included
+			defer func() {
+				if v := recover(); v != nil {
+					attemptSpan.AddEvent("attempt panicked")
+					attemptSpan.End()
+					results <- hedgeResult{peer: b, writer: hw, hedge: hedge,
+						panicked: &attemptPanic{value: v, stack: debug.Stack(), backend: b.URL.String()}}
+				}
+			}()
+			attemptStart := time.Now()
+			b.ReverseProxy.ServeHTTP(hw, backend.WithAttempt(attemptSpan.Inject(req), attempt))
+			elapsed := time.Since(attemptStart)
+			switch {
+			case hw.abandoned():
+				attemptSpan.AddEvent("attempt abandoned")
+			case attempt.Err != nil:
+				attemptSpan.AddEvent("attempt failed", "error", attempt.Err.Error())
+				attemptSpan.SetHTTPStatus(backend.FailureStatus(attempt.Err))
+			default:
+				b.ObserveLatency(elapsed)
+				attemptSpan.SetHTTPStatus(hw.code)
+			}
+			attemptSpan.End()
+			results <- hedgeResult{peer: b, writer: hw, hedge: hedge, err: attempt.Err, elapsed: elapsed}
+		}()
+	}
+
+	start(peer, *attempts, false)
+	timer := time.NewTimer(h.hedge.delay(peer))
+	defer timer.Stop()
+
+	pending := 1
+	var lastErr error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if race.decided() || !h.hedge.acquire() {
+				continue
+			}
+			next := h.sparePeer(group, *tried)
+			if next == nil {
+				h.hedge.release()
+				continue
+			}
+			*tried = append(*tried, next)
+			*attempts++
+			race.setHeader("X-Nexus-Attempts", strconv.Itoa(*attempts))
+			h.metrics.Hedged()
+			start(next, *attempts, true)
+			pending++
+
+		case res := <-results:
+			pending--
+			if p := res.panicked; p != nil {
+				race.stop()
+				if p.value == http.ErrAbortHandler {
+					panic(http.ErrAbortHandler)
+				}
+				panic(*p)
+			}
+			if res.writer.won {
+				if res.hedge && res.err == nil {
+					h.metrics.HedgeWon()
+				}
+				return res.peer.URL.String(), res.elapsed, res.err
+			}
+			if res.err != nil && !res.writer.abandoned() {
+				lastErr = res.err
+			}
+		}
+	}
+	return "", 0, lastErr
+}
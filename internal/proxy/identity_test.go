@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/pool"
+)
+
+func TestIdentityHeadersNameWinner(t *testing.T) {
+	bad := newTestBackend(t, dying(t, 0))
+	good := newTestBackend(t, flaky)
+	h := NewHandler(newTestPool(t, bad, good), 3)
+
+	// Within two requests one fails over from the dying backend
+	for range 2 {
+		w := serve(h, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200", w.Code)
+		}
+		if got := w.Header().Values("X-Backend-Server"); len(got) != 1 || got[0] != good.URL {
+			t.Errorf("X-Backend-Server = %q, want only %s", got, good.URL)
+		}
+		if got := w.Header().Get("X-Forwarded-By"); got != "Nexus" {
+			t.Errorf("X-Forwarded-By = %q, want Nexus", got)
+		}
+	}
+	if bad.hits.Load() != 1 {
+		t.Fatalf("dying backend hit %d times, want 1", bad.hits.Load())
+	}
+}
+
+func TestIdentityHeadersAllFailed(t *testing.T) {
+	t.Run("connection errors", func(t *testing.T) {
+		a := newTestBackend(t, dying(t, 0))
+		b := newTestBackend(t, dying(t, 0))
+		h := NewHandler(newTestPool(t, a, b), 3)
+
+		w := serve(h, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusBadGateway {
+			t.Fatalf("status %d, want 502", w.Code)
+		}
+		for _, name := range []string{"X-Backend-Server", "X-Forwarded-By"} {
+			if got := w.Header().Get(name); got != "" {
+				t.Errorf("%s = %q on an error Nexus answered itself", name, got)
+			}
+		}
+	})
+	t.Run("error statuses", func(t *testing.T) {
+		unavailable := func(name string) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				io.WriteString(w, name)
+			}
+		}
+		a := newTestBackend(t, unavailable("a"))
+		b := newTestBackend(t, unavailable("b"))
+		h := NewHandler(newTestPool(t, a, b), 3)
+		h.SetRetryPolicy(RetryPolicy{OnStatus: []int{http.StatusServiceUnavailable}})
+
+		// The last backend's response is relayed, naming it alone
+		w := serve(h, httptest.NewRequest(http.MethodGet, "/", nil))
+		last := map[string]string{"a": a.URL, "b": b.URL}[w.Body.String()]
+		if got := w.Header().Values("X-Backend-Server"); w.Code != http.StatusServiceUnavailable || len(got) != 1 || got[0] != last {
+			t.Errorf("got %d %q with X-Backend-Server %q, want the 503 of the backend naming it", w.Code, w.Body.String(), got)
+		}
+	})
+}
+
+func TestIdentityHeadersHidden(t *testing.T) {
+	up := newTestBackend(t, flaky)
+	p := &pool.ServerPool{}
+	b, err := backend.NewBackend(up.URL, backend.WithoutIdentityHeaders())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.AddBackend(b)
+	h := NewHandler(p, 3)
+
+	w := serve(h, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+	for _, name := range []string{"X-Backend-Server", "X-Forwarded-By"} {
+		if got := w.Header().Get(name); got != "" {
+			t.Errorf("%s = %q with identity headers hidden", name, got)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/errorpage"
+	"github.com/nexus-lb/nexus/internal/metrics"
+)
+
+// ConcurrencyLimiter is a counting semaphore around the proxy: beyond its
+// limit of requests in flight, requests are answered 503 with Retry-After
+// at once instead of queuing up on backend connections. Only the proxy
+// listener passes through it; probes answered ahead of it and the admin
+// API are never shed.
+type ConcurrencyLimiter struct {
+	next       http.Handler
+	retryAfter string
+	metrics    *metrics.Metrics
+	errorPages *errorpage.Pages
+
+	limit    atomic.Int64 // zero for no limit
+	inFlight atomic.Int64
+}
+
+// NewConcurrencyLimiter lets at most limit requests through to next at once;
+// zero lets every request through until SetLimit says otherwise. Rejected
+// clients are told to retry after retryAfter, rounded up to whole seconds.
+func NewConcurrencyLimiter(next http.Handler, limit int, retryAfter time.Duration, m *metrics.Metrics, p *errorpage.Pages) *ConcurrencyLimiter {
+	l := &ConcurrencyLimiter{
+		next:       next,
+		retryAfter: strconv.Itoa(int((retryAfter + time.Second - 1) / time.Second)),
+		metrics:    m,
+		errorPages: p,
+	}
+	l.limit.Store(int64(limit))
+	return l
+}
+
+// SetLimit changes the limit; requests already in flight are not affected
+func (l *ConcurrencyLimiter) SetLimit(limit int) {
+	l.limit.Store(int64(limit))
+}
+
+// Limit returns the current limit, zero meaning none
+func (l *ConcurrencyLimiter) Limit() int {
+	return int(l.limit.Load())
+}
+
+// InFlight returns the number of requests currently let through
+func (l *ConcurrencyLimiter) InFlight() int {
+	return int(l.inFlight.Load())
+}
+
+func (l *ConcurrencyLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := l.inFlight.Add(1)
+	defer l.inFlight.Add(-1)
+	if limit := l.limit.Load(); limit > 0 && n > limit {
+		l.metrics.Rejected("concurrency")
+		w.Header().Set("Retry-After", l.retryAfter)
+		if !l.errorPages.Render(w, r, http.StatusServiceUnavailable) {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		}
+		return
+	}
+	l.next.ServeHTTP(w, r)
+}
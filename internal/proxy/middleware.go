@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+)
+
+// Chain wraps h in mws, the first outermost, so that the middlewares see a
+// request in the order given
+func Chain(h http.Handler, mws ...func(http.Handler) http.Handler) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+type bodyLimitKey struct{}
+
+// BodyLimit replaces the handler's body limit with maxBytes for the requests
+// it wraps; zero lifts the limit. Unlike an http.MaxBytesReader of its own,
+// it can raise the limit as well as lower it.
+func BodyLimit(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), bodyLimitKey{}, maxBytes)))
+		})
+	}
+}
+
+// bodyLimitFrom returns the limit set by BodyLimit, if any
+func bodyLimitFrom(ctx context.Context) (int64, bool) {
+	limit, ok := ctx.Value(bodyLimitKey{}).(int64)
+	return limit, ok
+}
+
+// Timeout replaces the read and write timeouts of the listener for the
+// requests it wraps, counting from when they reach it; zero lifts a timeout.
+// Like the listener's own, the write timeout is a per-write timeout for
+// streaming responses.
+func Timeout(read, write time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rc := http.NewResponseController(w)
+			// Errors only mean the connection has no deadlines to change
+			rc.SetReadDeadline(deadlineAfter(read))
+			rc.SetWriteDeadline(deadlineAfter(write))
+			if dw := findDeadlineWriter(w); dw != nil {
+				dw.timeout = write
+			} else if write > 0 {
+				dw := &deadlineWriter{ResponseWriter: w, rc: rc, timeout: write}
+				defer dw.finish()
+				w = dw
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequestTimeout bounds the requests it wraps to d from when they reach it,
+// retries included. A backend that has not answered by then is cut off and
+// the client answered 504, as by the backend's own request timeout.
+func RequestTimeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeoutCause(r.Context(), d, backend.ErrUpstreamTimeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// SetUser records the user the middleware of r's route authenticated the
+// client as, for the access log. Outside a route's middleware it does
+// nothing.
+func SetUser(r *http.Request, user string) {
+	if ex, ok := r.Context().Value(exchangeKey{}).(*exchange); ok {
+		ex.user = user
+	}
+}
+
+// findDeadlineWriter returns the deadlineWriter w wraps, if any
+func findDeadlineWriter(w http.ResponseWriter) *deadlineWriter {
+	for {
+		switch u := w.(type) {
+		case *deadlineWriter:
+			return u
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = u.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/nexus-lb/nexus/internal/pool"
+)
+
+// Probes answers liveness and readiness probes for Nexus itself, ahead of
+// the load balancing handler so the probe paths never reach a backend
+type Probes struct {
+	pool     *pool.ServerPool
+	minAlive int
+	next     http.Handler
+	draining atomic.Bool
+}
+
+// NewProbes serves /healthz and /readyz and passes every other request to
+// next. Nexus is ready while at least minAlive backends are alive.
+func NewProbes(serverPool *pool.ServerPool, minAlive int, next http.Handler) *Probes {
+	return &Probes{
+		pool:     serverPool,
+		minAlive: minAlive,
+		next:     next,
+	}
+}
+
+// StartDraining makes /readyz fail from now on; call it when shutdown begins
+func (p *Probes) StartDraining() {
+	p.draining.Store(true)
+}
+
+func (p *Probes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/healthz":
+		// Answering at all means the process is serving
+		probeResponse(w, http.StatusOK, "ok")
+	case "/readyz":
+		switch {
+		case p.draining.Load():
+			probeResponse(w, http.StatusServiceUnavailable, "shutting down")
+		case p.aliveBackends() < p.minAlive:
+			probeResponse(w, http.StatusServiceUnavailable, "not enough backends alive")
+		default:
+			probeResponse(w, http.StatusOK, "ok")
+		}
+	default:
+		p.next.ServeHTTP(w, r)
+	}
+}
+
+// aliveBackends counts the backends currently able to take requests
+func (p *Probes) aliveBackends() int {
+	alive, _ := p.pool.GetPoolStatus()
+	return alive
+}
+
+// probeResponse writes a short plain-text probe answer that is never cached
+func probeResponse(w http.ResponseWriter, status int, body string) {
+	h := w.Header()
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write([]byte(body + "\n"))
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Results of waiting in the queue
+const (
+	queueReady    = "ready"    // a backend became available
+	queueTimeout  = "timeout"  // none did within the max wait
+	queueCanceled = "canceled" // the client went away
+	queueFull     = "full"     // the queue had no room
+)
+
+// queueRecheck is how often a waiting request looks again for a backend on
+// its own, for changes that nothing reports, such as a circuit breaker's
+// cooloff ending
+const queueRecheck = 20 * time.Millisecond
+
+// Queue holds requests that find no backend available for a short while,
+// so a pool that is mid-flap or briefly saturated delays them instead of
+// failing them. Waiters are woken by Notify whenever backends change state,
+// and give up at MaxWait or as soon as their client disconnects. A nil
+// *Queue holds nothing.
+type Queue struct {
+	maxDepth int64
+	maxWait  time.Duration
+	waiting  atomic.Int64
+
+	mu   sync.Mutex
+	wake chan struct{}
+}
+
+// NewQueue creates a queue of at most maxDepth requests, each waiting up to
+// maxWait
+func NewQueue(maxDepth int, maxWait time.Duration) *Queue {
+	return &Queue{
+		maxDepth: int64(maxDepth),
+		maxWait:  maxWait,
+		wake:     make(chan struct{}),
+	}
+}
+
+// Notify wakes every waiting request to look for a backend again; call it
+// when a backend comes up, joins the pool or otherwise changes state
+func (q *Queue) Notify() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+// Depth returns the number of requests waiting
+func (q *Queue) Depth() int {
+	if q == nil {
+		return 0
+	}
+	return int(q.waiting.Load())
+}
+
+// wait holds the request until ready reports a backend available, and returns
+// how that ended and how long it took
+func (q *Queue) wait(ctx context.Context, ready func() bool) (string, time.Duration) {
+	if q == nil || q.maxDepth <= 0 {
+		return queueFull, 0
+	}
+	if q.waiting.Add(1) > q.maxDepth {
+		q.waiting.Add(-1)
+		return queueFull, 0
+	}
+	defer q.waiting.Add(-1)
+
+	start := time.Now()
+	deadline := time.NewTimer(q.maxWait)
+	defer deadline.Stop()
+	recheck := time.NewTicker(queueRecheck)
+	defer recheck.Stop()
+	for {
+		q.mu.Lock()
+		wake := q.wake
+		q.mu.Unlock()
+		if ready() {
+			return queueReady, time.Since(start)
+		}
+		select {
+		case <-wake:
+		case <-recheck.C:
+		case <-deadline.C:
+			return queueTimeout, time.Since(start)
+		case <-ctx.Done():
+			return queueCanceled, time.Since(start)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import "net/http"
+
+// responseRecorder remembers the status code and body size of the response
+// written through it. Flushing and hijacking reach the underlying writer
+// through Unwrap.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WriteHeader records the final status code; informational responses other
+// than 101 Switching Protocols are passed through without being recorded
+func (r *responseRecorder) WriteHeader(code int) {
+	if r.status == 0 && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Status returns the recorded status code, or 200 if nothing was written
+func (r *responseRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// Written reports whether a response has started going out to the client
+func (r *responseRecorder) Written() bool {
+	return r.status != 0
+}
+
+// Bytes returns the number of body bytes written
+func (r *responseRecorder) Bytes() int64 {
+	return r.bytes
+}
@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// attemptPanic carries a panic out of the goroutine of a hedged attempt, to
+// be raised again in the request's own along with where it happened
+type attemptPanic struct {
+	value   any
+	stack   []byte
+	backend string
+}
+
+// recoverPanic turns a panic while serving r, in a handler or in a hook
+// such as ModifyResponse, into a 500 carrying the request ID, once the
+// panic is logged with its stack and counted. It must be deferred
+// directly. http.ErrAbortHandler, which aborts a response on purpose, is
+// passed on untouched; any other panic after the response has begun ends
+// in an abort too, as no error can be sent any more.
+func (h *Handler) recoverPanic(w http.ResponseWriter, r *http.Request, ex *exchange) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if v == http.ErrAbortHandler {
+		panic(v)
+	}
+	stack, served := debug.Stack(), ex.served
+	if ap, ok := v.(attemptPanic); ok {
+		v, stack, served = ap.value, ap.stack, ap.backend
+	}
+	id := r.Header.Get("X-Request-Id")
+	h.logger.Error("panic serving request", "method", r.Method, "path", r.URL.Path, "backend", served,
+		"route", ex.routed.Route, "request_id", id, "panic", fmt.Sprint(v), "stack", string(stack))
+	h.metrics.Panicked(served)
+	ex.sampled = true
+	if ex.rec.Written() {
+		panic(http.ErrAbortHandler)
+	}
+
+	const status = http.StatusInternalServerError
+	if h.errorPages.Render(w, r, status) {
+		return
+	}
+	if id == "" {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	w.Header().Set("X-Request-Id", id)
+	http.Error(w, http.StatusText(status)+" (request "+id+")", status)
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy decides how long the handler waits between attempts and which
+// failures it retries
+type RetryPolicy struct {
+	// Backoff is the pause before the first retry
+	Backoff time.Duration
+	// Exponential doubles the pause with every further retry, up to
+	// MaxBackoff; otherwise every pause is Backoff
+	Exponential bool
+	MaxBackoff  time.Duration
+	// Jitter randomizes each pause between half and all of its length, so
+	// clients failing together do not retry together
+	Jitter bool
+	// OnStatus lists backend response codes retried like connection errors
+	OnStatus []int
+	// Budget bounds the time a request may spend on attempts and the pauses
+	// between them; zero leaves it unbounded
+	Budget time.Duration
+}
+
+// DefaultRetryPolicy returns the policy Nexus has always used: a fixed 10ms
+// pause and only connection errors retried
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{Backoff: 10 * time.Millisecond}
+}
+
+// delay returns the pause before the given retry, counting from 1
+func (p RetryPolicy) delay(retry int) time.Duration {
+	d := p.Backoff
+	if p.Exponential {
+		for i := 1; i < retry && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+			d *= 2
+		}
+		if p.MaxBackoff > 0 {
+			d = min(d, p.MaxBackoff)
+		}
+	}
+	if p.Jitter && d > 1 {
+		d = d/2 + rand.N(d/2+1)
+	}
+	return d
+}
+
+// wait pauses before the given retry of a request that started at start. It
+// reports false, without waiting, if the pause would overrun the retry budget
+// or the client goes away.
+func (p RetryPolicy) wait(ctx context.Context, start time.Time, retry int) bool {
+	d := p.delay(retry)
+	if p.Budget > 0 && time.Since(start)+d >= p.Budget {
+		return false
+	}
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
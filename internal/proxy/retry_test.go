@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// dying reads the first n bytes of the request body and then drops the
+// connection without answering
+func dying(t *testing.T, n int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		io.ReadFull(r.Body, make([]byte, n))
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("hijack: %v", err)
+			return
+		}
+		conn.Close()
+	}
+}
+
+// echoBody answers with the request body it received
+func echoBody(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Write(body)
+}
+
+// serve sends r through h and returns the recorded response
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestRetryReplaysBody(t *testing.T) {
+	bad := newTestBackend(t, dying(t, 3))
+	good := newTestBackend(t, echoBody)
+	h := NewHandler(newTestPool(t, bad, good), 3)
+
+	// Within two requests the rotation sends one to the dying backend first
+	const body = "the complete request body"
+	for range 2 {
+		w := serve(h, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		if w.Code != http.StatusOK || w.Body.String() != body {
+			t.Fatalf("got %d %q, want the body echoed in full", w.Code, w.Body.String())
+		}
+	}
+	if bad.hits.Load() != 1 {
+		t.Errorf("dying backend hit %d times, want 1", bad.hits.Load())
+	}
+}
+
+func TestRetryWithoutBody(t *testing.T) {
+	bad := newTestBackend(t, dying(t, 0))
+	good := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "ok") })
+	h := NewHandler(newTestPool(t, bad, good), 3)
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		w := serve(h, httptest.NewRequest(method, "/", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, want 200", method, w.Code)
+		}
+	}
+	if bad.hits.Load() != 1 {
+		t.Errorf("dying backend hit %d times, want 1", bad.hits.Load())
+	}
+}
+
+func TestNoRetryForUnreplayableBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		size int64
+	}{
+		// Over the replay limit set below
+		{"large", "0123456789", 10},
+		// Of unknown length, as a chunked upload
+		{"streaming", "0123", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestBackend(t, dying(t, 3))
+			b := newTestBackend(t, dying(t, 3))
+			h := NewHandler(newTestPool(t, a, b), 3)
+			h.SetMaxReplayBody(4)
+
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			r.ContentLength = tt.size
+			w := serve(h, r)
+			if w.Code != http.StatusBadGateway {
+				t.Errorf("status %d, want 502", w.Code)
+			}
+			if hits := a.hits.Load() + b.hits.Load(); hits != 1 {
+				t.Errorf("backends hit %d times, want 1", hits)
+			}
+		})
+	}
+}
+
+// flaky fails requests for /flaky with a 503 and serves the rest
+func flaky(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/flaky" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	io.WriteString(w, "ok")
+}
+
+func TestRetryExcludesTriedBackends(t *testing.T) {
+	a := newTestBackend(t, flaky)
+	b := newTestBackend(t, flaky)
+	c := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "ok") })
+	h := NewHandler(newTestPool(t, a, b, c), 5)
+	h.SetRetryPolicy(RetryPolicy{OnStatus: []int{http.StatusServiceUnavailable}})
+
+	// Each request tries each flaky backend at most once on its way to the
+	// healthy one, so never needs more than three attempts
+	for range 6 {
+		w := serve(h, httptest.NewRequest(http.MethodGet, "/flaky", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200 from the healthy backend", w.Code)
+		}
+		if n, _ := strconv.Atoi(w.Header().Get("X-Nexus-Attempts")); n > 3 {
+			t.Errorf("%d attempts over three backends", n)
+		}
+	}
+	if c.hits.Load() != 6 {
+		t.Errorf("healthy backend hit %d times, want 6", c.hits.Load())
+	}
+	// The flaky backends stay up for other paths
+	if w := serve(h, httptest.NewRequest(http.MethodGet, "/other", nil)); w.Code != http.StatusOK {
+		t.Errorf("other path: status %d, want 200", w.Code)
+	}
+}
+
+func TestRetryExhaustsCandidates(t *testing.T) {
+	t.Run("error status", func(t *testing.T) {
+		a := newTestBackend(t, flaky)
+		b := newTestBackend(t, flaky)
+		h := NewHandler(newTestPool(t, a, b), 5)
+		h.SetRetryPolicy(RetryPolicy{OnStatus: []int{http.StatusServiceUnavailable}})
+
+		w := serve(h, httptest.NewRequest(http.MethodGet, "/flaky", nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("status %d, want the last backend's 503", w.Code)
+		}
+		if a.hits.Load() != 1 || b.hits.Load() != 1 || w.Header().Get("X-Nexus-Attempts") != "2" {
+			t.Errorf("hits %d and %d over %s attempts, want each backend tried once",
+				a.hits.Load(), b.hits.Load(), w.Header().Get("X-Nexus-Attempts"))
+		}
+	})
+	t.Run("connection error", func(t *testing.T) {
+		a := newTestBackend(t, dying(t, 0))
+		b := newTestBackend(t, dying(t, 0))
+		h := NewHandler(newTestPool(t, a, b), 5)
+
+		w := serve(h, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusBadGateway {
+			t.Errorf("status %d, want 502", w.Code)
+		}
+		if a.hits.Load() != 1 || b.hits.Load() != 1 {
+			t.Errorf("hits %d and %d, want each backend tried once", a.hits.Load(), b.hits.Load())
+		}
+	})
+}
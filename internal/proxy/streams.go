@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"sync"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+)
+
+// Streams tracks the long-lived requests going through it, WebSockets and
+// other upgrades along with server-sent event streams, so that shutdown can
+// give them a cutoff of their own instead of waiting for clients that never
+// finish
+type Streams struct {
+	next http.Handler
+
+	mu   sync.Mutex
+	open map[*streamWriter]struct{}
+}
+
+// NewStreams tracks the streams among the requests passed to next
+func NewStreams(next http.Handler) *Streams {
+	return &Streams{next: next, open: make(map[*streamWriter]struct{})}
+}
+
+// Count returns the number of streams currently open
+func (s *Streams) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.open)
+}
+
+// CancelAll cancels the context of every open stream, which ends its
+// backend request and with it the stream, and returns how many there were
+func (s *Streams) CancelAll() int {
+	s.mu.Lock()
+	open := make([]*streamWriter, 0, len(s.open))
+	for sw := range s.open {
+		open = append(open, sw)
+	}
+	s.mu.Unlock()
+	for _, sw := range open {
+		sw.cancel()
+	}
+	return len(open)
+}
+
+func (s *Streams) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	sw := &streamWriter{ResponseWriter: w, owner: s, cancel: cancel}
+	defer sw.untrack()
+	// Upgraded connections are hijacked without a WriteHeader, so they are
+	// known from the request
+	if backend.IsUpgrade(r) {
+		sw.track()
+	}
+	s.next.ServeHTTP(sw, r.WithContext(ctx))
+}
+
+// streamWriter registers its request as a stream once the response turns
+// out to be an event stream
+type streamWriter struct {
+	http.ResponseWriter
+	owner   *Streams
+	cancel  context.CancelFunc
+	tracked bool
+}
+
+func (sw *streamWriter) WriteHeader(code int) {
+	if code >= 200 && !sw.tracked {
+		if mediaType, _, _ := mime.ParseMediaType(sw.Header().Get("Content-Type")); mediaType == "text/event-stream" {
+			sw.track()
+		}
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *streamWriter) track() {
+	sw.tracked = true
+	sw.owner.mu.Lock()
+	sw.owner.open[sw] = struct{}{}
+	sw.owner.mu.Unlock()
+}
+
+func (sw *streamWriter) untrack() {
+	if !sw.tracked {
+		return
+	}
+	sw.owner.mu.Lock()
+	delete(sw.owner.open, sw)
+	sw.owner.mu.Unlock()
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (sw *streamWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
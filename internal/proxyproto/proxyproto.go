@@ -0,0 +1,217 @@
+// Package proxyproto reads the PROXY protocol header load balancers such as
+// AWS NLB and HAProxy put in front of a connection to pass on the address
+// of the client they accepted it from.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrMissingHeader is the error of a connection without a PROXY protocol
+// header on a listener that requires one
+var ErrMissingHeader = errors.New("proxyproto: connection did not send a PROXY protocol header")
+
+// v2Signature starts every version 2 header
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// Listener accepts connections whose RemoteAddr and LocalAddr are those the
+// PROXY protocol header names. Headers are read on the connection's first
+// use rather than in Accept, so a client that sends nothing holds up only
+// its own connection, and only until Timeout.
+type Listener struct {
+	net.Listener
+	// Required rejects connections that do not start with a header
+	Required bool
+	// Timeout bounds the wait for the header
+	Timeout time.Duration
+}
+
+// Accept waits for the next connection
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn, br: bufio.NewReader(conn), required: l.Required, timeout: l.Timeout}, nil
+}
+
+// Conn is a connection accepted by a Listener
+type Conn struct {
+	net.Conn
+	br       *bufio.Reader
+	required bool
+	timeout  time.Duration
+
+	once   sync.Once
+	source net.Addr
+	dest   net.Addr
+	err    error
+}
+
+// init reads the header, once
+func (c *Conn) init() {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+		c.source, c.dest, c.err = readHeader(c.br, c.required)
+		if c.err != nil {
+			c.Conn.Close()
+		}
+	})
+}
+
+// Read reads past the header. A missing or malformed header closes the
+// connection and fails every read.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.br.Read(p)
+}
+
+// RemoteAddr returns the client address the header names, or the peer's
+// if there was none
+func (c *Conn) RemoteAddr() net.Addr {
+	c.init()
+	if c.source != nil {
+		return c.source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to, as the header
+// names it, or the local end of the connection if there was none
+func (c *Conn) LocalAddr() net.Addr {
+	c.init()
+	if c.dest != nil {
+		return c.dest
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readHeader consumes a version 1 or 2 header from br. Both addresses are
+// nil for headers that carry none, and for connections without a header
+// when it is not required.
+func readHeader(br *bufio.Reader, required bool) (source, dest net.Addr, err error) {
+	first, err := br.Peek(1)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case first[0] == 'P':
+		if prefix, err := br.Peek(6); err == nil && string(prefix) == "PROXY " {
+			return readV1(br)
+		}
+	case first[0] == '\r':
+		if prefix, err := br.Peek(len(v2Signature)); err == nil && bytes.Equal(prefix, v2Signature) {
+			return readV2(br)
+		}
+	}
+	if required {
+		return nil, nil, ErrMissingHeader
+	}
+	return nil, nil, nil
+}
+
+// readV1 parses a text header such as "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+func readV1(br *bufio.Reader) (source, dest net.Addr, err error) {
+	// The longest valid header is 107 bytes
+	var line []byte
+	for len(line) < 107 {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, nil, errors.New("proxyproto: v1 header is not terminated by CRLF")
+	}
+	fields := strings.Split(text, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("proxyproto: malformed v1 header %q", text)
+	}
+	src, err1 := parseV1Addr(fields[2], fields[4])
+	dst, err2 := parseV1Addr(fields[3], fields[5])
+	if err := errors.Join(err1, err2); err != nil {
+		return nil, nil, fmt.Errorf("proxyproto: malformed v1 header %q: %w", text, err)
+	}
+	return src, dst, nil
+}
+
+func parseV1Addr(ip, port string) (net.Addr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(p))), nil
+}
+
+// readV2 parses a binary header
+func readV2(br *bufio.Reader) (source, dest net.Addr, err error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(br, fixed[:]); err != nil {
+		return nil, nil, err
+	}
+	verCmd, family := fixed[12], fixed[13]
+	length := int(binary.BigEndian.Uint16(fixed[14:16]))
+	if verCmd>>4 != 2 {
+		return nil, nil, fmt.Errorf("proxyproto: unsupported v2 version %d", verCmd>>4)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, nil, err
+	}
+
+	// LOCAL connections, such as the balancer's own health checks, and
+	// address families other than TCP keep the peer's address
+	if verCmd&0x0f == 0x0 {
+		return nil, nil, nil
+	}
+	if verCmd&0x0f != 0x1 {
+		return nil, nil, fmt.Errorf("proxyproto: unsupported v2 command %d", verCmd&0x0f)
+	}
+	var size int
+	switch family {
+	case 0x11: // TCP over IPv4
+		size = 4
+	case 0x21: // TCP over IPv6
+		size = 16
+	default:
+		return nil, nil, nil
+	}
+	if length < 2*size+4 {
+		return nil, nil, errors.New("proxyproto: v2 header too short for its addresses")
+	}
+	srcIP, _ := netip.AddrFromSlice(payload[:size])
+	dstIP, _ := netip.AddrFromSlice(payload[size : 2*size])
+	srcPort := binary.BigEndian.Uint16(payload[2*size:])
+	dstPort := binary.BigEndian.Uint16(payload[2*size+2:])
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(srcIP, srcPort)),
+		net.TCPAddrFromAddrPort(netip.AddrPortFrom(dstIP, dstPort)), nil
+}
@@ -0,0 +1,206 @@
+// Package ratelimit limits the request rate of each client IP with a token
+// bucket, so a single misbehaving client cannot crowd out the others
+package ratelimit
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/metrics"
+)
+
+// Options configure a Limiter
+type Options struct {
+	// Rate is the sustained number of requests per second a client may make
+	Rate float64
+	// Burst is how many requests a client may make at once after idling
+	Burst int
+	// MaxClients bounds the clients tracked at once; the least recently
+	// seen is forgotten to make room, starting over with a full bucket
+	MaxClients int
+	// Exempt lists the networks whose clients are never limited
+	Exempt []netip.Prefix
+	// Trusted resolves the client IP behind trusted proxies, like the
+	// access log does
+	Trusted backend.TrustedProxies
+	Metrics *metrics.Metrics
+}
+
+// Limiter answers clients over their rate with 429 and Retry-After and
+// passes every other request to the next handler
+type Limiter struct {
+	next http.Handler
+	o    Options
+
+	mu      sync.Mutex
+	clients map[string]*list.Element
+	order   *list.List // of *bucket, most recently seen first
+}
+
+// bucket is the token bucket of one client
+type bucket struct {
+	ip      string
+	tokens  float64
+	last    time.Time
+	limited uint64
+	// violations counts the malformed requests the client was refused
+	violations uint64
+}
+
+// New creates a limiter in front of next
+func New(next http.Handler, o Options) *Limiter {
+	return &Limiter{
+		next:    next,
+		o:       o,
+		clients: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// ParseExempt parses CIDRs such as "10.0.0.0/8"; a bare address is taken
+// as a single host
+func ParseExempt(cidrs []string) ([]netip.Prefix, error) {
+	exempt := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(cidr)
+			if addrErr != nil {
+				return nil, fmt.Errorf("exempt network %q: %w", cidr, err)
+			}
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		exempt = append(exempt, p.Masked())
+	}
+	return exempt, nil
+}
+
+func (l *Limiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ip := l.o.Trusted.ClientIP(r)
+	if l.exempt(ip) {
+		l.next.ServeHTTP(w, r)
+		return
+	}
+	if wait, ok := l.take(ip, time.Now()); !ok {
+		l.o.Metrics.Rejected("rate_limit")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+	l.next.ServeHTTP(w, r)
+}
+
+// exempt reports whether ip lies in one of the exempt networks
+func (l *Limiter) exempt(ip string) bool {
+	if len(l.o.Exempt) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range l.o.Exempt {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// take spends a token of ip's bucket, or reports how long until one is
+// available
+func (l *Limiter) take(ip string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.bucket(ip, now)
+	l.refill(b, now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	b.limited++
+	return time.Duration((1 - b.tokens) / l.o.Rate * float64(time.Second)), false
+}
+
+// refill adds the tokens earned since b was last used; the caller holds mu
+func (l *Limiter) refill(b *bucket, now time.Time) {
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*l.o.Rate, float64(l.o.Burst))
+	b.last = now
+}
+
+// Penalize charges ip for a malformed request refused before reaching the
+// limiter: it costs the client a whole burst, so one sending them is held
+// to the sustained rate, down to a debt of another burst
+func (l *Limiter) Penalize(ip string) {
+	if l.exempt(ip) {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	b := l.bucket(ip, now)
+	l.refill(b, now)
+	b.tokens = max(b.tokens-float64(l.o.Burst), -float64(l.o.Burst))
+	b.violations++
+}
+
+// bucket returns the bucket of ip, creating it full and evicting the least
+// recently seen client if there are too many; the caller holds mu
+func (l *Limiter) bucket(ip string, now time.Time) *bucket {
+	if e, ok := l.clients[ip]; ok {
+		l.order.MoveToFront(e)
+		return e.Value.(*bucket)
+	}
+	if l.order.Len() >= max(l.o.MaxClients, 1) {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.clients, oldest.Value.(*bucket).ip)
+	}
+	b := &bucket{ip: ip, tokens: float64(l.o.Burst), last: now}
+	l.clients[ip] = l.order.PushFront(b)
+	return b
+}
+
+// Limited returns how many requests of each tracked client were limited,
+// leaving out clients that never were
+func (l *Limiter) Limited() map[string]uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limited := make(map[string]uint64)
+	for e := l.order.Front(); e != nil; e = e.Next() {
+		if b := e.Value.(*bucket); b.limited > 0 {
+			limited[b.ip] = b.limited
+		}
+	}
+	return limited
+}
+
+// Violations returns how many malformed requests of each tracked client
+// were refused, leaving out clients that sent none
+func (l *Limiter) Violations() map[string]uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	violations := make(map[string]uint64)
+	for e := l.order.Front(); e != nil; e = e.Next() {
+		if b := e.Value.(*bucket); b.violations > 0 {
+			violations[b.ip] = b.violations
+		}
+	}
+	return violations
+}
+
+// Middleware limits the requests it wraps like New; every handler it wraps
+// gets buckets of its own
+func Middleware(o Options) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return New(next, o)
+	}
+}
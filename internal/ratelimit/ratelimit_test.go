@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newLimiter(o Options) *Limiter {
+	return New(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), o)
+}
+
+func serve(l *Limiter, remote, xff string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = remote
+	if xff != "" {
+		r.Header.Set("X-Forwarded-For", xff)
+	}
+	w := httptest.NewRecorder()
+	l.ServeHTTP(w, r)
+	return w
+}
+
+func TestBurstThenLimited(t *testing.T) {
+	l := newLimiter(Options{Rate: 1, Burst: 2, MaxClients: 10})
+	for i := range 2 {
+		if w := serve(l, "192.0.2.1:4000", ""); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i, w.Code)
+		}
+	}
+	w := serve(l, "192.0.2.1:4000", "")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Retry-After = %q, want 1", w.Header().Get("Retry-After"))
+	}
+	// Another client has a bucket of its own
+	if w := serve(l, "192.0.2.2:4000", ""); w.Code != http.StatusOK {
+		t.Errorf("other client: status %d, want 200", w.Code)
+	}
+	if got := l.Limited(); got["192.0.2.1"] != 1 || len(got) != 1 {
+		t.Errorf("Limited = %v", got)
+	}
+}
+
+func TestRefill(t *testing.T) {
+	l := newLimiter(Options{Rate: 2, Burst: 1, MaxClients: 10})
+	now := time.Unix(1000, 0)
+	if _, ok := l.take("192.0.2.1", now); !ok {
+		t.Fatal("first request limited")
+	}
+	wait, ok := l.take("192.0.2.1", now)
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("take = %v, %v; want 500ms, false", wait, ok)
+	}
+	if _, ok := l.take("192.0.2.1", now.Add(500*time.Millisecond)); !ok {
+		t.Fatal("request limited after the bucket refilled")
+	}
+}
+
+func TestForgedForwardedFor(t *testing.T) {
+	// trusted_proxies unset: only loopback peers are believed
+	l := newLimiter(Options{Rate: 1, Burst: 1, MaxClients: 10})
+	serve(l, "203.0.113.9:4000", "198.51.100.1")
+	// A fresh forged address each time does not buy a fresh bucket
+	if w := serve(l, "203.0.113.9:4000", "198.51.100.2"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("rotating forged addresses: status %d, want 429", w.Code)
+	}
+	if _, ok := l.Limited()["198.51.100.2"]; ok {
+		t.Error("the forged address was charged instead of the peer")
+	}
+}
+
+func TestForgedExemptAddress(t *testing.T) {
+	exempt, err := ParseExempt([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := newLimiter(Options{Rate: 1, Burst: 1, MaxClients: 10, Exempt: exempt})
+	serve(l, "203.0.113.9:4000", "10.0.0.1")
+	if w := serve(l, "203.0.113.9:4000", "10.0.0.1"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("forged exempt address: status %d, want 429", w.Code)
+	}
+	for range 3 {
+		if w := serve(l, "10.0.0.1:4000", ""); w.Code != http.StatusOK {
+			t.Fatalf("exempt client: status %d, want 200", w.Code)
+		}
+	}
+}
+
+func TestPenalize(t *testing.T) {
+	l := newLimiter(Options{Rate: 1, Burst: 3, MaxClients: 10})
+	l.Penalize("192.0.2.1")
+	if _, ok := l.take("192.0.2.1", time.Now()); ok {
+		t.Error("penalized client was not limited")
+	}
+	if got := l.Violations(); got["192.0.2.1"] != 1 {
+		t.Errorf("Violations = %v", got)
+	}
+}
+
+func TestMaxClients(t *testing.T) {
+	l := newLimiter(Options{Rate: 1, Burst: 1, MaxClients: 2})
+	now := time.Now()
+	for i := range 3 {
+		l.take(fmt.Sprintf("192.0.2.%d", i), now)
+	}
+	if n := len(l.clients); n != 2 {
+		t.Fatalf("tracking %d clients, want 2", n)
+	}
+	// The least recently seen was forgotten and starts over
+	if _, ok := l.take("192.0.2.0", now); !ok {
+		t.Error("evicted client did not start with a full bucket")
+	}
+}
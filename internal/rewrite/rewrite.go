@@ -0,0 +1,173 @@
+// Package rewrite answers requests with redirects and rewrites their paths
+// by regular expression, ahead of routing, so edge chores such as sending
+// clients to HTTPS never involve a backend
+package rewrite
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// MaxPasses bounds how many times a request's path is rewritten. Rules are
+// tried again from the first after every rewrite, so a rule whose output it
+// matches itself would otherwise loop forever.
+const MaxPasses = 10
+
+// Rule redirects or rewrites the requests whose path Path matches in full.
+// Path is matched against the path as the client encoded it, and the
+// target may refer to its groups as $1 or ${name} and to the request's
+// host as ${host}. Exactly one of Redirect and Rewrite is set.
+type Rule struct {
+	// Scheme, if set, limits the rule to "http" or "https" requests
+	Scheme string
+	// Path is a regular expression; empty matches every path
+	Path string
+	// Redirect is the URL or path clients are sent to with Status
+	Redirect string
+	// Status is the redirect's status code (default 301)
+	Status int
+	// Rewrite is the path the request continues with
+	Rewrite string
+}
+
+// Rewriter applies an ordered set of rules. A nil *Rewriter passes every
+// request through untouched.
+type Rewriter struct {
+	rules  []rule
+	logger *slog.Logger
+}
+
+type rule struct {
+	Rule
+	re *regexp.Regexp
+}
+
+// New creates a rewriter; rules are tried in order
+func New(rules []Rule) (*Rewriter, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	rw := &Rewriter{logger: slog.Default().With("component", "rewrite")}
+	for i, ru := range rules {
+		if (ru.Redirect == "") == (ru.Rewrite == "") {
+			return nil, fmt.Errorf("rule %d needs exactly one of redirect and rewrite", i)
+		}
+		if ru.Rewrite != "" && !strings.HasPrefix(ru.Rewrite, "/") {
+			return nil, fmt.Errorf("rule %d: rewrite %q must start with /", i, ru.Rewrite)
+		}
+		if ru.Redirect != "" && ru.Status == 0 {
+			ru.Status = http.StatusMovedPermanently
+		}
+		pattern := ru.Path
+		if pattern == "" {
+			pattern = ".*"
+		}
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		rw.rules = append(rw.rules, rule{Rule: ru, re: re})
+	}
+	return rw, nil
+}
+
+// Handle applies the rules to r. It rewrites r's path in place and returns
+// false for the request to go on, or answers it itself, with a redirect or
+// a 500 if the rules loop, and returns true.
+func (rw *Rewriter) Handle(w http.ResponseWriter, r *http.Request) bool {
+	if rw == nil {
+		return false
+	}
+	path, query := rawPath(r), r.URL.RawQuery
+	rewritten := false
+	for pass := 0; ; pass++ {
+		ru, target := rw.match(r, path)
+		switch {
+		case ru == nil:
+			if rewritten {
+				setPath(r, path, query)
+			}
+			return false
+		case ru.Redirect != "":
+			target, own, _ := strings.Cut(target, "?")
+			if q := joinQuery(own, query); q != "" {
+				target += "?" + q
+			}
+			http.Redirect(w, r, target, ru.Status)
+			return true
+		case pass == MaxPasses:
+			rw.logger.Warn("rewrite loop, giving up", "path", r.URL.Path, "rule", ru.Path, "passes", MaxPasses)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return true
+		}
+		// A query in the target goes ahead of the request's own
+		next, own, _ := strings.Cut(target, "?")
+		query = joinQuery(own, query)
+		rewritten = true
+		// A rewrite that leaves the path as it was would loop at once
+		if next == path {
+			setPath(r, path, query)
+			return false
+		}
+		path = next
+	}
+}
+
+// match returns the first rule matching path and its expanded target
+func (rw *Rewriter) match(r *http.Request, path string) (*rule, string) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	for i := range rw.rules {
+		ru := &rw.rules[i]
+		if ru.Scheme != "" && ru.Scheme != scheme {
+			continue
+		}
+		groups := ru.re.FindStringSubmatchIndex(path)
+		if groups == nil {
+			continue
+		}
+		template := ru.Rewrite
+		if ru.Redirect != "" {
+			template = ru.Redirect
+		}
+		// A literal $ in the host would read as a group reference
+		template = strings.ReplaceAll(template, "${host}", strings.ReplaceAll(r.Host, "$", "$$"))
+		return ru, string(ru.re.ExpandString(nil, template, path, groups))
+	}
+	return nil, ""
+}
+
+// rawPath returns the path of r as the client encoded it
+func rawPath(r *http.Request) string {
+	if uri := r.RequestURI; strings.HasPrefix(uri, "/") {
+		path, _, _ := strings.Cut(uri, "?")
+		return path
+	}
+	return r.URL.EscapedPath()
+}
+
+// setPath gives r the encoded path and query
+func setPath(r *http.Request, path, query string) {
+	if decoded, err := url.PathUnescape(path); err == nil {
+		r.URL.Path, r.URL.RawPath = decoded, path
+	} else {
+		// Not valid escaping; take the target as the decoded path
+		r.URL.Path, r.URL.RawPath = path, ""
+	}
+	r.URL.RawQuery = query
+	r.RequestURI = r.URL.RequestURI()
+}
+
+// joinQuery joins two encoded queries, either of which may be empty
+func joinQuery(first, second string) string {
+	if first == "" || second == "" {
+		return first + second
+	}
+	return first + "&" + second
+}
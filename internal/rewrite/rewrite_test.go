@@ -0,0 +1,140 @@
+package rewrite
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func mustNew(t *testing.T, rules ...Rule) *Rewriter {
+	t.Helper()
+	rw, err := New(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rw
+}
+
+func TestRedirects(t *testing.T) {
+	rw := mustNew(t,
+		Rule{Scheme: "http", Redirect: "https://${host}$0"},
+		Rule{Path: "/old-path", Redirect: "/new-path", Status: http.StatusPermanentRedirect},
+		Rule{Path: "/docs/(.*)", Redirect: "/manual/$1?from=docs", Status: http.StatusFound},
+	)
+	tests := []struct {
+		name     string
+		target   string
+		https    bool
+		status   int
+		location string
+	}{
+		{"to https", "http://example.com/a/b?x=1", false, http.StatusMovedPermanently, "https://example.com/a/b?x=1"},
+		{"to https keeps encoding", "http://example.com/a%20b%2Fc?q=a%26b", false, http.StatusMovedPermanently, "https://example.com/a%20b%2Fc?q=a%26b"},
+		{"moved path", "https://example.com/old-path?x=1&y=%20", true, http.StatusPermanentRedirect, "/new-path?x=1&y=%20"},
+		{"groups and target query", "https://example.com/docs/a%2Fb?page=2", true, http.StatusFound, "/manual/a%2Fb?from=docs&page=2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.https {
+				r.TLS = &tls.ConnectionState{}
+			}
+			w := httptest.NewRecorder()
+			if !rw.Handle(w, r) {
+				t.Fatal("request passed through")
+			}
+			if w.Code != tt.status || w.Header().Get("Location") != tt.location {
+				t.Errorf("got %d to %q, want %d to %q", w.Code, w.Header().Get("Location"), tt.status, tt.location)
+			}
+		})
+	}
+}
+
+func TestRewrites(t *testing.T) {
+	rw := mustNew(t,
+		Rule{Path: "/v1/(.*)", Rewrite: "/api/v1/$1"},
+		Rule{Path: "/find", Rewrite: "/search?src=legacy"},
+		Rule{Path: "/same", Rewrite: "/same"},
+	)
+	tests := []struct {
+		name       string
+		target     string
+		path       string
+		rawPath    string
+		query      string
+		requestURI string
+	}{
+		{"capture group", "/v1/users?id=7", "/api/v1/users", "/api/v1/users", "id=7", "/api/v1/users?id=7"},
+		{"encoded characters", "/v1/a%20b%2Fc?q=a%26b", "/api/v1/a b/c", "/api/v1/a%20b%2Fc", "q=a%26b", "/api/v1/a%20b%2Fc?q=a%26b"},
+		{"target query first", "/find?q=x", "/search", "/search", "src=legacy&q=x", "/search?src=legacy&q=x"},
+		{"rewrite to itself", "/same?q=1", "/same", "/same", "q=1", "/same?q=1"},
+		{"no match", "/other%2Fpath?q=1", "/other/path", "/other%2Fpath", "q=1", "/other%2Fpath?q=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if rw.Handle(httptest.NewRecorder(), r) {
+				t.Fatal("request answered instead of rewritten")
+			}
+			if r.URL.Path != tt.path || r.URL.EscapedPath() != tt.rawPath || r.URL.RawQuery != tt.query || r.RequestURI != tt.requestURI {
+				t.Errorf("rewritten to path %q (%q), query %q, URI %q", r.URL.Path, r.URL.EscapedPath(), r.URL.RawQuery, r.RequestURI)
+			}
+		})
+	}
+}
+
+func TestRewriteThenRedirect(t *testing.T) {
+	// Rules are tried again after a rewrite, so a later redirect sees the
+	// rewritten path
+	rw := mustNew(t,
+		Rule{Path: "/legacy/(.*)", Rewrite: "/old-path/$1"},
+		Rule{Path: "/old-path/(.*)", Redirect: "/new/$1"},
+	)
+	r := httptest.NewRequest(http.MethodGet, "/legacy/x?y=1", nil)
+	w := httptest.NewRecorder()
+	if !rw.Handle(w, r) || w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/new/x?y=1" {
+		t.Errorf("got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestLoopsCapped(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  string
+		rules []Rule
+	}{
+		{"growing", "/a", []Rule{{Path: "/a(.*)", Rewrite: "/a/$1"}}},
+		{"ping-pong", "/x", []Rule{{Path: "/x", Rewrite: "/y"}, {Path: "/y", Rewrite: "/x"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if !mustNew(t, tt.rules...).Handle(w, httptest.NewRequest(http.MethodGet, tt.path, nil)) || w.Code != http.StatusInternalServerError {
+				t.Errorf("looping rules gave %d, want 500", w.Code)
+			}
+		})
+	}
+}
+
+func TestNilPassesThrough(t *testing.T) {
+	rw := mustNew(t)
+	r := httptest.NewRequest(http.MethodGet, "/v1/x?y=1", nil)
+	if rw.Handle(httptest.NewRecorder(), r) || r.URL.Path != "/v1/x" || r.URL.RawQuery != "y=1" {
+		t.Errorf("nil rewriter changed the request to %s", r.URL)
+	}
+}
+
+func TestNewRejectsBadRules(t *testing.T) {
+	tests := map[string]Rule{
+		"both actions":     {Path: "/a", Redirect: "/b", Rewrite: "/c"},
+		"no action":        {Path: "/a"},
+		"relative rewrite": {Path: "/a", Rewrite: "b"},
+		"bad expression":   {Path: "/a(", Rewrite: "/b"},
+	}
+	for name, ru := range tests {
+		if _, err := New([]Rule{ru}); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
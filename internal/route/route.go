@@ -0,0 +1,558 @@
+// Package route picks the pool of backends that serves a request, from the
+// host it was sent to, its path, method and headers, ahead of load balancing
+// within the pool
+package route
+
+import (
+	"cmp"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// HostRoute sends requests for a host to a pool. Host is an exact name such
+// as "api.example.com" or a wildcard such as "*.example.com", which matches
+// any name below example.com but not example.com itself.
+type HostRoute struct {
+	Host string
+	// Pool is the pool of backends serving the host; "" for the default
+	Pool string
+	// Fallback, if set, names the pool serving the host while Pool has no
+	// backend available
+	Fallback string
+	// Middleware, if not nil, replaces the pool's middleware for the route
+	Middleware []func(http.Handler) http.Handler
+}
+
+// PathRoute sends requests whose path starts with Prefix to a pool.
+// Prefixes match whole path segments, and a trailing slash makes no
+// difference: "/api" and "/api/" both match /api, /api/ and /api/users but
+// not /apis.
+type PathRoute struct {
+	// Name labels the route in metrics and the access log (default the
+	// host, if any, and the prefix)
+	Name string
+	// Host, if set, limits the route to one host, exact or wildcard
+	Host   string
+	Prefix string
+	Pool   string
+	// Fallback, if set, names the pool serving the route while Pool has no
+	// backend available
+	Fallback string
+	// StripPrefix removes the prefix from the path sent to the backend
+	StripPrefix bool
+	// Middleware, if not nil, replaces the pool's middleware for the route
+	Middleware []func(http.Handler) http.Handler
+}
+
+// Rule sends the requests that meet all of its conditions to a pool; a rule
+// without conditions matches every request. Unlike path and host routes,
+// rules are tried in the order given, and the first to match wins.
+type Rule struct {
+	// Name labels the rule in metrics and the access log (default
+	// "rules[i]", i counting from 0)
+	Name string
+	// Host, if set, limits the rule to one host, exact or wildcard
+	Host string
+	// PathPrefix, if set, limits the rule to the paths under it, matched
+	// like the prefix of a PathRoute
+	PathPrefix string
+	// Methods, if set, limits the rule to these request methods
+	Methods []string
+	// Headers must all match
+	Headers []HeaderMatch
+	Pool    string
+	// Fallback, if set, names the pool serving the rule while Pool has no
+	// backend available
+	Fallback string
+	// StripPrefix removes PathPrefix from the path sent to the backend
+	StripPrefix bool
+	// Middleware, if not nil, replaces the pool's middleware for the rule
+	Middleware []func(http.Handler) http.Handler
+}
+
+// HeaderMatch is met by a request with a Name header whose value is Value,
+// or, if Regex is set instead, whose whole value Regex matches
+type HeaderMatch struct {
+	Name  string
+	Value string
+	Regex string
+}
+
+// Shadow reports a rule that can never match, because an earlier rule
+// matches every request it would
+type Shadow struct {
+	Rule string
+	By   string
+}
+
+// Options configure a Router
+type Options struct {
+	Rules []Rule
+	Hosts []HostRoute
+	Paths []PathRoute
+	// RejectNoHost refuses requests without a Host header with 421 instead
+	// of routing them by path alone
+	RejectNoHost bool
+	// RejectNoMatch refuses requests no route matches with 404 instead of
+	// sending them to the default pool
+	RejectNoMatch bool
+	// PoolMiddleware is the middleware of the routes to each pool that
+	// have none of their own; that of the default pool, "", also applies to
+	// requests no route matches
+	PoolMiddleware map[string][]func(http.Handler) http.Handler
+}
+
+// Match is the route a request took
+type Match struct {
+	// Route names the route, "" if none matched
+	Route string
+	// Pool is the pool serving the request, "" for the default
+	Pool string
+	// Fallback names the pool to serve the request instead while Pool has
+	// no backend available, "" for none
+	Fallback string
+	// strip is the prefix to remove from the path, if any
+	strip string
+}
+
+// Router maps requests to pools. The first rule to match wins; without one,
+// the path route with the longest matching prefix wins, one limited to the
+// request's host winning a tie; without one, the host routes decide, an
+// exact host winning over wildcards and a longer wildcard over a shorter
+// one.
+type Router struct {
+	rules         []rule
+	hosts         hostTable
+	paths         []pathRoute // in order of precedence
+	rejectNoHost  bool
+	rejectNoMatch bool
+	middleware    map[string][]func(http.Handler) http.Handler // by route name
+	fallbacks     map[string]string                            // by route name
+}
+
+// pathRoute is a PathRoute with its prefix and host normalized
+type pathRoute struct {
+	PathRoute
+	hosts hostTable
+}
+
+// rule is a Rule with its conditions compiled
+type rule struct {
+	Rule
+	hosts   hostTable
+	prefix  string
+	headers []headerMatch
+}
+
+// headerMatch is a compiled HeaderMatch
+type headerMatch struct {
+	name  string // canonical
+	value string
+	re    *regexp.Regexp // nil to match value exactly
+}
+
+// hostTable matches hosts against exact names and wildcards
+type hostTable struct {
+	exact     map[string]string
+	wildcards []wildcard // longest suffix first
+}
+
+// wildcard is a "*.suffix" pattern, kept as ".suffix"
+type wildcard struct {
+	suffix string
+	value  string
+}
+
+// New creates a router
+func New(o Options) (*Router, error) {
+	rt := &Router{
+		rejectNoHost:  o.RejectNoHost,
+		rejectNoMatch: o.RejectNoMatch,
+		middleware:    make(map[string][]func(http.Handler) http.Handler),
+		fallbacks:     make(map[string]string),
+	}
+	// Route names key the middleware and fallbacks, so they must be unique
+	name := func(name, pool, fallback string, mws []func(http.Handler) http.Handler) error {
+		if _, dup := rt.middleware[name]; dup {
+			return fmt.Errorf("route name %q is used twice", name)
+		}
+		if fallback != "" {
+			if fallback == pool {
+				return fmt.Errorf("route %q falls back to its own pool", name)
+			}
+			rt.fallbacks[name] = fallback
+		}
+		if mws == nil {
+			mws = o.PoolMiddleware[pool]
+		}
+		rt.middleware[name] = mws
+		return nil
+	}
+	for i, ru := range o.Rules {
+		compiled, err := compileRule(ru)
+		if err != nil {
+			return nil, fmt.Errorf("rules[%d]: %w", i, err)
+		}
+		if compiled.Name == "" {
+			compiled.Name = fmt.Sprintf("rules[%d]", i)
+		}
+		if err := name(compiled.Name, ru.Pool, ru.Fallback, ru.Middleware); err != nil {
+			return nil, err
+		}
+		rt.rules = append(rt.rules, compiled)
+	}
+	for _, hr := range o.Hosts {
+		if err := rt.hosts.add(hr.Host, hr.Pool); err != nil {
+			return nil, err
+		}
+		if err := name(normalize(hr.Host), hr.Pool, hr.Fallback, hr.Middleware); err != nil {
+			return nil, err
+		}
+	}
+	for _, pr := range o.Paths {
+		if !strings.HasPrefix(pr.Prefix, "/") {
+			return nil, fmt.Errorf("path prefix %q must start with /", pr.Prefix)
+		}
+		p := pathRoute{PathRoute: pr}
+		p.Prefix = normalizePrefix(pr.Prefix)
+		if p.Name == "" {
+			p.Name = pr.Host + p.Prefix
+		}
+		if pr.Host != "" {
+			if err := p.hosts.add(pr.Host, ""); err != nil {
+				return nil, err
+			}
+		}
+		for _, other := range rt.paths {
+			if other.Prefix == p.Prefix && normalize(other.Host) == normalize(p.Host) {
+				return nil, fmt.Errorf("path %q is routed twice", pr.Host+pr.Prefix)
+			}
+		}
+		if err := name(p.Name, pr.Pool, pr.Fallback, pr.Middleware); err != nil {
+			return nil, err
+		}
+		rt.paths = append(rt.paths, p)
+	}
+	slices.SortStableFunc(rt.paths, func(a, b pathRoute) int {
+		if c := cmp.Compare(len(b.Prefix), len(a.Prefix)); c != 0 {
+			return c
+		}
+		return cmp.Compare(hostRank(b.Host), hostRank(a.Host))
+	})
+	rt.hosts.sort()
+	if mws := o.PoolMiddleware[""]; mws != nil {
+		rt.middleware[""] = mws
+	}
+	return rt, nil
+}
+
+// hostRank orders routes limited to an exact host before those limited to
+// a wildcard, and those before routes for any host
+func hostRank(host string) int {
+	switch {
+	case host == "":
+		return 0
+	case strings.HasPrefix(host, "*"):
+		return 1
+	}
+	return 2
+}
+
+// Route returns the route r takes, or the status it is refused with
+func (rt *Router) Route(r *http.Request) (Match, int) {
+	m, status := rt.match(r)
+	m.Fallback = rt.fallbacks[m.Route]
+	return m, status
+}
+
+// match finds the route r takes
+func (rt *Router) match(r *http.Request) (Match, int) {
+	host := normalize(r.Host)
+	if host == "" && rt.rejectNoHost {
+		return Match{}, http.StatusMisdirectedRequest
+	}
+	for _, ru := range rt.rules {
+		if ru.matches(r, host) {
+			m := Match{Route: ru.Name, Pool: ru.Pool}
+			if ru.StripPrefix {
+				m.strip = ru.prefix
+			}
+			return m, 0
+		}
+	}
+	for _, p := range rt.paths {
+		if p.Host != "" {
+			if _, ok := p.hosts.match(host); !ok {
+				continue
+			}
+		}
+		if matchPrefix(r.URL.Path, p.Prefix) {
+			m := Match{Route: p.Name, Pool: p.Pool}
+			if p.StripPrefix {
+				m.strip = p.Prefix
+			}
+			return m, 0
+		}
+	}
+	if pool, ok := rt.hosts.match(host); ok {
+		return Match{Route: rt.hosts.pattern(host), Pool: pool}, 0
+	}
+	if rt.rejectNoMatch {
+		return Match{}, http.StatusNotFound
+	}
+	return Match{}, 0
+}
+
+// Middleware returns the middleware of each route by name, with "" for that
+// of requests no route matches, leaving out routes without any
+func (rt *Router) Middleware() map[string][]func(http.Handler) http.Handler {
+	mws := make(map[string][]func(http.Handler) http.Handler)
+	for name, list := range rt.middleware {
+		if len(list) > 0 {
+			mws[name] = list
+		}
+	}
+	return mws
+}
+
+// Shadowed returns the rules that can never match, each with the first
+// earlier rule that matches every request it would
+func (rt *Router) Shadowed() []Shadow {
+	var shadowed []Shadow
+	for i, later := range rt.rules {
+		for _, earlier := range rt.rules[:i] {
+			if earlier.covers(&later) {
+				shadowed = append(shadowed, Shadow{Rule: later.Name, By: earlier.Name})
+				break
+			}
+		}
+	}
+	return shadowed
+}
+
+// Apply rewrites r as the route requires, stripping the matched prefix
+// from its path while keeping the rest of the path as the client encoded it
+func (m Match) Apply(r *http.Request) {
+	if m.strip == "" || m.strip == "/" {
+		return
+	}
+	raw := r.URL.EscapedPath()
+	if uri := r.RequestURI; strings.HasPrefix(uri, "/") {
+		raw, _, _ = strings.Cut(uri, "?")
+	}
+	for i := 1; i <= len(raw); i++ {
+		if i < len(raw) && raw[i] != '/' {
+			continue
+		}
+		if decoded, err := url.PathUnescape(raw[:i]); err == nil && decoded == m.strip {
+			rest := raw[i:]
+			if rest == "" {
+				rest = "/"
+			}
+			if path, err := url.PathUnescape(rest); err == nil {
+				r.URL.Path, r.URL.RawPath = path, rest
+				r.RequestURI = rest
+				if r.URL.RawQuery != "" {
+					r.RequestURI += "?" + r.URL.RawQuery
+				}
+				return
+			}
+			break
+		}
+	}
+	// The raw path does not line up with the decoded one; give up on
+	// keeping its encoding
+	r.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, m.strip), "/")
+	r.URL.RawPath = ""
+	r.RequestURI = r.URL.RequestURI()
+}
+
+// compileRule checks a rule and compiles its conditions
+func compileRule(ru Rule) (rule, error) {
+	c := rule{Rule: ru}
+	if ru.Host != "" {
+		if err := c.hosts.add(ru.Host, ""); err != nil {
+			return rule{}, err
+		}
+	}
+	if ru.PathPrefix != "" {
+		if !strings.HasPrefix(ru.PathPrefix, "/") {
+			return rule{}, fmt.Errorf("path prefix %q must start with /", ru.PathPrefix)
+		}
+		c.prefix = normalizePrefix(ru.PathPrefix)
+	} else if ru.StripPrefix {
+		return rule{}, fmt.Errorf("strip_prefix needs a path prefix")
+	}
+	for _, hm := range ru.Headers {
+		if hm.Name == "" {
+			return rule{}, fmt.Errorf("header match without a name")
+		}
+		m := headerMatch{name: http.CanonicalHeaderKey(hm.Name), value: hm.Value}
+		if hm.Regex != "" {
+			// The whole value must match, as with an exact value
+			re, err := regexp.Compile("^(?:" + hm.Regex + ")$")
+			if err != nil {
+				return rule{}, fmt.Errorf("header %s: %w", hm.Name, err)
+			}
+			m.re = re
+		}
+		c.headers = append(c.headers, m)
+	}
+	return c, nil
+}
+
+// matches reports whether r, sent to host, meets every condition of the rule
+func (ru *rule) matches(r *http.Request, host string) bool {
+	if ru.Host != "" {
+		if _, ok := ru.hosts.match(host); !ok {
+			return false
+		}
+	}
+	if ru.prefix != "" && !matchPrefix(r.URL.Path, ru.prefix) {
+		return false
+	}
+	if len(ru.Methods) > 0 && !slices.Contains(ru.Methods, r.Method) {
+		return false
+	}
+	for _, hm := range ru.headers {
+		if !slices.ContainsFunc(r.Header.Values(hm.name), hm.matches) {
+			return false
+		}
+	}
+	return true
+}
+
+func (hm headerMatch) matches(value string) bool {
+	if hm.re != nil {
+		return hm.re.MatchString(value)
+	}
+	return value == hm.value
+}
+
+// covers reports whether the rule matches every request other does. It
+// errs on the side of false: two regular expressions are only known to
+// match the same values if they are the same.
+func (ru *rule) covers(other *rule) bool {
+	if ru.Host != "" && (other.Host == "" || !hostCovers(normalize(ru.Host), normalize(other.Host))) {
+		return false
+	}
+	if ru.prefix != "" && (other.prefix == "" || !matchPrefix(other.prefix, ru.prefix)) {
+		return false
+	}
+	if len(ru.Methods) > 0 && (len(other.Methods) == 0 ||
+		slices.ContainsFunc(other.Methods, func(m string) bool { return !slices.Contains(ru.Methods, m) })) {
+		return false
+	}
+	for _, hm := range ru.headers {
+		if !slices.ContainsFunc(other.headers, func(o headerMatch) bool {
+			switch {
+			case o.name != hm.name:
+				return false
+			case o.re != nil:
+				return hm.re != nil && hm.re.String() == o.re.String()
+			}
+			return hm.matches(o.value)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// hostCovers reports whether every host matching pattern other also
+// matches pattern, both normalized
+func hostCovers(pattern, other string) bool {
+	if pattern == other {
+		return true
+	}
+	suffix, ok := strings.CutPrefix(pattern, "*")
+	return ok && strings.HasSuffix(strings.TrimPrefix(other, "*"), suffix)
+}
+
+// matchPrefix reports whether path lies under prefix
+func matchPrefix(path, prefix string) bool {
+	return prefix == "/" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// normalizePrefix drops the trailing slash of a prefix other than "/"
+func normalizePrefix(prefix string) string {
+	if trimmed := strings.TrimRight(prefix, "/"); trimmed != "" {
+		return trimmed
+	}
+	return "/"
+}
+
+// add adds a host pattern to the table
+func (t *hostTable) add(pattern, value string) error {
+	host := normalize(pattern)
+	suffix, isWildcard := strings.CutPrefix(host, "*")
+	switch {
+	case host == "" || strings.Contains(suffix, "*"):
+		return fmt.Errorf("invalid host %q", pattern)
+	case isWildcard && (!strings.HasPrefix(suffix, ".") || len(suffix) < 2):
+		return fmt.Errorf("invalid host %q: wildcards must look like *.example.com", pattern)
+	case isWildcard:
+		if slices.ContainsFunc(t.wildcards, func(w wildcard) bool { return w.suffix == suffix }) {
+			return fmt.Errorf("host %q is routed twice", pattern)
+		}
+		t.wildcards = append(t.wildcards, wildcard{suffix: suffix, value: value})
+	default:
+		if _, dup := t.exact[host]; dup {
+			return fmt.Errorf("host %q is routed twice", pattern)
+		}
+		if t.exact == nil {
+			t.exact = make(map[string]string)
+		}
+		t.exact[host] = value
+	}
+	t.sort()
+	return nil
+}
+
+func (t *hostTable) sort() {
+	slices.SortStableFunc(t.wildcards, func(a, b wildcard) int {
+		return cmp.Compare(len(b.suffix), len(a.suffix))
+	})
+}
+
+// match returns the value of the pattern host matches
+func (t *hostTable) match(host string) (string, bool) {
+	if host == "" {
+		return "", false
+	}
+	if v, ok := t.exact[host]; ok {
+		return v, true
+	}
+	for _, w := range t.wildcards {
+		if strings.HasSuffix(host, w.suffix) {
+			return w.value, true
+		}
+	}
+	return "", false
+}
+
+// pattern returns the pattern host matches, as configured but normalized
+func (t *hostTable) pattern(host string) string {
+	if _, ok := t.exact[host]; ok {
+		return host
+	}
+	for _, w := range t.wildcards {
+		if strings.HasSuffix(host, w.suffix) {
+			return "*" + w.suffix
+		}
+	}
+	return ""
+}
+
+// normalize lowercases a host and strips its port and trailing dot
+func normalize(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -0,0 +1,191 @@
+// Package split divides traffic between the pools of backends of a canary
+// release by percentage, optionally keeping each client on one side
+package split
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+)
+
+// DefaultPool names the pool of backends that belong to no other; it takes
+// whatever share the named pools leave
+const DefaultPool = "default"
+
+// buckets is the resolution of a split: a request falls in one of this many
+// buckets, and each pool owns a contiguous range of them
+const buckets = 10000
+
+// Sticky modes keep a client in the same pool across requests
+const (
+	StickyNone   = ""
+	StickyIP     = "ip"
+	StickyCookie = "cookie"
+	StickyHeader = "header"
+)
+
+// Options configure a Splitter
+type Options struct {
+	// Percent is the initial share of each named pool
+	Percent map[string]float64
+	// Sticky is StickyNone, StickyIP, StickyCookie or StickyHeader
+	Sticky string
+	// Cookie names the cookie of StickyCookie
+	Cookie string
+	// StickyHeader names the request header of StickyHeader, such as one
+	// a route's JWT middleware sets from a claim
+	StickyHeader string
+	// Header, if set, tags responses with the pool that served them
+	Header string
+	// Trusted resolves the client IP of StickyIP
+	Trusted backend.TrustedProxies
+}
+
+// Status is the current split, default pool included
+type Status struct {
+	Percent map[string]float64 `json:"percent"`
+	Sticky  string             `json:"sticky,omitempty"`
+}
+
+// Splitter assigns requests to pools. A sticky client's bucket never
+// changes, so with a single named pool, raising its share only moves
+// clients of the default pool into it, never the other way.
+type Splitter struct {
+	pools   []string // named pools, in the order their ranges are laid out
+	sticky  string
+	cookie  string
+	key     string // request header of StickyHeader
+	header  string
+	trusted backend.TrustedProxies
+
+	mu     sync.RWMutex
+	shares map[string]int // buckets per named pool
+}
+
+// New creates a splitter between the default pool and the named pools
+func New(pools []string, o Options) (*Splitter, error) {
+	s := &Splitter{
+		pools:   slices.Sorted(slices.Values(pools)),
+		sticky:  o.Sticky,
+		cookie:  o.Cookie,
+		key:     o.StickyHeader,
+		header:  o.Header,
+		trusted: o.Trusted,
+		shares:  make(map[string]int, len(pools)),
+	}
+	for _, pool := range s.pools {
+		if err := s.SetPercent(pool, o.Percent[pool]); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Choose returns the pool that serves r, or "" for the default pool. It
+// sets the stickiness cookie and the tagging header on w as needed.
+func (s *Splitter) Choose(w http.ResponseWriter, r *http.Request) string {
+	pool := s.poolOf(s.bucket(w, r))
+	if s.header != "" {
+		name := pool
+		if name == "" {
+			name = DefaultPool
+		}
+		w.Header().Set(s.header, name)
+	}
+	return pool
+}
+
+// bucket places the request in a bucket: the client's own when sticky,
+// otherwise a random one
+func (s *Splitter) bucket(w http.ResponseWriter, r *http.Request) int {
+	switch s.sticky {
+	case StickyIP:
+		h := fnv.New32a()
+		h.Write([]byte(s.trusted.ClientIP(r)))
+		return int(h.Sum32() % buckets)
+	case StickyHeader:
+		// Requests without the header are not tied to anyone
+		if v := r.Header.Get(s.key); v != "" {
+			h := fnv.New32a()
+			h.Write([]byte(v))
+			return int(h.Sum32() % buckets)
+		}
+	case StickyCookie:
+		if c, err := r.Cookie(s.cookie); err == nil {
+			if n, err := strconv.Atoi(c.Value); err == nil && n >= 0 && n < buckets {
+				return n
+			}
+		}
+		n := rand.IntN(buckets)
+		http.SetCookie(w, &http.Cookie{
+			Name:     s.cookie,
+			Value:    strconv.Itoa(n),
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		return n
+	}
+	return rand.IntN(buckets)
+}
+
+// poolOf returns the pool owning bucket n; the named pools' ranges come
+// first, in order, and the default pool has the rest
+func (s *Splitter) poolOf(n int) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	end := 0
+	for _, pool := range s.pools {
+		end += s.shares[pool]
+		if n < end {
+			return pool
+		}
+	}
+	return ""
+}
+
+// SetPercent changes the share of a named pool; the default pool's share
+// changes by the difference
+func (s *Splitter) SetPercent(pool string, percent float64) error {
+	if !slices.Contains(s.pools, pool) {
+		return fmt.Errorf("unknown pool %q", pool)
+	}
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("percent must be between 0 and 100")
+	}
+	share := int(percent*buckets/100 + 0.5)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := share
+	for name, n := range s.shares {
+		if name != pool {
+			total += n
+		}
+	}
+	if total > buckets {
+		return fmt.Errorf("pools would take more than 100%% of traffic")
+	}
+	s.shares[pool] = share
+	return nil
+}
+
+// Status reports the share of every pool
+func (s *Splitter) Status() Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	percent := make(map[string]float64, len(s.shares)+1)
+	rest := buckets
+	for pool, n := range s.shares {
+		percent[pool] = float64(n) * 100 / buckets
+		rest -= n
+	}
+	percent[DefaultPool] = float64(rest) * 100 / buckets
+	return Status{Percent: percent, Sticky: s.sticky}
+}
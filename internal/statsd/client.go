@@ -0,0 +1,189 @@
+package statsd
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxPacketSize keeps datagrams under a typical Ethernet MTU
+	maxPacketSize = 1432
+	// flushInterval bounds how long a line waits to be batched
+	flushInterval = 100 * time.Millisecond
+	// errorLogInterval limits how often send failures are logged
+	errorLogInterval = time.Minute
+)
+
+// Client sends StatsD metrics over UDP. Sending never blocks the caller:
+// lines are queued to a background writer and dropped when the queue is
+// full, so a slow or missing StatsD server cannot slow the proxy down.
+type Client struct {
+	prefix     string
+	sampleRate float64
+	dogstatsd  bool
+	tags       []string
+
+	conn    net.Conn
+	lines   chan string
+	done    chan struct{}
+	closing sync.Once
+
+	dropped      atomic.Uint64
+	lastErrorLog atomic.Int64
+}
+
+// NewClient creates a client sending to addr. Metric names are prefixed
+// with prefix and a dot. With dogstatsd set, tags are appended in the
+// DogStatsD "|#key:value" form; otherwise they are ignored.
+func NewClient(addr, prefix string, sampleRate float64, dogstatsd bool, tags []string) (*Client, error) {
+	// Dialing UDP only resolves the address; it succeeds without a server
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+
+	c := &Client{
+		prefix:     prefix,
+		sampleRate: sampleRate,
+		dogstatsd:  dogstatsd,
+		tags:       tags,
+		conn:       conn,
+		lines:      make(chan string, 4096),
+		done:       make(chan struct{}),
+	}
+	go c.run()
+	return c, nil
+}
+
+// Count adds n to a counter. Counters are sampled at the configured rate.
+func (c *Client) Count(name string, n int64, tags ...string) {
+	c.sampled(name, strconv.FormatInt(n, 10), "c", tags)
+}
+
+// Timing records a duration in milliseconds, sampled at the configured rate
+func (c *Client) Timing(name string, d time.Duration, tags ...string) {
+	c.sampled(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64), "ms", tags)
+}
+
+// Gauge sets a gauge. Gauges are never sampled.
+func (c *Client) Gauge(name string, v float64, tags ...string) {
+	c.send(c.format(name, strconv.FormatFloat(v, 'f', -1, 64), "g", 1, tags))
+}
+
+// Event counts an occurrence that must not be sampled away, such as a
+// backend going down
+func (c *Client) Event(name string, tags ...string) {
+	c.send(c.format(name, "1", "c", 1, tags))
+}
+
+// Dropped returns how many lines were dropped because the queue was full
+func (c *Client) Dropped() uint64 {
+	return c.dropped.Load()
+}
+
+// Close flushes queued lines and closes the socket
+func (c *Client) Close() error {
+	c.closing.Do(func() { close(c.lines) })
+	<-c.done
+	return c.conn.Close()
+}
+
+// sampled queues a line subject to the sample rate
+func (c *Client) sampled(name, value, kind string, tags []string) {
+	if c.sampleRate < 1 && rand.Float64() >= c.sampleRate {
+		return
+	}
+	c.send(c.format(name, value, kind, c.sampleRate, tags))
+}
+
+// format renders one StatsD line
+func (c *Client) format(name, value, kind string, rate float64, tags []string) string {
+	var b strings.Builder
+	b.WriteString(c.prefix)
+	b.WriteString(name)
+	b.WriteByte(':')
+	b.WriteString(value)
+	b.WriteByte('|')
+	b.WriteString(kind)
+	if rate < 1 {
+		b.WriteString("|@")
+		b.WriteString(strconv.FormatFloat(rate, 'f', -1, 64))
+	}
+	if c.dogstatsd && len(c.tags)+len(tags) > 0 {
+		b.WriteString("|#")
+		b.WriteString(strings.Join(append(c.tags[:len(c.tags):len(c.tags)], tags...), ","))
+	}
+	return b.String()
+}
+
+// send queues a line without blocking
+func (c *Client) send(line string) {
+	defer func() {
+		// Sending after Close is dropped rather than panicking
+		if recover() != nil {
+			c.dropped.Add(1)
+		}
+	}()
+	select {
+	case c.lines <- line:
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+// run batches queued lines into datagrams
+func (c *Client) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	packet := make([]byte, 0, maxPacketSize)
+	flush := func() {
+		if len(packet) == 0 {
+			return
+		}
+		if _, err := c.conn.Write(packet); err != nil {
+			c.logError(err)
+		}
+		packet = packet[:0]
+	}
+
+	for {
+		select {
+		case line, ok := <-c.lines:
+			if !ok {
+				flush()
+				return
+			}
+			if len(packet) > 0 && len(packet)+1+len(line) > maxPacketSize {
+				flush()
+			}
+			if len(packet) > 0 {
+				packet = append(packet, '\n')
+			}
+			packet = append(packet, line...)
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// logError logs a send failure at most once per errorLogInterval
+func (c *Client) logError(err error) {
+	now := time.Now().UnixNano()
+	last := c.lastErrorLog.Load()
+	if now-last < int64(errorLogInterval) || !c.lastErrorLog.CompareAndSwap(last, now) {
+		return
+	}
+	slog.Warn("failed to send metrics", "component", "statsd", "error", err, "suppressed_for", errorLogInterval)
+}
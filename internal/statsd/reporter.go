@@ -0,0 +1,104 @@
+package statsd
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/events"
+	"github.com/nexus-lb/nexus/internal/metrics"
+)
+
+// Reporter translates proxy activity and backend state changes into StatsD
+// metrics. Per-backend metrics are tagged with DogStatsD, and carry the
+// backend in the metric name with plain StatsD.
+type Reporter struct {
+	client *Client
+	sub    *events.Subscription
+	done   chan struct{}
+}
+
+// NewReporter creates a reporter sending through client
+func NewReporter(client *Client) *Reporter {
+	return &Reporter{client: client}
+}
+
+// RequestFinished counts a completed request and its retries
+func (r *Reporter) RequestFinished(backend string, status, retries int, d time.Duration) {
+	class := metrics.StatusClass(status)
+	if r.client.dogstatsd {
+		r.client.Count("requests", 1, "code:"+class)
+	} else {
+		r.client.Count("requests."+class, 1)
+	}
+	if retries > 0 {
+		r.client.Count("retries", int64(retries))
+	}
+}
+
+// BackendServed records how long a backend took to serve one attempt
+func (r *Reporter) BackendServed(backend string, d time.Duration) {
+	if r.client.dogstatsd {
+		r.client.Timing("backend.response_time", d, "backend:"+backend)
+	} else {
+		r.client.Timing("backend."+metricName(backend)+".response_time", d)
+	}
+}
+
+// Watch reports backend up/down events published on bus until Close
+func (r *Reporter) Watch(bus *events.Bus) {
+	r.sub = bus.Subscribe(256)
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		for e := range r.sub.C {
+			change, ok := e.Data.(backend.StateChange)
+			if e.Type != backend.StateEventType || !ok {
+				continue
+			}
+			r.backendState(change)
+		}
+	}()
+}
+
+// backendState reports a backend going up or down
+func (r *Reporter) backendState(change backend.StateChange) {
+	state, up := "down", 0.0
+	if change.Alive {
+		state, up = "up", 1
+	}
+	if r.client.dogstatsd {
+		r.client.Event("backend."+state, "backend:"+change.URL)
+		r.client.Gauge("backend.alive", up, "backend:"+change.URL)
+	} else {
+		name := metricName(change.URL)
+		r.client.Event("backend." + name + "." + state)
+		r.client.Gauge("backend."+name+".alive", up)
+	}
+}
+
+// Close stops watching events and closes the client
+func (r *Reporter) Close(bus *events.Bus) error {
+	if r.sub != nil {
+		bus.Unsubscribe(r.sub)
+		<-r.done
+	}
+	return r.client.Close()
+}
+
+// metricName turns a backend URL into a StatsD-safe name segment, such as
+// "localhost_8081" for http://localhost:8081
+func metricName(backendURL string) string {
+	name := backendURL
+	if u, err := url.Parse(backendURL); err == nil && u.Host != "" {
+		name = u.Host + strings.TrimSuffix(u.Path, "/")
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-':
+			return r
+		}
+		return '_'
+	}, name)
+}
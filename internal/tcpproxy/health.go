@@ -0,0 +1,92 @@
+package tcpproxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// maxExpectRead bounds how much of a backend's answer a health check reads
+// looking for the expected bytes
+const maxExpectRead = 4096
+
+// HealthOptions configure the health checks of a proxy's targets. Without
+// Send or Expect, a target is healthy if it accepts a connection.
+type HealthOptions struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	// Send is written to the backend once connected
+	Send []byte
+	// Expect must then appear within the first bytes the backend answers
+	Expect []byte
+}
+
+// checkHealth checks every target every interval until the proxy stops
+func (p *Proxy) checkHealth() {
+	defer p.wg.Done()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			for _, t := range p.targets {
+				p.checkTarget(t)
+			}
+			timer.Reset(p.o.Health.Interval)
+		case <-p.stopping:
+			return
+		}
+	}
+}
+
+// checkTarget probes t and logs the transition if its state changed
+func (p *Proxy) checkTarget(t *Target) {
+	err := p.probe(t.Addr)
+	alive := err == nil
+	if t.alive.Swap(alive) == alive {
+		return
+	}
+	if alive {
+		p.logger.Info("backend recovered", "backend", t.Addr, "transition", "DOWN -> UP")
+	} else {
+		p.logger.Warn("backend failed health check", "backend", t.Addr, "transition", "UP -> DOWN", "error", err)
+	}
+}
+
+// probe connects to addr and, if configured, sends the probe and waits
+// for the expected answer, all within the timeout
+func (p *Proxy) probe(addr string) error {
+	h := p.o.Health
+	conn, err := net.DialTimeout("tcp", addr, h.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if len(h.Send) == 0 && len(h.Expect) == 0 {
+		return nil
+	}
+	conn.SetDeadline(time.Now().Add(h.Timeout))
+	if len(h.Send) > 0 {
+		if _, err := conn.Write(h.Send); err != nil {
+			return err
+		}
+	}
+	if len(h.Expect) == 0 {
+		return nil
+	}
+	var got []byte
+	buf := make([]byte, 512)
+	for len(got) < maxExpectRead {
+		n, err := conn.Read(buf)
+		got = append(got, buf[:n]...)
+		if bytes.Contains(got, h.Expect) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("expected answer not received: %w", err)
+		}
+	}
+	return errors.New("expected answer not received")
+}
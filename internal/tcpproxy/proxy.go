@@ -0,0 +1,342 @@
+// Package tcpproxy balances raw TCP connections, such as those of databases
+// and caches, across backends: each accepted connection is spliced to one
+// healthy backend until either side closes it or it goes idle. TLS
+// connections can be passed through to backends chosen by the server name
+// of their ClientHello, without being decrypted.
+package tcpproxy
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Options configure a Proxy
+type Options struct {
+	// Name identifies the listener in logs and metrics
+	Name string
+	// Listen is the address connections are accepted on
+	Listen string
+	// Backends are the host:port addresses connections are proxied to; with
+	// Routes, those of connections no route serves, which are closed if
+	// there are none
+	Backends []string
+	// Routes, if set, pass TLS connections through to backends by the
+	// server name they ask for
+	Routes []Route
+	// HelloTimeout bounds reading the ClientHello of a TLS connection
+	HelloTimeout time.Duration
+	// Balance is RoundRobin or LeastConn
+	Balance string
+	// DialTimeout bounds connecting to a backend; a backend that cannot be
+	// reached is skipped for the next one
+	DialTimeout time.Duration
+	// IdleTimeout closes connections over which nothing was sent either way
+	// for that long; zero for none
+	IdleTimeout time.Duration
+	// MaxConnections caps the connections proxied at once; those beyond it
+	// are closed as soon as accepted. Zero for no limit.
+	MaxConnections int
+	Health         HealthOptions
+	Logger         *slog.Logger
+}
+
+// Reasons connections are refused
+const (
+	RejectMaxConnections = "max_connections"
+	RejectNoBackend      = "no_backend"
+	// RejectNoRoute is a TLS connection no route serves, without backends
+	// to fall back on
+	RejectNoRoute = "no_route"
+	// RejectBadHello is a connection that did not open with a ClientHello
+	// in time
+	RejectBadHello = "bad_client_hello"
+)
+
+// rejectReasons are the reasons counted in Stats.Rejected
+var rejectReasons = []string{RejectMaxConnections, RejectNoBackend, RejectNoRoute, RejectBadHello}
+
+// Stats are the counts of a proxy
+type Stats struct {
+	Name   string `json:"name"`
+	Listen string `json:"listen"`
+	Active int64  `json:"active"`
+	// Rejected counts the connections refused, by reason
+	Rejected map[string]uint64 `json:"rejected"`
+	Targets  []TargetStats     `json:"backends"`
+}
+
+// Proxy accepts connections on a listener and splices each to a backend
+type Proxy struct {
+	o      Options
+	logger *slog.Logger
+	// targets are every backend, of routes included, once each
+	targets []*Target
+	// balancer picks among Backends; nil if there are none
+	balancer *balancer
+	routes   []sniRoute
+
+	ln       net.Listener
+	stopping chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup // the accept loop and the health checker
+
+	mu       sync.Mutex
+	sessions map[*session]struct{}
+	active   atomic.Int64
+	drained  chan struct{} // closed when the last session ends while stopping
+
+	rejected map[string]*atomic.Uint64
+}
+
+// New creates a proxy; its backends count as healthy until the first
+// health check says otherwise
+func New(o Options) *Proxy {
+	logger := o.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	p := &Proxy{
+		o:        o,
+		logger:   logger.With("component", "tcp", "listener", o.Name),
+		stopping: make(chan struct{}),
+		sessions: make(map[*session]struct{}),
+		drained:  make(chan struct{}),
+		rejected: make(map[string]*atomic.Uint64, len(rejectReasons)),
+	}
+	for _, reason := range rejectReasons {
+		p.rejected[reason] = new(atomic.Uint64)
+	}
+	byAddr := make(map[string]*Target)
+	newBalancer := func(addrs []string) *balancer {
+		b := &balancer{balance: o.Balance}
+		for _, addr := range addrs {
+			t, ok := byAddr[addr]
+			if !ok {
+				t = &Target{Addr: addr}
+				t.alive.Store(true)
+				byAddr[addr] = t
+				p.targets = append(p.targets, t)
+			}
+			b.targets = append(b.targets, t)
+		}
+		return b
+	}
+	if len(o.Backends) > 0 {
+		p.balancer = newBalancer(o.Backends)
+	}
+	for _, r := range o.Routes {
+		names := make([]string, len(r.ServerNames))
+		for i, name := range r.ServerNames {
+			names[i] = strings.ToLower(strings.TrimSuffix(name, "."))
+		}
+		p.routes = append(p.routes, sniRoute{names: names, balancer: newBalancer(r.Backends)})
+	}
+	return p
+}
+
+// Name returns the name of the listener
+func (p *Proxy) Name() string {
+	return p.o.Name
+}
+
+// Start launches the accept loop on ln, a listener on Options.Listen, and
+// the health checker
+func (p *Proxy) Start(ln net.Listener) {
+	p.ln = ln
+	p.logger.Info("TCP proxy listening", "addr", ln.Addr().String(), "backends", len(p.targets), "sni_routes", len(p.routes), "balance", p.o.Balance)
+	p.wg.Add(2)
+	go p.checkHealth()
+	go p.serve()
+}
+
+// serve accepts connections until the listener is closed
+func (p *Proxy) serve() {
+	defer p.wg.Done()
+	var backoff time.Duration
+	for {
+		conn, err := p.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// Failures such as running out of file descriptors are retried
+			// after a pause, like net/http does
+			backoff = min(max(2*backoff, 5*time.Millisecond), time.Second)
+			p.logger.Warn("accepting connection failed", "error", err, "retry_in", backoff)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+		if limit := p.o.MaxConnections; limit > 0 && p.active.Load() >= int64(limit) {
+			p.rejected[RejectMaxConnections].Add(1)
+			p.logger.Debug("refused connection over max_connections", "client", conn.RemoteAddr().String())
+			conn.Close()
+			continue
+		}
+		s := &session{client: conn}
+		if !p.track(s) {
+			conn.Close()
+			continue
+		}
+		go p.handle(s)
+	}
+}
+
+// track registers s as open, unless the proxy is stopping
+func (p *Proxy) track(s *session) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.stopping:
+		return false
+	default:
+	}
+	p.sessions[s] = struct{}{}
+	p.active.Add(1)
+	return true
+}
+
+// untrack removes s, telling a waiting Shutdown once the last one is gone
+func (p *Proxy) untrack(s *session) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.sessions, s)
+	if p.active.Add(-1) == 0 {
+		select {
+		case <-p.stopping:
+			p.closeDrained()
+		default:
+		}
+	}
+}
+
+// closeDrained closes drained once; the caller holds mu
+func (p *Proxy) closeDrained() {
+	select {
+	case <-p.drained:
+	default:
+		close(p.drained)
+	}
+}
+
+// handle connects s to a backend, trying each healthy one in turn until one
+// answers, and splices the two connections
+func (p *Proxy) handle(s *session) {
+	defer p.untrack(s)
+	defer s.client.Close()
+	client := s.client.RemoteAddr().String()
+
+	b, hello := p.balancer, []byte(nil)
+	if len(p.routes) > 0 {
+		name, read, err := readClientHello(s.client, p.o.HelloTimeout)
+		if err != nil {
+			p.rejected[RejectBadHello].Add(1)
+			p.logger.Debug("closing connection without a TLS ClientHello", "client", client, "error", err)
+			return
+		}
+		if b, hello = p.balancerFor(name), read; b == nil {
+			p.rejected[RejectNoRoute].Add(1)
+			p.logger.Debug("closing TLS connection no route serves", "client", client, "server_name", name)
+			return
+		}
+	}
+
+	var tried []*Target
+	for {
+		t := b.pick(tried)
+		if t == nil {
+			p.rejected[RejectNoBackend].Add(1)
+			p.logger.Warn("no backend available, closing connection", "client", client, "tried", len(tried))
+			return
+		}
+		conn, err := net.DialTimeout("tcp", t.Addr, p.o.DialTimeout)
+		if err != nil {
+			t.failures.Add(1)
+			p.logger.Warn("connecting to backend failed", "backend", t.Addr, "client", client, "error", err)
+			tried = append(tried, t)
+			continue
+		}
+		if !s.connect(conn) {
+			// Shutdown closed the session while the backend was dialed
+			conn.Close()
+			return
+		}
+		if len(hello) > 0 {
+			if _, err := conn.Write(hello); err != nil {
+				t.failures.Add(1)
+				p.logger.Warn("sending ClientHello to backend failed", "backend", t.Addr, "client", client, "error", err)
+				return
+			}
+			t.bytesIn.Add(uint64(len(hello)))
+		}
+		started := time.Now()
+		t.conns.Add(1)
+		t.active.Add(1)
+		s.splice(t, p.o.IdleTimeout)
+		t.active.Add(-1)
+		p.logger.Debug("connection closed", "client", client, "backend", t.Addr, "duration", time.Since(started))
+		return
+	}
+}
+
+// Active returns the number of connections being proxied
+func (p *Proxy) Active() int64 {
+	return p.active.Load()
+}
+
+// Stats returns the counts of the proxy and its backends
+func (p *Proxy) Stats() Stats {
+	st := Stats{
+		Name:     p.o.Name,
+		Listen:   p.o.Listen,
+		Active:   p.active.Load(),
+		Rejected: make(map[string]uint64, len(p.rejected)),
+	}
+	for reason, n := range p.rejected {
+		st.Rejected[reason] = n.Load()
+	}
+	for _, t := range p.targets {
+		st.Targets = append(st.Targets, t.stats())
+	}
+	return st
+}
+
+// Shutdown stops accepting connections and health checking, then waits for
+// the open connections to end until ctx is done, when they are closed. It
+// returns ctx's error if connections had to be closed.
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	p.stopOnce.Do(func() {
+		p.mu.Lock()
+		close(p.stopping)
+		if p.active.Load() == 0 {
+			p.closeDrained()
+		}
+		p.mu.Unlock()
+		if p.ln != nil {
+			p.ln.Close()
+		}
+	})
+	p.wg.Wait()
+	p.logger.Info("draining TCP connections", "active", p.active.Load())
+
+	select {
+	case <-p.drained:
+		return nil
+	case <-ctx.Done():
+	}
+	p.mu.Lock()
+	closed := len(p.sessions)
+	for s := range p.sessions {
+		s.close()
+	}
+	p.mu.Unlock()
+	p.logger.Warn("closing TCP connections still open after the drain timeout", "count", closed)
+	<-p.drained
+	return ctx.Err()
+}
@@ -0,0 +1,99 @@
+package tcpproxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bufferSize is the size of the buffer each direction of a session copies
+// through
+const bufferSize = 32 << 10
+
+// session is a client connection and the backend connection it is spliced
+// to
+type session struct {
+	client net.Conn
+
+	mu      sync.Mutex
+	backend net.Conn
+	closed  bool
+
+	// lastActive is when anything was last sent either way, in unix
+	// nanoseconds
+	lastActive atomic.Int64
+}
+
+// connect sets the backend connection, unless the session was closed
+func (s *session) connect(backend net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.backend = backend
+	return true
+}
+
+// close closes both connections, ending the splice
+func (s *session) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.client.Close()
+	if s.backend != nil {
+		s.backend.Close()
+	}
+}
+
+// splice copies bytes both ways until both sides have closed their end, or
+// until either fails or the session has been idle for idle
+func (s *session) splice(t *Target, idle time.Duration) {
+	s.lastActive.Store(time.Now().UnixNano())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.pipe(s.backend, s.client, &t.bytesIn, idle)
+	}()
+	s.pipe(s.client, s.backend, &t.bytesOut, idle)
+	<-done
+	s.close()
+}
+
+// pipe copies from src to dst. When src ends cleanly, dst is told so by
+// closing its write side and the other direction carries on; any other
+// end closes the whole session.
+func (s *session) pipe(dst, src net.Conn, counter *atomic.Uint64, idle time.Duration) {
+	buf := make([]byte, bufferSize)
+	for {
+		if idle > 0 {
+			src.SetReadDeadline(time.Now().Add(idle))
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			s.lastActive.Store(time.Now().UnixNano())
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				s.close()
+				return
+			}
+			counter.Add(uint64(n))
+		}
+		if err == nil {
+			continue
+		}
+		// The other direction may have kept the session busy meanwhile
+		if errors.Is(err, os.ErrDeadlineExceeded) && time.Since(time.Unix(0, s.lastActive.Load())) < idle {
+			continue
+		}
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok && errors.Is(err, io.EOF) {
+			cw.CloseWrite()
+			return
+		}
+		s.close()
+		return
+	}
+}
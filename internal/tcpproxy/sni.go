@@ -0,0 +1,101 @@
+package tcpproxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Route sends the TLS connections asking for one of its server names to
+// its backends
+type Route struct {
+	// ServerNames are host names, or wildcards such as *.example.com
+	// standing for one label
+	ServerNames []string
+	Backends    []string
+}
+
+// sniRoute is a Route ready to match server names
+type sniRoute struct {
+	names    []string
+	balancer *balancer
+}
+
+// matches reports whether the route serves name, which is lower case
+func (r *sniRoute) matches(name string) bool {
+	for _, pattern := range r.names {
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			label, found := strings.CutSuffix(name, suffix)
+			if found && label != "" && !strings.Contains(label, ".") {
+				return true
+			}
+		} else if pattern == name {
+			return true
+		}
+	}
+	return false
+}
+
+// balancerFor returns the balancer of the route serving name, or that of the
+// listener's own backends, nil if it has none
+func (p *Proxy) balancerFor(name string) *balancer {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name != "" {
+		for _, r := range p.routes {
+			if r.matches(name) {
+				return r.balancer
+			}
+		}
+	}
+	return p.balancer
+}
+
+// errHelloRead stops the handshake once the ClientHello has been read
+var errHelloRead = errors.New("client hello read")
+
+// readClientHello reads the TLS ClientHello a client opens with, within
+// timeout, and returns the server name it asks for, empty if none, along
+// with every byte read so they can be replayed to the backend. The
+// handshake itself is left to the backend: it is only carried far enough
+// for crypto/tls to parse the hello.
+func readClientHello(conn net.Conn, timeout time.Duration) (string, []byte, error) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var read bytes.Buffer
+	var serverName string
+	var parsed bool
+	err := tls.Server(helloConn{Conn: conn, r: io.TeeReader(conn, &read)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName, parsed = hello.ServerName, true
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	if !parsed {
+		return "", read.Bytes(), err
+	}
+	return serverName, read.Bytes(), nil
+}
+
+// helloConn lets crypto/tls read the ClientHello from a client connection
+// while nothing it would answer reaches the client
+type helloConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c helloConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c helloConn) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func (c helloConn) Close() error {
+	return nil
+}
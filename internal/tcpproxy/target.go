@@ -0,0 +1,87 @@
+package tcpproxy
+
+import (
+	"slices"
+	"sync/atomic"
+)
+
+// Balancing strategies
+const (
+	RoundRobin = "round_robin"
+	LeastConn  = "least_conn"
+)
+
+// Target is a backend connections are proxied to
+type Target struct {
+	// Addr is the backend's host:port
+	Addr string
+
+	alive    atomic.Bool
+	active   atomic.Int64
+	conns    atomic.Uint64
+	failures atomic.Uint64 // dials that failed
+	bytesIn  atomic.Uint64 // from the client to the backend
+	bytesOut atomic.Uint64 // from the backend to the client
+}
+
+// TargetStats are the counts of a target
+type TargetStats struct {
+	Addr   string `json:"address"`
+	Alive  bool   `json:"alive"`
+	Active int64  `json:"active"`
+	// Conns, DialFailures, BytesIn and BytesOut are running totals; bytes
+	// in are those sent by clients
+	Conns        uint64 `json:"connections"`
+	DialFailures uint64 `json:"dial_failures"`
+	BytesIn      uint64 `json:"bytes_in"`
+	BytesOut     uint64 `json:"bytes_out"`
+}
+
+// IsAlive reports whether the target passed its last health check
+func (t *Target) IsAlive() bool {
+	return t.alive.Load()
+}
+
+func (t *Target) stats() TargetStats {
+	return TargetStats{
+		Addr:         t.Addr,
+		Alive:        t.alive.Load(),
+		Active:       t.active.Load(),
+		Conns:        t.conns.Load(),
+		DialFailures: t.failures.Load(),
+		BytesIn:      t.bytesIn.Load(),
+		BytesOut:     t.bytesOut.Load(),
+	}
+}
+
+// balancer picks the target of each connection among those alive
+type balancer struct {
+	targets []*Target
+	balance string
+	next    atomic.Uint64
+}
+
+// pick returns the next alive target not in tried: in turn for round
+// robin, or the one with the fewest active connections for least_conn,
+// ties going in turn. It returns nil if none is left.
+func (b *balancer) pick(tried []*Target) *Target {
+	n := len(b.targets)
+	if n == 0 {
+		return nil
+	}
+	start := int(b.next.Add(1) % uint64(n))
+	var best *Target
+	for i := range n {
+		t := b.targets[(start+i)%n]
+		if !t.alive.Load() || slices.Contains(tried, t) {
+			continue
+		}
+		if b.balance != LeastConn {
+			return t
+		}
+		if best == nil || t.active.Load() < best.active.Load() {
+			best = t
+		}
+	}
+	return best
+}
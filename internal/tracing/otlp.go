@@ -0,0 +1,259 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// batchSize is the most spans sent in one export request
+	batchSize = 512
+	// exportInterval bounds how long a finished span waits to be exported
+	exportInterval = 5 * time.Second
+	// errorLogInterval limits how often export failures are logged
+	errorLogInterval = time.Minute
+)
+
+// exporter batches finished spans and posts them as OTLP/HTTP JSON. Spans
+// are dropped rather than slowing the proxy when the queue is full.
+type exporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+
+	mu     sync.RWMutex // guards closed against enqueues racing shutdown
+	closed bool
+	spans  chan *Span
+	done   chan struct{}
+
+	dropped      atomic.Uint64
+	lastErrorLog atomic.Int64
+}
+
+// newExporter starts an exporter posting to endpoint
+func newExporter(endpoint, serviceName string) *exporter {
+	e := &exporter{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		spans:       make(chan *Span, 4*batchSize),
+		done:        make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// enqueue queues a finished span without blocking
+func (e *exporter) enqueue(s *Span) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		e.dropped.Add(1)
+		return
+	}
+	select {
+	case e.spans <- s:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// shutdown exports what is queued and stops the exporter
+func (e *exporter) shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.spans)
+	}
+	e.mu.Unlock()
+
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run collects spans into batches and exports them
+func (e *exporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.export(batch); err != nil {
+			e.logError(err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case s, ok := <-e.spans:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) == batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// export posts one batch of spans
+func (e *exporter) export(spans []*Span) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector responded %s", resp.Status)
+	}
+	return nil
+}
+
+// logError logs an export failure at most once per errorLogInterval
+func (e *exporter) logError(err error) {
+	now := time.Now().UnixNano()
+	last := e.lastErrorLog.Load()
+	if now-last < int64(errorLogInterval) || !e.lastErrorLog.CompareAndSwap(last, now) {
+		return
+	}
+	slog.Warn("failed to export spans", "component", "tracing", "endpoint", e.endpoint,
+		"error", err, "suppressed_for", errorLogInterval)
+}
+
+// OTLP JSON encoding of an ExportTraceServiceRequest. IDs are hex and
+// 64-bit integers are strings, as the OTLP JSON mapping requires.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		TraceState        string         `json:"traceState,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Events            []otlpEvent    `json:"events,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpEvent struct {
+		TimeUnixNano string         `json:"timeUnixNano"`
+		Name         string         `json:"name"`
+		Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+		BoolValue   *bool   `json:"boolValue,omitempty"`
+	}
+)
+
+// request builds the export request for a batch of spans
+func (e *exporter) request(spans []*Span) otlpRequest {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           s.context.TraceID.String(),
+			SpanID:            s.context.SpanID.String(),
+			TraceState:        s.context.TraceState,
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: unixNano(s.start),
+			EndTimeUnixNano:   unixNano(s.end),
+			Attributes:        keyValues(s.attributes),
+			Status:            otlpStatus{Code: s.status, Message: s.statusMsg},
+		}
+		if !s.parent.IsZero() {
+			span.ParentSpanID = s.parent.String()
+		}
+		for _, ev := range s.events {
+			span.Events = append(span.Events, otlpEvent{
+				TimeUnixNano: unixNano(ev.time),
+				Name:         ev.name,
+				Attributes:   keyValues(ev.attributes),
+			})
+		}
+		out = append(out, span)
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: keyValues([]attribute{{"service.name", e.serviceName}})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "nexus"}, Spans: out}},
+	}}}
+}
+
+// keyValues encodes attributes as OTLP key/value pairs
+func keyValues(attrs []attribute) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		var v otlpValue
+		switch val := a.value.(type) {
+		case string:
+			v.StringValue = &val
+		case int64:
+			s := strconv.FormatInt(val, 10)
+			v.IntValue = &s
+		case bool:
+			v.BoolValue = &val
+		}
+		kvs = append(kvs, otlpKeyValue{Key: a.key, Value: v})
+	}
+	return kvs
+}
+
+// unixNano formats a time as OTLP's string-encoded nanoseconds
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
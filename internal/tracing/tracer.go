@@ -0,0 +1,226 @@
+package tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// Span kinds, numbered as in OTLP
+const (
+	KindServer = 2
+	KindClient = 3
+)
+
+// Span status codes, numbered as in OTLP
+const (
+	statusUnset = 0
+	statusError = 2
+)
+
+// Tracer starts spans and hands finished, sampled ones to its exporter. A
+// nil *Tracer, like a nil *Span, does nothing, so tracing costs nothing
+// when it is not configured.
+type Tracer struct {
+	exporter *exporter
+	// ratio is the sampling threshold applied to the trace ID of new traces
+	ratio uint64
+}
+
+// NewTracer creates a tracer exporting to an OTLP/HTTP endpoint such as
+// "http://localhost:4318/v1/traces". New traces are sampled with the given
+// probability; requests arriving with a traceparent follow its decision.
+func NewTracer(endpoint, serviceName string, sampleRatio float64) *Tracer {
+	return &Tracer{
+		exporter: newExporter(endpoint, serviceName),
+		ratio:    uint64(min(max(sampleRatio, 0), 1) * (1 << 63)),
+	}
+}
+
+// Shutdown exports buffered spans and stops the exporter
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.exporter.shutdown(ctx)
+}
+
+// StartRequest starts a server span for an incoming request, continuing the
+// trace from its traceparent header if it carries a valid one
+func (t *Tracer) StartRequest(r *http.Request, name string) *Span {
+	if t == nil {
+		return nil
+	}
+
+	parent, err := ParseTraceparent(r.Header.Get(TraceparentHeader))
+	sc := SpanContext{SpanID: newSpanID()}
+	var parentID SpanID
+	if err == nil {
+		sc.TraceID = parent.TraceID
+		sc.Flags = parent.Flags
+		sc.TraceState = r.Header.Get(TracestateHeader)
+		parentID = parent.SpanID
+	} else {
+		sc.TraceID = newTraceID()
+		if t.sample(sc.TraceID) {
+			sc.Flags = flagSampled
+		}
+	}
+
+	return t.start(name, KindServer, sc, parentID)
+}
+
+// sample decides whether a new trace is recorded
+func (t *Tracer) sample(id TraceID) bool {
+	return binary.BigEndian.Uint64(id[8:])>>1 < t.ratio
+}
+
+// start creates a span with the given identity
+func (t *Tracer) start(name string, kind int, sc SpanContext, parent SpanID) *Span {
+	s := &Span{tracer: t, context: sc}
+	if sc.Sampled() {
+		s.name = name
+		s.kind = kind
+		s.parent = parent
+		s.start = time.Now()
+	}
+	return s
+}
+
+// Span is one timed operation within a trace. Unsampled spans still carry
+// a context to propagate but record nothing. Spans are not safe for
+// concurrent use.
+type Span struct {
+	tracer  *Tracer
+	context SpanContext
+
+	name       string
+	kind       int
+	parent     SpanID
+	start, end time.Time
+	attributes []attribute
+	events     []event
+	status     int
+	statusMsg  string
+}
+
+// attribute is a key/value pair attached to a span or event
+type attribute struct {
+	key   string
+	value any // string, int64 or bool
+}
+
+// event is a timestamped annotation on a span
+type event struct {
+	time       time.Time
+	name       string
+	attributes []attribute
+}
+
+// Context returns the span's propagation context
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// recording reports whether the span is collecting data
+func (s *Span) recording() bool {
+	return s != nil && s.context.Sampled()
+}
+
+// StartChild starts a span within the same trace as s
+func (s *Span) StartChild(name string, kind int) *Span {
+	if s == nil {
+		return nil
+	}
+	sc := s.context
+	sc.SpanID = newSpanID()
+	return s.tracer.start(name, kind, sc, s.context.SpanID)
+}
+
+// SetString sets a string attribute
+func (s *Span) SetString(key, value string) {
+	if s.recording() {
+		s.attributes = append(s.attributes, attribute{key, value})
+	}
+}
+
+// SetInt sets an integer attribute
+func (s *Span) SetInt(key string, value int) {
+	if s.recording() {
+		s.attributes = append(s.attributes, attribute{key, int64(value)})
+	}
+}
+
+// AddEvent records a named event with string attributes given as key/value pairs
+func (s *Span) AddEvent(name string, kv ...string) {
+	if !s.recording() {
+		return
+	}
+	e := event{time: time.Now(), name: name}
+	for i := 0; i+1 < len(kv); i += 2 {
+		e.attributes = append(e.attributes, attribute{kv[i], kv[i+1]})
+	}
+	s.events = append(s.events, e)
+}
+
+// SetHTTPStatus records the response status code, marking 5xx as an error
+func (s *Span) SetHTTPStatus(code int) {
+	if !s.recording() {
+		return
+	}
+	s.SetInt("http.response.status_code", code)
+	if code >= 500 {
+		s.status = statusError
+		s.statusMsg = http.StatusText(code)
+	}
+}
+
+// Inject returns a shallow copy of r whose headers carry the span's
+// context, for sending to the next hop. r itself is not modified.
+func (s *Span) Inject(r *http.Request) *http.Request {
+	if s == nil {
+		return r
+	}
+	out := r.WithContext(r.Context())
+	out.Header = r.Header.Clone()
+	out.Header.Set(TraceparentHeader, s.context.Traceparent())
+	if s.context.TraceState != "" {
+		out.Header.Set(TracestateHeader, s.context.TraceState)
+	} else {
+		out.Header.Del(TracestateHeader)
+	}
+	return out
+}
+
+// End finishes the span and queues it for export if it was sampled
+func (s *Span) End() {
+	if !s.recording() || !s.end.IsZero() {
+		return
+	}
+	s.end = time.Now()
+	s.tracer.exporter.enqueue(s)
+}
+
+// newTraceID returns a random, non-zero trace ID
+func newTraceID() TraceID {
+	var id TraceID
+	for id.IsZero() {
+		binary.BigEndian.PutUint64(id[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(id[8:], rand.Uint64())
+	}
+	return id
+}
+
+// newSpanID returns a random, non-zero span ID
+func newSpanID() SpanID {
+	var id SpanID
+	for id.IsZero() {
+		binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	}
+	return id
+}
@@ -0,0 +1,88 @@
+package udpproxy
+
+import (
+	"bytes"
+	"net"
+	"time"
+)
+
+// HealthOptions configure how a proxy finds out about its targets. UDP has
+// no connection to check, so without Send a target is only taken out of
+// rotation when its host answers a datagram with an ICMP port unreachable,
+// and is put back after an Interval to be tried again.
+type HealthOptions struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	// Send is a heartbeat datagram sent to each target every interval; the
+	// target is up if it answers within the timeout
+	Send []byte
+	// Expect, if set, must appear in that answer; answers without it are
+	// ignored
+	Expect []byte
+}
+
+// checkHealth checks every target every interval until the proxy stops
+func (p *Proxy) checkHealth() {
+	defer p.wg.Done()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			for _, t := range p.targets {
+				p.checkTarget(t)
+			}
+			timer.Reset(p.o.Health.Interval)
+		case <-p.stopping:
+			return
+		}
+	}
+}
+
+// checkTarget probes t, or without a heartbeat retries it once it has been
+// down an interval, and logs the transition if its state changed
+func (p *Proxy) checkTarget(t *Target) {
+	if len(p.o.Health.Send) == 0 {
+		if !t.alive.Load() && time.Since(time.Unix(0, t.downSince.Load())) >= p.o.Health.Interval {
+			t.alive.Store(true)
+			p.logger.Info("retrying unreachable backend", "backend", t.Addr, "transition", "DOWN -> UP")
+		}
+		return
+	}
+	err := p.probe(t.Addr)
+	alive := err == nil
+	if t.alive.Swap(alive) == alive {
+		return
+	}
+	if alive {
+		p.logger.Info("backend recovered", "backend", t.Addr, "transition", "DOWN -> UP")
+	} else {
+		t.downSince.Store(time.Now().UnixNano())
+		p.logger.Warn("backend failed heartbeat", "backend", t.Addr, "transition", "UP -> DOWN", "error", err)
+	}
+}
+
+// probe sends the heartbeat to addr and waits for its answer within the
+// timeout
+func (p *Proxy) probe(addr string) error {
+	h := p.o.Health
+	conn, err := net.DialTimeout("udp", addr, h.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(h.Timeout))
+	if _, err := conn.Write(h.Send); err != nil {
+		return err
+	}
+	buf := make([]byte, p.o.MaxDatagramBytes)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(buf[:n], h.Expect) {
+			return nil
+		}
+	}
+}
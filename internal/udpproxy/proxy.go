@@ -0,0 +1,264 @@
+// Package udpproxy spreads UDP datagrams, such as syslog or StatsD traffic,
+// across backends. Each client address is a session with a socket of its
+// own towards one backend, chosen by a hash of the client IP so a client's
+// stream keeps going to the same backend while it is up; answers from the
+// backend are relayed back to the client.
+package udpproxy
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Options configure a Proxy
+type Options struct {
+	// Name identifies the listener in logs and metrics
+	Name string
+	// Listen is the address datagrams are received on
+	Listen string
+	// Backends are the host:port addresses datagrams are forwarded to
+	Backends []string
+	// SessionTimeout ends the session of a client that sent nothing and
+	// got nothing back for that long
+	SessionTimeout time.Duration
+	// MaxDatagramBytes is the largest datagram forwarded either way; larger
+	// ones are dropped
+	MaxDatagramBytes int
+	Health           HealthOptions
+	Logger           *slog.Logger
+}
+
+// Reasons datagrams are dropped
+const (
+	DropTooLarge  = "too_large"
+	DropNoBackend = "no_backend"
+	DropSendError = "send_error"
+)
+
+// dropReasons are the reasons counted in Stats.Dropped
+var dropReasons = []string{DropTooLarge, DropNoBackend, DropSendError}
+
+// Stats are the counts of a proxy
+type Stats struct {
+	Name     string `json:"name"`
+	Listen   string `json:"listen"`
+	Sessions int    `json:"sessions"`
+	// Dropped counts the datagrams not forwarded either way, by reason
+	Dropped map[string]uint64 `json:"dropped"`
+	Targets []TargetStats     `json:"backends"`
+}
+
+// Proxy receives datagrams on a listener and forwards each client's to its
+// backend
+type Proxy struct {
+	o       Options
+	logger  *slog.Logger
+	targets []*Target
+
+	conn     *net.UDPConn
+	stopping chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup // the read loop, the health checker and the reaper
+
+	mu       sync.Mutex
+	sessions map[netip.AddrPort]*session // by client address
+
+	dropped map[string]*atomic.Uint64
+}
+
+// New creates a proxy; its backends count as up until found otherwise
+func New(o Options) *Proxy {
+	logger := o.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	p := &Proxy{
+		o:        o,
+		logger:   logger.With("component", "udp", "listener", o.Name),
+		stopping: make(chan struct{}),
+		sessions: make(map[netip.AddrPort]*session),
+		dropped:  make(map[string]*atomic.Uint64, len(dropReasons)),
+	}
+	for _, reason := range dropReasons {
+		p.dropped[reason] = new(atomic.Uint64)
+	}
+	for _, addr := range o.Backends {
+		p.targets = append(p.targets, newTarget(addr))
+	}
+	return p
+}
+
+// Name returns the name of the listener
+func (p *Proxy) Name() string {
+	return p.o.Name
+}
+
+// Start launches the read loop on conn, a socket bound to Options.Listen,
+// the health checker and the reaper of idle sessions
+func (p *Proxy) Start(conn *net.UDPConn) {
+	p.conn = conn
+	p.logger.Info("UDP proxy listening", "addr", conn.LocalAddr().String(), "backends", len(p.targets),
+		"session_timeout", p.o.SessionTimeout, "max_datagram_bytes", p.o.MaxDatagramBytes)
+	p.wg.Add(3)
+	go p.serve()
+	go p.checkHealth()
+	go p.reap()
+}
+
+// serve forwards the datagrams of clients until the listener is closed
+func (p *Proxy) serve() {
+	defer p.wg.Done()
+	// One byte more than the limit tells datagrams over it apart
+	buf := make([]byte, p.o.MaxDatagramBytes+1)
+	for {
+		n, client, err := p.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			p.logger.Warn("reading datagram failed", "error", err)
+			continue
+		}
+		if n > p.o.MaxDatagramBytes {
+			p.dropped[DropTooLarge].Add(1)
+			continue
+		}
+		p.forward(buf[:n], netip.AddrPortFrom(client.Addr().Unmap(), client.Port()))
+	}
+}
+
+// forward sends a datagram from client to its backend. A backend refusing
+// it, as reported by the ICMP error of an earlier datagram, is taken out of
+// rotation and the datagram goes to the client's next backend instead.
+func (p *Proxy) forward(b []byte, client netip.AddrPort) {
+	for range len(p.targets) {
+		s := p.session(client)
+		if s == nil {
+			p.dropped[DropNoBackend].Add(1)
+			return
+		}
+		err := s.send(b)
+		if err == nil {
+			return
+		}
+		s.target.sendErrors.Add(1)
+		s.close()
+		if !isRefused(err) {
+			p.dropped[DropSendError].Add(1)
+			return
+		}
+		p.markDown(s.target, err)
+	}
+	p.dropped[DropNoBackend].Add(1)
+}
+
+// session returns the session of client, starting one towards the client's
+// backend if it has none. It returns nil if no backend is up.
+func (p *Proxy) session(client netip.AddrPort) *session {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.sessions[client]; ok && !s.closed.Load() {
+		return s
+	}
+	ip := client.Addr().String()
+	for {
+		t := pick(p.targets, ip)
+		if t == nil {
+			return nil
+		}
+		s, err := p.newSession(client, t)
+		if err != nil {
+			// Only a backend that cannot even be resolved fails here
+			p.markDown(t, err)
+			continue
+		}
+		p.sessions[client] = s
+		return s
+	}
+}
+
+// markDown takes t out of rotation after a failure seen forwarding to it
+func (p *Proxy) markDown(t *Target, err error) {
+	if t.alive.Swap(false) {
+		t.downSince.Store(time.Now().UnixNano())
+		p.logger.Warn("backend unreachable", "backend", t.Addr, "transition", "UP -> DOWN", "error", err)
+	}
+}
+
+// reap ends the sessions idle for the session timeout
+func (p *Proxy) reap() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(max(p.o.SessionTimeout/4, 100*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cutoff := time.Now().Add(-p.o.SessionTimeout).UnixNano()
+			p.mu.Lock()
+			for addr, s := range p.sessions {
+				if s.closed.Load() || s.lastActive.Load() < cutoff {
+					s.close()
+					delete(p.sessions, addr)
+				}
+			}
+			p.mu.Unlock()
+		case <-p.stopping:
+			return
+		}
+	}
+}
+
+// Stats returns the counts of the proxy and its backends
+func (p *Proxy) Stats() Stats {
+	p.mu.Lock()
+	sessions := len(p.sessions)
+	p.mu.Unlock()
+	st := Stats{
+		Name:     p.o.Name,
+		Listen:   p.o.Listen,
+		Sessions: sessions,
+		Dropped:  make(map[string]uint64, len(p.dropped)),
+	}
+	for reason, n := range p.dropped {
+		st.Dropped[reason] = n.Load()
+	}
+	for _, t := range p.targets {
+		st.Targets = append(st.Targets, t.stats())
+	}
+	return st
+}
+
+// Shutdown stops receiving datagrams and ends every session. Datagrams are
+// not acknowledged, so there is nothing to drain; ctx only bounds waiting
+// for the goroutines to finish.
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	p.stopOnce.Do(func() {
+		close(p.stopping)
+		if p.conn != nil {
+			p.conn.Close()
+		}
+		p.mu.Lock()
+		for addr, s := range p.sessions {
+			s.close()
+			delete(p.sessions, addr)
+		}
+		p.mu.Unlock()
+	})
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,97 @@
+package udpproxy
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// session is a client address and the socket its datagrams are forwarded
+// to its target through. The socket is connected, so the ICMP errors of a
+// backend not listening come back from it as ECONNREFUSED.
+type session struct {
+	p      *Proxy
+	client netip.AddrPort
+	target *Target
+	conn   *net.UDPConn
+
+	closed    atomic.Bool
+	closeOnce sync.Once
+
+	// lastActive is when a datagram last went either way, in unix
+	// nanoseconds
+	lastActive atomic.Int64
+}
+
+// newSession opens a socket towards t for client and starts relaying what
+// the backend answers
+func (p *Proxy) newSession(client netip.AddrPort, t *Target) (*session, error) {
+	raddr, err := net.ResolveUDPAddr("udp", t.Addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	s := &session{p: p, client: client, target: t, conn: conn}
+	s.lastActive.Store(time.Now().UnixNano())
+	t.sessions.Add(1)
+	go s.relay()
+	return s, nil
+}
+
+// send forwards a datagram from the client
+func (s *session) send(b []byte) error {
+	if _, err := s.conn.Write(b); err != nil {
+		return err
+	}
+	s.lastActive.Store(time.Now().UnixNano())
+	s.target.packetsIn.Add(1)
+	s.target.bytesIn.Add(uint64(len(b)))
+	return nil
+}
+
+// relay sends what the backend answers back to the client until the
+// session is closed or the backend turns out not to be listening
+func (s *session) relay() {
+	buf := make([]byte, s.p.o.MaxDatagramBytes+1)
+	for {
+		n, err := s.conn.Read(buf)
+		if err != nil {
+			if isRefused(err) {
+				s.p.markDown(s.target, err)
+			}
+			s.close()
+			return
+		}
+		if n > s.p.o.MaxDatagramBytes {
+			s.p.dropped[DropTooLarge].Add(1)
+			continue
+		}
+		if _, err := s.p.conn.WriteToUDPAddrPort(buf[:n], s.client); err != nil {
+			continue
+		}
+		s.lastActive.Store(time.Now().UnixNano())
+		s.target.packetsOut.Add(1)
+		s.target.bytesOut.Add(uint64(n))
+	}
+}
+
+// close closes the socket, ending the relay
+func (s *session) close() {
+	s.closeOnce.Do(func() {
+		s.closed.Store(true)
+		s.conn.Close()
+		s.target.sessions.Add(-1)
+	})
+}
+
+// isRefused reports whether err is a backend's ICMP port unreachable
+func isRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
@@ -0,0 +1,82 @@
+package udpproxy
+
+import (
+	"hash/fnv"
+	"sync/atomic"
+)
+
+// Target is a backend of a UDP proxy
+type Target struct {
+	Addr string
+
+	alive atomic.Bool
+	// downSince is when the target was last taken out of rotation, in unix
+	// nanoseconds
+	downSince atomic.Int64
+	sessions  atomic.Int64
+
+	packetsIn  atomic.Uint64
+	packetsOut atomic.Uint64
+	bytesIn    atomic.Uint64
+	bytesOut   atomic.Uint64
+	sendErrors atomic.Uint64
+}
+
+func newTarget(addr string) *Target {
+	t := &Target{Addr: addr}
+	t.alive.Store(true)
+	return t
+}
+
+// IsAlive reports whether the target is in rotation
+func (t *Target) IsAlive() bool {
+	return t.alive.Load()
+}
+
+// TargetStats are the counts of a target. In counts what clients sent to the
+// backend, Out what it sent back.
+type TargetStats struct {
+	Addr       string `json:"address"`
+	Alive      bool   `json:"alive"`
+	Sessions   int64  `json:"sessions"`
+	PacketsIn  uint64 `json:"packets_in"`
+	PacketsOut uint64 `json:"packets_out"`
+	BytesIn    uint64 `json:"bytes_in"`
+	BytesOut   uint64 `json:"bytes_out"`
+	SendErrors uint64 `json:"send_errors"`
+}
+
+func (t *Target) stats() TargetStats {
+	return TargetStats{
+		Addr:       t.Addr,
+		Alive:      t.alive.Load(),
+		Sessions:   t.sessions.Load(),
+		PacketsIn:  t.packetsIn.Load(),
+		PacketsOut: t.packetsOut.Load(),
+		BytesIn:    t.bytesIn.Load(),
+		BytesOut:   t.bytesOut.Load(),
+		SendErrors: t.sendErrors.Load(),
+	}
+}
+
+// pick returns the target for the client with IP ip among those alive, nil
+// if none is. It hashes the IP together with each target's address and takes
+// the highest score, so a client stays on its target while that is up and
+// only the clients of a target that goes down move.
+func pick(targets []*Target, ip string) *Target {
+	var best *Target
+	var bestScore uint64
+	for _, t := range targets {
+		if !t.alive.Load() {
+			continue
+		}
+		h := fnv.New64a()
+		h.Write([]byte(ip))
+		h.Write([]byte{0})
+		h.Write([]byte(t.Addr))
+		if score := h.Sum64(); best == nil || score > bestScore {
+			best, bestScore = t, score
+		}
+	}
+	return best
+}
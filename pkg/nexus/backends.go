@@ -0,0 +1,121 @@
+package nexus
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/journal"
+)
+
+// actor is who the journal records changes made through the library as
+const actor = "library"
+
+var (
+	// ErrBackendExists is returned when adding a backend already in the pool
+	ErrBackendExists = errors.New("backend already exists")
+	// ErrNoBackend is returned when removing a backend not in the pool
+	ErrNoBackend = errors.New("no such backend")
+)
+
+// BackendStatus is the state of one backend in the pool
+type BackendStatus struct {
+	URL            string `json:"url"`
+	Weight         int    `json:"weight"`
+	Alive          bool   `json:"alive"`
+	Draining       bool   `json:"draining"`
+	ActiveRequests int64  `json:"active_requests"`
+	// Pool is the traffic split pool of the backend, empty for the default
+	Pool string `json:"pool,omitempty"`
+	// Tier is the failover tier of the backend, 0 for the primary
+	Tier int `json:"tier,omitempty"`
+	// Origin tells where the backend came from, and Source the discovery
+	// source that found it
+	Origin string            `json:"origin"`
+	Source string            `json:"source,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Status is the state of the pool
+type Status struct {
+	Alive    int             `json:"alive"`
+	Total    int             `json:"total"`
+	Backends []BackendStatus `json:"backends"`
+}
+
+// Status returns the state of the pool and of each of its backends
+func (lb *LoadBalancer) Status() Status {
+	backends := lb.pool.GetBackends()
+	s := Status{Total: len(backends), Backends: make([]BackendStatus, 0, len(backends))}
+	for _, b := range backends {
+		alive := b.IsAlive()
+		if alive {
+			s.Alive++
+		}
+		s.Backends = append(s.Backends, BackendStatus{
+			URL:            b.URL.String(),
+			Weight:         b.Weight,
+			Alive:          alive,
+			Draining:       b.Draining(),
+			ActiveRequests: b.ActiveRequests(),
+			Pool:           b.Group,
+			Tier:           b.Tier,
+			Origin:         b.Origin,
+			Source:         b.Source,
+			Labels:         b.Labels,
+		})
+	}
+	return s
+}
+
+// AddBackend adds a backend to the pool, with the options of one added
+// through the admin API; a weight of 0 counts as 1. The health checker
+// picks it up on its next round and the proxy can select it immediately.
+func (lb *LoadBalancer) AddBackend(rawURL string, weight int) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an absolute http or https URL", rawURL)
+	}
+	if weight < 0 {
+		return fmt.Errorf("weight %d is negative", weight)
+	}
+	if weight == 0 {
+		weight = 1
+	}
+	if b := lb.pool.GetBackend(u.String()); b != nil {
+		return fmt.Errorf("%w: %s (origin %s)", ErrBackendExists, u.String(), b.Origin)
+	}
+
+	b, err := lb.newBackend(u.String(), weight, backend.OriginLibrary)
+	if err != nil {
+		return err
+	}
+	lb.pool.AddBackend(b)
+	lb.journal.Record(journal.TypeLibrary, actor,
+		fmt.Sprintf("added backend %s (weight %d)", b.URL.String(), b.Weight), nil)
+	return nil
+}
+
+// RemoveBackend removes a backend from the pool. With drain the backend
+// stops receiving new requests at once and is removed when its in-flight
+// requests finish, or the admin drain timeout elapses.
+func (lb *LoadBalancer) RemoveBackend(rawURL string, drain bool) error {
+	b := lb.pool.GetBackend(rawURL)
+	if b == nil {
+		return fmt.Errorf("%w: %s", ErrNoBackend, rawURL)
+	}
+
+	if !drain {
+		lb.pool.RemoveBackend(rawURL)
+		lb.journal.Record(journal.TypeLibrary, actor, "removed backend "+rawURL, nil)
+		return nil
+	}
+
+	b.StartDraining()
+	slog.Info("draining backend", "backend", rawURL, "inflight", b.ActiveRequests(), "actor", actor)
+	lb.journal.Record(journal.TypeLibrary, actor, "draining and removing backend "+rawURL, nil)
+	go lb.pool.DrainBackend(b, lb.cfg.Admin.DrainTimeout.Std())
+	return nil
+}
@@ -0,0 +1,72 @@
+package nexus
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+)
+
+func TestAddBackend(t *testing.T) {
+	first := newRecordingBackend(t)
+	added := newRecordingBackend(t)
+	lb := newTestLB(t, []string{first.URL}, "")
+
+	if err := lb.AddBackend(added.URL, 0); err != nil {
+		t.Fatal(err)
+	}
+	s := lb.Status()
+	if s.Total != 2 || s.Backends[1].URL != added.URL || s.Backends[1].Weight != 1 || s.Backends[1].Origin != backend.OriginLibrary {
+		t.Fatalf("status after adding: %+v", s)
+	}
+	// The proxy can select the new backend at once
+	for range 2 {
+		lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/x", nil))
+	}
+	if len(added.seen()) != 1 {
+		t.Errorf("added backend saw %v, want one request", added.seen())
+	}
+
+	if err := lb.AddBackend(added.URL, 2); !errors.Is(err, ErrBackendExists) {
+		t.Errorf("adding twice: %v, want ErrBackendExists", err)
+	}
+	for _, bad := range []string{"", "/relative", "ftp://example.com", "http://"} {
+		if err := lb.AddBackend(bad, 1); err == nil {
+			t.Errorf("%q: accepted", bad)
+		}
+	}
+	if err := lb.AddBackend("http://example.com", -1); err == nil {
+		t.Error("negative weight accepted")
+	}
+	if lb.Status().Total != 2 {
+		t.Errorf("failed additions changed the pool: %+v", lb.Status())
+	}
+}
+
+func TestRemoveBackend(t *testing.T) {
+	a := newRecordingBackend(t)
+	b := newRecordingBackend(t)
+	lb := newTestLB(t, []string{a.URL, b.URL}, `"admin": {"drain_timeout": "1s"}`)
+
+	if err := lb.RemoveBackend(a.URL, false); err != nil {
+		t.Fatal(err)
+	}
+	if s := lb.Status(); s.Total != 1 || s.Backends[0].URL != b.URL {
+		t.Fatalf("status after removing: %+v", s)
+	}
+	if err := lb.RemoveBackend(a.URL, false); !errors.Is(err, ErrNoBackend) {
+		t.Errorf("removing twice: %v, want ErrNoBackend", err)
+	}
+
+	// A drained backend gets no new requests and leaves once idle
+	if err := lb.RemoveBackend(b.URL, true); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	lb.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/x", nil))
+	if w.Code != http.StatusServiceUnavailable || len(b.seen()) != 0 {
+		t.Errorf("draining backend served: status %d, seen %v", w.Code, b.seen())
+	}
+}
@@ -0,0 +1,890 @@
+package nexus
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/nexus-lb/nexus/config"
+	"github.com/nexus-lb/nexus/internal/accesslog"
+	"github.com/nexus-lb/nexus/internal/audit"
+	"github.com/nexus-lb/nexus/internal/auth"
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/cors"
+	"github.com/nexus-lb/nexus/internal/discovery"
+	"github.com/nexus-lb/nexus/internal/errorpage"
+	"github.com/nexus-lb/nexus/internal/events"
+	"github.com/nexus-lb/nexus/internal/freeze"
+	"github.com/nexus-lb/nexus/internal/headers"
+	"github.com/nexus-lb/nexus/internal/health"
+	"github.com/nexus-lb/nexus/internal/ipfilter"
+	"github.com/nexus-lb/nexus/internal/journal"
+	"github.com/nexus-lb/nexus/internal/jwt"
+	"github.com/nexus-lb/nexus/internal/logdedup"
+	"github.com/nexus-lb/nexus/internal/maintenance"
+	"github.com/nexus-lb/nexus/internal/metrics"
+	"github.com/nexus-lb/nexus/internal/mirror"
+	"github.com/nexus-lb/nexus/internal/pool"
+	"github.com/nexus-lb/nexus/internal/proxy"
+	"github.com/nexus-lb/nexus/internal/ratelimit"
+	"github.com/nexus-lb/nexus/internal/rewrite"
+	"github.com/nexus-lb/nexus/internal/route"
+	"github.com/nexus-lb/nexus/internal/split"
+	"github.com/nexus-lb/nexus/internal/statsd"
+	"github.com/nexus-lb/nexus/internal/tracing"
+)
+
+// build creates the pool, the handler chain and everything they report
+// to, leaving the health checker and discovery sources to be started
+func (lb *LoadBalancer) build() error {
+	cfg := lb.cfg
+
+	// Create the server pool
+	lb.pool = &pool.ServerPool{}
+
+	// Record configuration and operator changes, publishing them as events
+	lb.bus = events.NewBus()
+	lb.journal = journal.New(cfg.Admin.JournalSize, lb.bus)
+	lb.watchLifecycle()
+
+	// Route automatic state changes through the freeze controller
+	lb.freeze = freeze.NewController(
+		cfg.Admin.Freeze.MaxDuration.Std(),
+		cfg.Admin.Freeze.OnExpire == "apply",
+		cfg.Admin.Freeze.LogOnlyHealth,
+	)
+	lb.freeze.SetNotify(func(actor, summary string) {
+		lb.journal.Record(journal.TypeFreeze, actor, summary, nil)
+	})
+	lb.pool.SetGate(lb.freeze)
+
+	// Keep a history of backend up/down transitions and publish them
+	lb.history = audit.New(cfg.Admin.EventsSize)
+	if cfg.Admin.EventsFile != "" {
+		if err := lb.history.OpenFile(cfg.Admin.EventsFile); err != nil {
+			return fmt.Errorf("events file: %w", err)
+		}
+	}
+	// Requests waiting for a backend are woken by every state change
+	queue := proxy.NewQueue(cfg.Queue.MaxDepth, cfg.Queue.MaxWait.Std())
+	lb.pool.SetStateListener(func(b *backend.Backend, alive bool, cause backend.Cause) {
+		lb.history.Record(b.URL.String(), alive, cause)
+		lb.bus.Publish(backend.StateEventType, backend.StateChange{URL: b.URL.String(), Alive: alive, Cause: cause})
+		queue.Notify()
+	})
+	lb.pool.SetMembershipListener(func(b *backend.Backend, added bool) {
+		lb.bus.Publish(pool.MembershipEventType, pool.MembershipChange{URL: b.URL.String(), Weight: b.Weight, Added: added})
+		queue.Notify()
+	})
+
+	// Collapse bursts of identical failure lines, such as every in-flight
+	// request failing at once when a backend dies
+	logDedup := logdedup.New(cfg.Log.DedupWindow.Std())
+
+	// Only trusted proxies may vouch for the client through X-Forwarded-*
+	trustedProxies, err := backend.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("trusted_proxies: %w", err)
+	}
+
+	errorPages, err := loadErrorPages(cfg.ErrorPages)
+	if err != nil {
+		return fmt.Errorf("error_pages: %w", err)
+	}
+
+	// Options every backend gets, whether configured or added at runtime
+	lb.backendOpts = []backend.Option{
+		backend.WithLogDedup(logDedup),
+		backend.WithTrustedProxies(trustedProxies),
+		backend.WithErrorPages(errorPages),
+		backend.WithServerErrorPolicy(serverErrorPolicy(cfg.HealthCheck.Passive)),
+		backend.WithHeaderRules(headerRules(cfg.Headers)),
+		backend.WithUpgradeIdleTimeout(cfg.WebSocket.IdleTimeout.Std()),
+	}
+	if cb := cfg.CircuitBreaker; cb != nil {
+		lb.backendOpts = append(lb.backendOpts, backend.WithBreaker(backend.BreakerPolicy{
+			Failures:         cb.Failures,
+			Window:           cb.Window.Std(),
+			Cooloff:          cb.Cooloff.Std(),
+			HalfOpenRequests: cb.HalfOpenRequests,
+		}))
+	}
+	if cfg.SetRealIP {
+		lb.backendOpts = append(lb.backendOpts, backend.WithRealIP())
+	}
+	if cfg.TLS.ForwardClientCert {
+		lb.backendOpts = append(lb.backendOpts, backend.WithClientCertHeader())
+	}
+	if cfg.HideIdentityHeaders {
+		lb.backendOpts = append(lb.backendOpts, backend.WithoutIdentityHeaders())
+	}
+
+	if err := lb.addBackends(); err != nil {
+		return err
+	}
+
+	// Create the metrics shared by the proxy, health checker and admin API
+	lb.metrics = metrics.New(cfg.Metrics.DurationBuckets)
+
+	// Optionally push metrics to StatsD as well
+	if sd := cfg.StatsD; sd != nil {
+		client, err := statsd.NewClient(sd.Address, sd.Prefix, sd.SampleRate, sd.DogStatsD, sd.Tags)
+		if err != nil {
+			return fmt.Errorf("statsd: %w", err)
+		}
+		lb.statsd = statsd.NewReporter(client)
+		lb.statsd.Watch(lb.bus)
+		lb.metrics.SetExporter(lb.statsd)
+		slog.Info("sending StatsD metrics", "address", sd.Address, "sample_rate", sd.SampleRate)
+	}
+
+	// Create the health checker, started once everything is built
+	lb.health = health.NewHealthChecker(lb.pool, cfg.HealthCheck.Interval.Std(), cfg.HealthCheck.Timeout.Std())
+	if a := cfg.HealthCheck.Adaptive; a != nil {
+		lb.health.SetAdaptive(health.Cadence{Min: a.MinInterval.Std(), Max: a.MaxInterval.Std()})
+	}
+	lb.health.SetMetrics(lb.metrics)
+
+	// Create the load balancing handler
+	handler := proxy.NewHandler(lb.pool, cfg.MaxRetries)
+	lb.handler = handler
+	handler.SetMetrics(lb.metrics)
+	handler.SetLogDedup(logDedup)
+	handler.SetTrustedProxies(trustedProxies)
+	handler.SetMaxReplayBody(cfg.Retry.MaxBodyBytes)
+	handler.SetRetryPolicy(retryPolicy(cfg.Retry))
+	handler.SetBodyLimits(bodyLimits(cfg.RequestBody))
+	handler.SetDeadlines(deadlines(cfg.Server))
+	handler.SetFlushRoutes(flushRoutes(cfg.Server))
+	handler.SetErrorPages(errorPages)
+	if cfg.Queue.MaxDepth > 0 {
+		handler.SetQueue(queue)
+		lb.metrics.Registry.NewGaugeFunc("nexus_queue_depth", "Requests waiting in the queue for a backend.", nil, func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(queue.Depth())}}
+		})
+	}
+	if hc := cfg.Hedging; hc != nil {
+		handler.SetHedging(hedgePolicy(hc))
+		slog.Info("hedging slow requests", "delay", hc.Delay.Std(), "delay_percentile", hc.DelayPercentile)
+	}
+
+	// Divide traffic between the backend pools; the shares can be changed
+	// through the admin API
+	if sc := cfg.Split; sc != nil {
+		lb.splitter, err = split.New(cfg.Pools(), split.Options{
+			Percent:      sc.Percent,
+			Sticky:       sc.Sticky,
+			Cookie:       sc.Cookie,
+			StickyHeader: sc.StickyHeader,
+			Header:       sc.Header,
+			Trusted:      trustedProxies,
+		})
+		if err != nil {
+			return fmt.Errorf("split: %w", err)
+		}
+		handler.SetSplit(lb.splitter)
+		slog.Info("splitting traffic between pools", "percent", lb.splitter.Status().Percent, "sticky", sc.Sticky)
+	}
+
+	// Answer the pools put in maintenance through the admin API with a 503
+	lb.maint, err = newMaintenance(cfg, trustedProxies, errorPages, lb.metrics)
+	if err != nil {
+		return fmt.Errorf("maintenance: %w", err)
+	}
+	handler.SetMaintenance(lb.maint)
+
+	// Redirect and rewrite requests ahead of routing
+	rewriter, err := newRewriter(cfg.Rewrites)
+	if err != nil {
+		return fmt.Errorf("rewrites: %w", err)
+	}
+	if rewriter != nil {
+		handler.SetRewriter(rewriter)
+		slog.Info("rewriting requests", "rules", len(cfg.Rewrites))
+	}
+
+	// Route requests to the pools by rule, host and path; the split only
+	// divides what is left for the default pool
+	var authGates []*auth.Gate
+	if rc := cfg.Routing; rc != nil {
+		router, parts, err := newRouter(rc, trustedProxies, lb.metrics)
+		if err != nil {
+			return fmt.Errorf("routing: %w", err)
+		}
+		for _, s := range router.Shadowed() {
+			slog.Warn("routing rule is unreachable", "rule", s.Rule, "shadowed_by", s.By)
+		}
+		handler.SetRouter(router)
+		lb.ipFilters, authGates = parts.filters, parts.gates
+		slog.Info("routing requests", "rules", len(rc.Rules), "hosts", len(rc.Hosts), "paths", len(rc.Paths),
+			"no_host", rc.NoHost, "no_match", rc.NoMatch)
+	}
+	lb.reloader = &reloader{pool: lb.pool, journal: lb.journal, current: cfg, gates: authGates}
+
+	// Mirror sampled requests to shadow backends, off the request path
+	if mc := cfg.Mirror; mc != nil {
+		lb.mirror, err = newMirror(mc, append(slices.Clone(lb.backendOpts),
+			backend.WithTransport(transportSettings(cfg.Transport))), lb.metrics)
+		if err != nil {
+			return err
+		}
+		handler.SetMirror(lb.mirror)
+		slog.Info("mirroring requests", "rules", len(mc.Rules), "workers", mc.Workers)
+	}
+
+	// Write access logs separately from the operational log, sampling
+	// successful requests if configured; the rate is adjustable at runtime
+	lb.sampler = accesslog.NewSampler(1)
+	handler.SetSampler(lb.sampler)
+	lb.metrics.Registry.NewCounterFunc("nexus_access_log_sampled_out_total",
+		"Requests left out of the access log by sampling.", nil, func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(lb.sampler.Skipped())}}
+		})
+	if a := cfg.AccessLog; a != nil {
+		lb.sampler.SetEvery(a.SampleEvery)
+		l, err := accesslog.New(accesslog.Options{
+			Output:     a.Output,
+			Format:     a.Format,
+			MaxSize:    int64(a.MaxSizeMB) << 20,
+			MaxAge:     a.MaxAge.Std(),
+			MaxBackups: a.MaxBackups,
+			QueueSize:  a.QueueSize,
+		})
+		if err != nil {
+			return fmt.Errorf("access log: %w", err)
+		}
+		lb.accessLog = l
+		handler.SetAccessLog(lb.accessLog)
+		lb.metrics.Registry.NewCounterFunc("nexus_access_log_dropped_total",
+			"Access log lines dropped because output fell behind.", nil, func() []metrics.Sample {
+				return []metrics.Sample{{Value: float64(lb.accessLog.Dropped())}}
+			})
+		slog.Info("writing access log", "format", a.Format, "output", a.Output)
+	}
+
+	// Tracing stays entirely off unless configured
+	if t := cfg.Tracing; t != nil {
+		lb.tracer = tracing.NewTracer(t.OTLPEndpoint, t.ServiceName, t.SampleRatio)
+		handler.SetTracer(lb.tracer)
+		slog.Info("exporting traces", "endpoint", t.OTLPEndpoint, "sample_ratio", t.SampleRatio)
+	}
+	lb.conns = &metrics.ConnCounters{}
+	registerPoolMetrics(lb.metrics, lb.pool, handler.Protocols(), lb.conns)
+
+	// Streams are tracked so that shutdown can cut them off
+	lb.streams = proxy.NewStreams(handler)
+	var root http.Handler = lb.streams
+	if c := cfg.Compression; c != nil {
+		root = proxy.NewCompressor(root, c.MinSize, c.Types, c.Level)
+	}
+
+	// Shed load beyond the concurrency limit; probes stay outside it
+	lb.limiter = proxy.NewConcurrencyLimiter(root, cfg.ConcurrencyLimit.MaxInFlight,
+		cfg.ConcurrencyLimit.RetryAfter.Std(), lb.metrics, errorPages)
+	root = lb.limiter
+	if n := cfg.ConcurrencyLimit.MaxInFlight; n > 0 {
+		slog.Info("limiting concurrent requests", "max_in_flight", n)
+	}
+
+	// Limit each client's request rate ahead of the concurrency limit, so
+	// a flood from one client does not take the slots of the others
+	var rateLimiter *ratelimit.Limiter
+	if rc := cfg.RateLimit; rc != nil {
+		exempt, err := ratelimit.ParseExempt(rc.Exempt)
+		if err != nil {
+			return fmt.Errorf("rate_limit: %w", err)
+		}
+		rateLimiter = ratelimit.New(root, ratelimit.Options{
+			Rate:       rc.Rate,
+			Burst:      rc.Burst,
+			MaxClients: rc.MaxClients,
+			Exempt:     exempt,
+			Trusted:    trustedProxies,
+			Metrics:    lb.metrics,
+		})
+		root = rateLimiter
+		lb.metrics.Registry.NewCounterFunc("nexus_rate_limited_requests_total",
+			"Requests answered 429, by client IP, for the clients currently tracked.", []string{"client"}, func() []metrics.Sample {
+				limited := rateLimiter.Limited()
+				samples := make([]metrics.Sample, 0, len(limited))
+				for ip, n := range limited {
+					samples = append(samples, metrics.Sample{Labels: []string{ip}, Value: float64(n)})
+				}
+				return samples
+			})
+		slog.Info("rate limiting clients", "rate", rc.Rate, "burst", rc.Burst, "max_clients", rc.MaxClients)
+	}
+
+	// Refuse oversized and ambiguous requests before they cost anything
+	// more, charging them to the client's rate limit
+	guard := proxy.GuardOptions{
+		MaxHeaders:          cfg.Server.MaxHeaderCount,
+		MaxHeaderValueBytes: cfg.Server.MaxHeaderValueBytes,
+		Trusted:             trustedProxies,
+		Metrics:             lb.metrics,
+		ErrorPages:          errorPages,
+	}
+	if rateLimiter != nil {
+		guard.OnViolation = rateLimiter.Penalize
+		lb.metrics.Registry.NewCounterFunc("nexus_request_violations_total",
+			"Requests refused as oversized or malformed, by client IP, for the clients currently tracked by the rate limiter.", []string{"client"}, func() []metrics.Sample {
+				violations := rateLimiter.Violations()
+				samples := make([]metrics.Sample, 0, len(violations))
+				for ip, n := range violations {
+					samples = append(samples, metrics.Sample{Labels: []string{ip}, Value: float64(n)})
+				}
+				return samples
+			})
+	}
+	root = proxy.NewRequestGuard(root, guard)
+
+	// Refuse denied clients before they count against any limit
+	if fc := cfg.IPFilter; fc != nil {
+		f, err := newIPFilter("global", fc, trustedProxies, lb.metrics)
+		if err != nil {
+			return fmt.Errorf("ip_filter: %w", err)
+		}
+		root = f.Wrap(root)
+		lb.ipFilters = append([]*ipfilter.Filter{f}, lb.ipFilters...)
+		slog.Info("filtering clients by IP", "allow", len(fc.Allow), "deny", len(fc.Deny))
+	}
+	if len(lb.ipFilters) > 0 {
+		lb.metrics.Registry.NewCounterFunc("nexus_ip_filter_rejected_total",
+			"Requests answered 403 by an IP filter, by filter.", []string{"filter"}, func() []metrics.Sample {
+				samples := make([]metrics.Sample, 0, len(lb.ipFilters))
+				for _, f := range lb.ipFilters {
+					samples = append(samples, metrics.Sample{Labels: []string{f.Name()}, Value: float64(f.Rejected())})
+				}
+				return samples
+			})
+	}
+
+	// Give every response the security headers, the 403s and 429s answered
+	// above included
+	if sh := cfg.SecurityHeaders; sh != nil {
+		root = securityHeaders(sh).Wrap(root)
+	}
+
+	// Answer liveness and readiness probes locally, ahead of the proxy
+	if p := cfg.Probes; p != nil {
+		lb.probes = proxy.NewProbes(lb.pool, p.MinAliveBackends, root)
+		root = lb.probes
+	}
+	lb.root = root
+	return nil
+}
+
+// addBackends adds the configured backends to the pool; SRV records,
+// Kubernetes Services, Consul services, backends files and etcd prefixes
+// stand for the backends they are discovered to have
+func (lb *LoadBalancer) addBackends() error {
+	cfg := lb.cfg
+	var kubeClient *discovery.KubeClient
+	var consulClient *discovery.ConsulClient
+	var etcdClient *discovery.EtcdClient
+	var err error
+	for _, bc := range cfg.Backends {
+		opts := configuredBackendOpts(cfg, bc, lb.backendOpts)
+		var source discovery.Source
+		if name, scheme, ok := discovery.ParseSRV(bc.URL); ok {
+			rc := newReconciler(cfg, bc.URL, backend.OriginDNS, lb.pool, opts, lb.journal, lb.freeze)
+			source = discovery.NewSRV(name, scheme, bc.ResolveInterval.Std(), rc)
+			lb.reconcilers = append(lb.reconcilers, rc)
+		} else if svc, scheme, ok := discovery.ParseKubernetes(bc.URL); ok {
+			if kubeClient == nil {
+				kc := cfg.Kubernetes
+				if kubeClient, err = discovery.NewKubeClient(kc.Kubeconfig, kc.Context); err != nil {
+					return fmt.Errorf("kubernetes: %w", err)
+				}
+			}
+			rc := newReconciler(cfg, bc.URL, backend.OriginKubernetes, lb.pool, opts, lb.journal, lb.freeze)
+			source = discovery.NewKubernetes(kubeClient, svc, scheme, cfg.Kubernetes.Resync.Std(), rc)
+			lb.reconcilers = append(lb.reconcilers, rc)
+		} else if path, ok := discovery.ParseFile(bc.URL); ok {
+			rc := newReconciler(cfg, bc.URL, backend.OriginFile, lb.pool, opts, lb.journal, lb.freeze)
+			source = discovery.NewFile(path, rc)
+			lb.reconcilers = append(lb.reconcilers, rc)
+		} else if svc, scheme, ok := discovery.ParseConsul(bc.URL); ok {
+			cc := cfg.Consul
+			if consulClient == nil {
+				consulClient = discovery.NewConsulClient(cc.Address, cc.Token, cc.Datacenter)
+			}
+			if cc.Health == "consul" {
+				opts = append(opts, backend.WithExternalHealth())
+			}
+			rc := newReconciler(cfg, bc.URL, backend.OriginConsul, lb.pool, opts, lb.journal, lb.freeze)
+			source = discovery.NewConsul(consulClient, svc, scheme, cc.Health != "nexus", rc)
+			lb.reconcilers = append(lb.reconcilers, rc)
+		} else if prefix, ok := discovery.ParseEtcd(bc.URL); ok {
+			if etcdClient == nil {
+				ec := cfg.Etcd
+				if etcdClient, err = discovery.NewEtcdClient(ec.Endpoints, ec.Username, ec.Password, ec.CAFile, ec.CertFile, ec.KeyFile); err != nil {
+					return fmt.Errorf("etcd: %w", err)
+				}
+			}
+			rc := newReconciler(cfg, bc.URL, backend.OriginEtcd, lb.pool, opts, lb.journal, lb.freeze)
+			source = discovery.NewEtcd(etcdClient, prefix, rc)
+			lb.reconcilers = append(lb.reconcilers, rc)
+		}
+		if source != nil {
+			if err := source.Refresh(); err != nil {
+				slog.Warn("initial discovery failed, retrying in the background", "component", "discovery", "source", bc.URL, "error", err)
+			}
+			lb.sources = append(lb.sources, source)
+			continue
+		}
+
+		b, err := backend.NewBackend(bc.URL, opts...)
+		if err != nil {
+			return fmt.Errorf("backend %s: %w", bc.URL, err)
+		}
+		lb.pool.AddBackend(b)
+	}
+	return nil
+}
+
+// newBackend creates a backend added at runtime, from origin
+func (lb *LoadBalancer) newBackend(url string, weight int, origin string) (*backend.Backend, error) {
+	return backend.NewBackend(url, append(slices.Clone(lb.backendOpts),
+		backend.WithWeight(weight),
+		backend.WithOrigin(origin, ""),
+		backend.WithTransport(transportSettings(lb.cfg.Transport)))...)
+}
+
+// configuredBackendOpts returns the options of a configured backend, or of
+// every backend an SRV spec resolves to
+func configuredBackendOpts(cfg *config.Config, bc config.BackendConfig, base []backend.Option) []backend.Option {
+	opts := append(slices.Clone(base),
+		backend.WithTransport(backendTransport(cfg, bc)))
+	if bc.Weight > 0 {
+		opts = append(opts, backend.WithWeight(bc.Weight))
+	}
+	if bc.FlushInterval != 0 {
+		opts = append(opts, backend.WithFlushInterval(bc.FlushInterval.Std()))
+	}
+	if bc.Pool != "" {
+		opts = append(opts, backend.WithGroup(bc.Pool))
+	}
+	if bc.HealthCheckInterval > 0 {
+		opts = append(opts, backend.WithHealthInterval(bc.HealthCheckInterval.Std()))
+	}
+	if w := bc.Warmup; w != nil {
+		opts = append(opts, backend.WithWarmup(backend.Warmup{
+			Requests:      w.Requests,
+			Path:          w.Path,
+			LatencyBudget: w.LatencyBudget.Std(),
+			Timeout:       w.Timeout.Std(),
+		}))
+	}
+	return opts
+}
+
+// newReconciler creates the reconciler of the backends discovered for spec,
+// which get the options of the spec's entry
+func newReconciler(cfg *config.Config, spec, origin string, p *pool.ServerPool, opts []backend.Option, j *journal.Journal, gate backend.Gate) *discovery.Reconciler {
+	return discovery.NewReconciler(discovery.Options{
+		Source: spec,
+		Origin: origin,
+		Pool:   p,
+		NewBackend: func(t discovery.Target) (*backend.Backend, error) {
+			return backend.NewBackend(t.URL, append(slices.Clone(opts),
+				backend.WithWeight(t.Weight),
+				backend.WithTier(t.Tier),
+				backend.WithLabels(t.Labels))...)
+		},
+		DrainTimeout: cfg.Admin.DrainTimeout.Std(),
+		Journal:      j,
+		Gate:         gate,
+	})
+}
+
+// retryPolicy converts the retry configuration for the proxy handler
+func retryPolicy(rc config.RetryConfig) proxy.RetryPolicy {
+	return proxy.RetryPolicy{
+		Backoff:     rc.Backoff.Std(),
+		Exponential: rc.BackoffType == "exponential",
+		MaxBackoff:  rc.MaxBackoff.Std(),
+		Jitter:      rc.Jitter,
+		OnStatus:    rc.OnStatus,
+		Budget:      rc.Budget.Std(),
+	}
+}
+
+// bodyLimits converts the request body size configuration
+func bodyLimits(rc config.RequestBodyConfig) proxy.BodyLimits {
+	l := proxy.BodyLimits{Default: rc.MaxBytes}
+	for _, route := range rc.Routes {
+		l.Routes = append(l.Routes, proxy.BodyLimitRoute{PathPrefix: route.PathPrefix, MaxBytes: route.MaxBytes})
+	}
+	return l
+}
+
+// newRewriter converts the rewrite rules
+func newRewriter(rc []config.RewriteRuleConfig) (*rewrite.Rewriter, error) {
+	rules := make([]rewrite.Rule, 0, len(rc))
+	for _, rw := range rc {
+		rules = append(rules, rewrite.Rule{
+			Scheme:   rw.Scheme,
+			Path:     rw.Path,
+			Redirect: rw.Redirect,
+			Status:   rw.Status,
+			Rewrite:  rw.Rewrite,
+		})
+	}
+	return rewrite.New(rules)
+}
+
+// routeParts are the parts of route middleware managed after the router is
+// built: IP filters through the admin API, auth gates on reload
+type routeParts struct {
+	filters []*ipfilter.Filter
+	gates   []*auth.Gate
+}
+
+// newRouter converts the routing configuration, returning the parts of its
+// middleware managed later as well; trusted and m serve the rate limits,
+// IP filters and auth gates of route middleware
+func newRouter(rc *config.RoutingConfig, trusted backend.TrustedProxies, m *metrics.Metrics) (*route.Router, routeParts, error) {
+	pool := func(name string) string {
+		if name == split.DefaultPool {
+			return ""
+		}
+		return name
+	}
+	var err error
+	var parts routeParts
+	mw := func(field string, mc []config.MiddlewareConfig) []func(http.Handler) http.Handler {
+		mws, mwErr := routeMiddleware(field, mc, trusted, m, &parts)
+		if mwErr != nil && err == nil {
+			err = mwErr
+		}
+		return mws
+	}
+	o := route.Options{
+		RejectNoHost:   rc.NoHost == "reject",
+		RejectNoMatch:  rc.NoMatch == "404",
+		PoolMiddleware: make(map[string][]func(http.Handler) http.Handler),
+	}
+	for name, mc := range rc.PoolMiddleware {
+		o.PoolMiddleware[pool(name)] = mw("routing.pool_middleware."+name, mc)
+	}
+	for i, ru := range rc.Rules {
+		rule := route.Rule{
+			Name:        ru.Name,
+			Host:        ru.Host,
+			PathPrefix:  ru.PathPrefix,
+			Methods:     ru.Methods,
+			Pool:        pool(ru.Pool),
+			Fallback:    ru.FallbackPool,
+			StripPrefix: ru.StripPrefix,
+			Middleware:  mw(fmt.Sprintf("routing.rules[%d].middleware", i), ru.Middleware),
+		}
+		for _, hm := range ru.Headers {
+			rule.Headers = append(rule.Headers, route.HeaderMatch{Name: hm.Name, Value: hm.Value, Regex: hm.Regex})
+		}
+		o.Rules = append(o.Rules, rule)
+	}
+	for i, hr := range rc.Hosts {
+		o.Hosts = append(o.Hosts, route.HostRoute{
+			Host:       hr.Host,
+			Pool:       pool(hr.Pool),
+			Fallback:   hr.FallbackPool,
+			Middleware: mw(fmt.Sprintf("routing.hosts[%d].middleware", i), hr.Middleware),
+		})
+	}
+	for i, pr := range rc.Paths {
+		o.Paths = append(o.Paths, route.PathRoute{
+			Name:        pr.Name,
+			Host:        pr.Host,
+			Prefix:      pr.PathPrefix,
+			Pool:        pool(pr.Pool),
+			Fallback:    pr.FallbackPool,
+			StripPrefix: pr.StripPrefix,
+			Middleware:  mw(fmt.Sprintf("routing.paths[%d].middleware", i), pr.Middleware),
+		})
+	}
+	if err != nil {
+		return nil, routeParts{}, err
+	}
+	router, err := route.New(o)
+	return router, parts, err
+}
+
+// routeMiddleware converts the middleware of a route, in order, set in
+// field; nil stays nil, for the route to take its pool's. IP filters and
+// auth gates are named after the field of their step and added to parts.
+func routeMiddleware(field string, mc []config.MiddlewareConfig, trusted backend.TrustedProxies, m *metrics.Metrics, parts *routeParts) ([]func(http.Handler) http.Handler, error) {
+	if mc == nil {
+		return nil, nil
+	}
+	mws := make([]func(http.Handler) http.Handler, 0, len(mc))
+	for i, c := range mc {
+		name := fmt.Sprintf("%s[%d]", field, i)
+		switch {
+		case c.RateLimit != nil:
+			exempt, err := ratelimit.ParseExempt(c.RateLimit.Exempt)
+			if err != nil {
+				return nil, err
+			}
+			mws = append(mws, ratelimit.Middleware(ratelimit.Options{
+				Rate:       c.RateLimit.Rate,
+				Burst:      c.RateLimit.Burst,
+				MaxClients: c.RateLimit.MaxClients,
+				Exempt:     exempt,
+				Trusted:    trusted,
+				Metrics:    m,
+			}))
+		case c.BodyLimit != nil:
+			mws = append(mws, proxy.BodyLimit(c.BodyLimit.MaxBytes))
+		case c.Timeout != nil:
+			mws = append(mws, proxy.Timeout(c.Timeout.ReadTimeout.Std(), c.Timeout.WriteTimeout.Std()))
+		case c.RequestTimeout > 0:
+			mws = append(mws, proxy.RequestTimeout(c.RequestTimeout.Std()))
+		case c.Headers != nil:
+			mws = append(mws, headers.Middleware(headerRules([]config.HeaderRuleConfig{*c.Headers})))
+		case c.SecurityHeaders != nil:
+			mws = append(mws, securityHeaders(c.SecurityHeaders).Wrap)
+		case c.CORS != nil:
+			mws = append(mws, cors.New(cors.Options{
+				Origins:        c.CORS.AllowedOrigins,
+				Methods:        c.CORS.AllowedMethods,
+				Headers:        c.CORS.AllowedHeaders,
+				ExposedHeaders: c.CORS.ExposedHeaders,
+				MaxAge:         c.CORS.MaxAge.Std(),
+				Credentials:    c.CORS.AllowCredentials,
+				Strict:         c.CORS.Strict,
+				Metrics:        m,
+			}).Wrap)
+		case c.IPFilter != nil:
+			f, err := newIPFilter(name, c.IPFilter, trusted, m)
+			if err != nil {
+				return nil, err
+			}
+			mws = append(mws, f.Wrap)
+			parts.filters = append(parts.filters, f)
+		case c.Auth != nil:
+			g, err := auth.New(auth.Options{
+				Name:         name,
+				Realm:        c.Auth.Realm,
+				HtpasswdFile: c.Auth.HtpasswdFile,
+				APIKeyHeader: c.Auth.APIKeyHeader,
+				APIKeys:      c.Auth.APIKeys,
+				Metrics:      m,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("%s.auth: %w", name, err)
+			}
+			mws = append(mws, g.Wrap)
+			parts.gates = append(parts.gates, g)
+		case c.JWT != nil:
+			v, err := jwt.New(jwt.Options{
+				Name:          name,
+				Realm:         c.JWT.Realm,
+				JWKSURL:       c.JWT.JWKSURL,
+				JWKSRefresh:   c.JWT.JWKSRefresh.Std(),
+				PublicKeyFile: c.JWT.PublicKeyFile,
+				Issuer:        c.JWT.Issuer,
+				Audience:      c.JWT.Audience,
+				ClockSkew:     c.JWT.ClockSkew.Std(),
+				ForwardClaims: c.JWT.ForwardClaims,
+				SkipPreflight: c.JWT.SkipPreflight,
+				Metrics:       m,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("%s.jwt: %w", name, err)
+			}
+			mws = append(mws, v.Wrap)
+		}
+	}
+	return mws, nil
+}
+
+// newIPFilter creates the IP filter named name
+func newIPFilter(name string, fc *config.IPFilterConfig, trusted backend.TrustedProxies, m *metrics.Metrics) (*ipfilter.Filter, error) {
+	lists, err := ipfilter.Parse(fc.Allow, fc.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return ipfilter.New(name, lists, ipfilter.Options{Trusted: trusted, Metrics: m}), nil
+}
+
+// hedgePolicy converts the hedging configuration for the proxy handler
+func hedgePolicy(hc *config.HedgingConfig) proxy.HedgePolicy {
+	percentiles := map[string]float64{"p50": 0.5, "p90": 0.9, "p95": 0.95, "p99": 0.99}
+	return proxy.HedgePolicy{
+		Delay:          hc.Delay.Std(),
+		Percentile:     percentiles[hc.DelayPercentile],
+		MaxOutstanding: hc.MaxOutstanding,
+		MaxRatio:       hc.MaxPercent / 100,
+	}
+}
+
+// newMirror creates the shadow backends of the mirror rules, each with opts,
+// and starts the mirror
+func newMirror(mc *config.MirrorConfig, opts []backend.Option, m *metrics.Metrics) (*mirror.Mirror, error) {
+	var rules []mirror.Rule
+	for _, rc := range mc.Rules {
+		rule := mirror.Rule{Percent: rc.Percent, Methods: rc.Methods, PathPrefix: rc.PathPrefix}
+		for _, target := range rc.Targets {
+			b, err := backend.NewBackend(target, opts...)
+			if err != nil {
+				return nil, fmt.Errorf("mirror target %s: %w", target, err)
+			}
+			rule.Targets = append(rule.Targets, b)
+		}
+		rules = append(rules, rule)
+	}
+	return mirror.New(rules, mirror.Options{
+		Workers:   mc.Workers,
+		QueueSize: mc.QueueSize,
+		Timeout:   mc.Timeout.Std(),
+		Metrics:   m,
+	}), nil
+}
+
+// deadlines converts the listener timeouts for the proxy handler
+func deadlines(sc config.ServerConfig) proxy.Deadlines {
+	d := proxy.Deadlines{Write: sc.WriteTimeout.Std()}
+	for _, route := range sc.Routes {
+		d.Routes = append(d.Routes, proxy.DeadlineRoute{
+			PathPrefix: route.PathPrefix,
+			Read:       route.ReadTimeout.Std(),
+			Write:      route.WriteTimeout.Std(),
+		})
+	}
+	return d
+}
+
+// flushRoutes collects the routes with their own flush interval
+func flushRoutes(sc config.ServerConfig) []proxy.FlushRoute {
+	var routes []proxy.FlushRoute
+	for _, route := range sc.Routes {
+		if route.FlushInterval != 0 {
+			routes = append(routes, proxy.FlushRoute{PathPrefix: route.PathPrefix, Interval: route.FlushInterval.Std()})
+		}
+	}
+	return routes
+}
+
+// serverErrorPolicy converts the passive health check configuration
+func serverErrorPolicy(pc config.PassiveConfig) backend.ServerErrorPolicy {
+	p := backend.ServerErrorPolicy{
+		Consecutive: pc.Consecutive5xx,
+		ErrorRate:   pc.ErrorRate,
+		Window:      pc.Window.Std(),
+		MinRequests: pc.MinRequests,
+	}
+	for _, ig := range pc.Ignore {
+		p.Ignore = append(p.Ignore, backend.ServerErrorIgnore{Statuses: ig.Status, PathPrefix: ig.PathPrefix})
+	}
+	return p
+}
+
+// headerRules converts the header rule configuration
+func headerRules(hc []config.HeaderRuleConfig) *headers.Rules {
+	ops := func(oc *config.HeaderOpsConfig) *headers.Ops {
+		if oc == nil {
+			return nil
+		}
+		return &headers.Ops{Set: oc.Set, Add: oc.Add, Remove: oc.Remove}
+	}
+	var rules []headers.Rule
+	for _, h := range hc {
+		rules = append(rules, headers.Rule{PathPrefix: h.PathPrefix, Request: ops(h.Request), Response: ops(h.Response)})
+	}
+	return headers.New(rules)
+}
+
+// securityHeaders converts a security_headers block
+func securityHeaders(sc *config.SecurityHeadersConfig) *headers.Security {
+	o := headers.SecurityOptions{
+		NoSniff:               sc.ContentTypeOptions,
+		FrameOptions:          sc.FrameOptions,
+		ContentSecurityPolicy: sc.ContentSecurityPolicy,
+		Always:                sc.Always,
+	}
+	if h := sc.HSTS; h != nil {
+		o.HSTSMaxAge = h.MaxAge.Std()
+		o.HSTSIncludeSubdomains = h.IncludeSubdomains
+		o.HSTSPreload = h.Preload
+	}
+	return headers.NewSecurity(o)
+}
+
+// loadErrorPages reads the configured error page templates; it returns nil
+// when none are configured
+func loadErrorPages(ec *config.ErrorPagesConfig) (*errorpage.Pages, error) {
+	if ec == nil {
+		return nil, nil
+	}
+	pages := errorpage.New()
+	pages.SetRetryAfter(ec.RetryAfter.Std())
+	for status, pc := range ec.Pages {
+		if err := loadErrorPage(pages, status, pc); err != nil {
+			return nil, err
+		}
+	}
+	return pages, nil
+}
+
+// loadErrorPage reads the templates of one error page into pages
+func loadErrorPage(pages *errorpage.Pages, status int, pc config.ErrorPageConfig) error {
+	var html []byte
+	if pc.HTMLFile != "" {
+		var err error
+		if html, err = os.ReadFile(pc.HTMLFile); err != nil {
+			return err
+		}
+	}
+	jsonBody := pc.JSON
+	if pc.JSONFile != "" {
+		data, err := os.ReadFile(pc.JSONFile)
+		if err != nil {
+			return err
+		}
+		jsonBody = string(data)
+	}
+	if err := pages.Set(status, string(html), jsonBody); err != nil {
+		return fmt.Errorf("page for %d: %w", status, err)
+	}
+	return nil
+}
+
+// newMaintenance creates the maintenance mode of the pools. Its page and
+// Retry-After default to those error_pages gives 503s.
+func newMaintenance(cfg *config.Config, trusted backend.TrustedProxies, errorPages *errorpage.Pages, m *metrics.Metrics) (*maintenance.Mode, error) {
+	mc := cfg.Maintenance
+	allow, err := ratelimit.ParseExempt(mc.Allow)
+	if err != nil {
+		return nil, err
+	}
+	pages := errorPages
+	if mc.Page != nil || mc.RetryAfter > 0 {
+		pages = errorpage.New()
+		page, retryAfter := mc.Page, mc.RetryAfter
+		if ec := cfg.ErrorPages; ec != nil {
+			if page == nil {
+				if pc, ok := ec.Pages[http.StatusServiceUnavailable]; ok {
+					page = &pc
+				}
+			}
+			if retryAfter == 0 {
+				retryAfter = ec.RetryAfter
+			}
+		}
+		pages.SetRetryAfter(retryAfter.Std())
+		if page != nil {
+			if err := loadErrorPage(pages, http.StatusServiceUnavailable, *page); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return maintenance.New(maintenance.Options{
+		Allow:   allow,
+		Trusted: trusted,
+		Pages:   pages,
+		Metrics: m,
+	}), nil
+}
@@ -0,0 +1,38 @@
+package nexus
+
+import (
+	"fmt"
+
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/ratelimit"
+)
+
+// Check returns the problems of cfg that only show once the parts it
+// configures are built, and routing rules that can never match, without
+// building a load balancer
+func Check(cfg Config) []string {
+	var problems []string
+	trusted, err := backend.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("trusted_proxies: %v", err))
+	}
+	if _, err := loadErrorPages(cfg.ErrorPages); err != nil {
+		problems = append(problems, fmt.Sprintf("error_pages: %v", err))
+	}
+	if rc := cfg.RateLimit; rc != nil {
+		if _, err := ratelimit.ParseExempt(rc.Exempt); err != nil {
+			problems = append(problems, fmt.Sprintf("rate_limit: %v", err))
+		}
+	}
+	if rc := cfg.Routing; rc != nil {
+		router, _, err := newRouter(rc, trusted, nil)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("routing: %v", err))
+		} else {
+			for _, s := range router.Shadowed() {
+				problems = append(problems, fmt.Sprintf("routing: rule %q is unreachable: rule %q before it matches every request it would", s.Rule, s.By))
+			}
+		}
+	}
+	return problems
+}
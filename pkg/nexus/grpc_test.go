@@ -0,0 +1,186 @@
+package nexus
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// grpcFrame length-prefixes a gRPC message
+func grpcFrame(msg string) []byte {
+	frame := make([]byte, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	copy(frame[5:], msg)
+	return frame
+}
+
+// readGRPCFrame reads one length-prefixed gRPC message
+func readGRPCFrame(r io.Reader) (string, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return "", err
+	}
+	msg := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+	_, err := io.ReadFull(r, msg)
+	return string(msg), err
+}
+
+// grpcEcho is a tiny gRPC service over h2c. Echo answers with the message
+// it was sent, Stream sends three messages, each only once the client has
+// asked for it on next, and Fail ends with the grpc-status of its message.
+type grpcEcho struct {
+	next chan struct{}
+}
+
+func (g *grpcEcho) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || r.Header.Get("Content-Type") != "application/grpc" {
+		http.Error(w, "gRPC over HTTP/2 only", http.StatusUnsupportedMediaType)
+		return
+	}
+	msg, err := readGRPCFrame(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status")
+	status := "0"
+	switch r.URL.Path {
+	case "/echo.Echo/Echo":
+		w.Write(grpcFrame(msg))
+	case "/echo.Echo/Stream":
+		for i := range 3 {
+			<-g.next
+			w.Write(grpcFrame(fmt.Sprintf("%s %d", msg, i)))
+			w.(http.Flusher).Flush()
+		}
+	case "/echo.Echo/Fail":
+		w.WriteHeader(http.StatusOK)
+		status = msg
+	}
+	w.Header().Set("Grpc-Status", status)
+}
+
+// newGRPCTest starts the echo service and a load balancer in front of it
+// speaking h2c both ways, and returns the service, the load balancer, its
+// address and an HTTP/2 client
+func newGRPCTest(t *testing.T) (*grpcEcho, *LoadBalancer, string, *http.Client) {
+	t.Helper()
+	echo := &grpcEcho{next: make(chan struct{})}
+	up := httptest.NewServer(h2c.NewHandler(echo, &http2.Server{}))
+	t.Cleanup(up.Close)
+
+	lb := loadTestLB(t, fmt.Sprintf(`"backends": [{"url": %q, "protocol": "h2c"}]`, up.URL))
+	front := httptest.NewServer(h2c.NewHandler(lb, &http2.Server{}))
+	t.Cleanup(front.Close)
+
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+	return echo, lb, front.URL, client
+}
+
+// call starts a gRPC call of method with one request message
+func call(t *testing.T, client *http.Client, base, method, msg string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, base+"/echo.Echo/"+method, bytes.NewReader(grpcFrame(msg)))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		t.Fatalf("%s: HTTP status %d", method, resp.StatusCode)
+	}
+	return resp
+}
+
+func TestGRPCUnary(t *testing.T) {
+	_, _, base, client := newGRPCTest(t)
+	resp := call(t, client, base, "Echo", "hello")
+	defer resp.Body.Close()
+
+	msg, err := readGRPCFrame(resp.Body)
+	if err != nil || msg != "hello" {
+		t.Fatalf("got %q, %v", msg, err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("grpc-status %q, want 0", got)
+	}
+}
+
+func TestGRPCServerStream(t *testing.T) {
+	echo, _, base, client := newGRPCTest(t)
+
+	// The service sends each message only once the previous one was
+	// received, so the call would hang if the proxy held messages back
+	go func() { echo.next <- struct{}{} }()
+	resp := call(t, client, base, "Stream", "tick")
+	defer resp.Body.Close()
+	for i := range 3 {
+		got := make(chan string, 1)
+		go func() {
+			msg, _ := readGRPCFrame(resp.Body)
+			got <- msg
+		}()
+		select {
+		case msg := <-got:
+			if want := fmt.Sprintf("tick %d", i); msg != want {
+				t.Fatalf("message %q, want %q", msg, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("message %d not flushed through", i)
+		}
+		if i < 2 {
+			echo.next <- struct{}{}
+		}
+	}
+	io.Copy(io.Discard, resp.Body)
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("grpc-status %q, want 0", got)
+	}
+}
+
+func TestGRPCStatusPassiveHealth(t *testing.T) {
+	_, lb, base, client := newGRPCTest(t)
+	fail := func(code string, n int) {
+		for range n {
+			resp := call(t, client, base, "Fail", code)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if got := resp.Trailer.Get("Grpc-Status"); got != code {
+				t.Fatalf("grpc-status %q, want %s", got, code)
+			}
+		}
+	}
+
+	// Over HTTP every call succeeds; only the trailers tell them apart.
+	// INVALID_ARGUMENT blames the client, so the backend stays up.
+	fail("3", 10)
+	if lb.Status().Alive != 1 {
+		t.Fatal("client errors marked the backend down")
+	}
+	// UNAVAILABLE blames the backend: five in a row take it down
+	fail("14", 5)
+	if lb.Status().Alive != 0 {
+		t.Error("backend still up after five UNAVAILABLE calls")
+	}
+}
@@ -0,0 +1,205 @@
+// Package lifecycle coordinates the stages in the life of a Nexus process:
+// start, configuration reloads, backends going up and down, and the two
+// ends of shutdown. The binary and programs embedding Nexus register hooks
+// for the stages they care about, and hand the coordinator to nexus.New
+// with nexus.WithLifecycle. Each hook gets a context bounded by the hook
+// timeout; one that fails, panics or overruns is logged and the rest run
+// regardless, so no hook can hold up shutdown.
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// Stage is a point in the life of the process that hooks run at
+type Stage string
+
+const (
+	// Start follows the opening of every listener
+	Start Stage = "start"
+	// ConfigReload follows a configuration reload that was applied
+	ConfigReload Stage = "config_reload"
+	// ShutdownBegin follows the request to shut down, before draining
+	ShutdownBegin Stage = "shutdown_begin"
+	// ShutdownComplete follows the end of draining, just before exit
+	ShutdownComplete Stage = "shutdown_complete"
+)
+
+// Hook is run at a stage
+type Hook func(ctx context.Context) error
+
+// BackendChange describes a backend going up or down
+type BackendChange struct {
+	URL   string
+	Alive bool
+	// Cause tells what changed the state, such as "health_check" or
+	// "passive: refused", as the admin API shows it
+	Cause string
+}
+
+// BackendHook is run when a backend goes up or down
+type BackendHook func(ctx context.Context, change BackendChange) error
+
+type hook struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+type backendHook struct {
+	name string
+	run  BackendHook
+}
+
+// Coordinator runs the hooks of each stage and tracks the request to shut
+// down, whether it comes from a signal, an upgrade or an embedding program
+type Coordinator struct {
+	timeout time.Duration
+	logger  *slog.Logger
+
+	mu    sync.Mutex
+	hooks map[Stage][]hook
+	up    []backendHook
+	down  []backendHook
+
+	stopOnce sync.Once
+	stopping chan struct{}
+	reason   string
+}
+
+// New creates a coordinator giving each hook up to timeout
+func New(timeout time.Duration, logger *slog.Logger) *Coordinator {
+	return &Coordinator{
+		timeout:  timeout,
+		logger:   logger,
+		hooks:    make(map[Stage][]hook),
+		stopping: make(chan struct{}),
+	}
+}
+
+// OnStart registers a hook run once Nexus accepts connections
+func (c *Coordinator) OnStart(name string, h Hook) { c.add(Start, name, h) }
+
+// OnConfigReload registers a hook run after each applied reload
+func (c *Coordinator) OnConfigReload(name string, h Hook) { c.add(ConfigReload, name, h) }
+
+// OnShutdownBegin registers a hook run when shutdown starts, before the
+// listeners are drained
+func (c *Coordinator) OnShutdownBegin(name string, h Hook) { c.add(ShutdownBegin, name, h) }
+
+// OnShutdownComplete registers a hook run once everything has drained
+func (c *Coordinator) OnShutdownComplete(name string, h Hook) { c.add(ShutdownComplete, name, h) }
+
+// OnBackendUp registers a hook run when a backend comes up
+func (c *Coordinator) OnBackendUp(name string, h BackendHook) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.up = append(c.up, backendHook{name, h})
+}
+
+// OnBackendDown registers a hook run when a backend goes down
+func (c *Coordinator) OnBackendDown(name string, h BackendHook) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.down = append(c.down, backendHook{name, h})
+}
+
+func (c *Coordinator) add(stage Stage, name string, h Hook) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hooks[stage] = append(c.hooks[stage], hook{name, h})
+}
+
+// Run runs the hooks of stage one after another, in the order registered
+func (c *Coordinator) Run(ctx context.Context, stage Stage) {
+	c.mu.Lock()
+	hooks := c.hooks[stage]
+	c.mu.Unlock()
+	for _, h := range hooks {
+		c.run(ctx, string(stage), h)
+	}
+}
+
+// run runs one hook with its timeout. A hook that ignores its context is
+// left running once the timeout passes.
+func (c *Coordinator) run(ctx context.Context, stage string, h hook) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				result <- fmt.Errorf("panic: %v", v)
+			}
+		}()
+		result <- h.run(ctx)
+	}()
+	var err error
+	select {
+	case err = <-result:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		c.logger.Error("lifecycle hook failed", "stage", stage, "hook", h.name, "error", err)
+	}
+}
+
+// BackendChanged runs the backend up or down hooks for change, one after
+// another in the order registered. The load balancer calls it for each
+// state change, in order.
+func (c *Coordinator) BackendChanged(ctx context.Context, change BackendChange) {
+	c.mu.Lock()
+	hooks, stage := c.down, "backend_down"
+	if change.Alive {
+		hooks, stage = c.up, "backend_up"
+	}
+	c.mu.Unlock()
+	for _, h := range hooks {
+		c.run(ctx, stage, hook{h.name, func(ctx context.Context) error {
+			return h.run(ctx, change)
+		}})
+	}
+}
+
+// Stop asks the process to shut down, for reason; only the first request
+// counts
+func (c *Coordinator) Stop(reason string) {
+	c.stopOnce.Do(func() {
+		c.reason = reason
+		close(c.stopping)
+	})
+}
+
+// Stopping returns a channel closed once shutdown has been asked for
+func (c *Coordinator) Stopping() <-chan struct{} {
+	return c.stopping
+}
+
+// Reason returns why shutdown was asked for, once Stopping is closed
+func (c *Coordinator) Reason() string {
+	<-c.stopping
+	return c.reason
+}
+
+// HandleSignals asks to shut down on the first of sigs. One arriving while
+// shutting down already, for whatever reason, is passed to force instead.
+func (c *Coordinator) HandleSignals(force func(os.Signal), sigs ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	go func() {
+		for sig := range ch {
+			select {
+			case <-c.stopping:
+				force(sig)
+			default:
+				c.Stop(sig.String())
+			}
+		}
+	}()
+}
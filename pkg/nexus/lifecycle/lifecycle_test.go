@@ -0,0 +1,175 @@
+package lifecycle
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a log destination safe for hooks logging concurrently
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func newTestCoordinator(timeout time.Duration) (*Coordinator, *syncBuffer) {
+	logs := &syncBuffer{}
+	return New(timeout, slog.New(slog.NewTextHandler(logs, nil))), logs
+}
+
+func TestHooksRunInOrderWithDeadline(t *testing.T) {
+	c, _ := newTestCoordinator(time.Second)
+	var order []string
+	for _, name := range []string{"first", "second", "third"} {
+		c.OnShutdownBegin(name, func(ctx context.Context) error {
+			if _, ok := ctx.Deadline(); !ok {
+				t.Errorf("hook %s has no deadline", name)
+			}
+			order = append(order, name)
+			return nil
+		})
+	}
+	c.OnStart("other stage", func(ctx context.Context) error {
+		t.Error("hook of another stage ran")
+		return nil
+	})
+	c.Run(context.Background(), ShutdownBegin)
+	if strings.Join(order, ",") != "first,second,third" {
+		t.Errorf("hooks ran as %v", order)
+	}
+}
+
+func TestHookContextsAreSeparate(t *testing.T) {
+	c, _ := newTestCoordinator(time.Second)
+	var first context.Context
+	c.OnConfigReload("first", func(ctx context.Context) error {
+		first = ctx
+		return nil
+	})
+	c.OnConfigReload("second", func(ctx context.Context) error {
+		// The first hook's context ended with it; this one's has not
+		if first.Err() == nil || ctx.Err() != nil {
+			t.Errorf("first context err %v, second %v", first.Err(), ctx.Err())
+		}
+		return nil
+	})
+	c.Run(context.Background(), ConfigReload)
+}
+
+func TestFailuresLoggedAndSkipped(t *testing.T) {
+	c, logs := newTestCoordinator(50 * time.Millisecond)
+	release := make(chan struct{})
+	defer close(release)
+
+	c.OnShutdownComplete("fails", func(ctx context.Context) error { return errors.New("flush failed") })
+	c.OnShutdownComplete("panics", func(ctx context.Context) error { panic("boom") })
+	// Overruns its timeout and ignores its context
+	c.OnShutdownComplete("hangs", func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	ran := false
+	c.OnShutdownComplete("last", func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+
+	start := time.Now()
+	c.Run(context.Background(), ShutdownComplete)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("hooks held shutdown up for %s", elapsed)
+	}
+	if !ran {
+		t.Error("a failing hook kept a later one from running")
+	}
+	out := logs.String()
+	for _, want := range []string{"hook=fails error=\"flush failed\"", "hook=panics error=\"panic: boom\"", "hook=hangs error=\"context deadline exceeded\""} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "hook=last") {
+		t.Error("a successful hook was logged as failed")
+	}
+}
+
+func TestBackendHooks(t *testing.T) {
+	c, logs := newTestCoordinator(time.Second)
+	var got []string
+	c.OnBackendUp("up", func(ctx context.Context, change BackendChange) error {
+		got = append(got, "up "+change.URL+" "+change.Cause)
+		return nil
+	})
+	c.OnBackendDown("down", func(ctx context.Context, change BackendChange) error {
+		got = append(got, "down "+change.URL+" "+change.Cause)
+		return errors.New("pager unreachable")
+	})
+	c.OnBackendDown("after", func(ctx context.Context, change BackendChange) error {
+		got = append(got, "after "+change.URL)
+		return nil
+	})
+
+	c.BackendChanged(context.Background(), BackendChange{URL: "http://a", Alive: false, Cause: "passive: refused"})
+	c.BackendChanged(context.Background(), BackendChange{URL: "http://a", Alive: true, Cause: "health_check"})
+	want := []string{"down http://a passive: refused", "after http://a", "up http://a health_check"}
+	if !slices.Equal(got, want) {
+		t.Errorf("hooks ran as %q, want %q", got, want)
+	}
+	if out := logs.String(); !strings.Contains(out, "stage=backend_down hook=down") {
+		t.Errorf("failed backend hook not logged:\n%s", out)
+	}
+}
+
+func TestStop(t *testing.T) {
+	c, _ := newTestCoordinator(time.Second)
+	c.Stop("upgrade")
+	c.Stop("terminated")
+	select {
+	case <-c.Stopping():
+	default:
+		t.Fatal("Stopping not closed")
+	}
+	if c.Reason() != "upgrade" {
+		t.Errorf("reason %q, want the first one", c.Reason())
+	}
+}
+
+func TestHandleSignals(t *testing.T) {
+	c, _ := newTestCoordinator(time.Second)
+	forced := make(chan struct{}, 1)
+	c.HandleSignals(func(os.Signal) { forced <- struct{}{} }, syscall.SIGUSR2)
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR2)
+	select {
+	case <-c.Stopping():
+	case <-time.After(time.Second):
+		t.Fatal("signal did not stop the process")
+	}
+	// A second one while shutting down forces it
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR2)
+	select {
+	case <-forced:
+	case <-time.After(time.Second):
+		t.Fatal("second signal not passed to force")
+	}
+}
@@ -0,0 +1,81 @@
+package nexus_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nexus-lb/nexus/config"
+	"github.com/nexus-lb/nexus/pkg/nexus"
+	"github.com/nexus-lb/nexus/pkg/nexus/lifecycle"
+)
+
+// This package sits outside the module's internal tree, as a program
+// embedding Nexus does, so everything it uses is public
+
+func ExampleWithLifecycle() {
+	cfg, err := config.Load("nexus.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+	life := lifecycle.New(5*time.Second, slog.Default())
+	life.OnBackendDown("page", func(ctx context.Context, change lifecycle.BackendChange) error {
+		log.Printf("backend %s down: %s", change.URL, change.Cause)
+		return nil
+	})
+	lb, err := nexus.New(*cfg, nexus.WithLifecycle(life))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer lb.Shutdown(context.Background())
+	http.ListenAndServe(":8080", lb)
+}
+
+func TestLifecycleBackendHooks(t *testing.T) {
+	// A backend refusing connections is marked down by the first health
+	// check or request to it
+	gone := httptest.NewServer(http.NotFoundHandler())
+	gone.Close()
+	path := filepath.Join(t.TempDir(), "config.json")
+	body := fmt.Sprintf(`{"listen": ":0", "backends": [{"url": %q}]}`, gone.URL)
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	life := lifecycle.New(time.Second, slog.Default())
+	down := make(chan lifecycle.BackendChange, 1)
+	life.OnBackendDown("record", func(ctx context.Context, change lifecycle.BackendChange) error {
+		down <- change
+		return nil
+	})
+	lb, err := nexus.New(*cfg, nexus.WithLifecycle(life))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		lb.Shutdown(ctx)
+	}()
+
+	lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	select {
+	case change := <-down:
+		if change.URL != gone.URL || change.Alive || change.Cause == "" {
+			t.Errorf("hook saw %+v", change)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("backend down hook did not run")
+	}
+}
@@ -0,0 +1,130 @@
+package nexus
+
+import (
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/metrics"
+	"github.com/nexus-lb/nexus/internal/pool"
+)
+
+// registerPoolMetrics exports pool state and the protocol counters, which
+// are read at scrape time rather than recorded twice
+func registerPoolMetrics(m *metrics.Metrics, serverPool *pool.ServerPool, protocols *metrics.ProtocolCounters, conns *metrics.ConnCounters) {
+	m.Registry.NewGaugeFunc("nexus_backends_alive", "Backends currently marked UP.", nil, func() []metrics.Sample {
+		alive, _ := serverPool.GetPoolStatus()
+		return []metrics.Sample{{Value: float64(alive)}}
+	})
+	m.Registry.NewGaugeFunc("nexus_backends_total", "Backends in the pool.", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(serverPool.GetPoolSize())}}
+	})
+	m.Registry.NewGaugeFunc("nexus_backend_requests_in_flight", "Requests in flight to each backend.",
+		[]string{"backend"}, func() []metrics.Sample {
+			backends := serverPool.GetBackends()
+			samples := make([]metrics.Sample, 0, len(backends))
+			for _, b := range backends {
+				samples = append(samples, metrics.Sample{
+					Labels: []string{b.URL.String()},
+					Value:  float64(b.ActiveRequests()),
+				})
+			}
+			return samples
+		})
+	m.Registry.NewGaugeFunc("nexus_backend_upgraded_connections", "WebSocket and other upgraded connections open to each backend.",
+		[]string{"backend"}, func() []metrics.Sample {
+			backends := serverPool.GetBackends()
+			samples := make([]metrics.Sample, 0, len(backends))
+			for _, b := range backends {
+				samples = append(samples, metrics.Sample{
+					Labels: []string{b.URL.String()},
+					Value:  float64(b.Upgrades()),
+				})
+			}
+			return samples
+		})
+	m.Registry.NewGaugeFunc("nexus_backend_up", "Whether each backend is marked UP (1) or DOWN (0).",
+		[]string{"backend"}, func() []metrics.Sample {
+			backends := serverPool.GetBackends()
+			samples := make([]metrics.Sample, 0, len(backends))
+			for _, b := range backends {
+				up := 0.0
+				if b.IsAlive() {
+					up = 1
+				}
+				samples = append(samples, metrics.Sample{Labels: []string{b.URL.String()}, Value: up})
+			}
+			return samples
+		})
+	m.Registry.NewCounterFunc("nexus_backend_errors_total",
+		"Failed requests to each backend, by class (refused, timeout, reset, dns, tls, canceled, other, 5xx).",
+		[]string{"backend", "class"}, func() []metrics.Sample {
+			backends := serverPool.GetBackends()
+			samples := make([]metrics.Sample, 0, len(backend.ErrorClasses)*len(backends))
+			for _, b := range backends {
+				counts := b.Errors()
+				for _, class := range backend.ErrorClasses {
+					samples = append(samples, metrics.Sample{
+						Labels: []string{b.URL.String(), class},
+						Value:  float64(counts[class]),
+					})
+				}
+			}
+			return samples
+		})
+	m.Registry.NewGaugeFunc("nexus_backend_latency_seconds",
+		"Estimated latency percentiles of each backend, excluding retries on other backends.",
+		[]string{"backend", "quantile"}, func() []metrics.Sample {
+			backends := serverPool.GetBackends()
+			samples := make([]metrics.Sample, 0, 3*len(backends))
+			for _, b := range backends {
+				l := b.Latency()
+				if l.Count == 0 {
+					continue
+				}
+				url := b.URL.String()
+				samples = append(samples,
+					metrics.Sample{Labels: []string{url, "0.5"}, Value: l.P50.Seconds()},
+					metrics.Sample{Labels: []string{url, "0.95"}, Value: l.P95.Seconds()},
+					metrics.Sample{Labels: []string{url, "0.99"}, Value: l.P99.Seconds()})
+			}
+			return samples
+		})
+	m.Registry.NewGaugeFunc("nexus_client_connections", "Client connections held open, by state.",
+		[]string{"state"}, func() []metrics.Sample {
+			c := conns.Snapshot()
+			return []metrics.Sample{
+				{Labels: []string{"new"}, Value: float64(c.New)},
+				{Labels: []string{"active"}, Value: float64(c.Active)},
+				{Labels: []string{"idle"}, Value: float64(c.Idle)},
+			}
+		})
+	m.Registry.NewGaugeFunc("nexus_backend_connections", "Connections open to each backend, and of those the estimated idle ones.",
+		[]string{"backend", "state"}, func() []metrics.Sample {
+			backends := serverPool.GetBackends()
+			samples := make([]metrics.Sample, 0, 2*len(backends))
+			for _, b := range backends {
+				c := b.Conns()
+				url := b.URL.String()
+				samples = append(samples,
+					metrics.Sample{Labels: []string{url, "open"}, Value: float64(c.Open)},
+					metrics.Sample{Labels: []string{url, "idle"}, Value: float64(c.Idle)})
+			}
+			return samples
+		})
+	m.Registry.NewCounterFunc("nexus_backend_connections_dialed_total", "Connections dialed to each backend.",
+		[]string{"backend"}, func() []metrics.Sample {
+			backends := serverPool.GetBackends()
+			samples := make([]metrics.Sample, 0, len(backends))
+			for _, b := range backends {
+				samples = append(samples, metrics.Sample{Labels: []string{b.URL.String()}, Value: float64(b.Conns().Dialed)})
+			}
+			return samples
+		})
+	m.Registry.NewCounterFunc("nexus_requests_by_protocol_total", "Requests received, by HTTP protocol version.",
+		[]string{"protocol"}, func() []metrics.Sample {
+			counts := protocols.Snapshot()
+			samples := make([]metrics.Sample, 0, len(counts))
+			for _, proto := range []string{"HTTP/1.0", "HTTP/1.1", "HTTP/2.0", "HTTP/3.0", "other"} {
+				samples = append(samples, metrics.Sample{Labels: []string{proto}, Value: float64(counts[proto])})
+			}
+			return samples
+		})
+}
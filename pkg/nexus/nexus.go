@@ -0,0 +1,424 @@
+// Package nexus runs the Nexus load balancer inside another program. New
+// builds, from the same configuration, the handler the nexus binary serves,
+// retries and passive health checks included; the program keeps its own
+// listeners and can mount the handler anywhere in its mux.
+package nexus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nexus-lb/nexus/config"
+	"github.com/nexus-lb/nexus/internal/accesslog"
+	"github.com/nexus-lb/nexus/internal/admin"
+	"github.com/nexus-lb/nexus/internal/audit"
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/discovery"
+	"github.com/nexus-lb/nexus/internal/events"
+	"github.com/nexus-lb/nexus/internal/freeze"
+	"github.com/nexus-lb/nexus/internal/health"
+	"github.com/nexus-lb/nexus/internal/ipfilter"
+	"github.com/nexus-lb/nexus/internal/journal"
+	"github.com/nexus-lb/nexus/internal/maintenance"
+	"github.com/nexus-lb/nexus/internal/metrics"
+	"github.com/nexus-lb/nexus/internal/mirror"
+	"github.com/nexus-lb/nexus/internal/pool"
+	"github.com/nexus-lb/nexus/internal/proxy"
+	"github.com/nexus-lb/nexus/internal/split"
+	"github.com/nexus-lb/nexus/internal/statsd"
+	"github.com/nexus-lb/nexus/internal/tcpproxy"
+	"github.com/nexus-lb/nexus/internal/tracing"
+	"github.com/nexus-lb/nexus/internal/udpproxy"
+	"github.com/nexus-lb/nexus/pkg/nexus/lifecycle"
+)
+
+// Config is the configuration of a load balancer, as config.Load reads it
+type Config = config.Config
+
+// LoadBalancer is a load balancer built from a configuration. It serves the
+// proxy and its probes as an http.Handler; the admin API and the TCP and
+// UDP listeners run once it is started.
+type LoadBalancer struct {
+	cfg       *config.Config
+	version   string
+	startedAt time.Time
+	life      *lifecycle.Coordinator
+	// lifeSub feeds backend state changes to life until lifeDone
+	lifeSub  *events.Subscription
+	lifeDone chan struct{}
+
+	pool        *pool.ServerPool
+	bus         *events.Bus
+	journal     *journal.Journal
+	freeze      *freeze.Controller
+	history     *audit.Log
+	backendOpts []backend.Option
+	sources     []discovery.Source
+	reconcilers []*discovery.Reconciler
+	metrics     *metrics.Metrics
+	statsd      *statsd.Reporter
+	health      *health.HealthChecker
+	handler     *proxy.Handler
+	splitter    *split.Splitter
+	maint       *maintenance.Mode
+	mirror      *mirror.Mirror
+	sampler     *accesslog.Sampler
+	accessLog   *accesslog.Logger
+	tracer      *tracing.Tracer
+	conns       *metrics.ConnCounters
+	streams     *proxy.Streams
+	limiter     *proxy.ConcurrencyLimiter
+	ipFilters   []*ipfilter.Filter
+	probes      *proxy.Probes
+	reloader    *reloader
+	root        http.Handler
+
+	admin      *admin.Server
+	adminErr   <-chan error
+	tcp        []*tcpproxy.Proxy
+	udp        []*udpproxy.Proxy
+	beginOnce  sync.Once
+	tcpStopped chan struct{}
+	cutoff     *time.Timer
+}
+
+// Option configures a LoadBalancer
+type Option func(*LoadBalancer)
+
+// WithVersion sets the version the admin API reports
+func WithVersion(v string) Option {
+	return func(lb *LoadBalancer) { lb.version = v }
+}
+
+// WithStartTime sets when the process started, for the uptime the admin
+// API reports; it defaults to when New is called
+func WithStartTime(t time.Time) Option {
+	return func(lb *LoadBalancer) { lb.startedAt = t }
+}
+
+// WithLifecycle runs the backend hooks of c as backends go up and down
+func WithLifecycle(c *lifecycle.Coordinator) Option {
+	return func(lb *LoadBalancer) { lb.life = c }
+}
+
+// New builds a load balancer from cfg and starts its health checks and
+// service discovery. Nothing listens until Start is called, and the
+// handler can serve at once.
+func New(cfg Config, opts ...Option) (*LoadBalancer, error) {
+	lb := &LoadBalancer{cfg: &cfg, version: "dev", startedAt: time.Now()}
+	for _, opt := range opts {
+		opt(lb)
+	}
+	if err := lb.build(); err != nil {
+		lb.release(context.Background())
+		return nil, err
+	}
+	lb.health.Start()
+	for _, source := range lb.sources {
+		source.Start()
+	}
+	return lb, nil
+}
+
+// ServeHTTP proxies a request to a backend, or answers it locally if it is
+// a probe or is refused
+func (lb *LoadBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	lb.root.ServeHTTP(w, r)
+}
+
+// ConnState counts the client connections of a server serving the load
+// balancer, for metrics and shutdown; set it as the server's ConnState
+func (lb *LoadBalancer) ConnState(conn net.Conn, state http.ConnState) {
+	lb.conns.ConnState(conn, state)
+}
+
+// Listeners opens the sockets of the admin API and the TCP and UDP
+// listeners
+type Listeners interface {
+	Listen(addr string) (net.Listener, error)
+	ListenUDP(addr string) (*net.UDPConn, error)
+}
+
+// netListeners opens new sockets
+type netListeners struct{}
+
+func (netListeners) Listen(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+func (netListeners) ListenUDP(addr string) (*net.UDPConn, error) {
+	a, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenUDP("udp", a)
+}
+
+// Start starts the admin API, waits for the backends the startup gate
+// asks for, and starts the TCP and UDP listeners, with sockets from ls, or
+// new ones if it is nil. Whatever started before an error is stopped by
+// Shutdown.
+func (lb *LoadBalancer) Start(ls Listeners) error {
+	if ls == nil {
+		ls = netListeners{}
+	}
+	cfg := lb.cfg
+
+	// Without an address, none of the admin, metrics or debug endpoints
+	// exist
+	if cfg.Admin.Address != "" {
+		lb.admin = admin.NewServer(cfg.Admin.Address, admin.Sources{
+			Config:      cfg,
+			Pool:        lb.pool,
+			Freeze:      lb.freeze,
+			Protocols:   lb.handler.Protocols(),
+			Conns:       lb.conns,
+			Metrics:     lb.metrics,
+			Journal:     lb.journal,
+			Audit:       lb.history,
+			Events:      lb.bus,
+			Sampler:     lb.sampler,
+			Split:       lb.splitter,
+			Maintenance: lb.maint,
+			Discovery:   lb.reconcilers,
+			IPFilters:   lb.ipFilters,
+			Limiter:     lb.limiter,
+			Health:      lb.health,
+			StartedAt:   lb.startedAt,
+			Version:     lb.version,
+			NewBackend: func(url string, weight int) (*backend.Backend, error) {
+				return lb.newBackend(url, weight, backend.OriginAdmin)
+			},
+		})
+		ln, err := ls.Listen(lb.admin.Addr())
+		if err != nil {
+			lb.admin = nil
+			return fmt.Errorf("admin server: %w", err)
+		}
+		lb.adminErr = lb.admin.Start(ln)
+	} else {
+		slog.Info("admin API disabled")
+	}
+
+	// Hold the listeners back until enough backends are healthy; after an
+	// upgrade, the previous process keeps serving until then
+	if n := cfg.Startup.MinHealthy; n > 0 {
+		if err := lb.waitHealthy(n, cfg.Startup.Timeout.Std()); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if lb.tcp, err = startTCPProxies(cfg.TCP, ls, lb.metrics); err != nil {
+		return err
+	}
+	lb.udp, err = startUDPProxies(cfg.UDP, ls, lb.metrics)
+	return err
+}
+
+// AdminErr returns a channel receiving the error that stops the admin API
+// serving after Start; the proxy carries on regardless. Without an admin
+// API it is nil.
+func (lb *LoadBalancer) AdminErr() <-chan error {
+	return lb.adminErr
+}
+
+// waitHealthy blocks until n backends have passed a health check, failing
+// if they have not within timeout
+func (lb *LoadBalancer) waitHealthy(n int, timeout time.Duration) error {
+	slog.Info("waiting for backends to become healthy", "min_healthy", n, "timeout", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	healthy, err := lb.health.WaitHealthy(ctx, n)
+	if err != nil {
+		return fmt.Errorf("too few healthy backends to start: %d of %d healthy within %v, %d needed",
+			healthy, lb.pool.GetPoolSize(), timeout, n)
+	}
+	slog.Info("backends healthy", "healthy", healthy, "min_healthy", n,
+		"elapsed", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// Activity is what the load balancer has in flight
+type Activity struct {
+	Requests int
+	Streams  int
+	// The client connections counted through ConnState
+	Connections       int64
+	ActiveConnections int64
+	NewConnections    int64
+}
+
+// Activity returns what the load balancer has in flight
+func (lb *LoadBalancer) Activity() Activity {
+	conns := lb.conns.Snapshot()
+	return Activity{
+		Requests:          lb.limiter.InFlight(),
+		Streams:           lb.streams.Count(),
+		Connections:       conns.Open,
+		ActiveConnections: conns.Active,
+		NewConnections:    conns.New,
+	}
+}
+
+// StartDraining fails the readiness probe, so that upstream load balancers
+// stop sending traffic before shutdown
+func (lb *LoadBalancer) StartDraining() {
+	if lb.probes != nil {
+		lb.probes.StartDraining()
+	}
+}
+
+// ReopenAccessLog reopens the access log file, for logrotate
+func (lb *LoadBalancer) ReopenAccessLog() error {
+	return lb.accessLog.Reopen()
+}
+
+// Reload applies the settings of the configuration file at path that can
+// change at runtime on behalf of actor, reporting whether it did
+func (lb *LoadBalancer) Reload(path, actor string) bool {
+	return lb.reloader.reload(path, actor)
+}
+
+// BeginShutdown stops the health checks, service discovery and UDP
+// listeners, starts draining the TCP listeners, and has streams cut off
+// after the stream shutdown timeout, so that the servers serving the load
+// balancer can shut down. Shutdown calls it if it has not been.
+func (lb *LoadBalancer) BeginShutdown(ctx context.Context) {
+	lb.beginOnce.Do(func() {
+		lb.health.Stop()
+		for _, source := range lb.sources {
+			source.Stop()
+		}
+
+		// Datagrams have nothing to drain; UDP listeners stop at once
+		stopUDPProxies(ctx, lb.udp)
+
+		// TCP listeners drain alongside the HTTP ones, each for its own
+		// drain timeout
+		lb.tcpStopped = make(chan struct{})
+		go func() {
+			defer close(lb.tcpStopped)
+			stopTCPProxies(lb.tcp, lb.cfg.TCP)
+		}()
+
+		// WebSockets and event streams would hold the servers open for as
+		// long as their clients stay; they are cut off sooner if so
+		// configured
+		streamTimeout := lb.cfg.StreamShutdownTimeout.Std()
+		if streamTimeout <= 0 {
+			streamTimeout = lb.cfg.ShutdownTimeout.Std()
+		}
+		lb.cutoff = time.AfterFunc(streamTimeout, func() {
+			if n := lb.streams.CancelAll(); n > 0 {
+				slog.Warn("cutting off streams", "count", n)
+			}
+		})
+	})
+}
+
+// Shutdown stops the load balancer once the servers serving it have shut
+// down: WebSocket tunnels and mirrored requests get until ctx is done, the
+// TCP listeners finish draining, and the admin API, exporters and logs are
+// closed
+func (lb *LoadBalancer) Shutdown(ctx context.Context) error {
+	lb.BeginShutdown(ctx)
+	defer lb.cutoff.Stop()
+
+	// Hijacked connections are not waited for by http.Server.Shutdown;
+	// give WebSocket tunnels until ctx is done, then close them
+	for _, b := range lb.pool.GetBackends() {
+		if !b.WaitUpgrades(ctx) {
+			slog.Warn("closing upgraded connections", "backend", b.URL.String(), "count", b.CloseUpgrades())
+		}
+	}
+
+	lb.mirror.Stop(ctx)
+	<-lb.tcpStopped
+
+	var errs []error
+	if err := lb.admin.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("admin server: %w", err))
+	}
+	if err := lb.closeExporters(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	protocols := lb.handler.Protocols().Snapshot()
+	for _, proto := range slices.Sorted(maps.Keys(protocols)) {
+		if protocols[proto] > 0 {
+			slog.Info("served requests", "protocol", proto, "requests", protocols[proto])
+		}
+	}
+
+	lb.unwatchLifecycle()
+	return errors.Join(errs...)
+}
+
+// closeExporters flushes and closes the metrics and trace exporters and
+// the logs
+func (lb *LoadBalancer) closeExporters(ctx context.Context) error {
+	if lb.statsd != nil {
+		lb.statsd.Close(lb.bus)
+	}
+	var err error
+	if terr := lb.tracer.Shutdown(ctx); terr != nil {
+		err = fmt.Errorf("trace export: %w", terr)
+	}
+	lb.accessLog.Close()
+	lb.history.Close()
+	return err
+}
+
+// release undoes what build did before it failed
+func (lb *LoadBalancer) release(ctx context.Context) {
+	lb.mirror.Stop(ctx)
+	if err := lb.closeExporters(ctx); err != nil {
+		slog.Warn("failed to close exporters", "error", err)
+	}
+	lb.unwatchLifecycle()
+}
+
+// watchLifecycle runs the backend hooks of the lifecycle coordinator for
+// the state changes published on the bus, in order, until
+// unwatchLifecycle
+func (lb *LoadBalancer) watchLifecycle() {
+	if lb.life == nil {
+		return
+	}
+	lb.lifeSub = lb.bus.Subscribe(256)
+	lb.lifeDone = make(chan struct{})
+	go func() {
+		defer close(lb.lifeDone)
+		for e := range lb.lifeSub.C {
+			change, ok := e.Data.(backend.StateChange)
+			if e.Type != backend.StateEventType || !ok {
+				continue
+			}
+			lb.life.BackendChanged(context.Background(), lifecycle.BackendChange{
+				URL:   change.URL,
+				Alive: change.Alive,
+				Cause: change.Cause.String(),
+			})
+		}
+	}()
+}
+
+// unwatchLifecycle stops running backend hooks, once those under way are
+// done
+func (lb *LoadBalancer) unwatchLifecycle() {
+	if lb.lifeSub != nil {
+		lb.bus.Unsubscribe(lb.lifeSub)
+		<-lb.lifeDone
+		lb.lifeSub = nil
+	}
+}
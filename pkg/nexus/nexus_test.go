@@ -0,0 +1,238 @@
+package nexus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nexus-lb/nexus/config"
+)
+
+// newTestLB loads a configuration with the given backends plus the given
+// top-level fields and builds a load balancer from it, as the nexus binary
+// would
+func newTestLB(t *testing.T, backends []string, fields string) *LoadBalancer {
+	t.Helper()
+	list := make([]string, len(backends))
+	for i, u := range backends {
+		list[i] = fmt.Sprintf(`{"url": %q}`, u)
+	}
+	body := `"backends": [` + strings.Join(list, ", ") + `]`
+	if fields != "" {
+		body += ", " + fields
+	}
+	return loadTestLB(t, body)
+}
+
+// loadTestLB builds a load balancer from a configuration of the given
+// top-level fields and a listener
+func loadTestLB(t *testing.T, fields string) *LoadBalancer {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"listen": ":0", `+fields+"}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb, err := New(*cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		lb.Shutdown(ctx)
+	})
+	return lb
+}
+
+// recordingBackend is an upstream remembering the paths it was asked for
+type recordingBackend struct {
+	*httptest.Server
+	mu    sync.Mutex
+	paths []string
+}
+
+func newRecordingBackend(t *testing.T) *recordingBackend {
+	t.Helper()
+	rb := &recordingBackend{}
+	rb.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rb.mu.Lock()
+		rb.paths = append(rb.paths, r.URL.Path)
+		rb.mu.Unlock()
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "backend %s", r.URL.Path)
+	}))
+	t.Cleanup(rb.Close)
+	return rb
+}
+
+func (rb *recordingBackend) seen() []string {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return append([]string(nil), rb.paths...)
+}
+
+func TestDebugPathsProxied(t *testing.T) {
+	up := newRecordingBackend(t)
+	// Profiling enabled on the admin listener must not leak onto the proxy
+	lb := newTestLB(t, []string{up.URL}, `"admin": {"pprof": true}`)
+
+	paths := []string{"/debug/pprof/", "/debug/pprof/heap", "/debug/pprof/cmdline", "/debug/runtime", "/debug/vars"}
+	for _, path := range paths {
+		w := httptest.NewRecorder()
+		lb.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK || w.Body.String() != "backend "+path {
+			t.Errorf("%s: got %d %q, want the backend's answer", path, w.Code, w.Body.String())
+		}
+	}
+	if got := up.seen(); len(got) != len(paths) {
+		t.Errorf("backend saw %v, want %v", got, paths)
+	}
+}
+
+// dyingBackend drops every connection without answering
+func dyingBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestRetriesAndPassiveHealth(t *testing.T) {
+	bad := dyingBackend(t)
+	good := newRecordingBackend(t)
+	lb := newTestLB(t, []string{bad.URL, good.URL}, "")
+
+	// Within two requests the rotation sends one to the dying backend
+	// first; it is retried on the good one and the dying one marked down
+	for i := range 2 {
+		w := httptest.NewRecorder()
+		lb.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/r%d", i), nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200 from the good backend", w.Code)
+		}
+	}
+	if got := up(lb.Status()); len(got) != 1 || got[0] != good.URL {
+		t.Errorf("backends up %v, want only %s", got, good.URL)
+	}
+}
+
+func TestServerErrorsMarkDown(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	lb := newTestLB(t, []string{failing.URL}, `"health_check": {"passive": {"consecutive_5xx": 3}}`)
+
+	for i := range 3 {
+		if lb.Status().Alive != 1 {
+			t.Fatalf("backend down after %d server errors", i)
+		}
+		w := httptest.NewRecorder()
+		lb.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("status %d, want the backend's 500", w.Code)
+		}
+	}
+	if lb.Status().Alive != 0 {
+		t.Error("backend still up after three server errors in a row")
+	}
+}
+
+func TestMountedUnderMux(t *testing.T) {
+	be := newRecordingBackend(t)
+	lb := newTestLB(t, []string{be.URL}, "")
+	mux := http.NewServeMux()
+	mux.Handle("/lb/", http.StripPrefix("/lb", lb))
+	mux.HandleFunc("/own", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "own") })
+
+	for path, want := range map[string]string{"/lb/users/7": "backend /users/7", "/own": "own"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("%s: got %d %q, want %q", path, w.Code, w.Body.String(), want)
+		}
+	}
+	if got := be.seen(); len(got) != 1 || got[0] != "/users/7" {
+		t.Errorf("backend saw %v", got)
+	}
+}
+
+// up lists the URLs of the backends that are up
+func up(s Status) []string {
+	var urls []string
+	for _, b := range s.Backends {
+		if b.Alive {
+			urls = append(urls, b.URL)
+		}
+	}
+	return urls
+}
+
+// closedListeners hands out listeners that are already closed, so serving
+// on them fails at once
+type closedListeners struct{}
+
+func (closedListeners) Listen(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	ln.Close()
+	return ln, nil
+}
+
+func (closedListeners) ListenUDP(addr string) (*net.UDPConn, error) {
+	return nil, errors.New("no UDP in this test")
+}
+
+func TestAdminFailureReported(t *testing.T) {
+	be := newRecordingBackend(t)
+	lb := newTestLB(t, []string{be.URL}, `"admin": {"address": "127.0.0.1:0"}`)
+	if err := lb.Start(closedListeners{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The embedding program is told, and the proxy keeps serving
+	select {
+	case err := <-lb.AdminErr():
+		if err == nil {
+			t.Error("nil error from the failed admin API")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("admin API failure not reported")
+	}
+	w := httptest.NewRecorder()
+	lb.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/x", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("proxy answered %d after the admin API failed", w.Code)
+	}
+}
+
+func TestNoAdminErrWithoutAdmin(t *testing.T) {
+	lb := newTestLB(t, []string{newRecordingBackend(t).URL}, `"admin": {"address": ""}`)
+	if err := lb.Start(closedListeners{}); err != nil {
+		t.Fatal(err)
+	}
+	if lb.AdminErr() != nil {
+		t.Error("error channel without an admin API")
+	}
+}
@@ -0,0 +1,98 @@
+package nexus
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/nexus-lb/nexus/config"
+	"github.com/nexus-lb/nexus/internal/auth"
+	"github.com/nexus-lb/nexus/internal/backend"
+	"github.com/nexus-lb/nexus/internal/journal"
+	"github.com/nexus-lb/nexus/internal/pool"
+)
+
+// transportSettings converts a transport configuration into backend settings
+func transportSettings(tc config.TransportConfig) backend.TransportSettings {
+	return backend.TransportSettings{
+		DialTimeout:           tc.DialTimeout.Std(),
+		KeepAlive:             tc.KeepAlive.Std(),
+		MaxIdleConns:          tc.MaxIdleConns,
+		MaxIdleConnsPerHost:   tc.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       tc.MaxConnsPerHost,
+		IdleConnTimeout:       tc.IdleConnTimeout.Std(),
+		TLSHandshakeTimeout:   tc.TLSHandshakeTimeout.Std(),
+		ResponseHeaderTimeout: tc.ResponseHeaderTimeout.Std(),
+		RequestTimeout:        tc.RequestTimeout.Std(),
+		StreamIdleTimeout:     tc.StreamIdleTimeout.Std(),
+		InsecureSkipVerify:    tc.InsecureSkipVerify,
+	}
+}
+
+// backendTransport returns the transport settings of a configured backend
+func backendTransport(cfg *config.Config, bc config.BackendConfig) backend.TransportSettings {
+	s := transportSettings(cfg.TransportFor(bc))
+	s.Protocol = bc.Protocol
+	return s
+}
+
+// reloader re-reads the configuration file on demand and remembers the
+// last loaded version so each reload can be journaled as a diff
+type reloader struct {
+	pool    *pool.ServerPool
+	journal *journal.Journal
+	current *config.Config
+	// gates have their credentials files read again on every reload
+	gates []*auth.Gate
+}
+
+// reload re-reads the configuration file at path and applies the settings that can
+// change at runtime, reporting whether it did. Backend transports are
+// swapped without disturbing in-flight requests. Credentials files are read
+// again even if the configuration cannot be.
+func (rl *reloader) reload(path, actor string) bool {
+	for _, g := range rl.gates {
+		if err := g.Reload(); err != nil {
+			slog.Error("keeping current credentials", "component", "reload", "error", err)
+		}
+	}
+	if path == "" {
+		slog.Info("no configuration file in use, nothing to reload", "component", "reload")
+		return false
+	}
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		slog.Error("keeping current configuration", "component", "reload", "error", err)
+		return false
+	}
+
+	byURL := make(map[string]config.BackendConfig, len(cfg.Backends))
+	for _, bc := range cfg.Backends {
+		byURL[bc.URL] = bc
+	}
+
+	swapped := 0
+	for _, b := range rl.pool.GetBackends() {
+		bc, ok := byURL[b.URL.String()]
+		if !ok {
+			continue
+		}
+		settings := backendTransport(cfg, bc)
+		if settings != b.TransportSettings() {
+			b.SetTransport(settings)
+			swapped++
+		}
+	}
+
+	diff, err := journal.Diff(rl.current, cfg)
+	if err != nil {
+		slog.Warn("could not diff configurations", "component", "reload", "error", err)
+	}
+	rl.current = cfg
+	rl.journal.Record(journal.TypeConfigReload, actor,
+		fmt.Sprintf("reloaded %s: %d settings changed, %d backend transports updated", path, len(diff), swapped),
+		diff)
+
+	slog.Info("configuration reloaded", "component", "reload", "path", path, "changes", len(diff), "transports_updated", swapped)
+	return true
+}
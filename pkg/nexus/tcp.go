@@ -0,0 +1,127 @@
+package nexus
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/nexus-lb/nexus/config"
+	"github.com/nexus-lb/nexus/internal/metrics"
+	"github.com/nexus-lb/nexus/internal/tcpproxy"
+)
+
+// startTCPProxies starts the TCP listeners and exports their counts. On
+// error it returns those started before.
+func startTCPProxies(tcs []config.TCPProxyConfig, ls Listeners, m *metrics.Metrics) ([]*tcpproxy.Proxy, error) {
+	proxies := make([]*tcpproxy.Proxy, 0, len(tcs))
+	for _, tc := range tcs {
+		idle := tc.IdleTimeout.Std()
+		if idle < 0 {
+			idle = 0
+		}
+		o := tcpproxy.Options{
+			Name:           tc.Name,
+			Listen:         tc.Listen,
+			Backends:       tc.Backends,
+			Balance:        tc.Balance,
+			DialTimeout:    tc.DialTimeout.Std(),
+			IdleTimeout:    idle,
+			MaxConnections: tc.MaxConnections,
+			Health: tcpproxy.HealthOptions{
+				Interval: tc.HealthCheck.Interval.Std(),
+				Timeout:  tc.HealthCheck.Timeout.Std(),
+				Send:     []byte(tc.HealthCheck.Send),
+				Expect:   []byte(tc.HealthCheck.Expect),
+			},
+		}
+		if sni := tc.SNI; sni != nil {
+			o.HelloTimeout = sni.HelloTimeout.Std()
+			for _, r := range sni.Routes {
+				o.Routes = append(o.Routes, tcpproxy.Route{ServerNames: r.ServerNames, Backends: r.Backends})
+			}
+		}
+		ln, err := ls.Listen(tc.Listen)
+		if err != nil {
+			return proxies, fmt.Errorf("TCP proxy %s: %w", tc.Name, err)
+		}
+		p := tcpproxy.New(o)
+		p.Start(ln)
+		proxies = append(proxies, p)
+	}
+	if len(proxies) > 0 {
+		registerTCPMetrics(m, proxies)
+	}
+	return proxies, nil
+}
+
+// stopTCPProxies stops the TCP listeners at once and gives each its drain
+// timeout for the connections still open
+func stopTCPProxies(proxies []*tcpproxy.Proxy, tcs []config.TCPProxyConfig) {
+	var wg sync.WaitGroup
+	for i, p := range proxies {
+		wg.Go(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), tcs[i].DrainTimeout.Std())
+			defer cancel()
+			if err := p.Shutdown(ctx); err != nil {
+				slog.Warn("TCP proxy shutdown cut connections off", "listener", p.Name(), "error", err)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// registerTCPMetrics exports the connection and byte counts of the TCP
+// listeners and their backends
+func registerTCPMetrics(m *metrics.Metrics, proxies []*tcpproxy.Proxy) {
+	perBackend := func(value func(tcpproxy.TargetStats) float64) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			var samples []metrics.Sample
+			for _, p := range proxies {
+				st := p.Stats()
+				for _, t := range st.Targets {
+					samples = append(samples, metrics.Sample{Labels: []string{st.Name, t.Addr}, Value: value(t)})
+				}
+			}
+			return samples
+		}
+	}
+	labels := []string{"listener", "backend"}
+	m.Registry.NewGaugeFunc("nexus_tcp_connections", "TCP connections open to each backend.", labels,
+		perBackend(func(t tcpproxy.TargetStats) float64 { return float64(t.Active) }))
+	m.Registry.NewCounterFunc("nexus_tcp_connections_total", "TCP connections proxied to each backend.", labels,
+		perBackend(func(t tcpproxy.TargetStats) float64 { return float64(t.Conns) }))
+	m.Registry.NewCounterFunc("nexus_tcp_dial_failures_total", "Failed connection attempts to each TCP backend.", labels,
+		perBackend(func(t tcpproxy.TargetStats) float64 { return float64(t.DialFailures) }))
+	m.Registry.NewGaugeFunc("nexus_tcp_backend_up", "Whether each TCP backend is passing health checks (1) or not (0).", labels,
+		perBackend(func(t tcpproxy.TargetStats) float64 {
+			if t.Alive {
+				return 1
+			}
+			return 0
+		}))
+	m.Registry.NewCounterFunc("nexus_tcp_bytes_total", "Bytes proxied to and from each TCP backend, by direction (in from clients, out to them).",
+		[]string{"listener", "backend", "direction"}, func() []metrics.Sample {
+			var samples []metrics.Sample
+			for _, p := range proxies {
+				st := p.Stats()
+				for _, t := range st.Targets {
+					samples = append(samples,
+						metrics.Sample{Labels: []string{st.Name, t.Addr, "in"}, Value: float64(t.BytesIn)},
+						metrics.Sample{Labels: []string{st.Name, t.Addr, "out"}, Value: float64(t.BytesOut)})
+				}
+			}
+			return samples
+		})
+	m.Registry.NewCounterFunc("nexus_tcp_rejected_total", "TCP connections closed unproxied, by reason (max_connections, no_backend, no_route, bad_client_hello).",
+		[]string{"listener", "reason"}, func() []metrics.Sample {
+			var samples []metrics.Sample
+			for _, p := range proxies {
+				st := p.Stats()
+				for _, reason := range []string{tcpproxy.RejectMaxConnections, tcpproxy.RejectNoBackend, tcpproxy.RejectNoRoute, tcpproxy.RejectBadHello} {
+					samples = append(samples, metrics.Sample{Labels: []string{st.Name, reason}, Value: float64(st.Rejected[reason])})
+				}
+			}
+			return samples
+		})
+}
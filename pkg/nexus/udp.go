@@ -0,0 +1,110 @@
+package nexus
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/nexus-lb/nexus/config"
+	"github.com/nexus-lb/nexus/internal/metrics"
+	"github.com/nexus-lb/nexus/internal/udpproxy"
+)
+
+// startUDPProxies starts the UDP listeners and exports their counts. On
+// error it returns those started before.
+func startUDPProxies(ucs []config.UDPProxyConfig, ls Listeners, m *metrics.Metrics) ([]*udpproxy.Proxy, error) {
+	proxies := make([]*udpproxy.Proxy, 0, len(ucs))
+	for _, uc := range ucs {
+		p := udpproxy.New(udpproxy.Options{
+			Name:             uc.Name,
+			Listen:           uc.Listen,
+			Backends:         uc.Backends,
+			SessionTimeout:   uc.SessionTimeout.Std(),
+			MaxDatagramBytes: uc.MaxDatagramBytes,
+			Health: udpproxy.HealthOptions{
+				Interval: uc.HealthCheck.Interval.Std(),
+				Timeout:  uc.HealthCheck.Timeout.Std(),
+				Send:     []byte(uc.HealthCheck.Send),
+				Expect:   []byte(uc.HealthCheck.Expect),
+			},
+		})
+		conn, err := ls.ListenUDP(uc.Listen)
+		if err != nil {
+			return proxies, fmt.Errorf("UDP proxy %s: %w", uc.Name, err)
+		}
+		p.Start(conn)
+		proxies = append(proxies, p)
+	}
+	if len(proxies) > 0 {
+		registerUDPMetrics(m, proxies)
+	}
+	return proxies, nil
+}
+
+// stopUDPProxies stops the UDP listeners and ends their sessions
+func stopUDPProxies(ctx context.Context, proxies []*udpproxy.Proxy) {
+	for _, p := range proxies {
+		if err := p.Shutdown(ctx); err != nil {
+			slog.Warn("UDP proxy shutdown error", "listener", p.Name(), "error", err)
+		}
+	}
+}
+
+// registerUDPMetrics exports the datagram counts of the UDP listeners and
+// their backends
+func registerUDPMetrics(m *metrics.Metrics, proxies []*udpproxy.Proxy) {
+	perBackend := func(value func(udpproxy.TargetStats) float64) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			var samples []metrics.Sample
+			for _, p := range proxies {
+				st := p.Stats()
+				for _, t := range st.Targets {
+					samples = append(samples, metrics.Sample{Labels: []string{st.Name, t.Addr}, Value: value(t)})
+				}
+			}
+			return samples
+		}
+	}
+	perDirection := func(in, out func(udpproxy.TargetStats) uint64) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			var samples []metrics.Sample
+			for _, p := range proxies {
+				st := p.Stats()
+				for _, t := range st.Targets {
+					samples = append(samples,
+						metrics.Sample{Labels: []string{st.Name, t.Addr, "in"}, Value: float64(in(t))},
+						metrics.Sample{Labels: []string{st.Name, t.Addr, "out"}, Value: float64(out(t))})
+				}
+			}
+			return samples
+		}
+	}
+	labels := []string{"listener", "backend"}
+	m.Registry.NewGaugeFunc("nexus_udp_sessions", "UDP client sessions open to each backend.", labels,
+		perBackend(func(t udpproxy.TargetStats) float64 { return float64(t.Sessions) }))
+	m.Registry.NewCounterFunc("nexus_udp_send_errors_total", "Datagrams each UDP backend could not be sent.", labels,
+		perBackend(func(t udpproxy.TargetStats) float64 { return float64(t.SendErrors) }))
+	m.Registry.NewGaugeFunc("nexus_udp_backend_up", "Whether each UDP backend is in rotation (1) or not (0).", labels,
+		perBackend(func(t udpproxy.TargetStats) float64 {
+			if t.Alive {
+				return 1
+			}
+			return 0
+		}))
+	directed := []string{"listener", "backend", "direction"}
+	m.Registry.NewCounterFunc("nexus_udp_packets_total", "Datagrams forwarded to and from each UDP backend, by direction (in from clients, out to them).", directed,
+		perDirection(func(t udpproxy.TargetStats) uint64 { return t.PacketsIn }, func(t udpproxy.TargetStats) uint64 { return t.PacketsOut }))
+	m.Registry.NewCounterFunc("nexus_udp_bytes_total", "Bytes forwarded to and from each UDP backend, by direction (in from clients, out to them).", directed,
+		perDirection(func(t udpproxy.TargetStats) uint64 { return t.BytesIn }, func(t udpproxy.TargetStats) uint64 { return t.BytesOut }))
+	m.Registry.NewCounterFunc("nexus_udp_dropped_total", "UDP datagrams dropped, by reason (too_large, no_backend, send_error).",
+		[]string{"listener", "reason"}, func() []metrics.Sample {
+			var samples []metrics.Sample
+			for _, p := range proxies {
+				st := p.Stats()
+				for _, reason := range []string{udpproxy.DropTooLarge, udpproxy.DropNoBackend, udpproxy.DropSendError} {
+					samples = append(samples, metrics.Sample{Labels: []string{st.Name, reason}, Value: float64(st.Dropped[reason])})
+				}
+			}
+			return samples
+		})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// percentFlag is an -assert-error-rate threshold, given as a percentage
+// such as 1% or a fraction such as 0.01
+type percentFlag struct {
+	rate float64
+	set  bool
+}
+
+func (p *percentFlag) String() string {
+	if !p.set {
+		return ""
+	}
+	return strconv.FormatFloat(p.rate*100, 'f', -1, 64) + "%"
+}
+
+func (p *percentFlag) Set(s string) error {
+	f, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err == nil && strings.HasSuffix(s, "%") {
+		f /= 100
+	}
+	if err != nil || f < 0 || f > 1 {
+		return fmt.Errorf("%q is not a rate between 0%% and 100%%", s)
+	}
+	p.rate, p.set = f, true
+	return nil
+}
+
+// Assertions are the thresholds a test must stay within to pass: on the
+// whole run, and with PerBackend on each backend too
+type Assertions struct {
+	ErrorRate  percentFlag
+	P99        time.Duration
+	PerBackend bool
+}
+
+// Checked reports whether there is any threshold to check
+func (a Assertions) Checked() bool {
+	return a.ErrorRate.set || a.P99 > 0
+}
+
+// AssertionResult is one threshold checked on the whole run ("all") or a
+// backend. Error rates are fractions and latencies milliseconds.
+type AssertionResult struct {
+	Scope     string  `json:"scope"`
+	Assertion string  `json:"assertion"`
+	Limit     float64 `json:"limit"`
+	Actual    float64 `json:"actual"`
+	Passed    bool    `json:"passed"`
+}
+
+// check checks the thresholds against r. A p99 threshold is not checked
+// on a scope without successful requests, which the error rate covers.
+func (a Assertions) check(r Result) []AssertionResult {
+	var results []AssertionResult
+	scope := func(name string, errorRate float64, latency LatencySummary) {
+		if a.ErrorRate.set {
+			results = append(results, AssertionResult{Scope: name, Assertion: "error_rate",
+				Limit: a.ErrorRate.rate, Actual: errorRate, Passed: errorRate <= a.ErrorRate.rate})
+		}
+		if a.P99 > 0 && latency.Count > 0 {
+			results = append(results, AssertionResult{Scope: name, Assertion: "p99",
+				Limit: ms(a.P99), Actual: ms(latency.P99), Passed: latency.P99 <= a.P99})
+		}
+	}
+	scope("all", r.ErrorRate, r.Latency)
+	if a.PerBackend {
+		for _, b := range r.Backends {
+			scope(b.Backend, b.ErrorRate, b.Latency)
+		}
+	}
+	return results
+}
+
+// String describes the result, and by how much a failed one missed
+func (a AssertionResult) String() string {
+	verdict := "PASS"
+	if !a.Passed {
+		verdict = "FAIL"
+	}
+	var line string
+	switch a.Assertion {
+	case "error_rate":
+		line = fmt.Sprintf("error rate %.2f%%, limit %.2f%%", a.Actual*100, a.Limit*100)
+		if !a.Passed {
+			line += fmt.Sprintf(", over by %.2f points", (a.Actual-a.Limit)*100)
+		}
+	default:
+		actual, limit := round(millis(a.Actual)), round(millis(a.Limit))
+		line = fmt.Sprintf("%s %v, limit %v", a.Assertion, actual, limit)
+		if !a.Passed {
+			line += fmt.Sprintf(", over by %v", round(actual-limit))
+		}
+	}
+	return fmt.Sprintf("%s %-30s %s", verdict, a.Scope, line)
+}
+
+// millis converts fractional milliseconds to a duration
+func millis(f float64) time.Duration {
+	return time.Duration(f * float64(time.Millisecond))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// The buckets failed requests are counted in, besides one per status
+// outside 2xx
+const (
+	failRefused         = "connection refused"
+	failReset           = "connection reset"
+	failDNS             = "dns failure"
+	failConnectTimeout  = "connect timeout"
+	failResponseTimeout = "response timeout"
+	failOther           = "other"
+)
+
+// classify returns the bucket of a request that failed with err, having
+// got its connection as conn tells. A timeout before a connection was got
+// is a connect timeout, pointing at Nexus or the network; one after it a
+// response timeout, pointing at the backend.
+func classify(err error, conn Conn) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		return failDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return failRefused
+	case errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE):
+		return failReset
+	case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout():
+		if conn.Got {
+			return failResponseTimeout
+		}
+		return failConnectTimeout
+	}
+	return failOther
+}
+
+// statusFailure returns the bucket of a response with status, or "" if it
+// is a 2xx
+func statusFailure(status int) string {
+	if status >= 200 && status < 300 {
+		return ""
+	}
+	return "status " + strconv.Itoa(status)
+}
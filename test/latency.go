@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// histogramBuckets and histogramWidth size the ASCII latency histogram
+const (
+	histogramBuckets = 10
+	histogramWidth   = 40
+)
+
+// LatencySummary condenses a set of request durations
+type LatencySummary struct {
+	Count     int
+	Min       time.Duration
+	Avg       time.Duration
+	P50       time.Duration
+	P90       time.Duration
+	P95       time.Duration
+	P99       time.Duration
+	Max       time.Duration
+	Histogram []HistogramBucket
+}
+
+// HistogramBucket counts the durations up to Upper, above the previous
+// bucket's
+type HistogramBucket struct {
+	Upper time.Duration
+	Count int
+}
+
+// summarize computes the summary of durations, which it leaves unchanged
+func summarize(durations []time.Duration) LatencySummary {
+	if len(durations) == 0 {
+		return LatencySummary{}
+	}
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	return LatencySummary{
+		Count:     len(sorted),
+		Min:       sorted[0],
+		Avg:       total / time.Duration(len(sorted)),
+		P50:       percentile(sorted, 50),
+		P90:       percentile(sorted, 90),
+		P95:       percentile(sorted, 95),
+		P99:       percentile(sorted, 99),
+		Max:       sorted[len(sorted)-1],
+		Histogram: histogram(sorted),
+	}
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p/100*float64(len(sorted))+0.999999) - 1
+	return sorted[max(0, min(rank, len(sorted)-1))]
+}
+
+// histogram splits sorted durations into equal-width buckets between the
+// fastest and the slowest
+func histogram(sorted []time.Duration) []HistogramBucket {
+	lo, hi := sorted[0], sorted[len(sorted)-1]
+	width := (hi - lo) / histogramBuckets
+	if width <= 0 {
+		width = 1
+	}
+	buckets := make([]HistogramBucket, histogramBuckets)
+	for i := range buckets {
+		buckets[i].Upper = lo + width*time.Duration(i+1)
+	}
+	buckets[histogramBuckets-1].Upper = hi
+	for _, d := range sorted {
+		buckets[min(int((d-lo)/width), histogramBuckets-1)].Count++
+	}
+	return buckets
+}
+
+// MarshalJSON writes the durations in milliseconds
+func (s LatencySummary) MarshalJSON() ([]byte, error) {
+	type bucket struct {
+		UpperMs float64 `json:"upper_ms"`
+		Count   int     `json:"count"`
+	}
+	buckets := make([]bucket, len(s.Histogram))
+	for i, b := range s.Histogram {
+		buckets[i] = bucket{ms(b.Upper), b.Count}
+	}
+	return json.Marshal(struct {
+		Count     int      `json:"count"`
+		MinMs     float64  `json:"min_ms"`
+		AvgMs     float64  `json:"avg_ms"`
+		P50Ms     float64  `json:"p50_ms"`
+		P90Ms     float64  `json:"p90_ms"`
+		P95Ms     float64  `json:"p95_ms"`
+		P99Ms     float64  `json:"p99_ms"`
+		MaxMs     float64  `json:"max_ms"`
+		Histogram []bucket `json:"histogram"`
+	}{s.Count, ms(s.Min), ms(s.Avg), ms(s.P50), ms(s.P90), ms(s.P95), ms(s.P99), ms(s.Max), buckets})
+}
+
+// ms converts d to fractional milliseconds
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// printLatency prints a summary on one line
+func printLatency(w io.Writer, label string, s LatencySummary) {
+	if s.Count == 0 {
+		fmt.Fprintf(w, "  %-30s no requests\n", label)
+		return
+	}
+	fmt.Fprintf(w, "  %-30s min=%v avg=%v p50=%v p90=%v p95=%v p99=%v max=%v\n", label,
+		round(s.Min), round(s.Avg), round(s.P50), round(s.P90), round(s.P95), round(s.P99), round(s.Max))
+}
+
+// printHistogram prints the buckets of a summary, each with a bar scaled to
+// the fullest one
+func printHistogram(w io.Writer, indent string, s LatencySummary) {
+	fullest := 0
+	for _, b := range s.Histogram {
+		fullest = max(fullest, b.Count)
+	}
+	for _, b := range s.Histogram {
+		bar := strings.Repeat("#", b.Count*histogramWidth/fullest)
+		fmt.Fprintf(w, "%s%10v | %-*s %d\n", indent, round(b.Upper), histogramWidth, bar, b.Count)
+	}
+}
+
+// round shortens a duration for display
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
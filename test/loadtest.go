@@ -1,171 +1,485 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// console receives everything printed while a test runs; it is stderr
+// when the result goes to stdout in a structured format. printRequests
+// prints a line per request.
+var (
+	console       io.Writer = os.Stdout
+	printRequests           = true
+)
+
+// Sample is the outcome of one request
+type Sample struct {
+	Backend string
+	// Status is 0 without a response
+	Status int
+	// Error describes why the request got no response, or not in full, or
+	// is empty if the response was read in full
+	Error string
+	// Failure is the bucket the request failed in, or empty if it got a
+	// 2xx response in full
+	Failure string
+	Took    time.Duration
+	// Step is the index of the load step the request was sent in, Target
+	// that of its target
+	Step   int
+	Target int
+	// Conn is how the request got its connection
+	Conn Conn
+}
+
+// Stats collects the samples of a test
 type Stats struct {
-	totalRequests   int64
-	successRequests int64
-	failedRequests  int64
-	backendCounts   map[string]*int64
-	mu              sync.Mutex
-	startTime       time.Time
+	sent      atomic.Int64
+	startTime time.Time
+
+	mu      sync.Mutex
+	samples []Sample
+
+	// requestedRate is the rate the test aimed for, 0 if none; interrupted
+	// is set if it was stopped early
+	requestedRate float64
+	interrupted   bool
+
+	// steps and stepDuration are the load steps of a stepped test
+	steps        []int
+	stepDuration time.Duration
+
+	// targets are the targets of a test mixing several
+	targets []Target
 }
 
-func (s *Stats) recordSuccess(backend string) {
-	atomic.AddInt64(&s.successRequests, 1)
+func (s Sample) failed() bool {
+	return s.Failure != ""
+}
+
+func (s *Stats) record(sample Sample) {
 	s.mu.Lock()
-	if s.backendCounts[backend] == nil {
-		var count int64
-		s.backendCounts[backend] = &count
-	}
-	atomic.AddInt64(s.backendCounts[backend], 1)
+	s.samples = append(s.samples, sample)
 	s.mu.Unlock()
 }
 
-func (s *Stats) recordFailure() {
-	atomic.AddInt64(&s.failedRequests, 1)
+// errorKind describes err without what differs from request to request
+func errorKind(err error) string {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return opErr.Op + ": " + opErr.Err.Error()
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err.Error()
+	}
+	return err.Error()
 }
 
-func (s *Stats) printSummary() {
-	duration := time.Since(s.startTime)
-	total := atomic.LoadInt64(&s.totalRequests)
-	success := atomic.LoadInt64(&s.successRequests)
-	failed := atomic.LoadInt64(&s.failedRequests)
-
-	fmt.Println("\n" + "==============================================")
-	fmt.Println("LOAD TEST SUMMARY")
-	fmt.Println("==============================================")
-	fmt.Printf("Total Requests:      %d\n", total)
-	fmt.Printf("Successful:          %d (%.1f%%)\n", success, float64(success)/float64(total)*100)
-	fmt.Printf("Failed:              %d (%.1f%%)\n", failed, float64(failed)/float64(total)*100)
-	fmt.Printf("Duration:            %v\n", duration.Round(time.Millisecond))
-	fmt.Printf("Requests/sec:        %.2f\n", float64(total)/duration.Seconds())
-	fmt.Println("----------------------------------------------")
-	fmt.Println("Backend Distribution:")
+// job is a request to send: its number, counted from 1, and its load step
+type job struct {
+	num  int
+	step int
+}
 
-	s.mu.Lock()
-	for backend, count := range s.backendCounts {
-		c := atomic.LoadInt64(count)
-		fmt.Printf("  %-30s %d (%.1f%%)\n", backend, c, float64(c)/float64(success)*100)
-	}
-	s.mu.Unlock()
-	fmt.Println("==============================================")
+// Test is a load test: what it sends, through which client, for how long
+// and at what pace
+type Test struct {
+	Client *http.Client
+	Spec   *RequestSpec
+	// Targets, if any, are sent in place of Spec, drawn by weight
+	Targets []Target
+	// Requests is how many to send, 0 to keep on until the context is done
+	// or the profile ends; Duration is the time limit, for the description
+	Requests int
+	Duration time.Duration
+
+	// Sequential tests wait DelayMs between requests; concurrent ones
+	// release them at the pace of Profile to Workers
+	DelayMs int
+	Workers int
+	Profile LoadProfile
+
+	// Progress draws a progress line while the test runs; Interval, if not
+	// 0, is how often to print a snapshot of the latest requests
+	Progress bool
+	Interval time.Duration
+
+	Stats *Stats
 }
 
-func sendRequest(url string, requestNum int, stats *Stats) {
-	client := &http.Client{
-		Timeout: 5 * time.Second,
+// send sends one request and records its sample
+func (t *Test) send(j job) {
+	t.Stats.sent.Add(1)
+	sample := Sample{Step: j.step}
+	defer func() { t.Stats.record(sample) }()
+	fail := func(err error) {
+		if printRequests {
+			fmt.Fprintf(console, "[%d] FAILED: %v\n", j.num, err)
+		}
+		sample.Error = errorKind(err)
+		sample.Failure = classify(err, sample.Conn)
 	}
 
-	resp, err := client.Get(url)
+	spec := t.Spec
+	if len(t.Targets) > 0 {
+		sample.Target = pick(t.Targets)
+		spec = t.Targets[sample.Target].Spec
+	}
+	req, err := spec.newRequest()
 	if err != nil {
-		fmt.Printf("[%d] FAILED: %v\n", requestNum, err)
-		stats.recordFailure()
+		fail(err)
+		return
+	}
+	trace := &connTrace{}
+	req = trace.with(req)
+
+	// Time the whole request, reading the body included
+	start := time.Now()
+	resp, err := t.Client.Do(req)
+	sample.Conn = trace.conn()
+	if err != nil {
+		sample.Took = time.Since(start)
+		fail(err)
 		return
 	}
 	defer resp.Body.Close()
+	sample.Status = resp.StatusCode
 
 	// Read the response body
-	io.Copy(io.Discard, resp.Body)
+	_, err = io.Copy(io.Discard, resp.Body)
+	sample.Took = time.Since(start)
+	if err != nil {
+		fail(fmt.Errorf("reading body: %w", err))
+		return
+	}
 
 	// Get the backend server from the custom header
 	backend := resp.Header.Get("X-Backend-Server")
 	if backend == "" {
 		backend = "Unknown"
 	}
+	sample.Backend = backend
+	sample.Failure = statusFailure(resp.StatusCode)
 
-	fmt.Printf("[%d] SUCCESS: Status=%d, Backend=%s\n", requestNum, resp.StatusCode, backend)
-	stats.recordSuccess(backend)
+	if printRequests {
+		outcome := "SUCCESS"
+		if sample.failed() {
+			outcome = "FAILED"
+		}
+		fmt.Fprintf(console, "[%d] %s: Status=%d, Backend=%s\n", j.num, outcome, resp.StatusCode, backend)
+	}
 }
 
-func runSequentialTest(url string, numRequests int, delayMs int) {
-	fmt.Printf("Starting SEQUENTIAL load test\n")
-	fmt.Printf("Target URL:     %s\n", url)
-	fmt.Printf("Total Requests: %d\n", numRequests)
-	fmt.Printf("Delay:          %dms between requests\n\n", delayMs)
+// newStats starts the statistics of the test; requestedRate is the rate it
+// aims for, 0 if there is none
+func (t *Test) newStats(requestedRate float64) *Stats {
+	return &Stats{startTime: time.Now(), requestedRate: requestedRate, targets: t.Targets}
+}
 
-	stats := &Stats{
-		totalRequests: int64(numRequests),
-		backendCounts: make(map[string]*int64),
-		startTime:     time.Now(),
+// describe prints what the test sends and for how long
+func (t *Test) describe() {
+	spec := t.Spec
+	fmt.Fprintf(console, "Target URL:     %s\n", spec.URL)
+	if spec.Method != http.MethodGet || len(spec.Body) > 0 {
+		fmt.Fprintf(console, "Method:         %s, %d byte body\n", spec.Method, len(spec.Body))
 	}
-
-	for i := 1; i <= numRequests; i++ {
-		sendRequest(url, i, stats)
-		if delayMs > 0 && i < numRequests {
-			time.Sleep(time.Duration(delayMs) * time.Millisecond)
+	if len(spec.Header) > 0 {
+		fmt.Fprintf(console, "Headers:        %s\n", headerFlag(spec.Header))
+	}
+	if len(t.Targets) > 0 {
+		fmt.Fprintf(console, "Targets:        %d, by weight\n", len(t.Targets))
+		for _, target := range t.Targets {
+			fmt.Fprintf(console, "  %6d  %s\n", target.Weight, target.Name)
 		}
 	}
-
-	stats.printSummary()
+	if t.Duration > 0 {
+		fmt.Fprintf(console, "Duration:       %v\n", t.Duration)
+	} else {
+		fmt.Fprintf(console, "Total Requests: %d\n", t.Requests)
+	}
 }
 
-func runConcurrentTest(url string, numRequests, concurrency int, rateLimit int) {
-	fmt.Printf("Starting CONCURRENT load test\n")
-	fmt.Printf("Target URL:     %s\n", url)
-	fmt.Printf("Total Requests: %d\n", numRequests)
-	fmt.Printf("Concurrency:    %d goroutines\n", concurrency)
-	fmt.Printf("Rate Limit:     %d requests/second\n\n", rateLimit)
+// runSequential sends the requests one after another
+func (t *Test) runSequential(ctx context.Context) {
+	fmt.Fprintf(console, "Starting SEQUENTIAL load test\n")
+	t.describe()
+	fmt.Fprintf(console, "Delay:          %dms between requests\n\n", t.DelayMs)
 
-	stats := &Stats{
-		totalRequests: int64(numRequests),
-		backendCounts: make(map[string]*int64),
-		startTime:     time.Now(),
+	t.Stats = t.newStats(0)
+	defer t.watch()()
+	for i := 1; t.Requests == 0 || i <= t.Requests; i++ {
+		if ctx.Err() != nil {
+			break
+		}
+		t.send(job{num: i})
+		if t.DelayMs > 0 && i != t.Requests {
+			select {
+			case <-time.After(time.Duration(t.DelayMs) * time.Millisecond):
+			case <-ctx.Done():
+			}
+		}
 	}
+}
 
-	var wg sync.WaitGroup
-	requestChan := make(chan int, numRequests)
+// releaseTick is the shortest wait between releases. Timers cannot wake
+// thousands of times a second on time, so at high rates the requests due
+// over a tick are released together, like tokens from a bucket.
+const releaseTick = 5 * time.Millisecond
+
+// runConcurrent sends the requests through the workers at the pace of the
+// profile. Once ctx is done no request is started, and those in flight
+// are waited for.
+func (t *Test) runConcurrent(ctx context.Context) {
+	fmt.Fprintf(console, "Starting CONCURRENT load test\n")
+	t.describe()
+	fmt.Fprintf(console, "Concurrency:    %d goroutines\n", t.Workers)
+	t.Profile.describe()
+	fmt.Fprintln(console)
 
-	// Rate limiter: controls how fast requests are sent
-	ticker := time.NewTicker(time.Second / time.Duration(rateLimit))
-	defer ticker.Stop()
+	profile := t.Profile
+	stats := t.newStats(profile.Rate)
+	stats.steps, stats.stepDuration = profile.Steps, profile.StepDuration
+	t.Stats = stats
+	defer t.watch()()
+
+	var wg sync.WaitGroup
+	buffer := t.Requests
+	if buffer == 0 {
+		buffer = t.Workers
+	}
+	requestChan := make(chan job, buffer)
 
 	// Start worker goroutines
-	for i := 0; i < concurrency; i++ {
+	for i := 0; i < t.Workers; i++ {
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
-			for requestNum := range requestChan {
-				sendRequest(url, requestNum, stats)
+			for j := range requestChan {
+				if ctx.Err() != nil {
+					return
+				}
+				t.send(j)
 			}
 		}(i)
 	}
 
-	// Send requests with rate limiting
+	// Release each request once the profile has it due; a release running
+	// late catches up at once rather than lowering the rate
+	var releasing time.Duration
 	go func() {
-		for i := 1; i <= numRequests; i++ {
-			<-ticker.C // Wait for rate limiter
-			requestChan <- i
+		defer close(requestChan)
+		defer func() { releasing = time.Since(stats.startTime) }()
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		for i := 0; t.Requests == 0 || i < t.Requests; i++ {
+			due, step, ok := profile.dueAt(i)
+			if !ok {
+				return
+			}
+			if wait := time.Until(stats.startTime.Add(due)); wait > 0 {
+				timer.Reset(max(wait, releaseTick))
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case requestChan <- job{num: i + 1, step: step}:
+			case <-ctx.Done():
+				return
+			}
 		}
-		close(requestChan)
 	}()
 
 	wg.Wait()
-	stats.printSummary()
+	// A ramped or stepped test aims for the average rate of its profile
+	// while requests were released
+	if (profile.Ramp > 0 || len(profile.Steps) > 0) && releasing > 0 {
+		stats.requestedRate = profile.dueBy(releasing) / releasing.Seconds()
+	}
+}
+
+// usageError reports a bad combination of flags and exits
+func usageError(msg any) {
+	fmt.Fprintln(os.Stderr, msg)
+	os.Exit(2)
 }
 
 func main() {
 	// Command line flags
 	url := flag.String("url", "http://localhost:8000", "Target URL")
 	numRequests := flag.Int("n", 20, "Number of requests")
+	duration := flag.Duration("duration", 0, "Run for this long instead of sending -n requests, e.g. 5m")
 	concurrent := flag.Bool("c", false, "Run concurrent test (default: sequential)")
 	concurrency := flag.Int("workers", 10, "Number of concurrent workers (only for -c)")
-	rateLimit := flag.Int("rate", 10, "Requests per second (only for -c)")
+	rateLimit := flag.Int("rate", 10, "Requests per second, 0 for unlimited (only for -c)")
 	delayMs := flag.Int("delay", 100, "Delay between requests in ms (only for sequential)")
+	method := flag.String("method", http.MethodGet, "HTTP method")
+	body := flag.String("body", "", "Request body")
+	bodyFile := flag.String("body-file", "", "File to read the request body from")
+	targetsFile := flag.String("targets", "", `File of "weight method path [body-file]" lines to mix, against -url`)
+	contentType := flag.String("content-type", "", "Content-Type of the request body")
+	header := make(headerFlag)
+	flag.Var(header, "H", `Request header as "Key: Value" (repeatable)`)
+	ramp := flag.Duration("ramp", 0, "Ramp the rate up from 0 over this long (only for -c)")
+	stepList := flag.String("steps", "", "Hold each of these comma-separated rates in turn, e.g. 50,100,200 (only for -c)")
+	stepDuration := flag.Duration("step-duration", 30*time.Second, "How long each of -steps is held")
+	keepAlive := flag.Bool("keepalive", true, "Reuse connections between requests")
+	output := flag.String("output", "text", "Summary format: text, json or csv")
+	outFile := flag.String("out", "", "Write the summary to this file instead of stdout")
+	quiet := flag.Bool("quiet", false, "Show a progress line instead of a line per request")
+	interval := flag.Duration("interval", 0, "Print throughput, error rate and p99 this often, e.g. 5s")
+	var asserts Assertions
+	flag.Var(&asserts.ErrorRate, "assert-error-rate", "Exit 1 if the error rate exceeds this, e.g. 1%")
+	flag.DurationVar(&asserts.P99, "assert-p99", 0, "Exit 1 if the p99 latency exceeds this, e.g. 250ms")
+	flag.BoolVar(&asserts.PerBackend, "assert-per-backend", false, "Check the -assert thresholds on each backend too")
 
 	flag.Parse()
 
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	if *duration < 0 || *duration > 0 && setFlags["n"] {
+		usageError("-duration must be positive and cannot be combined with -n")
+	}
+	if *duration > 0 {
+		*numRequests = 0
+	}
+	profile := LoadProfile{Rate: float64(*rateLimit), Ramp: *ramp}
+	if *stepList != "" {
+		steps, err := parseSteps(*stepList)
+		if err != nil {
+			usageError(err)
+		}
+		if setFlags["n"] || setFlags["duration"] || *ramp != 0 || *stepDuration <= 0 {
+			usageError("-steps cannot be combined with -n, -duration or -ramp, and -step-duration must be positive")
+		}
+		profile = LoadProfile{Steps: steps, StepDuration: *stepDuration}
+		*numRequests = 0
+	}
+	if *ramp < 0 || (*ramp > 0 || *stepList != "") && !*concurrent {
+		usageError("-ramp and -steps need -c, and -ramp must not be negative")
+	}
+	if *rateLimit < 0 || *rateLimit == 0 && *ramp > 0 {
+		usageError("-rate must not be negative, and -ramp needs a rate to ramp up to")
+	}
+	if *output != "text" && *output != "json" && *output != "csv" {
+		usageError("-output must be text, json or csv")
+	}
+	if *interval < 0 || asserts.P99 < 0 {
+		usageError("-interval and -assert-p99 must not be negative")
+	}
+	if asserts.PerBackend && !asserts.Checked() {
+		usageError("-assert-per-backend needs -assert-error-rate or -assert-p99")
+	}
+	// Structured output is for programs: nothing but the result goes to
+	// stdout
+	if *output != "text" {
+		console, printRequests = os.Stderr, false
+	}
+	if *quiet {
+		printRequests = false
+	}
+	out := io.Writer(os.Stdout)
+	if *outFile != "" {
+		f, err := os.Create(*outFile)
+		if err != nil {
+			usageError(err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if *targetsFile != "" && (setFlags["method"] || *body != "" || *bodyFile != "") {
+		usageError("-targets cannot be combined with -method, -body or -body-file")
+	}
+	spec, err := newRequestSpec(*method, *url, *body, *bodyFile, *contentType, http.Header(header))
+	if err != nil {
+		usageError(err)
+	}
+	var targets []Target
+	if *targetsFile != "" {
+		if targets, err = loadTargets(*targetsFile, *url, *contentType, http.Header(header)); err != nil {
+			usageError(err)
+		}
+	}
+
+	// Ctrl-C stops the test early, still printing the summary of what
+	// completed; a second one exits at once
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if *duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+	var interrupted atomic.Bool
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	go func() {
+		select {
+		case <-sigChan:
+			interrupted.Store(true)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigChan)
+	}()
+
+	params := Params{Mode: "sequential", URL: spec.URL, Method: spec.Method, Requests: *numRequests,
+		Concurrency: 1, DelayMs: *delayMs, KeepAlive: *keepAlive, Targets: *targetsFile}
+	if *duration > 0 {
+		params.Duration = duration.String()
+	}
+	test := &Test{Spec: spec, Targets: targets, Requests: *numRequests, Duration: *duration, DelayMs: *delayMs,
+		Progress: *quiet, Interval: *interval}
 	if *concurrent {
-		runConcurrentTest(*url, *numRequests, *concurrency, *rateLimit)
+		params.Mode, params.Concurrency, params.Rate, params.DelayMs = "concurrent", *concurrency, *rateLimit, 0
+		if profile.Ramp > 0 {
+			params.Ramp = profile.Ramp.String()
+		}
+		params.Unlimited = profile.Rate == 0 && len(profile.Steps) == 0
+		if len(profile.Steps) > 0 {
+			params.Rate, params.Steps, params.StepDuration = 0, profile.Steps, profile.StepDuration.String()
+		}
+		test.Client = newClient(*keepAlive, *concurrency)
+		test.Workers, test.Profile, test.Duration = *concurrency, profile, max(*duration, profile.Length())
+		test.runConcurrent(ctx)
 	} else {
-		runSequentialTest(*url, *numRequests, *delayMs)
+		test.Client = newClient(*keepAlive, 1)
+		test.runSequential(ctx)
+	}
+	test.Stats.interrupted = interrupted.Load()
+	result := buildResult(params, test.Stats)
+	result.Assertions = asserts.check(result)
+	if err := writeResult(out, *output, result); err != nil {
+		fmt.Fprintln(os.Stderr, "writing the summary:", err)
+		os.Exit(1)
+	}
+
+	// A failed assertion fails the run, for CI to act on; the text summary
+	// has listed them already
+	failed := false
+	for _, a := range result.Assertions {
+		if !a.Passed {
+			failed = true
+			if *output != "text" {
+				fmt.Fprintln(console, a)
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
 	}
 }
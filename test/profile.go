@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoadProfile is the rate requests are released at over the course of a
+// concurrent test: constant, ramped up linearly from 0, or held at each of
+// a series of steps in turn. A Rate of 0 without steps is unlimited.
+type LoadProfile struct {
+	Rate float64
+	Ramp time.Duration
+
+	Steps        []int
+	StepDuration time.Duration
+}
+
+// parseSteps parses a comma-separated list of rates such as 50,100,200
+func parseSteps(s string) ([]int, error) {
+	var steps []int
+	for _, field := range strings.Split(s, ",") {
+		rate, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("step %q is not a positive rate", field)
+		}
+		steps = append(steps, rate)
+	}
+	return steps, nil
+}
+
+// Length is how long the profile lasts, 0 if it goes on until the test
+// ends otherwise
+func (p LoadProfile) Length() time.Duration {
+	return p.StepDuration * time.Duration(len(p.Steps))
+}
+
+// dueAt returns when, counted from the start, the request following i
+// others is due, and the step it belongs to. ok is false once the profile
+// has ended.
+func (p LoadProfile) dueAt(i int) (due time.Duration, step int, ok bool) {
+	n := float64(i)
+	if len(p.Steps) > 0 {
+		for k, rate := range p.Steps {
+			inStep := float64(rate) * p.StepDuration.Seconds()
+			if n < inStep {
+				return p.StepDuration*time.Duration(k) + secs(n/float64(rate)), k, true
+			}
+			n -= inStep
+		}
+		return 0, 0, false
+	}
+	if p.Rate == 0 {
+		return 0, 0, true
+	}
+
+	// Over the ramp the rate grows as Rate*t/Ramp, so the requests due by
+	// t number Rate*t²/(2*Ramp)
+	if p.Ramp > 0 {
+		inRamp := p.Rate * p.Ramp.Seconds() / 2
+		if n < inRamp {
+			return secs(math.Sqrt(2 * p.Ramp.Seconds() * n / p.Rate)), 0, true
+		}
+		return p.Ramp + secs((n-inRamp)/p.Rate), 0, true
+	}
+	return secs(n / p.Rate), 0, true
+}
+
+// dueBy returns how many requests are due within elapsed
+func (p LoadProfile) dueBy(elapsed time.Duration) float64 {
+	t := elapsed.Seconds()
+	if len(p.Steps) > 0 {
+		var n float64
+		for _, rate := range p.Steps {
+			held := min(t, p.StepDuration.Seconds())
+			n += float64(rate) * held
+			if t -= held; t <= 0 {
+				break
+			}
+		}
+		return n
+	}
+	if r := p.Ramp.Seconds(); r > 0 {
+		if t < r {
+			return p.Rate * t * t / (2 * r)
+		}
+		return p.Rate*r/2 + p.Rate*(t-r)
+	}
+	return p.Rate * t
+}
+
+// secs converts fractional seconds to a duration
+func secs(f float64) time.Duration {
+	return time.Duration(f * float64(time.Second))
+}
+
+// describe prints the profile in the test description
+func (p LoadProfile) describe() {
+	switch {
+	case len(p.Steps) > 0:
+		fmt.Fprintf(console, "Steps:          %v requests/second, %v each\n", p.Steps, p.StepDuration)
+	case p.Rate == 0:
+		fmt.Fprintf(console, "Rate Limit:     none, as fast as the workers go\n")
+	case p.Ramp > 0:
+		fmt.Fprintf(console, "Rate Limit:     %g requests/second, ramped up over %v\n", p.Rate, p.Ramp)
+	default:
+		fmt.Fprintf(console, "Rate Limit:     %g requests/second\n", p.Rate)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressEvery is how often the progress line is redrawn
+const progressEvery = 500 * time.Millisecond
+
+// since returns the samples recorded after the first i, and how many there
+// are in all
+func (s *Stats) since(i int) ([]Sample, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Sample(nil), s.samples[i:]...), len(s.samples)
+}
+
+// window condenses the samples completed over a stretch of the test
+type window struct {
+	requests int
+	failed   int
+	rate     float64
+	p99      time.Duration
+}
+
+func newWindow(samples []Sample, over time.Duration) window {
+	w := window{requests: len(samples)}
+	var latencies []time.Duration
+	for _, s := range samples {
+		if s.failed() {
+			w.failed++
+		} else {
+			latencies = append(latencies, s.Took)
+		}
+	}
+	if over > 0 {
+		w.rate = float64(w.requests) / over.Seconds()
+	}
+	w.p99 = summarize(latencies).P99
+	return w
+}
+
+func (w window) errorPct() float64 {
+	return float64(w.failed) / float64(max(w.requests, 1)) * 100
+}
+
+// watch draws the progress line, if t.Progress is set, and prints a
+// snapshot of each t.Interval, if set, while the test runs. The returned
+// function stops it.
+func (t *Test) watch() (stop func()) {
+	if !t.Progress && t.Interval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		progress := time.NewTicker(progressEvery)
+		defer progress.Stop()
+		var snapshots <-chan time.Time
+		if t.Interval > 0 {
+			ticker := time.NewTicker(t.Interval)
+			defer ticker.Stop()
+			snapshots = ticker.C
+		}
+
+		drawn := 0
+		// Each view keeps where its last window ended
+		lastProgress, progressAt := 0, time.Now()
+		lastSnapshot, snapshotAt := 0, time.Now()
+		for {
+			select {
+			case now := <-progress.C:
+				if !t.Progress {
+					continue
+				}
+				var samples []Sample
+				samples, lastProgress = t.Stats.since(lastProgress)
+				w := newWindow(samples, now.Sub(progressAt))
+				progressAt = now
+				drawn = t.drawProgress(lastProgress, w, drawn)
+			case now := <-snapshots:
+				var samples []Sample
+				samples, lastSnapshot = t.Stats.since(lastSnapshot)
+				w := newWindow(samples, now.Sub(snapshotAt))
+				snapshotAt = now
+				clearLine(drawn)
+				drawn = 0
+				p99 := "-"
+				if w.failed < w.requests {
+					p99 = round(w.p99).String()
+				}
+				fmt.Fprintf(console, "[%6v] %d requests, %.1f req/s, %.1f%% errors, p99 %s\n",
+					now.Sub(t.Stats.startTime).Round(time.Second), w.requests, w.rate, w.errorPct(), p99)
+			case <-done:
+				clearLine(drawn)
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// drawProgress redraws the progress line after completed requests, over
+// the previous one of width drawn, and returns its width
+func (t *Test) drawProgress(completed int, w window, drawn int) int {
+	var of string
+	switch {
+	case t.Requests > 0:
+		of = fmt.Sprintf("%d/%d requests", completed, t.Requests)
+	case t.Duration > 0:
+		of = fmt.Sprintf("%d requests, %v/%v", completed,
+			time.Since(t.Stats.startTime).Round(time.Second), t.Duration)
+	default:
+		of = fmt.Sprintf("%d requests", completed)
+	}
+	line := fmt.Sprintf("%s  %.1f req/s  %.1f%% errors", of, w.rate, w.errorPct())
+	fmt.Fprintf(console, "\r%-*s", drawn, line)
+	return max(drawn, len(line))
+}
+
+// clearLine blanks a progress line of width drawn
+func clearLine(drawn int) {
+	if drawn > 0 {
+		fmt.Fprintf(console, "\r%s\r", strings.Repeat(" ", drawn))
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// headerFlag collects the repeatable -H "Key: Value" flags
+type headerFlag http.Header
+
+func (h headerFlag) String() string {
+	var pairs []string
+	for _, key := range slices.Sorted(maps.Keys(h)) {
+		for _, v := range h[key] {
+			pairs = append(pairs, key+": "+v)
+		}
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func (h headerFlag) Set(s string) error {
+	key, value, ok := strings.Cut(s, ":")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return fmt.Errorf("header %q is not in the form \"Key: Value\"", s)
+	}
+	http.Header(h).Add(key, strings.TrimSpace(value))
+	return nil
+}
+
+// RequestSpec describes the request every worker sends. The body is kept
+// as bytes and read afresh for each request, so workers share no reader.
+type RequestSpec struct {
+	Method string
+	URL    string
+	Body   []byte
+	Header http.Header
+}
+
+// newRequestSpec builds the request from the flags; body and bodyFile are
+// mutually exclusive
+func newRequestSpec(method, url, body, bodyFile, contentType string, header http.Header) (*RequestSpec, error) {
+	spec := &RequestSpec{Method: strings.ToUpper(method), URL: url, Header: header}
+	switch {
+	case body != "" && bodyFile != "":
+		return nil, fmt.Errorf("-body and -body-file cannot be combined")
+	case body != "":
+		spec.Body = []byte(body)
+	case bodyFile != "":
+		data, err := os.ReadFile(bodyFile)
+		if err != nil {
+			return nil, err
+		}
+		spec.Body = data
+	}
+	if contentType != "" {
+		spec.Header.Set("Content-Type", contentType)
+	}
+	// Check the method and URL once, rather than failing every request
+	if _, err := spec.newRequest(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// newRequest creates one request with its own reader over the body
+func (s *RequestSpec) newRequest() (*http.Request, error) {
+	req, err := http.NewRequest(s.Method, s.URL, bytes.NewReader(s.Body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = s.Header.Clone()
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+		req.Header.Del("Host")
+	}
+	return req, nil
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Params are the settings a test ran with, so that its result describes
+// itself
+type Params struct {
+	Mode        string `json:"mode"`
+	URL         string `json:"url"`
+	Method      string `json:"method"`
+	Requests    int    `json:"requests,omitempty"`
+	Duration    string `json:"duration,omitempty"`
+	Concurrency int    `json:"concurrency"`
+	Rate        int    `json:"rate,omitempty"`
+	// Unlimited is set if a concurrent test sent as fast as it could
+	Unlimited bool `json:"unlimited,omitempty"`
+	DelayMs   int  `json:"delay_ms,omitempty"`
+	KeepAlive bool `json:"keepalive"`
+
+	Ramp         string `json:"ramp,omitempty"`
+	Steps        []int  `json:"steps,omitempty"`
+	StepDuration string `json:"step_duration,omitempty"`
+
+	// Targets is the targets file the requests were mixed from
+	Targets string `json:"targets,omitempty"`
+}
+
+// Result is the outcome of a test, as printed in the summary and written
+// by -output
+type Result struct {
+	Params      Params    `json:"params"`
+	StartedAt   time.Time `json:"started_at"`
+	Elapsed     seconds   `json:"elapsed_seconds"`
+	Interrupted bool      `json:"interrupted"`
+
+	Requests       int64   `json:"requests"`
+	Successful     int64   `json:"successful"`
+	Failed         int64   `json:"failed"`
+	ErrorRate      float64 `json:"error_rate"`
+	RequestsPerSec float64 `json:"requests_per_sec"`
+	RequestedRate  float64 `json:"requested_rate,omitempty"`
+
+	// StatusCodes counts the responses read in full by status; Errors
+	// counts the requests that got none, or not in full, by error
+	StatusCodes map[int]int64    `json:"status_codes"`
+	Errors      map[string]int64 `json:"errors"`
+	// Failures counts the failed requests by bucket: a class of error, or
+	// a status outside 2xx
+	Failures map[string]int64 `json:"failures"`
+
+	// Latency covers the successful requests, FailedLatency the others up
+	// to their error or response
+	Latency       LatencySummary  `json:"latency"`
+	FailedLatency LatencySummary  `json:"failed_latency"`
+	Backends      []BackendResult `json:"backends"`
+
+	// Connections tells how the requests got their connections
+	Connections ConnStats `json:"connections"`
+
+	// Steps are the results of each load step of a stepped test
+	Steps []StepResult `json:"steps,omitempty"`
+
+	// Targets are the results of each target of a test mixing several
+	Targets []TargetResult `json:"targets,omitempty"`
+
+	// Assertions are the thresholds checked, if any were given
+	Assertions []AssertionResult `json:"assertions,omitempty"`
+}
+
+// TargetResult is the outcome of the requests sent to one target. Share
+// is its part of all requests, to compare with its weight.
+type TargetResult struct {
+	Target         string           `json:"target"`
+	Weight         int              `json:"weight"`
+	Requests       int64            `json:"requests"`
+	Share          float64          `json:"share"`
+	Failed         int64            `json:"failed"`
+	ErrorRate      float64          `json:"error_rate"`
+	RequestsPerSec float64          `json:"requests_per_sec"`
+	Failures       map[string]int64 `json:"failures"`
+	Latency        LatencySummary   `json:"latency"`
+}
+
+// StepResult is the outcome of one load step
+type StepResult struct {
+	Rate           int            `json:"rate"`
+	Requests       int64          `json:"requests"`
+	Failed         int64          `json:"failed"`
+	ErrorRate      float64        `json:"error_rate"`
+	RequestsPerSec float64        `json:"requests_per_sec"`
+	Latency        LatencySummary `json:"latency"`
+}
+
+// ConnStats counts the connections opened and reused, and times the phases
+// of opening them
+type ConnStats struct {
+	Opened     int64          `json:"opened"`
+	Reused     int64          `json:"reused"`
+	ReuseRatio float64        `json:"reuse_ratio"`
+	DNS        LatencySummary `json:"dns"`
+	Connect    LatencySummary `json:"connect"`
+	TLS        LatencySummary `json:"tls"`
+}
+
+// BackendResult is the share of the successful requests one backend
+// served. Failed counts its responses outside 2xx, and ErrorRate their
+// part of all its responses.
+type BackendResult struct {
+	Backend   string         `json:"backend"`
+	Requests  int64          `json:"requests"`
+	Share     float64        `json:"share"`
+	Failed    int64          `json:"failed"`
+	ErrorRate float64        `json:"error_rate"`
+	Latency   LatencySummary `json:"latency"`
+}
+
+// seconds is a duration written to JSON in fractional seconds
+type seconds time.Duration
+
+func (s seconds) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(s).Seconds())
+}
+
+// buildResult condenses the samples of a test
+func buildResult(params Params, stats *Stats) Result {
+	stats.mu.Lock()
+	samples := slices.Clone(stats.samples)
+	stats.mu.Unlock()
+
+	elapsed := time.Since(stats.startTime)
+	r := Result{
+		Params:        params,
+		StartedAt:     stats.startTime,
+		Elapsed:       seconds(elapsed),
+		Interrupted:   stats.interrupted,
+		Requests:      stats.sent.Load(),
+		RequestedRate: stats.requestedRate,
+		StatusCodes:   make(map[int]int64),
+		Errors:        make(map[string]int64),
+		Failures:      make(map[string]int64),
+		Backends:      []BackendResult{},
+	}
+	var latencies, failedLatencies []time.Duration
+	backendLatencies := make(map[string][]time.Duration)
+	backendFailed := make(map[string]int64)
+	steps := make([]StepResult, len(stats.steps))
+	stepLatencies := make([][]time.Duration, len(stats.steps))
+	targets := make([]TargetResult, len(stats.targets))
+	targetLatencies := make([][]time.Duration, len(stats.targets))
+	for k, target := range stats.targets {
+		targets[k] = TargetResult{Target: target.Name, Weight: target.Weight, Failures: make(map[string]int64)}
+	}
+	var dns, connect, handshake []time.Duration
+	for _, s := range samples {
+		switch c := s.Conn; {
+		case c.Reused:
+			r.Connections.Reused++
+		case c.Got:
+			r.Connections.Opened++
+		}
+		if s.Conn.DNS > 0 {
+			dns = append(dns, s.Conn.DNS)
+		}
+		if s.Conn.Connect > 0 {
+			connect = append(connect, s.Conn.Connect)
+		}
+		if s.Conn.TLS > 0 {
+			handshake = append(handshake, s.Conn.TLS)
+		}
+		if len(steps) > 0 {
+			steps[s.Step].Requests++
+			if s.failed() {
+				steps[s.Step].Failed++
+			} else {
+				stepLatencies[s.Step] = append(stepLatencies[s.Step], s.Took)
+			}
+		}
+		if len(targets) > 0 {
+			target := &targets[s.Target]
+			target.Requests++
+			if s.failed() {
+				target.Failed++
+				target.Failures[s.Failure]++
+			} else {
+				targetLatencies[s.Target] = append(targetLatencies[s.Target], s.Took)
+			}
+		}
+		if s.Error != "" {
+			r.Errors[s.Error]++
+		} else {
+			r.StatusCodes[s.Status]++
+		}
+		if s.failed() {
+			r.Failed++
+			r.Failures[s.Failure]++
+			if s.Backend != "" {
+				backendFailed[s.Backend]++
+			}
+			failedLatencies = append(failedLatencies, s.Took)
+			continue
+		}
+		r.Successful++
+		latencies = append(latencies, s.Took)
+		backendLatencies[s.Backend] = append(backendLatencies[s.Backend], s.Took)
+	}
+	if r.Requests > 0 {
+		r.ErrorRate = float64(r.Failed) / float64(r.Requests)
+	}
+	r.RequestsPerSec = float64(r.Requests) / elapsed.Seconds()
+	r.Latency = summarize(latencies)
+	r.FailedLatency = summarize(failedLatencies)
+	if got := r.Connections.Opened + r.Connections.Reused; got > 0 {
+		r.Connections.ReuseRatio = float64(r.Connections.Reused) / float64(got)
+	}
+	r.Connections.DNS, r.Connections.Connect, r.Connections.TLS = summarize(dns), summarize(connect), summarize(handshake)
+	backends := slices.Collect(maps.Keys(backendLatencies))
+	for backend := range backendFailed {
+		if _, ok := backendLatencies[backend]; !ok {
+			backends = append(backends, backend)
+		}
+	}
+	slices.Sort(backends)
+	for _, backend := range backends {
+		ds, failed := backendLatencies[backend], backendFailed[backend]
+		r.Backends = append(r.Backends, BackendResult{
+			Backend:   backend,
+			Requests:  int64(len(ds)),
+			Share:     float64(len(ds)) / float64(max(r.Successful, 1)),
+			Failed:    failed,
+			ErrorRate: float64(failed) / float64(int64(len(ds))+failed),
+			Latency:   summarize(ds),
+		})
+	}
+	for k, rate := range stats.steps {
+		step := &steps[k]
+		step.Rate = rate
+		if step.Requests > 0 {
+			step.ErrorRate = float64(step.Failed) / float64(step.Requests)
+		}
+		// Interrupting the test cuts the last step reached short
+		held := stats.stepDuration
+		if stats.interrupted {
+			held = min(held, elapsed-stats.stepDuration*time.Duration(k))
+		}
+		if held > 0 {
+			step.RequestsPerSec = float64(step.Requests) / held.Seconds()
+		}
+		step.Latency = summarize(stepLatencies[k])
+	}
+	r.Steps = steps
+	for k := range targets {
+		target := &targets[k]
+		if target.Requests > 0 {
+			target.ErrorRate = float64(target.Failed) / float64(target.Requests)
+		}
+		target.Share = float64(target.Requests) / float64(max(len(samples), 1))
+		target.RequestsPerSec = float64(target.Requests) / elapsed.Seconds()
+		target.Latency = summarize(targetLatencies[k])
+	}
+	r.Targets = targets
+	return r
+}
+
+// writeResult writes r in format: text, json or csv
+func writeResult(w io.Writer, format string, r Result) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case "csv":
+		return writeCSV(w, r)
+	default:
+		printSummary(w, r)
+		return nil
+	}
+}
+
+// csvHeader are the columns of -output csv: one row for the whole test,
+// one per backend, load step, target, connection statistic, status code,
+// failure bucket and error
+var csvHeader = []string{"scope", "requests", "successful", "failed", "error_rate", "requests_per_sec",
+	"min_ms", "avg_ms", "p50_ms", "p90_ms", "p95_ms", "p99_ms", "max_ms"}
+
+func writeCSV(w io.Writer, r Result) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	cw.Write(append([]string{"all", itoa(r.Requests), itoa(r.Successful), itoa(r.Failed),
+		ftoa(r.ErrorRate), ftoa(r.RequestsPerSec)}, latencyColumns(r.Latency)...))
+	for _, b := range r.Backends {
+		cw.Write(append([]string{"backend:" + b.Backend, itoa(b.Requests + b.Failed), itoa(b.Requests), itoa(b.Failed),
+			ftoa(b.ErrorRate), ""}, latencyColumns(b.Latency)...))
+	}
+	for _, step := range r.Steps {
+		cw.Write(append([]string{"step:" + strconv.Itoa(step.Rate), itoa(step.Requests), itoa(step.Requests - step.Failed),
+			itoa(step.Failed), ftoa(step.ErrorRate), ftoa(step.RequestsPerSec)}, latencyColumns(step.Latency)...))
+	}
+	for _, target := range r.Targets {
+		cw.Write(append([]string{"target:" + target.Target, itoa(target.Requests), itoa(target.Requests - target.Failed),
+			itoa(target.Failed), ftoa(target.ErrorRate), ftoa(target.RequestsPerSec)}, latencyColumns(target.Latency)...))
+	}
+	cw.Write(append([]string{"conn:opened", itoa(r.Connections.Opened), "", "", "", ""}, latencyColumns(LatencySummary{})...))
+	cw.Write(append([]string{"conn:reused", itoa(r.Connections.Reused), "", "", "", ""}, latencyColumns(LatencySummary{})...))
+	for _, phase := range []struct {
+		name string
+		s    LatencySummary
+	}{{"dns", r.Connections.DNS}, {"connect", r.Connections.Connect}, {"tls", r.Connections.TLS}} {
+		cw.Write(append([]string{"conn:" + phase.name, strconv.Itoa(phase.s.Count), "", "", "", ""}, latencyColumns(phase.s)...))
+	}
+	for _, status := range slices.Sorted(maps.Keys(r.StatusCodes)) {
+		c := r.StatusCodes[status]
+		successful, failed := itoa(c), ""
+		if statusFailure(status) != "" {
+			successful, failed = "", itoa(c)
+		}
+		cw.Write(append([]string{"status:" + strconv.Itoa(status), itoa(c), successful, failed, "", ""}, latencyColumns(LatencySummary{})...))
+	}
+	for _, bucket := range slices.Sorted(maps.Keys(r.Failures)) {
+		c := r.Failures[bucket]
+		cw.Write(append([]string{"failure:" + bucket, itoa(c), "", itoa(c), "", ""}, latencyColumns(LatencySummary{})...))
+	}
+	for _, kind := range slices.Sorted(maps.Keys(r.Errors)) {
+		c := r.Errors[kind]
+		cw.Write(append([]string{"error:" + kind, itoa(c), "", itoa(c), "", ""}, latencyColumns(LatencySummary{})...))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// latencyColumns are the latency cells of a CSV row, empty without requests
+func latencyColumns(s LatencySummary) []string {
+	if s.Count == 0 {
+		return make([]string, 7)
+	}
+	cols := []string{}
+	for _, d := range []time.Duration{s.Min, s.Avg, s.P50, s.P90, s.P95, s.P99, s.Max} {
+		cols = append(cols, ftoa(ms(d)))
+	}
+	return cols
+}
+
+func itoa(n int64) string     { return strconv.FormatInt(n, 10) }
+func ftoa(f float64) string   { return strconv.FormatFloat(f, 'f', 4, 64) }
+func pct(n, of int64) float64 { return float64(n) / float64(max(of, 1)) * 100 }
+
+// printSummary prints r for people
+func printSummary(w io.Writer, r Result) {
+	elapsed := time.Duration(r.Elapsed)
+
+	fmt.Fprintln(w, "\n"+"==============================================")
+	fmt.Fprintln(w, "LOAD TEST SUMMARY")
+	fmt.Fprintln(w, "==============================================")
+	fmt.Fprintf(w, "Total Requests:      %d\n", r.Requests)
+	fmt.Fprintf(w, "Successful:          %d (%.1f%%)\n", r.Successful, pct(r.Successful, r.Requests))
+	fmt.Fprintf(w, "Failed:              %d (%.1f%%)\n", r.Failed, pct(r.Failed, r.Requests))
+	fmt.Fprintf(w, "Duration:            %v\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Requests/sec:        %.2f\n", r.RequestsPerSec)
+	switch {
+	case r.RequestedRate > 0:
+		fmt.Fprintf(w, "Requested rate:      %.2f/sec (%.1f%% achieved)\n", r.RequestedRate,
+			r.RequestsPerSec/r.RequestedRate*100)
+	case r.Params.Unlimited:
+		fmt.Fprintln(w, "Requested rate:      unlimited")
+	}
+	if r.Interrupted {
+		fmt.Fprintln(w, "Interrupted:         summary covers the requests sent until then")
+	}
+	if len(r.Steps) > 0 {
+		fmt.Fprintln(w, "----------------------------------------------")
+		fmt.Fprintln(w, "Steps:")
+		fmt.Fprintf(w, "  %8s %9s %11s %8s %8s %10s %10s\n", "Rate/s", "Requests", "Achieved/s", "Failed", "Error %", "p50", "p99")
+		for _, step := range r.Steps {
+			fmt.Fprintf(w, "  %8d %9d %11.2f %8d %7.1f%% %10v %10v\n", step.Rate, step.Requests, step.RequestsPerSec,
+				step.Failed, step.ErrorRate*100, round(step.Latency.P50), round(step.Latency.P99))
+		}
+	}
+	if len(r.Targets) > 0 {
+		fmt.Fprintln(w, "----------------------------------------------")
+		fmt.Fprintln(w, "Targets:")
+		fmt.Fprintf(w, "  %-30s %7s %9s %8s %8s %10s %10s\n", "Target", "Share", "Requests", "Failed", "Error %", "p50", "p99")
+		for _, target := range r.Targets {
+			fmt.Fprintf(w, "  %-30s %6.1f%% %9d %8d %7.1f%% %10v %10v\n", target.Target, target.Share*100, target.Requests,
+				target.Failed, target.ErrorRate*100, round(target.Latency.P50), round(target.Latency.P99))
+			for _, bucket := range slices.Sorted(maps.Keys(target.Failures)) {
+				fmt.Fprintf(w, "    %-28s %d\n", bucket, target.Failures[bucket])
+			}
+		}
+	}
+	fmt.Fprintln(w, "----------------------------------------------")
+	fmt.Fprintln(w, "Backend Distribution:")
+	for _, b := range r.Backends {
+		failed := ""
+		if b.Failed > 0 {
+			failed = fmt.Sprintf(", %d failed", b.Failed)
+		}
+		fmt.Fprintf(w, "  %-30s %d (%.1f%%)%s\n", b.Backend, b.Requests, b.Share*100, failed)
+	}
+	fmt.Fprintln(w, "----------------------------------------------")
+	fmt.Fprintln(w, "Status Codes:")
+	for _, status := range slices.Sorted(maps.Keys(r.StatusCodes)) {
+		c := r.StatusCodes[status]
+		fmt.Fprintf(w, "  %-30s %d (%.1f%%)\n", fmt.Sprintf("%d %s", status, http.StatusText(status)), c, pct(c, r.Requests))
+	}
+	var noResponse int64
+	for _, c := range r.Errors {
+		noResponse += c
+	}
+	if noResponse > 0 {
+		fmt.Fprintf(w, "  %-30s %d (%.1f%%)\n", "No response", noResponse, pct(noResponse, r.Requests))
+	}
+	if len(r.Failures) > 0 {
+		fmt.Fprintln(w, "----------------------------------------------")
+		fmt.Fprintln(w, "Failures:")
+		for _, bucket := range slices.Sorted(maps.Keys(r.Failures)) {
+			c := r.Failures[bucket]
+			fmt.Fprintf(w, "  %-30s %d (%.1f%%)\n", bucket, c, pct(c, r.Requests))
+		}
+	}
+	if len(r.Errors) > 0 {
+		fmt.Fprintln(w, "----------------------------------------------")
+		fmt.Fprintln(w, "Errors:")
+		for _, kind := range slices.Sorted(maps.Keys(r.Errors)) {
+			fmt.Fprintf(w, "  %-30s %d\n", kind, r.Errors[kind])
+		}
+	}
+	fmt.Fprintln(w, "----------------------------------------------")
+	fmt.Fprintln(w, "Latency (successful requests):")
+	printLatency(w, "All backends", r.Latency)
+	for _, b := range r.Backends {
+		printLatency(w, b.Backend, b.Latency)
+	}
+	for _, target := range r.Targets {
+		printLatency(w, target.Target, target.Latency)
+	}
+	if r.FailedLatency.Count > 0 {
+		printLatency(w, "Failed requests", r.FailedLatency)
+	}
+	fmt.Fprintln(w, "----------------------------------------------")
+	fmt.Fprintln(w, "Connections:")
+	fmt.Fprintf(w, "  %-30s %d\n", "Opened", r.Connections.Opened)
+	fmt.Fprintf(w, "  %-30s %d (%.1f%% reuse)\n", "Reused", r.Connections.Reused, r.Connections.ReuseRatio*100)
+	for _, phase := range []struct {
+		name string
+		s    LatencySummary
+	}{{"DNS lookup", r.Connections.DNS}, {"TCP connect", r.Connections.Connect}, {"TLS handshake", r.Connections.TLS}} {
+		if phase.s.Count > 0 {
+			printLatency(w, phase.name, phase.s)
+		}
+	}
+	if r.Latency.Count > 0 {
+		fmt.Fprintln(w, "----------------------------------------------")
+		fmt.Fprintln(w, "Latency Histogram:")
+		fmt.Fprintln(w, "  All backends")
+		printHistogram(w, "    ", r.Latency)
+		for _, b := range r.Backends {
+			fmt.Fprintf(w, "  %s\n", b.Backend)
+			printHistogram(w, "    ", b.Latency)
+		}
+	}
+	if len(r.Assertions) > 0 {
+		fmt.Fprintln(w, "----------------------------------------------")
+		fmt.Fprintln(w, "Assertions:")
+		for _, a := range r.Assertions {
+			fmt.Fprintf(w, "  %s\n", a)
+		}
+	}
+	fmt.Fprintln(w, "==============================================")
+}
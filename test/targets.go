@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Target is one of the requests a targets file mixes, sent in proportion
+// to its weight
+type Target struct {
+	// Name is the method and path, as in the file
+	Name   string
+	Weight int
+	Spec   *RequestSpec
+}
+
+// loadTargets reads a targets file. Each line is "weight method path
+// [body-file]", the path being appended to base and the body file read
+// relative to the targets file; blank lines and lines starting with # are
+// skipped. Every target gets contentType and header.
+func loadTargets(path, base, contentType string, header http.Header) ([]Target, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var targets []Target
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		target, err := parseTarget(text, filepath.Dir(path), base, contentType, header)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		targets = append(targets, target)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%s: no targets", path)
+	}
+	return targets, nil
+}
+
+func parseTarget(text, dir, base, contentType string, header http.Header) (Target, error) {
+	fields := strings.Fields(text)
+	if len(fields) != 3 && len(fields) != 4 {
+		return Target{}, fmt.Errorf("want \"weight method path [body-file]\", got %q", text)
+	}
+	weight, err := strconv.Atoi(fields[0])
+	if err != nil || weight <= 0 {
+		return Target{}, fmt.Errorf("weight %q is not a positive integer", fields[0])
+	}
+	method, path := strings.ToUpper(fields[1]), fields[2]
+	if !strings.HasPrefix(path, "/") {
+		return Target{}, fmt.Errorf("path %q does not start with /", path)
+	}
+	var bodyFile string
+	if len(fields) == 4 {
+		bodyFile = fields[3]
+		if !filepath.IsAbs(bodyFile) {
+			bodyFile = filepath.Join(dir, bodyFile)
+		}
+	}
+	spec, err := newRequestSpec(method, strings.TrimSuffix(base, "/")+path, "", bodyFile, contentType, header.Clone())
+	if err != nil {
+		return Target{}, err
+	}
+	return Target{Name: method + " " + path, Weight: weight, Spec: spec}, nil
+}
+
+// pick draws the index of a target by weight
+func pick(targets []Target) int {
+	total := 0
+	for _, target := range targets {
+		total += target.Weight
+	}
+	n := rand.IntN(total)
+	for i, target := range targets {
+		if n -= target.Weight; n < 0 {
+			return i
+		}
+	}
+	return len(targets) - 1
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// newClient returns the client every worker shares. With keepAlive its
+// transport keeps an idle connection per worker, so that each can reuse
+// one; without it every request opens a connection of its own.
+func newClient(keepAlive bool, workers int) *http.Client {
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			DialContext:         (&net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        workers,
+			MaxIdleConnsPerHost: workers,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 5 * time.Second,
+			DisableKeepAlives:   !keepAlive,
+		},
+	}
+}
+
+// connTrace follows how a request got its connection. The dial callbacks
+// may run on other goroutines, racing each other for several addresses.
+type connTrace struct {
+	mu                               sync.Mutex
+	gotConn, reused                  bool
+	dnsStart, connectStart, tlsStart time.Time
+	dns, connect, tls                time.Duration
+}
+
+// with returns req traced by t
+func (t *connTrace) with(req *http.Request) *http.Request {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.gotConn, t.reused = true, info.Reused
+			t.mu.Unlock()
+		},
+		DNSStart: func(httptrace.DNSStartInfo) { t.start(&t.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { t.done(&t.dnsStart, &t.dns) },
+		ConnectStart: func(string, string) {
+			t.start(&t.connectStart)
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				t.done(&t.connectStart, &t.connect)
+			}
+		},
+		TLSHandshakeStart: func() { t.start(&t.tlsStart) },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				t.done(&t.tlsStart, &t.tls)
+			}
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+func (t *connTrace) start(at *time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if at.IsZero() {
+		*at = time.Now()
+	}
+}
+
+func (t *connTrace) done(started *time.Time, took *time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if *took == 0 {
+		*took = time.Since(*started)
+	}
+}
+
+// Conn is how a request got its connection: reused, or opened taking the
+// DNS, connect and TLS times given, each 0 if that phase did not happen
+type Conn struct {
+	Got     bool
+	Reused  bool
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+}
+
+// conn returns what t followed
+func (t *connTrace) conn() Conn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return Conn{Got: t.gotConn, Reused: t.reused, DNS: t.dns, Connect: t.connect, TLS: t.tls}
+}
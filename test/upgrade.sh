@@ -0,0 +1,71 @@
+#!/usr/bin/env bash
+# Upgrades Nexus with SIGUSR2 in the middle of a load test and fails unless
+# every request was answered 200 and the new process took over. Needs Go,
+# Python 3 and curl; run from anywhere in the repository.
+set -euo pipefail
+
+cd "$(dirname "$0")/.."
+work=$(mktemp -d)
+new=""
+cleanup() {
+	[ -n "$new" ] && kill -INT "$new" 2>/dev/null || true
+	kill $(jobs -p) 2>/dev/null || true
+	wait 2>/dev/null || true
+	rm -rf "$work"
+}
+trap cleanup EXIT
+
+go build -o "$work/nexus" ./cmd/nexus
+go build -o "$work/loadtest" ./test
+
+for port in 8081 8082 8083; do
+	python3 -m http.server "$port" --bind 127.0.0.1 --directory "$work" >/dev/null 2>&1 &
+done
+cat >"$work/nexus.json" <<EOF
+{
+  "listen": "127.0.0.1:8000",
+  "backends": [
+    {"url": "http://127.0.0.1:8081"},
+    {"url": "http://127.0.0.1:8082"},
+    {"url": "http://127.0.0.1:8083"}
+  ],
+  "admin": {"listen": "127.0.0.1:8001"}
+}
+EOF
+
+"$work/nexus" -config "$work/nexus.json" 2>"$work/nexus.log" &
+old=$!
+for _ in $(seq 50); do
+	curl -sf -o /dev/null http://127.0.0.1:8000/ && break
+	sleep 0.1
+done
+
+# 2000 requests over 10 seconds, each on a new connection
+"$work/loadtest" -c -keepalive=false -n 2000 -workers 20 -rate 200 -url http://127.0.0.1:8000/ >"$work/loadtest.out" &
+load=$!
+sleep 3
+
+# Replace the binary the way a deployment would, then upgrade to it
+go build -o "$work/nexus.new" ./cmd/nexus
+mv -f "$work/nexus.new" "$work/nexus"
+kill -USR2 "$old"
+wait "$load"
+
+new=$(sed -n 's/.*handed the listeners over to the new process.* pid=\([0-9]*\).*/\1/p' "$work/nexus.log")
+status=0
+if [ -z "$new" ] || ! kill -0 "$new" 2>/dev/null; then
+	echo "FAIL: no new process took over"
+	status=1
+fi
+if kill -0 "$old" 2>/dev/null; then
+	echo "FAIL: the previous process $old is still running"
+	status=1
+fi
+failed=$(grep -E '^\[[0-9]+\]' "$work/loadtest.out" | grep -c -v 'Status=200' || true)
+answered=$(grep -c 'Status=200' "$work/loadtest.out" || true)
+echo "upgraded from pid $old to pid ${new:-none}: $answered requests answered 200, $failed failed"
+if [ "$failed" -ne 0 ]; then
+	grep -E '^\[[0-9]+\]' "$work/loadtest.out" | grep -v 'Status=200' | head
+	status=1
+fi
+exit $status